@@ -7,15 +7,44 @@ class ConfigParseErrorLogger
   def initialize
   end
 
+  # max length of the setting value in the source of an error
+  MAX_SOURCE_VALUE_LENGTH = 256
+
   class << self
-    def logError(message)
+    # source is the location of the setting causing the error, from configSource
+    def logError(message, source = nil)
       begin
         errorMessage = "config::error::" + message
+        if !source.nil? && !source.empty?
+          errorMessage += " config::source::" + source.to_json
+        end
         jsonMessage = errorMessage.to_json
         STDERR.puts jsonMessage
       rescue => errorStr
         puts "Error in ConfigParserErrorLogger::logError: #{errorStr}"
       end
     end
+
+    # returns the configmap key, the section and the value of the setting causing an error. The value is the one of
+    # the section (e.g. log_collection_settings.stdout) in the parsed config, when set
+    def configSource(configMapKey, section = nil, parsedConfig = nil)
+      source = { "key" => configMapKey }
+      if !section.nil?
+        source["section"] = section
+        if !parsedConfig.nil?
+          value = parsedConfig
+          section.split(".").each do |name|
+            value = value.kind_of?(Hash) ? value[name.to_sym] : nil
+          end
+          if !value.nil?
+            source["value"] = value.to_s[0, MAX_SOURCE_VALUE_LENGTH]
+          end
+        end
+      end
+      return source
+    rescue => errorStr
+      puts "Error in ConfigParserErrorLogger::configSource: #{errorStr}"
+      return nil
+    end
   end
 end
@@ -10,7 +10,8 @@ end
 
 require_relative "ConfigParseErrorLogger"
 
-@configMapMountPath = "/etc/config/settings/log-data-collection-settings"
+@configMapKey = "log-data-collection-settings"
+@configMapMountPath = "/etc/config/settings/#{@configMapKey}"
 @configVersion = ""
 @configSchemaVersion = ""
 # Setting default values which will be used in case they are not set in the configmap or if configmap doesnt exist
@@ -18,6 +19,8 @@ require_relative "ConfigParseErrorLogger"
 @stdoutExcludeNamespaces = "kube-system"
 @collectStderrLogs = true
 @stderrExcludeNamespaces = "kube-system"
+@stdoutIncludeNamespaces = ""
+@stderrIncludeNamespaces = ""
 @collectClusterEnvVariables = true
 @logTailPath = "/var/log/containers/*.log"
 @logExclusionRegexPattern = "(^((?!stdout|stderr).)*$)"
@@ -26,6 +29,22 @@ require_relative "ConfigParseErrorLogger"
 @containerLogSchemaVersion = ""
 @collectAllKubeEvents = false
 @containerLogsRoute = "v2" # default for linux
+@containerLogsTeeRoute = "" # the records are written to one route by default
+@containerLogsV2Percent = "" # the canary of the v2 route is disabled by default
+@dcrEndpoint = "" # the DCR of the dcr route
+@dcrImmutableId = ""
+@dcrContainerLogsStream = ""
+@dcrInsightsMetricsStream = ""
+@kafkaBrokers = "" # the topic of the kafka route
+@kafkaTopic = ""
+@kafkaSaslPasswordPath = ""
+@eventHubsNamespace = "" # the event hubs of the eventhubs route and of the KubeMonAgentEvents
+@eventHubsHub = ""
+@eventHubsKubeMonAgentEventsHub = ""
+@eventHubsClientId = ""
+@otlpEndpoint = "" # the OTLP collector of the otlp route
+@otlpInsecure = false
+@otlpHeaders = ""
 @adxDatabaseName = "containerinsights" # default for all configurations
 if !@os_type.nil? && !@os_type.empty? && @os_type.strip.casecmp("windows") == 0
   @containerLogsRoute = "v1" # default is v1 for windows until windows agent integrates windows ama
@@ -45,12 +64,22 @@ def parseConfigMap
       return nil
     end
   rescue => errorStr
-    ConfigParseErrorLogger.logError("Exception while parsing config map for log collection/env variable settings: #{errorStr}, using defaults, please check config map for errors")
+    ConfigParseErrorLogger.logError("Exception while parsing config map for log collection/env variable settings: #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey))
     @excludePath = "*_kube-system_*.log"
     return nil
   end
 end
 
+# Returns the comma separated namespaces of the include list of a stream, the logs are collected only from these namespaces
+# when it is set. The exclude list takes precedence when a namespace is in both lists
+def getIncludeNamespaces(namespaces, stream)
+  if namespaces.nil? || !namespaces.kind_of?(Array) || namespaces.length == 0 || !namespaces[0].kind_of?(String)
+    return ""
+  end
+  puts "config::Using config map setting for #{stream} log collection to include namespace"
+  return namespaces.join(",")
+end
+
 # Use the ruby structure created after config parsing to set the right values to be used as environment variables
 def populateSettingValuesFromConfigMap(parsedConfig)
   if !parsedConfig.nil? && !parsedConfig[:log_collection_settings].nil?
@@ -80,9 +109,12 @@ def populateSettingValuesFromConfigMap(parsedConfig)
             end
           end
         end
+        if @collectStdoutLogs
+          @stdoutIncludeNamespaces = getIncludeNamespaces(parsedConfig[:log_collection_settings][:stdout][:include_namespaces], "stdout")
+        end
       end
     rescue => errorStr
-      ConfigParseErrorLogger.logError("Exception while reading config map settings for stdout log collection - #{errorStr}, using defaults, please check config map for errors")
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for stdout log collection - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.stdout", parsedConfig))
     end
 
     #Get stderr log config settings
@@ -117,9 +149,12 @@ def populateSettingValuesFromConfigMap(parsedConfig)
             end
           end
         end
+        if @collectStderrLogs
+          @stderrIncludeNamespaces = getIncludeNamespaces(parsedConfig[:log_collection_settings][:stderr][:include_namespaces], "stderr")
+        end
       end
     rescue => errorStr
-      ConfigParseErrorLogger.logError("Exception while reading config map settings for stderr log collection - #{errorStr}, using defaults, please check config map for errors")
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for stderr log collection - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.stderr", parsedConfig))
     end
 
     #Get environment variables log config settings
@@ -129,7 +164,7 @@ def populateSettingValuesFromConfigMap(parsedConfig)
         puts "config::Using config map setting for cluster level environment variable collection"
       end
     rescue => errorStr
-      ConfigParseErrorLogger.logError("Exception while reading config map settings for cluster level environment variable collection - #{errorStr}, using defaults, please check config map for errors")
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for cluster level environment variable collection - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.env_var", parsedConfig))
     end
 
     #Get container log enrichment setting
@@ -139,7 +174,7 @@ def populateSettingValuesFromConfigMap(parsedConfig)
         puts "config::Using config map setting for cluster level container log enrichment"
       end
     rescue => errorStr
-      ConfigParseErrorLogger.logError("Exception while reading config map settings for cluster level container log enrichment - #{errorStr}, using defaults, please check config map for errors")
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for cluster level container log enrichment - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.enrich_container_logs", parsedConfig))
     end
 
      #Get container log schema version setting
@@ -149,7 +184,7 @@ def populateSettingValuesFromConfigMap(parsedConfig)
         puts "config::Using config map setting for container log schema version"
       end
     rescue => errorStr
-      ConfigParseErrorLogger.logError("Exception while reading config map settings for container log schema version - #{errorStr}, using defaults, please check config map for errors")
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for container log schema version - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.schema", parsedConfig))
     end
 
     #Get kube events enrichment setting
@@ -159,7 +194,7 @@ def populateSettingValuesFromConfigMap(parsedConfig)
         puts "config::Using config map setting for kube event collection"
       end
     rescue => errorStr
-      ConfigParseErrorLogger.logError("Exception while reading config map settings for kube event collection - #{errorStr}, using defaults, please check config map for errors")
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for kube event collection - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.collect_all_kube_events", parsedConfig))
     end
 
     #Get container logs route setting
@@ -173,7 +208,94 @@ def populateSettingValuesFromConfigMap(parsedConfig)
         end         
       end
     rescue => errorStr
-      ConfigParseErrorLogger.logError("Exception while reading config map settings for container logs route - #{errorStr}, using defaults, please check config map for errors")
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for container logs route - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.route_container_logs", parsedConfig))
+    end
+
+    #Get container logs tee route setting, the second route the records are written to
+    begin
+      if !parsedConfig[:log_collection_settings][:route_container_logs].nil? && !parsedConfig[:log_collection_settings][:route_container_logs][:tee_version].nil?
+        @containerLogsTeeRoute = parsedConfig[:log_collection_settings][:route_container_logs][:tee_version].strip
+        puts "config::Using config map setting for container logs tee route: #{@containerLogsTeeRoute}"
+      end
+    rescue => errorStr
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for container logs tee route - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.route_container_logs", parsedConfig))
+    end
+
+    #Get container logs v2 canary percentage setting, the percentage of the containers sent thru the v2 route on the v1 route
+    begin
+      if !parsedConfig[:log_collection_settings][:route_container_logs].nil? && !parsedConfig[:log_collection_settings][:route_container_logs][:v2_percent].nil?
+        v2Percent = parsedConfig[:log_collection_settings][:route_container_logs][:v2_percent]
+        if v2Percent.is_a?(Integer) && v2Percent >= 0 && v2Percent <= 100
+          @containerLogsV2Percent = v2Percent
+          puts "config::Using config map setting for container logs v2 canary percentage: #{@containerLogsV2Percent}"
+        else
+          puts "config::Ignoring config map settings for container logs v2 canary percentage since it is not an integer between 0 and 100"
+        end
+      end
+    rescue => errorStr
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for container logs v2 canary percentage - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.route_container_logs", parsedConfig))
+    end
+
+    #Get the DCR settings of the dcr route, the DCE endpoint, the DCR immutable id and the streams
+    begin
+      routeSettings = parsedConfig[:log_collection_settings][:route_container_logs]
+      if !routeSettings.nil?
+        @dcrEndpoint = routeSettings[:dcr_endpoint].strip if !routeSettings[:dcr_endpoint].nil?
+        @dcrImmutableId = routeSettings[:dcr_immutable_id].strip if !routeSettings[:dcr_immutable_id].nil?
+        @dcrContainerLogsStream = routeSettings[:dcr_container_logs_stream].strip if !routeSettings[:dcr_container_logs_stream].nil?
+        @dcrInsightsMetricsStream = routeSettings[:dcr_insights_metrics_stream].strip if !routeSettings[:dcr_insights_metrics_stream].nil?
+        if !@dcrEndpoint.empty? || !@dcrImmutableId.empty?
+          puts "config::Using config map setting for the DCR of the dcr route: #{@dcrImmutableId} of #{@dcrEndpoint}"
+        end
+      end
+    rescue => errorStr
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for the DCR of the dcr route - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.route_container_logs", parsedConfig))
+    end
+
+    #Get the Kafka settings of the kafka route, the brokers, the topic and the file of the SASL password
+    begin
+      routeSettings = parsedConfig[:log_collection_settings][:route_container_logs]
+      if !routeSettings.nil?
+        @kafkaBrokers = routeSettings[:kafka_brokers].strip if !routeSettings[:kafka_brokers].nil?
+        @kafkaTopic = routeSettings[:kafka_topic].strip if !routeSettings[:kafka_topic].nil?
+        @kafkaSaslPasswordPath = routeSettings[:kafka_sasl_password_path].strip if !routeSettings[:kafka_sasl_password_path].nil?
+        if !@kafkaBrokers.empty? || !@kafkaTopic.empty?
+          puts "config::Using config map setting for the topic of the kafka route: #{@kafkaTopic} of #{@kafkaBrokers}"
+        end
+      end
+    rescue => errorStr
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for the topic of the kafka route - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.route_container_logs", parsedConfig))
+    end
+
+    #Get the Event Hubs settings, the namespace, the event hubs of the container logs and the KubeMonAgentEvents and the client id of the managed identity
+    begin
+      routeSettings = parsedConfig[:log_collection_settings][:route_container_logs]
+      if !routeSettings.nil?
+        @eventHubsNamespace = routeSettings[:eventhubs_namespace].strip if !routeSettings[:eventhubs_namespace].nil?
+        @eventHubsHub = routeSettings[:eventhubs_hub].strip if !routeSettings[:eventhubs_hub].nil?
+        @eventHubsKubeMonAgentEventsHub = routeSettings[:eventhubs_kube_mon_agent_events_hub].strip if !routeSettings[:eventhubs_kube_mon_agent_events_hub].nil?
+        @eventHubsClientId = routeSettings[:eventhubs_client_id].strip if !routeSettings[:eventhubs_client_id].nil?
+        if !@eventHubsNamespace.empty?
+          puts "config::Using config map setting for the event hubs namespace: #{@eventHubsNamespace}"
+        end
+      end
+    rescue => errorStr
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for the event hubs namespace - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.route_container_logs", parsedConfig))
+    end
+
+    #Get the OTLP collector of the otlp route, its endpoint, whether it is reached without TLS and the headers of the export requests
+    begin
+      routeSettings = parsedConfig[:log_collection_settings][:route_container_logs]
+      if !routeSettings.nil?
+        @otlpEndpoint = routeSettings[:otlp_endpoint].strip if !routeSettings[:otlp_endpoint].nil?
+        @otlpInsecure = routeSettings[:otlp_insecure] if !routeSettings[:otlp_insecure].nil?
+        @otlpHeaders = routeSettings[:otlp_headers].strip if !routeSettings[:otlp_headers].nil?
+        if !@otlpEndpoint.empty?
+          puts "config::Using config map setting for the OTLP collector: #{@otlpEndpoint}, insecure #{@otlpInsecure}"
+        end
+      end
+    rescue => errorStr
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for the OTLP collector - #{errorStr}, using defaults, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.route_container_logs", parsedConfig))
     end
 
     #Get ADX database name setting
@@ -189,7 +311,7 @@ def populateSettingValuesFromConfigMap(parsedConfig)
         puts "config::No ADX database name set, using default value : #{@adxDatabaseName}"
       end
     rescue => errorStr
-      ConfigParseErrorLogger.logError("Exception while reading config map settings for adx database name - #{errorStr}, using default #{@adxDatabaseName}, please check config map for errors")
+      ConfigParseErrorLogger.logError("Exception while reading config map settings for adx database name - #{errorStr}, using default #{@adxDatabaseName}, please check config map for errors", ConfigParseErrorLogger.configSource(@configMapKey, "log_collection_settings.adx_database", parsedConfig))
     end
   end
 end
@@ -203,7 +325,7 @@ if !@configSchemaVersion.nil? && !@configSchemaVersion.empty? && @configSchemaVe
   end
 else
   if (File.file?(@configMapMountPath))
-    ConfigParseErrorLogger.logError("config::unsupported/missing config schema version - '#{@configSchemaVersion}' , using defaults, please use supported schema version")
+    ConfigParseErrorLogger.logError("config::unsupported/missing config schema version - '#{@configSchemaVersion}' , using defaults, please use supported schema version", ConfigParseErrorLogger.configSource("schema-version"))
   end
   @excludePath = "*_kube-system_*.log"
 end
@@ -226,13 +348,31 @@ if !file.nil?
   file.write("export AZMON_LOG_TAIL_PATH=#{@logTailPath}\n")
   file.write("export AZMON_LOG_EXCLUSION_REGEX_PATTERN=\"#{@logExclusionRegexPattern}\"\n")
   file.write("export AZMON_STDOUT_EXCLUDED_NAMESPACES=#{@stdoutExcludeNamespaces}\n")
+  file.write("export AZMON_STDOUT_INCLUDED_NAMESPACES=#{@stdoutIncludeNamespaces}\n")
   file.write("export AZMON_COLLECT_STDERR_LOGS=#{@collectStderrLogs}\n")
   file.write("export AZMON_STDERR_EXCLUDED_NAMESPACES=#{@stderrExcludeNamespaces}\n")
+  file.write("export AZMON_STDERR_INCLUDED_NAMESPACES=#{@stderrIncludeNamespaces}\n")
   file.write("export AZMON_CLUSTER_COLLECT_ENV_VAR=#{@collectClusterEnvVariables}\n")
   file.write("export AZMON_CLUSTER_LOG_TAIL_EXCLUDE_PATH=#{@excludePath}\n")
   file.write("export AZMON_CLUSTER_CONTAINER_LOG_ENRICH=#{@enrichContainerLogs}\n")
   file.write("export AZMON_CLUSTER_COLLECT_ALL_KUBE_EVENTS=#{@collectAllKubeEvents}\n")
   file.write("export AZMON_CONTAINER_LOGS_ROUTE=#{@containerLogsRoute}\n")
+  file.write("export AZMON_CONTAINER_LOGS_TEE_ROUTE=#{@containerLogsTeeRoute}\n")
+  file.write("export AZMON_CONTAINER_LOGS_V2_PERCENT=#{@containerLogsV2Percent}\n")
+  file.write("export AZMON_DCR_ENDPOINT=#{@dcrEndpoint}\n")
+  file.write("export AZMON_DCR_IMMUTABLE_ID=#{@dcrImmutableId}\n")
+  file.write("export AZMON_DCR_CONTAINER_LOGS_STREAM=#{@dcrContainerLogsStream}\n")
+  file.write("export AZMON_DCR_INSIGHTS_METRICS_STREAM=#{@dcrInsightsMetricsStream}\n")
+  file.write("export AZMON_KAFKA_BROKERS=#{@kafkaBrokers}\n")
+  file.write("export AZMON_KAFKA_TOPIC=#{@kafkaTopic}\n")
+  file.write("export AZMON_KAFKA_SASL_PASSWORD_PATH=#{@kafkaSaslPasswordPath}\n")
+  file.write("export AZMON_EVENTHUBS_NAMESPACE=#{@eventHubsNamespace}\n")
+  file.write("export AZMON_EVENTHUBS_HUB=#{@eventHubsHub}\n")
+  file.write("export AZMON_EVENTHUBS_KUBE_MON_AGENT_EVENTS_HUB=#{@eventHubsKubeMonAgentEventsHub}\n")
+  file.write("export AZMON_EVENTHUBS_CLIENT_ID=#{@eventHubsClientId}\n")
+  file.write("export AZMON_OTLP_ENDPOINT=#{@otlpEndpoint}\n")
+  file.write("export AZMON_OTLP_INSECURE=#{@otlpInsecure}\n")
+  file.write("export AZMON_OTLP_HEADERS=\"#{@otlpHeaders}\"\n")
   file.write("export AZMON_CONTAINER_LOG_SCHEMA_VERSION=#{@containerLogSchemaVersion}\n")
   file.write("export AZMON_ADX_DATABASE_NAME=#{@adxDatabaseName}\n")
   # Close file after writing all environment variables
@@ -267,10 +407,14 @@ if !@os_type.nil? && !@os_type.empty? && @os_type.strip.casecmp("windows") == 0
     file.write(commands)
     commands = get_command_windows('AZMON_STDOUT_EXCLUDED_NAMESPACES', @stdoutExcludeNamespaces)
     file.write(commands)
+    commands = get_command_windows('AZMON_STDOUT_INCLUDED_NAMESPACES', @stdoutIncludeNamespaces)
+    file.write(commands)
     commands = get_command_windows('AZMON_COLLECT_STDERR_LOGS', @collectStderrLogs)
     file.write(commands)
     commands = get_command_windows('AZMON_STDERR_EXCLUDED_NAMESPACES', @stderrExcludeNamespaces)
     file.write(commands)
+    commands = get_command_windows('AZMON_STDERR_INCLUDED_NAMESPACES', @stderrIncludeNamespaces)
+    file.write(commands)
     commands = get_command_windows('AZMON_CLUSTER_COLLECT_ENV_VAR', @collectClusterEnvVariables)
     file.write(commands)
     commands = get_command_windows('AZMON_CLUSTER_LOG_TAIL_EXCLUDE_PATH', @excludePath)
@@ -281,6 +425,38 @@ if !@os_type.nil? && !@os_type.empty? && @os_type.strip.casecmp("windows") == 0
     file.write(commands)
     commands = get_command_windows('AZMON_CONTAINER_LOGS_ROUTE', @containerLogsRoute)
     file.write(commands)
+    commands = get_command_windows('AZMON_CONTAINER_LOGS_TEE_ROUTE', @containerLogsTeeRoute)
+    file.write(commands)
+    commands = get_command_windows('AZMON_CONTAINER_LOGS_V2_PERCENT', @containerLogsV2Percent)
+    file.write(commands)
+    commands = get_command_windows('AZMON_DCR_ENDPOINT', @dcrEndpoint)
+    file.write(commands)
+    commands = get_command_windows('AZMON_DCR_IMMUTABLE_ID', @dcrImmutableId)
+    file.write(commands)
+    commands = get_command_windows('AZMON_DCR_CONTAINER_LOGS_STREAM', @dcrContainerLogsStream)
+    file.write(commands)
+    commands = get_command_windows('AZMON_DCR_INSIGHTS_METRICS_STREAM', @dcrInsightsMetricsStream)
+    file.write(commands)
+    commands = get_command_windows('AZMON_KAFKA_BROKERS', @kafkaBrokers)
+    file.write(commands)
+    commands = get_command_windows('AZMON_KAFKA_TOPIC', @kafkaTopic)
+    file.write(commands)
+    commands = get_command_windows('AZMON_KAFKA_SASL_PASSWORD_PATH', @kafkaSaslPasswordPath)
+    file.write(commands)
+    commands = get_command_windows('AZMON_EVENTHUBS_NAMESPACE', @eventHubsNamespace)
+    file.write(commands)
+    commands = get_command_windows('AZMON_EVENTHUBS_HUB', @eventHubsHub)
+    file.write(commands)
+    commands = get_command_windows('AZMON_EVENTHUBS_KUBE_MON_AGENT_EVENTS_HUB', @eventHubsKubeMonAgentEventsHub)
+    file.write(commands)
+    commands = get_command_windows('AZMON_EVENTHUBS_CLIENT_ID', @eventHubsClientId)
+    file.write(commands)
+    commands = get_command_windows('AZMON_OTLP_ENDPOINT', @otlpEndpoint)
+    file.write(commands)
+    commands = get_command_windows('AZMON_OTLP_INSECURE', @otlpInsecure)
+    file.write(commands)
+    commands = get_command_windows('AZMON_OTLP_HEADERS', @otlpHeaders)
+    file.write(commands)
     commands = get_command_windows('AZMON_CONTAINER_LOG_SCHEMA_VERSION', @containerLogSchemaVersion)
     file.write(commands)
     commands = get_command_windows('AZMON_ADX_DATABASE_NAME', @adxDatabaseName)
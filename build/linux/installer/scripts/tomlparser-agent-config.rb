@@ -61,6 +61,14 @@ require_relative "ConfigParseErrorLogger"
 @fbitTailBufferMaxSizeMBs = 0
 @fbitTailMemBufLimitMBs = 0
 
+# configmap settings of the KubeMonAgentEvents, the defaults of the plugin are used when not set
+@kubeMonAgentEventsFlushIntervalMins = 0
+@kubeMonAgentEventsMaxPerPost = -1
+@kubeMonAgentEventsMinLevel = ""
+@kubeMonAgentEventsDisabledCategories = ""
+@kubeMonAgentHeartbeatEnabled = false
+@pluginLogFormat = ""
+@pluginLogLevel = ""
 
 def is_number?(value)
   true if Integer(value) rescue false
@@ -181,6 +189,59 @@ def populateSettingValuesFromConfigMap(parsedConfig)
     puts "config::error:Exception while reading config settings for agent configuration setting - #{errorStr}, using defaults"
     @enable_health_model = false
   end
+
+  # KubeMonAgentEvents settings
+  begin
+    if !parsedConfig.nil? && !parsedConfig[:agent_settings].nil? && !parsedConfig[:agent_settings][:kube_mon_agent_events].nil?
+      kubemon_events_config = parsedConfig[:agent_settings][:kube_mon_agent_events]
+      flushIntervalMins = kubemon_events_config[:flush_interval_mins]
+      if !flushIntervalMins.nil? && is_number?(flushIntervalMins) && flushIntervalMins.to_i > 0
+        @kubeMonAgentEventsFlushIntervalMins = flushIntervalMins.to_i
+        puts "Using config map value: kube_mon_agent_events flush_interval_mins = #{@kubeMonAgentEventsFlushIntervalMins}"
+      end
+      maxEventsPerPost = kubemon_events_config[:max_events_per_post]
+      if !maxEventsPerPost.nil? && is_number?(maxEventsPerPost) && maxEventsPerPost.to_i >= 0
+        @kubeMonAgentEventsMaxPerPost = maxEventsPerPost.to_i
+        puts "Using config map value: kube_mon_agent_events max_events_per_post = #{@kubeMonAgentEventsMaxPerPost}"
+      end
+      minLevel = kubemon_events_config[:min_level]
+      if !minLevel.nil? && ["info", "warning", "error"].include?(minLevel.to_s.strip.downcase)
+        @kubeMonAgentEventsMinLevel = minLevel.to_s.strip
+        puts "Using config map value: kube_mon_agent_events min_level = #{@kubeMonAgentEventsMinLevel}"
+      end
+      disabledCategories = kubemon_events_config[:disabled_categories]
+      if !disabledCategories.nil? && disabledCategories.kind_of?(Array)
+        @kubeMonAgentEventsDisabledCategories = disabledCategories.map { |category| category.to_s.strip }.reject(&:empty?).join(",")
+        puts "Using config map value: kube_mon_agent_events disabled_categories = #{@kubeMonAgentEventsDisabledCategories}"
+      end
+      heartbeatEnabled = kubemon_events_config[:heartbeat_enabled]
+      if !heartbeatEnabled.nil? && (heartbeatEnabled == true || heartbeatEnabled == false)
+        @kubeMonAgentHeartbeatEnabled = heartbeatEnabled
+        puts "Using config map value: kube_mon_agent_events heartbeat_enabled = #{@kubeMonAgentHeartbeatEnabled}"
+      end
+    end
+  rescue => errorStr
+    puts "config::error:Exception while reading config settings for KubeMonAgentEvents - #{errorStr}, using defaults"
+  end
+
+  # plugin runtime log settings
+  begin
+    if !parsedConfig.nil? && !parsedConfig[:agent_settings].nil? && !parsedConfig[:agent_settings][:plugin_log].nil?
+      plugin_log_config = parsedConfig[:agent_settings][:plugin_log]
+      logFormat = plugin_log_config[:format]
+      if !logFormat.nil? && ["text", "json"].include?(logFormat.to_s.strip.downcase)
+        @pluginLogFormat = logFormat.to_s.strip.downcase
+        puts "Using config map value: plugin_log format = #{@pluginLogFormat}"
+      end
+      logLevel = plugin_log_config[:level]
+      if !logLevel.nil? && ["debug", "info", "warn", "warning", "error"].include?(logLevel.to_s.strip.downcase)
+        @pluginLogLevel = logLevel.to_s.strip.downcase
+        puts "Using config map value: plugin_log level = #{@pluginLogLevel}"
+      end
+    end
+  rescue => errorStr
+    puts "config::error:Exception while reading config settings for the plugin log - #{errorStr}, using defaults"
+  end
 end
 
 @configSchemaVersion = ENV["AZMON_AGENT_CFG_SCHEMA_VERSION"]
@@ -222,6 +283,27 @@ if !file.nil?
   if @fbitTailMemBufLimitMBs > 0
     file.write("export FBIT_TAIL_MEM_BUF_LIMIT=#{@fbitTailMemBufLimitMBs}\n")
   end 
+  # KubeMonAgentEvents settings
+  if @kubeMonAgentEventsFlushIntervalMins > 0
+    file.write("export AZMON_KUBEMON_EVENTS_FLUSH_INTERVAL_MINUTES=#{@kubeMonAgentEventsFlushIntervalMins}\n")
+  end
+  if @kubeMonAgentEventsMaxPerPost >= 0
+    file.write("export AZMON_KUBEMON_EVENTS_MAX_PER_POST=#{@kubeMonAgentEventsMaxPerPost}\n")
+  end
+  if !@kubeMonAgentEventsMinLevel.empty?
+    file.write("export AZMON_KUBEMON_EVENTS_MIN_LEVEL=#{@kubeMonAgentEventsMinLevel}\n")
+  end
+  if !@kubeMonAgentEventsDisabledCategories.empty?
+    file.write("export AZMON_KUBEMON_EVENTS_DISABLED_CATEGORIES=#{@kubeMonAgentEventsDisabledCategories}\n")
+  end
+  file.write("export AZMON_KUBEMON_HEARTBEAT_ENABLED=#{@kubeMonAgentHeartbeatEnabled}\n")
+  # plugin runtime log settings
+  if !@pluginLogFormat.empty?
+    file.write("export AZMON_PLUGIN_LOG_FORMAT=#{@pluginLogFormat}\n")
+  end
+  if !@pluginLogLevel.empty?
+    file.write("export AZMON_PLUGIN_LOG_LEVEL=#{@pluginLogLevel}\n")
+  end
   # Close file after writing all environment variables
   file.close
 else
@@ -11,6 +11,14 @@ require_relative "/etc/fluent/plugin/constants"
 
 # Setting default values which will be used in case they are not set in the configmap or if configmap doesnt exist
 @collectPVKubeSystemMetrics = false
+# Filters of the telegraf metrics sent to Log Analytics, comma separated glob patterns. Empty lists send all the metrics
+@telegrafMetricFilterSettings = [:included_namespaces, :excluded_namespaces, :included_names, :excluded_names, :included_tags, :excluded_tags]
+@telegrafMetricFilters = {}
+# Window in seconds of the aggregation of the telegraf metrics sent to Log Analytics, 0 sends the metrics as collected
+@telegrafMetricAggregationWindowSeconds = 0
+# Max distinct values of a tag of a telegraf metric namespace per hour and what is done over it (drop or hash), 0 does not limit the tags
+@telegrafMaxTagValues = 0
+@telegrafTagOverflowAction = "drop"
 
 # Use parser to parse the configmap toml file to a ruby structure
 def parseConfigMap
@@ -42,6 +50,67 @@ def populateSettingValuesFromConfigMap(parsedConfig)
   rescue => errorStr
     ConfigParseErrorLogger.logError("Exception while reading config map settings for PV kube-system collection - #{errorStr}, using defaults, please check config map for errors")
   end
+
+  # Get the allow and deny lists of the telegraf metrics sent to Log Analytics
+  begin
+    if !parsedConfig.nil? && !parsedConfig[:metric_collection_settings][:telegraf_metric_filters].nil?
+      filters = parsedConfig[:metric_collection_settings][:telegraf_metric_filters]
+      @telegrafMetricFilterSettings.each do |setting|
+        patterns = filters[setting]
+        next if patterns.nil?
+        if patterns.kind_of?(Array) && patterns.all? { |pattern| pattern.kind_of?(String) && !pattern.include?(",") }
+          @telegrafMetricFilters[setting] = patterns.map(&:strip).reject(&:empty?).join(",")
+          puts "config::Using config map setting for telegraf metric filter #{setting}"
+        else
+          ConfigParseErrorLogger.logError("config::telegraf metric filter #{setting} must be a list of patterns without commas, ignoring it")
+        end
+      end
+    end
+  rescue => errorStr
+    ConfigParseErrorLogger.logError("Exception while reading config map settings for telegraf metric filters - #{errorStr}, using defaults, please check config map for errors")
+  end
+
+  # Get the window of the aggregation of the telegraf metrics sent to Log Analytics
+  begin
+    if !parsedConfig.nil? && !parsedConfig[:metric_collection_settings][:telegraf_metric_aggregation].nil? && !parsedConfig[:metric_collection_settings][:telegraf_metric_aggregation][:window_seconds].nil?
+      windowSeconds = parsedConfig[:metric_collection_settings][:telegraf_metric_aggregation][:window_seconds]
+      if windowSeconds.kind_of?(Integer) && windowSeconds >= 0
+        @telegrafMetricAggregationWindowSeconds = windowSeconds
+        puts "config::Using config map setting for telegraf metric aggregation window"
+      else
+        ConfigParseErrorLogger.logError("config::telegraf metric aggregation window_seconds must be a positive integer, not aggregating the telegraf metrics")
+      end
+    end
+  rescue => errorStr
+    ConfigParseErrorLogger.logError("Exception while reading config map settings for telegraf metric aggregation - #{errorStr}, using defaults, please check config map for errors")
+  end
+
+  # Get the limit of the distinct values of the tags of the telegraf metrics
+  begin
+    if !parsedConfig.nil? && !parsedConfig[:metric_collection_settings][:telegraf_tag_cardinality].nil?
+      cardinalitySettings = parsedConfig[:metric_collection_settings][:telegraf_tag_cardinality]
+      maxValuesPerTag = cardinalitySettings[:max_values_per_tag]
+      if !maxValuesPerTag.nil?
+        if maxValuesPerTag.kind_of?(Integer) && maxValuesPerTag >= 0
+          @telegrafMaxTagValues = maxValuesPerTag
+          puts "config::Using config map setting for telegraf tag cardinality limit"
+        else
+          ConfigParseErrorLogger.logError("config::telegraf tag cardinality max_values_per_tag must be a positive integer, not limiting the tags")
+        end
+      end
+      overflowAction = cardinalitySettings[:overflow_action]
+      if !overflowAction.nil?
+        if overflowAction.kind_of?(String) && ["drop", "hash"].include?(overflowAction.strip.downcase)
+          @telegrafTagOverflowAction = overflowAction.strip.downcase
+          puts "config::Using config map setting for telegraf tag overflow action"
+        else
+          ConfigParseErrorLogger.logError("config::telegraf tag cardinality overflow_action must be drop or hash, using drop")
+        end
+      end
+    end
+  rescue => errorStr
+    ConfigParseErrorLogger.logError("Exception while reading config map settings for telegraf tag cardinality - #{errorStr}, using defaults, please check config map for errors")
+  end
 end
 
 @configSchemaVersion = ENV["AZMON_AGENT_CFG_SCHEMA_VERSION"]
@@ -62,6 +131,12 @@ file = File.open("config_metric_collection_env_var", "w")
 
 if !file.nil?
   file.write("export AZMON_PV_COLLECT_KUBE_SYSTEM_METRICS=#{@collectPVKubeSystemMetrics}\n")
+  @telegrafMetricFilters.each do |setting, patterns|
+    file.write("export AZMON_TELEGRAF_METRICS_#{setting.to_s.upcase}=\"#{patterns}\"\n")
+  end
+  file.write("export AZMON_TELEGRAF_METRICS_AGGREGATION_WINDOW_SECONDS=#{@telegrafMetricAggregationWindowSeconds}\n")
+  file.write("export AZMON_TELEGRAF_METRICS_MAX_TAG_VALUES=#{@telegrafMaxTagValues}\n")
+  file.write("export AZMON_TELEGRAF_METRICS_TAG_OVERFLOW_ACTION=#{@telegrafTagOverflowAction}\n")
   # Close file after writing all metric collection setting environment variables
   file.close
   puts "****************End Metric Collection Settings Processing********************"
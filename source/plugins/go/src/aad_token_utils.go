@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// env variables injected by the azure workload identity webhook into pods using a federated service account
+const (
+	envAzureClientID           = "AZURE_CLIENT_ID"
+	envAzureTenantID           = "AZURE_TENANT_ID"
+	envAzureFederatedTokenFile = "AZURE_FEDERATED_TOKEN_FILE"
+	envAzureAuthorityHost      = "AZURE_AUTHORITY_HOST"
+)
+
+const (
+	defaultAzureAuthorityHost = "https://login.microsoftonline.com/"
+	clientAssertionType       = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// AAD tokens are refreshed this long before they expire
+	aadTokenRefreshBuffer = 5 * time.Minute
+)
+
+type aadTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+// isWorkloadIdentityConfigured returns whether the pod has a projected service account token for workload identity
+func isWorkloadIdentityConfigured() bool {
+	return strings.TrimSpace(os.Getenv(envAzureFederatedTokenFile)) != ""
+}
+
+func getAzureAuthorityHost() string {
+	authority := strings.TrimSpace(os.Getenv(envAzureAuthorityHost))
+	if authority == "" {
+		authority = defaultAzureAuthorityHost
+	}
+	return authority
+}
+
+// newAADTokenHTTPClient returns the client for the AAD token requests, going through the proxy when configured
+func newAADTokenHTTPClient() *http.Client {
+	transport := &http.Transport{}
+	if ProxyEndpoint != "" {
+		if proxyEndpointUrl, err := url.Parse(ProxyEndpoint); err == nil {
+			transport.Proxy = http.ProxyURL(proxyEndpointUrl)
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}
+}
+
+// newWorkloadIdentityTokenProvider returns the provider exchanging the projected service account token for the scope.
+// The tenant and client id from the webhook are used when not given explicitly
+func newWorkloadIdentityTokenProvider(tenantID string, clientID string, scope string) *TokenProvider {
+	if tenantID == "" {
+		tenantID = strings.TrimSpace(os.Getenv(envAzureTenantID))
+	}
+	if clientID == "" {
+		clientID = strings.TrimSpace(os.Getenv(envAzureClientID))
+	}
+	authority := getAzureAuthorityHost()
+	tokenFile := strings.TrimSpace(os.Getenv(envAzureFederatedTokenFile))
+	httpClient := newAADTokenHTTPClient()
+	return getOrCreateTokenProvider("workloadidentity:"+clientID+":"+scope, aadTokenRefreshBuffer, func() (string, int64, error) {
+		return getAccessTokenFromFederatedToken(httpClient, authority, tenantID, clientID, tokenFile, scope)
+	})
+}
+
+// newClientSecretTokenProvider returns the provider getting tokens for the scope with the service principal secret
+func newClientSecretTokenProvider(tenantID string, clientID string, clientSecret string, scope string) *TokenProvider {
+	authority := getAzureAuthorityHost()
+	httpClient := newAADTokenHTTPClient()
+	return getOrCreateTokenProvider("serviceprincipal:"+clientID+":"+scope, aadTokenRefreshBuffer, func() (string, int64, error) {
+		if tenantID == "" || clientID == "" || clientSecret == "" {
+			return "", 0, errors.New("getAccessTokenFromClientSecret: tenant id, client id and client secret are required")
+		}
+		endpoint := buildAADTokenEndpoint(authority, tenantID)
+		form := url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", clientID)
+		form.Set("client_secret", clientSecret)
+		form.Set("scope", scope)
+		return requestAADToken(httpClient, endpoint, form)
+	})
+}
+
+func buildAADTokenEndpoint(authority string, tenantID string) string {
+	return strings.TrimSuffix(authority, "/") + "/" + tenantID + "/oauth2/v2.0/token"
+}
+
+// buildFederatedTokenRequest returns the token endpoint and the client credentials form with the service account
+// token as client assertion
+func buildFederatedTokenRequest(authority string, tenantID string, clientID string, assertion string, scope string) (string, url.Values) {
+	endpoint := buildAADTokenEndpoint(authority, tenantID)
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", clientID)
+	form.Set("client_assertion_type", clientAssertionType)
+	form.Set("client_assertion", assertion)
+	form.Set("scope", scope)
+	return endpoint, form
+}
+
+// getAccessTokenFromFederatedToken exchanges the projected service account token for an AAD access token and returns
+// the token with its expiration (unix time)
+func getAccessTokenFromFederatedToken(httpClient *http.Client, authority string, tenantID string, clientID string, tokenFile string, scope string) (string, int64, error) {
+	if tenantID == "" || clientID == "" || tokenFile == "" {
+		return "", 0, errors.New("getAccessTokenFromFederatedToken: tenant id, client id and federated token file are required")
+	}
+	// the token file is rotated by kubelet, so it is read for every exchange
+	assertion, err := ReadFileContents(tokenFile)
+	if err != nil {
+		return "", 0, err
+	}
+	endpoint, form := buildFederatedTokenRequest(authority, tenantID, clientID, assertion, scope)
+	return requestAADToken(httpClient, endpoint, form)
+}
+
+// requestAADToken posts the token request form to the AAD token endpoint and returns the access token with its
+// expiration (unix time). Retries are done by the token provider
+func requestAADToken(httpClient *http.Client, endpoint string, form url.Values) (string, int64, error) {
+	resp, err := httpClient.PostForm(endpoint, form)
+	if err != nil {
+		return "", 0, fmt.Errorf("requestAADToken: Error calling token endpoint: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	responseBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != 200 {
+		return "", 0, fmt.Errorf("requestAADToken: token request failed with error code: %d %s", resp.StatusCode, string(responseBytes))
+	}
+	var tokenResponse aadTokenResponse
+	if err = json.Unmarshal(responseBytes, &tokenResponse); err != nil {
+		return "", 0, err
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", 0, errors.New("requestAADToken: token response has no access token")
+	}
+	Log("Info requestAADToken: got token for scope %s, expires in %d seconds", form.Get("scope"), tokenResponse.ExpiresIn)
+	return tokenResponse.AccessToken, time.Now().Unix() + tokenResponse.ExpiresIn, nil
+}
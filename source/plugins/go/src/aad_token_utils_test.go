@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_buildFederatedTokenRequest(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		authority string
+		endpoint  string
+	}
+
+	tests := []test_struct{
+		{"authority with trailing slash", "https://login.microsoftonline.com/", "https://login.microsoftonline.com/tenant/oauth2/v2.0/token"},
+		{"authority without trailing slash", "https://login.microsoftonline.us", "https://login.microsoftonline.us/tenant/oauth2/v2.0/token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			endpoint, form := buildFederatedTokenRequest(tt.authority, "tenant", "client", "assertion", "https://mycluster.westeurope.kusto.windows.net/.default")
+			if endpoint != tt.endpoint {
+				t.Errorf("buildFederatedTokenRequest endpoint = %s, want %s", endpoint, tt.endpoint)
+			}
+			if form.Get("grant_type") != "client_credentials" || form.Get("client_id") != "client" || form.Get("client_assertion") != "assertion" ||
+				form.Get("client_assertion_type") != clientAssertionType || form.Get("scope") != "https://mycluster.westeurope.kusto.windows.net/.default" {
+				t.Errorf("buildFederatedTokenRequest form = %v", form)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// path of the file with the token of the admin api. The admin api is served with the stats endpoint, on localhost, and
+// is off when the token is not set
+const envAdminTokenPath = "AZMON_ADMIN_TOKEN_PATH"
+
+// the feature flags the admin api can flip at runtime, read by the flushes without restarting the agent
+var adminFeatureFlags = map[string]*bool{
+	"msgpack_passthrough":  &MdsdMsgpackPassthrough,
+	"mdsd_forward_options": &MdsdForwardOptions,
+	"record_metadata":      &ForwardRecordMetadata,
+	"sort_batch":           &SortBatchByTimestamp,
+}
+
+var (
+	adminToken string
+	// PausedRoutes the routes paused thru the admin api. Their flushes are retried, so fluent-bit buffers the records
+	// until the route is resumed
+	PausedRoutes = make(map[string]bool)
+	// PausedRoutesMutex read and write mutex access to PausedRoutes
+	PausedRoutesMutex = &sync.RWMutex{}
+	// requests for a refresh of the container metadata or a flush of the KubeMonAgentEvents before the next tick
+	containerMetadataRefreshRequests = make(chan struct{}, 1)
+	kubeMonAgentEventsFlushRequests  = make(chan struct{}, 1)
+)
+
+// initializeAdminAPI registers the admin api on the stats endpoint when its token is set
+func initializeAdminAPI() {
+	adminToken = ""
+	path := strings.TrimSpace(os.Getenv(envAdminTokenPath))
+	if path == "" {
+		return
+	}
+	token, err := ReadFileContents(path)
+	if err != nil || token == "" {
+		Log("Error::admin::Unable to read the admin token from %s, the admin api is disabled", path)
+		return
+	}
+	if strings.TrimSpace(os.Getenv(envStatsPort)) == "" {
+		Log("Error::admin::%s is not set, the admin api is disabled", envStatsPort)
+		return
+	}
+	adminToken = token
+	StatsServeMux.HandleFunc("/admin/flags", adminHandler(handleAdminFlags))
+	StatsServeMux.HandleFunc("/admin/enrichment/refresh", adminHandler(handleAdminEnrichmentRefresh))
+	StatsServeMux.HandleFunc("/admin/kubemonagentevents/flush", adminHandler(handleAdminKubeMonAgentEventsFlush))
+	StatsServeMux.HandleFunc("/admin/routes/pause", adminHandler(handleAdminRoutePause(true)))
+	StatsServeMux.HandleFunc("/admin/routes/resume", adminHandler(handleAdminRoutePause(false)))
+	Log("Admin api enabled on the stats endpoint")
+}
+
+// adminHandler checks the bearer token of the admin requests and logs them
+func adminHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Method == http.MethodPost {
+			Log("Admin request %s %s", r.URL.Path, r.URL.RawQuery)
+		}
+		handler(w, r)
+	}
+}
+
+// handleAdminFlags returns the feature flags, or sets the flag name to value on POST
+func handleAdminFlags(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		name := r.URL.Query().Get("name")
+		flag, ok := adminFeatureFlags[name]
+		if !ok {
+			http.Error(w, "unknown flag "+name, http.StatusBadRequest)
+			return
+		}
+		value, err := strconv.ParseBool(r.URL.Query().Get("value"))
+		if err != nil {
+			http.Error(w, "value is not a boolean", http.StatusBadRequest)
+			return
+		}
+		*flag = value
+		Log("Warning::admin::Feature flag %s set to %v", name, value)
+	}
+	writeAdminResponse(w, getAdminState())
+}
+
+// adminState the flags and the paused routes
+type adminState struct {
+	Flags        map[string]bool `json:"flags"`
+	PausedRoutes []string        `json:"pausedRoutes"`
+}
+
+func getAdminState() adminState {
+	state := adminState{Flags: make(map[string]bool), PausedRoutes: []string{}}
+	for name, flag := range adminFeatureFlags {
+		state.Flags[name] = *flag
+	}
+	PausedRoutesMutex.RLock()
+	defer PausedRoutesMutex.RUnlock()
+	for route := range PausedRoutes {
+		state.PausedRoutes = append(state.PausedRoutes, route)
+	}
+	sort.Strings(state.PausedRoutes)
+	return state
+}
+
+func handleAdminEnrichmentRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	requestNow(containerMetadataRefreshRequests)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func handleAdminKubeMonAgentEventsFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	requestNow(kubeMonAgentEventsFlushRequests)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleAdminRoutePause pauses or resumes the route of the request
+func handleAdminRoutePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		route := r.URL.Query().Get("route")
+		if route == "" {
+			http.Error(w, "route is required", http.StatusBadRequest)
+			return
+		}
+		setRoutePaused(route, paused)
+		writeAdminResponse(w, getAdminState())
+	}
+}
+
+func writeAdminResponse(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func setRoutePaused(route string, paused bool) {
+	PausedRoutesMutex.Lock()
+	defer PausedRoutesMutex.Unlock()
+	if paused {
+		PausedRoutes[route] = true
+		Log("Warning::admin::Route %s paused, its flushes are retried until it is resumed", route)
+	} else {
+		delete(PausedRoutes, route)
+		Log("Route %s resumed", route)
+	}
+}
+
+// isRoutePaused returns whether the route was paused thru the admin api
+func isRoutePaused(route string) bool {
+	PausedRoutesMutex.RLock()
+	defer PausedRoutesMutex.RUnlock()
+	return PausedRoutes[route]
+}
+
+// requestNow requests a refresh or a flush, a request already pending covers it
+func requestNow(requests chan struct{}) {
+	select {
+	case requests <- struct{}{}:
+	default:
+	}
+}
+
+// waitForTickOrRequest waits for the next tick of a periodic refresh or flush, or for a request to run it now
+func waitForTickOrRequest(tick <-chan time.Time, requests <-chan struct{}) {
+	select {
+	case <-tick:
+	case <-requests:
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_adminHandler(t *testing.T) {
+	adminToken = "secret"
+	defer func() { adminToken = "" }()
+	testFlag := false
+	adminFeatureFlags["test_flag"] = &testFlag
+	defer delete(adminFeatureFlags, "test_flag")
+	PausedRoutes = make(map[string]bool)
+
+	type test_struct struct {
+		testname   string
+		method     string
+		url        string
+		token      string
+		handler    http.HandlerFunc
+		statusCode int
+	}
+
+	tests := []test_struct{
+		{"no token", "POST", "/admin/flags?name=test_flag&value=true", "", handleAdminFlags, http.StatusUnauthorized},
+		{"wrong token", "POST", "/admin/flags?name=test_flag&value=true", "other", handleAdminFlags, http.StatusUnauthorized},
+		{"unknown flag", "POST", "/admin/flags?name=missing&value=true", "secret", handleAdminFlags, http.StatusBadRequest},
+		{"invalid value", "POST", "/admin/flags?name=test_flag&value=maybe", "secret", handleAdminFlags, http.StatusBadRequest},
+		{"set flag", "POST", "/admin/flags?name=test_flag&value=true", "secret", handleAdminFlags, http.StatusOK},
+		{"pause without route", "POST", "/admin/routes/pause", "secret", handleAdminRoutePause(true), http.StatusBadRequest},
+		{"pause", "POST", "/admin/routes/pause?route=v2", "secret", handleAdminRoutePause(true), http.StatusOK},
+		{"refresh with get", "GET", "/admin/enrichment/refresh", "secret", handleAdminEnrichmentRefresh, http.StatusMethodNotAllowed},
+		{"refresh", "POST", "/admin/enrichment/refresh", "secret", handleAdminEnrichmentRefresh, http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			adminHandler(tt.handler)(w, r)
+			if w.Code != tt.statusCode {
+				t.Errorf("status code = %d, want %d", w.Code, tt.statusCode)
+			}
+		})
+	}
+
+	if testFlag != true {
+		t.Errorf("test_flag = %v, want true", testFlag)
+	}
+	if !isRoutePaused("v2") || isRoutePaused("mdsd") {
+		t.Errorf("paused routes = %v, want v2", PausedRoutes)
+	}
+	if len(containerMetadataRefreshRequests) != 1 {
+		t.Errorf("refresh requests = %d, want 1", len(containerMetadataRefreshRequests))
+	}
+	setRoutePaused("v2", false)
+	if isRoutePaused("v2") {
+		t.Errorf("route v2 is still paused")
+	}
+}
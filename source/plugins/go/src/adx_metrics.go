@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-kusto-go/kusto/ingest"
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+// env variable of the ADX table of the telegraf metrics. When set on the ADX route, the metrics are ingested into the
+// table of the ADX cluster of the container logs instead of being sent to the workspace
+const envADXMetricsTable = "AZMON_ADX_METRICS_TABLE"
+
+// env variable of the ingestion mapping of the metrics table
+const envADXMetricsMapping = "AZMON_ADX_METRICS_MAPPING"
+
+// env variable of the max number of metrics of an ingestion, the metrics of a flush over it are ingested in several
+// ingestions
+const envADXMetricsMaxBatchSize = "AZMON_ADX_METRICS_MAX_BATCH_SIZE"
+
+const (
+	defaultADXMetricsMapping      = "InsightsMetricsMapping"
+	defaultADXMetricsMaxBatchSize = 10000
+)
+
+var (
+	// TelegrafMetricsRouteADX when true, the telegraf metrics are ingested into the ADX metrics table
+	TelegrafMetricsRouteADX bool
+	// ADXMetricsConfig the cluster, database, credentials and table of the metrics ingestor
+	ADXMetricsConfig adxClientConfig
+	// ADXMetricsMapping the ingestion mapping of the metrics table
+	ADXMetricsMapping string
+	// ADXMetricsMaxBatchSize the max number of metrics of an ingestion
+	ADXMetricsMaxBatchSize int
+
+	adxMetricsIngestorMutex = &sync.Mutex{}
+	adxMetricsIngestor      *ingest.Ingestion
+)
+
+// initializeADXMetricsRoute routes the telegraf metrics to the ADX metrics table when configured on the ADX route
+func initializeADXMetricsRoute() {
+	TelegrafMetricsRouteADX = false
+	table := strings.TrimSpace(os.Getenv(envADXMetricsTable))
+	if table == "" {
+		return
+	}
+	if ContainerLogsRouteADX == false || len(ContainerLogSinkDeclarations) > 0 {
+		Log("Error::ADX::%s is only supported on the %s route without declared sinks, the telegraf metrics are sent to the workspace", envADXMetricsTable, ContainerLogsADXRoute)
+		return
+	}
+	if DataBoundaryADXBlocked == true {
+		Log("Error::ADX::The telegraf metrics are not ingested into ADX since the cluster is outside of the %s data boundary", DataBoundary)
+		return
+	}
+	ADXMetricsConfig = adxClientConfig{
+		clusterURI:       AdxClusterUri,
+		database:         AdxDatabaseName,
+		tenantID:         AdxTenantID,
+		clientID:         AdxClientID,
+		clientSecret:     AdxClientSecret,
+		workloadIdentity: AdxWorkloadIdentity,
+		table:            table,
+	}
+	if ADXMetricsConfig.database == "" {
+		ADXMetricsConfig.database = DefaultAdxDatabaseName
+	}
+	ADXMetricsMapping = strings.TrimSpace(os.Getenv(envADXMetricsMapping))
+	if ADXMetricsMapping == "" {
+		ADXMetricsMapping = defaultADXMetricsMapping
+	}
+	ADXMetricsMaxBatchSize = defaultADXMetricsMaxBatchSize
+	if value := strings.TrimSpace(os.Getenv(envADXMetricsMaxBatchSize)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			ADXMetricsMaxBatchSize = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d", value, envADXMetricsMaxBatchSize, defaultADXMetricsMaxBatchSize)
+		}
+	}
+	TelegrafMetricsRouteADX = true
+	Log("Routing telegraf metrics thru %s route to table %s with mapping %s, at most %d metrics per ingestion", ContainerLogsADXRoute, table, ADXMetricsMapping, ADXMetricsMaxBatchSize)
+}
+
+// getTelegrafMetricsRouteName returns the route of the telegraf metrics
+func getTelegrafMetricsRouteName() string {
+	if TelegrafMetricsRouteADX == true {
+		return ContainerLogsADXRoute
+	}
+	if TelegrafMetricsRouteDCR == true {
+		return ContainerLogsDCRRoute
+	}
+	if TelegrafMetricsRouteOTLP == true {
+		return ContainerLogsOTLPRoute
+	}
+	return getAgentDataRouteName()
+}
+
+// getADXMetricsIngestor returns the ingestor of the metrics table, created again when the previous creation failed
+func getADXMetricsIngestor() (*ingest.Ingestion, error) {
+	adxMetricsIngestorMutex.Lock()
+	defer adxMetricsIngestorMutex.Unlock()
+	if adxMetricsIngestor == nil {
+		ingestor, err := newADXIngestor(ADXMetricsConfig)
+		if err != nil {
+			return nil, err
+		}
+		adxMetricsIngestor = ingestor
+	}
+	return adxMetricsIngestor, nil
+}
+
+// sendTelegrafMetricsToADX ingests the metrics into the ADX metrics table, in batches of at most the max batch size. The
+// batches ingested by an earlier delivery of the chunk are not ingested again
+func sendTelegrafMetricsToADX(ctx context.Context, laMetrics []*laTelegrafMetric, batchID string) int {
+	ingestor, err := getADXMetricsIngestor()
+	if err != nil {
+		Log("Error::ADX::Unable to create the ADX ingestor of table %s: %s", ADXMetricsConfig.table, err.Error())
+		addIngestionFailureEvent(ContainerLogsADXRoute, InsightsMetricsDataType, err, time.Now())
+		ContainerLogTelemetryMutex.Lock()
+		ContainerLogsADXClientCreateErrors += 1
+		ContainerLogTelemetryMutex.Unlock()
+		PluginMetrics.addClientCreateError("adx")
+		return output.FLB_RETRY
+	}
+
+	start := time.Now()
+	for offset := 0; offset < len(laMetrics); offset += ADXMetricsMaxBatchSize {
+		end := offset + ADXMetricsMaxBatchSize
+		if end > len(laMetrics) {
+			end = len(laMetrics)
+		}
+		err := sendUnsentPayload("PostTelegrafMetricsToLA", ContainerLogsADXRoute, batchID, offset, end, func() error {
+			if err := ingestTelegrafMetrics(ctx, ingestor, laMetrics[offset:end]); err != nil {
+				return err
+			}
+			UpdateNumTelegrafMetricsSentTelemetry(end-offset, 0, 0)
+			return nil
+		})
+		if err != nil {
+			Log("PostTelegrafMetricsToLA::Error:(retriable) when ingesting %d metrics into ADX table %s: %s", end-offset, ADXMetricsConfig.table, err.Error())
+			addIngestionFailureEvent(ContainerLogsADXRoute, InsightsMetricsDataType, err, time.Now())
+			UpdateNumTelegrafMetricsSentTelemetry(0, 1, 0)
+			return output.FLB_RETRY
+		}
+	}
+	Log("Success::ADX::Successfully ingested telegraf metrics into ADX table %s in %s", ADXMetricsConfig.table, time.Since(start))
+	return output.FLB_OK
+}
+
+func ingestTelegrafMetrics(ctx context.Context, ingestor *ingest.Ingestion, laMetrics []*laTelegrafMetric) error {
+	r, w := io.Pipe()
+	defer r.Close()
+	enc := json.NewEncoder(w)
+	go func() {
+		defer w.Close()
+		for _, metric := range laMetrics {
+			if encError := enc.Encode(metric); encError != nil {
+				Log(fmt.Sprintf("Error::ADX Encoding metric for ADX %s", encError))
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	sendStart := time.Now()
+	_, err := ingestor.FromReader(ctx, r, ingest.IngestionMappingRef(ADXMetricsMapping, ingest.JSON), ingest.FileFormat(ingest.JSON))
+	trackFlushDependency(dependencyTypeADX, dependencyTarget(ADXMetricsConfig.clusterURI), InsightsMetricsDataType, sendStart, errorDependencyResultCode(err), err == nil, len(laMetrics))
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_initializeADXMetricsRoute(t *testing.T) {
+	type test_struct struct {
+		testname     string
+		adxRoute     bool
+		table        string
+		maxBatchSize string
+		enabled      bool
+		batchSize    int
+	}
+
+	tests := []test_struct{
+		{"not configured", true, "", "", false, 0},
+		{"not the ADX route", false, "InsightsMetrics", "", false, 0},
+		{"defaults", true, "InsightsMetrics", "", true, defaultADXMetricsMaxBatchSize},
+		{"max batch size", true, "InsightsMetrics", "500", true, 500},
+		{"invalid max batch size", true, "InsightsMetrics", "-1", true, defaultADXMetricsMaxBatchSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ContainerLogsRouteADX = tt.adxRoute
+			os.Setenv(envADXMetricsTable, tt.table)
+			os.Setenv(envADXMetricsMaxBatchSize, tt.maxBatchSize)
+			defer func() {
+				ContainerLogsRouteADX = false
+				TelegrafMetricsRouteADX = false
+				os.Unsetenv(envADXMetricsTable)
+				os.Unsetenv(envADXMetricsMaxBatchSize)
+			}()
+			initializeADXMetricsRoute()
+			if TelegrafMetricsRouteADX != tt.enabled {
+				t.Fatalf("TelegrafMetricsRouteADX = %v, want %v", TelegrafMetricsRouteADX, tt.enabled)
+			}
+			if tt.enabled && (ADXMetricsMaxBatchSize != tt.batchSize || ADXMetricsConfig.table != tt.table || ADXMetricsMapping != defaultADXMetricsMapping || ADXMetricsConfig.database != DefaultAdxDatabaseName) {
+				t.Errorf("batch size %d, table %s, mapping %s, database %s", ADXMetricsMaxBatchSize, ADXMetricsConfig.table, ADXMetricsMapping, ADXMetricsConfig.database)
+			}
+			if tt.enabled && getTelegrafMetricsRouteName() != ContainerLogsADXRoute {
+				t.Errorf("getTelegrafMetricsRouteName() = %s, want %s", getTelegrafMetricsRouteName(), ContainerLogsADXRoute)
+			}
+		})
+	}
+}
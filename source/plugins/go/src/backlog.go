@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// env variable of the filesystem buffer of fluent-bit, the storage.path of its configuration
+const envFbitStoragePath = "AZMON_FBIT_STORAGE_PATH"
+
+const defaultFbitStoragePath = "/var/opt/microsoft/docker-cimprov/state/flbstore/"
+
+// retryingSince the time each route started having chunks in retry, guarded by ChunksInRetryMutex
+var retryingSince = make(map[string]time.Time)
+
+// routeBacklog the chunks in retry of a route. Fluent-bit does not tell the retries apart, so the oldest chunk is
+// assumed to be in retry since the route started having chunks in retry
+type routeBacklog struct {
+	Route                 string  `json:"route"`
+	ChunksInRetry         int     `json:"chunksInRetry"`
+	OldestRetryAgeSeconds float64 `json:"oldestRetryAgeSeconds"`
+}
+
+// diskBacklog the chunks waiting in a directory
+type diskBacklog struct {
+	Path                  string  `json:"path"`
+	Chunks                int     `json:"chunks"`
+	Bytes                 int64   `json:"bytes"`
+	OldestChunkAgeSeconds float64 `json:"oldestChunkAgeSeconds"`
+}
+
+// backlogStats how far behind the node is, from the retry queues of the routes, the filesystem buffer of fluent-bit and
+// the dead-letter directory of the retry budget
+type backlogStats struct {
+	Retries    []routeBacklog `json:"retries"`
+	DiskBuffer diskBacklog    `json:"diskBuffer"`
+	DeadLetter *diskBacklog   `json:"deadLetter,omitempty"`
+}
+
+// trackRetryingSince updates when the route started having chunks in retry, called with ChunksInRetryMutex held
+func trackRetryingSince(route string, chunksInRetry int, now time.Time) {
+	if chunksInRetry == 0 {
+		delete(retryingSince, route)
+	} else if _, ok := retryingSince[route]; !ok {
+		retryingSince[route] = now
+	}
+}
+
+func fbitStoragePath() string {
+	if path := strings.TrimSpace(os.Getenv(envFbitStoragePath)); path != "" {
+		return path
+	}
+	return defaultFbitStoragePath
+}
+
+// getBacklogStats returns the backlog of the node
+func getBacklogStats() backlogStats {
+	now := time.Now()
+	stats := backlogStats{Retries: []routeBacklog{}}
+
+	ChunksInRetryMutex.Lock()
+	for route, chunks := range ChunksInRetry {
+		if chunks == 0 {
+			continue
+		}
+		backlog := routeBacklog{Route: route, ChunksInRetry: chunks}
+		if since, ok := retryingSince[route]; ok {
+			backlog.OldestRetryAgeSeconds = now.Sub(since).Seconds()
+		}
+		stats.Retries = append(stats.Retries, backlog)
+	}
+	ChunksInRetryMutex.Unlock()
+	sort.Slice(stats.Retries, func(i, j int) bool { return stats.Retries[i].Route < stats.Retries[j].Route })
+
+	stats.DiskBuffer = getDiskBacklog(fbitStoragePath(), now)
+	if RetryBudgetDeadLetterDir != "" {
+		deadLetter := getDiskBacklog(RetryBudgetDeadLetterDir, now)
+		stats.DeadLetter = &deadLetter
+	}
+	return stats
+}
+
+// getDiskBacklog returns the files under the directory, empty when it can't be read
+func getDiskBacklog(path string, now time.Time) diskBacklog {
+	backlog := diskBacklog{Path: path}
+	var oldest time.Time
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		backlog.Chunks++
+		backlog.Bytes += info.Size()
+		if oldest.IsZero() || info.ModTime().Before(oldest) {
+			oldest = info.ModTime()
+		}
+		return nil
+	})
+	if !oldest.IsZero() {
+		backlog.OldestChunkAgeSeconds = now.Sub(oldest).Seconds()
+	}
+	return backlog
+}
+
+// addBacklogDimensions adds the backlog of the node to the heartbeat
+func addBacklogDimensions(dimensions map[string]string) {
+	stats := getBacklogStats()
+	chunksInRetry := 0
+	oldestRetryAge := 0.0
+	for _, backlog := range stats.Retries {
+		chunksInRetry += backlog.ChunksInRetry
+		if backlog.OldestRetryAgeSeconds > oldestRetryAge {
+			oldestRetryAge = backlog.OldestRetryAgeSeconds
+		}
+	}
+	dimensions["ChunksInRetry"] = strconv.Itoa(chunksInRetry)
+	dimensions["OldestRetryAgeSecs"] = strconv.Itoa(int(oldestRetryAge))
+	dimensions["DiskBufferChunks"] = strconv.Itoa(stats.DiskBuffer.Chunks)
+	dimensions["DiskBufferBytes"] = strconv.FormatInt(stats.DiskBuffer.Bytes, 10)
+	dimensions["DiskBufferOldestChunkAgeSecs"] = strconv.Itoa(int(stats.DiskBuffer.OldestChunkAgeSeconds))
+	if stats.DeadLetter != nil {
+		dimensions["DeadLetterChunks"] = strconv.Itoa(stats.DeadLetter.Chunks)
+		dimensions["DeadLetterBytes"] = strconv.FormatInt(stats.DeadLetter.Bytes, 10)
+	}
+}
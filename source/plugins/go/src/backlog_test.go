@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+func Test_getBacklogStats(t *testing.T) {
+	type test_struct struct {
+		testname string
+		outcomes []int
+		chunks   int
+		retrying bool
+	}
+
+	tests := []test_struct{
+		{"no retries", []int{output.FLB_OK}, 0, false},
+		{"retrying", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_OK}, 1, true},
+		{"drained", []int{output.FLB_RETRY, output.FLB_OK}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ChunksInRetry = make(map[string]int)
+			retryingSince = make(map[string]time.Time)
+			for _, retCode := range tt.outcomes {
+				recordFlushOutcome("test", retCode)
+			}
+			stats := getBacklogStats()
+			if tt.chunks == 0 {
+				if len(stats.Retries) != 0 || len(retryingSince) != 0 {
+					t.Errorf("getBacklogStats() retries = %+v, want none", stats.Retries)
+				}
+				return
+			}
+			if len(stats.Retries) != 1 || stats.Retries[0].ChunksInRetry != tt.chunks || stats.Retries[0].OldestRetryAgeSeconds < 0 {
+				t.Errorf("getBacklogStats() retries = %+v, want %d chunks", stats.Retries, tt.chunks)
+			}
+		})
+	}
+}
+
+func Test_getDiskBacklog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flbstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "tail.0"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "tail.0", "1-1.flb"), make([]byte, 100), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "tail.0", "1-2.flb"), make([]byte, 50), 0644)
+	now := time.Now()
+	os.Chtimes(filepath.Join(dir, "tail.0", "1-1.flb"), now.Add(-time.Minute), now.Add(-time.Minute))
+
+	backlog := getDiskBacklog(dir, now)
+	if backlog.Chunks != 2 || backlog.Bytes != 150 || backlog.OldestChunkAgeSeconds < 59 || backlog.OldestChunkAgeSeconds > 61 {
+		t.Errorf("getDiskBacklog() = %+v, want 2 chunks of 150 bytes, oldest 60 seconds old", backlog)
+	}
+
+	if missing := getDiskBacklog(filepath.Join(dir, "missing"), now); missing.Chunks != 0 || missing.Bytes != 0 {
+		t.Errorf("getDiskBacklog() of a missing directory = %+v, want empty", missing)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sorts the container log records of a batch by their log timestamp before the flush, when true
+const envSortBatchByTimestamp = "AZMON_SORT_BATCH_BY_TIMESTAMP"
+
+// SortBatchByTimestamp when true, the records of a container logs batch are sent in timestamp order
+var SortBatchByTimestamp bool
+
+// initializeBatchSorting reads whether the container log batches are sorted by timestamp
+func initializeBatchSorting() {
+	SortBatchByTimestamp = strings.EqualFold(strings.TrimSpace(os.Getenv(envSortBatchByTimestamp)), "true")
+	if SortBatchByTimestamp {
+		Log("Container log batches are sorted by timestamp")
+	}
+}
+
+// timestampSorter sorts the records of a batch by the parsed timestamps, swapping the records along with the keys
+type timestampSorter struct {
+	keys []int64
+	swap func(i, j int)
+}
+
+func (s *timestampSorter) Len() int           { return len(s.keys) }
+func (s *timestampSorter) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s *timestampSorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.swap(i, j)
+}
+
+// sortByTimestamp stably sorts the n records of a batch by their RFC 3339 timestamp, and returns how many records were
+// earlier than a record before them. A record without a valid timestamp keeps the timestamp of the record before it
+func sortByTimestamp(n int, timestamp func(i int) string, swap func(i, j int)) int {
+	if n < 2 {
+		return 0
+	}
+	keys := make([]int64, n)
+	var previous, latest int64
+	outOfOrder := 0
+	for i := 0; i < n; i++ {
+		key := previous
+		if parsed, err := time.Parse(time.RFC3339Nano, timestamp(i)); err == nil {
+			key = parsed.UnixNano()
+		}
+		if i > 0 && key < latest {
+			outOfOrder++
+		}
+		if i == 0 || key > latest {
+			latest = key
+		}
+		keys[i] = key
+		previous = key
+	}
+	if outOfOrder > 0 {
+		sort.Stable(&timestampSorter{keys: keys, swap: swap})
+	}
+	return outOfOrder
+}
+
+// sortContainerLogBatch sorts the records of the batch of the route by timestamp, and counts the records out of order
+func sortContainerLogBatch(msgPackEntries []MsgPackEntry, dataItemsADX []DataItemADX, dataItemsLAv2 []DataItemLAv2, dataItemsLAv1 []DataItemLAv1) {
+	if SortBatchByTimestamp == false {
+		return
+	}
+	records := 0
+	outOfOrder := 0
+	switch {
+	case len(msgPackEntries) > 0:
+		timestampKey := "LogEntryTimeStamp"
+		if ContainerLogSchemaV2 == true {
+			timestampKey = "TimeGenerated"
+		}
+		records = len(msgPackEntries)
+		outOfOrder = sortByTimestamp(records, func(i int) string { return msgPackEntries[i].Record[timestampKey] },
+			func(i, j int) { msgPackEntries[i], msgPackEntries[j] = msgPackEntries[j], msgPackEntries[i] })
+	case len(dataItemsADX) > 0:
+		records = len(dataItemsADX)
+		outOfOrder = sortByTimestamp(records, func(i int) string { return dataItemsADX[i].TimeGenerated },
+			func(i, j int) { dataItemsADX[i], dataItemsADX[j] = dataItemsADX[j], dataItemsADX[i] })
+	case len(dataItemsLAv2) > 0:
+		records = len(dataItemsLAv2)
+		outOfOrder = sortByTimestamp(records, func(i int) string { return dataItemsLAv2[i].TimeGenerated },
+			func(i, j int) { dataItemsLAv2[i], dataItemsLAv2[j] = dataItemsLAv2[j], dataItemsLAv2[i] })
+	case len(dataItemsLAv1) > 0:
+		records = len(dataItemsLAv1)
+		outOfOrder = sortByTimestamp(records, func(i int) string { return dataItemsLAv1[i].LogEntryTimeStamp },
+			func(i, j int) { dataItemsLAv1[i], dataItemsLAv1[j] = dataItemsLAv1[j], dataItemsLAv1[i] })
+	}
+
+	ContainerLogTelemetryMutex.Lock()
+	ContainerLogsSortedRecordCount += float64(records)
+	ContainerLogsOutOfOrderRecordCount += float64(outOfOrder)
+	ContainerLogTelemetryMutex.Unlock()
+}
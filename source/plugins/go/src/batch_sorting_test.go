@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_sortByTimestamp(t *testing.T) {
+	type test_struct struct {
+		testname       string
+		timestamps     []string
+		want           []string
+		wantOutOfOrder int
+	}
+
+	tests := []test_struct{
+		{"in order",
+			[]string{"2023-11-14T22:13:20Z", "2023-11-14T22:13:21Z"},
+			[]string{"2023-11-14T22:13:20Z", "2023-11-14T22:13:21Z"}, 0},
+		{"out of order",
+			[]string{"2023-11-14T22:13:22Z", "2023-11-14T22:13:20Z", "2023-11-14T22:13:21Z"},
+			[]string{"2023-11-14T22:13:20Z", "2023-11-14T22:13:21Z", "2023-11-14T22:13:22Z"}, 2},
+		{"fractional seconds of different lengths",
+			[]string{"2023-11-14T22:13:20.12Z", "2023-11-14T22:13:20.1Z"},
+			[]string{"2023-11-14T22:13:20.1Z", "2023-11-14T22:13:20.12Z"}, 1},
+		{"invalid timestamp stays after the record before it",
+			[]string{"2023-11-14T22:13:21Z", "invalid", "2023-11-14T22:13:20Z"},
+			[]string{"2023-11-14T22:13:20Z", "2023-11-14T22:13:21Z", "invalid"}, 1},
+		{"single record", []string{"invalid"}, []string{"invalid"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			records := append([]string{}, tt.timestamps...)
+			outOfOrder := sortByTimestamp(len(records), func(i int) string { return records[i] },
+				func(i, j int) { records[i], records[j] = records[j], records[i] })
+			if !reflect.DeepEqual(records, tt.want) || outOfOrder != tt.wantOutOfOrder {
+				t.Errorf("sortByTimestamp() = %v, %d out of order, want %v, %d", records, outOfOrder, tt.want, tt.wantOutOfOrder)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// percentage (1-100) of the containers whose log records are sent thru the v2 route while the others are sent to ODS,
+// to ramp the v2 route gradually on the v1 route
+const envContainerLogsV2Percent = "AZMON_CONTAINER_LOGS_V2_PERCENT"
+
+// ContainerLogsV2Percent the percentage of the containers sent thru the v2 route, 0 when the canary is disabled
+var ContainerLogsV2Percent uint32
+
+// CanarySink the sink of the v2 route of the canary containers, nil when the canary is disabled
+var CanarySink Sink
+
+// initializeCanaryRoute creates the sink of the v2 route of the canary, after the v1 route is set up
+func initializeCanaryRoute() {
+	ContainerLogsV2Percent = 0
+	CanarySink = nil
+	value := strings.TrimSpace(os.Getenv(envContainerLogsV2Percent))
+	if value == "" {
+		return
+	}
+	percent, err := strconv.Atoi(value)
+	if err != nil || percent < 0 || percent > 100 {
+		Log("Error::canary::Invalid value %s for %s, the container logs are sent thru the %s route", value, envContainerLogsV2Percent, getContainerLogsRouteName())
+		return
+	}
+	if percent == 0 {
+		return
+	}
+	if getContainerLogsRouteName() != ContainerLogsV1Route || len(ContainerLogSinkDeclarations) > 0 {
+		Log("%s is only supported on the %s route, the container logs are sent thru the %s route", envContainerLogsV2Percent, ContainerLogsV1Route, getContainerLogsRouteName())
+		return
+	}
+	if IsWindows == true && !initializeWindowsMdsdRoute() {
+		return
+	}
+	CreateMDSDClient(ContainerLogV2, ContainerType)
+	ContainerLogsV2Percent = uint32(percent)
+	CanarySink = instrumentSink(sinkTypeMdsd, newMdsdSink(ContainerLogsV2Route))
+	Log("Sending the container logs of %d%% of the containers thru the %s route, and the others thru the %s route", percent, ContainerLogsV2Route, ContainerLogsV1Route)
+}
+
+// routesToCanary whether the log records of the container are sent thru the v2 route. The containers are picked by
+// the hash of their id, so all the records of a container take the same route
+func routesToCanary(containerID string) bool {
+	if ContainerLogsV2Percent == 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(containerID))
+	return h.Sum32()%100 < ContainerLogsV2Percent
+}
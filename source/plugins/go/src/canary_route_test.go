@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func Test_routesToCanary(t *testing.T) {
+	type test_struct struct {
+		testName string
+		percent  uint32
+		wantMin  int
+		wantMax  int
+	}
+
+	tests := []test_struct{
+		{"disabled", 0, 0, 0},
+		{"10 percent", 10, 50, 150},
+		{"50 percent", 50, 400, 600},
+		{"all", 100, 1000, 1000},
+	}
+
+	defer func() { ContainerLogsV2Percent = 0 }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ContainerLogsV2Percent = tt.percent
+			canaries := 0
+			for i := 0; i < 1000; i++ {
+				containerID := "container" + strconv.Itoa(i)
+				canary := routesToCanary(containerID)
+				if canary != routesToCanary(containerID) {
+					t.Fatalf("routesToCanary(%s) is not deterministic", containerID)
+				}
+				if canary {
+					canaries++
+				}
+			}
+			if canaries < tt.wantMin || canaries > tt.wantMax {
+				t.Errorf("routesToCanary() picked %d of 1000 containers, want between %d and %d", canaries, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
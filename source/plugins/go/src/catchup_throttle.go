@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variables for smoothing the container log send rate while replaying a backlog after an outage
+const (
+	envCatchUpMaxRecordsPerSec = "AZMON_CATCHUP_MAX_RECORDS_PER_SEC"
+	envCatchUpMaxBytesPerSec   = "AZMON_CATCHUP_MAX_BYTES_PER_SEC"
+)
+
+// catchUpRateLimiter is a token bucket which holds at most one second worth of tokens. Steady state traffic below the
+// rate is never held back, only a burst such as the replay of fluent-bit retries or the disk buffer is spread out
+type catchUpRateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+var (
+	// CatchUpRecordsLimiter limits the records sent per second, nil when not configured
+	CatchUpRecordsLimiter *catchUpRateLimiter
+	// CatchUpBytesLimiter limits the log bytes sent per second, nil when not configured
+	CatchUpBytesLimiter *catchUpRateLimiter
+)
+
+func newCatchUpRateLimiter(ratePerSec float64) *catchUpRateLimiter {
+	return &catchUpRateLimiter{rate: ratePerSec, tokens: ratePerSec, last: time.Now()}
+}
+
+// inDebt refills the bucket and returns whether it is in debt. Batches larger than the tokens left are allowed by
+// going into debt, which holds back the following batches until it is paid back
+func (l *catchUpRateLimiter) inDebt(now time.Time) bool {
+	return l.debt(now) > 0
+}
+
+// debt refills the bucket and returns how long it takes to pay back its debt, zero when it is not in debt or the
+// limiter is not configured
+func (l *catchUpRateLimiter) debt(now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+		l.last = now
+	}
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// take takes the tokens of a batch sent
+func (l *catchUpRateLimiter) take(n float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens -= n
+}
+
+// initializeCatchUpThrottling reads the configured send rate limits, throttling is off when neither is set
+func initializeCatchUpThrottling() {
+	CatchUpRecordsLimiter = nil
+	CatchUpBytesLimiter = nil
+	if rate := parseCatchUpRate(envCatchUpMaxRecordsPerSec); rate > 0 {
+		CatchUpRecordsLimiter = newCatchUpRateLimiter(rate)
+		Log("Limiting the container log send rate to %.0f records/sec", rate)
+	}
+	if rate := parseCatchUpRate(envCatchUpMaxBytesPerSec); rate > 0 {
+		CatchUpBytesLimiter = newCatchUpRateLimiter(rate)
+		Log("Limiting the container log send rate to %.0f bytes/sec", rate)
+	}
+}
+
+func parseCatchUpRate(envName string) float64 {
+	value := strings.TrimSpace(os.Getenv(envName))
+	if value == "" {
+		return 0
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate <= 0 {
+		Log("Invalid value %s for %s, send rate will not be limited", value, envName)
+		return 0
+	}
+	return rate
+}
+
+// throttleCatchUp waits within the flush until the limiters have paid back their debt, and returns whether the batch
+// can be sent within the configured send rate. The wait is capped at half of the time left before the deadline of the
+// flush, leaving the other half to the send, and the flush is retried by fluent-bit when the debt takes longer
+func throttleCatchUp(ctx context.Context, numRecords int, numBytes int) bool {
+	if (CatchUpRecordsLimiter == nil && CatchUpBytesLimiter == nil) || numRecords == 0 {
+		return true
+	}
+	now := time.Now()
+	wait := CatchUpRecordsLimiter.debt(now)
+	if bytesWait := CatchUpBytesLimiter.debt(now); bytesWait > wait {
+		wait = bytesWait
+	}
+	if wait > 0 {
+		ContainerLogTelemetryMutex.Lock()
+		CatchUpThrottledFlushCount += 1
+		ContainerLogTelemetryMutex.Unlock()
+		if deadline, ok := ctx.Deadline(); ok && wait > deadline.Sub(now)/2 {
+			Log("Info::Retrying the flush of %d records (%d bytes) later to stay within the configured send rate", numRecords, numBytes)
+			return false
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if CatchUpRecordsLimiter != nil {
+		CatchUpRecordsLimiter.take(float64(numRecords))
+	}
+	if CatchUpBytesLimiter != nil {
+		CatchUpBytesLimiter.take(float64(numBytes))
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_catchUpRateLimiterInDebt(t *testing.T) {
+	type test_struct struct {
+		testname string
+		batches  []float64
+		elapsed  time.Duration
+		after    time.Duration
+		output   bool
+	}
+
+	tests := []test_struct{
+		{"within rate", []float64{50, 50}, 0, 0, false},
+		{"burst over rate", []float64{100, 50}, 0, 0, true},
+		{"batch larger than bucket", []float64{300}, 0, time.Second, true},
+		{"debt paid back", []float64{300}, 0, 2 * time.Second, false},
+		{"tokens refilled", []float64{100, 100}, time.Second, 0, false},
+		{"refill capped at one second", []float64{100, 150}, 10 * time.Second, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			now := time.Now()
+			limiter := &catchUpRateLimiter{rate: 100, tokens: 100, last: now}
+			for i, batch := range tt.batches {
+				if i > 0 {
+					now = now.Add(tt.elapsed)
+				}
+				limiter.inDebt(now)
+				limiter.take(batch)
+			}
+			if got := limiter.inDebt(now.Add(tt.after)); got != tt.output {
+				t.Errorf("inDebt() after %v = %v, want %v", tt.batches, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_throttleCatchUp(t *testing.T) {
+	defer func() { CatchUpRecordsLimiter, CatchUpBytesLimiter = nil, nil }()
+	CatchUpRecordsLimiter, CatchUpBytesLimiter = newCatchUpRateLimiter(100), newCatchUpRateLimiter(1000)
+	CatchUpThrottledFlushCount = 0
+
+	if !throttleCatchUp(context.Background(), 50, 1050) {
+		t.Errorf("throttleCatchUp() = false for the first batch, want true")
+	}
+	// the bytes limiter is in debt for 50ms, which is waited within the flush
+	start := time.Now()
+	if !throttleCatchUp(context.Background(), 10, 10) {
+		t.Errorf("throttleCatchUp() = false after waiting for the debt, want true")
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("throttleCatchUp() returned after %v, want after the 50ms debt", elapsed)
+	}
+	// the debt takes longer than half of the time left before the deadline of the flush
+	CatchUpBytesLimiter.take(2000)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if throttleCatchUp(ctx, 10, 10) {
+		t.Errorf("throttleCatchUp() = true with a debt past the deadline, want false")
+	}
+	if CatchUpThrottledFlushCount != 2 {
+		t.Errorf("throttleCatchUp() counted %v throttled flushes, want 2", CatchUpThrottledFlushCount)
+	}
+}
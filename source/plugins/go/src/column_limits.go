@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// env variable of the max length of the columns as <column>=<max bytes>[:<truncation>] separated by commas, e.g.
+// LogEntry=16384,Tags=4096:tail. The truncation keeps the head (default) or the tail of the value, or drops it
+const envColumnLengthLimits = "AZMON_COLUMN_LENGTH_LIMITS"
+
+// env variable of the marker replacing the truncated part of the values, within the max length
+const envColumnTruncationMarker = "AZMON_COLUMN_TRUNCATION_MARKER"
+
+// the columns with a configurable max length. LogEntry also limits the LogMessage column of ContainerLogV2
+const (
+	columnLogEntry = "LogEntry"
+	columnTags     = "Tags"
+	columnMessage  = "Message"
+)
+
+const (
+	columnTruncationHead = "head"
+	columnTruncationTail = "tail"
+	columnTruncationDrop = "drop"
+)
+
+// columnLengthLimit the max length of a column and how its values are truncated
+type columnLengthLimit struct {
+	maxBytes   int
+	truncation string
+}
+
+var (
+	// ColumnLengthLimits the max length of the columns, empty when the columns are not limited
+	ColumnLengthLimits map[string]columnLengthLimit
+	// ColumnTruncationMarker replaces the truncated part of the values
+	ColumnTruncationMarker string
+	// ColumnTruncationCount the values truncated per column (uses ContainerLogTelemetryTicker)
+	ColumnTruncationCount = make(map[string]float64)
+)
+
+// initializeColumnLengthLimits reads the max length of the columns
+func initializeColumnLengthLimits() {
+	ColumnLengthLimits = nil
+	ColumnTruncationMarker = os.Getenv(envColumnTruncationMarker)
+	value := strings.TrimSpace(os.Getenv(envColumnLengthLimits))
+	if value == "" {
+		return
+	}
+	limits, err := parseColumnLengthLimits(value)
+	if err != nil {
+		Log("Error::columnlimits::Invalid value %s for %s, the columns are not limited: %s", value, envColumnLengthLimits, err.Error())
+		return
+	}
+	ColumnLengthLimits = limits
+	Log("Column length limits = %s", value)
+}
+
+func parseColumnLengthLimits(value string) (map[string]columnLengthLimit, error) {
+	limits := make(map[string]columnLengthLimit)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("limit %s is not <column>=<max bytes>[:<truncation>]", item)
+		}
+		column := ""
+		for _, known := range []string{columnLogEntry, columnTags, columnMessage} {
+			if strings.EqualFold(strings.TrimSpace(kv[0]), known) {
+				column = known
+			}
+		}
+		if column == "" {
+			return nil, fmt.Errorf("unknown column %s", kv[0])
+		}
+		limit := columnLengthLimit{truncation: columnTruncationHead}
+		maxAndTruncation := strings.SplitN(strings.TrimSpace(kv[1]), ":", 2)
+		maxBytes, err := strconv.Atoi(maxAndTruncation[0])
+		if err != nil || maxBytes <= 0 {
+			return nil, fmt.Errorf("invalid max length %s of column %s", maxAndTruncation[0], column)
+		}
+		limit.maxBytes = maxBytes
+		if len(maxAndTruncation) == 2 {
+			limit.truncation = strings.ToLower(strings.TrimSpace(maxAndTruncation[1]))
+			switch limit.truncation {
+			case columnTruncationHead, columnTruncationTail, columnTruncationDrop:
+			default:
+				return nil, fmt.Errorf("unknown truncation %s of column %s", maxAndTruncation[1], column)
+			}
+		}
+		limits[column] = limit
+	}
+	return limits, nil
+}
+
+// limitColumnLength truncates the value of the column to its max length, and counts the truncation
+func limitColumnLength(column string, value string) string {
+	limit, ok := ColumnLengthLimits[column]
+	if !ok || len(value) <= limit.maxBytes {
+		return value
+	}
+	ContainerLogTelemetryMutex.Lock()
+	ColumnTruncationCount[column] += 1
+	ContainerLogTelemetryMutex.Unlock()
+	return truncateColumnValue(value, limit, ColumnTruncationMarker)
+}
+
+func truncateColumnValue(value string, limit columnLengthLimit, marker string) string {
+	if limit.truncation == columnTruncationDrop {
+		return truncateUTF8(marker, limit.maxBytes)
+	}
+	keep := limit.maxBytes - len(marker)
+	if keep <= 0 {
+		return truncateUTF8(marker, limit.maxBytes)
+	}
+	if limit.truncation == columnTruncationTail {
+		tail := value[len(value)-keep:]
+		// skip the continuation bytes of a character cut at the start
+		for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+			tail = tail[1:]
+		}
+		return marker + tail
+	}
+	return truncateUTF8(value, keep) + marker
+}
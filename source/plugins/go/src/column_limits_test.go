@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseColumnLengthLimits(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		output   map[string]columnLengthLimit
+		wantErr  bool
+	}
+
+	tests := []test_struct{
+		{"default truncation", "logentry=100", map[string]columnLengthLimit{columnLogEntry: {100, columnTruncationHead}}, false},
+		{"all columns", "LogEntry=100:tail, Tags=50:drop,Message=20:head", map[string]columnLengthLimit{columnLogEntry: {100, columnTruncationTail}, columnTags: {50, columnTruncationDrop}, columnMessage: {20, columnTruncationHead}}, false},
+		{"unknown column", "Image=10", nil, true},
+		{"invalid length", "Tags=0", nil, true},
+		{"unknown truncation", "Tags=10:middle", nil, true},
+		{"no length", "Tags", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := parseColumnLengthLimits(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseColumnLengthLimits(%s) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.output) {
+				t.Errorf("parseColumnLengthLimits(%s) = %v, want %v", tt.value, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_truncateColumnValue(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		limit    columnLengthLimit
+		marker   string
+		output   string
+	}
+
+	tests := []test_struct{
+		{"head", "0123456789", columnLengthLimit{4, columnTruncationHead}, "", "0123"},
+		{"head with marker", "0123456789", columnLengthLimit{6, columnTruncationHead}, "...", "012..."},
+		{"tail", "0123456789", columnLengthLimit{4, columnTruncationTail}, "", "6789"},
+		{"tail with marker", "0123456789", columnLengthLimit{6, columnTruncationTail}, "...", "...789"},
+		{"tail cutting a character", "aé€", columnLengthLimit{4, columnTruncationTail}, "", "€"},
+		{"drop", "0123456789", columnLengthLimit{4, columnTruncationDrop}, "", ""},
+		{"marker longer than the limit", "0123456789", columnLengthLimit{2, columnTruncationHead}, "...", ".."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := truncateColumnValue(tt.value, tt.limit, tt.marker); got != tt.output {
+				t.Errorf("truncateColumnValue() = %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
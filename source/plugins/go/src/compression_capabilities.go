@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// content encodings of the payloads, in the order of preference
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+	encodingNone = "none"
+)
+
+var encodingPreference = []string{encodingZstd, encodingGzip, encodingNone}
+
+// encodings the agent can produce. zstd is recorded when a destination advertises it, but is not selected since the
+// agent has no zstd encoder
+var agentEncodings = map[string]bool{encodingGzip: true, encodingNone: true}
+
+// routes with a configurable compression. The accepted encodings are read from AZMON_<ROUTE>_ACCEPTED_ENCODINGS or
+// <route>_accepted_encodings, e.g. "gzip,none". AZMON_<ROUTE>_COMPRESSION or <route>_compression, gzip or none, sets the
+// compression of the route and takes precedence over the accepted encodings
+var compressionRoutes = []string{requestRouteODS, ContainerLogsV2Route}
+
+const compressionProbeTimeout = 10 * time.Second
+
+// compressionCapability the encodings accepted by the destination of a route and where that came from
+type compressionCapability struct {
+	accepted map[string]bool
+	// config, probe or default
+	source string
+}
+
+var (
+	// RouteCompressionCapabilities the accepted encodings of the destination of each route
+	RouteCompressionCapabilities = make(map[string]*compressionCapability)
+	// RouteCompressionMutex read and write mutex access to RouteCompressionCapabilities
+	RouteCompressionMutex = &sync.RWMutex{}
+)
+
+// initializeCompressionCapabilities reads the encodings configured for the routes. Without configuration, the
+// destinations are assumed to accept uncompressed payloads only, until a probe says otherwise
+func initializeCompressionCapabilities() {
+	RouteCompressionMutex.Lock()
+	defer RouteCompressionMutex.Unlock()
+	for _, route := range compressionRoutes {
+		capability := &compressionCapability{accepted: map[string]bool{encodingNone: true}, source: "default"}
+		if value := routeSetting(route, "accepted_encodings"); value != "" {
+			accepted, err := parseAcceptedEncodings(value)
+			if err != nil {
+				Log("Error::compression::Ignoring the accepted encodings of the %s route: %s", route, err.Error())
+			} else {
+				capability = &compressionCapability{accepted: accepted, source: "config"}
+			}
+		}
+		if value := routeSetting(route, "compression"); value != "" {
+			accepted, err := parseRouteCompression(value)
+			if err != nil {
+				Log("Error::compression::Ignoring the compression of the %s route: %s", route, err.Error())
+			} else {
+				capability = &compressionCapability{accepted: accepted, source: "config"}
+			}
+		}
+		RouteCompressionCapabilities[route] = capability
+		Log("Accepted encodings of the %s route from %s: %v, using %s", route, capability.source, acceptedEncodingList(capability.accepted), bestEncoding(capability.accepted))
+	}
+}
+
+// parseAcceptedEncodings parses a comma separated list of encodings, also in the Accept-Encoding header format
+func parseAcceptedEncodings(value string) (map[string]bool, error) {
+	accepted := make(map[string]bool)
+	for _, encoding := range strings.Split(value, ",") {
+		// drop the quality values, e.g. gzip;q=0.8
+		encoding = strings.ToLower(strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0]))
+		switch encoding {
+		case "":
+			continue
+		case "identity":
+			encoding = encodingNone
+		case encodingZstd, encodingGzip, encodingNone:
+		default:
+			return nil, fmt.Errorf("unknown encoding %s", encoding)
+		}
+		accepted[encoding] = true
+	}
+	if len(accepted) == 0 {
+		return nil, fmt.Errorf("no encoding in %q", value)
+	}
+	return accepted, nil
+}
+
+// parseRouteCompression returns the accepted encodings of the compression of a route. The payloads fall back to
+// uncompressed when the destination rejects gzip
+func parseRouteCompression(value string) (map[string]bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case encodingGzip:
+		return map[string]bool{encodingGzip: true, encodingNone: true}, nil
+	case encodingNone, "off", "false":
+		return map[string]bool{encodingNone: true}, nil
+	}
+	return nil, fmt.Errorf("unknown compression %s", value)
+}
+
+// bestEncoding returns the preferred encoding accepted by the destination that the agent can produce
+func bestEncoding(accepted map[string]bool) string {
+	for _, encoding := range encodingPreference {
+		if accepted[encoding] && agentEncodings[encoding] {
+			return encoding
+		}
+	}
+	return encodingNone
+}
+
+func acceptedEncodingList(accepted map[string]bool) []string {
+	encodings := []string{}
+	for _, encoding := range encodingPreference {
+		if accepted[encoding] {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+// selectRouteEncoding returns the encoding of the payloads of the route
+func selectRouteEncoding(route string) string {
+	RouteCompressionMutex.RLock()
+	defer RouteCompressionMutex.RUnlock()
+	capability, ok := RouteCompressionCapabilities[route]
+	if !ok {
+		return encodingNone
+	}
+	return bestEncoding(capability.accepted)
+}
+
+// markRouteEncodingUnsupported records that the destination rejected the encoding, so the route falls back to the next
+// encoding it accepts
+func markRouteEncodingUnsupported(route string, encoding string) {
+	RouteCompressionMutex.Lock()
+	defer RouteCompressionMutex.Unlock()
+	capability, ok := RouteCompressionCapabilities[route]
+	if !ok || encoding == encodingNone || !capability.accepted[encoding] {
+		return
+	}
+	delete(capability.accepted, encoding)
+	capability.accepted[encodingNone] = true
+	Log("Warning::compression::The destination of the %s route rejected %s, using %s", route, encoding, bestEncoding(capability.accepted))
+}
+
+// probeRouteCompression asks the destination of the route which encodings it accepts (RFC 7694 Accept-Encoding in the
+// response). The configured encodings take precedence over the probe
+func probeRouteCompression(route string, endpoint string) {
+	RouteCompressionMutex.RLock()
+	capability, ok := RouteCompressionCapabilities[route]
+	configured := ok && capability.source == "config"
+	RouteCompressionMutex.RUnlock()
+	if configured || endpoint == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ParentContext, compressionProbeTimeout)
+	defer cancel()
+	// the probe is not authenticated, the encodings are advertised on the rejected requests too
+	req, err := http.NewRequestWithContext(ctx, http.MethodOptions, endpoint, nil)
+	if err != nil {
+		Log("Error::compression::Unable to probe the %s route: %s", route, err.Error())
+		return
+	}
+	setRouteRequestHeaders(req, route)
+	logRouteRequest(route, req)
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		Log("Error::compression::Unable to probe the %s route: %s", route, err.Error())
+		return
+	}
+	resp.Body.Close()
+	acceptEncoding := resp.Header.Get("Accept-Encoding")
+	if acceptEncoding == "" {
+		Log("The destination of the %s route does not advertise its accepted encodings", route)
+		return
+	}
+	accepted, err := parseAcceptedEncodings(acceptEncoding)
+	if err != nil {
+		Log("Error::compression::Unable to parse the accepted encodings %q of the %s route: %s", acceptEncoding, route, err.Error())
+		return
+	}
+	RouteCompressionMutex.Lock()
+	RouteCompressionCapabilities[route] = &compressionCapability{accepted: accepted, source: "probe"}
+	RouteCompressionMutex.Unlock()
+	Log("Accepted encodings of the %s route from probe: %v, using %s", route, acceptedEncodingList(accepted), bestEncoding(accepted))
+}
+
+// compressPayload encodes the payload with the encoding
+func compressPayload(encoding string, payload []byte) ([]byte, error) {
+	switch encoding {
+	case encodingGzip:
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case encodingNone, "":
+		return payload, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %s", encoding)
+}
+
+// trackPayloadCompression counts the bytes of the compressed ODS payloads before and after compression
+func trackPayloadCompression(route string, encoding string, rawBytes int, compressedBytes int) {
+	if route != requestRouteODS || encoding == encodingNone {
+		return
+	}
+	ContainerLogTelemetryMutex.Lock()
+	defer ContainerLogTelemetryMutex.Unlock()
+	ODSRawPayloadBytes += float64(rawBytes)
+	ODSCompressedPayloadBytes += float64(compressedBytes)
+}
+
+// doRouteRequest sends the request of the route, and falls back to the next accepted encoding when the destination
+// rejects the encoding of the payload
+func doRouteRequest(route string, req *http.Request) (*http.Response, error) {
+	return doRouteRequestWithClient(&HTTPClient, route, req)
+}
+
+// doRouteRequestWithClient sends the request of the route with the client, logging the redacted request at the debug
+// level
+func doRouteRequestWithClient(client *http.Client, route string, req *http.Request) (*http.Response, error) {
+	logRouteRequest(route, req)
+	resp, err := client.Do(req)
+	if err == nil && resp != nil && resp.StatusCode == http.StatusUnsupportedMediaType {
+		if encoding := req.Header.Get("Content-Encoding"); encoding != "" {
+			markRouteEncodingUnsupported(route, encoding)
+		}
+	}
+	return resp, err
+}
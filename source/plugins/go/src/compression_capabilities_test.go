@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_parseAcceptedEncodings(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		best     string
+		isError  bool
+	}
+
+	tests := []test_struct{
+		{"gzip and none", "gzip,none", encodingGzip, false},
+		{"accept-encoding header with quality values", "zstd;q=1.0, gzip;q=0.8, identity", encodingGzip, false},
+		{"zstd only", "zstd", encodingNone, false},
+		{"identity only", "identity", encodingNone, false},
+		{"unknown encoding", "br", "", true},
+		{"empty", " , ", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			accepted, err := parseAcceptedEncodings(tt.value)
+			if (err != nil) != tt.isError {
+				t.Fatalf("parseAcceptedEncodings(%q) error = %v, want error %v", tt.value, err, tt.isError)
+			}
+			if err == nil && bestEncoding(accepted) != tt.best {
+				t.Errorf("bestEncoding(%q) = %s, want %s", tt.value, bestEncoding(accepted), tt.best)
+			}
+		})
+	}
+}
+
+func Test_parseRouteCompression(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		best     string
+		isError  bool
+	}
+
+	tests := []test_struct{
+		{"gzip", "gzip", encodingGzip, false},
+		{"gzip upper case", " GZIP ", encodingGzip, false},
+		{"none", "none", encodingNone, false},
+		{"off", "off", encodingNone, false},
+		{"unknown compression", "zstd", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			accepted, err := parseRouteCompression(tt.value)
+			if (err != nil) != tt.isError {
+				t.Fatalf("parseRouteCompression(%q) error = %v, want error %v", tt.value, err, tt.isError)
+			}
+			if err == nil && bestEncoding(accepted) != tt.best {
+				t.Errorf("bestEncoding(%q) = %s, want %s", tt.value, bestEncoding(accepted), tt.best)
+			}
+			if err == nil && !accepted[encodingNone] {
+				t.Errorf("parseRouteCompression(%q) does not fall back to uncompressed payloads", tt.value)
+			}
+		})
+	}
+}
+
+func Test_markRouteEncodingUnsupported(t *testing.T) {
+	RouteCompressionCapabilities["test"] = &compressionCapability{accepted: map[string]bool{encodingGzip: true}, source: "probe"}
+	defer delete(RouteCompressionCapabilities, "test")
+
+	if got := selectRouteEncoding("test"); got != encodingGzip {
+		t.Fatalf("selectRouteEncoding() = %s, want %s", got, encodingGzip)
+	}
+	markRouteEncodingUnsupported("test", encodingGzip)
+	if got := selectRouteEncoding("test"); got != encodingNone {
+		t.Errorf("selectRouteEncoding() after the destination rejected gzip = %s, want %s", got, encodingNone)
+	}
+}
+
+func Test_compressPayload(t *testing.T) {
+	payload := []byte(`{"DataType":"CONTAINER_LOG_BLOB"}`)
+	compressed, err := compressPayload(encodingGzip, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, payload) {
+		t.Errorf("compressPayload() round trip = %s, want %s", decompressed, payload)
+	}
+}
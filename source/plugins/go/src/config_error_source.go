@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// the marker of the location of the setting causing a config error, followed by the json of the location. The config
+// processing scripts add it to the error lines, see ConfigParseErrorLogger.rb
+const configErrorSourceMarker = " config::source::"
+
+// configErrorSource the configmap key, the section and the value of the setting causing a config error
+type configErrorSource struct {
+	Key     string `json:"key"`
+	Section string `json:"section"`
+	Value   string `json:"value"`
+}
+
+// parseConfigErrorSource splits the config error line, as logged by the config processing scripts without its outer
+// quotes, into the error message and the location of the setting causing it
+func parseConfigErrorSource(logRecordString string) (string, configErrorSource) {
+	var source configErrorSource
+	index := strings.LastIndex(logRecordString, configErrorSourceMarker)
+	if index < 0 {
+		return logRecordString, source
+	}
+	// the line is json encoded, so the quotes of the json of the location are escaped
+	var sourceJSON string
+	if err := json.Unmarshal([]byte(`"`+logRecordString[index+len(configErrorSourceMarker):]+`"`), &sourceJSON); err != nil {
+		return logRecordString, source
+	}
+	if err := json.Unmarshal([]byte(sourceJSON), &source); err != nil {
+		return logRecordString, configErrorSource{}
+	}
+	return logRecordString[:index], source
+}
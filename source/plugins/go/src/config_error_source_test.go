@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_parseConfigErrorSource(t *testing.T) {
+	type test_struct struct {
+		testname string
+		line     string
+		message  string
+		source   configErrorSource
+	}
+
+	tests := []test_struct{
+		{"without source", `config::error::Exception while parsing config map`, `config::error::Exception while parsing config map`, configErrorSource{}},
+		{"with source", `config::error::Exception while reading config map settings for stdout log collection - undefined method, using defaults config::source::{\"key\":\"log-data-collection-settings\",\"section\":\"log_collection_settings.stdout\",\"value\":\"{:enabled=>\\\"yes\\\"}\"}`,
+			`config::error::Exception while reading config map settings for stdout log collection - undefined method, using defaults`,
+			configErrorSource{Key: "log-data-collection-settings", Section: "log_collection_settings.stdout", Value: `{:enabled=>"yes"}`}},
+		{"key only", `config::error::Exception while parsing config map config::source::{\"key\":\"log-data-collection-settings\"}`, `config::error::Exception while parsing config map`, configErrorSource{Key: "log-data-collection-settings"}},
+		{"invalid source", `config::error::Exception config::source::{\"key\"`, `config::error::Exception config::source::{\"key\"`, configErrorSource{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			message, source := parseConfigErrorSource(tt.line)
+			if message != tt.message || source != tt.source {
+				t.Errorf("parseConfigErrorSource() = %q, %+v, want %q, %+v", message, source, tt.message, tt.source)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContainerExitEventCategory is the KubeMonAgentEvent category for abnormal container terminations
+const ContainerExitEventCategory = "container.azm.ms/containerexit"
+
+// OOMKilledReason is the termination reason reported by the kubelet for containers killed due to memory limits
+const OOMKilledReason = "OOMKilled"
+
+var (
+	// ContainerExitEvent hash of abnormal container terminations since the last KubeMonAgentEvents flush
+	ContainerExitEvent map[string]KubeMonAgentEventTags
+	// seenTerminatedContainers tracks the terminated container ids already observed by the enrichment refresh
+	seenTerminatedContainers map[string]bool
+	// containerExitTrackingSeeded is false until the first enrichment refresh has populated seenTerminatedContainers
+	containerExitTrackingSeeded bool
+	// containerExitLogMarkerEnabled when true, a marker record is also injected in the container log stream
+	containerExitLogMarkerEnabled bool
+	// pendingContainerExitLogMarkers log marker records waiting for the next container log flush
+	pendingContainerExitLogMarkers []map[interface{}]interface{}
+	// ContainerExitLogMarkerMutex read and write mutex access to pendingContainerExitLogMarkers
+	ContainerExitLogMarkerMutex = &sync.Mutex{}
+)
+
+func initializeContainerExitTracking() {
+	ContainerExitEvent = make(map[string]KubeMonAgentEventTags)
+	seenTerminatedContainers = make(map[string]bool)
+	containerExitTrackingSeeded = false
+	if strings.Compare(strings.ToLower(strings.TrimSpace(os.Getenv("AZMON_CONTAINER_EXIT_LOG_MARKER"))), "true") == 0 {
+		containerExitLogMarkerEnabled = true
+		Log("Container exit log markers enabled")
+	}
+}
+
+// trackContainerTerminations is called by the enrichment refresh for every pod on the node and records
+// non-zero exit codes and OOM kills that were not seen in a previous refresh
+func trackContainerTerminations(pod corev1.Pod, statuses []corev1.ContainerStatus, current map[string]bool) {
+	for _, status := range statuses {
+		for _, terminated := range []*corev1.ContainerStateTerminated{status.State.Terminated, status.LastTerminationState.Terminated} {
+			if terminated == nil || terminated.ContainerID == "" {
+				continue
+			}
+			containerID, _ := runtimeContainerID(terminated.ContainerID)
+			current[containerID] = true
+			if seenTerminatedContainers[containerID] || !containerExitTrackingSeeded {
+				continue
+			}
+			if terminated.ExitCode == 0 && terminated.Reason != OOMKilledReason {
+				continue
+			}
+			recordContainerExit(pod, status.Name, containerID, terminated)
+		}
+	}
+}
+
+// completeContainerTerminationsRefresh swaps in the terminated container ids seen during the current refresh
+func completeContainerTerminationsRefresh(current map[string]bool) {
+	seenTerminatedContainers = current
+	containerExitTrackingSeeded = true
+}
+
+func recordContainerExit(pod corev1.Pod, containerName string, containerID string, terminated *corev1.ContainerStateTerminated) {
+	finishedAt := terminated.FinishedAt.Time.UTC().Format(time.RFC3339)
+	if terminated.FinishedAt.IsZero() {
+		finishedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	reason := terminated.Reason
+	if reason == "" {
+		reason = "Error"
+	}
+	message := fmt.Sprintf("Container %s in pod %s/%s terminated with exit code %d (%s)", containerName, pod.Namespace, pod.Name, terminated.ExitCode, reason)
+	Log("Info::containerexit::%s containerID: %s", message, containerID)
+
+	EventHashUpdateMutex.Lock()
+	if val, ok := ContainerExitEvent[message]; ok {
+		ContainerExitEvent[message] = KubeMonAgentEventTags{
+			PodName:         pod.Name,
+			ContainerId:     containerID,
+			FirstOccurrence: val.FirstOccurrence,
+			LastOccurrence:  finishedAt,
+			Count:           val.Count + 1,
+		}
+	} else {
+		ContainerExitEvent[message] = KubeMonAgentEventTags{
+			PodName:         pod.Name,
+			ContainerId:     containerID,
+			FirstOccurrence: finishedAt,
+			LastOccurrence:  finishedAt,
+			Count:           1,
+		}
+	}
+	EventHashUpdateMutex.Unlock()
+
+	if containerExitLogMarkerEnabled {
+		// the file path is built the same way as the kubelet log file name, so the marker goes through the same parsing and filtering
+		marker := map[interface{}]interface{}{
+			"filepath": []byte(fmt.Sprintf("/var/log/containers/%s_%s_%s-%s.log", pod.Name, pod.Namespace, containerName, containerID)),
+			"stream":   []byte("stderr"),
+			"time":     []byte(finishedAt),
+			"log":      []byte(fmt.Sprintf("[azmon] %s", message)),
+		}
+		ContainerExitLogMarkerMutex.Lock()
+		pendingContainerExitLogMarkers = append(pendingContainerExitLogMarkers, marker)
+		ContainerExitLogMarkerMutex.Unlock()
+	}
+}
+
+// drainContainerExitLogMarkers returns and clears the log marker records waiting to be flushed
+func drainContainerExitLogMarkers() []map[interface{}]interface{} {
+	ContainerExitLogMarkerMutex.Lock()
+	defer ContainerExitLogMarkerMutex.Unlock()
+	markers := pendingContainerExitLogMarkers
+	pendingContainerExitLogMarkers = nil
+	return markers
+}
+
+// prependContainerExitLogMarkers returns the marker records followed by the records of the flush
+func prependContainerExitLogMarkers(markers []map[interface{}]interface{}, records []map[interface{}]interface{}) []map[interface{}]interface{} {
+	if len(markers) == 0 {
+		return records
+	}
+	flushed := make([]map[interface{}]interface{}, 0, len(markers)+len(records))
+	flushed = append(flushed, markers...)
+	return append(flushed, records...)
+}
+
+// requeueContainerExitLogMarkers puts the log marker records of a retried flush back, ahead of the markers recorded
+// since they were drained
+func requeueContainerExitLogMarkers(markers []map[interface{}]interface{}) {
+	if len(markers) == 0 {
+		return
+	}
+	ContainerExitLogMarkerMutex.Lock()
+	defer ContainerExitLogMarkerMutex.Unlock()
+	pendingContainerExitLogMarkers = prependContainerExitLogMarkers(markers, pendingContainerExitLogMarkers)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fluent/fluent-bit-go/output"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func terminatedStatus(name string, containerID string, exitCode int32, reason string) corev1.ContainerStatus {
+	return corev1.ContainerStatus{
+		Name: name,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			ContainerID: "containerd://" + containerID,
+			ExitCode:    exitCode,
+			Reason:      reason,
+			FinishedAt:  metav1.Unix(1700000000, 0),
+		}},
+	}
+}
+
+func Test_trackContainerTerminations(t *testing.T) {
+	type test_struct struct {
+		testname   string
+		seeded     bool
+		seen       map[string]bool
+		status     corev1.ContainerStatus
+		wantEvents int
+	}
+
+	tests := []test_struct{
+		{"not seeded", false, map[string]bool{}, terminatedStatus("app", "c1", 1, "Error"), 0},
+		{"non-zero exit code", true, map[string]bool{}, terminatedStatus("app", "c1", 1, "Error"), 1},
+		{"oom killed", true, map[string]bool{}, terminatedStatus("app", "c1", 137, OOMKilledReason), 1},
+		{"completed", true, map[string]bool{}, terminatedStatus("app", "c1", 0, "Completed"), 0},
+		{"seen in the previous refresh", true, map[string]bool{"c1": true}, terminatedStatus("app", "c1", 1, "Error"), 0},
+		{"running", true, map[string]bool{}, corev1.ContainerStatus{Name: "app"}, 0},
+	}
+
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "ns"}}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			initializeContainerExitTracking()
+			seenTerminatedContainers, containerExitTrackingSeeded = tt.seen, tt.seeded
+			current := make(map[string]bool)
+			trackContainerTerminations(pod, []corev1.ContainerStatus{tt.status}, current)
+			if len(ContainerExitEvent) != tt.wantEvents {
+				t.Errorf("trackContainerTerminations() recorded %d events, want %d", len(ContainerExitEvent), tt.wantEvents)
+			}
+			if tt.status.State.Terminated != nil && !current["c1"] {
+				t.Errorf("trackContainerTerminations() did not track the terminated container c1")
+			}
+		})
+	}
+}
+
+func Test_recordContainerExit(t *testing.T) {
+	defer func() { containerExitLogMarkerEnabled = false }()
+	initializeContainerExitTracking()
+	containerExitLogMarkerEnabled = true
+	drainContainerExitLogMarkers()
+
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "ns"}}
+	status := terminatedStatus("app", "c1", 1, "")
+	recordContainerExit(pod, "app", "c1", status.State.Terminated)
+	recordContainerExit(pod, "app", "c1", status.State.Terminated)
+
+	message := "Container app in pod ns/pod-1 terminated with exit code 1 (Error)"
+	event, ok := ContainerExitEvent[message]
+	if !ok || event.Count != 2 || event.ContainerId != "c1" || event.FirstOccurrence != "2023-11-14T22:13:20Z" {
+		t.Errorf("recordContainerExit() events = %+v", ContainerExitEvent)
+	}
+	markers := drainContainerExitLogMarkers()
+	if len(markers) != 2 {
+		t.Fatalf("recordContainerExit() queued %d markers, want 2", len(markers))
+	}
+	if filepath := ToString(markers[0]["filepath"]); filepath != "/var/log/containers/pod-1_ns_app-c1.log" {
+		t.Errorf("recordContainerExit() marker filepath = %s", filepath)
+	}
+	if log := ToString(markers[0]["log"]); log != "[azmon] "+message {
+		t.Errorf("recordContainerExit() marker log = %s", log)
+	}
+}
+
+func Test_requeueContainerExitLogMarkers(t *testing.T) {
+	drainContainerExitLogMarkers()
+	retried := []map[interface{}]interface{}{{"log": []byte("m1")}, {"log": []byte("m2")}}
+	pendingContainerExitLogMarkers = []map[interface{}]interface{}{{"log": []byte("m3")}}
+	requeueContainerExitLogMarkers(retried)
+
+	markers := drainContainerExitLogMarkers()
+	var logs []string
+	for _, marker := range markers {
+		logs = append(logs, ToString(marker["log"]))
+	}
+	if len(logs) != 3 || logs[0] != "m1" || logs[1] != "m2" || logs[2] != "m3" {
+		t.Errorf("requeueContainerExitLogMarkers() queued %v, want [m1 m2 m3]", logs)
+	}
+}
+
+func Test_flushContainerLogRecordsRequeuesMarkers(t *testing.T) {
+	defer func() { containerExitLogMarkerEnabled, DownstreamReady = false, true }()
+	initializeContainerExitTracking()
+	containerExitLogMarkerEnabled = true
+	drainContainerExitLogMarkers()
+	pendingContainerExitLogMarkers = []map[interface{}]interface{}{{"log": []byte("marker"), "stream": []byte("stderr")}}
+
+	// the flush is retried while the downstream is not ready
+	DownstreamReady = false
+	if retCode := flushContainerLogRecords(nil, "", false); retCode != output.FLB_RETRY {
+		t.Fatalf("flushContainerLogRecords() = %d, want FLB_RETRY", retCode)
+	}
+	if markers := drainContainerExitLogMarkers(); len(markers) != 1 {
+		t.Errorf("flushContainerLogRecords() left %d markers queued after a retry, want 1", len(markers))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// comma separated labels of the containers added to the records as ContainerLabels, a label ending with * matches the
+// labels starting with it
+const envContainerLabelsAllowlist = "AZMON_CONTAINER_LABELS_ALLOWLIST"
+
+var (
+	// ContainerLabelsAllowlist the container labels added to the records, empty when the label enrichment is disabled
+	ContainerLabelsAllowlist []string
+	// ContainerLabelsMap caches the container id to the json of its allowed labels
+	ContainerLabelsMap map[string]string
+	// CRI client of the label refresh with the kubernetes metadata source
+	containerLabelsCRIClient *http.Client
+)
+
+// initializeContainerLabels reads the allowlist of the container labels. The labels come from the CRI socket of the
+// runtime, whatever the container metadata source
+func initializeContainerLabels() {
+	ContainerLabelsAllowlist = parseContainerLabelsAllowlist(os.Getenv(envContainerLabelsAllowlist))
+	if len(ContainerLabelsAllowlist) == 0 {
+		return
+	}
+	if IsWindows == true {
+		Log("Container label enrichment is not supported on windows")
+		ContainerLabelsAllowlist = nil
+		return
+	}
+	containerLabelsCRIClient = newCRIClient(CRISocketPath)
+	Log("Container labels added to the records = %s", strings.Join(ContainerLabelsAllowlist, ","))
+}
+
+func parseContainerLabelsAllowlist(value string) []string {
+	var allowlist []string
+	for _, label := range strings.Split(value, ",") {
+		if label = strings.TrimSpace(label); label != "" && label != "*" {
+			allowlist = append(allowlist, label)
+		}
+	}
+	return allowlist
+}
+
+// allowedContainerLabels returns the json of the labels in the allowlist, empty when there are none
+func allowedContainerLabels(labels map[string]string, allowlist []string) string {
+	allowed := make(map[string]string)
+	for key, value := range labels {
+		for _, label := range allowlist {
+			if key == label || (strings.HasSuffix(label, "*") && strings.HasPrefix(key, strings.TrimSuffix(label, "*"))) {
+				allowed[key] = value
+				break
+			}
+		}
+	}
+	if len(allowed) == 0 {
+		return ""
+	}
+	labelsJSON, err := json.Marshal(allowed)
+	if err != nil {
+		return ""
+	}
+	return string(labelsJSON)
+}
+
+// updateContainerLabels refreshes the allowed labels of the containers of the runtime
+func updateContainerLabels(containers []criContainer) {
+	if len(ContainerLabelsAllowlist) == 0 {
+		return
+	}
+	_containerLabelsMap := make(map[string]string)
+	for _, container := range containers {
+		if labels := allowedContainerLabels(container.Labels, ContainerLabelsAllowlist); container.ID != "" && labels != "" {
+			_containerLabelsMap[container.ID] = labels
+		}
+	}
+
+	DataUpdateMutex.Lock()
+	ContainerLabelsMap = _containerLabelsMap
+	DataUpdateMutex.Unlock()
+}
+
+// refreshContainerLabelsFromCRI refreshes the container labels with the kubernetes metadata source, which does not
+// have them
+func refreshContainerLabelsFromCRI() {
+	if containerLabelsCRIClient == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ParentContext, criRequestTimeout)
+	defer cancel()
+	containers, err := listCRIContainers(ctx, containerLabelsCRIClient)
+	if err != nil {
+		Log("Error getting the container labels from %s: %s", CRISocketPath, err.Error())
+		return
+	}
+	updateContainerLabels(containers)
+}
+
+// snapshotContainerLabels returns the container labels, replaced by the refreshes so not copied
+func snapshotContainerLabels() map[string]string {
+	rlockContainerMetadata()
+	defer DataUpdateMutex.RUnlock()
+	return ContainerLabelsMap
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_allowedContainerLabels(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		labels    map[string]string
+		allowlist string
+		output    string
+	}
+
+	labels := map[string]string{
+		"com.docker.compose.project":        "shop",
+		"com.docker.compose.service":        "web",
+		"org.opencontainers.image.revision": "0123abcd",
+		"io.kubernetes.pod.uid":             "uid-1",
+	}
+	tests := []test_struct{
+		{"exact", labels, "org.opencontainers.image.revision", `{"org.opencontainers.image.revision":"0123abcd"}`},
+		{"prefix", labels, "com.docker.compose.*", `{"com.docker.compose.project":"shop","com.docker.compose.service":"web"}`},
+		{"not present", labels, "build.sha", ""},
+		{"all ignored", labels, " * ,", ""},
+		{"no labels", nil, "com.docker.compose.*", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := allowedContainerLabels(tt.labels, parseContainerLabelsAllowlist(tt.allowlist)); got != tt.output {
+				t.Errorf("allowedContainerLabels() = %s, want %s", got, tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+// env variable of the interval (seconds) at which the log throughput of the containers is sent as InsightsMetrics. The
+// metrics are off when not set or 0
+const envContainerLogThroughputIntervalSeconds = "AZMON_CONTAINER_LOG_THROUGHPUT_INTERVAL_SECONDS"
+
+const minContainerLogThroughputIntervalSeconds = 60
+
+// the namespace and fields of the throughput metrics
+const (
+	containerLogThroughputMetricNamespace = "container.azm.ms/containerlogs"
+	containerLogRecordsPerSecondField     = "recordsPerSecond"
+	containerLogBytesPerSecondField       = "bytesPerSecond"
+)
+
+// containerLogThroughputKey the container of the throughput
+type containerLogThroughputKey struct {
+	namespace     string
+	podName       string
+	containerName string
+	containerID   string
+}
+
+// containerLogThroughput the records and bytes of the logs of a container
+type containerLogThroughput struct {
+	records int64
+	bytes   int64
+}
+
+var (
+	// ContainerLogThroughputInterval the interval of the throughput metrics, 0 when they are off
+	ContainerLogThroughputInterval time.Duration
+	// ContainerLogThroughputTicker to send the throughput metrics periodically
+	ContainerLogThroughputTicker *time.Ticker
+
+	containerLogThroughputMutex       = &sync.Mutex{}
+	containerLogThroughputByContainer = make(map[containerLogThroughputKey]*containerLogThroughput)
+	containerLogThroughputWindowStart time.Time
+)
+
+// initializeContainerLogThroughput reads the interval of the throughput metrics
+func initializeContainerLogThroughput() {
+	ContainerLogThroughputInterval = 0
+	value := strings.TrimSpace(os.Getenv(envContainerLogThroughputIntervalSeconds))
+	if value == "" || value == "0" {
+		return
+	}
+	intervalSeconds, err := strconv.Atoi(value)
+	if err != nil || intervalSeconds < minContainerLogThroughputIntervalSeconds {
+		Log("Invalid value %s for %s, the interval is at least %d seconds. The container log throughput metrics are disabled", value, envContainerLogThroughputIntervalSeconds, minContainerLogThroughputIntervalSeconds)
+		return
+	}
+	ContainerLogThroughputInterval = time.Second * time.Duration(intervalSeconds)
+	containerLogThroughputMutex.Lock()
+	containerLogThroughputByContainer = make(map[containerLogThroughputKey]*containerLogThroughput)
+	containerLogThroughputWindowStart = time.Now()
+	containerLogThroughputMutex.Unlock()
+	Log("Sending the container log throughput as InsightsMetrics every %s", ContainerLogThroughputInterval)
+}
+
+// addContainerLogThroughput adds the throughput of the records of a flush
+func addContainerLogThroughput(batch map[containerLogThroughputKey]*containerLogThroughput) {
+	if ContainerLogThroughputInterval == 0 || len(batch) == 0 {
+		return
+	}
+	containerLogThroughputMutex.Lock()
+	defer containerLogThroughputMutex.Unlock()
+	for key, throughput := range batch {
+		total, ok := containerLogThroughputByContainer[key]
+		if !ok {
+			total = &containerLogThroughput{}
+			containerLogThroughputByContainer[key] = total
+		}
+		total.records += throughput.records
+		total.bytes += throughput.bytes
+	}
+}
+
+// countContainerLogRecord counts a record of a flush in the throughput of its container
+func countContainerLogRecord(batch map[containerLogThroughputKey]*containerLogThroughput, key containerLogThroughputKey, bytes int) {
+	if ContainerLogThroughputInterval == 0 {
+		return
+	}
+	throughput, ok := batch[key]
+	if !ok {
+		throughput = &containerLogThroughput{}
+		batch[key] = throughput
+	}
+	throughput.records++
+	throughput.bytes += int64(bytes)
+}
+
+// takeContainerLogThroughput returns the throughput since the last call and its window, and starts a new window
+func takeContainerLogThroughput(now time.Time) (map[containerLogThroughputKey]*containerLogThroughput, time.Time) {
+	containerLogThroughputMutex.Lock()
+	defer containerLogThroughputMutex.Unlock()
+	throughput, windowStart := containerLogThroughputByContainer, containerLogThroughputWindowStart
+	containerLogThroughputByContainer = make(map[containerLogThroughputKey]*containerLogThroughput)
+	containerLogThroughputWindowStart = now
+	return throughput, windowStart
+}
+
+// startContainerLogThroughputMetrics sends the throughput metrics periodically when they are on
+func startContainerLogThroughputMetrics() {
+	if ContainerLogThroughputInterval == 0 {
+		return
+	}
+	ContainerLogThroughputTicker = time.NewTicker(ContainerLogThroughputInterval)
+	go sendContainerLogThroughputMetrics()
+}
+
+func sendContainerLogThroughputMetrics() {
+	for range ContainerLogThroughputTicker.C {
+		now := time.Now()
+		throughput, windowStart := takeContainerLogThroughput(now)
+		records := buildContainerLogThroughputMetrics(throughput, windowStart, now)
+		if len(records) == 0 {
+			continue
+		}
+		// the metrics are sent on the route of the telegraf metrics, the throughput of the window is dropped when it fails
+		if retCode := PostTelegrafMetricsToLA(records, ""); retCode != output.FLB_OK {
+			Log("Warning::throughput::Unable to send the log throughput of %d containers, dropping the window of %s", len(throughput), now.Sub(windowStart))
+		}
+	}
+}
+
+// buildContainerLogThroughputMetrics returns the records per second and bytes per second of the containers over the
+// window, as telegraf metrics
+func buildContainerLogThroughputMetrics(throughput map[containerLogThroughputKey]*containerLogThroughput, windowStart time.Time, now time.Time) []map[interface{}]interface{} {
+	window := now.Sub(windowStart).Seconds()
+	if window <= 0 {
+		return nil
+	}
+	var records []map[interface{}]interface{}
+	for key, total := range throughput {
+		tags := map[interface{}]interface{}{
+			"podNamespace":  key.namespace,
+			"podName":       key.podName,
+			"containerName": key.containerName,
+			"containerId":   key.containerID,
+		}
+		fields := map[interface{}]interface{}{
+			containerLogRecordsPerSecondField: float64(total.records) / window,
+			containerLogBytesPerSecondField:   float64(total.bytes) / window,
+		}
+		records = append(records, map[interface{}]interface{}{
+			"name":      containerLogThroughputMetricNamespace,
+			"tags":      tags,
+			"fields":    fields,
+			"timestamp": uint64(now.Unix()),
+		})
+	}
+	return records
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_buildContainerLogThroughputMetrics(t *testing.T) {
+	type test_struct struct {
+		testname        string
+		interval        string
+		flushes         [][]int
+		recordsPerSec   float64
+		bytesPerSec     float64
+		expectedMetrics int
+	}
+
+	tests := []test_struct{
+		{"disabled", "", [][]int{{10, 20}}, 0, 0, 0},
+		{"interval under the min", "30", [][]int{{10, 20}}, 0, 0, 0},
+		{"one flush", "60", [][]int{{60, 120, 60}}, 0.05, 4, 1},
+		{"several flushes", "60", [][]int{{60, 120}, {60}, {}}, 0.05, 4, 1},
+		{"no records", "60", [][]int{{}}, 0, 0, 0},
+	}
+
+	key := containerLogThroughputKey{namespace: "default", podName: "web-0", containerName: "web", containerID: "abc"}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			os.Setenv(envContainerLogThroughputIntervalSeconds, tt.interval)
+			defer os.Unsetenv(envContainerLogThroughputIntervalSeconds)
+			initializeContainerLogThroughput()
+			for _, flush := range tt.flushes {
+				batch := make(map[containerLogThroughputKey]*containerLogThroughput)
+				for _, bytes := range flush {
+					countContainerLogRecord(batch, key, bytes)
+				}
+				addContainerLogThroughput(batch)
+			}
+			windowStart := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+			throughput, _ := takeContainerLogThroughput(windowStart.Add(time.Minute))
+			metrics := buildContainerLogThroughputMetrics(throughput, windowStart, windowStart.Add(time.Minute))
+			if len(metrics) != tt.expectedMetrics {
+				t.Fatalf("got %d metrics, want %d", len(metrics), tt.expectedMetrics)
+			}
+			if tt.expectedMetrics == 0 {
+				return
+			}
+			fields := metrics[0]["fields"].(map[interface{}]interface{})
+			if fields[containerLogRecordsPerSecondField] != tt.recordsPerSec || fields[containerLogBytesPerSecondField] != tt.bytesPerSec {
+				t.Errorf("got %v, want %v records/s and %v bytes/s", fields, tt.recordsPerSec, tt.bytesPerSec)
+			}
+			tags := metrics[0]["tags"].(map[interface{}]interface{})
+			if tags["podNamespace"] != "default" || tags["containerName"] != "web" {
+				t.Errorf("unexpected tags %v", tags)
+			}
+			if throughput, _ := takeContainerLogThroughput(windowStart); len(throughput) != 0 {
+				t.Errorf("the throughput is not reset once taken")
+			}
+		})
+	}
+}
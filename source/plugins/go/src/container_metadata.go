@@ -0,0 +1,390 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// env variables of the container metadata cache
+const (
+	// max number of containers of the cache, the least recently seen ones being evicted
+	envContainerMetadataCacheSize = "AZMON_CONTAINER_METADATA_CACHE_SIZE"
+	// how long the metadata of a container is kept after the refreshes stop seeing it, for the last lines of its logs
+	envContainerMetadataCacheTTLSeconds = "AZMON_CONTAINER_METADATA_CACHE_TTL_SECONDS"
+	// how long a container whose lookup found no metadata is not looked up again
+	envContainerMetadataMissTTLSeconds = "AZMON_CONTAINER_METADATA_MISS_TTL_SECONDS"
+)
+
+const (
+	defaultContainerMetadataCacheSize = 10000
+	defaultContainerMetadataCacheTTL  = 5 * time.Minute
+	defaultContainerMetadataMissTTL   = 30 * time.Second
+	// the lookups are done by the flushes, so the queries of a flush are given less time than a refresh in total. The
+	// containers not queried before the deadline are looked up by the next flushes
+	containerMetadataLookupTimeout = 2 * time.Second
+	// max number of containers of ContainerMetadataMisses
+	maxContainerMetadataMisses = 1000
+)
+
+// the read lock of the container metadata is counted as contended when it waits longer than this
+const containerMetadataLockContentionThreshold = time.Millisecond
+
+// rlockContainerMetadata read locks DataUpdateMutex, counting the time the flush waited for a refresh of the container
+// metadata holding the lock. The maps are replaced, never updated, by the refreshes, so the readers use the maps they
+// read under the lock without copying them
+func rlockContainerMetadata() {
+	start := time.Now()
+	DataUpdateMutex.RLock()
+	if wait := time.Since(start); wait > containerMetadataLockContentionThreshold {
+		ContainerLogTelemetryMutex.Lock()
+		ContainerMetadataLockContentionCount += 1
+		ContainerMetadataLockWaitMs += float64(wait / time.Millisecond)
+		ContainerLogTelemetryMutex.Unlock()
+	}
+}
+
+// containerMetadata the metadata of a container the logs are enriched with, the restart count being empty when unknown
+type containerMetadata struct {
+	image        string
+	name         string
+	podUID       string
+	restartCount string
+}
+
+// containerMetadataEntry a container of the cache
+type containerMetadataEntry struct {
+	containerID string
+	metadata    containerMetadata
+	// when the container was last seen by a refresh or a lookup
+	seenAt time.Time
+}
+
+// containerMetadataCache the metadata of the containers seen by the refreshes and the lookups, kept for the ttl after
+// the container was last seen and evicted by least recently seen when full. The refreshes publish the maps of the
+// containers of the cache, so the logs written after a container is removed are still enriched
+type containerMetadataCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+var (
+	// ContainerMetadataCache the metadata of the containers, nil when the logs are not enriched
+	ContainerMetadataCache *containerMetadataCache
+	// ContainerMetadataMisses the containers whose lookup found no metadata recently, not looked up again until their
+	// ttl expires
+	ContainerMetadataMisses *containerMetadataMisses
+	// containerMetadataLookupCRIClient queries the containers of the CRI runtime with the cri metadata source
+	containerMetadataLookupCRIClient *http.Client
+)
+
+// initializeContainerMetadataCache reads the size and the ttl of the container metadata cache
+func initializeContainerMetadataCache() {
+	size := defaultContainerMetadataCacheSize
+	if value := strings.TrimSpace(os.Getenv(envContainerMetadataCacheSize)); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			size = n
+		} else {
+			Log("Invalid value %s for %s, using the default %d containers", value, envContainerMetadataCacheSize, defaultContainerMetadataCacheSize)
+		}
+	}
+	ttl := defaultContainerMetadataCacheTTL
+	if value := strings.TrimSpace(os.Getenv(envContainerMetadataCacheTTLSeconds)); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			ttl = time.Duration(seconds) * time.Second
+		} else {
+			Log("Invalid value %s for %s, using the default %s", value, envContainerMetadataCacheTTLSeconds, defaultContainerMetadataCacheTTL)
+		}
+	}
+	missTTL := defaultContainerMetadataMissTTL
+	if value := strings.TrimSpace(os.Getenv(envContainerMetadataMissTTLSeconds)); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			missTTL = time.Duration(seconds) * time.Second
+		} else {
+			Log("Invalid value %s for %s, using the default %s", value, envContainerMetadataMissTTLSeconds, defaultContainerMetadataMissTTL)
+		}
+	}
+	ContainerMetadataCache = newContainerMetadataCache(size, ttl)
+	ContainerMetadataMisses = newContainerMetadataMisses(maxContainerMetadataMisses, missTTL)
+	if ContainerMetadataSource == containerMetadataSourceCRI {
+		containerMetadataLookupCRIClient = newCRIClient(CRISocketPath)
+	}
+	Log("Container metadata cache size = %d containers, ttl = %s, lookup miss ttl = %s", size, ttl, missTTL)
+}
+
+func newContainerMetadataCache(size int, ttl time.Duration) *containerMetadataCache {
+	return &containerMetadataCache{size: size, ttl: ttl, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// add records the metadata of a container seen now, evicting the least recently seen container when full
+func (c *containerMetadataCache) add(containerID string, metadata containerMetadata, now time.Time) {
+	if element, ok := c.entries[containerID]; ok {
+		entry := element.Value.(*containerMetadataEntry)
+		entry.metadata = metadata
+		entry.seenAt = now
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[containerID] = c.order.PushFront(&containerMetadataEntry{containerID: containerID, metadata: metadata, seenAt: now})
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *containerMetadataCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*containerMetadataEntry).containerID)
+}
+
+// refresh adds the containers of a refresh, evicts the containers not seen for the ttl, and returns the maps of the
+// containers of the cache to publish
+func (c *containerMetadataCache) refresh(imageIDs map[string]string, names map[string]string, podUIDs map[string]string, restartCounts map[string]string, now time.Time) (map[string]string, map[string]string, map[string]string, map[string]string) {
+	if c == nil {
+		return imageIDs, names, podUIDs, restartCounts
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for containerID := range podUIDs {
+		c.add(containerID, containerMetadata{image: imageIDs[containerID], name: names[containerID], podUID: podUIDs[containerID], restartCount: restartCounts[containerID]}, now)
+	}
+	// the least recently seen containers are at the back
+	for element := c.order.Back(); element != nil && now.Sub(element.Value.(*containerMetadataEntry).seenAt) > c.ttl; element = c.order.Back() {
+		c.remove(element)
+	}
+
+	imageIDs = make(map[string]string, c.order.Len())
+	names = make(map[string]string, c.order.Len())
+	podUIDs = make(map[string]string, c.order.Len())
+	restartCounts = make(map[string]string, c.order.Len())
+	for containerID, element := range c.entries {
+		metadata := element.Value.(*containerMetadataEntry).metadata
+		imageIDs[containerID] = metadata.image
+		names[containerID] = metadata.name
+		podUIDs[containerID] = metadata.podUID
+		if metadata.restartCount != "" {
+			restartCounts[containerID] = metadata.restartCount
+		}
+	}
+	return imageIDs, names, podUIDs, restartCounts
+}
+
+// get returns the metadata of a container of the cache
+func (c *containerMetadataCache) get(containerID string) (containerMetadata, bool) {
+	if c == nil {
+		return containerMetadata{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[containerID]
+	if !ok {
+		return containerMetadata{}, false
+	}
+	return element.Value.(*containerMetadataEntry).metadata, true
+}
+
+// containerMetadataMisses the containers whose lookup found no metadata, with when their miss expires
+type containerMetadataMisses struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	expires map[string]time.Time
+}
+
+func newContainerMetadataMisses(size int, ttl time.Duration) *containerMetadataMisses {
+	return &containerMetadataMisses{size: size, ttl: ttl, expires: make(map[string]time.Time)}
+}
+
+// contains returns whether the lookup of the container found no metadata within the ttl
+func (m *containerMetadataMisses) contains(containerID string, now time.Time) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expires, ok := m.expires[containerID]
+	if ok && now.After(expires) {
+		delete(m.expires, containerID)
+		return false
+	}
+	return ok
+}
+
+// add records the miss of the container. When full, the expired misses are removed first and then any miss, the
+// container only being looked up again earlier
+func (m *containerMetadataMisses) add(containerID string, now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.expires[containerID]; !ok && len(m.expires) >= m.size {
+		for id, expires := range m.expires {
+			if now.After(expires) {
+				delete(m.expires, id)
+			}
+		}
+		for id := range m.expires {
+			if len(m.expires) < m.size {
+				break
+			}
+			delete(m.expires, id)
+		}
+	}
+	m.expires[containerID] = now.Add(m.ttl)
+}
+
+// lookupContainerMetadata returns the metadata of a container missing from the maps when it started after the last
+// refresh, from the cache, the pods of the informer cache, or a query of its pod or of the CRI runtime. The container
+// is added to the cache and a refresh is requested, so the next flushes have it in the maps. A container which is not
+// found is not looked up again for the miss ttl. The queries of a flush share the containerMetadataLookupTimeout from
+// the first one, the deadline being set by it, and the containers are not queried once it is passed
+func lookupContainerMetadata(containerID string, namespace string, podName string, deadline *time.Time) (containerMetadata, bool) {
+	if ContainerMetadataCache == nil {
+		return containerMetadata{}, false
+	}
+	if metadata, ok := ContainerMetadataCache.get(containerID); ok {
+		return metadata, true
+	}
+	if ContainerMetadataMisses.contains(containerID, time.Now()) {
+		return containerMetadata{}, false
+	}
+	if deadline.IsZero() {
+		*deadline = time.Now().Add(containerMetadataLookupTimeout)
+	} else if !time.Now().Before(*deadline) {
+		return containerMetadata{}, false
+	}
+	metadata, found := findContainerMetadata(containerID, namespace, podName, *deadline)
+	if !found {
+		ContainerMetadataMisses.add(containerID, time.Now())
+		ContainerLogTelemetryMutex.Lock()
+		ContainerMetadataLookupMissCount += 1
+		ContainerLogTelemetryMutex.Unlock()
+		return containerMetadata{}, false
+	}
+	ContainerMetadataCache.mu.Lock()
+	ContainerMetadataCache.add(containerID, metadata, time.Now())
+	ContainerMetadataCache.mu.Unlock()
+	requestNow(containerMetadataRefreshRequests)
+	ContainerLogTelemetryMutex.Lock()
+	ContainerMetadataLookupCount += 1
+	ContainerLogTelemetryMutex.Unlock()
+	return metadata, true
+}
+
+// findContainerMetadata looks up the container in the CRI runtime with the cri metadata source, otherwise in the pods
+// of the informer cache and then in its pod read from the API server, which the informer may not have received yet
+func findContainerMetadata(containerID string, namespace string, podName string, deadline time.Time) (containerMetadata, bool) {
+	ctx, cancel := context.WithDeadline(ParentContext, deadline)
+	defer cancel()
+	if ContainerMetadataSource == containerMetadataSourceCRI {
+		if containerMetadataLookupCRIClient == nil {
+			return containerMetadata{}, false
+		}
+		container, found, err := findCRIContainer(ctx, containerMetadataLookupCRIClient, containerID)
+		if err != nil {
+			Log("Error looking up the container %s in %s: %s", containerID, CRISocketPath, err.Error())
+		}
+		if !found {
+			return containerMetadata{}, false
+		}
+		return criContainerMetadata(container)
+	}
+	if NodePodLister != nil {
+		if pods, err := listNodePods(); err == nil {
+			if metadata, found := findPodContainerMetadata(pods, containerID); found {
+				return metadata, true
+			}
+		}
+	}
+	if ClientSet == nil || namespace == "" || podName == "" {
+		return containerMetadata{}, false
+	}
+	pod, err := ClientSet.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		Log("Error looking up the pod %s/%s of the container %s: %s", namespace, podName, containerID, err.Error())
+		return containerMetadata{}, false
+	}
+	return findPodContainerMetadata([]*corev1.Pod{pod}, containerID)
+}
+
+// findPodContainerMetadata returns the metadata of the container of the pods, or of their init containers, with the id
+func findPodContainerMetadata(pods []*corev1.Pod, containerID string) (containerMetadata, bool) {
+	for _, pod := range pods {
+		for _, statuses := range [][]corev1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses} {
+			for _, status := range statuses {
+				if id, _ := runtimeContainerID(status.ContainerID); id == containerID {
+					return containerMetadata{
+						image:        status.Image,
+						name:         fmt.Sprintf("%s/%s", pod.UID, status.Name),
+						podUID:       string(pod.UID),
+						restartCount: strconv.Itoa(int(status.RestartCount)),
+					}, true
+				}
+			}
+		}
+	}
+	return containerMetadata{}, false
+}
+
+// resolve adds the metadata of a container missing from the maps of the flush when it is found by a lookup, once per
+// container and flush. The maps shared with the refreshes are copied before the first container is added
+func (m *containerLogMetadata) resolve(containerID string, namespace string, podName string) {
+	if containerID == "" || m.lookedUp[containerID] {
+		return
+	}
+	if _, known := m.podUIDs[containerID]; known {
+		return
+	}
+	if m.lookedUp == nil {
+		m.lookedUp = make(map[string]bool)
+	}
+	m.lookedUp[containerID] = true
+	metadata, found := lookupContainerMetadata(containerID, namespace, podName, &m.lookupDeadline)
+	if !found {
+		return
+	}
+	if !m.copied {
+		m.imageIDs = copyStringMap(m.imageIDs)
+		m.names = copyStringMap(m.names)
+		m.podUIDs = copyStringMap(m.podUIDs)
+		m.restartCounts = copyStringMap(m.restartCounts)
+		m.copied = true
+	}
+	m.imageIDs[containerID] = metadata.image
+	m.names[containerID] = metadata.name
+	m.podUIDs[containerID] = metadata.podUID
+	if metadata.restartCount != "" {
+		m.restartCounts[containerID] = metadata.restartCount
+	}
+}
+
+// addIncarnationColumns adds the pod uid and the restart count of the container to a record of the ContainerLogV2
+// schema, when known, so the logs of the incarnations of a pod name are told apart
+func (m *containerLogMetadata) addIncarnationColumns(stringMap map[string]string, containerID string) {
+	if val, ok := m.podUIDs[containerID]; ok {
+		stringMap["PodUid"] = val
+	}
+	if val, ok := m.restartCounts[containerID]; ok {
+		stringMap["RestartCount"] = val
+	}
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	copied := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_rlockContainerMetadata(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		refresh   time.Duration
+		contended bool
+	}
+
+	tests := []test_struct{
+		{"no refresh", 0, false},
+		{"refresh holding the lock", 20 * time.Millisecond, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ContainerMetadataLockContentionCount, ContainerMetadataLockWaitMs = 0, 0
+			ImageIDMap = map[string]string{"c1": "image"}
+			if tt.refresh > 0 {
+				DataUpdateMutex.Lock()
+				go func() {
+					time.Sleep(tt.refresh)
+					DataUpdateMutex.Unlock()
+				}()
+			}
+			imageIDMap, _, _, _ := snapshotContainerMetadata()
+			imageIDMap["c2"] = "image"
+			if len(ImageIDMap) != 2 {
+				t.Errorf("snapshotContainerMetadata() copied the image map")
+			}
+			if contended := ContainerMetadataLockContentionCount > 0; contended != tt.contended || (ContainerMetadataLockWaitMs > 0) != tt.contended {
+				t.Errorf("contention counted = %v with %v ms waited, want %v", contended, ContainerMetadataLockWaitMs, tt.contended)
+			}
+		})
+	}
+}
+
+func Test_containerMetadataCacheRefresh(t *testing.T) {
+	type test_struct struct {
+		testname   string
+		size       int
+		ttl        time.Duration
+		refreshes  [][]string
+		containers []string
+	}
+
+	tests := []test_struct{
+		{"refreshed containers", 10, time.Minute, [][]string{{"c1", "c2"}}, []string{"c1", "c2"}},
+		{"removed container within the ttl", 10, time.Minute, [][]string{{"c1", "c2"}, {"c2"}}, []string{"c1", "c2"}},
+		{"removed container after the ttl", 10, 0, [][]string{{"c1", "c2"}, {"c2"}}, []string{"c2"}},
+		{"least recently seen container evicted", 2, time.Minute, [][]string{{"c1"}, {"c2"}, {"c3"}}, []string{"c2", "c3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			cache := newContainerMetadataCache(tt.size, tt.ttl)
+			now := time.Now()
+			var podUIDs map[string]string
+			for _, containers := range tt.refreshes {
+				now = now.Add(time.Second)
+				refreshed := make(map[string]string)
+				for _, containerID := range containers {
+					refreshed[containerID] = "pod-" + containerID
+				}
+				_, _, podUIDs, _ = cache.refresh(refreshed, refreshed, refreshed, map[string]string{}, now)
+			}
+			if len(podUIDs) != len(tt.containers) {
+				t.Errorf("refresh() = %v, want the containers %v", podUIDs, tt.containers)
+			}
+			for _, containerID := range tt.containers {
+				if podUIDs[containerID] != "pod-"+containerID {
+					t.Errorf("refresh() = %v, want the containers %v", podUIDs, tt.containers)
+				}
+			}
+		})
+	}
+}
+
+func Test_findPodContainerMetadata(t *testing.T) {
+	type test_struct struct {
+		testname    string
+		containerID string
+		want        containerMetadata
+		found       bool
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: "pod1"},
+		Status: corev1.PodStatus{
+			ContainerStatuses:     []corev1.ContainerStatus{{Name: "app", Image: "app:1", ContainerID: "containerd://c1", RestartCount: 2}},
+			InitContainerStatuses: []corev1.ContainerStatus{{Name: "init", Image: "init:1", ContainerID: "docker://c2"}},
+		},
+	}
+
+	tests := []test_struct{
+		{"container", "c1", containerMetadata{image: "app:1", name: "pod1/app", podUID: "pod1", restartCount: "2"}, true},
+		{"init container", "c2", containerMetadata{image: "init:1", name: "pod1/init", podUID: "pod1", restartCount: "0"}, true},
+		{"unknown container", "c3", containerMetadata{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, found := findPodContainerMetadata([]*corev1.Pod{pod}, tt.containerID)
+			if got != tt.want || found != tt.found {
+				t.Errorf("findPodContainerMetadata() = %v, %v, want %v, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func Test_containerLogMetadataResolve(t *testing.T) {
+	ContainerMetadataCache = newContainerMetadataCache(10, time.Minute)
+	ContainerMetadataMisses = newContainerMetadataMisses(10, time.Minute)
+	defer func() { ContainerMetadataCache, ContainerMetadataMisses = nil, nil }()
+	ContainerMetadataCache.add("c2", containerMetadata{image: "app:2", name: "pod2/app", podUID: "pod2"}, time.Now())
+
+	podUIDs := map[string]string{"c1": "pod1"}
+	metadata := containerLogMetadata{imageIDs: map[string]string{"c1": "app:1"}, names: map[string]string{"c1": "pod1/app"}, podUIDs: podUIDs, restartCounts: map[string]string{}}
+	metadata.resolve("c1", "default", "pod1")
+	if metadata.copied {
+		t.Errorf("resolve() copied the maps for a known container")
+	}
+	metadata.resolve("c2", "default", "pod2")
+	metadata.resolve("c3", "default", "pod3")
+	if metadata.imageIDs["c2"] != "app:2" || metadata.podUIDs["c2"] != "pod2" || len(podUIDs) != 1 {
+		t.Errorf("resolve() = %v, want the container of the cache added to a copy of the maps", metadata.podUIDs)
+	}
+	if !metadata.lookedUp["c3"] || !ContainerMetadataMisses.contains("c3", time.Now()) {
+		t.Errorf("resolve() did not record the lookup miss of the unknown container")
+	}
+}
+
+func Test_addIncarnationColumns(t *testing.T) {
+	type test_struct struct {
+		testname         string
+		containerID      string
+		wantPodUID       string
+		wantRestartCount string
+	}
+
+	tests := []test_struct{
+		{"known container", "c1", "uid-1", "2"},
+		{"unknown restart count", "c2", "uid-2", ""},
+		{"unknown container", "c3", "", ""},
+	}
+
+	metadata := containerLogMetadata{
+		podUIDs:       map[string]string{"c1": "uid-1", "c2": "uid-2"},
+		restartCounts: map[string]string{"c1": "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			stringMap := map[string]string{"ContainerId": tt.containerID}
+			metadata.addIncarnationColumns(stringMap, tt.containerID)
+			item := newDataItemLAv2(stringMap)
+			if item.PodUid != tt.wantPodUID || item.RestartCount != tt.wantRestartCount {
+				t.Errorf("addIncarnationColumns() = %q, %q, want %q, %q", item.PodUid, item.RestartCount, tt.wantPodUID, tt.wantRestartCount)
+			}
+			if _, ok := stringMap["PodUid"]; ok != (tt.wantPodUID != "") {
+				t.Errorf("addIncarnationColumns() added PodUid = %v, want %v", ok, tt.wantPodUID != "")
+			}
+		})
+	}
+}
+
+func Test_containerMetadataMisses(t *testing.T) {
+	now := time.Now()
+	misses := newContainerMetadataMisses(2, time.Minute)
+	misses.add("c1", now)
+	misses.add("c2", now.Add(90*time.Second))
+	if !misses.contains("c1", now.Add(59*time.Second)) || misses.contains("c1", now.Add(61*time.Second)) {
+		t.Errorf("contains() does not expire the miss of c1 after the ttl")
+	}
+	misses.add("c1", now)
+	misses.add("c3", now.Add(2*time.Minute))
+	if len(misses.expires) != 2 || !misses.contains("c2", now.Add(2*time.Minute)) || !misses.contains("c3", now.Add(2*time.Minute)) {
+		t.Errorf("add() kept %v over the size, want the expired miss of c1 evicted", misses.expires)
+	}
+}
+
+func Test_lookupContainerMetadataDeadline(t *testing.T) {
+	type test_struct struct {
+		testname   string
+		deadline   time.Time
+		wantMissed bool
+	}
+
+	tests := []test_struct{
+		{"first query of the flush", time.Time{}, true},
+		{"before the deadline", time.Now().Add(time.Minute), true},
+		{"deadline passed", time.Now().Add(-time.Second), false},
+	}
+
+	defer func() { ContainerMetadataCache, ContainerMetadataMisses = nil, nil }()
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ContainerMetadataCache = newContainerMetadataCache(10, time.Minute)
+			ContainerMetadataMisses = newContainerMetadataMisses(10, time.Minute)
+			deadline := tt.deadline
+			if _, found := lookupContainerMetadata("c1", "default", "pod1", &deadline); found {
+				t.Fatalf("lookupContainerMetadata() found an unknown container")
+			}
+			if deadline.IsZero() {
+				t.Errorf("lookupContainerMetadata() did not set the deadline of the flush")
+			}
+			if missed := ContainerMetadataMisses.contains("c1", time.Now()); missed != tt.wantMissed {
+				t.Errorf("lookupContainerMetadata() recorded a miss = %v, want %v", missed, tt.wantMissed)
+			}
+		})
+	}
+}
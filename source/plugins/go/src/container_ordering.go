@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// number of ordering lanes the containers are hashed to, 0 disables the ordering. The flushes with records of the
+// same lane are sent one at a time, in the order they were handed to the plugin
+const envContainerOrderingLanes = "AZMON_CONTAINER_ORDERING_LANES"
+
+const defaultContainerOrderingLanes = 64
+
+// orderingLane serves the flushes of its containers in ticket order
+type orderingLane struct {
+	next    uint64
+	serving uint64
+	// tickets released before their turn, i.e. flushes cancelled while waiting
+	released map[uint64]bool
+}
+
+// containerOrdering keeps the records of a container in order across concurrent flushes
+type containerOrdering struct {
+	mu    sync.Mutex
+	lanes []orderingLane
+	// closed and replaced when a lane moves to its next ticket
+	changed chan struct{}
+}
+
+// ContainerOrdering the ordering lanes of the container logs flushes, nil when the ordering is disabled
+var ContainerOrdering *containerOrdering
+
+// initializeContainerOrdering reads the number of ordering lanes
+func initializeContainerOrdering() {
+	lanes := defaultContainerOrderingLanes
+	if value := strings.TrimSpace(os.Getenv(envContainerOrderingLanes)); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			lanes = n
+		} else {
+			Log("Invalid value %s for %s, using the default %d lanes", value, envContainerOrderingLanes, defaultContainerOrderingLanes)
+		}
+	}
+	ContainerOrdering = newContainerOrdering(lanes)
+	Log("Container ordering lanes = %d", lanes)
+}
+
+func newContainerOrdering(lanes int) *containerOrdering {
+	if lanes <= 0 {
+		return nil
+	}
+	ordering := &containerOrdering{lanes: make([]orderingLane, lanes), changed: make(chan struct{})}
+	for i := range ordering.lanes {
+		ordering.lanes[i].released = make(map[uint64]bool)
+	}
+	return ordering
+}
+
+// lane returns the lane of the container log file, the file identifies the container
+func (o *containerOrdering) lane(filepath []byte) int {
+	return int(containerKeyHash(filepath) % uint32(len(o.lanes)))
+}
+
+// containerKeyHash returns the hash of the key of a container, its log file or its id, which the records of the
+// container are kept in order by
+func containerKeyHash(key []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32()
+}
+
+// recordLanes returns the sorted lanes of the containers of the records
+func (o *containerOrdering) recordLanes(records []map[interface{}]interface{}) []int {
+	if o == nil {
+		return nil
+	}
+	seen := make(map[int]bool)
+	for _, record := range records {
+		seen[o.lane([]byte(ToString(record["filepath"])))] = true
+	}
+	return sortedLanes(seen)
+}
+
+func sortedLanes(seen map[int]bool) []int {
+	lanes := make([]int, 0, len(seen))
+	for lane := range seen {
+		lanes = append(lanes, lane)
+	}
+	sort.Ints(lanes)
+	return lanes
+}
+
+// acquire takes a ticket in each of the lanes and waits for the turn of the flush in all of them, or until the flush
+// context is done. The tickets are taken together, so the flushes are served in the same order in every lane and
+// can't deadlock. The returned func releases the lanes and must be called once the records are sent
+func (o *containerOrdering) acquire(ctx context.Context, lanes []int) (func(), error) {
+	if o == nil || len(lanes) == 0 {
+		return func() {}, nil
+	}
+	tickets := make([]uint64, len(lanes))
+	o.mu.Lock()
+	for i, lane := range lanes {
+		tickets[i] = o.lanes[lane].next
+		o.lanes[lane].next++
+	}
+	o.mu.Unlock()
+	release := func() { o.release(lanes, tickets) }
+
+	for {
+		o.mu.Lock()
+		ready := true
+		for i, lane := range lanes {
+			if o.lanes[lane].serving != tickets[i] {
+				ready = false
+				break
+			}
+		}
+		changed := o.changed
+		o.mu.Unlock()
+		if ready {
+			return release, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			// the tickets are given up, so the later flushes of the lanes aren't blocked
+			release()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release moves the lanes past the tickets, and past the tickets released before their turn
+func (o *containerOrdering) release(lanes []int, tickets []uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, lane := range lanes {
+		l := &o.lanes[lane]
+		if l.serving != tickets[i] {
+			l.released[tickets[i]] = true
+			continue
+		}
+		l.serving++
+		for l.released[l.serving] {
+			delete(l.released, l.serving)
+			l.serving++
+		}
+	}
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_containerOrderingRecordLanes(t *testing.T) {
+	type test_struct struct {
+		testname string
+		records  []map[interface{}]interface{}
+		want     int
+	}
+
+	tests := []test_struct{
+		{"same container", []map[interface{}]interface{}{
+			{"filepath": []byte("/var/log/containers/a.log")},
+			{"filepath": []byte("/var/log/containers/a.log")}}, 1},
+		{"no records", []map[interface{}]interface{}{}, 0},
+	}
+
+	ordering := newContainerOrdering(8)
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := ordering.recordLanes(tt.records); len(got) != tt.want {
+				t.Errorf("recordLanes() = %v, want %d lanes", got, tt.want)
+			}
+		})
+	}
+
+	lanes := ordering.recordLanes([]map[interface{}]interface{}{
+		{"filepath": []byte("/var/log/containers/a.log")},
+		{"filepath": []byte("/var/log/containers/b.log")},
+		{"filepath": []byte("/var/log/containers/c.log")}})
+	if !sort.IntsAreSorted(lanes) {
+		t.Errorf("recordLanes() = %v, want sorted lanes", lanes)
+	}
+	if newContainerOrdering(0) != nil {
+		t.Errorf("newContainerOrdering(0) != nil, want the ordering disabled")
+	}
+}
+
+func Test_containerOrderingAcquire(t *testing.T) {
+	ordering := newContainerOrdering(4)
+	ctx := context.Background()
+
+	releaseFirst, err := ordering.acquire(ctx, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a flush of another lane is not blocked
+	releaseOther, err := ordering.acquire(ctx, []int{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseOther()
+
+	// a flush of the same lane which gives up while waiting doesn't block the later flushes
+	cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := ordering.acquire(cancelled, []int{1, 2}); err == nil {
+		t.Fatalf("acquire() error = nil while an earlier flush holds the lane, want the context error")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		releaseSecond, err := ordering.acquire(ctx, []int{1})
+		if err != nil {
+			t.Error(err)
+		}
+		close(acquired)
+		releaseSecond()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("acquire() returned before the earlier flush of the lane released it")
+	case <-time.After(20 * time.Millisecond):
+	}
+	releaseFirst()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("acquire() still waiting after the earlier flush of the lane released it")
+	}
+}
@@ -0,0 +1,60 @@
+// Package containermetadata parses the container metadata the kubelet and the container runtimes encode in the
+// container log file paths and in the container ids. It is shared by the plugin and the tooling, so both parse the
+// same way.
+package containermetadata
+
+import (
+	"strings"
+)
+
+// ContainerLogFile the container metadata of a container log file path
+type ContainerLogFile struct {
+	ContainerID   string
+	Namespace     string
+	PodName       string
+	ContainerName string
+}
+
+// ParseContainerLogFilePath gets the container id, namespace, pod name and container name from a container log file
+// path, /var/log/containers/<pod name>_<namespace>_<container name>-<container id>.log. The fields that can't be
+// parsed are empty
+// sample path /var/log/containers/kube-proxy-dgcx7_kube-system_kube-proxy-8df7e49e9028b60b5b0d0547f409c455a9567946cf763267b7e6fa053ab8c182.log
+func ParseContainerLogFilePath(path string) ContainerLogFile {
+	var file ContainerLogFile
+
+	start := strings.LastIndex(path, "-")
+	end := strings.LastIndex(path, ".")
+	if start < end && start != -1 && end != -1 {
+		file.ContainerID = path[start+1 : end]
+	}
+
+	start = strings.Index(path, "_")
+	end = strings.LastIndex(path, "_")
+	if start < end && start != -1 && end != -1 {
+		file.Namespace = path[start+1 : end]
+	}
+
+	start = strings.LastIndex(path, "_")
+	end = strings.LastIndex(path, "-")
+	if start < end && start != -1 && end != -1 {
+		file.ContainerName = path[start+1 : end]
+	}
+
+	start = strings.Index(path, "/containers/")
+	end = strings.Index(path, "_")
+	if start < end && start != -1 && end != -1 {
+		file.PodName = path[start+len("/containers/") : end]
+	}
+
+	return file
+}
+
+// ParseKubeletContainerID splits a container id reported by the kubelet, <runtime>://<id>, into the id and the
+// runtime. The runtime is empty when the id has none
+func ParseKubeletContainerID(kubeletContainerID string) (string, string) {
+	index := strings.Index(kubeletContainerID, "://")
+	if index == -1 {
+		return kubeletContainerID[strings.LastIndex(kubeletContainerID, "/")+1:], ""
+	}
+	return kubeletContainerID[index+len("://"):], kubeletContainerID[:index]
+}
@@ -0,0 +1,69 @@
+package containermetadata
+
+import (
+	"testing"
+)
+
+func Test_ParseContainerLogFilePath(t *testing.T) {
+	type test_struct struct {
+		testName string
+		path     string
+		output   ContainerLogFile
+	}
+
+	tests := []test_struct{
+		{
+			"container log file",
+			"/var/log/containers/kube-proxy-dgcx7_kube-system_kube-proxy-8df7e49e9028b60b5b0d0547f409c455a9567946cf763267b7e6fa053ab8c182.log",
+			ContainerLogFile{"8df7e49e9028b60b5b0d0547f409c455a9567946cf763267b7e6fa053ab8c182", "kube-system", "kube-proxy-dgcx7", "kube-proxy"},
+		},
+		{
+			"no container id",
+			"/var/log/containers/web_default_nginx.log",
+			ContainerLogFile{"", "default", "web", ""},
+		},
+		{
+			"not a container log file",
+			"/var/log/syslog",
+			ContainerLogFile{},
+		},
+		{
+			"empty",
+			"",
+			ContainerLogFile{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := ParseContainerLogFilePath(tt.path); got != tt.output {
+				t.Errorf("ParseContainerLogFilePath(%s) = %+v, want %+v", tt.path, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_ParseKubeletContainerID(t *testing.T) {
+	type test_struct struct {
+		testName    string
+		containerID string
+		id          string
+		runtime     string
+	}
+
+	tests := []test_struct{
+		{"containerd", "containerd://0123abcd", "0123abcd", "containerd"},
+		{"cri-o", "cri-o://0123abcd", "0123abcd", "cri-o"},
+		{"no runtime", "0123abcd", "0123abcd", ""},
+		{"empty", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			id, runtime := ParseKubeletContainerID(tt.containerID)
+			if id != tt.id || runtime != tt.runtime {
+				t.Errorf("ParseKubeletContainerID(%s) = (%s, %s), want (%s, %s)", tt.containerID, id, runtime, tt.id, tt.runtime)
+			}
+		})
+	}
+}
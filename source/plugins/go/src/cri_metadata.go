@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// env variables of the container metadata enrichment
+const (
+	// kubernetes (default) to read the container metadata from the pods of the node, cri to read it from the CRI socket
+	envContainerMetadataSource = "AZMON_CONTAINER_METADATA_SOURCE"
+	// overrides the CRI socket of the detected container runtime
+	envCRISocketPath = "AZMON_CRI_SOCKET_PATH"
+)
+
+const (
+	containerMetadataSourceKubernetes = "kubernetes"
+	containerMetadataSourceCRI        = "cri"
+	// containerd socket, the host's /var/run is mounted at /var/run/host. The socket of the detected runtime is used
+	// when it is not containerd
+	defaultCRISocketPath = "/var/run/host/containerd/containerd.sock"
+	criRequestTimeout    = 10 * time.Second
+)
+
+// CRI runtime services, v1alpha2 for the runtimes older than kubernetes 1.23
+var criRuntimeServices = []string{"runtime.v1.RuntimeService", "runtime.v1alpha2.RuntimeService"}
+
+// labels and annotations the kubelet sets on the containers
+const (
+	criLabelPodName           = "io.kubernetes.pod.name"
+	criLabelPodNamespace      = "io.kubernetes.pod.namespace"
+	criLabelPodUID            = "io.kubernetes.pod.uid"
+	criLabelContainerName     = "io.kubernetes.container.name"
+	criAnnotationRestartCount = "io.kubernetes.container.restartCount"
+)
+
+// fields of the CRI Container message
+const (
+	criContainerFieldID          = 1
+	criContainerFieldMetadata    = 3
+	criContainerFieldImage       = 4
+	criContainerFieldLabels      = 8
+	criContainerFieldAnnotations = 9
+)
+
+const (
+	grpcStatusOK            = "0"
+	grpcStatusUnimplemented = "12"
+	// compressed flag and message length
+	grpcMessageHeaderSize = 5
+)
+
+// protobuf wire types
+const (
+	protoWireVarint          = 0
+	protoWireFixed64         = 1
+	protoWireLengthDelimited = 2
+	protoWireFixed32         = 5
+)
+
+var (
+	// ContainerMetadataSource where the container metadata added to the records comes from
+	ContainerMetadataSource = containerMetadataSourceKubernetes
+	// CRISocketPath the CRI socket of the container runtime
+	CRISocketPath = defaultCRISocketPath
+)
+
+// criContainer the fields of a CRI container used by the enrichment
+type criContainer struct {
+	ID          string
+	Name        string
+	Image       string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// initializeContainerMetadataSource reads where the container metadata comes from
+func initializeContainerMetadataSource() {
+	ContainerMetadataSource = containerMetadataSourceKubernetes
+	CRISocketPath = criSocketOfContainerRuntime()
+	switch source := strings.ToLower(strings.TrimSpace(os.Getenv(envContainerMetadataSource))); source {
+	case "", containerMetadataSourceKubernetes:
+	case containerMetadataSourceCRI:
+		if IsWindows == true {
+			Log("The %s container metadata source is not supported on windows, using %s", source, containerMetadataSourceKubernetes)
+			break
+		}
+		ContainerMetadataSource = containerMetadataSourceCRI
+		if path := strings.TrimSpace(os.Getenv(envCRISocketPath)); path != "" {
+			CRISocketPath = path
+		}
+		Log("Container metadata source = %s (%s). Container exit events are only tracked with the %s source", ContainerMetadataSource, CRISocketPath, containerMetadataSourceKubernetes)
+	default:
+		Log("Invalid value %s for %s, using %s", source, envContainerMetadataSource, containerMetadataSourceKubernetes)
+	}
+}
+
+// updateContainerMetadataFromCRI refreshes the container metadata maps from the containers of the CRI runtime, on the
+// same ticker as the kubernetes source
+func updateContainerMetadataFromCRI() {
+	client := newCRIClient(CRISocketPath)
+	for ; true; waitForTickOrRequest(ContainerImageNameRefreshTicker.C, containerMetadataRefreshRequests) {
+		Log("Updating ImageIDMap and NameIDMap from %s", CRISocketPath)
+		ctx, cancel := context.WithTimeout(ParentContext, criRequestTimeout)
+		containers, err := listCRIContainers(ctx, client)
+		cancel()
+		if err != nil {
+			Log("Error getting the containers from %s: %s\nIt is ok to log here and continue, because the logs will be missing image and Name, but the logs will still have the containerID", CRISocketPath, err.Error())
+			continue
+		}
+		updateContainerLabels(containers)
+
+		_imageIDMap := make(map[string]string)
+		_nameIDMap := make(map[string]string)
+		_podUIDMap := make(map[string]string)
+		_restartCountMap := make(map[string]string)
+		for _, container := range containers {
+			metadata, ok := criContainerMetadata(container)
+			if !ok {
+				continue
+			}
+			_imageIDMap[container.ID] = metadata.image
+			_nameIDMap[container.ID] = metadata.name
+			_podUIDMap[container.ID] = metadata.podUID
+			if metadata.restartCount != "" {
+				_restartCountMap[container.ID] = metadata.restartCount
+			}
+		}
+
+		// the containers removed since the previous refresh are kept for the ttl of the cache
+		imageIDMap, nameIDMap, podUIDMap, restartCountMap := ContainerMetadataCache.refresh(_imageIDMap, _nameIDMap, _podUIDMap, _restartCountMap, time.Now())
+		DataUpdateMutex.Lock()
+		ImageIDMap = imageIDMap
+		NameIDMap = nameIDMap
+		PodUIDMap = podUIDMap
+		RestartCountMap = restartCountMap
+		DataUpdateMutex.Unlock()
+		Log("Updated the image and name maps with %d containers", len(imageIDMap))
+	}
+}
+
+// criContainerMetadata returns the metadata of a CRI container, false when it is not a kubernetes container
+func criContainerMetadata(container criContainer) (containerMetadata, bool) {
+	podUID := container.Labels[criLabelPodUID]
+	if container.ID == "" || podUID == "" {
+		return containerMetadata{}, false
+	}
+	return containerMetadata{
+		image:        container.Image,
+		name:         fmt.Sprintf("%s/%s", podUID, container.Name),
+		podUID:       podUID,
+		restartCount: container.Annotations[criAnnotationRestartCount],
+	}, true
+}
+
+// newCRIClient returns an HTTP/2 client for the gRPC calls to the CRI socket, which is plain text
+func newCRIClient(socketPath string) *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.DialTimeout("unix", socketPath, criRequestTimeout)
+			},
+		},
+		Timeout: criRequestTimeout,
+	}
+}
+
+// listCRIContainers lists all the containers of the runtime
+func listCRIContainers(ctx context.Context, client *http.Client) ([]criContainer, error) {
+	// an empty ListContainersRequest lists all the containers
+	return invokeListContainers(ctx, client, nil)
+}
+
+// findCRIContainer returns the container of the runtime with the id, false when the runtime has no such container
+func findCRIContainer(ctx context.Context, client *http.Client, containerID string) (criContainer, bool, error) {
+	containers, err := invokeListContainers(ctx, client, encodeListContainersRequest(containerID))
+	if err != nil || len(containers) == 0 {
+		return criContainer{}, false, err
+	}
+	return containers[0], true, nil
+}
+
+// encodeListContainersRequest encodes a ListContainersRequest whose filter (field 1) has the container id (field 1)
+func encodeListContainersRequest(containerID string) []byte {
+	filter := appendProtoBytes(nil, 1, []byte(containerID))
+	return appendProtoBytes(nil, 1, filter)
+}
+
+func appendProtoVarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutUvarint(buf, v)]...)
+}
+
+// appendProtoBytes appends a length delimited protobuf field
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	b = appendProtoVarint(b, uint64(field<<3|protoWireLengthDelimited))
+	b = appendProtoVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// invokeListContainers lists the containers of the request, with the v1 runtime service or the v1alpha2 one when v1 is
+// not implemented
+func invokeListContainers(ctx context.Context, client *http.Client, request []byte) ([]criContainer, error) {
+	var err error
+	for _, service := range criRuntimeServices {
+		var response []byte
+		response, err = invokeGRPC(ctx, client, "/"+service+"/ListContainers", request)
+		if err == errGRPCUnimplemented {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return parseListContainersResponse(response)
+	}
+	return nil, err
+}
+
+var errGRPCUnimplemented = errors.New("grpc method not implemented")
+
+// invokeGRPC makes a unary gRPC call with the encoded request message, and returns the encoded response message
+func invokeGRPC(ctx context.Context, client *http.Client, method string, message []byte) ([]byte, error) {
+	body := make([]byte, grpcMessageHeaderSize, grpcMessageHeaderSize+len(message))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+	body = append(body, message...)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	response, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned http status %d", method, resp.StatusCode)
+	}
+	// the status is in the trailers, or in the headers when there is no response message
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	switch status {
+	case grpcStatusOK:
+	case grpcStatusUnimplemented:
+		return nil, errGRPCUnimplemented
+	default:
+		return nil, fmt.Errorf("%s returned grpc status %s: %s", method, status, resp.Trailer.Get("Grpc-Message"))
+	}
+	if len(response) < grpcMessageHeaderSize || response[0] != 0 {
+		return nil, fmt.Errorf("%s returned an invalid or compressed message", method)
+	}
+	size := binary.BigEndian.Uint32(response[1:grpcMessageHeaderSize])
+	if uint32(len(response)-grpcMessageHeaderSize) < size {
+		return nil, fmt.Errorf("%s returned a truncated message", method)
+	}
+	return response[grpcMessageHeaderSize : grpcMessageHeaderSize+size], nil
+}
+
+// parseListContainersResponse decodes the containers (field 1) of a CRI ListContainersResponse
+func parseListContainersResponse(b []byte) ([]criContainer, error) {
+	containers := []criContainer{}
+	err := walkProtoFields(b, func(field int, wireType int, varint uint64, value []byte) error {
+		if field != 1 || wireType != protoWireLengthDelimited {
+			return nil
+		}
+		container, err := parseCRIContainer(value)
+		if err != nil {
+			return err
+		}
+		containers = append(containers, container)
+		return nil
+	})
+	return containers, err
+}
+
+// parseCRIContainer decodes the id, metadata name, image, labels and annotations of a CRI Container
+func parseCRIContainer(b []byte) (criContainer, error) {
+	container := criContainer{Labels: make(map[string]string), Annotations: make(map[string]string)}
+	err := walkProtoFields(b, func(field int, wireType int, varint uint64, value []byte) error {
+		if wireType != protoWireLengthDelimited {
+			return nil
+		}
+		switch field {
+		case criContainerFieldID:
+			container.ID = string(value)
+		case criContainerFieldMetadata:
+			// ContainerMetadata, name is field 1
+			return walkProtoFields(value, func(field int, wireType int, varint uint64, value []byte) error {
+				if field == 1 && wireType == protoWireLengthDelimited {
+					container.Name = string(value)
+				}
+				return nil
+			})
+		case criContainerFieldImage:
+			// ImageSpec, image is field 1
+			return walkProtoFields(value, func(field int, wireType int, varint uint64, value []byte) error {
+				if field == 1 && wireType == protoWireLengthDelimited {
+					container.Image = string(value)
+				}
+				return nil
+			})
+		case criContainerFieldLabels:
+			return parseProtoMapEntry(value, container.Labels)
+		case criContainerFieldAnnotations:
+			return parseProtoMapEntry(value, container.Annotations)
+		}
+		return nil
+	})
+	if container.Name == "" {
+		container.Name = container.Labels[criLabelContainerName]
+	}
+	return container, err
+}
+
+// parseProtoMapEntry decodes a map<string, string> entry, key is field 1 and value field 2
+func parseProtoMapEntry(b []byte, m map[string]string) error {
+	key, entryValue := "", ""
+	err := walkProtoFields(b, func(field int, wireType int, varint uint64, value []byte) error {
+		if wireType != protoWireLengthDelimited {
+			return nil
+		}
+		if field == 1 {
+			key = string(value)
+		} else if field == 2 {
+			entryValue = string(value)
+		}
+		return nil
+	})
+	if err == nil {
+		m[key] = entryValue
+	}
+	return err
+}
+
+// walkProtoFields calls fn with each field of a protobuf message, with the value of the varint fields or the bytes of
+// the length delimited fields. The fixed size fields are skipped
+func walkProtoFields(b []byte, fn func(field int, wireType int, varint uint64, value []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid protobuf field tag")
+		}
+		b = b[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+		var varint uint64
+		var value []byte
+		switch wireType {
+		case protoWireVarint:
+			if varint, n = binary.Uvarint(b); n <= 0 {
+				return fmt.Errorf("invalid varint in protobuf field %d", field)
+			}
+			b = b[n:]
+		case protoWireFixed64, protoWireFixed32:
+			size := 8
+			if wireType == protoWireFixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return fmt.Errorf("truncated protobuf field %d", field)
+			}
+			b = b[size:]
+			continue
+		case protoWireLengthDelimited:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("truncated protobuf field %d", field)
+			}
+			value = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d in field %d", wireType, field)
+		}
+		if err := fn(field, wireType, varint, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func appendProtoMapEntry(b []byte, field int, key string, value string) []byte {
+	entry := appendProtoBytes(nil, 1, []byte(key))
+	entry = appendProtoBytes(entry, 2, []byte(value))
+	return appendProtoBytes(b, field, entry)
+}
+
+func Test_parseListContainersResponse(t *testing.T) {
+	type test_struct struct {
+		testname string
+		response []byte
+		want     []criContainer
+		wantErr  bool
+	}
+
+	container := appendProtoBytes(nil, criContainerFieldID, []byte("0123456789abcdef"))
+	container = appendProtoBytes(container, 2, []byte("sandbox"))
+	container = appendProtoBytes(container, criContainerFieldMetadata, appendProtoBytes([]byte{2 << 3, 3}, 1, []byte("web")))
+	container = appendProtoBytes(container, criContainerFieldImage, appendProtoBytes(nil, 1, []byte("sha256:abc")))
+	// state and created_at
+	container = append(container, 6<<3, 1, 7<<3|protoWireFixed64, 0, 0, 0, 0, 0, 0, 0, 0)
+	container = appendProtoMapEntry(container, criContainerFieldLabels, criLabelPodUID, "uid-1")
+	container = appendProtoMapEntry(container, criContainerFieldAnnotations, criAnnotationRestartCount, "2")
+
+	tests := []test_struct{
+		{"container",
+			appendProtoBytes(nil, 1, container),
+			[]criContainer{{ID: "0123456789abcdef", Name: "web", Image: "sha256:abc",
+				Labels: map[string]string{criLabelPodUID: "uid-1"}, Annotations: map[string]string{criAnnotationRestartCount: "2"}}},
+			false},
+		{"no containers", nil, []criContainer{}, false},
+		{"truncated", appendProtoBytes(nil, 1, container)[:10], nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := parseListContainersResponse(tt.response)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseListContainersResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseListContainersResponse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_encodeListContainersRequest(t *testing.T) {
+	type test_struct struct {
+		testname    string
+		containerID string
+	}
+
+	tests := []test_struct{
+		{"container id", "0123456789abcdef"},
+		{"empty container id", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			var got string
+			err := walkProtoFields(encodeListContainersRequest(tt.containerID), func(field int, wireType int, varint uint64, value []byte) error {
+				if field != 1 || wireType != protoWireLengthDelimited {
+					t.Errorf("request field %d of wire type %d, want the filter", field, wireType)
+					return nil
+				}
+				return walkProtoFields(value, func(field int, wireType int, varint uint64, value []byte) error {
+					if field == 1 && wireType == protoWireLengthDelimited {
+						got = string(value)
+					}
+					return nil
+				})
+			})
+			if err != nil || got != tt.containerID {
+				t.Errorf("encodeListContainersRequest() filters the container %q, error = %v, want %q", got, err, tt.containerID)
+			}
+		})
+	}
+}
+
+func Test_criContainerMetadata(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		container criContainer
+		want      containerMetadata
+		ok        bool
+	}
+
+	tests := []test_struct{
+		{"kubernetes container",
+			criContainer{ID: "c1", Name: "web", Image: "sha256:abc", Labels: map[string]string{criLabelPodUID: "uid-1"}, Annotations: map[string]string{criAnnotationRestartCount: "2"}},
+			containerMetadata{image: "sha256:abc", name: "uid-1/web", podUID: "uid-1", restartCount: "2"}, true},
+		{"no restart count",
+			criContainer{ID: "c1", Name: "web", Image: "sha256:abc", Labels: map[string]string{criLabelPodUID: "uid-1"}},
+			containerMetadata{image: "sha256:abc", name: "uid-1/web", podUID: "uid-1"}, true},
+		{"not a kubernetes container", criContainer{ID: "c1", Name: "web"}, containerMetadata{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, ok := criContainerMetadata(tt.container)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("criContainerMetadata() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// env variables for the data boundary enforcement mode
+const (
+	// name of the geo boundary (e.g. "eu") all destinations must be in. Enforcement is off when not set
+	envDataBoundary = "AZMON_DATA_BOUNDARY"
+	// optional comma separated regions which override the built-in regions of the boundary
+	envDataBoundaryRegions = "AZMON_DATA_BOUNDARY_REGIONS"
+)
+
+const defaultAppInsightsEndpoint = "https://dc.services.visualstudio.com/v2/track"
+
+// regions within each supported geo boundary
+var dataBoundaryRegions = map[string][]string{
+	"eu": {
+		"northeurope", "westeurope", "francecentral", "francesouth", "germanywestcentral", "germanynorth",
+		"swedencentral", "swedensouth", "norwayeast", "norwaywest", "switzerlandnorth", "switzerlandwest",
+		"italynorth", "polandcentral", "spaincentral",
+	},
+}
+
+var (
+	// DataBoundary the configured geo boundary, empty when enforcement is off
+	DataBoundary string
+	// DataBoundaryRegionSet regions within the configured boundary
+	DataBoundaryRegionSet map[string]bool
+	// DataBoundaryADXBlocked when true, container logs are not sent to the ADX cluster since it is outside the boundary
+	DataBoundaryADXBlocked bool
+	// DataBoundaryWorkspaceBlocked when true, no data is sent to the workspace since its region is unknown or outside
+	// the boundary. The other destinations are checked on their own
+	DataBoundaryWorkspaceBlocked bool
+)
+
+// regional ingestion endpoints look like https://westeurope-5.in.applicationinsights.azure.com/v2/track
+var appInsightsRegionalHostRegex = regexp.MustCompile(`^([a-z0-9]+?)(-\d+)?\.in\.applicationinsights\.azure\.com$`)
+
+// the logs ingestion endpoints of the DCEs look like https://mydce-a1b2.westeurope-1.ingest.monitor.azure.com
+var dceIngestionHostRegex = regexp.MustCompile(`^[a-z0-9-]+\.([a-z0-9]+?)(-\d+)?\.ingest\.monitor\.azure\.com$`)
+
+// parseDataBoundaryRegions returns the regions of the boundary, with the explicitly configured regions taking precedence.
+// An unknown boundary without explicit regions has no regions, so every destination is refused
+func parseDataBoundaryRegions(boundary string, regions string) map[string]bool {
+	regionSet := make(map[string]bool)
+	for _, region := range strings.Split(regions, ",") {
+		if normalizeRegion(region) != "" {
+			regionSet[normalizeRegion(region)] = true
+		}
+	}
+	if len(regionSet) > 0 {
+		return regionSet
+	}
+	for _, region := range dataBoundaryRegions[strings.ToLower(strings.TrimSpace(boundary))] {
+		regionSet[region] = true
+	}
+	return regionSet
+}
+
+// regionFromADXClusterUri returns the region of an ADX cluster uri like https://mycluster.westeurope.kusto.windows.net
+func regionFromADXClusterUri(clusterUri string) string {
+	u, err := url.Parse(strings.TrimSpace(clusterUri))
+	if err != nil {
+		return ""
+	}
+	labels := strings.Split(strings.ToLower(u.Hostname()), ".")
+	if len(labels) < 5 || !strings.HasSuffix(strings.ToLower(u.Hostname()), ".kusto.windows.net") {
+		return ""
+	}
+	return labels[1]
+}
+
+// regionFromAppInsightsEndpoint returns the region of a regional AppInsights ingestion endpoint, empty for the global one
+func regionFromAppInsightsEndpoint(endpoint string) string {
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil {
+		return ""
+	}
+	match := appInsightsRegionalHostRegex.FindStringSubmatch(strings.ToLower(u.Hostname()))
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// regionFromDCEEndpoint returns the region of the logs ingestion endpoint of a DCE
+func regionFromDCEEndpoint(endpoint string) string {
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil {
+		return ""
+	}
+	match := dceIngestionHostRegex.FindStringSubmatch(strings.ToLower(u.Hostname()))
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// isWithinDataBoundary returns whether the region is in the configured boundary. Unknown regions are outside of it
+func isWithinDataBoundary(region string) bool {
+	region = normalizeRegion(region)
+	return region != "" && DataBoundaryRegionSet[region]
+}
+
+// initializeDataBoundary reads the boundary configuration and refuses the telemetry destination when it is outside
+// the boundary. This runs right after the telemetry client is initialized and before any telemetry is sent
+func initializeDataBoundary() {
+	DataBoundary = strings.ToLower(strings.TrimSpace(os.Getenv(envDataBoundary)))
+	DataBoundaryRegionSet = make(map[string]bool)
+	DataBoundaryADXBlocked = false
+	DataBoundaryWorkspaceBlocked = false
+	if DataBoundary == "" {
+		return
+	}
+	DataBoundaryRegionSet = parseDataBoundaryRegions(DataBoundary, os.Getenv(envDataBoundaryRegions))
+	if len(DataBoundaryRegionSet) == 0 {
+		Log("Error::databoundary::Unknown data boundary %s without configured regions, all destinations will be refused", DataBoundary)
+	}
+	Log("Info::databoundary::Enforcing data boundary %s", DataBoundary)
+	fmt.Fprintf(os.Stdout, "Enforcing data boundary %s\n", DataBoundary)
+
+	appInsightsEndpoint := os.Getenv(envAppInsightsEndpoint)
+	if appInsightsEndpoint == "" {
+		appInsightsEndpoint = defaultAppInsightsEndpoint
+	}
+	if !isWithinDataBoundary(regionFromAppInsightsEndpoint(appInsightsEndpoint)) {
+		if TelemetryClient != nil {
+			TelemetryClient.SetIsEnabled(false)
+		}
+		refuseDataBoundaryDestination("telemetry", appInsightsEndpoint)
+	}
+}
+
+// enforceWorkspaceDataBoundary refuses the workspace (ODS and mdsd routes) when its region is unknown or outside the boundary
+func enforceWorkspaceDataBoundary() {
+	if DataBoundary == "" {
+		return
+	}
+	workspaceRegion := getWorkspaceRegion()
+	if !isWithinDataBoundary(workspaceRegion) {
+		DataBoundaryWorkspaceBlocked = true
+		refuseDataBoundaryDestination("workspace", fmt.Sprintf("%s (region '%s')", WorkspaceID, normalizeRegion(workspaceRegion)))
+	}
+}
+
+// enforceADXDataBoundary refuses the ADX cluster when its region cannot be determined or is outside the boundary. The
+// clusters of the declared ADX sinks are checked when the sinks are created
+func enforceADXDataBoundary() {
+	if DataBoundary == "" || ContainerLogsRouteADX == false || len(ContainerLogSinkDeclarations) > 0 {
+		return
+	}
+	if !isWithinDataBoundary(regionFromADXClusterUri(AdxClusterUri)) {
+		DataBoundaryADXBlocked = true
+		refuseDataBoundaryDestination("ADX cluster", AdxClusterUri)
+	}
+}
+
+func refuseDataBoundaryDestination(destination string, detail string) {
+	message := fmt.Sprintf("Refusing %s destination %s since it is outside of the %s data boundary", destination, detail, DataBoundary)
+	Log("Error::databoundary::%s", message)
+	fmt.Fprintf(os.Stdout, "%s\n", message)
+	addDataResidencyEvent(message)
+}
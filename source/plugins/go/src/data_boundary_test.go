@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_regionFromADXClusterUri(t *testing.T) {
+	type test_struct struct {
+		testname string
+		uri      string
+		output   string
+	}
+
+	tests := []test_struct{
+		{"cluster uri", "https://mycluster.westeurope.kusto.windows.net", "westeurope"},
+		{"ingest uri", "https://ingest-mycluster.northeurope.kusto.windows.net/", "northeurope"},
+		{"no region", "https://mycluster.kusto.windows.net", ""},
+		{"other domain", "https://mycluster.westeurope.example.com", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got := regionFromADXClusterUri(tt.uri)
+			if got != tt.output {
+				t.Errorf("regionFromADXClusterUri(%s) = %s, want %s", tt.uri, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_regionFromAppInsightsEndpoint(t *testing.T) {
+	type test_struct struct {
+		testname string
+		endpoint string
+		output   string
+	}
+
+	tests := []test_struct{
+		{"regional endpoint", "https://westeurope-5.in.applicationinsights.azure.com/v2/track", "westeurope"},
+		{"regional endpoint without index", "https://francecentral.in.applicationinsights.azure.com/v2/track", "francecentral"},
+		{"global endpoint", "https://dc.services.visualstudio.com/v2/track", ""},
+		{"sovereign endpoint", "https://dc.applicationinsights.azure.cn/v2/track", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got := regionFromAppInsightsEndpoint(tt.endpoint)
+			if got != tt.output {
+				t.Errorf("regionFromAppInsightsEndpoint(%s) = %s, want %s", tt.endpoint, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_regionFromDCEEndpoint(t *testing.T) {
+	type test_struct struct {
+		testname string
+		endpoint string
+		output   string
+	}
+
+	tests := []test_struct{
+		{"regional endpoint", "https://mydce-a1b2.westeurope-1.ingest.monitor.azure.com", "westeurope"},
+		{"endpoint without index", "https://mydce-a1b2.francecentral.ingest.monitor.azure.com/", "francecentral"},
+		{"private link endpoint", "https://mydce-a1b2.privatelink.monitor.azure.com", ""},
+		{"invalid endpoint", "not a url", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got := regionFromDCEEndpoint(tt.endpoint)
+			if got != tt.output {
+				t.Errorf("regionFromDCEEndpoint(%s) = %s, want %s", tt.endpoint, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_parseDataBoundaryRegions(t *testing.T) {
+	type test_struct struct {
+		testname string
+		boundary string
+		regions  string
+		region   string
+		output   bool
+	}
+
+	tests := []test_struct{
+		{"eu region", "eu", "", "West Europe", true},
+		{"non eu region", "EU", "", "eastus", false},
+		{"configured regions override", "eu", "uksouth, ukwest", "uksouth", true},
+		{"configured regions exclude built-in", "eu", "uksouth", "westeurope", false},
+		{"unknown boundary", "mars", "", "westeurope", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got := parseDataBoundaryRegions(tt.boundary, tt.regions)[normalizeRegion(tt.region)]
+			if got != tt.output {
+				t.Errorf("parseDataBoundaryRegions(%s, %s)[%s] = %t, want %t", tt.boundary, tt.regions, tt.region, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_enforceWorkspaceDataBoundary(t *testing.T) {
+	type test_struct struct {
+		testname        string
+		workspaceRegion string
+		blocked         bool
+	}
+
+	tests := []test_struct{
+		{"workspace within the boundary", "westeurope", false},
+		{"workspace outside of the boundary", "eastus", true},
+		{"unknown workspace region", "", true},
+	}
+
+	defer func() {
+		DataBoundary, DataBoundaryWorkspaceBlocked = "", false
+		os.Unsetenv(envWorkspaceRegion)
+	}()
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			DataBoundary, DataBoundaryRegionSet = "eu", parseDataBoundaryRegions("eu", "")
+			DataBoundaryWorkspaceBlocked, DataResidencyBlocked = false, false
+			DataResidencyEvent = make(map[string]KubeMonAgentEventTags)
+			os.Setenv(envWorkspaceRegion, tt.workspaceRegion)
+			enforceWorkspaceDataBoundary()
+			if DataBoundaryWorkspaceBlocked != tt.blocked {
+				t.Errorf("enforceWorkspaceDataBoundary() blocked the workspace = %v, want %v", DataBoundaryWorkspaceBlocked, tt.blocked)
+			}
+			if DataResidencyBlocked {
+				t.Errorf("enforceWorkspaceDataBoundary() blocked the workspace region policy")
+			}
+			if (len(DataResidencyEvent) > 0) != tt.blocked {
+				t.Errorf("enforceWorkspaceDataBoundary() recorded %d events", len(DataResidencyEvent))
+			}
+		})
+	}
+}
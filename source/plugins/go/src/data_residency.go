@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DataResidencyEventCategory is the KubeMonAgentEvent category for workspace region policy violations
+const DataResidencyEventCategory = "container.azm.ms/dataresidency"
+
+// env variables for the workspace region policy
+const (
+	envWorkspaceRegion             = "AZMON_WORKSPACE_REGION"
+	envAllowedWorkspaceRegions     = "AZMON_ALLOWED_WORKSPACE_REGIONS"
+	envWorkspaceRegionPolicyMode   = "AZMON_WORKSPACE_REGION_POLICY_MODE"
+	envClusterRegion               = "AKS_REGION"
+	workspaceRegionPolicyModeWarn  = "warn"
+	workspaceRegionPolicyModeBlock = "block"
+)
+
+const eventNameDataResidencyViolation = "WorkspaceRegionPolicyViolationEvent"
+
+var (
+	// DataResidencyEvent hash of region policy violations, kept for the lifetime of the container like config errors
+	DataResidencyEvent map[string]KubeMonAgentEventTags
+	// DataResidencyBlocked when true, no data is sent to the workspace since its region violates the policy in block mode
+	DataResidencyBlocked bool
+)
+
+// normalizeRegion converts display names like "East US" to the region name "eastus"
+func normalizeRegion(region string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(region), " ", "", -1))
+}
+
+// evaluateWorkspaceRegionPolicy returns an empty string when the workspace region complies with the policy, and the
+// violation otherwise. Without an allowed regions list, the workspace must be in the same region as the cluster
+func evaluateWorkspaceRegionPolicy(workspaceRegion string, clusterRegion string, allowedRegions []string) string {
+	workspaceRegion = normalizeRegion(workspaceRegion)
+	clusterRegion = normalizeRegion(clusterRegion)
+	if len(allowedRegions) > 0 {
+		for _, allowedRegion := range allowedRegions {
+			if normalizeRegion(allowedRegion) == workspaceRegion {
+				return ""
+			}
+		}
+		return fmt.Sprintf("Workspace region %s is not in the allowed regions %s", workspaceRegion, strings.Join(allowedRegions, ","))
+	}
+	if clusterRegion != "" && workspaceRegion != clusterRegion {
+		return fmt.Sprintf("Workspace region %s does not match the cluster region %s", workspaceRegion, clusterRegion)
+	}
+	return ""
+}
+
+// validateWorkspaceRegion checks the workspace region against the cluster region and allowed regions policy at startup
+func validateWorkspaceRegion() {
+	DataResidencyBlocked = false
+
+	workspaceRegion := getWorkspaceRegion()
+	var allowedRegions []string
+	for _, region := range strings.Split(os.Getenv(envAllowedWorkspaceRegions), ",") {
+		if strings.TrimSpace(region) != "" {
+			allowedRegions = append(allowedRegions, strings.TrimSpace(region))
+		}
+	}
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv(envWorkspaceRegionPolicyMode)))
+	if mode != workspaceRegionPolicyModeBlock {
+		mode = workspaceRegionPolicyModeWarn
+	}
+	clusterRegion := os.Getenv(envClusterRegion)
+
+	if workspaceRegion == "" {
+		if len(allowedRegions) > 0 {
+			message := "Workspace region is unknown, unable to validate it against the allowed regions"
+			Log("Warning::dataresidency::%s", message)
+			addDataResidencyEvent(message)
+		} else {
+			Log("Info::dataresidency::Workspace region is not configured, skipping region validation")
+		}
+		return
+	}
+
+	violation := evaluateWorkspaceRegionPolicy(workspaceRegion, clusterRegion, allowedRegions)
+	if violation == "" {
+		Log("Info::dataresidency::Workspace region %s complies with the region policy", normalizeRegion(workspaceRegion))
+		return
+	}
+
+	telemetryDimensions := make(map[string]string)
+	telemetryDimensions["WorkspaceRegion"] = normalizeRegion(workspaceRegion)
+	telemetryDimensions["ClusterRegion"] = normalizeRegion(clusterRegion)
+	telemetryDimensions["PolicyMode"] = mode
+	SendEvent(eventNameDataResidencyViolation, telemetryDimensions)
+
+	if mode == workspaceRegionPolicyModeBlock {
+		DataResidencyBlocked = true
+		message := fmt.Sprintf("%s. Data will not be sent to the workspace since the region policy mode is %s", violation, mode)
+		Log("Error::dataresidency::%s", message)
+		fmt.Fprintf(os.Stdout, "%s\n", message)
+		addDataResidencyEvent(message)
+	} else {
+		Log("Warning::dataresidency::%s", violation)
+		addDataResidencyEvent(violation)
+	}
+}
+
+// getWorkspaceRegion returns the workspace region set in the env, empty when it is not set
+func getWorkspaceRegion() string {
+	return strings.TrimSpace(os.Getenv(envWorkspaceRegion))
+}
+
+// flushDataResidencyEventRecords sends the region policy events alone while the workspace is blocked by the region
+// policy, so the workspace shows why no other data is sent to it. They hold no data collected from the cluster
+func flushDataResidencyEventRecords() {
+	start := time.Now()
+	EventHashUpdateMutex.Lock()
+	laKubeMonAgentEventsRecords, msgPackEntries := buildKubeMonAgentEventRecords(DataResidencyEvent, DataResidencyEventCategory, KubeMonAgentEventWarning, start)
+	telemetryDimensions := map[string]string{"DataResidencyEventCount": strconv.Itoa(len(DataResidencyEvent))}
+	EventHashUpdateMutex.Unlock()
+	if len(laKubeMonAgentEventsRecords) == 0 {
+		return
+	}
+	ctx, stopWatchdog := startFlushWatchdog("flushKubeMonAgentEventRecords")
+	defer stopWatchdog()
+	sendKubeMonAgentEventRecords(ctx, laKubeMonAgentEventsRecords, msgPackEntries, start, telemetryDimensions)
+}
+
+// countDataResidencyDroppedRecords counts the container log records dropped while the workspace is blocked by the
+// region policy
+func countDataResidencyDroppedRecords(numRecords int) {
+	ContainerLogTelemetryMutex.Lock()
+	defer ContainerLogTelemetryMutex.Unlock()
+	DataResidencyDroppedRecordCount += float64(numRecords)
+}
+
+func addDataResidencyEvent(message string) {
+	eventTimeStamp := time.Now().UTC().Format(time.RFC3339)
+	EventHashUpdateMutex.Lock()
+	defer EventHashUpdateMutex.Unlock()
+	DataResidencyEvent[message] = KubeMonAgentEventTags{
+		FirstOccurrence: eventTimeStamp,
+		LastOccurrence:  eventTimeStamp,
+		Count:           1,
+	}
+}
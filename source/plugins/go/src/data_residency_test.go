@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+func Test_evaluateWorkspaceRegionPolicy(t *testing.T) {
+	type test_struct struct {
+		testname        string
+		workspaceRegion string
+		clusterRegion   string
+		allowedRegions  []string
+		compliant       bool
+	}
+
+	tests := []test_struct{
+		{"same region", "eastus", "eastus", nil, true},
+		{"same region display name", "East US", "eastus", nil, true},
+		{"different region", "westeurope", "eastus", nil, false},
+		{"unknown cluster region", "westeurope", "", nil, true},
+		{"allowed region", "northeurope", "westeurope", []string{"westeurope", "North Europe"}, true},
+		{"not allowed region", "eastus", "eastus", []string{"westeurope", "northeurope"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got := evaluateWorkspaceRegionPolicy(tt.workspaceRegion, tt.clusterRegion, tt.allowedRegions)
+			if (got == "") != tt.compliant {
+				t.Errorf("evaluateWorkspaceRegionPolicy(%s, %s, %v) = '%s', want compliant %t", tt.workspaceRegion, tt.clusterRegion, tt.allowedRegions, got, tt.compliant)
+			}
+		})
+	}
+}
+
+func Test_postDataHelperDataResidencyBlocked(t *testing.T) {
+	defer func() { DataResidencyBlocked = false }()
+	DataResidencyBlocked = true
+	DataResidencyDroppedRecordCount = 0
+	records := []map[interface{}]interface{}{{"log": []byte("line 1")}, {"log": []byte("line 2")}}
+	if retCode := postDataHelper(context.Background(), records, "", nil); retCode != output.FLB_OK {
+		t.Errorf("postDataHelper() = %d, want FLB_OK", retCode)
+	}
+	if DataResidencyDroppedRecordCount != 2 {
+		t.Errorf("postDataHelper() counted %v dropped records, want 2", DataResidencyDroppedRecordCount)
+	}
+}
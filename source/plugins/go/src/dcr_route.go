@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+// settings of a DCR, read like the route settings: AZMON_DCR_<SETTING> or dcr_<setting> in the plugin configuration
+// for the dcr route, and AZMON_<NAME>_<SETTING> for a declared dcr sink
+const (
+	// the logs ingestion endpoint of the DCE, e.g. https://mydce-a1b2.westeurope-1.ingest.monitor.azure.com
+	dcrSettingEndpoint = "endpoint"
+	// the immutable id of the DCR, e.g. dcr-00000000000000000000000000000000
+	dcrSettingImmutableID = "immutable_id"
+	// the streams of the DCR the records are posted to
+	dcrSettingContainerLogsStream   = "container_logs_stream"
+	dcrSettingInsightsMetricsStream = "insights_metrics_stream"
+)
+
+const (
+	logsIngestionAPIVersion = "2023-01-01"
+	// under the 1 MB limit of a call of the Logs Ingestion API
+	dcrMaxPayloadBytes = 1000 * 1000
+
+	defaultDCRContainerLogStream    = "Custom-ContainerLog"
+	defaultDCRContainerLogV2Stream  = "Custom-ContainerLogV2"
+	defaultDCRInsightsMetricsStream = "Custom-InsightsMetrics"
+)
+
+var dcrImmutableIDRegex = regexp.MustCompile(`^dcr-[0-9a-f]{32}$`)
+
+// dcrConfig the DCR and DCE the records are posted to thru the Logs Ingestion API
+type dcrConfig struct {
+	endpoint    string
+	immutableID string
+	// the stream of the container logs, the one of the schema of the records when not set
+	containerLogsStream   string
+	insightsMetricsStream string
+	// the client with the client certificate or the CA bundle of the route, the LogsIngestionHTTPClient when nil
+	httpClient *http.Client
+}
+
+var (
+	// ContainerLogsRouteDCR when true, the container logs are posted to a DCR thru the Logs Ingestion API
+	ContainerLogsRouteDCR bool
+	// TelegrafMetricsRouteDCR when true, the telegraf metrics are posted to the InsightsMetrics stream of the DCR
+	TelegrafMetricsRouteDCR bool
+	// DCRRouteConfig the DCR of the dcr route
+	DCRRouteConfig dcrConfig
+)
+
+// newDCRConfig reads the DCR settings of the route or the declared sink. The sends are authenticated with the AAD auth
+// of the Logs Ingestion API
+func newDCRConfig(name string) (dcrConfig, error) {
+	config := dcrConfig{
+		endpoint:              strings.TrimSuffix(routeSetting(name, dcrSettingEndpoint), "/"),
+		immutableID:           strings.ToLower(routeSetting(name, dcrSettingImmutableID)),
+		containerLogsStream:   routeSetting(name, dcrSettingContainerLogsStream),
+		insightsMetricsStream: routeSetting(name, dcrSettingInsightsMetricsStream),
+	}
+	if config.insightsMetricsStream == "" {
+		config.insightsMetricsStream = defaultDCRInsightsMetricsStream
+	}
+	if !isValidUrl(config.endpoint) || !strings.HasPrefix(strings.ToLower(config.endpoint), "https://") {
+		return config, fmt.Errorf("invalid DCE endpoint %q, it must be an https url", config.endpoint)
+	}
+	if !dcrImmutableIDRegex.MatchString(config.immutableID) {
+		return config, fmt.Errorf("invalid DCR immutable id %q", config.immutableID)
+	}
+	if LogsIngestionTokenProvider == nil {
+		return config, fmt.Errorf("the Logs Ingestion API needs %s", envLogsIngestionAuthMode)
+	}
+	if DataBoundary != "" && !isWithinDataBoundary(regionFromDCEEndpoint(config.endpoint)) {
+		refuseDataBoundaryDestination("DCE", config.endpoint)
+		return config, fmt.Errorf("%s is outside of the %s data boundary", config.endpoint, DataBoundary)
+	}
+	tlsConfig, err := newRouteTLSConfig(name, "", "")
+	if err != nil {
+		return config, err
+	}
+	if tlsConfig != nil {
+		config.httpClient = newLogsIngestionHTTPClientWithTLS(tlsConfig)
+	}
+	return config, nil
+}
+
+// streamURL returns the url posting to the stream of the DCR
+func (c dcrConfig) streamURL(stream string) string {
+	return fmt.Sprintf("%s/dataCollectionRules/%s/streams/%s?api-version=%s", c.endpoint, c.immutableID, url.PathEscape(stream), logsIngestionAPIVersion)
+}
+
+// initializeDCRRoute reads the DCR of the dcr route, and returns whether the container logs can be sent thru it
+func initializeDCRRoute() bool {
+	config, err := newDCRConfig(ContainerLogsDCRRoute)
+	if err != nil {
+		Log("Error::dcr::Unable to route the container logs thru the %s route: %s", ContainerLogsDCRRoute, err.Error())
+		return false
+	}
+	DCRRouteConfig = config
+	Log("Routing container logs thru the DCR %s of %s", config.immutableID, config.endpoint)
+	return true
+}
+
+// initializeDCRMetricsRoute routes the telegraf metrics to the InsightsMetrics stream of the DCR on the dcr route
+func initializeDCRMetricsRoute() {
+	TelegrafMetricsRouteDCR = false
+	if ContainerLogsRouteDCR == false || DCRRouteConfig.endpoint == "" {
+		return
+	}
+	TelegrafMetricsRouteDCR = true
+	Log("Routing telegraf metrics thru the %s route to stream %s", ContainerLogsDCRRoute, DCRRouteConfig.insightsMetricsStream)
+}
+
+// dcrSink posts the records to a stream of a DCR thru the Logs Ingestion API, with the ContainerLog or the
+// ContainerLogV2 schema
+type dcrSink struct {
+	sinkHealth
+	name   string
+	config dcrConfig
+}
+
+// newDeclaredDCRSink creates a DCR sink from the endpoint, immutable_id and container_logs_stream settings of the sink
+func newDeclaredDCRSink(name string) (Sink, error) {
+	config, err := newDCRConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	return &dcrSink{name: name, config: config}, nil
+}
+
+func (s *dcrSink) Name() string { return s.name }
+
+func (s *dcrSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	return s.setSendResult(s.send(ctx, batch))
+}
+
+// send posts the batch, in chunks under the max payload size of the Logs Ingestion API
+func (s *dcrSink) send(ctx context.Context, batch *containerLogBatch) error {
+	schemaV2 := len(batch.dataItemsLAv2) > 0
+	stream := s.config.containerLogsStream
+	if stream == "" {
+		stream = defaultDCRContainerLogStream
+		if schemaV2 {
+			stream = defaultDCRContainerLogV2Stream
+		}
+	}
+	count := len(batch.dataItemsLAv1)
+	if schemaV2 {
+		count = len(batch.dataItemsLAv2)
+	}
+	marshal := func(start int, end int) ([]byte, error) {
+		var marshalled []byte
+		var err error
+		if schemaV2 {
+			marshalled, err = json.Marshal(batch.dataItemsLAv2[start:end])
+		} else {
+			marshalled, err = json.Marshal(batch.dataItemsLAv1[start:end])
+		}
+		if err != nil {
+			message := fmt.Sprintf("Error while Marshalling log Entry: %s", err.Error())
+			Log(message)
+			SendException(message)
+			return nil, errBatchDropped
+		}
+		return marshalled, nil
+	}
+	err := sendPayloadChunks(ctx, "PostDataHelper", count, dcrMaxPayloadBytes, marshal, func(start int, end int, payload []byte) error {
+		return sendUnsentPayload("PostDataHelper", s.name, batch.id, start, end, func() error {
+			return postToDCRStream(ctx, s.config, stream, getContainerLogsDataType(), payload, end-start)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	Log("PostDataHelper::Info::Successfully posted %d records to the stream %s of the DCR %s in %s", count, stream, s.config.immutableID, time.Since(batch.start))
+	return nil
+}
+
+// postToDCRStream posts a payload of records to the stream of the DCR
+func postToDCRStream(ctx context.Context, config dcrConfig, stream string, dataType string, payload []byte, numRecords int) error {
+	endpoint := config.streamURL(stream)
+	sendStart := time.Now()
+	resp, reqID, err := postToLogsIngestion(ctx, config.httpClient, endpoint, payload)
+	trackFlushDependency(dependencyTypeDCR, dependencyTarget(endpoint), dataType, sendStart, httpDependencyResultCode(resp, err), err == nil && resp != nil && resp.StatusCode/100 == 2, numRecords)
+	if err != nil {
+		Log("Error::dcr::Error when posting %d records to the stream %s: %s", numRecords, stream, err.Error())
+		return err
+	}
+	if resp == nil {
+		return errors.New("no response from the Logs Ingestion API")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		LogWith(pluginLogFields{Route: ContainerLogsDCRRoute, RequestID: reqID}, "Error::dcr::RequestId %s Status %s Status Code %d when posting %d records to the stream %s", reqID, resp.Status, resp.StatusCode, numRecords, stream)
+		return &sinkStatusError{statusCode: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	return nil
+}
+
+// sendTelegrafMetricsToDCR posts the metrics to the InsightsMetrics stream of the DCR of the route, the payloads posted
+// by an earlier delivery of the chunk are not posted again
+func sendTelegrafMetricsToDCR(ctx context.Context, laMetrics []*laTelegrafMetric, batchID string) int {
+	start := time.Now()
+	stream := DCRRouteConfig.insightsMetricsStream
+	marshal := func(start int, end int) ([]byte, error) {
+		jsonBytes, err := json.Marshal(laMetrics[start:end])
+		if err != nil {
+			message := fmt.Sprintf("PostTelegrafMetricsToLA::Error:when marshalling json %q", err)
+			Log(message)
+			SendException(message)
+			return nil, errBatchDropped
+		}
+		return jsonBytes, nil
+	}
+	err := sendPayloadChunks(ctx, "PostTelegrafMetricsToLA", len(laMetrics), dcrMaxPayloadBytes, marshal, func(start int, end int, payload []byte) error {
+		err := sendUnsentPayload("PostTelegrafMetricsToLA", ContainerLogsDCRRoute, batchID, start, end, func() error {
+			return postToDCRStream(ctx, DCRRouteConfig, stream, InsightsMetricsDataType, payload, end-start)
+		})
+		if isThrottled(err) {
+			UpdateNumTelegrafMetricsSentTelemetry(0, 1, 1)
+			return err
+		} else if err != nil {
+			UpdateNumTelegrafMetricsSentTelemetry(0, 1, 0)
+			return err
+		}
+		UpdateNumTelegrafMetricsSentTelemetry(end-start, 0, 0)
+		return nil
+	})
+	if err == errBatchDropped {
+		return output.FLB_OK
+	} else if err != nil {
+		Log("PostTelegrafMetricsToLA::Error:(retriable) when posting %d metrics to the stream %s: %s", len(laMetrics), stream, err.Error())
+		return output.FLB_RETRY
+	}
+	Log("PostTelegrafMetricsToLA::Info:Successfully posted %d metrics to the stream %s of the DCR %s in %s", len(laMetrics), stream, DCRRouteConfig.immutableID, time.Since(start))
+	return output.FLB_OK
+}
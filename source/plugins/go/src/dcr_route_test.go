@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_dcrSink(t *testing.T) {
+	type test_struct struct {
+		testName   string
+		stream     string
+		schemaV2   bool
+		status     int
+		wantPath   string
+		wantErr    bool
+		wantStatus int
+	}
+
+	immutableID := "dcr-0123456789abcdef0123456789abcdef"
+	tests := []test_struct{
+		{"v1 schema", "", false, 204, "/dataCollectionRules/" + immutableID + "/streams/Custom-ContainerLog", false, 0},
+		{"v2 schema", "", true, 204, "/dataCollectionRules/" + immutableID + "/streams/Custom-ContainerLogV2", false, 0},
+		{"configured stream", "Custom-MyLogs", true, 204, "/dataCollectionRules/" + immutableID + "/streams/Custom-MyLogs", false, 0},
+		{"throttled", "", false, 429, "/dataCollectionRules/" + immutableID + "/streams/Custom-ContainerLog", true, 429},
+	}
+
+	LogsIngestionTokenProvider = &TokenProvider{name: "test", fetch: func() (string, int64, error) {
+		return "token1", time.Now().Add(time.Hour).Unix(), nil
+	}, refreshBefore: aadTokenRefreshBuffer}
+	LogsIngestionHTTPClient = &http.Client{}
+	defer func() { LogsIngestionTokenProvider, LogsIngestionHTTPClient = nil, nil }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var gotPath, gotAuthorization string
+			var gotRecords []map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				gotAuthorization = r.Header.Get("Authorization")
+				if r.URL.Query().Get("api-version") != logsIngestionAPIVersion {
+					t.Errorf("api-version = %s, want %s", r.URL.Query().Get("api-version"), logsIngestionAPIVersion)
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				if err := json.Unmarshal(body, &gotRecords); err != nil {
+					t.Errorf("the body is not an array of records: %s", string(body))
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			sink := &dcrSink{name: ContainerLogsDCRRoute, config: dcrConfig{endpoint: server.URL, immutableID: immutableID, containerLogsStream: tt.stream}}
+			batch := &containerLogBatch{start: time.Now()}
+			if tt.schemaV2 {
+				batch.dataItemsLAv2 = []DataItemLAv2{{LogMessage: "line1"}, {LogMessage: "line2"}}
+			} else {
+				batch.dataItemsLAv1 = []DataItemLAv1{{LogEntry: "line1"}, {LogEntry: "line2"}}
+			}
+			err := sink.Send(context.Background(), batch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if statusErr, ok := err.(*sinkStatusError); tt.wantErr && (!ok || statusErr.statusCode != tt.wantStatus) {
+				t.Errorf("Send() error = %v, want status code %d", err, tt.wantStatus)
+			}
+			if sink.Healthy() == tt.wantErr {
+				t.Errorf("Healthy() = %v after the send", sink.Healthy())
+			}
+			if gotPath != tt.wantPath || gotAuthorization != "Bearer token1" || len(gotRecords) != 2 {
+				t.Errorf("Send() posted %d records to %s with %q, want 2 records to %s with the bearer token", len(gotRecords), gotPath, gotAuthorization, tt.wantPath)
+			}
+		})
+	}
+}
+
+func Test_newDCRConfig(t *testing.T) {
+	type test_struct struct {
+		testName    string
+		endpoint    string
+		immutableID string
+		auth        bool
+		wantErr     bool
+	}
+
+	tests := []test_struct{
+		{"valid", "https://mydce-a1b2.westeurope-1.ingest.monitor.azure.com/", "DCR-0123456789ABCDEF0123456789ABCDEF", true, false},
+		{"http endpoint", "http://mydce-a1b2.westeurope-1.ingest.monitor.azure.com", "dcr-0123456789abcdef0123456789abcdef", true, true},
+		{"no endpoint", "", "dcr-0123456789abcdef0123456789abcdef", true, true},
+		{"invalid immutable id", "https://mydce-a1b2.westeurope-1.ingest.monitor.azure.com", "mydcr", true, true},
+		{"no auth", "https://mydce-a1b2.westeurope-1.ingest.monitor.azure.com", "dcr-0123456789abcdef0123456789abcdef", false, true},
+	}
+
+	defer func() { PluginConfiguration, LogsIngestionTokenProvider = nil, nil }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			PluginConfiguration = map[string]string{"dcr_endpoint": tt.endpoint, "dcr_immutable_id": tt.immutableID}
+			LogsIngestionTokenProvider = nil
+			if tt.auth {
+				LogsIngestionTokenProvider = &TokenProvider{name: "test"}
+			}
+			config, err := newDCRConfig(ContainerLogsDCRRoute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newDCRConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if strings.HasSuffix(config.endpoint, "/") || config.immutableID != strings.ToLower(tt.immutableID) || config.insightsMetricsStream != defaultDCRInsightsMetricsStream {
+				t.Errorf("newDCRConfig() = %+v", config)
+			}
+		})
+	}
+}
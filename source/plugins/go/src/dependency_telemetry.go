@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+)
+
+// ratio of the outbound flushes reported as dependency telemetry, between 0 (off) and 1
+const envDependencyTelemetrySamplingRatio = "AZMON_DEPENDENCY_TELEMETRY_SAMPLING_RATIO"
+
+const defaultDependencyTelemetrySamplingRatio = 0.01
+
+// dependency types of the flush backends
+const (
+	dependencyTypeODS       = "ODS"
+	dependencyTypeMDSD      = "MDSD"
+	dependencyTypeADX       = "ADX"
+	dependencyTypeDCR       = "LogsIngestion"
+	dependencyTypeKafka     = "Kafka"
+	dependencyTypeEventHubs = "Azure Event Hubs"
+	dependencyTypeOTLP      = "OTLP"
+	dependencyTypeMDM       = "Azure Monitor Metrics"
+)
+
+const dependencyResultCodeError = "error"
+
+var dependencyTelemetrySamplingRatio = defaultDependencyTelemetrySamplingRatio
+
+// initializeDependencyTelemetry reads the sampling ratio of the flush dependency telemetry
+func initializeDependencyTelemetry() {
+	dependencyTelemetrySamplingRatio = defaultDependencyTelemetrySamplingRatio
+	value := strings.TrimSpace(os.Getenv(envDependencyTelemetrySamplingRatio))
+	if value == "" {
+		return
+	}
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		Log("Invalid value %s for %s, using the default sampling ratio %.2f", value, envDependencyTelemetrySamplingRatio, defaultDependencyTelemetrySamplingRatio)
+		return
+	}
+	dependencyTelemetrySamplingRatio = ratio
+	Log("Reporting %.2f of the flushes as dependency telemetry", dependencyTelemetrySamplingRatio)
+}
+
+// trackFlushDependency reports a sampled outbound flush (backend, target, duration and result) as dependency telemetry
+// to the agent's AppInsights resource
+func trackFlushDependency(dependencyType string, target string, dataType string, start time.Time, resultCode string, success bool, numRecords int) {
+	if TelemetryClient == nil || dependencyTelemetrySamplingRatio <= 0 || rand.Float64() >= dependencyTelemetrySamplingRatio {
+		return
+	}
+	dependency := appinsights.NewRemoteDependencyTelemetry(dataType, dependencyType, target, success)
+	dependency.Timestamp = start
+	dependency.Duration = time.Since(start)
+	dependency.ResultCode = resultCode
+	dependency.Properties["DataType"] = dataType
+	dependency.Properties["SamplingRatio"] = strconv.FormatFloat(dependencyTelemetrySamplingRatio, 'f', -1, 64)
+	dependency.Measurements["Records"] = float64(numRecords)
+	TelemetryClient.Track(dependency)
+}
+
+// httpDependencyResultCode returns the status code of the response, or error when the request failed
+func httpDependencyResultCode(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return dependencyResultCodeError
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// errorDependencyResultCode returns the result code of a send without status code (mdsd socket, ADX ingestion)
+func errorDependencyResultCode(err error) string {
+	if err != nil {
+		return dependencyResultCodeError
+	}
+	return "ok"
+}
+
+// dependencyTarget returns the host of the endpoint, so the query strings and paths don't end up in the telemetry
+func dependencyTarget(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return endpoint
+	}
+	return u.Host
+}
+
+// getContainerLogsDataType returns the data type of the container logs for the configured schema
+func getContainerLogsDataType() string {
+	if ContainerLogSchemaV2 == true {
+		return ContainerLogV2DataType
+	}
+	return ContainerLogDataType
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_dependencyTarget(t *testing.T) {
+	type test_struct struct {
+		testname string
+		endpoint string
+		output   string
+	}
+
+	tests := []test_struct{
+		{"ODS endpoint", "https://00000000-0000-0000-0000-000000000000.ods.opinsights.azure.com/OperationalData.svc/PostJsonDataItems", "00000000-0000-0000-0000-000000000000.ods.opinsights.azure.com"},
+		{"ADX cluster with query", "https://cluster.westeurope.kusto.windows.net/?sig=secret", "cluster.westeurope.kusto.windows.net"},
+		{"socket path", "/var/run/mdsd/default_fluent.socket", "/var/run/mdsd/default_fluent.socket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := dependencyTarget(tt.endpoint); got != tt.output {
+				t.Errorf("dependencyTarget(%s) = %s, want %s", tt.endpoint, got, tt.output)
+			}
+		})
+	}
+}
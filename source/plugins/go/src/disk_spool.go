@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+// env variables of the disk spool of the container log chunks which can't be delivered
+const (
+	// directory the chunks are spooled to. The spool is off when not set
+	envSpoolDir = "AZMON_SPOOL_DIR"
+	// cap on the size of the spool, in MB
+	envSpoolMaxMB = "AZMON_SPOOL_MAX_MB"
+	// how long (seconds) the route fails before the failed chunks are spooled instead of retried by fluent-bit
+	envSpoolAfterSeconds = "AZMON_SPOOL_AFTER_SECONDS"
+	// interval (seconds) at which the spooled chunks are replayed once the route recovers
+	envSpoolReplayIntervalSeconds = "AZMON_SPOOL_REPLAY_INTERVAL_SECONDS"
+)
+
+const (
+	defaultSpoolMaxMB                 = 500
+	defaultSpoolAfterSeconds          = 60
+	defaultSpoolReplayIntervalSeconds = 30
+	spoolFileExtension                = ".json"
+)
+
+var (
+	// SpoolDir the spool directory, empty when the spool is off
+	SpoolDir string
+	// SpoolMaxBytes the cap on the bytes of the spool
+	SpoolMaxBytes int64
+	// SpoolAfter how long the route fails before the failed chunks are spooled
+	SpoolAfter time.Duration
+	// SpoolReplayTicker to replay the spooled chunks periodically
+	SpoolReplayTicker *time.Ticker
+
+	// SpoolMutex guards the spool size, the file sequence and the failures of the route
+	SpoolMutex         = &sync.Mutex{}
+	spoolSize          int64
+	spoolSequence      int64
+	spoolFailingSince  time.Time
+	spoolReplayPending bool
+)
+
+// initializeDiskSpool reads the spool configuration, and the size of the chunks spooled before a restart
+func initializeDiskSpool() {
+	SpoolDir = strings.TrimSpace(os.Getenv(envSpoolDir))
+	if SpoolDir == "" {
+		return
+	}
+	maxMB := defaultSpoolMaxMB
+	if value := strings.TrimSpace(os.Getenv(envSpoolMaxMB)); value != "" {
+		if mb, err := strconv.Atoi(value); err == nil && mb > 0 {
+			maxMB = mb
+		} else {
+			Log("Invalid value %s for %s, using the default %d MB", value, envSpoolMaxMB, defaultSpoolMaxMB)
+		}
+	}
+	SpoolMaxBytes = int64(maxMB) * 1024 * 1024
+	SpoolAfter = readSpoolSeconds(envSpoolAfterSeconds, defaultSpoolAfterSeconds)
+	if err := os.MkdirAll(SpoolDir, 0755); err != nil {
+		Log("Error::spool::Unable to create the spool directory %s, the failed chunks are retried: %s", SpoolDir, err.Error())
+		SpoolDir = ""
+		return
+	}
+	files, err := listSpoolFiles()
+	if err != nil {
+		Log("Error::spool::Unable to read the spool directory %s, the failed chunks are retried: %s", SpoolDir, err.Error())
+		SpoolDir = ""
+		return
+	}
+	SpoolMutex.Lock()
+	spoolSize = 0
+	for _, file := range files {
+		spoolSize += file.Size()
+	}
+	spoolReplayPending = len(files) > 0
+	SpoolMutex.Unlock()
+	updateSpoolDepthTelemetry()
+	Log("Spooling the container log chunks to %s (max %d MB) after %s of failures, %d chunks spooled before the start", SpoolDir, maxMB, SpoolAfter, len(files))
+}
+
+func readSpoolSeconds(name string, defaultSeconds int) time.Duration {
+	seconds := defaultSeconds
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			seconds = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d seconds", value, name, defaultSeconds)
+		}
+	}
+	return time.Second * time.Duration(seconds)
+}
+
+// startSpoolReplay replays the spooled chunks periodically when the spool is on
+func startSpoolReplay() {
+	if SpoolDir == "" {
+		return
+	}
+	SpoolReplayTicker = time.NewTicker(readSpoolSeconds(envSpoolReplayIntervalSeconds, defaultSpoolReplayIntervalSeconds))
+	go func() {
+		for range SpoolReplayTicker.C {
+			replaySpool()
+		}
+	}()
+}
+
+// trackSpoolOutcome tracks since when the flushes of the container logs fail, including the replays of the spool
+func trackSpoolOutcome(retCode int, now time.Time) {
+	if SpoolDir == "" {
+		return
+	}
+	SpoolMutex.Lock()
+	defer SpoolMutex.Unlock()
+	if retCode == output.FLB_OK {
+		spoolFailingSince = time.Time{}
+	} else if retCode == output.FLB_RETRY && spoolFailingSince.IsZero() {
+		spoolFailingSince = now
+	}
+}
+
+// spoolFailedChunk spools a chunk which failed to flush when the flushes have failed for longer than the spool delay,
+// so it is replayed from the disk instead of being retried by fluent-bit. Returns FLB_OK when the chunk was spooled
+func spoolFailedChunk(route string, records []map[interface{}]interface{}, now time.Time) int {
+	if SpoolDir == "" {
+		return output.FLB_RETRY
+	}
+	SpoolMutex.Lock()
+	failingFor := now.Sub(spoolFailingSince)
+	failing := !spoolFailingSince.IsZero()
+	SpoolMutex.Unlock()
+	if !failing || failingFor < SpoolAfter {
+		return output.FLB_RETRY
+	}
+	if err := writeSpoolChunk(route, records, now); err != nil {
+		Log("Error::spool::Unable to spool %d records, will retry: %s", len(records), err.Error())
+		return output.FLB_RETRY
+	}
+	// the chunk is not retried by fluent-bit anymore
+	recordFlushOutcome(route, output.FLB_OK)
+	Log("PostDataHelper::Warning::spooled %d records since the %s route has failed for %s", len(records), route, failingFor)
+	ContainerLogTelemetryMutex.Lock()
+	SpooledChunkCount += 1
+	ContainerLogTelemetryMutex.Unlock()
+	return output.FLB_OK
+}
+
+// writeSpoolChunk writes the records of a chunk as json lines to the spool. The file names sort in the order of the
+// chunks
+func writeSpoolChunk(route string, records []map[interface{}]interface{}, now time.Time) error {
+	var buffer bytes.Buffer
+	for _, record := range records {
+		line, err := json.Marshal(toDeadLetterRecord(record))
+		if err != nil {
+			return err
+		}
+		buffer.Write(line)
+		buffer.WriteString("\n")
+	}
+
+	SpoolMutex.Lock()
+	defer SpoolMutex.Unlock()
+	if spoolSize+int64(buffer.Len()) > SpoolMaxBytes {
+		return fmt.Errorf("the spool %s is full (%d MB)", SpoolDir, SpoolMaxBytes/1024/1024)
+	}
+	spoolSequence++
+	fileName := filepath.Join(SpoolDir, fmt.Sprintf("%020d-%06d-%s%s", now.UnixNano(), spoolSequence%1000000, route, spoolFileExtension))
+	if err := ioutil.WriteFile(fileName, buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+	spoolSize += int64(buffer.Len())
+	spoolReplayPending = true
+	ContainerLogTelemetryMutex.Lock()
+	SpoolDepthBytes = float64(spoolSize)
+	ContainerLogTelemetryMutex.Unlock()
+	return nil
+}
+
+// replaySpool sends the spooled chunks in order once the route is not failing, and stops at the first failure
+func replaySpool() {
+	SpoolMutex.Lock()
+	replay := spoolReplayPending && spoolFailingSince.IsZero()
+	SpoolMutex.Unlock()
+	if !replay {
+		return
+	}
+	files, err := listSpoolFiles()
+	if err != nil {
+		Log("Error::spool::Unable to read the spool directory %s: %s", SpoolDir, err.Error())
+		return
+	}
+	replayed := 0
+	for _, file := range files {
+		fileName := filepath.Join(SpoolDir, file.Name())
+		records, err := readSpoolChunk(fileName)
+		if err == nil && len(records) > 0 {
+			// a replay which fails stays in the spool, it is not spooled again. The spooled records are hashed for the
+			// batch id, the raw chunk not being kept
+			if retCode := flushContainerLogRecords(records, flushBatchID(records), false); retCode != output.FLB_OK {
+				break
+			}
+			replayed++
+		} else if err != nil {
+			Log("Error::spool::Dropping the spooled chunk %s which can't be read: %s", fileName, err.Error())
+		}
+		if err := os.Remove(fileName); err != nil {
+			Log("Error::spool::Unable to remove the spooled chunk %s: %s", fileName, err.Error())
+			break
+		}
+		SpoolMutex.Lock()
+		spoolSize -= file.Size()
+		if spoolSize < 0 {
+			spoolSize = 0
+		}
+		SpoolMutex.Unlock()
+	}
+
+	SpoolMutex.Lock()
+	if remaining, err := listSpoolFiles(); err == nil && len(remaining) == 0 {
+		spoolReplayPending = false
+	}
+	SpoolMutex.Unlock()
+	updateSpoolDepthTelemetry()
+	if replayed > 0 {
+		Log("PostDataHelper::Info::replayed %d spooled chunks", replayed)
+		ContainerLogTelemetryMutex.Lock()
+		SpoolReplayedChunkCount += float64(replayed)
+		ContainerLogTelemetryMutex.Unlock()
+	}
+}
+
+// listSpoolFiles returns the spooled chunks, oldest first
+func listSpoolFiles() ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(SpoolDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), spoolFileExtension) {
+			files = append(files, entry)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+// readSpoolChunk reads the records of a spooled chunk, as the records of fluent-bit
+func readSpoolChunk(fileName string) ([]map[interface{}]interface{}, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var records []map[interface{}]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		if converted, ok := fromSpoolRecord(record).(map[interface{}]interface{}); ok {
+			records = append(records, converted)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// fromSpoolRecord converts a json record back to the types of the fluent-bit records, the strings being bytes
+func fromSpoolRecord(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case map[string]interface{}:
+		record := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			record[key] = fromSpoolRecord(value)
+		}
+		return record
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			values[i] = fromSpoolRecord(value)
+		}
+		return values
+	}
+	return value
+}
+
+func updateSpoolDepthTelemetry() {
+	SpoolMutex.Lock()
+	depth := spoolSize
+	SpoolMutex.Unlock()
+	ContainerLogTelemetryMutex.Lock()
+	SpoolDepthBytes = float64(depth)
+	ContainerLogTelemetryMutex.Unlock()
+}
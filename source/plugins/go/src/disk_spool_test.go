@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+func Test_spoolFailedChunk(t *testing.T) {
+	type test_struct struct {
+		testname string
+		// the return codes of the flushes, one second apart, before the chunk is spooled
+		flushes       []int
+		maxBytes      int64
+		retCode       int
+		spooledChunks int
+	}
+
+	tests := []test_struct{
+		{"not failing", []int{output.FLB_OK}, 1024, output.FLB_RETRY, 0},
+		{"failing for less than the spool delay", []int{output.FLB_RETRY, output.FLB_RETRY}, 1024, output.FLB_RETRY, 0},
+		{"failing for longer than the spool delay", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY}, 1024, output.FLB_OK, 1},
+		{"recovered", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY, output.FLB_OK}, 1024, output.FLB_RETRY, 0},
+		{"spool full", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY}, 10, output.FLB_RETRY, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "spool")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			os.Setenv(envSpoolDir, dir)
+			os.Setenv(envSpoolAfterSeconds, "3")
+			defer os.Unsetenv(envSpoolDir)
+			defer os.Unsetenv(envSpoolAfterSeconds)
+			initializeDiskSpool()
+			SpoolMaxBytes = tt.maxBytes
+			spoolFailingSince = time.Time{}
+
+			now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+			for _, flush := range tt.flushes {
+				now = now.Add(time.Second)
+				trackSpoolOutcome(flush, now)
+			}
+			records := []map[interface{}]interface{}{{"log": []byte("hello\n"), "stream": []byte("stdout")}}
+			if retCode := spoolFailedChunk(ContainerLogsV1Route, records, now); retCode != tt.retCode {
+				t.Errorf("spoolFailedChunk() = %d, want %d", retCode, tt.retCode)
+			}
+			files, err := listSpoolFiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != tt.spooledChunks {
+				t.Errorf("%d chunks spooled, want %d", len(files), tt.spooledChunks)
+			}
+		})
+	}
+}
+
+func Test_readSpoolChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SpoolDir = dir
+	SpoolMaxBytes = 1024 * 1024
+	defer func() { SpoolDir = "" }()
+
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	first := []map[interface{}]interface{}{
+		{"log": []byte("hello\n"), "kubernetes": map[interface{}]interface{}{"pod_name": []byte("web-0")}},
+	}
+	second := []map[interface{}]interface{}{{"log": []byte("world\n")}, {"log": []byte("again\n")}}
+	for _, records := range [][]map[interface{}]interface{}{first, second} {
+		if err := writeSpoolChunk(ContainerLogsV1Route, records, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := listSpoolFiles()
+	if err != nil || len(files) != 2 {
+		t.Fatalf("listSpoolFiles() = %d files, %v, want 2 files", len(files), err)
+	}
+	for i, records := range [][]map[interface{}]interface{}{first, second} {
+		got, err := readSpoolChunk(filepath.Join(dir, files[i].Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, records) {
+			t.Errorf("readSpoolChunk() of chunk %d = %v, want %v", i, got, records)
+		}
+	}
+}
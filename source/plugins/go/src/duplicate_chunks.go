@@ -0,0 +1,145 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variables of the duplicate chunk detection
+const (
+	// chunks remembered per plugin, 0 disables the detection
+	envDuplicateChunkWindowSize = "AZMON_DUPLICATE_CHUNK_WINDOW_SIZE"
+	// how long a flushed chunk is remembered
+	envDuplicateChunkTTLSeconds = "AZMON_DUPLICATE_CHUNK_TTL_SECONDS"
+)
+
+const (
+	defaultDuplicateChunkWindowSize = 512
+	defaultDuplicateChunkTTL        = 5 * time.Minute
+)
+
+// recentChunk a chunk flushed successfully on a route
+type recentChunk struct {
+	key       string
+	flushedAt time.Time
+}
+
+// recentChunks is an LRU of the chunks flushed recently, so a chunk fluent-bit delivers again is not ingested twice
+type recentChunks struct {
+	mu       sync.Mutex
+	size     int
+	ttl      time.Duration
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+// RecentChunks the chunks flushed recently, nil when the duplicate detection is disabled
+var RecentChunks *recentChunks
+
+// initializeDuplicateChunkDetection reads the size and the ttl of the recently flushed chunks
+func initializeDuplicateChunkDetection() {
+	size := defaultDuplicateChunkWindowSize
+	if value := strings.TrimSpace(os.Getenv(envDuplicateChunkWindowSize)); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			size = n
+		} else {
+			Log("Invalid value %s for %s, using the default %d chunks", value, envDuplicateChunkWindowSize, defaultDuplicateChunkWindowSize)
+		}
+	}
+	ttl := defaultDuplicateChunkTTL
+	if value := strings.TrimSpace(os.Getenv(envDuplicateChunkTTLSeconds)); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			ttl = time.Duration(seconds) * time.Second
+		} else {
+			Log("Invalid value %s for %s, using the default %s", value, envDuplicateChunkTTLSeconds, defaultDuplicateChunkTTL)
+		}
+	}
+	RecentChunks = newRecentChunks(size, ttl)
+	Log("Duplicate chunk detection window = %d chunks, ttl = %s", size, ttl)
+}
+
+func newRecentChunks(size int, ttl time.Duration) *recentChunks {
+	if size <= 0 {
+		return nil
+	}
+	return &recentChunks{size: size, ttl: ttl, order: list.New(), elements: make(map[string]*list.Element)}
+}
+
+// contains returns whether the chunk was flushed on the route within the ttl
+func (r *recentChunks) contains(route string, id string, now time.Time) bool {
+	if r == nil || id == "" {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	element, ok := r.elements[route+"/"+id]
+	if !ok {
+		return false
+	}
+	if now.Sub(element.Value.(*recentChunk).flushedAt) > r.ttl {
+		r.order.Remove(element)
+		delete(r.elements, route+"/"+id)
+		return false
+	}
+	return true
+}
+
+// add records the chunk flushed on the route, evicting the least recently flushed chunk when full
+func (r *recentChunks) add(route string, id string, now time.Time) {
+	if r == nil || id == "" {
+		return
+	}
+	key := route + "/" + id
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if element, ok := r.elements[key]; ok {
+		element.Value.(*recentChunk).flushedAt = now
+		r.order.MoveToFront(element)
+		return
+	}
+	r.elements[key] = r.order.PushFront(&recentChunk{key: key, flushedAt: now})
+	if r.order.Len() > r.size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.elements, oldest.Value.(*recentChunk).key)
+	}
+}
+
+// flushBatchID returns the id of the records, empty when neither the checkpoint nor the duplicate detection needs it
+func flushBatchID(records []map[interface{}]interface{}) string {
+	if FlushCheckpoint == nil && RecentChunks == nil {
+		return ""
+	}
+	return recordsBatchID(records)
+}
+
+// flushChunkID returns the id of a raw msgpack chunk, empty when neither the checkpoint nor the duplicate detection
+// needs it
+func flushChunkID(chunk []byte) string {
+	if FlushCheckpoint == nil && RecentChunks == nil {
+		return ""
+	}
+	return rawChunkID(chunk)
+}
+
+// skipFlushedBatch returns whether the batch was already flushed on the route, recently or before a restart
+func skipFlushedBatch(caller string, route string, batchID string, numRecords int) bool {
+	if RecentChunks.contains(route, batchID, time.Now()) {
+		Log("%s::Info::skipping %d records of batch %s delivered again after it was flushed on the %s route", caller, numRecords, batchID, route)
+		ContainerLogTelemetryMutex.Lock()
+		DuplicateChunkCount += 1
+		ContainerLogTelemetryMutex.Unlock()
+		return true
+	}
+	return skipCheckpointedBatch(caller, route, batchID, numRecords)
+}
+
+// addFlushedBatch records the batch flushed successfully on the route
+func addFlushedBatch(route string, batchID string) {
+	RecentChunks.add(route, batchID, time.Now())
+	FlushCheckpoint.add(route, batchID)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_recentChunks(t *testing.T) {
+	type test_struct struct {
+		testname string
+		route    string
+		id       string
+		at       time.Duration
+		output   bool
+	}
+
+	// chunk-a and chunk-b are flushed on v2 at 0s, chunk-c evicts chunk-a
+	tests := []test_struct{
+		{"flushed recently", "v2", "chunk-b", time.Minute, true},
+		{"other route", "adx", "chunk-b", time.Minute, false},
+		{"evicted", "v2", "chunk-a", time.Minute, false},
+		{"expired", "v2", "chunk-c", 10 * time.Minute, false},
+		{"empty id", "v2", "", time.Minute, false},
+	}
+
+	start := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			chunks := newRecentChunks(2, 5*time.Minute)
+			for _, id := range []string{"chunk-a", "chunk-b", "chunk-c"} {
+				chunks.add("v2", id, start)
+			}
+			if got := chunks.contains(tt.route, tt.id, start.Add(tt.at)); got != tt.output {
+				t.Errorf("contains(%s, %q) = %v, want %v", tt.route, tt.id, got, tt.output)
+			}
+		})
+	}
+
+	if newRecentChunks(0, time.Minute).contains("v2", "chunk-a", start) {
+		t.Errorf("contains() = true with the detection disabled, want false")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const redactedConfigValue = "<redacted>"
+
+// the env variables of the agent in the effective configuration
+var effectiveConfigEnvPrefixes = []string{"AZMON_", "FBIT_", "AKS_", "ACS_", "APPLICATIONINSIGHTS_", "AZURE_", "TELEMETRY_", "CONTAINER_", "CONTROLLER_TYPE", "OS_TYPE", "WSID", "DOMAIN", "MCS_ENDPOINT", "PROXY", "NODE_NAME", "HOSTNAME"}
+
+// the keys whose values are secrets. The paths of the secret files are kept
+var sensitiveConfigKeyRegex = regexp.MustCompile(`(?i)(secret|token|password|passwd|key|auth|credential|proxy|connection_?string)`)
+
+// effectiveConfig the configuration the plugin resolved at startup
+type effectiveConfig struct {
+	Env                 map[string]string `json:"env"`
+	PluginConfiguration map[string]string `json:"pluginConfiguration"`
+	Routes              map[string]string `json:"routes"`
+	FeatureFlags        map[string]bool   `json:"featureFlags"`
+}
+
+// getEffectiveConfig returns the env variables, plugin configuration, routes and feature flags, with the secrets
+// redacted
+func getEffectiveConfig() effectiveConfig {
+	config := effectiveConfig{
+		Env:                 make(map[string]string),
+		PluginConfiguration: make(map[string]string),
+		Routes:              make(map[string]string),
+		FeatureFlags:        make(map[string]bool),
+	}
+	for _, entry := range os.Environ() {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || !isEffectiveConfigEnv(kv[0]) {
+			continue
+		}
+		config.Env[kv[0]] = redactConfigValue(kv[0], kv[1])
+	}
+	for key, value := range PluginConfiguration {
+		config.PluginConfiguration[key] = redactConfigValue(key, value)
+	}
+
+	config.Routes["container_logs"] = getContainerLogsRouteName()
+	config.Routes["agent_data"] = getAgentDataRouteName()
+	if ContainerLogSink != nil {
+		config.Routes["container_log_sink"] = ContainerLogSink.Name()
+	}
+	config.Routes["oms_endpoint"] = redactConfigValue("oms_endpoint", OMSEndpoint)
+	config.Routes["adx_cluster_uri"] = redactConfigValue("adx_cluster_uri", AdxClusterUri)
+	config.Routes["telegraf_metrics"] = getTelegrafMetricsRouteName()
+	config.Routes["dce_endpoint"] = redactConfigValue("dce_endpoint", DCRRouteConfig.endpoint)
+	config.Routes["kafka_topic"] = KafkaRouteConfig.topic
+	config.Routes["eventhubs_namespace"] = EventHubsRouteConfig.namespace
+	config.Routes["otlp_endpoint"] = OTLPRouteConfig.endpoint
+	config.Routes["mdm_endpoint"] = MDMMetricsEndpoint
+	config.Routes["container_runtime"] = getContainerRuntime()
+	config.Routes["container_metadata_source"] = ContainerMetadataSource
+	config.Routes["data_boundary"] = DataBoundary
+	config.Routes["retry_budget_chunks"] = strconv.Itoa(RetryBudgetChunks)
+
+	for name, flag := range adminFeatureFlags {
+		config.FeatureFlags[name] = *flag
+	}
+	config.FeatureFlags["container_log_schema_v2"] = ContainerLogSchemaV2
+	config.FeatureFlags["enrich_container_logs"] = enrichContainerLogs
+	config.FeatureFlags["log_level_detection"] = LogLevelDetection
+	config.FeatureFlags["json_field_promotion"] = len(JSONPromotedFields) > 0
+	config.FeatureFlags["workspace_routing"] = len(WorkspaceRoutesByNamespace) > 0
+	config.FeatureFlags["tee_route"] = TeeSink != nil
+	config.FeatureFlags["v2_canary"] = CanarySink != nil
+	config.FeatureFlags["aad_msi_auth"] = IsAADMSIAuthMode
+	config.FeatureFlags["workload_identity_auth"] = IsWorkloadIdentityAuthMode
+	config.FeatureFlags["logs_ingestion_auth"] = LogsIngestionTokenProvider != nil
+	config.FeatureFlags["windows"] = IsWindows
+	return config
+}
+
+func isEffectiveConfigEnv(name string) bool {
+	for _, prefix := range effectiveConfigEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactConfigValue redacts the value of a secret key and the credentials of urls
+func redactConfigValue(key string, value string) string {
+	if value == "" {
+		return value
+	}
+	lowerKey := strings.ToLower(key)
+	if sensitiveConfigKeyRegex.MatchString(lowerKey) && !strings.HasSuffix(lowerKey, "_path") && !strings.HasSuffix(lowerKey, "_dir") {
+		return redactedConfigValue
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		u.User = url.User(redactedConfigValue)
+		return u.String()
+	}
+	return value
+}
+
+// logEffectiveConfig logs the effective configuration once at startup
+func logEffectiveConfig() {
+	config, err := json.Marshal(getEffectiveConfig())
+	if err != nil {
+		Log("Error::config::Unable to render the effective configuration: %s", err.Error())
+		return
+	}
+	Log("Effective configuration: %s", string(config))
+}
+
+func handleEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getEffectiveConfig())
+}
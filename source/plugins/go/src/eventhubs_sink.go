@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/auth"
+	eventhub "github.com/Azure/azure-event-hubs-go/v3"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// settings of an Event Hubs namespace, read like the route settings: AZMON_EVENTHUBS_<SETTING> or
+// eventhubs_<setting> in the plugin configuration for the eventhubs route, and AZMON_<NAME>_<SETTING> for a declared
+// eventhubs sink
+const (
+	// the fully qualified namespace, e.g. mynamespace.servicebus.windows.net
+	eventHubsSettingNamespace = "namespace"
+	// the event hub of the container logs
+	eventHubsSettingHub = "hub"
+	// the event hub of the KubeMonAgentEvents, not streamed when not set
+	eventHubsSettingKubeMonAgentEventsHub = "kube_mon_agent_events_hub"
+	// client id of the user-assigned identity, the only identity of the node when not set
+	eventHubsSettingClientID = "client_id"
+)
+
+const (
+	// the audience of the tokens of the managed identity for Event Hubs
+	eventHubsResource = "https://eventhubs.azure.net/"
+	// the max size of a batch of events of the standard tier
+	eventHubsMaxBatchBytes = 1000 * 1000
+)
+
+// eventHubsConfig the namespace, event hubs and identity a sink publishes to
+type eventHubsConfig struct {
+	namespace             string
+	hub                   string
+	kubeMonAgentEventsHub string
+	clientID              string
+}
+
+var (
+	// ContainerLogsRouteEventHubs when true, the container logs are published to an event hub
+	ContainerLogsRouteEventHubs bool
+	// EventHubsRouteConfig the event hubs of the eventhubs route
+	EventHubsRouteConfig eventHubsConfig
+	// EventHubsKubeMonAgentEventsPublisher publishes the KubeMonAgentEvents with the ones of the agent data route, nil
+	// when they are not streamed
+	EventHubsKubeMonAgentEventsPublisher *eventHubsPublisher
+)
+
+// newEventHubsConfig reads the Event Hubs settings of the route or the declared sink
+func newEventHubsConfig(name string) (eventHubsConfig, error) {
+	config := eventHubsConfig{
+		namespace:             strings.ToLower(routeSetting(name, eventHubsSettingNamespace)),
+		hub:                   routeSetting(name, eventHubsSettingHub),
+		kubeMonAgentEventsHub: routeSetting(name, eventHubsSettingKubeMonAgentEventsHub),
+		clientID:              routeSetting(name, eventHubsSettingClientID),
+	}
+	if !strings.Contains(config.namespace, ".") || strings.Contains(config.namespace, "/") {
+		return config, fmt.Errorf("invalid namespace %q, it must be the fully qualified namespace", config.namespace)
+	}
+	if config.hub == "" && config.kubeMonAgentEventsHub == "" {
+		return config, fmt.Errorf("the %s or the %s is required", eventHubsSettingHub, eventHubsSettingKubeMonAgentEventsHub)
+	}
+	// the AMQP connection is dialed by the Event Hubs client, which takes no TLS config
+	if hasRouteTLSSettings(name) {
+		return config, errors.New("a client certificate or a CA bundle is not supported by the AMQP connection, publish thru the Kafka endpoint of the namespace with a kafka sink instead")
+	}
+	return config, nil
+}
+
+// initializeEventHubsRoute reads the event hubs of the eventhubs route, and returns whether the container logs can be
+// sent thru it
+func initializeEventHubsRoute() bool {
+	config, err := newEventHubsConfig(ContainerLogsEventHubsRoute)
+	if err == nil && config.hub == "" {
+		err = fmt.Errorf("the %s is required", eventHubsSettingHub)
+	}
+	if err != nil {
+		Log("Error::eventhubs::Unable to route the container logs thru the %s route: %s", ContainerLogsEventHubsRoute, err.Error())
+		return false
+	}
+	EventHubsRouteConfig = config
+	Log("Publishing container logs to the event hub %s of %s", config.hub, config.namespace)
+	return true
+}
+
+// initializeEventHubsKubeMonAgentEvents streams the KubeMonAgentEvents to the event hub of the eventhubs settings,
+// whatever the route of the container logs
+func initializeEventHubsKubeMonAgentEvents() {
+	EventHubsKubeMonAgentEventsPublisher = nil
+	if routeSetting(ContainerLogsEventHubsRoute, eventHubsSettingKubeMonAgentEventsHub) == "" {
+		return
+	}
+	config, err := newEventHubsConfig(ContainerLogsEventHubsRoute)
+	if err != nil {
+		Log("Error::eventhubs::Unable to stream the KubeMonAgentEvents: %s", err.Error())
+		return
+	}
+	EventHubsKubeMonAgentEventsPublisher = newEventHubsPublisher(config, config.kubeMonAgentEventsHub)
+	Log("Streaming KubeMonAgentEvents to the event hub %s of %s", config.kubeMonAgentEventsHub, config.namespace)
+}
+
+// eventHubsTokenProvider gets the CBS tokens of the AMQP connection from the token provider of the managed identity
+type eventHubsTokenProvider struct {
+	provider *TokenProvider
+}
+
+func newEventHubsTokenProvider(clientID string) eventHubsTokenProvider {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	provider := getOrCreateTokenProvider("msi:"+clientID+":"+eventHubsResource, aadTokenRefreshBuffer, func() (string, int64, error) {
+		return getAccessTokenFromIMDSForResource(httpClient, imdsTokenEndpoint, eventHubsResource, clientID)
+	})
+	return eventHubsTokenProvider{provider: provider}
+}
+
+// GetToken implements auth.TokenProvider, the token is the same for all the event hubs of the namespace
+func (p eventHubsTokenProvider) GetToken(uri string) (*auth.Token, error) {
+	token, err := p.provider.Token()
+	if token == "" {
+		return nil, err
+	}
+	p.provider.mu.Lock()
+	expiration := p.provider.expiration
+	p.provider.mu.Unlock()
+	return auth.NewToken(auth.CBSTokenTypeJWT, token, strconv.FormatInt(expiration, 10)), nil
+}
+
+// eventHubsPublisher publishes JSON events to an event hub, connecting on the first publish
+type eventHubsPublisher struct {
+	config eventHubsConfig
+	name   string
+
+	mu  sync.Mutex
+	hub *eventhub.Hub
+}
+
+func newEventHubsPublisher(config eventHubsConfig, name string) *eventHubsPublisher {
+	return &eventHubsPublisher{config: config, name: name}
+}
+
+func (p *eventHubsPublisher) getHub() (*eventhub.Hub, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hub != nil {
+		return p.hub, nil
+	}
+	// the namespace of the hub and the endpoint suffix of its cloud
+	parts := strings.SplitN(p.config.namespace, ".", 2)
+	hub, err := eventhub.NewHub(parts[0], p.name, newEventHubsTokenProvider(p.config.clientID), eventhub.HubWithEnvironment(azure.Environment{ServiceBusEndpointSuffix: parts[1]}))
+	if err != nil {
+		return nil, err
+	}
+	p.hub = hub
+	return hub, nil
+}
+
+// publish sends the events in batches under the max batch size of Event Hubs, the failures are counted
+func (p *eventHubsPublisher) publish(ctx context.Context, dataType string, events []*eventhub.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	hub, err := p.getHub()
+	if err != nil {
+		Log("Error::eventhubs::Unable to connect to the event hub %s of %s: %s", p.name, p.config.namespace, err.Error())
+		PluginMetrics.addClientCreateError("eventhubs")
+		return err
+	}
+	sendStart := time.Now()
+	err = hub.SendBatch(ctx, eventhub.NewEventBatchIterator(events...), eventhub.BatchWithMaxSizeInBytes(eventHubsMaxBatchBytes))
+	trackFlushDependency(dependencyTypeEventHubs, p.config.namespace+"/"+p.name, dataType, sendStart, errorDependencyResultCode(err), err == nil, len(events))
+	if err != nil {
+		// the batches published before the failure are published again with the retry
+		Log("Error::eventhubs::Failed to publish %d %s events to the event hub %s: %s", len(events), dataType, p.name, err.Error())
+		ContainerLogTelemetryMutex.Lock()
+		EventHubsPublishFailedEventCount += float64(len(events))
+		ContainerLogTelemetryMutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// newJSONEvents marshals the records, one event per record, skipping the records that can't be marshalled
+func newJSONEvents(count int, record func(i int) interface{}) []*eventhub.Event {
+	events := make([]*eventhub.Event, 0, count)
+	for i := 0; i < count; i++ {
+		value, err := json.Marshal(record(i))
+		if err != nil {
+			Log("Error::eventhubs::Unable to marshal a record: %s", err.Error())
+			continue
+		}
+		events = append(events, eventhub.NewEvent(value))
+	}
+	return events
+}
+
+// eventHubsSink publishes the records to an event hub as JSON events, one event per record in the ContainerLog or the
+// ContainerLogV2 schema
+type eventHubsSink struct {
+	sinkHealth
+	name      string
+	publisher *eventHubsPublisher
+}
+
+func newEventHubsSink(name string, config eventHubsConfig) *eventHubsSink {
+	return &eventHubsSink{name: name, publisher: newEventHubsPublisher(config, config.hub)}
+}
+
+// newDeclaredEventHubsSink creates an Event Hubs sink from the namespace, hub and client_id settings of the sink
+func newDeclaredEventHubsSink(name string) (Sink, error) {
+	config, err := newEventHubsConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	if config.hub == "" {
+		return nil, fmt.Errorf("the %s is required", eventHubsSettingHub)
+	}
+	return newEventHubsSink(name, config), nil
+}
+
+func (s *eventHubsSink) Name() string { return s.name }
+
+func (s *eventHubsSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	return s.setSendResult(s.send(ctx, batch))
+}
+
+func (s *eventHubsSink) send(ctx context.Context, batch *containerLogBatch) error {
+	var events []*eventhub.Event
+	if len(batch.dataItemsLAv2) > 0 {
+		events = newJSONEvents(len(batch.dataItemsLAv2), func(i int) interface{} { return &batch.dataItemsLAv2[i] })
+	} else {
+		events = newJSONEvents(len(batch.dataItemsLAv1), func(i int) interface{} { return &batch.dataItemsLAv1[i] })
+	}
+	if err := s.publisher.publish(ctx, getContainerLogsDataType(), events); err != nil {
+		return err
+	}
+	Log("Success::eventhubs::Published %d container log records to the event hub %s in %s", len(events), s.publisher.name, time.Since(batch.start))
+	return nil
+}
+
+// publishKubeMonAgentEventsToEventHubs streams the KubeMonAgentEvents of a flush. The stream is best effort, the
+// failures are counted and don't retry the flush of the agent data route
+func publishKubeMonAgentEventsToEventHubs(ctx context.Context, records []laKubeMonAgentEvents) {
+	if EventHubsKubeMonAgentEventsPublisher == nil || len(records) == 0 {
+		return
+	}
+	events := newJSONEvents(len(records), func(i int) interface{} { return &records[i] })
+	if err := EventHubsKubeMonAgentEventsPublisher.publish(ctx, KubeMonAgentEventDataType, events); err == nil {
+		Log("Success::eventhubs::Published %d KubeMonAgentEvents to the event hub %s", len(events), EventHubsKubeMonAgentEventsPublisher.name)
+	}
+}
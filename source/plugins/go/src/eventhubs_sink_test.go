@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_newEventHubsConfig(t *testing.T) {
+	type test_struct struct {
+		testName      string
+		settings      map[string]string
+		wantErr       bool
+		wantNamespace string
+	}
+
+	tests := []test_struct{
+		{"container logs", map[string]string{"eventhubs_namespace": "MyNamespace.servicebus.windows.net", "eventhubs_hub": "logs"}, false, "mynamespace.servicebus.windows.net"},
+		{"kubemonagentevents only", map[string]string{"eventhubs_namespace": "mynamespace.servicebus.chinacloudapi.cn", "eventhubs_kube_mon_agent_events_hub": "events"}, false, "mynamespace.servicebus.chinacloudapi.cn"},
+		{"short namespace", map[string]string{"eventhubs_namespace": "mynamespace", "eventhubs_hub": "logs"}, true, ""},
+		{"namespace url", map[string]string{"eventhubs_namespace": "sb://mynamespace.servicebus.windows.net/", "eventhubs_hub": "logs"}, true, ""},
+		{"no hub", map[string]string{"eventhubs_namespace": "mynamespace.servicebus.windows.net"}, true, ""},
+	}
+
+	defer func() { PluginConfiguration = nil }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			PluginConfiguration = tt.settings
+			config, err := newEventHubsConfig(ContainerLogsEventHubsRoute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newEventHubsConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (config.namespace != tt.wantNamespace || config.hub != tt.settings["eventhubs_hub"] || config.kubeMonAgentEventsHub != tt.settings["eventhubs_kube_mon_agent_events_hub"]) {
+				t.Errorf("newEventHubsConfig() = %+v", config)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variables of the flush checkpoint
+const (
+	// set to false to disable the checkpoint
+	envFlushCheckpointEnabled = "AZMON_FLUSH_CHECKPOINT_ENABLED"
+	// overrides the default checkpoint file
+	envFlushCheckpointPath = "AZMON_FLUSH_CHECKPOINT_PATH"
+)
+
+const (
+	linuxFlushCheckpointPath   = "/var/opt/microsoft/docker-cimprov/state/out_oms_flush_checkpoint.json"
+	windowsFlushCheckpointPath = "/etc/omsagentwindows/out_oms_flush_checkpoint.json"
+	// batch ids kept per route, more than the chunks fluent-bit replays from its storage after a restart
+	maxCheckpointBatchIDs = 1000
+	// the checkpoint is written at most once per this interval
+	flushCheckpointPersistInterval = 5 * time.Second
+)
+
+// flushCheckpoint holds the ids of the last batches flushed successfully per route, persisted so that the chunks
+// replayed by fluent-bit after a restart can be skipped
+type flushCheckpoint struct {
+	mu    sync.Mutex
+	path  string
+	dirty bool
+	// batch ids per route, oldest first
+	Routes map[string][]string `json:"routes"`
+	index  map[string]map[string]bool
+}
+
+// FlushCheckpoint the checkpoint of the flushed batches, nil when disabled
+var FlushCheckpoint *flushCheckpoint
+
+// initializeFlushCheckpoint loads the checkpoint of the previous run and starts persisting it
+func initializeFlushCheckpoint() {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv(envFlushCheckpointEnabled)), "false") {
+		Log("Flush checkpoint is disabled")
+		return
+	}
+	path := strings.TrimSpace(os.Getenv(envFlushCheckpointPath))
+	if path == "" {
+		path = linuxFlushCheckpointPath
+		if IsWindows == true {
+			path = windowsFlushCheckpointPath
+		}
+	}
+	checkpoint, err := loadFlushCheckpoint(path)
+	if err != nil {
+		Log("Error::checkpoint::Unable to load the flush checkpoint %s, starting with an empty one: %s", path, err.Error())
+	}
+	FlushCheckpoint = checkpoint
+	Log("Flush checkpoint = %s", path)
+	go FlushCheckpoint.persistPeriodically()
+}
+
+func newFlushCheckpoint(path string) *flushCheckpoint {
+	return &flushCheckpoint{path: path, Routes: make(map[string][]string), index: make(map[string]map[string]bool)}
+}
+
+// loadFlushCheckpoint reads the checkpoint file, a missing file is an empty checkpoint
+func loadFlushCheckpoint(path string) (*flushCheckpoint, error) {
+	checkpoint := newFlushCheckpoint(path)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+	if err := json.Unmarshal(content, checkpoint); err != nil {
+		return newFlushCheckpoint(path), err
+	}
+	if checkpoint.Routes == nil {
+		checkpoint.Routes = make(map[string][]string)
+	}
+	for route, batchIDs := range checkpoint.Routes {
+		checkpoint.index[route] = make(map[string]bool, len(batchIDs))
+		for _, batchID := range batchIDs {
+			checkpoint.index[route][batchID] = true
+		}
+	}
+	return checkpoint, nil
+}
+
+// recordsBatchID returns the id of the batch, the same for the same records replayed after a restart
+func recordsBatchID(records []map[interface{}]interface{}) string {
+	if len(records) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, record := range records {
+		writeRecordHash(h, record)
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// rawChunkID returns the id of a raw msgpack chunk, for the flushes which don't decode the records
+func rawChunkID(chunk []byte) string {
+	if len(chunk) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(chunk)
+	return hex.EncodeToString(sum[:16])
+}
+
+// writeRecordHash writes the record to the hash with the map keys sorted, so the id does not depend on the map order
+func writeRecordHash(h hash.Hash, value interface{}) {
+	switch v := value.(type) {
+	case []byte:
+		h.Write(v)
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		values := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			k := fmt.Sprintf("%s", key)
+			keys = append(keys, k)
+			values[k] = value
+		}
+		sort.Strings(keys)
+		h.Write([]byte{'{'})
+		for _, key := range keys {
+			h.Write([]byte(key))
+			h.Write([]byte{':'})
+			writeRecordHash(h, values[key])
+			h.Write([]byte{','})
+		}
+		h.Write([]byte{'}'})
+	case []interface{}:
+		h.Write([]byte{'['})
+		for _, value := range v {
+			writeRecordHash(h, value)
+			h.Write([]byte{','})
+		}
+		h.Write([]byte{']'})
+	default:
+		fmt.Fprintf(h, "%v", v)
+	}
+}
+
+// contains returns whether the batch was already flushed on the route
+func (c *flushCheckpoint) contains(route string, batchID string) bool {
+	if c == nil || batchID == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.index[route][batchID]
+}
+
+// add records the batch flushed on the route, evicting the oldest ids beyond maxCheckpointBatchIDs
+func (c *flushCheckpoint) add(route string, batchID string) {
+	if c == nil || batchID == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.index[route] == nil {
+		c.index[route] = make(map[string]bool)
+	}
+	if c.index[route][batchID] {
+		return
+	}
+	c.Routes[route] = append(c.Routes[route], batchID)
+	c.index[route][batchID] = true
+	if len(c.Routes[route]) > maxCheckpointBatchIDs {
+		evicted := len(c.Routes[route]) - maxCheckpointBatchIDs
+		for _, batchID := range c.Routes[route][:evicted] {
+			delete(c.index[route], batchID)
+		}
+		c.Routes[route] = append([]string(nil), c.Routes[route][evicted:]...)
+	}
+	c.dirty = true
+}
+
+func (c *flushCheckpoint) persistPeriodically() {
+	for ; true; <-time.After(flushCheckpointPersistInterval) {
+		if err := c.persist(); err != nil {
+			Log("Error::checkpoint::Unable to write the flush checkpoint %s: %s", c.path, err.Error())
+		}
+	}
+}
+
+// persist writes the checkpoint when it changed, thru a temporary file so a crash does not leave a partial checkpoint.
+// The checkpoint stays dirty when the write fails, so it is written again on the next interval
+func (c *flushCheckpoint) persist() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	content, err := json.Marshal(c)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	// cleared before the write, so the batches added while it is written mark it dirty again
+	c.dirty = false
+	c.mu.Unlock()
+	if err := c.write(content); err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (c *flushCheckpoint) write(content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmpPath := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, c.path)
+}
+
+// skipCheckpointedBatch returns whether the batch was already flushed on the route before a restart
+func skipCheckpointedBatch(caller string, route string, batchID string, numRecords int) bool {
+	if !FlushCheckpoint.contains(route, batchID) {
+		return false
+	}
+	Log("%s::Info::skipping %d records of batch %s already flushed on the %s route", caller, numRecords, batchID, route)
+	ContainerLogTelemetryMutex.Lock()
+	CheckpointDeduplicatedChunkCount += 1
+	ContainerLogTelemetryMutex.Unlock()
+	return true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_recordsBatchID(t *testing.T) {
+	type test_struct struct {
+		testname string
+		first    []map[interface{}]interface{}
+		second   []map[interface{}]interface{}
+		same     bool
+	}
+
+	tests := []test_struct{
+		{"same records",
+			[]map[interface{}]interface{}{{"log": []byte("a"), "stream": []byte("stdout"), "filepath": []byte("/var/log/containers/a.log")}},
+			[]map[interface{}]interface{}{{"filepath": []byte("/var/log/containers/a.log"), "stream": []byte("stdout"), "log": []byte("a")}},
+			true},
+		{"different records",
+			[]map[interface{}]interface{}{{"log": []byte("a")}},
+			[]map[interface{}]interface{}{{"log": []byte("b")}},
+			false},
+		{"different order of records",
+			[]map[interface{}]interface{}{{"log": []byte("a")}, {"log": []byte("b")}},
+			[]map[interface{}]interface{}{{"log": []byte("b")}, {"log": []byte("a")}},
+			false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			first := recordsBatchID(tt.first)
+			second := recordsBatchID(tt.second)
+			if (first == second) != tt.same {
+				t.Errorf("recordsBatchID() = %s and %s, want same %v", first, second, tt.same)
+			}
+		})
+	}
+}
+
+func Test_flushCheckpointPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	checkpoint := newFlushCheckpoint(path)
+	for i := 0; i < maxCheckpointBatchIDs+1; i++ {
+		checkpoint.add("v2", fmt.Sprintf("batch-%d", i))
+	}
+	checkpoint.add("adx", "batch")
+	if err := checkpoint.persist(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadFlushCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type test_struct struct {
+		testname string
+		route    string
+		batchID  string
+		output   bool
+	}
+
+	tests := []test_struct{
+		{"evicted batch", "v2", "batch-0", false},
+		{"latest batch", "v2", fmt.Sprintf("batch-%d", maxCheckpointBatchIDs), true},
+		{"other route", "adx", "batch", true},
+		{"batch of another route", "v2", "batch", false},
+		{"oldest kept batch", "v2", "batch-1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := loaded.contains(tt.route, tt.batchID); got != tt.output {
+				t.Errorf("contains(%s, %q) = %v, want %v", tt.route, tt.batchID, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_flushCheckpointPersistFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the directory of the checkpoint is a file, so the write fails
+	blocked := filepath.Join(dir, "blocked")
+	if err := ioutil.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint := newFlushCheckpoint(filepath.Join(blocked, "checkpoint.json"))
+	checkpoint.add("v2", "batch")
+	if err := checkpoint.persist(); err == nil {
+		t.Fatalf("persist() succeeded writing under a file")
+	}
+	if !checkpoint.dirty {
+		t.Fatalf("persist() cleared the dirty flag of a checkpoint it failed to write")
+	}
+
+	checkpoint.path = filepath.Join(dir, "checkpoint.json")
+	if err := checkpoint.persist(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadFlushCheckpoint(checkpoint.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.contains("v2", "batch") || checkpoint.dirty {
+		t.Errorf("persist() did not write the checkpoint after the failure")
+	}
+}
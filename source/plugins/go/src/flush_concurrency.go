@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cap on the concurrent flushes of all the routes. The cap of a route is read from AZMON_<ROUTE>_MAX_INFLIGHT_FLUSHES or
+// <route>_max_inflight_flushes in the plugin configuration. No cap when not set
+const envMaxInflightFlushes = "AZMON_MAX_INFLIGHT_FLUSHES"
+
+// flushSemaphore holds a slot for each in-flight flush
+type flushSemaphore chan struct{}
+
+var (
+	// GlobalFlushSemaphore caps the in-flight flushes of all the routes, nil when not capped
+	GlobalFlushSemaphore flushSemaphore
+	// RouteFlushSemaphores caps the in-flight flushes of each route, only set at init
+	RouteFlushSemaphores = make(map[string]flushSemaphore)
+)
+
+// initializeFlushConcurrency reads the global and per-route caps on the in-flight flushes, for the routes of the
+// container logs of the sink registry and the route of the agent data
+func initializeFlushConcurrency() {
+	if limit := parseMaxInflightFlushes(envMaxInflightFlushes, os.Getenv(envMaxInflightFlushes)); limit > 0 {
+		GlobalFlushSemaphore = make(flushSemaphore, limit)
+		Log("Max in-flight flushes = %d", limit)
+	}
+	routes := map[string]bool{getAgentDataRouteName(): true}
+	for _, containerLogRoute := range containerLogRoutes {
+		routes[containerLogRoute.route] = true
+	}
+	for route := range routes {
+		if limit := parseMaxInflightFlushes(route+"_max_inflight_flushes", routeSetting(route, "max_inflight_flushes")); limit > 0 {
+			RouteFlushSemaphores[route] = make(flushSemaphore, limit)
+			Log("Max in-flight flushes for the %s route = %d", route, limit)
+		}
+	}
+}
+
+func parseMaxInflightFlushes(setting string, value string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		Log("Invalid value %s for %s, the in-flight flushes are not capped", value, setting)
+		return 0
+	}
+	return limit
+}
+
+// acquireFlushSlot waits for a free slot in the global and route caps, or until the flush context is done. The returned
+// func releases the slots and must be called when the flush returns
+func acquireFlushSlot(ctx context.Context, route string) (func(), error) {
+	start := time.Now()
+	semaphores := []flushSemaphore{}
+	if GlobalFlushSemaphore != nil {
+		semaphores = append(semaphores, GlobalFlushSemaphore)
+	}
+	if routeSemaphore, ok := RouteFlushSemaphores[route]; ok {
+		semaphores = append(semaphores, routeSemaphore)
+	}
+	release := func(acquired []flushSemaphore) {
+		for _, semaphore := range acquired {
+			<-semaphore
+		}
+	}
+
+	// always acquired in the same order, global first, so flushes of different routes don't deadlock
+	for i, semaphore := range semaphores {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			release(semaphores[:i])
+			updateFlushQueueWaitTelemetry(time.Since(start))
+			return nil, ctx.Err()
+		}
+	}
+	if len(semaphores) > 0 {
+		updateFlushQueueWaitTelemetry(time.Since(start))
+	}
+	return func() { release(semaphores) }, nil
+}
+
+func updateFlushQueueWaitTelemetry(wait time.Duration) {
+	ContainerLogTelemetryMutex.Lock()
+	FlushQueueWaitMs += float64(wait / time.Millisecond)
+	ContainerLogTelemetryMutex.Unlock()
+}
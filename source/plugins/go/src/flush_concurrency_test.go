@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_acquireFlushSlot(t *testing.T) {
+	defer func() {
+		GlobalFlushSemaphore = nil
+		RouteFlushSemaphores = make(map[string]flushSemaphore)
+	}()
+
+	type test_struct struct {
+		testname    string
+		globalLimit int
+		routeLimit  int
+		inflight    int
+		isError     bool
+	}
+
+	tests := []test_struct{
+		{"not capped", 0, 0, 5, false},
+		{"below the global cap", 2, 0, 1, false},
+		{"global cap reached", 2, 0, 2, true},
+		{"route cap reached", 5, 1, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			GlobalFlushSemaphore = nil
+			RouteFlushSemaphores = make(map[string]flushSemaphore)
+			if tt.globalLimit > 0 {
+				GlobalFlushSemaphore = make(flushSemaphore, tt.globalLimit)
+			}
+			if tt.routeLimit > 0 {
+				RouteFlushSemaphores[ContainerLogsV2Route] = make(flushSemaphore, tt.routeLimit)
+			}
+			for i := 0; i < tt.inflight; i++ {
+				if _, err := acquireFlushSlot(context.Background(), ContainerLogsV2Route); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			release, err := acquireFlushSlot(ctx, ContainerLogsV2Route)
+			if (err != nil) != tt.isError {
+				t.Fatalf("acquireFlushSlot() error = %v, want error %v", err, tt.isError)
+			}
+			if err == nil {
+				release()
+			} else if len(GlobalFlushSemaphore) != tt.inflight {
+				t.Errorf("acquireFlushSlot() left %d global slots held, want %d", len(GlobalFlushSemaphore), tt.inflight)
+			}
+		})
+	}
+}
+
+func Test_initializeFlushConcurrency(t *testing.T) {
+	defer func() { RouteFlushSemaphores = make(map[string]flushSemaphore) }()
+	RouteFlushSemaphores = make(map[string]flushSemaphore)
+	for _, route := range []string{ContainerLogsKafkaRoute, ContainerLogsOTLPRoute, "mdsd"} {
+		os.Setenv("AZMON_"+strings.ToUpper(route)+"_MAX_INFLIGHT_FLUSHES", "3")
+		defer os.Unsetenv("AZMON_" + strings.ToUpper(route) + "_MAX_INFLIGHT_FLUSHES")
+	}
+
+	initializeFlushConcurrency()
+	for _, route := range []string{ContainerLogsKafkaRoute, ContainerLogsOTLPRoute, "mdsd"} {
+		if semaphore, ok := RouteFlushSemaphores[route]; !ok || cap(semaphore) != 3 {
+			t.Errorf("the %s route has %d in-flight flush slots, want 3", route, cap(semaphore))
+		}
+	}
+	if len(RouteFlushSemaphores) != 3 {
+		t.Errorf("%d routes are capped, want 3", len(RouteFlushSemaphores))
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+)
+
+// env variables for tracing the flushes
+const (
+	// ratio of the flushes traced, between 0 and 1. Tracing is off when not set
+	envTraceSamplingRatio = "AZMON_TRACE_SAMPLING_RATIO"
+	// otlp (default when an endpoint is configured) or appinsights
+	envTraceExporter = "AZMON_TRACE_EXPORTER"
+	// OTLP/HTTP traces endpoint, e.g. http://otel-collector:4318/v1/traces
+	envTraceOTLPEndpoint = "AZMON_TRACE_OTLP_ENDPOINT"
+)
+
+const (
+	traceExporterOTLP        = "otlp"
+	traceExporterAppInsights = "appinsights"
+
+	traceServiceName     = "container-insights-out-oms"
+	traceExportBatchSize = 100
+	traceExportInterval  = 5 * time.Second
+	traceSpanQueueSize   = 1000
+	otlpSpanKindInternal = 1
+	otlpStatusCodeOk     = 1
+	otlpStatusCodeError  = 2
+)
+
+// flushSpan is a sampled span around a flush. The methods are no-ops on a nil span, which is what is returned for
+// the flushes not sampled
+type flushSpan struct {
+	name       string
+	traceID    string
+	spanID     string
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	failed     bool
+}
+
+var (
+	traceSamplingRatio float64
+	traceExporter      string
+	traceOTLPEndpoint  string
+	traceSpanQueue     chan *flushSpan
+	traceHTTPClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// initializeFlushTracing reads the tracing configuration and starts the span exporter when tracing is on
+func initializeFlushTracing() {
+	traceSamplingRatio = 0
+	value := strings.TrimSpace(os.Getenv(envTraceSamplingRatio))
+	if value == "" {
+		return
+	}
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		Log("Invalid value %s for %s, flush tracing is disabled", value, envTraceSamplingRatio)
+		return
+	}
+	traceOTLPEndpoint = strings.TrimSpace(os.Getenv(envTraceOTLPEndpoint))
+	traceExporter = strings.ToLower(strings.TrimSpace(os.Getenv(envTraceExporter)))
+	if traceExporter == "" {
+		traceExporter = traceExporterAppInsights
+		if traceOTLPEndpoint != "" {
+			traceExporter = traceExporterOTLP
+		}
+	}
+	if traceExporter == traceExporterOTLP && traceOTLPEndpoint == "" {
+		Log("%s is required for the otlp trace exporter, flush tracing is disabled", envTraceOTLPEndpoint)
+		return
+	}
+	if traceExporter != traceExporterOTLP && traceExporter != traceExporterAppInsights {
+		Log("Unknown trace exporter %s, flush tracing is disabled", traceExporter)
+		return
+	}
+	traceSamplingRatio = ratio
+	traceSpanQueue = make(chan *flushSpan, traceSpanQueueSize)
+	Log("Tracing %.2f of the flushes with the %s exporter", traceSamplingRatio, traceExporter)
+	go exportFlushSpans()
+}
+
+// startFlushSpan starts a span when the flush is sampled, nil otherwise
+func startFlushSpan(name string) *flushSpan {
+	if traceSamplingRatio <= 0 || mathrand.Float64() >= traceSamplingRatio {
+		return nil
+	}
+	return &flushSpan{
+		name:       name,
+		traceID:    randomHexID(16),
+		spanID:     randomHexID(8),
+		start:      time.Now(),
+		attributes: map[string]interface{}{"route": getContainerLogsRouteName()},
+	}
+}
+
+func (s *flushSpan) setAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attributes[key] = value
+}
+
+// finish ends the span with the fluent-bit return code of the flush and queues it for export
+func (s *flushSpan) finish(retCode int) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.attributes["flb.return_code"] = retCode
+	s.failed = retCode != output.FLB_OK
+	select {
+	case traceSpanQueue <- s:
+	default:
+		// never block a flush on tracing
+	}
+}
+
+// getContainerLogsRouteName returns the route the container logs are sent thru
+func getContainerLogsRouteName() string {
+	switch {
+	case ContainerLogsRouteGeneva:
+		return ContainerLogsGenevaRoute
+	case ContainerLogsRouteV2:
+		return ContainerLogsV2Route
+	case ContainerLogsRouteADX:
+		return ContainerLogsADXRoute
+	case ContainerLogsRouteDCR:
+		return ContainerLogsDCRRoute
+	case ContainerLogsRouteKafka:
+		return ContainerLogsKafkaRoute
+	case ContainerLogsRouteEventHubs:
+		return ContainerLogsEventHubsRoute
+	case ContainerLogsRouteOTLP:
+		return ContainerLogsOTLPRoute
+	}
+	return ContainerLogsV1Route
+}
+
+// getAgentDataRouteName returns the route of the telegraf metrics and KubeMonAgentEvents, mdsd on linux and ODS direct
+// on windows
+func getAgentDataRouteName() string {
+	if IsWindows == false {
+		return "mdsd"
+	}
+	return ContainerLogsV1Route
+}
+
+func randomHexID(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		mathrand.Read(id)
+	}
+	return hex.EncodeToString(id)
+}
+
+func exportFlushSpans() {
+	ticker := time.NewTicker(traceExportInterval)
+	var batch []*flushSpan
+	for {
+		select {
+		case span := <-traceSpanQueue:
+			batch = append(batch, span)
+			if len(batch) < traceExportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if traceExporter == traceExporterOTLP {
+			if err := exportSpansToOTLP(batch); err != nil {
+				Log("Error::tracing::Failed to export %d spans to %s: %s", len(batch), traceOTLPEndpoint, err.Error())
+			}
+		} else {
+			exportSpansToAppInsights(batch)
+		}
+		batch = nil
+	}
+}
+
+// buildOTLPTracesPayload returns the OTLP/HTTP JSON export request for the spans
+func buildOTLPTracesPayload(spans []*flushSpan) ([]byte, error) {
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, span := range spans {
+		attributes := make([]map[string]interface{}, 0, len(span.attributes))
+		for key, value := range span.attributes {
+			attributes = append(attributes, map[string]interface{}{"key": key, "value": otlpAnyValue(value)})
+		}
+		statusCode := otlpStatusCodeOk
+		if span.failed {
+			statusCode = otlpStatusCodeError
+		}
+		otlpSpans = append(otlpSpans, map[string]interface{}{
+			"traceId":           span.traceID,
+			"spanId":            span.spanID,
+			"name":              span.name,
+			"kind":              otlpSpanKindInternal,
+			"startTimeUnixNano": strconv.FormatInt(span.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.end.UnixNano(), 10),
+			"attributes":        attributes,
+			"status":            map[string]interface{}{"code": statusCode},
+		})
+	}
+	resourceAttributes := []map[string]interface{}{
+		{"key": "service.name", "value": otlpAnyValue(traceServiceName)},
+		{"key": "host.name", "value": otlpAnyValue(Computer)},
+		{"key": "k8s.cluster.name", "value": otlpAnyValue(ResourceName)},
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []map[string]interface{}{{
+			"resource": map[string]interface{}{"attributes": resourceAttributes},
+			"scopeSpans": []map[string]interface{}{{
+				"scope": map[string]interface{}{"name": traceServiceName},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+	return json.Marshal(payload)
+}
+
+func otlpAnyValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprintf("%v", value)}
+}
+
+func exportSpansToOTLP(spans []*flushSpan) error {
+	payload, err := buildOTLPTracesPayload(spans)
+	if err != nil {
+		return err
+	}
+	resp, err := traceHTTPClient.Post(traceOTLPEndpoint, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// exportSpansToAppInsights tracks the spans as requests of the agent's AppInsights resource, with the trace id as
+// operation id
+func exportSpansToAppInsights(spans []*flushSpan) {
+	if TelemetryClient == nil {
+		return
+	}
+	for _, span := range spans {
+		request := appinsights.NewRequestTelemetry("FLUSH", span.name, span.end.Sub(span.start), fmt.Sprintf("%v", span.attributes["flb.return_code"]))
+		request.Name = span.name
+		request.Id = span.spanID
+		request.Timestamp = span.start
+		request.Success = !span.failed
+		for key, value := range span.attributes {
+			request.Properties[key] = fmt.Sprintf("%v", value)
+		}
+		request.Tags.Operation().SetId(span.traceID)
+		TelemetryClient.Track(request)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_buildOTLPTracesPayload(t *testing.T) {
+	type test_struct struct {
+		testname   string
+		failed     bool
+		statusCode float64
+	}
+
+	tests := []test_struct{
+		{"successful flush", false, otlpStatusCodeOk},
+		{"failed flush", true, otlpStatusCodeError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			start := time.Unix(1600000000, 0)
+			span := &flushSpan{
+				name:       "PostDataHelper",
+				traceID:    "0af7651916cd43dd8448eb211c80319c",
+				spanID:     "b7ad6b7169203331",
+				start:      start,
+				end:        start.Add(time.Second),
+				attributes: map[string]interface{}{"records": 10},
+				failed:     tt.failed,
+			}
+			payload, err := buildOTLPTracesPayload([]*flushSpan{span})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded struct {
+				ResourceSpans []struct {
+					ScopeSpans []struct {
+						Spans []struct {
+							TraceID         string `json:"traceId"`
+							SpanID          string `json:"spanId"`
+							EndTimeUnixNano string `json:"endTimeUnixNano"`
+							Attributes      []map[string]interface{}
+							Status          map[string]interface{}
+						} `json:"spans"`
+					} `json:"scopeSpans"`
+				} `json:"resourceSpans"`
+			}
+			if err := json.Unmarshal(payload, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			got := decoded.ResourceSpans[0].ScopeSpans[0].Spans[0]
+			if got.TraceID != span.traceID || got.SpanID != span.spanID || got.EndTimeUnixNano != "1600000001000000000" {
+				t.Errorf("buildOTLPTracesPayload() span = %+v", got)
+			}
+			if got.Status["code"] != tt.statusCode {
+				t.Errorf("buildOTLPTracesPayload() status = %v, want %v", got.Status["code"], tt.statusCode)
+			}
+			if value := got.Attributes[0]["value"].(map[string]interface{}); got.Attributes[0]["key"] != "records" || value["intValue"] != "10" {
+				t.Errorf("buildOTLPTracesPayload() attributes = %v", got.Attributes)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hard deadline of a flush, after which the watchdog cancels it
+const envFlushDeadlineSeconds = "AZMON_FLUSH_DEADLINE_SECONDS"
+
+const (
+	defaultFlushDeadline = 60 * time.Second
+	// size of the goroutine stack snapshot logged for a stuck flush
+	flushStackSnapshotSize = 64 * 1024
+)
+
+var flushDeadline = defaultFlushDeadline
+
+// initializeFlushWatchdog reads the flush deadline
+func initializeFlushWatchdog() {
+	flushDeadline = defaultFlushDeadline
+	value := strings.TrimSpace(os.Getenv(envFlushDeadlineSeconds))
+	if value == "" {
+		return
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		Log("Invalid value %s for %s, using the default flush deadline %s", value, envFlushDeadlineSeconds, defaultFlushDeadline)
+		return
+	}
+	flushDeadline = time.Duration(seconds) * time.Second
+	Log("Flush deadline = %s", flushDeadline)
+}
+
+// watchdogContext the context of a flush, which reports the deadline of the watchdog so the waits of the flush can stay
+// within it. The watchdog cancels it once the stuck flush is reported
+type watchdogContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c *watchdogContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+// startFlushWatchdog returns the context of a flush, which the watchdog cancels when the flush runs past the deadline.
+// The returned func stops the watchdog and must be called when the flush returns
+func startFlushWatchdog(name string) (context.Context, func()) {
+	cancelCtx, cancel := context.WithCancel(ParentContext)
+	start := time.Now()
+	ctx := &watchdogContext{Context: cancelCtx, deadline: start.Add(flushDeadline)}
+	watchdog := time.AfterFunc(flushDeadline, func() {
+		reportStuckFlush(name, time.Since(start))
+		cancel()
+	})
+	return ctx, func() {
+		watchdog.Stop()
+		cancel()
+	}
+}
+
+// reportStuckFlush logs a snapshot of the goroutine stacks, so the blocked call can be found, and counts the stuck flush
+func reportStuckFlush(name string, elapsed time.Duration) {
+	stack := make([]byte, flushStackSnapshotSize)
+	stack = stack[:runtime.Stack(stack, true)]
+	Log("Error::watchdog::%s is still running after %s, cancelling it. Goroutine stacks:\n%s", name, elapsed, stack)
+
+	ContainerLogTelemetryMutex.Lock()
+	StuckFlushCount += 1
+	ContainerLogTelemetryMutex.Unlock()
+}
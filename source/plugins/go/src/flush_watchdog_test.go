@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_startFlushWatchdog(t *testing.T) {
+	flushDeadline = 20 * time.Millisecond
+	defer func() { flushDeadline = defaultFlushDeadline }()
+
+	type test_struct struct {
+		testname      string
+		flushDuration time.Duration
+		cancelled     bool
+	}
+
+	tests := []test_struct{
+		{"flush within the deadline", 0, false},
+		{"stuck flush", 200 * time.Millisecond, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			StuckFlushCount = 0
+			ctx, stopWatchdog := startFlushWatchdog(tt.testname)
+			if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > flushDeadline {
+				t.Errorf("startFlushWatchdog() deadline = %v, %v, want within %v", deadline, ok, flushDeadline)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(tt.flushDuration):
+			}
+			cancelled := ctx.Err() != nil
+			stopWatchdog()
+			time.Sleep(2 * flushDeadline)
+
+			if cancelled != tt.cancelled || (StuckFlushCount == 1) != tt.cancelled {
+				t.Errorf("startFlushWatchdog() cancelled = %v with %v stuck flushes, want cancelled %v", cancelled, StuckFlushCount, tt.cancelled)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variables of the workers which send the sub-batches of a flush concurrently
+const (
+	// number of workers of a flush, the batch is sent at once when not set or 1
+	envFlushWorkers = "AZMON_FLUSH_WORKERS"
+	// max number of records of a sub-batch
+	envFlushWorkerBatchSize = "AZMON_FLUSH_WORKER_BATCH_SIZE"
+	// number of times a failed sub-batch is sent again within the flush, before the flush is retried
+	envFlushWorkerRetries = "AZMON_FLUSH_WORKER_RETRIES"
+)
+
+const (
+	defaultFlushWorkerBatchSize = 1000
+	defaultFlushWorkerRetries   = 2
+)
+
+var (
+	// FlushWorkers the number of workers of a flush, 1 when the batch is sent at once
+	FlushWorkers = 1
+	// FlushWorkerBatchSize the max number of records of a sub-batch
+	FlushWorkerBatchSize = defaultFlushWorkerBatchSize
+	// FlushWorkerRetries the number of times a failed sub-batch is sent again within the flush
+	FlushWorkerRetries = defaultFlushWorkerRetries
+	// FlushWorkerRetryBackoff the base delay of the backoff between the sends of a failed sub-batch
+	FlushWorkerRetryBackoff = 500 * time.Millisecond
+)
+
+// initializeFlushWorkers reads the workers configuration
+func initializeFlushWorkers() {
+	FlushWorkers = readFlushWorkerSetting(envFlushWorkers, 1, 1)
+	FlushWorkerBatchSize = readFlushWorkerSetting(envFlushWorkerBatchSize, defaultFlushWorkerBatchSize, 1)
+	FlushWorkerRetries = readFlushWorkerSetting(envFlushWorkerRetries, defaultFlushWorkerRetries, 0)
+	if FlushWorkers > 1 {
+		Log("Flushes send sub-batches of %d records with %d workers, a failed sub-batch is sent %d more times", FlushWorkerBatchSize, FlushWorkers, FlushWorkerRetries)
+	}
+}
+
+func readFlushWorkerSetting(name string, defaultValue int, minValue int) int {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < minValue {
+		Log("Invalid value %s for %s, using the default %d", value, name, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// sendContainerLogBatch sends the batch of a flush to the container log sink, split into sub-batches sent by the
+// workers when it has more records than a sub-batch. A failed sub-batch is sent again within the flush, and the error
+// of a sub-batch which still fails is returned so the flush is retried. The sub-batches sent are remembered with the
+// id of the chunk, so the retry of the flush only sends the sub-batches which failed
+func sendContainerLogBatch(ctx context.Context, batch *containerLogBatch) error {
+	if FlushWorkers <= 1 || batch.len() <= FlushWorkerBatchSize {
+		return ContainerLogSink.Send(ctx, batch)
+	}
+	route := ContainerLogSink.Name()
+	subBatches := batch.split(FlushWorkerBatchSize)
+	errs := make([]error, len(subBatches))
+	retries := make([]int, len(subBatches))
+	workers := FlushWorkers
+	if workers > len(subBatches) {
+		workers = len(subBatches)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if isPayloadSent(route, subBatches[i].id) {
+					Log("PostDataHelper::Info::skipping the sub-batch %s already sent on the %s route", subBatches[i].id, route)
+					continue
+				}
+				retries[i], errs[i] = sendSubBatch(ctx, subBatches[i])
+				if errs[i] == nil {
+					addFlushedBatch(route, subBatches[i].id)
+				}
+			}
+		}()
+	}
+	for i := range subBatches {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	totalRetries, dropped := 0, 0
+	var failed error
+	for i, err := range errs {
+		totalRetries += retries[i]
+		if err == errBatchDropped {
+			dropped++
+		} else if err != nil && failed == nil {
+			failed = err
+		}
+	}
+	ContainerLogTelemetryMutex.Lock()
+	FlushWorkerSubBatchCount += float64(len(subBatches))
+	FlushWorkerRetryCount += float64(totalRetries)
+	ContainerLogTelemetryMutex.Unlock()
+	if failed != nil {
+		Log("PostDataHelper::Error::%d of the %d sub-batches failed", countFailedSubBatches(errs), len(subBatches))
+		return failed
+	}
+	if dropped == len(subBatches) {
+		return errBatchDropped
+	}
+	return nil
+}
+
+// sendSubBatch sends a sub-batch, again after a backoff when it fails. Returns the number of times it was sent again
+// and the error of the last send
+func sendSubBatch(ctx context.Context, batch *containerLogBatch) (int, error) {
+	for retry := 0; ; retry++ {
+		err := ContainerLogSink.Send(ctx, batch)
+		if err == nil || err == errBatchDropped || retry >= FlushWorkerRetries {
+			return retry, err
+		}
+		select {
+		case <-time.After(jitteredBackoff(retry, FlushWorkerRetryBackoff)):
+		case <-ctx.Done():
+			return retry, err
+		}
+	}
+}
+
+func countFailedSubBatches(errs []error) int {
+	failed := 0
+	for _, err := range errs {
+		if err != nil && err != errBatchDropped {
+			failed++
+		}
+	}
+	return failed
+}
+
+// split splits the batch into total/size sub-batches, rounded up, by the hash of the container of the records like the
+// ordering lanes, so the records of a container are in a single sub-batch and in order. The sizes of the sub-batches
+// depend on the containers, and the empty ones are left out. The log bytes are shared in proportion to the records.
+// The sub-batches of the same records get the same ids
+func (b *containerLogBatch) split(size int) []*containerLogBatch {
+	total := b.len()
+	count := (total + size - 1) / size
+	buckets := make([]*containerLogBatch, count)
+	for i := range buckets {
+		buckets[i] = &containerLogBatch{start: b.start, id: subBatchID(b.id, i, count)}
+	}
+	for i := 0; i < total; i++ {
+		subBatch := buckets[containerKeyHash([]byte(b.containerID(i)))%uint32(count)]
+		// the batch of a flush has the items of a single schema, so only one of the slices has items
+		switch {
+		case len(b.msgPackEntries) > 0:
+			subBatch.msgPackEntries = append(subBatch.msgPackEntries, b.msgPackEntries[i])
+		case len(b.dataItemsADX) > 0:
+			subBatch.dataItemsADX = append(subBatch.dataItemsADX, b.dataItemsADX[i])
+		case len(b.dataItemsLAv2) > 0:
+			subBatch.dataItemsLAv2 = append(subBatch.dataItemsLAv2, b.dataItemsLAv2[i])
+		default:
+			subBatch.dataItemsLAv1 = append(subBatch.dataItemsLAv1, b.dataItemsLAv1[i])
+		}
+	}
+	var subBatches []*containerLogBatch
+	for _, subBatch := range buckets {
+		if records := subBatch.len(); records > 0 {
+			subBatch.logBytes = b.logBytes * records / total
+			subBatches = append(subBatches, subBatch)
+		}
+	}
+	return subBatches
+}
+
+// containerID returns the id of the container of the record i of the batch
+func (b *containerLogBatch) containerID(i int) string {
+	switch {
+	case len(b.msgPackEntries) > 0:
+		if id, ok := b.msgPackEntries[i].Record["ContainerId"]; ok {
+			return id
+		}
+		return b.msgPackEntries[i].Record["Id"]
+	case len(b.dataItemsADX) > 0:
+		return b.dataItemsADX[i].ContainerId
+	case len(b.dataItemsLAv2) > 0:
+		return b.dataItemsLAv2[i].ContainerId
+	default:
+		return b.dataItemsLAv1[i].ID
+	}
+}
+
+// subBatchID returns the id of the sub-batch index of the count sub-batches of the chunk, empty when the chunk has no id
+func subBatchID(batchID string, index int, count int) string {
+	if batchID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/sub-batch-%d-of-%d", batchID, index, count)
+}
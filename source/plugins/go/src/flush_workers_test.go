@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// subBatchSink a sink failing the sub-batches with the records of the configured containers a number of times
+type subBatchSink struct {
+	sinkHealth
+	mu sync.Mutex
+	// the remaining failures of the sub-batches by the containers of their records
+	failures map[string]int
+	sent     int
+}
+
+func (s *subBatchSink) Name() string { return "test" }
+
+func (s *subBatchSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range batch.dataItemsLAv1 {
+		if s.failures[item.ID] > 0 {
+			s.failures[item.ID]--
+			return s.setSendResult(errors.New("ingestion failed"))
+		}
+	}
+	s.sent += batch.len()
+	return s.setSendResult(nil)
+}
+
+// newContainerLogTestBatch returns a batch of 25 records of 5 containers
+func newContainerLogTestBatch(id string) *containerLogBatch {
+	batch := &containerLogBatch{logBytes: 250, id: id}
+	for i := 0; i < 25; i++ {
+		batch.dataItemsLAv1 = append(batch.dataItemsLAv1, DataItemLAv1{ID: "c" + strconv.Itoa(i%5), LogEntry: strconv.Itoa(i)})
+	}
+	return batch
+}
+
+// subBatchRecords returns the number of records of the sub-batch with the records of the container
+func subBatchRecords(batch *containerLogBatch, size int, container string) int {
+	for _, subBatch := range batch.split(size) {
+		for _, item := range subBatch.dataItemsLAv1 {
+			if item.ID == container {
+				return subBatch.len()
+			}
+		}
+	}
+	return 0
+}
+
+func Test_sendContainerLogBatch(t *testing.T) {
+	type test_struct struct {
+		testname string
+		workers  int
+		failures map[string]int
+		isError  bool
+		sent     int
+	}
+
+	failing := subBatchRecords(newContainerLogTestBatch(""), 10, "c0")
+	tests := []test_struct{
+		{"single worker", 1, map[string]int{}, false, 25},
+		{"workers", 3, map[string]int{}, false, 25},
+		{"sub-batch sent again", 3, map[string]int{"c0": 2}, false, 25},
+		{"sub-batch still failing", 3, map[string]int{"c0": 3}, true, 25 - failing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			FlushWorkers, FlushWorkerBatchSize, FlushWorkerRetries, FlushWorkerRetryBackoff = tt.workers, 10, 2, time.Millisecond
+			defer func() { FlushWorkers = 1 }()
+			sink := &subBatchSink{failures: tt.failures}
+			ContainerLogSink = sink
+			if err := sendContainerLogBatch(context.Background(), newContainerLogTestBatch("")); (err != nil) != tt.isError {
+				t.Errorf("sendContainerLogBatch() error = %v, want error %v", err, tt.isError)
+			}
+			if sink.sent != tt.sent {
+				t.Errorf("%d records sent, want %d", sink.sent, tt.sent)
+			}
+		})
+	}
+}
+
+func Test_sendContainerLogBatchRetry(t *testing.T) {
+	FlushWorkers, FlushWorkerBatchSize, FlushWorkerRetries, FlushWorkerRetryBackoff = 3, 10, 0, time.Millisecond
+	RecentChunks = newRecentChunks(16, time.Minute)
+	defer func() {
+		FlushWorkers = 1
+		RecentChunks = nil
+	}()
+	sink := &subBatchSink{failures: map[string]int{"c0": 1}}
+	ContainerLogSink = sink
+	if err := sendContainerLogBatch(context.Background(), newContainerLogTestBatch("chunk")); err == nil {
+		t.Fatal("sendContainerLogBatch() succeeded, want the error of the failed sub-batch")
+	}
+	failing := subBatchRecords(newContainerLogTestBatch("chunk"), 10, "c0")
+	if sink.sent != 25-failing {
+		t.Errorf("%d records sent, want %d", sink.sent, 25-failing)
+	}
+	// the retry of the flush only sends the sub-batch which failed
+	if err := sendContainerLogBatch(context.Background(), newContainerLogTestBatch("chunk")); err != nil {
+		t.Fatalf("sendContainerLogBatch() error = %v", err)
+	}
+	if sink.sent != 25 {
+		t.Errorf("%d records sent after the retry, want 25", sink.sent)
+	}
+}
+
+func Test_containerLogBatchSplit(t *testing.T) {
+	batch := &containerLogBatch{logBytes: 100, id: "chunk"}
+	for i := 0; i < 25; i++ {
+		batch.msgPackEntries = append(batch.msgPackEntries, MsgPackEntry{Record: map[string]string{
+			"ContainerId": "c" + strconv.Itoa(i%5),
+			"LogMessage":  strconv.Itoa(i),
+		}})
+	}
+	subBatches := batch.split(10)
+	if len(subBatches) == 0 || len(subBatches) > 3 {
+		t.Fatalf("split() = %d sub-batches, want 1 to 3", len(subBatches))
+	}
+	records, logBytes, ids := 0, 0, map[string]bool{}
+	containers := map[string]int{}
+	for i, subBatch := range subBatches {
+		records += subBatch.len()
+		logBytes += subBatch.logBytes
+		ids[subBatch.id] = true
+		last := map[string]int{}
+		for _, entry := range subBatch.msgPackEntries {
+			container := entry.Record["ContainerId"]
+			if sub, ok := containers[container]; ok && sub != i {
+				t.Errorf("the records of container %s are in sub-batches %d and %d", container, sub, i)
+			}
+			containers[container] = i
+			line, _ := strconv.Atoi(entry.Record["LogMessage"])
+			if previous, ok := last[container]; ok && line < previous {
+				t.Errorf("record %d of container %s is after record %d", line, container, previous)
+			}
+			last[container] = line
+		}
+	}
+	if records != 25 {
+		t.Errorf("the sub-batches have %d records, want 25", records)
+	}
+	if logBytes > 100 {
+		t.Errorf("the sub-batches have %d log bytes, want at most 100", logBytes)
+	}
+	if len(ids) != len(subBatches) || ids[""] {
+		t.Errorf("the sub-batch ids %v are not distinct", ids)
+	}
+	if again := batch.split(10); len(again) != len(subBatches) || again[0].id != subBatches[0].id || again[0].len() != subBatches[0].len() {
+		t.Errorf("split() of the same records returned different sub-batches")
+	}
+}
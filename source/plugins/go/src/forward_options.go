@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// adds the forward protocol option map (size and chunk id) to the container log messages written to mdsd, when true.
+// mdsd acknowledges the chunks, so a message is only reported flushed once mdsd has it
+const envMdsdForwardOptions = "AZMON_MDSD_FORWARD_OPTIONS"
+
+const mdsdForwardAckTimeout = 10 * time.Second
+
+// MdsdForwardOptions when true, the container log messages carry the forward options and are acknowledged by mdsd
+var MdsdForwardOptions bool
+
+// MsgPackForwardOption the option map of a forward mode message
+type MsgPackForwardOption struct {
+	// number of entries of the message
+	Size int `msg:"size"`
+	// gzip when the entries are a compressed packed stream, empty otherwise
+	Compressed string `msg:"compressed"`
+	// id of the message the receiver acknowledges with {"ack": chunk}, empty when no ack is expected
+	Chunk string `msg:"chunk"`
+}
+
+// initializeMdsdForwardOptions reads whether the container log messages carry the forward options. The entries are
+// compressed when gzip is accepted on the v2 route (AZMON_V2_ACCEPTED_ENCODINGS or v2_accepted_encodings)
+func initializeMdsdForwardOptions() {
+	MdsdForwardOptions = false
+	if ContainerLogsRouteV2 == false {
+		return
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv(envMdsdForwardOptions)), "true") {
+		MdsdForwardOptions = true
+		Log("Forward options enabled for the container logs of the %s route", getContainerLogsRouteName())
+	}
+	if encoding := selectRouteEncoding(ContainerLogsV2Route); encoding != encodingNone {
+		Log("Container log entries are written to mdsd as a %s compressed packed forward stream", encoding)
+	}
+}
+
+// newMdsdForwardOption returns the option of a container log message with the number of entries, nil when the message
+// is a plain forward mode message
+func newMdsdForwardOption(size int) *MsgPackForwardOption {
+	encoding := selectRouteEncoding(ContainerLogsV2Route)
+	if MdsdForwardOptions == false && encoding == encodingNone {
+		return nil
+	}
+	option := &MsgPackForwardOption{Size: size}
+	if encoding == encodingGzip {
+		option.Compressed = encodingGzip
+	}
+	if MdsdForwardOptions {
+		option.Chunk = newForwardChunkID()
+	}
+	return option
+}
+
+// newForwardChunkID returns a unique id of 128 bits, base64 encoded as by the fluentd forward clients
+func newForwardChunkID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return base64.StdEncoding.EncodeToString(id)
+}
+
+// appendForwardMessage appends the message with the tag and the msgpack stream of the entries to b. The message is
+// [tag, [entry, ...]] without option, [tag, [entry, ...], option] with an option, and
+// [tag, bin(gzip(entry stream)), option] when the option says the entries are compressed
+func appendForwardMessage(b []byte, tag string, entries []byte, numEntries int, option *MsgPackForwardOption) ([]byte, error) {
+	if option == nil {
+		b = append(b, 0x92)
+		b = msgp.AppendString(b, tag)
+		b = msgp.AppendArrayHeader(b, uint32(numEntries))
+		return append(b, entries...), nil
+	}
+
+	b = append(b, 0x93)
+	b = msgp.AppendString(b, tag)
+	if option.Compressed != "" {
+		compressed, err := compressPayload(option.Compressed, entries)
+		if err != nil {
+			return nil, err
+		}
+		b = msgp.AppendBytes(b, compressed)
+	} else {
+		b = msgp.AppendArrayHeader(b, uint32(numEntries))
+		b = append(b, entries...)
+	}
+
+	fields := uint32(1)
+	if option.Compressed != "" {
+		fields++
+	}
+	if option.Chunk != "" {
+		fields++
+	}
+	b = msgp.AppendMapHeader(b, fields)
+	b = msgp.AppendString(b, "size")
+	b = msgp.AppendInt(b, option.Size)
+	if option.Compressed != "" {
+		b = msgp.AppendString(b, "compressed")
+		b = msgp.AppendString(b, option.Compressed)
+	}
+	if option.Chunk != "" {
+		b = msgp.AppendString(b, "chunk")
+		b = msgp.AppendString(b, option.Chunk)
+	}
+	return b, nil
+}
+
+// readForwardAck waits for the {"ack": chunk} response of the receiver to the message with the chunk id
+func readForwardAck(conn net.Conn, chunk string) error {
+	conn.SetReadDeadline(time.Now().Add(mdsdForwardAckTimeout))
+	reader := msgp.NewReader(conn)
+	fields, err := reader.ReadMapHeader()
+	if err != nil {
+		return err
+	}
+	ack := ""
+	for i := uint32(0); i < fields; i++ {
+		key, err := reader.ReadString()
+		if err != nil {
+			return err
+		}
+		if key == "ack" {
+			if ack, err = reader.ReadString(); err != nil {
+				return err
+			}
+		} else if err = reader.Skip(); err != nil {
+			return err
+		}
+	}
+	if ack != chunk {
+		return fmt.Errorf("unexpected ack %q for chunk %q", ack, chunk)
+	}
+	return nil
+}
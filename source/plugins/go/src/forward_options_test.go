@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+func Test_appendForwardMessage(t *testing.T) {
+	type test_struct struct {
+		testname string
+		option   *MsgPackForwardOption
+		// number of elements of the message
+		elements uint32
+		want     map[string]string
+	}
+
+	tests := []test_struct{
+		{"no option", nil, 2, nil},
+		{"size and chunk", &MsgPackForwardOption{Size: 2, Chunk: "abc="}, 3, map[string]string{"chunk": "abc="}},
+		{"compressed", &MsgPackForwardOption{Size: 2, Compressed: encodingGzip}, 3, map[string]string{"compressed": encodingGzip}},
+	}
+
+	var entries []byte
+	for i := 0; i < 2; i++ {
+		entries = append(entries, 0x92)
+		entries = msgp.AppendInt64(entries, 1700000000)
+		entries = msgp.AppendMapStrStr(entries, map[string]string{"LogMessage": "hello"})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			message, err := appendForwardMessage(nil, "mdsd.tag", entries, 2, tt.option)
+			if err != nil {
+				t.Fatalf("appendForwardMessage() error = %v", err)
+			}
+			elements, b, err := msgp.ReadArrayHeaderBytes(message)
+			if err != nil || elements != tt.elements {
+				t.Fatalf("message has %d elements (%v), want %d", elements, err, tt.elements)
+			}
+			if _, b, err = msgp.ReadStringBytes(b); err != nil {
+				t.Fatal(err)
+			}
+			var stream []byte
+			if tt.option != nil && tt.option.Compressed != "" {
+				var compressed []byte
+				if compressed, b, err = msgp.ReadBytesZC(b); err != nil {
+					t.Fatal(err)
+				}
+				reader, err := gzip.NewReader(bytes.NewReader(compressed))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stream, err = ioutil.ReadAll(reader); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				var size uint32
+				if size, b, err = msgp.ReadArrayHeaderBytes(b); err != nil || size != 2 {
+					t.Fatalf("entries array of %d (%v), want 2", size, err)
+				}
+				stream = b[:len(entries)]
+				b = b[len(entries):]
+			}
+			if !bytes.Equal(stream, entries) {
+				t.Errorf("entries = %v, want %v", stream, entries)
+			}
+			if tt.option == nil {
+				if len(b) != 0 {
+					t.Errorf("%d bytes after the entries, want none", len(b))
+				}
+				return
+			}
+			fields, b, err := msgp.ReadMapHeaderBytes(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			options := make(map[string]string)
+			for i := uint32(0); i < fields; i++ {
+				var key string
+				if key, b, err = msgp.ReadStringBytes(b); err != nil {
+					t.Fatal(err)
+				}
+				if key == "size" {
+					var size int64
+					if size, b, err = msgp.ReadInt64Bytes(b); err != nil || size != 2 {
+						t.Errorf("size = %d (%v), want 2", size, err)
+					}
+					continue
+				}
+				if options[key], b, err = msgp.ReadStringBytes(b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(options) != len(tt.want) || len(b) != 0 {
+				t.Errorf("options = %v, want size and %v", options, tt.want)
+			}
+			for key, value := range tt.want {
+				if options[key] != value {
+					t.Errorf("option %s = %s, want %s", key, options[key], value)
+				}
+			}
+		})
+	}
+}
+
+func Test_readForwardAck(t *testing.T) {
+	type test_struct struct {
+		testname string
+		response []byte
+		wantErr  bool
+	}
+
+	ack := msgp.AppendMapHeader(nil, 1)
+	ack = msgp.AppendString(ack, "ack")
+	ack = msgp.AppendString(ack, "abc=")
+	otherAck := msgp.AppendMapHeader(nil, 1)
+	otherAck = msgp.AppendString(otherAck, "ack")
+	otherAck = msgp.AppendString(otherAck, "xyz=")
+
+	tests := []test_struct{
+		{"ack of the chunk", ack, false},
+		{"ack of another chunk", otherAck, true},
+		{"not an ack", msgp.AppendString(nil, "ack"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				server.Write(tt.response)
+				server.Close()
+			}()
+			if err := readForwardAck(client, "abc="); (err != nil) != tt.wantErr {
+				t.Errorf("readForwardAck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
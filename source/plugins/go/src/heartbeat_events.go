@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeartbeatEventCategory is the KubeMonAgentEvent category for the health heartbeats of the agent, so the agents which
+// are stuck can be told apart from the agents with no data
+const HeartbeatEventCategory = "container.azm.ms/heartbeat"
+
+// env variable to send a heartbeat record with each flush of the KubeMonAgentEvents, not sent by default
+const envKubeMonHeartbeatEnabled = "AZMON_KUBEMON_HEARTBEAT_ENABLED"
+
+const (
+	connectivityConnected    = "Connected"
+	connectivityDisconnected = "Disconnected"
+)
+
+var (
+	// HeartbeatEnabled when true, a heartbeat record is sent with each flush of the KubeMonAgentEvents
+	HeartbeatEnabled bool
+	// HeartbeatAgentVersion the version of the agent in the heartbeats
+	HeartbeatAgentVersion string
+
+	heartbeatMutex = &sync.Mutex{}
+	// the container log records flushed and the time at the last heartbeat, for the records per second
+	lastHeartbeatTime           time.Time
+	lastHeartbeatFlushedRecords float64
+)
+
+// initializeHeartbeats reads whether the heartbeats are sent
+func initializeHeartbeats(agentVersion string) {
+	HeartbeatAgentVersion = agentVersion
+	HeartbeatEnabled = strings.EqualFold(strings.TrimSpace(os.Getenv(envKubeMonHeartbeatEnabled)), "true")
+	heartbeatMutex.Lock()
+	lastHeartbeatTime = time.Now()
+	lastHeartbeatFlushedRecords = PluginMetrics.getFlushedRecords()
+	heartbeatMutex.Unlock()
+	if HeartbeatEnabled {
+		Log("A heartbeat KubeMonAgentEvents record is sent every %s", KubeMonAgentEventsFlushInterval)
+	}
+}
+
+// takeHeartbeatEvent returns the heartbeat of the flush at now, with the container log records per second since the
+// last heartbeat. Empty when the heartbeats are not sent
+func takeHeartbeatEvent(now time.Time) map[string]KubeMonAgentEventTags {
+	events := make(map[string]KubeMonAgentEventTags)
+	if !HeartbeatEnabled {
+		return events
+	}
+	route := getContainerLogsRouteName()
+	flushedRecords := PluginMetrics.getFlushedRecords()
+	heartbeatMutex.Lock()
+	recordsPerSecond := 0.0
+	if elapsed := now.Sub(lastHeartbeatTime).Seconds(); elapsed > 0 {
+		recordsPerSecond = (flushedRecords - lastHeartbeatFlushedRecords) / elapsed
+	}
+	lastHeartbeatTime = now
+	lastHeartbeatFlushedRecords = flushedRecords
+	heartbeatMutex.Unlock()
+
+	tags := KubeMonAgentEventTags{
+		FirstOccurrence:  now.UTC().Format(time.RFC3339),
+		LastOccurrence:   now.UTC().Format(time.RFC3339),
+		Count:            1,
+		AgentVersion:     HeartbeatAgentVersion,
+		Route:            route,
+		MDSDConnectivity: getMDSDConnectivity(),
+		ADXConnectivity:  getADXConnectivity(),
+		RecordsPerSecond: strconv.FormatFloat(recordsPerSecond, 'f', 2, 64),
+	}
+	if lastFlush := PluginMetrics.getLastSuccessfulFlush(route); !lastFlush.IsZero() {
+		tags.LastSuccessfulFlush = lastFlush.UTC().Format(time.RFC3339)
+	}
+	events["Heartbeat"] = tags
+	return events
+}
+
+// getMDSDConnectivity returns whether the mdsd clients the agent sends to are connected, empty on windows
+func getMDSDConnectivity() string {
+	if IsWindows == true {
+		return ""
+	}
+	if MdsdKubeMonMsgpUnixSocketClient == nil || MdsdInsightsMetricsMsgpUnixSocketClient == nil {
+		return connectivityDisconnected
+	}
+	if (ContainerLogsRouteV2 == true || ContainerLogsRouteGeneva == true) && !MdsdContainerLogConnPool.connected() {
+		return connectivityDisconnected
+	}
+	return connectivityConnected
+}
+
+// getADXConnectivity returns whether the ADX client is created, empty when nothing is sent to ADX
+func getADXConnectivity() string {
+	if ContainerLogsRouteADX == false && TelegrafMetricsRouteADX == false {
+		return ""
+	}
+	if ADXIngestor == nil {
+		return connectivityDisconnected
+	}
+	return connectivityConnected
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+func Test_takeHeartbeatEvent(t *testing.T) {
+	type test_struct struct {
+		testName        string
+		enabled         bool
+		flushedRecords  int
+		flushed         bool
+		wantEvents      int
+		wantRecordsRate string
+	}
+
+	// the heartbeats taken in order, 10 seconds apart
+	tests := []test_struct{
+		{"disabled", false, 0, false, 0, ""},
+		{"no flush yet", true, 0, false, 1, "0.00"},
+		{"records flushed", true, 25, true, 1, "2.50"},
+	}
+
+	defer func(metrics *pluginMetrics, isWindows bool) {
+		PluginMetrics, IsWindows, HeartbeatEnabled = metrics, isWindows, false
+	}(PluginMetrics, IsWindows)
+	PluginMetrics = newPluginMetrics()
+	IsWindows = true
+	initializeHeartbeats("1.0.0")
+	now := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			HeartbeatEnabled = tt.enabled
+			now = now.Add(10 * time.Second)
+			if tt.flushed {
+				PluginMetrics.addFlushedRecords(tt.flushedRecords, time.Second)
+				PluginMetrics.addFlushOutcome(getContainerLogsRouteName(), output.FLB_OK)
+			}
+			events := takeHeartbeatEvent(now)
+			if len(events) != tt.wantEvents {
+				t.Fatalf("takeHeartbeatEvent() = %v, want %d events", events, tt.wantEvents)
+			}
+			for _, tags := range events {
+				if tags.AgentVersion != "1.0.0" || tags.Route != getContainerLogsRouteName() || tags.RecordsPerSecond != tt.wantRecordsRate || (tags.LastSuccessfulFlush != "") != tt.flushed {
+					t.Errorf("takeHeartbeatEvent() = %+v, want %s records per second", tags, tt.wantRecordsRate)
+				}
+			}
+		})
+	}
+}
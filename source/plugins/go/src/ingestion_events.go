@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IngestionEventCategory is the KubeMonAgentEvent category for the failures of the sends to mdsd and ADX
+const IngestionEventCategory = "container.azm.ms/ingestion"
+
+const (
+	// the max number of distinct errors kept as samples of the failures of a route
+	maxIngestionEventErrorSamples = 3
+	// the max length of an error sample
+	maxIngestionEventErrorSampleLength = 512
+)
+
+var (
+	// IngestionEvent hash of the send failures since the last KubeMonAgentEvents flush, by route and data type. It has
+	// its own mutex since the sends fail while the flushes hold EventHashUpdateMutex
+	IngestionEvent      = make(map[string]KubeMonAgentEventTags)
+	ingestionEventMutex = &sync.Mutex{}
+)
+
+// addIngestionFailureEvent adds a failed send of the data type to the route, the class of the last failure and the
+// first distinct errors being kept
+func addIngestionFailureEvent(route string, dataType string, err error, now time.Time) {
+	if err == nil {
+		return
+	}
+	message := fmt.Sprintf("Failed to send %s to the %s route", dataType, route)
+	sample := err.Error()
+	if len(sample) > maxIngestionEventErrorSampleLength {
+		sample = sample[:maxIngestionEventErrorSampleLength]
+	}
+	eventTimeStamp := now.UTC().Format(time.RFC3339)
+
+	ingestionEventMutex.Lock()
+	defer ingestionEventMutex.Unlock()
+	val, ok := IngestionEvent[message]
+	if !ok {
+		val = KubeMonAgentEventTags{
+			FirstOccurrence: eventTimeStamp,
+			Route:           route,
+			DataType:        dataType,
+		}
+	}
+	val.LastOccurrence = eventTimeStamp
+	val.Count = val.Count + 1
+	val.ErrorClass = classifySinkError(err)
+	if len(val.ErrorSamples) < maxIngestionEventErrorSamples && !containsString(val.ErrorSamples, sample) {
+		val.ErrorSamples = append(val.ErrorSamples, sample)
+	}
+	IngestionEvent[message] = val
+}
+
+// takeIngestionEvents returns the send failures since the last flush and clears them
+func takeIngestionEvents() map[string]KubeMonAgentEventTags {
+	ingestionEventMutex.Lock()
+	defer ingestionEventMutex.Unlock()
+	events := IngestionEvent
+	IngestionEvent = make(map[string]KubeMonAgentEventTags)
+	return events
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
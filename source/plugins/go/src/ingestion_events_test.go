@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_addIngestionFailureEvent(t *testing.T) {
+	type test_struct struct {
+		testname    string
+		route       string
+		errs        []error
+		count       int
+		errorClass  string
+		samples     []string
+		wantMessage string
+	}
+
+	unavailable := &sinkStatusError{statusCode: 503}
+	tests := []test_struct{
+		{
+			"deduped samples",
+			"v2",
+			[]error{errors.New("broken pipe"), errors.New("broken pipe"), errors.New("connection refused")},
+			3,
+			sinkFailureOther,
+			[]string{"broken pipe", "connection refused"},
+			"Failed to send CONTAINER_LOG_BLOB to the v2 route",
+		},
+		{
+			"samples limited",
+			"adx",
+			[]error{errors.New("a"), errors.New("b"), errors.New("c"), unavailable},
+			4,
+			sinkFailureServer,
+			[]string{"a", "b", "c"},
+			"Failed to send CONTAINER_LOG_BLOB to the adx route",
+		},
+		{
+			"no error",
+			"v2",
+			[]error{nil},
+			0,
+			"",
+			nil,
+			"",
+		},
+	}
+
+	first := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			for i, err := range tt.errs {
+				addIngestionFailureEvent(tt.route, ContainerLogDataType, err, first.Add(time.Duration(i)*time.Minute))
+			}
+			events := takeIngestionEvents()
+			if tt.wantMessage == "" {
+				if len(events) != 0 {
+					t.Errorf("got events %v, want none", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("got %d events %v, want 1", len(events), events)
+			}
+			tags, ok := events[tt.wantMessage]
+			if !ok {
+				t.Fatalf("got events %v, want the message %q", events, tt.wantMessage)
+			}
+			if tags.Count != tt.count || tags.ErrorClass != tt.errorClass || !reflect.DeepEqual(tags.ErrorSamples, tt.samples) {
+				t.Errorf("got %+v, want %d failures of class %s with the samples %v", tags, tt.count, tt.errorClass, tt.samples)
+			}
+			if tags.Route != tt.route || tags.DataType != ContainerLogDataType {
+				t.Errorf("got the route %s and data type %s, want %s and %s", tags.Route, tags.DataType, tt.route, ContainerLogDataType)
+			}
+			lastOccurrence := first.Add(time.Duration(len(tt.errs)-1) * time.Minute).Format(time.RFC3339)
+			if tags.FirstOccurrence != first.Format(time.RFC3339) || tags.LastOccurrence != lastOccurrence {
+				t.Errorf("got the occurrences %s and %s, want %s and %s", tags.FirstOccurrence, tags.LastOccurrence, first.Format(time.RFC3339), lastOccurrence)
+			}
+			if len(takeIngestionEvents()) != 0 {
+				t.Errorf("the events are not cleared once taken")
+			}
+		})
+	}
+}
@@ -19,8 +19,11 @@ const AMCSAgentConfigAPIVersion = "2020-08-01-preview"
 const AMCSIngestionTokenAPIVersion = "2020-04-01-preview"
 const MaxRetries = 3
 
-var IMDSToken string
-var IMDSTokenExpiration int64
+// AMCSAccessTokenProvider gets the AMCS access token from IMDS or with the workload identity
+var AMCSAccessTokenProvider *TokenProvider
+
+// ODSIngestionTokenProvider gets the ODS ingestion token from AMCS
+var ODSIngestionTokenProvider *TokenProvider
 
 var ConfigurationId string
 var ChannelId string
@@ -231,13 +234,14 @@ func getAgentConfiguration(imdsAccessToken string) (configurationId string, chan
 
 	var bearer = "Bearer " + imdsAccessToken
 	// Create a new request using http
-	req, err := http.NewRequest("GET", amcs_endpoint.String(), nil)
+	req, _, err := newRouteRequest(ParentContext, "GET", requestRouteAMCS, amcs_endpoint.String(), nil)
 	if err != nil {
 		message := fmt.Sprintf("getAgentConfiguration: Error creating HTTP request for AMCS endpoint: %s", err.Error())
 		Log(message)
 		return configurationId, channelId, err
 	}
 	req.Header.Set("Authorization", bearer)
+	logRouteRequest(requestRouteAMCS, req)
 
 	var resp *http.Response = nil
 	IsSuccess := false
@@ -339,7 +343,7 @@ func getIngestionAuthToken(imdsAccessToken string, configurationId string, chann
 
 	var bearer = "Bearer " + imdsAccessToken
 	// Create a new request using http
-	req, err := http.NewRequest("GET", amcs_endpoint.String(), nil)
+	req, _, err := newRouteRequest(ParentContext, "GET", requestRouteAMCS, amcs_endpoint.String(), nil)
 	if err != nil {
 		Log("getIngestionAuthToken: Error creating HTTP request for AMCS endpoint: %s", err.Error())
 		return ingestionAuthToken, refreshInterval, err
@@ -347,6 +351,7 @@ func getIngestionAuthToken(imdsAccessToken string, configurationId string, chann
 
 	// add authorization header to the req
 	req.Header.Add("Authorization", bearer)
+	logRouteRequest(requestRouteAMCS, req)
 
 	var resp *http.Response = nil
     IsSuccess := false
@@ -450,59 +455,52 @@ func getTokenRefreshIntervalFromAmcsResponse(header http.Header) (refreshInterva
 	return 0, errors.New("getTokenRefreshIntervalFromAmcsResponse: didn't find max-age in response header")
 }
 
-func refreshIngestionAuthToken() {
-	for ; true; <-IngestionAuthTokenRefreshTicker.C {
-		if IMDSToken == "" || IMDSTokenExpiration <= (time.Now().Unix() + 60 * 60) { // token valid 24 hrs and refresh token 1 hr before expiry
-			imdsToken, imdsTokenExpiry, err := getAccessTokenFromIMDS()
-			if err != nil {
-				message := fmt.Sprintf("refreshIngestionAuthToken: Error on getAccessTokenFromIMDS  %s \n", err.Error())
-				Log(message)
-				SendException(message)
-			} else {
-				IMDSToken = imdsToken
-				IMDSTokenExpiration = imdsTokenExpiry
-			}
-		}
-		if IMDSToken == "" {
-			message := "refreshIngestionAuthToken: IMDSToken is empty"
-			Log(message)
-			SendException(message)
-			continue
-		}
-		var err error
-		// ignore agent configuration expiring, the configuration and channel IDs will never change (without creating an agent restart)
-		if ConfigurationId == "" || ChannelId == "" {
-			ConfigurationId, ChannelId, err = getAgentConfiguration(IMDSToken)
-			if err != nil {
-				message := fmt.Sprintf("refreshIngestionAuthToken: Error getAgentConfiguration %s \n", err.Error())
-				Log(message)
-				SendException(message)
-				continue
-			}
-		}
-		if IMDSToken == "" || ConfigurationId == "" || ChannelId == ""  {
-			message := "refreshIngestionAuthToken: IMDSToken or ConfigurationId or ChannelId empty"
-			Log(message)
-			SendException(message)
-			continue
-		}
-		ingestionAuthToken, refreshIntervalInSeconds, err := getIngestionAuthToken(IMDSToken, ConfigurationId, ChannelId)
+// initializeODSIngestionTokenProvider sets up the AMCS access token (IMDS MSI or workload identity) and the ODS ingestion
+// token providers and starts refreshing the ingestion token in the background
+func initializeODSIngestionTokenProvider() {
+	if IsWorkloadIdentityAuthMode == true {
+		AMCSAccessTokenProvider = newWorkloadIdentityTokenProvider("", "", fmt.Sprintf("https://%s/.default", os.Getenv("MCS_ENDPOINT")))
+	} else {
+		// token valid 24 hrs and refresh token 1 hr before expiry
+		AMCSAccessTokenProvider = getOrCreateTokenProvider("msi:amcs", time.Hour, getAccessTokenFromIMDS)
+	}
+	ODSIngestionTokenProvider = getOrCreateTokenProvider("ods:ingestion", 5*time.Minute, getODSIngestionAuthTokenFromAMCS)
+	ODSIngestionTokenProvider.startProactiveRefresh()
+}
+
+// getODSIngestionAuthTokenFromAMCS gets the ingestion token from AMCS and returns it with the time it should be
+// refreshed by, based on the max-age of the response
+func getODSIngestionAuthTokenFromAMCS() (string, int64, error) {
+	amcsAccessToken, err := AMCSAccessTokenProvider.Token()
+	if amcsAccessToken == "" {
+		return "", 0, fmt.Errorf("getODSIngestionAuthTokenFromAMCS: AMCS access token is empty: %v", err)
+	}
+	// ignore agent configuration expiring, the configuration and channel IDs will never change (without creating an agent restart)
+	if ConfigurationId == "" || ChannelId == "" {
+		ConfigurationId, ChannelId, err = getAgentConfiguration(amcsAccessToken)
 		if err != nil {
-			message := fmt.Sprintf("refreshIngestionAuthToken: Error getIngestionAuthToken %s \n", err.Error())
-			Log(message)
-			SendException(message)
-			continue
-		}
-		IngestionAuthTokenUpdateMutex.Lock()
-		ODSIngestionAuthToken = ingestionAuthToken
-		IngestionAuthTokenUpdateMutex.Unlock()
-		if refreshIntervalInSeconds > 0 && refreshIntervalInSeconds != defaultIngestionAuthTokenRefreshIntervalSeconds {
-			//TODO - use Reset which is better when go version upgraded to 1.15 or up rather Stop() and NewTicker
-			//IngestionAuthTokenRefreshTicker.Reset(time.Second * time.Duration(refreshIntervalInSeconds))
-			IngestionAuthTokenRefreshTicker.Stop()
-			IngestionAuthTokenRefreshTicker = time.NewTicker(time.Second * time.Duration(refreshIntervalInSeconds))
+			return "", 0, fmt.Errorf("getODSIngestionAuthTokenFromAMCS: Error getAgentConfiguration %s", err.Error())
 		}
 	}
+	if ConfigurationId == "" || ChannelId == "" {
+		return "", 0, errors.New("getODSIngestionAuthTokenFromAMCS: ConfigurationId or ChannelId empty")
+	}
+	ingestionAuthToken, refreshIntervalInSeconds, err := getIngestionAuthToken(amcsAccessToken, ConfigurationId, ChannelId)
+	if err != nil {
+		return "", 0, fmt.Errorf("getODSIngestionAuthTokenFromAMCS: Error getIngestionAuthToken %s", err.Error())
+	}
+	if refreshIntervalInSeconds <= 0 {
+		refreshIntervalInSeconds = defaultIngestionAuthTokenRefreshIntervalSeconds
+	}
+	return ingestionAuthToken, time.Now().Unix() + refreshIntervalInSeconds, nil
+}
+
+// getODSIngestionAuthToken returns the ingestion token for the ODS posts, empty when it is not available
+func getODSIngestionAuthToken() string {
+	if ODSIngestionTokenProvider == nil {
+		return ""
+	}
+	return ODSIngestionTokenProvider.OAuthToken()
 }
 
 func IsRetriableError(httpStatusCode int) bool {
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// comma separated fields of the json log lines promoted to columns of the records, on the mdsd and ADX routes. A
+// field is promoted to the column of its name, or to the column after = (e.g. trace_id=TraceId). Nested fields are
+// named with dots (e.g. http.status)
+const envJSONPromotedFields = "AZMON_JSON_PROMOTED_FIELDS"
+
+// the columns of the container log schemas, which the promoted fields can't override
+var reservedPromotedColumns = map[string]bool{
+	"Id": true, "Image": true, "Name": true, "SourceSystem": true, "Computer": true, "TimeOfCommand": true,
+	"LogEntry": true, "LogEntrySource": true, "LogEntryTimeStamp": true, "LogEntryTimeOfCommand": true,
+	"TimeGenerated": true, "ContainerId": true, "ContainerName": true, "PodName": true, "PodNamespace": true,
+	"LogMessage": true, "LogSource": true, "AzureResourceId": true, "PodUid": true, "RestartCount": true,
+	"ContainerLabels": true, "LogLevel": true,
+}
+
+// jsonPromotedField a field of the json log lines and the column it is promoted to
+type jsonPromotedField struct {
+	path   []string
+	column string
+}
+
+// JSONPromotedFields the fields of the json log lines promoted to columns, empty when the promotion is disabled
+var JSONPromotedFields []jsonPromotedField
+
+// initializeJSONFieldPromotion reads the fields promoted to columns, after the route is set up
+func initializeJSONFieldPromotion() {
+	JSONPromotedFields = nil
+	value := strings.TrimSpace(os.Getenv(envJSONPromotedFields))
+	if value == "" {
+		return
+	}
+	if ContainerLogsRouteV2 == false && ContainerLogsRouteADX == false {
+		Log("%s is only supported on the %s and %s routes, no json field is promoted", envJSONPromotedFields, ContainerLogsV2Route, ContainerLogsADXRoute)
+		return
+	}
+	fields, err := parseJSONPromotedFields(value)
+	if err != nil {
+		Log("Error::jsonfields::Invalid %s, no json field is promoted: %s", envJSONPromotedFields, err.Error())
+		return
+	}
+	JSONPromotedFields = fields
+	Log("Promoting the json log fields %s to columns", value)
+}
+
+func parseJSONPromotedFields(value string) ([]jsonPromotedField, error) {
+	var fields []jsonPromotedField
+	columns := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, column := entry, entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			name, column = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
+		if name == "" || column == "" {
+			return nil, fmt.Errorf("the field %q has no name or column", entry)
+		}
+		if reservedPromotedColumns[column] {
+			return nil, fmt.Errorf("the column %s of the field %s is a column of the container log schema", column, name)
+		}
+		if columns[column] {
+			return nil, fmt.Errorf("the column %s is set by more than one field", column)
+		}
+		columns[column] = true
+		fields = append(fields, jsonPromotedField{path: strings.Split(name, "."), column: column})
+	}
+	return fields, nil
+}
+
+// promoteJSONFields returns the columns of the promoted fields of a json log line, nil when the line is not json or
+// has none of the fields. The string values are promoted as is, the other values as json
+func promoteJSONFields(logEntry string, fields []jsonPromotedField) map[string]string {
+	trimmed := strings.TrimSpace(logEntry)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil
+	}
+	var parsed map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil
+	}
+	var columns map[string]string
+	for _, field := range fields {
+		value, ok := lookupJSONField(parsed, field.path)
+		if !ok || value == nil {
+			continue
+		}
+		if columns == nil {
+			columns = make(map[string]string, len(fields))
+		}
+		if s, isString := value.(string); isString {
+			columns[field.column] = s
+		} else if b, err := json.Marshal(value); err == nil {
+			columns[field.column] = string(b)
+		}
+	}
+	return columns
+}
+
+func lookupJSONField(parsed map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = parsed
+	for _, name := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// MarshalJSON adds the promoted fields to the columns of the item, which the ingestion mapping of the table maps
+func (d DataItemADX) MarshalJSON() ([]byte, error) {
+	type dataItemADX DataItemADX
+	item, err := json.Marshal(dataItemADX(d))
+	if err != nil || len(d.PromotedFields) == 0 {
+		return item, err
+	}
+	promoted, err := json.Marshal(d.PromotedFields)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.Write(item[:len(item)-1])
+	b.WriteByte(',')
+	b.Write(promoted[1:])
+	return b.Bytes(), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_parseJSONPromotedFields(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		columns  []string
+		isError  bool
+	}
+
+	tests := []test_struct{
+		{"fields", "msg, level,", []string{"msg", "level"}, false},
+		{"renamed field", "trace_id=TraceId,http.status=HttpStatus", []string{"TraceId", "HttpStatus"}, false},
+		{"reserved column", "msg=LogMessage", nil, true},
+		{"duplicate column", "msg,message=msg", nil, true},
+		{"no column", "msg=", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			fields, err := parseJSONPromotedFields(tt.value)
+			if (err != nil) != tt.isError {
+				t.Fatalf("parseJSONPromotedFields() error = %v, want error %v", err, tt.isError)
+			}
+			var columns []string
+			for _, field := range fields {
+				columns = append(columns, field.column)
+			}
+			if !reflect.DeepEqual(columns, tt.columns) {
+				t.Errorf("parseJSONPromotedFields() columns = %v, want %v", columns, tt.columns)
+			}
+		})
+	}
+}
+
+func Test_promoteJSONFields(t *testing.T) {
+	type test_struct struct {
+		testname string
+		logEntry string
+		output   map[string]string
+	}
+
+	fields, err := parseJSONPromotedFields("msg,level,trace_id=TraceId,http.status=HttpStatus,tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []test_struct{
+		{"not json", "plain text\n", nil},
+		{"invalid json", `{"msg": "a"`, nil},
+		{"no promoted field", `{"other": 1}`, nil},
+		{"promoted fields", `{"msg":"started","level":"info","trace_id":"abc"}` + "\n", map[string]string{"msg": "started", "level": "info", "TraceId": "abc"}},
+		{"nested and non string fields", `{"http":{"status":503},"tags":["a","b"],"msg":null}`, map[string]string{"HttpStatus": "503", "tags": `["a","b"]`}},
+		{"large number", `{"msg":12345678901234567890}`, map[string]string{"msg": "12345678901234567890"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := promoteJSONFields(tt.logEntry, fields); !reflect.DeepEqual(got, tt.output) {
+				t.Errorf("promoteJSONFields() = %v, want %v", got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_DataItemADX_MarshalJSON(t *testing.T) {
+	type test_struct struct {
+		testname string
+		item     DataItemADX
+		output   map[string]interface{}
+	}
+
+	tests := []test_struct{
+		{"no promoted fields", DataItemADX{LogMessage: "a"}, map[string]interface{}{"LogMessage": "a"}},
+		{"promoted fields", DataItemADX{LogMessage: "a", PromotedFields: map[string]string{"TraceId": "abc"}}, map[string]interface{}{"LogMessage": "a", "TraceId": "abc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			b, err := json.Marshal(tt.item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("invalid json %s: %v", string(b), err)
+			}
+			for key, value := range tt.output {
+				if got[key] != value {
+					t.Errorf("MarshalJSON() %s = %v, want %v in %s", key, got[key], value, string(b))
+				}
+			}
+			if _, ok := got["PromotedFields"]; ok {
+				t.Errorf("MarshalJSON() = %s, want no PromotedFields", string(b))
+			}
+		})
+	}
+}
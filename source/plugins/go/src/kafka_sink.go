@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// settings of a Kafka sink, read like the route settings: AZMON_KAFKA_<SETTING> or kafka_<setting> in the plugin
+// configuration for the kafka route, and AZMON_<NAME>_<SETTING> for a declared kafka sink
+const (
+	// the bootstrap brokers as host:port separated by commas, e.g. mynamespace.servicebus.windows.net:9093
+	kafkaSettingBrokers = "brokers"
+	kafkaSettingTopic   = "topic"
+	// SASL PLAIN credentials, the password is read from a file like a mounted secret
+	kafkaSettingSASLUsername     = "sasl_username"
+	kafkaSettingSASLPasswordPath = "sasl_password_path"
+	// TLS is on by default, false to connect to a plaintext listener
+	kafkaSettingTLS = "tls"
+	// max number of records of a produce request
+	kafkaSettingBatchSize = "batch_size"
+	// the partition key of the sink, the one of the streaming route or pod when not set
+	kafkaSettingPartitionKey = "partition_key"
+)
+
+const (
+	// the SASL user of the Kafka endpoint of Event Hubs, with the connection string as password
+	eventHubsKafkaUsername = "$ConnectionString"
+	defaultKafkaBatchSize  = 500
+	// the max size of a record of Event Hubs and of the default broker configuration
+	kafkaMaxMessageBytes = 1000 * 1000
+)
+
+// kafkaConfig the brokers, credentials and topic a Kafka sink publishes to
+type kafkaConfig struct {
+	brokers      []string
+	topic        string
+	username     string
+	password     string
+	tls          bool
+	tlsConfig    *tls.Config
+	batchSize    int
+	partitionKey string
+}
+
+var (
+	// ContainerLogsRouteKafka when true, the container logs are published to a Kafka topic in the ContainerLogV2 schema
+	ContainerLogsRouteKafka bool
+	// KafkaRouteConfig the topic of the kafka route
+	KafkaRouteConfig kafkaConfig
+)
+
+// newKafkaConfig reads the Kafka settings of the route or the declared sink
+func newKafkaConfig(name string) (kafkaConfig, error) {
+	config := kafkaConfig{
+		topic:     routeSetting(name, kafkaSettingTopic),
+		username:  routeSetting(name, kafkaSettingSASLUsername),
+		tls:       !strings.EqualFold(routeSetting(name, kafkaSettingTLS), "false"),
+		batchSize: defaultKafkaBatchSize,
+	}
+	for _, broker := range strings.Split(routeSetting(name, kafkaSettingBrokers), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			config.brokers = append(config.brokers, broker)
+		}
+	}
+	if len(config.brokers) == 0 || config.topic == "" {
+		return config, errors.New("the brokers and the topic are required")
+	}
+	if config.tls {
+		tlsConfig, err := newRouteDialTLSConfig(name)
+		if err != nil {
+			return config, err
+		}
+		config.tlsConfig = tlsConfig
+	}
+	if path := routeSetting(name, kafkaSettingSASLPasswordPath); path != "" {
+		password, err := ReadFileContents(path)
+		if err != nil {
+			return config, err
+		}
+		config.password = password
+		if config.username == "" {
+			config.username = eventHubsKafkaUsername
+		}
+	}
+	if value := routeSetting(name, kafkaSettingBatchSize); value != "" {
+		batchSize, err := strconv.Atoi(value)
+		if err != nil || batchSize <= 0 {
+			return config, fmt.Errorf("invalid batch size %s", value)
+		}
+		config.batchSize = batchSize
+	}
+	switch value := routeSetting(name, kafkaSettingPartitionKey); {
+	case value != "":
+		key, err := parsePartitionKey(value)
+		if err != nil {
+			return config, err
+		}
+		config.partitionKey = key
+	case routeSetting(streamingRoute, "partition_key") != "":
+		config.partitionKey = StreamingPartitionKey
+	default:
+		config.partitionKey = partitionKeyPod
+	}
+	return config, nil
+}
+
+// initializeKafkaRoute reads the topic of the kafka route, and returns whether the container logs can be sent thru it
+func initializeKafkaRoute() bool {
+	config, err := newKafkaConfig(ContainerLogsKafkaRoute)
+	if err != nil {
+		Log("Error::kafka::Unable to route the container logs thru the %s route: %s", ContainerLogsKafkaRoute, err.Error())
+		return false
+	}
+	KafkaRouteConfig = config
+	Log("Publishing container logs to the topic %s of %s, keyed on %s", config.topic, strings.Join(config.brokers, ","), config.partitionKey)
+	return true
+}
+
+// newSaramaConfig returns the producer configuration, publishing in batches of the batch size and acknowledged by all
+// the in-sync replicas. The partitions are picked by the sink
+func (c kafkaConfig) newSaramaConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.ClientID = agentName
+	// Event Hubs supports the Kafka protocol from 1.0
+	config.Version = sarama.V1_0_0_0
+	config.Net.TLS.Enable = c.tls
+	if c.tls {
+		config.Net.TLS.Config = c.tlsConfig
+		if config.Net.TLS.Config == nil {
+			config.Net.TLS.Config = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+	}
+	if c.password != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		config.Net.SASL.User = c.username
+		config.Net.SASL.Password = c.password
+	}
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = sarama.NewManualPartitioner
+	config.Producer.MaxMessageBytes = kafkaMaxMessageBytes
+	config.Producer.Flush.MaxMessages = c.batchSize
+	config.Producer.Timeout = 30 * time.Second
+	return config
+}
+
+// kafkaSink publishes the records to a Kafka topic as ContainerLogV2 JSON, one message per record
+type kafkaSink struct {
+	sinkHealth
+	name   string
+	config kafkaConfig
+
+	mu       sync.Mutex
+	client   sarama.Client
+	producer sarama.SyncProducer
+}
+
+// newDeclaredKafkaSink creates a Kafka sink from the brokers, topic, credentials, batch_size and partition_key
+// settings of the sink
+func newDeclaredKafkaSink(name string) (Sink, error) {
+	config, err := newKafkaConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaSink{name: name, config: config}, nil
+}
+
+func (s *kafkaSink) Name() string { return s.name }
+
+func (s *kafkaSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	return s.setSendResult(s.send(batch))
+}
+
+// getProducer returns the client and the producer, connected again when the previous connection failed
+func (s *kafkaSink) getProducer() (sarama.Client, sarama.SyncProducer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.producer != nil {
+		return s.client, s.producer, nil
+	}
+	client, err := sarama.NewClient(s.config.brokers, s.config.newSaramaConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	s.client, s.producer = client, producer
+	return client, producer, nil
+}
+
+// closeProducer drops the connection after a failed publish, so the next send connects again
+func (s *kafkaSink) closeProducer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.producer != nil {
+		s.producer.Close()
+		// the producer of a client doesn't close it
+		s.client.Close()
+	}
+	s.client, s.producer = nil, nil
+}
+
+func (s *kafkaSink) send(batch *containerLogBatch) error {
+	if len(batch.dataItemsLAv2) == 0 {
+		return nil
+	}
+	client, producer, err := s.getProducer()
+	if err != nil {
+		Log("Error::kafka::Unable to connect to the brokers %s: %s", strings.Join(s.config.brokers, ","), err.Error())
+		PluginMetrics.addClientCreateError("kafka")
+		return err
+	}
+	partitions, err := client.Partitions(s.config.topic)
+	if err != nil || len(partitions) == 0 {
+		if err == nil {
+			err = fmt.Errorf("the topic %s has no partition", s.config.topic)
+		}
+		Log("Error::kafka::Unable to get the partitions of the topic %s: %s", s.config.topic, err.Error())
+		return err
+	}
+
+	messages := make([]*sarama.ProducerMessage, 0, len(batch.dataItemsLAv2))
+	for i := range batch.dataItemsLAv2 {
+		item := &batch.dataItemsLAv2[i]
+		value, err := json.Marshal(item)
+		if err != nil {
+			Log("Error::kafka::Unable to marshal the record of container %s: %s", item.ContainerId, err.Error())
+			continue
+		}
+		key := recordPartitionKey(s.config.partitionKey, map[string]string{"PodNamespace": item.PodNamespace, "PodName": item.PodName, "ContainerId": item.ContainerId})
+		message := &sarama.ProducerMessage{
+			Topic:     s.config.topic,
+			Value:     sarama.ByteEncoder(value),
+			Partition: partitions[partitionForKey(key, len(partitions))],
+		}
+		if key != "" {
+			message.Key = sarama.StringEncoder(key)
+		}
+		messages = append(messages, message)
+	}
+
+	sendStart := time.Now()
+	err = producer.SendMessages(messages)
+	trackFlushDependency(dependencyTypeKafka, s.config.brokers[0], ContainerLogV2DataType, sendStart, errorDependencyResultCode(err), err == nil, len(messages))
+	if err != nil {
+		failed := len(messages)
+		if producerErrors, ok := err.(sarama.ProducerErrors); ok {
+			failed = len(producerErrors)
+		}
+		Log("Error::kafka::Failed to publish %d of %d records to the topic %s, will retry: %s", failed, len(messages), s.config.topic, err.Error())
+		s.closeProducer()
+		return err
+	}
+	Log("Success::kafka::Published %d container log records to the topic %s in %s", len(messages), s.config.topic, time.Since(batch.start))
+	return nil
+}
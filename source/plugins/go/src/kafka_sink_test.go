@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_newKafkaConfig(t *testing.T) {
+	type test_struct struct {
+		testName      string
+		settings      map[string]string
+		password      bool
+		wantErr       bool
+		wantBrokers   []string
+		wantUsername  string
+		wantBatchSize int
+		wantKey       string
+	}
+
+	tests := []test_struct{
+		{"defaults", map[string]string{"kafka_brokers": "broker1:9093", "kafka_topic": "logs"}, false, false, []string{"broker1:9093"}, "", defaultKafkaBatchSize, partitionKeyPod},
+		{"event hubs", map[string]string{"kafka_brokers": "ns1.servicebus.windows.net:9093, ns2.servicebus.windows.net:9093", "kafka_topic": "logs"}, true, false, []string{"ns1.servicebus.windows.net:9093", "ns2.servicebus.windows.net:9093"}, eventHubsKafkaUsername, defaultKafkaBatchSize, partitionKeyPod},
+		{"sasl username", map[string]string{"kafka_brokers": "broker1:9093", "kafka_topic": "logs", "kafka_sasl_username": "user1"}, true, false, []string{"broker1:9093"}, "user1", defaultKafkaBatchSize, partitionKeyPod},
+		{"batch size and key", map[string]string{"kafka_brokers": "broker1:9093", "kafka_topic": "logs", "kafka_batch_size": "100", "kafka_partition_key": "namespace"}, false, false, []string{"broker1:9093"}, "", 100, partitionKeyNamespace},
+		{"no brokers", map[string]string{"kafka_topic": "logs"}, false, true, nil, "", 0, ""},
+		{"no topic", map[string]string{"kafka_brokers": "broker1:9093"}, false, true, nil, "", 0, ""},
+		{"invalid batch size", map[string]string{"kafka_brokers": "broker1:9093", "kafka_topic": "logs", "kafka_batch_size": "0"}, false, true, nil, "", 0, ""},
+		{"invalid partition key", map[string]string{"kafka_brokers": "broker1:9093", "kafka_topic": "logs", "kafka_partition_key": "node"}, false, true, nil, "", 0, ""},
+	}
+
+	dir, err := ioutil.TempDir("", "kafka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordPath := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordPath, []byte("Endpoint=sb://ns1.servicebus.windows.net/"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { PluginConfiguration = nil }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			PluginConfiguration = map[string]string{}
+			for setting, value := range tt.settings {
+				PluginConfiguration[setting] = value
+			}
+			if tt.password {
+				PluginConfiguration["kafka_sasl_password_path"] = passwordPath
+			}
+			config, err := newKafkaConfig(ContainerLogsKafkaRoute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newKafkaConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(config.brokers, tt.wantBrokers) || config.topic != "logs" || config.username != tt.wantUsername || config.batchSize != tt.wantBatchSize || config.partitionKey != tt.wantKey || !config.tls {
+				t.Errorf("newKafkaConfig() = %+v", config)
+			}
+			if tt.password && config.password == "" {
+				t.Errorf("newKafkaConfig() didn't read the password from %s", passwordPath)
+			}
+		})
+	}
+}
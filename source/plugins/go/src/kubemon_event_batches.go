@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// env variable of the interval (minutes) of the flushes of the KubeMonAgentEvents, 60 by default
+const envKubeMonAgentEventsFlushIntervalMinutes = "AZMON_KUBEMON_EVENTS_FLUSH_INTERVAL_MINUTES"
+
+// env variable of the max number of KubeMonAgentEvents records per post (mdsd write or ODS request), the records of a
+// flush over it being sent in several posts. All the records of a flush are sent in one post when 0
+const envKubeMonAgentEventsMaxPerPost = "AZMON_KUBEMON_EVENTS_MAX_PER_POST"
+
+const defaultKubeMonAgentEventsMaxPerPost = 1000
+
+var (
+	// KubeMonAgentEventsFlushInterval the interval of the flushes of the KubeMonAgentEvents
+	KubeMonAgentEventsFlushInterval time.Duration
+	// KubeMonAgentEventsMaxPerPost the max number of KubeMonAgentEvents records per post, 0 for no limit
+	KubeMonAgentEventsMaxPerPost int
+)
+
+// initializeKubeMonAgentEventBatches reads the flush interval and the max records per post of the KubeMonAgentEvents
+func initializeKubeMonAgentEventBatches() {
+	intervalMinutes := kubeMonAgentConfigEventFlushInterval
+	if value := strings.TrimSpace(os.Getenv(envKubeMonAgentEventsFlushIntervalMinutes)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			intervalMinutes = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d minutes", value, envKubeMonAgentEventsFlushIntervalMinutes, kubeMonAgentConfigEventFlushInterval)
+		}
+	}
+	KubeMonAgentEventsFlushInterval = time.Minute * time.Duration(intervalMinutes)
+
+	KubeMonAgentEventsMaxPerPost = defaultKubeMonAgentEventsMaxPerPost
+	if value := strings.TrimSpace(os.Getenv(envKubeMonAgentEventsMaxPerPost)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			KubeMonAgentEventsMaxPerPost = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d", value, envKubeMonAgentEventsMaxPerPost, defaultKubeMonAgentEventsMaxPerPost)
+		}
+	}
+	Log("KubeMonAgentEvents are flushed every %s, at most %d records per post (0 for no limit)", KubeMonAgentEventsFlushInterval, KubeMonAgentEventsMaxPerPost)
+}
+
+// kubeMonAgentEventBatches returns the ranges [start, end) of the records of a flush sent in each post
+func kubeMonAgentEventBatches(count int) [][2]int {
+	var batches [][2]int
+	size := KubeMonAgentEventsMaxPerPost
+	if size <= 0 {
+		size = count
+	}
+	for start := 0; start < count; start += size {
+		end := start + size
+		if end > count {
+			end = count
+		}
+		batches = append(batches, [2]int{start, end})
+	}
+	return batches
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_kubeMonAgentEventBatches(t *testing.T) {
+	type test_struct struct {
+		testName   string
+		maxPerPost int
+		count      int
+		want       [][2]int
+	}
+
+	tests := []test_struct{
+		{"no records", 2, 0, nil},
+		{"one post", 10, 3, [][2]int{{0, 3}}},
+		{"split", 2, 5, [][2]int{{0, 2}, {2, 4}, {4, 5}}},
+		{"exact", 2, 4, [][2]int{{0, 2}, {2, 4}}},
+		{"no limit", 0, 5, [][2]int{{0, 5}}},
+	}
+
+	defer func() { KubeMonAgentEventsMaxPerPost = 0 }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			KubeMonAgentEventsMaxPerPost = tt.maxPerPost
+			if got := kubeMonAgentEventBatches(tt.count); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kubeMonAgentEventBatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
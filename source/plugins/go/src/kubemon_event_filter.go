@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// env variable of the min level (Info, Warning or Error) of the KubeMonAgentEvents records sent, Info by default
+const envKubeMonAgentEventsMinLevel = "AZMON_KUBEMON_EVENTS_MIN_LEVEL"
+
+// env variable of the KubeMonAgentEvents categories not sent, separated by commas. The categories are the full name
+// (container.azm.ms/noerror) or the name without the prefix (noerror)
+const envKubeMonAgentEventsDisabledCategories = "AZMON_KUBEMON_EVENTS_DISABLED_CATEGORIES"
+
+const kubeMonAgentEventCategoryPrefix = "container.azm.ms/"
+
+// the rank of the KubeMonAgentEvents levels
+var kubeMonAgentEventLevels = map[string]int{KubeMonAgentEventInfo: 0, KubeMonAgentEventWarning: 1, KubeMonAgentEventError: 2}
+
+var (
+	// KubeMonAgentEventsMinLevel the min level of the KubeMonAgentEvents records sent
+	KubeMonAgentEventsMinLevel = KubeMonAgentEventInfo
+	// KubeMonAgentEventsDisabledCategories the KubeMonAgentEvents categories not sent
+	KubeMonAgentEventsDisabledCategories = make(map[string]bool)
+)
+
+// initializeKubeMonAgentEventFilter reads the min level and the disabled categories of the KubeMonAgentEvents
+func initializeKubeMonAgentEventFilter() {
+	KubeMonAgentEventsMinLevel = KubeMonAgentEventInfo
+	if value := strings.TrimSpace(os.Getenv(envKubeMonAgentEventsMinLevel)); value != "" {
+		level := ""
+		for known := range kubeMonAgentEventLevels {
+			if strings.EqualFold(value, known) {
+				level = known
+			}
+		}
+		if level == "" {
+			Log("Invalid value %s for %s, sending the KubeMonAgentEvents of all levels", value, envKubeMonAgentEventsMinLevel)
+		} else {
+			KubeMonAgentEventsMinLevel = level
+		}
+	}
+
+	KubeMonAgentEventsDisabledCategories = make(map[string]bool)
+	for _, category := range strings.Split(os.Getenv(envKubeMonAgentEventsDisabledCategories), ",") {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if category == "" {
+			continue
+		}
+		if !strings.HasPrefix(category, kubeMonAgentEventCategoryPrefix) {
+			category = kubeMonAgentEventCategoryPrefix + category
+		}
+		KubeMonAgentEventsDisabledCategories[category] = true
+	}
+	Log("KubeMonAgentEvents min level = %s, disabled categories = %v", KubeMonAgentEventsMinLevel, KubeMonAgentEventsDisabledCategories)
+}
+
+// isKubeMonAgentEventEnabled whether the records of the category and level are sent
+func isKubeMonAgentEventEnabled(category string, level string) bool {
+	if KubeMonAgentEventsDisabledCategories[category] {
+		return false
+	}
+	return kubeMonAgentEventLevels[level] >= kubeMonAgentEventLevels[KubeMonAgentEventsMinLevel]
+}
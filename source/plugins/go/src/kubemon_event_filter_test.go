@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_isKubeMonAgentEventEnabled(t *testing.T) {
+	type test_struct struct {
+		testname           string
+		minLevel           string
+		disabledCategories string
+		category           string
+		level              string
+		output             bool
+	}
+
+	tests := []test_struct{
+		{"defaults", "", "", NoErrorEventCategory, KubeMonAgentEventInfo, true},
+		{"under the min level", "Warning", "", NoErrorEventCategory, KubeMonAgentEventInfo, false},
+		{"at the min level", "warning", "", PromScrapingErrorEventCategory, KubeMonAgentEventWarning, true},
+		{"over the min level", "Warning", "", ConfigErrorEventCategory, KubeMonAgentEventError, true},
+		{"invalid min level", "Debug", "", NoErrorEventCategory, KubeMonAgentEventInfo, true},
+		{"disabled category", "", "noerror, promscraping", PromScrapingErrorEventCategory, KubeMonAgentEventWarning, false},
+		{"disabled category full name", "", "container.azm.ms/configmap", ConfigErrorEventCategory, KubeMonAgentEventError, false},
+		{"other category", "", "noerror", ConfigErrorEventCategory, KubeMonAgentEventError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			os.Setenv(envKubeMonAgentEventsMinLevel, tt.minLevel)
+			os.Setenv(envKubeMonAgentEventsDisabledCategories, tt.disabledCategories)
+			defer os.Unsetenv(envKubeMonAgentEventsMinLevel)
+			defer os.Unsetenv(envKubeMonAgentEventsDisabledCategories)
+			initializeKubeMonAgentEventFilter()
+			if got := isKubeMonAgentEventEnabled(tt.category, tt.level); got != tt.output {
+				t.Errorf("isKubeMonAgentEventEnabled(%s, %s) = %v, want %v", tt.category, tt.level, got, tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// env variable of what is done with the container log records over the log analytics limits: none (default, the
+// violations are only counted), truncate (the columns are truncated to the limit) or split (the log column is split in
+// several records, the other columns are truncated)
+const envLogAnalyticsLimitsAction = "AZMON_LA_LIMITS_ACTION"
+
+const (
+	laLimitsActionNone     = "none"
+	laLimitsActionTruncate = "truncate"
+	laLimitsActionSplit    = "split"
+)
+
+// max number of columns of a log analytics record
+const logAnalyticsMaxColumns = 500
+
+// the log analytics column names start with a letter, followed by letters, digits and underscores
+var logAnalyticsColumnNameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// the columns of the log lines, split with the split action
+var logAnalyticsLogColumns = map[string]bool{"LogEntry": true, "LogMessage": true}
+
+// LogAnalyticsLimitsAction what is done with the records over the log analytics limits
+var LogAnalyticsLimitsAction = laLimitsActionNone
+
+// initializeLogAnalyticsLimits reads the action on the records over the log analytics limits
+func initializeLogAnalyticsLimits() {
+	LogAnalyticsLimitsAction = laLimitsActionNone
+	value := strings.ToLower(strings.TrimSpace(os.Getenv(envLogAnalyticsLimitsAction)))
+	switch value {
+	case "", laLimitsActionNone:
+	case laLimitsActionTruncate, laLimitsActionSplit:
+		LogAnalyticsLimitsAction = value
+	default:
+		Log("Invalid value %s for %s, the records over the log analytics limits are only counted", value, envLogAnalyticsLimitsAction)
+	}
+	Log("Log analytics limits action = %s", LogAnalyticsLimitsAction)
+}
+
+// laLimitsViolations the records over the log analytics limits in a batch
+type laLimitsViolations struct {
+	// records with a column over 32KB
+	fieldSize int
+	// records with too many columns or invalid column names
+	columns int
+}
+
+// enforceLogAnalyticsLimits applies the limits action to the container log records sent to log analytics, and counts
+// the records violating the limits
+func enforceLogAnalyticsLimits(msgPackEntries []MsgPackEntry, dataItemsLAv2 []DataItemLAv2, dataItemsLAv1 []DataItemLAv1) ([]MsgPackEntry, []DataItemLAv2, []DataItemLAv1) {
+	var violations laLimitsViolations
+	if hasLogAnalyticsLimitsViolation(msgPackEntries, dataItemsLAv2, dataItemsLAv1) {
+		msgPackEntries = enforceRecordLimits(msgPackEntries, LogAnalyticsLimitsAction, &violations)
+		dataItemsLAv2 = enforceDataItemLAv2Limits(dataItemsLAv2, LogAnalyticsLimitsAction, &violations)
+		dataItemsLAv1 = enforceDataItemLAv1Limits(dataItemsLAv1, LogAnalyticsLimitsAction, &violations)
+	}
+	if violations.fieldSize > 0 || violations.columns > 0 {
+		Log("Warning::lalimits::%d records over the 32KB column limit and %d records with invalid columns, action %s", violations.fieldSize, violations.columns, LogAnalyticsLimitsAction)
+		ContainerLogTelemetryMutex.Lock()
+		ContainerLogsLAFieldSizeViolationCount += float64(violations.fieldSize)
+		ContainerLogsLAColumnViolationCount += float64(violations.columns)
+		ContainerLogTelemetryMutex.Unlock()
+	}
+	return msgPackEntries, dataItemsLAv2, dataItemsLAv1
+}
+
+// hasLogAnalyticsLimitsViolation checks the batch before copying it, since the violations are rare
+func hasLogAnalyticsLimitsViolation(msgPackEntries []MsgPackEntry, dataItemsLAv2 []DataItemLAv2, dataItemsLAv1 []DataItemLAv1) bool {
+	for _, entry := range msgPackEntries {
+		if len(entry.Record) > logAnalyticsMaxColumns {
+			return true
+		}
+		for column, value := range entry.Record {
+			if len(value) > logAnalyticsMaxFieldBytes || !logAnalyticsColumnNameRegex.MatchString(column) {
+				return true
+			}
+		}
+	}
+	for i := range dataItemsLAv2 {
+		if hasStringFieldOverLimit(reflect.ValueOf(&dataItemsLAv2[i]).Elem()) {
+			return true
+		}
+	}
+	for i := range dataItemsLAv1 {
+		if hasStringFieldOverLimit(reflect.ValueOf(&dataItemsLAv1[i]).Elem()) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasStringFieldOverLimit(item reflect.Value) bool {
+	for i := 0; i < item.NumField(); i++ {
+		if field := item.Field(i); field.Kind() == reflect.String && field.Len() > logAnalyticsMaxFieldBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// enforceRecordLimits applies the limits to the records of the mdsd route, whose columns are not fixed
+func enforceRecordLimits(entries []MsgPackEntry, action string, violations *laLimitsViolations) []MsgPackEntry {
+	limited := make([]MsgPackEntry, 0, len(entries))
+	for _, entry := range entries {
+		if invalidColumns := invalidLogAnalyticsColumns(entry.Record); len(invalidColumns) > 0 {
+			violations.columns++
+			if action != laLimitsActionNone {
+				record := make(map[string]string, len(entry.Record))
+				for column, value := range entry.Record {
+					record[column] = value
+				}
+				for _, column := range invalidColumns {
+					delete(record, column)
+				}
+				entry.Record = record
+			}
+		}
+
+		logColumn := ""
+		overLimit := false
+		for column, value := range entry.Record {
+			if len(value) > logAnalyticsMaxFieldBytes {
+				overLimit = true
+				if logAnalyticsLogColumns[column] {
+					logColumn = column
+				}
+			}
+		}
+		if !overLimit {
+			limited = append(limited, entry)
+			continue
+		}
+		violations.fieldSize++
+		if action == laLimitsActionNone {
+			limited = append(limited, entry)
+			continue
+		}
+
+		record := make(map[string]string, len(entry.Record))
+		for column, value := range entry.Record {
+			record[column] = truncateUTF8(value, logAnalyticsMaxFieldBytes)
+		}
+		if action != laLimitsActionSplit || logColumn == "" {
+			entry.Record = record
+			limited = append(limited, entry)
+			continue
+		}
+		for _, part := range splitUTF8(entry.Record[logColumn], logAnalyticsMaxFieldBytes) {
+			partRecord := make(map[string]string, len(record))
+			for column, value := range record {
+				partRecord[column] = value
+			}
+			partRecord[logColumn] = part
+			limited = append(limited, MsgPackEntry{Time: entry.Time, Record: partRecord, Metadata: entry.Metadata})
+		}
+	}
+	return limited
+}
+
+// invalidLogAnalyticsColumns returns the columns with an invalid name, and the columns over the column limit
+func invalidLogAnalyticsColumns(record map[string]string) []string {
+	var invalid []string
+	var valid []string
+	for column := range record {
+		if logAnalyticsColumnNameRegex.MatchString(column) {
+			valid = append(valid, column)
+		} else {
+			invalid = append(invalid, column)
+		}
+	}
+	if len(valid) > logAnalyticsMaxColumns {
+		sort.Strings(valid)
+		invalid = append(invalid, valid[logAnalyticsMaxColumns:]...)
+	}
+	return invalid
+}
+
+func enforceDataItemLAv2Limits(items []DataItemLAv2, action string, violations *laLimitsViolations) []DataItemLAv2 {
+	limited := make([]DataItemLAv2, 0, len(items))
+	for _, item := range items {
+		if !hasStringFieldOverLimit(reflect.ValueOf(&item).Elem()) {
+			limited = append(limited, item)
+			continue
+		}
+		violations.fieldSize++
+		if action == laLimitsActionNone {
+			limited = append(limited, item)
+			continue
+		}
+		logMessage := item.LogMessage
+		truncateStringFields(reflect.ValueOf(&item).Elem())
+		if action != laLimitsActionSplit || len(logMessage) <= logAnalyticsMaxFieldBytes {
+			limited = append(limited, item)
+			continue
+		}
+		for _, part := range splitUTF8(logMessage, logAnalyticsMaxFieldBytes) {
+			item.LogMessage = part
+			limited = append(limited, item)
+		}
+	}
+	return limited
+}
+
+func enforceDataItemLAv1Limits(items []DataItemLAv1, action string, violations *laLimitsViolations) []DataItemLAv1 {
+	limited := make([]DataItemLAv1, 0, len(items))
+	for _, item := range items {
+		if !hasStringFieldOverLimit(reflect.ValueOf(&item).Elem()) {
+			limited = append(limited, item)
+			continue
+		}
+		violations.fieldSize++
+		if action == laLimitsActionNone {
+			limited = append(limited, item)
+			continue
+		}
+		logEntry := item.LogEntry
+		truncateStringFields(reflect.ValueOf(&item).Elem())
+		if action != laLimitsActionSplit || len(logEntry) <= logAnalyticsMaxFieldBytes {
+			limited = append(limited, item)
+			continue
+		}
+		for _, part := range splitUTF8(logEntry, logAnalyticsMaxFieldBytes) {
+			item.LogEntry = part
+			limited = append(limited, item)
+		}
+	}
+	return limited
+}
+
+func truncateStringFields(item reflect.Value) {
+	for i := 0; i < item.NumField(); i++ {
+		if field := item.Field(i); field.Kind() == reflect.String {
+			field.SetString(truncateUTF8(field.String(), logAnalyticsMaxFieldBytes))
+		}
+	}
+}
+
+// truncateUTF8 truncates the value to at most max bytes, without cutting a character
+func truncateUTF8(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	end := max
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+	return value[:end]
+}
+
+// splitUTF8 splits the value in parts of at most max bytes, without cutting a character
+func splitUTF8(value string, max int) []string {
+	var parts []string
+	for len(value) > max {
+		part := truncateUTF8(value, max)
+		if part == "" {
+			// max is shorter than the first character
+			part = value[:max]
+		}
+		parts = append(parts, part)
+		value = value[len(part):]
+	}
+	return append(parts, value)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_splitUTF8(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		max      int
+		output   []string
+	}
+
+	tests := []test_struct{
+		{"under the limit", "abc", 4, []string{"abc"}},
+		{"ascii", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"multibyte character at the boundary", "aé€b", 4, []string{"aé", "€b"}},
+		{"character longer than the limit", "€", 2, []string{"\xe2\x82", "\xac"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := splitUTF8(tt.value, tt.max); !reflect.DeepEqual(got, tt.output) {
+				t.Errorf("splitUTF8() = %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_enforceLogAnalyticsLimits(t *testing.T) {
+	type test_struct struct {
+		testname   string
+		action     string
+		entries    []MsgPackEntry
+		items      []DataItemLAv2
+		lengths    []int
+		violations laLimitsViolations
+	}
+
+	long := strings.Repeat("a", logAnalyticsMaxFieldBytes*2+10)
+	tests := []test_struct{
+		{"within the limits", laLimitsActionSplit, []MsgPackEntry{{Record: map[string]string{"LogMessage": "line"}}}, nil, []int{4}, laLimitsViolations{}},
+		{"count only", laLimitsActionNone, []MsgPackEntry{{Record: map[string]string{"LogMessage": long}}}, nil, []int{len(long)}, laLimitsViolations{fieldSize: 1}},
+		{"truncate", laLimitsActionTruncate, []MsgPackEntry{{Record: map[string]string{"LogMessage": long}}}, nil, []int{logAnalyticsMaxFieldBytes}, laLimitsViolations{fieldSize: 1}},
+		{"split", laLimitsActionSplit, []MsgPackEntry{{Record: map[string]string{"LogMessage": long}}}, nil, []int{logAnalyticsMaxFieldBytes, logAnalyticsMaxFieldBytes, 10}, laLimitsViolations{fieldSize: 1}},
+		{"split v2 item", laLimitsActionSplit, nil, []DataItemLAv2{{LogMessage: long}}, []int{logAnalyticsMaxFieldBytes, logAnalyticsMaxFieldBytes, 10}, laLimitsViolations{fieldSize: 1}},
+		{"invalid column name", laLimitsActionTruncate, []MsgPackEntry{{Record: map[string]string{"LogMessage": "line", "1bad-name": "x"}}}, nil, []int{4}, laLimitsViolations{columns: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			var violations laLimitsViolations
+			var lengths []int
+			for _, entry := range enforceRecordLimits(tt.entries, tt.action, &violations) {
+				lengths = append(lengths, len(entry.Record["LogMessage"]))
+				if _, ok := entry.Record["1bad-name"]; ok && tt.action != laLimitsActionNone {
+					t.Errorf("invalid column kept")
+				}
+			}
+			for _, item := range enforceDataItemLAv2Limits(tt.items, tt.action, &violations) {
+				lengths = append(lengths, len(item.LogMessage))
+			}
+			if !reflect.DeepEqual(lengths, tt.lengths) || violations != tt.violations {
+				t.Errorf("log lengths = %v, violations = %+v, want %v, %+v", lengths, violations, tt.lengths, tt.violations)
+			}
+		})
+	}
+}
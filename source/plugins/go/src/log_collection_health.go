@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"Docker-Provider/source/plugins/go/src/containermetadata"
+)
+
+// LogCollectionErrorEventCategory is the KubeMonAgentEvent category for stdout/stderr tailing failures (windows)
+const LogCollectionErrorEventCategory = "container.azm.ms/logcollection"
+
+// default location of the fluentd log which tails container logs on windows
+const defaultWindowsFluentdLogFilePath = "/etc/fluent/fluent.log"
+
+const logCollectionHealthCheckIntervalSeconds = 60
+
+// number of consecutive health checks a problem file fails before it is reported as persistently failing
+const logCollectionPersistentFailureChecks = 3
+
+// problem files probed per health check, so a burst of failures does not stall the checks
+const maxProblemLogFilesPerCheck = 100
+
+// max delay between the probes of a problem file, the delay doubles from the health check interval on each failed probe
+const maxProblemLogFileProbeBackoff = 15 * time.Minute
+
+// LogCollectionFailureType to be used as enum for the type of tailing failure
+type LogCollectionFailureType int
+
+const (
+	// LogCollectionNoFailure when the line does not describe a tailing failure
+	LogCollectionNoFailure LogCollectionFailureType = iota
+	LogCollectionTailFailure
+	LogCollectionLockedFile
+	LogCollectionEncodingError
+)
+
+var (
+	// LogCollectionErrorEvent hash of tailing failures since the last KubeMonAgentEvents flush
+	LogCollectionErrorEvent map[string]KubeMonAgentEventTags
+	// LogCollectionHealthTicker checks the fluentd log and problem files periodically
+	LogCollectionHealthTicker *time.Ticker
+	// problemLogFiles files which failed to be tailed, with the failure type last seen for each of them
+	problemLogFiles = make(map[string]*problemLogFile)
+	// LogCollectionHealthMutex read and write mutex access to problemLogFiles
+	LogCollectionHealthMutex = &sync.Mutex{}
+)
+
+// problemLogFile a file which failed to be tailed, and the consecutive health checks it failed
+type problemLogFile struct {
+	failureType  LogCollectionFailureType
+	failedChecks int
+	// the file is not probed again before this time
+	nextProbe time.Time
+}
+
+var logCollectionFilePathRegex = regexp.MustCompile(`(?i)([a-z]:)?[/\\][^"'\s,]+\.log`)
+
+// classifyLogCollectionFailure determines whether a fluentd log line describes a container log tailing failure
+func classifyLogCollectionFailure(line string) LogCollectionFailureType {
+	lowerLine := strings.ToLower(line)
+	if !strings.Contains(lowerLine, "[warn]") && !strings.Contains(lowerLine, "[error]") {
+		return LogCollectionNoFailure
+	}
+	switch {
+	case strings.Contains(lowerLine, "being used by another process"),
+		strings.Contains(lowerLine, "sharing violation"),
+		strings.Contains(lowerLine, "errno::eacces"),
+		strings.Contains(lowerLine, "permission denied"):
+		return LogCollectionLockedFile
+	case strings.Contains(lowerLine, "invalid byte sequence"),
+		strings.Contains(lowerLine, "incompatible character encodings"),
+		strings.Contains(lowerLine, "encoding::"):
+		return LogCollectionEncodingError
+	case strings.Contains(lowerLine, "in_tail"),
+		strings.Contains(lowerLine, "errno::enoent"),
+		strings.Contains(lowerLine, "unable to open"),
+		strings.Contains(lowerLine, "pattern not matched"):
+		return LogCollectionTailFailure
+	}
+	return LogCollectionNoFailure
+}
+
+// String returns the name used for the failure type in telemetry and events
+func (t LogCollectionFailureType) String() string {
+	switch t {
+	case LogCollectionTailFailure:
+		return "TailFailure"
+	case LogCollectionLockedFile:
+		return "LockedFile"
+	case LogCollectionEncodingError:
+		return "EncodingError"
+	}
+	return "None"
+}
+
+// monitorLogCollectionHealth follows the fluentd log on windows for tailing failures and reports the problem files.
+// fluentd tails the files again on its own, the problem files are re-opened with a backoff to report whether they are
+// still failing
+func monitorLogCollectionHealth(fluentdLogFilePath string) {
+	offset := int64(-1)
+	for ; true; <-LogCollectionHealthTicker.C {
+		offset = scanFluentdLog(fluentdLogFilePath, offset)
+		checkProblemLogFiles(time.Now())
+	}
+}
+
+// scanFluentdLog reads the lines appended to the fluentd log since the given offset and returns the new offset.
+// A negative offset starts at the end of the file, so that failures from before the plugin started are not reported
+func scanFluentdLog(fluentdLogFilePath string, offset int64) int64 {
+	file, err := os.Open(fluentdLogFilePath)
+	if err != nil {
+		Log("Error::logcollection::Unable to open fluentd log %s: %s", fluentdLogFilePath, err.Error())
+		return offset
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		Log("Error::logcollection::Unable to stat fluentd log %s: %s", fluentdLogFilePath, err.Error())
+		return offset
+	}
+	if offset < 0 {
+		return info.Size()
+	}
+	if info.Size() < offset {
+		// log was rotated
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		Log("Error::logcollection::Unable to seek fluentd log %s: %s", fluentdLogFilePath, err.Error())
+		return offset
+	}
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		Log("Error::logcollection::Unable to read fluentd log %s: %s", fluentdLogFilePath, err.Error())
+		return offset
+	}
+	// only process complete lines, the rest is read on the next check
+	lastNewLine := strings.LastIndex(string(content), "\n")
+	if lastNewLine < 0 {
+		return offset
+	}
+	for _, line := range strings.Split(string(content[:lastNewLine]), "\n") {
+		failureType := classifyLogCollectionFailure(line)
+		if failureType == LogCollectionNoFailure {
+			continue
+		}
+		filePath := logCollectionFilePathRegex.FindString(line)
+		if strings.EqualFold(filePath, fluentdLogFilePath) {
+			filePath = ""
+		}
+		recordLogCollectionFailure(failureType, filePath, strings.TrimSpace(line))
+	}
+	return offset + int64(lastNewLine) + 1
+}
+
+func recordLogCollectionFailure(failureType LogCollectionFailureType, filePath string, line string) {
+	UpdateLogCollectionFailureTelemetry(failureType, 1, 0)
+	if filePath != "" {
+		LogCollectionHealthMutex.Lock()
+		if problem, ok := problemLogFiles[filePath]; ok {
+			problem.failureType = failureType
+		} else {
+			problemLogFiles[filePath] = &problemLogFile{failureType: failureType}
+		}
+		LogCollectionHealthMutex.Unlock()
+	}
+	message := fmt.Sprintf("%s while collecting container logs: %s", failureType.String(), line)
+	if filePath != "" {
+		message = fmt.Sprintf("%s while collecting container logs from %s", failureType.String(), filePath)
+	}
+	addLogCollectionErrorEvent(message, filePath)
+}
+
+func addLogCollectionErrorEvent(message string, filePath string) {
+	logFile := containermetadata.ParseContainerLogFilePath(filePath)
+	containerID, podName := logFile.ContainerID, logFile.PodName
+	eventTimeStamp := time.Now().UTC().Format(time.RFC3339)
+	EventHashUpdateMutex.Lock()
+	defer EventHashUpdateMutex.Unlock()
+	if val, ok := LogCollectionErrorEvent[message]; ok {
+		LogCollectionErrorEvent[message] = KubeMonAgentEventTags{
+			PodName:         podName,
+			ContainerId:     containerID,
+			FirstOccurrence: val.FirstOccurrence,
+			LastOccurrence:  eventTimeStamp,
+			Count:           val.Count + 1,
+		}
+	} else {
+		LogCollectionErrorEvent[message] = KubeMonAgentEventTags{
+			PodName:         podName,
+			ContainerId:     containerID,
+			FirstOccurrence: eventTimeStamp,
+			LastOccurrence:  eventTimeStamp,
+			Count:           1,
+		}
+	}
+}
+
+// checkProblemLogFiles re-opens the files which failed to be tailed and are due for a probe, without waiting. Files
+// which are readable again or gone are removed from the problem set, files which are still locked or undecodable are
+// probed again after their backoff, and are reported once as persistent failures after
+// logCollectionPersistentFailureChecks failed probes
+func checkProblemLogFiles(now time.Time) {
+	LogCollectionHealthMutex.Lock()
+	files := make(map[string]LogCollectionFailureType)
+	for filePath, problem := range problemLogFiles {
+		if len(files) == maxProblemLogFilesPerCheck {
+			break
+		}
+		if now.Before(problem.nextProbe) {
+			continue
+		}
+		files[filePath] = problem.failureType
+	}
+	LogCollectionHealthMutex.Unlock()
+
+	for filePath, failureType := range files {
+		err := probeLogFile(filePath)
+		LogCollectionHealthMutex.Lock()
+		problem, ok := problemLogFiles[filePath]
+		if !ok {
+			LogCollectionHealthMutex.Unlock()
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			problem.failedChecks++
+			problem.nextProbe = now.Add(problemLogFileProbeBackoff(problem.failedChecks))
+			failedChecks := problem.failedChecks
+			LogCollectionHealthMutex.Unlock()
+			if failedChecks == logCollectionPersistentFailureChecks {
+				Log("Error::logcollection::%s persists for %s after %d checks: %s", failureType.String(), filePath, failedChecks, err.Error())
+				addLogCollectionErrorEvent(fmt.Sprintf("Persistent %s while collecting container logs from %s: %s", failureType.String(), filePath, err.Error()), filePath)
+			}
+			continue
+		}
+		// either readable again or the container (and its log file) is gone
+		delete(problemLogFiles, filePath)
+		LogCollectionHealthMutex.Unlock()
+		if err == nil {
+			Log("Info::logcollection::%s file %s is readable again", failureType.String(), filePath)
+			UpdateLogCollectionFailureTelemetry(failureType, 0, 1)
+		}
+	}
+}
+
+// problemLogFileProbeBackoff returns the delay before the next probe of a file which failed the probes
+func problemLogFileProbeBackoff(failedChecks int) time.Duration {
+	backoff := logCollectionHealthCheckIntervalSeconds * time.Second
+	for i := 1; i < failedChecks && backoff < maxProblemLogFileProbeBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxProblemLogFileProbeBackoff {
+		backoff = maxProblemLogFileProbeBackoff
+	}
+	return backoff
+}
+
+// probeLogFile opens the file for reading and validates the encoding of its last bytes
+func probeLogFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	const probeSize = 4096
+	start := info.Size() - probeSize
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, probeSize)
+	n, err := file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	// skip a possibly partial first and last line
+	probe := string(buf[:n])
+	if start > 0 {
+		if i := strings.Index(probe, "\n"); i >= 0 {
+			probe = probe[i+1:]
+		}
+	}
+	if i := strings.LastIndex(probe, "\n"); i >= 0 {
+		probe = probe[:i]
+	}
+	if !utf8.ValidString(probe) {
+		return fmt.Errorf("invalid utf-8 content")
+	}
+	return nil
+}
+
+// UpdateLogCollectionFailureTelemetry updates the log collection failure and recovery counters
+func UpdateLogCollectionFailureTelemetry(failureType LogCollectionFailureType, numFailures int, numRecovered int) {
+	ContainerLogTelemetryMutex.Lock()
+	defer ContainerLogTelemetryMutex.Unlock()
+	switch failureType {
+	case LogCollectionTailFailure:
+		LogCollectionTailFailureCount += float64(numFailures)
+	case LogCollectionLockedFile:
+		LogCollectionLockedFileCount += float64(numFailures)
+	case LogCollectionEncodingError:
+		LogCollectionEncodingErrorCount += float64(numFailures)
+	}
+	LogCollectionFileRecoveredCount += float64(numRecovered)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_classifyLogCollectionFailure(t *testing.T) {
+	type test_struct struct {
+		testname string
+		line     string
+		output   LogCollectionFailureType
+	}
+
+	tests := []test_struct{
+		{"info line", "2021-11-05 10:00:00 +0000 [info]: #0 following tail of C:/var/log/containers/app-abc_default_app-0123.log", LogCollectionNoFailure},
+		{"locked file", "2021-11-05 10:00:00 +0000 [warn]: #0 The process cannot access the file because it is being used by another process. - C:/var/log/containers/app-abc_default_app-0123.log", LogCollectionLockedFile},
+		{"permission denied", "2021-11-05 10:00:00 +0000 [error]: #0 Permission denied @ rb_sysopen - C:/var/log/containers/app-abc_default_app-0123.log", LogCollectionLockedFile},
+		{"encoding error", "2021-11-05 10:00:00 +0000 [warn]: #0 invalid byte sequence in UTF-8", LogCollectionEncodingError},
+		{"tail failure", "2021-11-05 10:00:00 +0000 [warn]: #0 [in_tail] pattern not matched: \"abc\"", LogCollectionTailFailure},
+		{"unrelated warning", "2021-11-05 10:00:00 +0000 [warn]: #0 buffer flush took longer time than slow_flush_log_threshold", LogCollectionNoFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got := classifyLogCollectionFailure(tt.line)
+			if got != tt.output {
+				t.Errorf("classifyLogCollectionFailure(%s) = %s, want %s", tt.line, got.String(), tt.output.String())
+			}
+		})
+	}
+}
+
+func Test_scanFluentdLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcollection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fluentdLogFilePath := filepath.Join(dir, "fluent.log")
+	if err := ioutil.WriteFile(fluentdLogFilePath, []byte("2021-11-05 10:00:00 +0000 [warn]: #0 invalid byte sequence in UTF-8 - C:/var/log/containers/old_default_app-0123.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	LogCollectionErrorEvent = make(map[string]KubeMonAgentEventTags)
+	problemLogFiles = make(map[string]*problemLogFile)
+
+	// the failures from before the plugin started are not reported
+	offset := scanFluentdLog(fluentdLogFilePath, -1)
+	file, err := os.OpenFile(fluentdLogFilePath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("2021-11-05 10:01:00 +0000 [warn]: #0 The process cannot access the file because it is being used by another process. - C:/var/log/containers/app-abc_default_app-0123.log\n")
+	file.WriteString("2021-11-05 10:01:00 +0000 [info]: #0 following tail of C:/var/log/containers/app-abc_default_app-0123.log\n")
+	// a partial line is read on the next check
+	file.WriteString("2021-11-05 10:01:00 +0000 [warn]: #0 invalid byte")
+	file.Close()
+	offset = scanFluentdLog(fluentdLogFilePath, offset)
+
+	problem, ok := problemLogFiles["C:/var/log/containers/app-abc_default_app-0123.log"]
+	if len(problemLogFiles) != 1 || !ok || problem.failureType != LogCollectionLockedFile {
+		t.Errorf("scanFluentdLog() problem files = %v", problemLogFiles)
+	}
+	if len(LogCollectionErrorEvent) != 1 {
+		t.Errorf("scanFluentdLog() recorded %d events, want 1", len(LogCollectionErrorEvent))
+	}
+	if info, _ := os.Stat(fluentdLogFilePath); offset >= info.Size() {
+		t.Errorf("scanFluentdLog() offset = %d, the partial line was read", offset)
+	}
+}
+
+func Test_checkProblemLogFiles(t *testing.T) {
+	type test_struct struct {
+		testname   string
+		content    []byte
+		checks     int
+		wantKept   bool
+		wantEvents int
+	}
+
+	tests := []test_struct{
+		{"readable again", []byte("line 1\nline 2\n"), 1, false, 0},
+		{"gone", nil, 1, false, 0},
+		{"undecodable", []byte("line 1\n\xff\xfe\nline 3\n"), 1, true, 0},
+		{"persistently undecodable", []byte("line 1\n\xff\xfe\nline 3\n"), logCollectionPersistentFailureChecks + 1, true, 1},
+	}
+
+	dir, err := ioutil.TempDir("", "logcollection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			filePath := filepath.Join(dir, "app-abc_default_app-0123.log")
+			os.Remove(filePath)
+			if tt.content != nil {
+				if err := ioutil.WriteFile(filePath, tt.content, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			LogCollectionErrorEvent = make(map[string]KubeMonAgentEventTags)
+			problemLogFiles = map[string]*problemLogFile{filePath: {failureType: LogCollectionEncodingError}}
+			now := time.Now()
+			for i := 0; i < tt.checks; i++ {
+				checkProblemLogFiles(now.Add(time.Duration(i) * maxProblemLogFileProbeBackoff))
+			}
+			if _, kept := problemLogFiles[filePath]; kept != tt.wantKept {
+				t.Errorf("checkProblemLogFiles() kept the file = %v, want %v", kept, tt.wantKept)
+			}
+			if len(LogCollectionErrorEvent) != tt.wantEvents {
+				t.Errorf("checkProblemLogFiles() recorded %d events, want %d", len(LogCollectionErrorEvent), tt.wantEvents)
+			}
+		})
+	}
+}
+
+func Test_problemLogFileProbeBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcollection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "app-abc_default_app-0123.log")
+	if err := ioutil.WriteFile(filePath, []byte("line 1\n\xff\xfe\nline 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	LogCollectionErrorEvent = make(map[string]KubeMonAgentEventTags)
+	problemLogFiles = map[string]*problemLogFile{filePath: {failureType: LogCollectionEncodingError}}
+	defer func() { problemLogFiles = make(map[string]*problemLogFile) }()
+
+	now := time.Now()
+	// the file is probed again once its backoff has passed
+	for i, elapsed := range []time.Duration{0, time.Minute - time.Second, time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		checkProblemLogFiles(now.Add(elapsed))
+		if want := []int{1, 1, 2, 2, 3}[i]; problemLogFiles[filePath].failedChecks != want {
+			t.Errorf("%d failed probes after %s, want %d", problemLogFiles[filePath].failedChecks, elapsed, want)
+		}
+	}
+	if backoff := problemLogFileProbeBackoff(100); backoff != maxProblemLogFileProbeBackoff {
+		t.Errorf("problemLogFileProbeBackoff() = %s, want at most %s", backoff, maxProblemLogFileProbeBackoff)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// path of the drop rules of the container log lines, the log-drop-rules key of the container-azm-ms-agentconfig
+// configmap by default
+const envLogDropRulesPath = "AZMON_LOG_DROP_RULES_PATH"
+
+const defaultLogDropRulesPath = "/etc/config/settings/log-drop-rules"
+
+// logDropRuleConfig a drop rule as configured in the configmap. The rule applies to all the namespaces or containers
+// when they are not set
+type logDropRuleConfig struct {
+	Name       string   `json:"name"`
+	Namespaces []string `json:"namespaces"`
+	Containers []string `json:"containers"`
+	Pattern    string   `json:"pattern"`
+}
+
+// logDropRule drops the log lines of its namespaces and containers which match its pattern
+type logDropRule struct {
+	name       string
+	namespaces map[string]bool
+	containers map[string]bool
+	pattern    *regexp.Regexp
+}
+
+var (
+	// LogDropRules the drop rules of the container log lines
+	LogDropRules []*logDropRule
+	// LogDropRuleCounts the number of lines dropped by each rule since the last telemetry tick
+	LogDropRuleCounts = make(map[string]float64)
+	// LogDropRuleMutex read and write mutex access to LogDropRuleCounts
+	LogDropRuleMutex = &sync.Mutex{}
+)
+
+// initializeLogDropRules loads the drop rules from the configmap, when it has them
+func initializeLogDropRules() {
+	path := strings.TrimSpace(os.Getenv(envLogDropRulesPath))
+	if path == "" {
+		path = defaultLogDropRulesPath
+	}
+	LogDropRules = nil
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log("Error::droprules::Unable to read the log drop rules from %s: %s", path, err.Error())
+		}
+		return
+	}
+	rules, err := parseLogDropRules(content)
+	if err != nil {
+		Log("Error::droprules::Invalid log drop rules in %s, no container log line is dropped: %s", path, err.Error())
+		return
+	}
+	LogDropRules = rules
+	Log("Loaded %d log drop rules from %s", len(rules), path)
+}
+
+// parseLogDropRules parses the json array of the drop rules
+func parseLogDropRules(content []byte) ([]*logDropRule, error) {
+	if strings.TrimSpace(string(content)) == "" {
+		return nil, nil
+	}
+	var configs []logDropRuleConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, err
+	}
+	rules := make([]*logDropRule, 0, len(configs))
+	for i, config := range configs {
+		if config.Pattern == "" {
+			return nil, fmt.Errorf("the rule %d has no pattern", i)
+		}
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("the pattern of the rule %d is invalid: %s", i, err.Error())
+		}
+		rule := &logDropRule{name: config.Name, pattern: pattern, namespaces: toStringSet(config.Namespaces), containers: toStringSet(config.Containers)}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule-%d", i)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func toStringSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[strings.TrimSpace(value)] = true
+	}
+	return set
+}
+
+func (r *logDropRule) matches(namespace string, containerName string, logEntry string) bool {
+	if r.namespaces != nil && !r.namespaces[namespace] {
+		return false
+	}
+	if r.containers != nil && !r.containers[containerName] {
+		return false
+	}
+	return r.pattern.MatchString(logEntry)
+}
+
+// matchLogDropRule returns the name of the first drop rule matching the log line, empty when the line is kept
+func matchLogDropRule(namespace string, containerName string, logEntry string) string {
+	for _, rule := range LogDropRules {
+		if rule.matches(namespace, containerName, logEntry) {
+			return rule.name
+		}
+	}
+	return ""
+}
+
+// addLogDropRuleCounts adds the lines dropped by each rule in a flush
+func addLogDropRuleCounts(dropped map[string]int) {
+	if len(dropped) == 0 {
+		return
+	}
+	LogDropRuleMutex.Lock()
+	defer LogDropRuleMutex.Unlock()
+	for rule, count := range dropped {
+		LogDropRuleCounts[rule] += float64(count)
+	}
+}
+
+// sendLogDropRuleTelemetry sends the lines dropped by each rule since the last tick
+func sendLogDropRuleTelemetry() {
+	LogDropRuleMutex.Lock()
+	counts := LogDropRuleCounts
+	LogDropRuleCounts = make(map[string]float64)
+	LogDropRuleMutex.Unlock()
+
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		trackSinkMetric(metricNameLogDropRuleDroppedCount, counts[rule], map[string]string{"Rule": rule})
+	}
+}
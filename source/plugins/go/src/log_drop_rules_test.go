@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_matchLogDropRule(t *testing.T) {
+	type test_struct struct {
+		testname      string
+		namespace     string
+		containerName string
+		logEntry      string
+		output        string
+	}
+
+	rules, err := parseLogDropRules([]byte(`[
+		{"name": "healthchecks", "namespaces": ["default"], "containers": ["web"], "pattern": "GET /healthz"},
+		{"pattern": "^DEBUG "}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	LogDropRules = rules
+	defer func() { LogDropRules = nil }()
+
+	tests := []test_struct{
+		{"healthcheck of the container", "default", "web", "10.0.0.1 GET /healthz 200\n", "healthchecks"},
+		{"healthcheck of another container", "default", "api", "10.0.0.1 GET /healthz 200\n", ""},
+		{"healthcheck of another namespace", "prod", "web", "10.0.0.1 GET /healthz 200\n", ""},
+		{"debug line of any container", "prod", "api", "DEBUG connecting\n", "rule-1"},
+		{"kept line", "default", "web", "ERROR boom\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := matchLogDropRule(tt.namespace, tt.containerName, tt.logEntry); got != tt.output {
+				t.Errorf("matchLogDropRule() = %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_parseLogDropRules(t *testing.T) {
+	type test_struct struct {
+		testname string
+		content  string
+		rules    int
+		isError  bool
+	}
+
+	tests := []test_struct{
+		{"empty", "", 0, false},
+		{"rules", `[{"pattern": "a"}, {"name": "b", "pattern": "b"}]`, 2, false},
+		{"no pattern", `[{"name": "a"}]`, 0, true},
+		{"invalid pattern", `[{"pattern": "("}]`, 0, true},
+		{"invalid json", `{"pattern": "a"}`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			rules, err := parseLogDropRules([]byte(tt.content))
+			if (err != nil) != tt.isError {
+				t.Errorf("parseLogDropRules() error = %v, want error %v", err, tt.isError)
+			}
+			if len(rules) != tt.rules {
+				t.Errorf("parseLogDropRules() = %d rules, want %d", len(rules), tt.rules)
+			}
+		})
+	}
+}
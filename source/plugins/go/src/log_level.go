@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// "true" adds the LogLevel detected in the log lines to the records of all the schemas and routes
+const envLogLevelDetection = "AZMON_LOG_LEVEL_DETECTION"
+
+// values of the LogLevel column of the ContainerLogV2 table
+const (
+	logLevelCritical = "critical"
+	logLevelError    = "error"
+	logLevelWarning  = "warning"
+	logLevelInfo     = "info"
+	logLevelDebug    = "debug"
+	logLevelTrace    = "trace"
+)
+
+// only the start of a line is searched for the level tokens, where the loggers write the level
+const logLevelTokenSearchLength = 256
+
+// the names of the level in the json log lines
+var jsonLogLevelKeys = []string{"level", "severity", "lvl", "loglevel", "log.level"}
+
+var (
+	// the prefix of the klog lines, Lmmdd hh:mm:ss.uuuuuu
+	klogLevelRegex = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}\.\d+ `)
+	// level=error of the logfmt lines
+	logfmtLevelRegex = regexp.MustCompile(`(?i)\b(?:level|lvl|severity)=["']?([a-z]+)`)
+	// the upper case level tokens of the plain text lines
+	logLevelTokenRegex = regexp.MustCompile(`\b(FATAL|PANIC|CRITICAL|CRIT|ERROR|ERR|WARNING|WARN|INFO|NOTICE|DEBUG|TRACE)\b`)
+)
+
+// LogLevelDetection whether the LogLevel detected in the log lines is added to the records
+var LogLevelDetection bool
+
+// initializeLogLevelDetection reads whether the log level of the log lines is detected
+func initializeLogLevelDetection() {
+	LogLevelDetection = strings.EqualFold(strings.TrimSpace(os.Getenv(envLogLevelDetection)), "true")
+	if LogLevelDetection {
+		Log("The log level of the container log lines is detected and added to the records")
+	}
+}
+
+// streamLogLevel returns the log level of a log line from its stream, the stderr lines being errors
+func streamLogLevel(logSource string) string {
+	if strings.EqualFold(logSource, "stderr") {
+		return logLevelError
+	}
+	return logLevelInfo
+}
+
+// detectLogLevel returns the log level of a log line, from its json level field, its klog prefix, its logfmt level or
+// its level token, and from its stream when it has none of them
+func detectLogLevel(logEntry string, logSource string) string {
+	trimmed := strings.TrimSpace(logEntry)
+	if strings.HasPrefix(trimmed, "{") {
+		if level := jsonLogLevel(trimmed); level != "" {
+			return level
+		}
+	}
+	if match := klogLevelRegex.FindStringSubmatch(trimmed); match != nil {
+		return normalizeLogLevel(match[1])
+	}
+	if len(trimmed) > logLevelTokenSearchLength {
+		trimmed = trimmed[:logLevelTokenSearchLength]
+	}
+	if match := logfmtLevelRegex.FindStringSubmatch(trimmed); match != nil {
+		if level := normalizeLogLevel(match[1]); level != "" {
+			return level
+		}
+	}
+	if match := logLevelTokenRegex.FindStringSubmatch(trimmed); match != nil {
+		return normalizeLogLevel(match[1])
+	}
+	return streamLogLevel(logSource)
+}
+
+func jsonLogLevel(logEntry string) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(logEntry), &fields); err != nil {
+		return ""
+	}
+	for _, key := range jsonLogLevelKeys {
+		for name, value := range fields {
+			if !strings.EqualFold(name, key) {
+				continue
+			}
+			if level, ok := value.(string); ok {
+				return normalizeLogLevel(level)
+			}
+		}
+	}
+	return ""
+}
+
+// normalizeLogLevel maps the level names of the loggers to the LogLevel values, empty when the name is not a level
+func normalizeLogLevel(level string) string {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "f", "fatal", "panic", "crit", "critical", "emerg", "emergency", "alert", "dpanic":
+		return logLevelCritical
+	case "e", "err", "error":
+		return logLevelError
+	case "w", "warn", "warning":
+		return logLevelWarning
+	case "i", "info", "information", "informational", "notice":
+		return logLevelInfo
+	case "d", "debug":
+		return logLevelDebug
+	case "t", "trace", "verbose":
+		return logLevelTrace
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_streamLogLevel(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		logSource string
+		output    string
+	}
+
+	tests := []test_struct{
+		{"stdout", "stdout", "info"},
+		{"stderr", "stderr", "error"},
+		{"stderr uppercase", "STDERR", "error"},
+		{"no stream", "", "info"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := streamLogLevel(tt.logSource); got != tt.output {
+				t.Errorf("streamLogLevel() = %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_detectLogLevel(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		logEntry  string
+		logSource string
+		output    string
+	}
+
+	tests := []test_struct{
+		{"json level", `{"level":"warn","msg":"slow request"}` + "\n", "stdout", "warning"},
+		{"json severity", `{"Severity":"ERROR","message":"boom"}`, "stdout", "error"},
+		{"json without level", `{"msg":"hello"}`, "stderr", "error"},
+		{"invalid json", `{"level":"debug"`, "stdout", "info"},
+		{"klog info", "I0102 15:04:05.123456       1 controller.go:42] synced\n", "stderr", "info"},
+		{"klog fatal", "F0102 15:04:05.123456       1 main.go:10] exiting\n", "stderr", "critical"},
+		{"logfmt", `time=2021-01-02T15:04:05Z level=debug msg="connecting"`, "stdout", "debug"},
+		{"token", "2021-01-02 15:04:05 WARN disk almost full\n", "stdout", "warning"},
+		{"token in brackets", "[ERROR] connection refused\n", "stdout", "error"},
+		{"lower case word", "no error here\n", "stdout", "info"},
+		{"token after the start of the line", strings.Repeat("a", 300) + " ERROR", "stdout", "info"},
+		{"no level stderr", "Traceback (most recent call last):\n", "stderr", "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := detectLogLevel(tt.logEntry, tt.logSource); got != tt.output {
+				t.Errorf("detectLogLevel() = %q, want %q", got, tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variables of the redaction of the container log entries
+const (
+	// path of the redaction rules, the log-redaction-rules key of the container-azm-ms-agentconfig configmap by default
+	envLogRedactionRulesPath = "AZMON_LOG_REDACTION_RULES_PATH"
+	// interval (seconds) at which the rules are reloaded when they change
+	envLogRedactionReloadIntervalSeconds = "AZMON_LOG_REDACTION_RELOAD_INTERVAL_SECONDS"
+)
+
+const (
+	defaultLogRedactionRulesPath             = "/etc/config/settings/log-redaction-rules"
+	defaultLogRedactionReloadIntervalSeconds = 60
+	defaultLogRedactionReplacement           = "[REDACTED]"
+)
+
+// the built-in rules, used by name when a rule has no pattern
+var builtinLogRedactionPatterns = map[string]string{
+	"email":       `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"ipv4":        `\b(?:(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])\b`,
+	"creditcard":  `\b(?:[0-9][ -]?){12,18}[0-9]\b`,
+	"bearertoken": `(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`,
+}
+
+// logRedactionRuleConfig a redaction rule as configured in the configmap
+type logRedactionRuleConfig struct {
+	Name        string  `json:"name"`
+	Pattern     string  `json:"pattern"`
+	Replacement *string `json:"replacement"`
+}
+
+// logRedactionRule masks the matches of its pattern in the log entries
+type logRedactionRule struct {
+	name        string
+	pattern     *regexp.Regexp
+	replacement string
+	// whether a match is masked, all the matches are when not set
+	validate func(match string) bool
+}
+
+var (
+	// LogRedactionRules the redaction rules of the container log entries
+	LogRedactionRules []*logRedactionRule
+	// LogRedactionRulesMutex read and write mutex access to LogRedactionRules, which are reloaded on their changes
+	LogRedactionRulesMutex = &sync.RWMutex{}
+	// LogRedactionRulesPath the path the rules are loaded from
+	LogRedactionRulesPath string
+	// LogRedactionReloadTicker to reload the rules periodically
+	LogRedactionReloadTicker *time.Ticker
+	// LogRedactionCounts the number of matches masked by each rule since the last telemetry tick
+	LogRedactionCounts = make(map[string]float64)
+	// LogRedactionCountsMutex read and write mutex access to LogRedactionCounts
+	LogRedactionCountsMutex = &sync.Mutex{}
+	// the content the rules were last loaded from
+	logRedactionRulesContent []byte
+)
+
+// initializeLogRedaction loads the redaction rules from the configmap, when it has them
+func initializeLogRedaction() {
+	LogRedactionRulesPath = strings.TrimSpace(os.Getenv(envLogRedactionRulesPath))
+	if LogRedactionRulesPath == "" {
+		LogRedactionRulesPath = defaultLogRedactionRulesPath
+	}
+	logRedactionRulesContent = nil
+	reloadLogRedactionRules()
+}
+
+// startLogRedactionReload reloads the redaction rules periodically, so the changes of the configmap apply without a
+// restart
+func startLogRedactionReload() {
+	seconds := defaultLogRedactionReloadIntervalSeconds
+	if value := strings.TrimSpace(os.Getenv(envLogRedactionReloadIntervalSeconds)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			seconds = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d seconds", value, envLogRedactionReloadIntervalSeconds, defaultLogRedactionReloadIntervalSeconds)
+		}
+	}
+	LogRedactionReloadTicker = time.NewTicker(time.Second * time.Duration(seconds))
+	go func() {
+		for range LogRedactionReloadTicker.C {
+			reloadLogRedactionRules()
+		}
+	}()
+}
+
+// reloadLogRedactionRules loads the rules when their content changed. Invalid rules keep the rules loaded before
+func reloadLogRedactionRules() {
+	content, err := ioutil.ReadFile(LogRedactionRulesPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log("Error::redaction::Unable to read the log redaction rules from %s: %s", LogRedactionRulesPath, err.Error())
+			return
+		}
+		content = []byte{}
+	}
+	if logRedactionRulesContent != nil && bytes.Equal(content, logRedactionRulesContent) {
+		return
+	}
+	logRedactionRulesContent = content
+	rules, err := parseLogRedactionRules(content)
+	if err != nil {
+		Log("Error::redaction::Invalid log redaction rules in %s, keeping the %d rules loaded before: %s", LogRedactionRulesPath, len(getLogRedactionRules()), err.Error())
+		return
+	}
+	LogRedactionRulesMutex.Lock()
+	LogRedactionRules = rules
+	LogRedactionRulesMutex.Unlock()
+	if len(rules) > 0 || len(content) > 0 {
+		Log("Loaded %d log redaction rules from %s", len(rules), LogRedactionRulesPath)
+	}
+}
+
+// parseLogRedactionRules parses the json array of the redaction rules
+func parseLogRedactionRules(content []byte) ([]*logRedactionRule, error) {
+	if strings.TrimSpace(string(content)) == "" {
+		return nil, nil
+	}
+	var configs []logRedactionRuleConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, err
+	}
+	rules := make([]*logRedactionRule, 0, len(configs))
+	for i, config := range configs {
+		rule := &logRedactionRule{name: config.Name, replacement: defaultLogRedactionReplacement}
+		if config.Replacement != nil {
+			rule.replacement = *config.Replacement
+		}
+		pattern := config.Pattern
+		if pattern == "" {
+			builtin, ok := builtinLogRedactionPatterns[strings.ToLower(config.Name)]
+			if !ok {
+				return nil, fmt.Errorf("the rule %d has no pattern and %q is not a built-in rule", i, config.Name)
+			}
+			pattern = builtin
+			if strings.EqualFold(config.Name, "creditcard") {
+				rule.validate = isLuhnValid
+			}
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("the pattern of the rule %d is invalid: %s", i, err.Error())
+		}
+		rule.pattern = compiled
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule-%d", i)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func getLogRedactionRules() []*logRedactionRule {
+	LogRedactionRulesMutex.RLock()
+	defer LogRedactionRulesMutex.RUnlock()
+	return LogRedactionRules
+}
+
+// hasLogRedactionRules whether the log entries are redacted
+func hasLogRedactionRules() bool {
+	return len(getLogRedactionRules()) > 0
+}
+
+// redactLogEntry masks the matches of the rules in a log entry, and counts the masked matches by rule
+func redactLogEntry(logEntry string, redacted map[string]int) string {
+	for _, rule := range getLogRedactionRules() {
+		if !rule.pattern.MatchString(logEntry) {
+			continue
+		}
+		logEntry = rule.pattern.ReplaceAllStringFunc(logEntry, func(match string) string {
+			if rule.validate != nil && !rule.validate(match) {
+				return match
+			}
+			redacted[rule.name]++
+			return rule.replacement
+		})
+	}
+	return logEntry
+}
+
+// isLuhnValid whether the digits of a match pass the Luhn check of the card numbers
+func isLuhnValid(match string) bool {
+	sum, digits := 0, 0
+	for i := len(match) - 1; i >= 0; i-- {
+		if match[i] < '0' || match[i] > '9' {
+			continue
+		}
+		digit := int(match[i] - '0')
+		if digits%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+// addLogRedactionCounts adds the matches masked by each rule in a flush
+func addLogRedactionCounts(redacted map[string]int) {
+	if len(redacted) == 0 {
+		return
+	}
+	LogRedactionCountsMutex.Lock()
+	defer LogRedactionCountsMutex.Unlock()
+	for rule, count := range redacted {
+		LogRedactionCounts[rule] += float64(count)
+	}
+}
+
+// sendLogRedactionTelemetry sends the matches masked by each rule since the last tick
+func sendLogRedactionTelemetry() {
+	LogRedactionCountsMutex.Lock()
+	counts := LogRedactionCounts
+	LogRedactionCounts = make(map[string]float64)
+	LogRedactionCountsMutex.Unlock()
+
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		trackSinkMetric(metricNameLogRedactionCount, counts[rule], map[string]string{"Rule": rule})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AAD auth of the sends to the Logs Ingestion API, msi (the kubelet or a user-assigned identity thru IMDS) or
+// workloadidentity (the federated service account token), instead of the agent certificates of ODS
+const (
+	envLogsIngestionAuthMode = "AZMON_LOGS_INGESTION_AUTH_MODE"
+	// client id of the user-assigned identity, or of the app registration federated with the service account
+	envLogsIngestionClientID = "AZMON_LOGS_INGESTION_CLIENT_ID"
+	// audience of the tokens, for the sovereign clouds
+	envLogsIngestionAudience = "AZMON_LOGS_INGESTION_AUDIENCE"
+)
+
+const (
+	logsIngestionAuthModeMSI              = "msi"
+	logsIngestionAuthModeWorkloadIdentity = "workloadidentity"
+	defaultLogsIngestionAudience          = "https://monitor.azure.com"
+)
+
+// IMDS is not routable thru the proxy, so its requests use a dedicated client
+var imdsTokenEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+var (
+	// LogsIngestionAuthMode the AAD auth mode of the Logs Ingestion API, empty when it is not configured
+	LogsIngestionAuthMode string
+	// LogsIngestionTokenProvider caches the token of the Logs Ingestion API and refreshes it before it expires
+	LogsIngestionTokenProvider *TokenProvider
+	// LogsIngestionHTTPClient the client of the Logs Ingestion API, going thru the proxy when configured
+	LogsIngestionHTTPClient *http.Client
+)
+
+// initializeLogsIngestionAuth creates the token provider of the configured auth mode and starts its proactive refresh
+func initializeLogsIngestionAuth() {
+	LogsIngestionAuthMode = ""
+	LogsIngestionTokenProvider = nil
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv(envLogsIngestionAuthMode)))
+	if mode == "" {
+		return
+	}
+	clientID := strings.TrimSpace(os.Getenv(envLogsIngestionClientID))
+	audience := getLogsIngestionAudience()
+	switch mode {
+	case logsIngestionAuthModeMSI:
+		httpClient := &http.Client{Timeout: 30 * time.Second}
+		LogsIngestionTokenProvider = getOrCreateTokenProvider("msi:"+clientID+":"+audience, aadTokenRefreshBuffer, func() (string, int64, error) {
+			return getAccessTokenFromIMDSForResource(httpClient, imdsTokenEndpoint, audience+"/", clientID)
+		})
+	case logsIngestionAuthModeWorkloadIdentity:
+		if !isWorkloadIdentityConfigured() {
+			Log("Error::token::%s is %s but %s is not set, the Logs Ingestion API is not available", envLogsIngestionAuthMode, mode, envAzureFederatedTokenFile)
+			return
+		}
+		LogsIngestionTokenProvider = newWorkloadIdentityTokenProvider("", clientID, audience+"/.default")
+	default:
+		Log("Error::token::Invalid value %s for %s, it must be %s or %s", mode, envLogsIngestionAuthMode, logsIngestionAuthModeMSI, logsIngestionAuthModeWorkloadIdentity)
+		return
+	}
+	LogsIngestionAuthMode = mode
+	LogsIngestionHTTPClient = newAADTokenHTTPClient()
+	LogsIngestionTokenProvider.startProactiveRefresh()
+	Log("Logs Ingestion API auth mode %s configured for the audience %s", mode, audience)
+}
+
+func getLogsIngestionAudience() string {
+	audience := strings.TrimSpace(os.Getenv(envLogsIngestionAudience))
+	if audience == "" {
+		audience = defaultLogsIngestionAudience
+	}
+	return strings.TrimSuffix(audience, "/")
+}
+
+// logsIngestionRequestAuthorization returns the cached AAD token of the Logs Ingestion API
+func logsIngestionRequestAuthorization() (string, error) {
+	if LogsIngestionTokenProvider == nil {
+		return "", fmt.Errorf("%s is not configured", envLogsIngestionAuthMode)
+	}
+	return LogsIngestionTokenProvider.Token()
+}
+
+// newLogsIngestionHTTPClientWithTLS returns a client of the Logs Ingestion API with the TLS config of a route, going
+// thru the proxy when configured
+func newLogsIngestionHTTPClientWithTLS(tlsConfig *tls.Config) *http.Client {
+	httpClient := newAADTokenHTTPClient()
+	httpClient.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	return httpClient
+}
+
+// postToLogsIngestion posts the JSON records to the endpoint of the Logs Ingestion API with the bearer token, thru the
+// client of the route or the LogsIngestionHTTPClient when nil
+func postToLogsIngestion(ctx context.Context, httpClient *http.Client, endpoint string, body []byte) (*http.Response, string, error) {
+	if LogsIngestionHTTPClient == nil {
+		return nil, "", fmt.Errorf("%s is not configured", envLogsIngestionAuthMode)
+	}
+	if httpClient == nil {
+		httpClient = LogsIngestionHTTPClient
+	}
+	req, reqID, err := newRouteRequest(ctx, "POST", requestRouteLogsIngestion, endpoint, body)
+	if err != nil {
+		return nil, reqID, err
+	}
+	resp, err := doRouteRequestWithClient(httpClient, requestRouteLogsIngestion, req)
+	return resp, reqID, err
+}
+
+// getAccessTokenFromIMDSForResource gets a token for the resource from IMDS, for the identity with the client id or
+// the only identity of the node when empty, and returns it with its expiration (unix time). Retries are done by the
+// token provider
+func getAccessTokenFromIMDSForResource(httpClient *http.Client, endpoint string, resource string, clientID string) (string, int64, error) {
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", resource)
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	req, err := http.NewRequest("GET", endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("getAccessTokenFromIMDSForResource: Error calling token endpoint: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	responseBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != 200 {
+		return "", 0, fmt.Errorf("getAccessTokenFromIMDSForResource: IMDS request failed with status code %d", resp.StatusCode)
+	}
+	var imdsResponse IMDSResponse
+	if err := json.Unmarshal(responseBytes, &imdsResponse); err != nil {
+		return "", 0, err
+	}
+	if imdsResponse.AccessToken == "" {
+		return "", 0, errors.New("getAccessTokenFromIMDSForResource: IMDS response has no access token")
+	}
+	expiration, err := strconv.ParseInt(imdsResponse.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("getAccessTokenFromIMDSForResource: invalid expires_on %q", imdsResponse.ExpiresOn)
+	}
+	return imdsResponse.AccessToken, expiration, nil
+}
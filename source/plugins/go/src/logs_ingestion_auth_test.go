@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_getAccessTokenFromIMDSForResource(t *testing.T) {
+	type test_struct struct {
+		testName       string
+		clientID       string
+		status         int
+		body           string
+		wantToken      string
+		wantExpiration int64
+		wantErr        bool
+	}
+
+	tests := []test_struct{
+		{"system identity", "", 200, `{"access_token":"token1","expires_on":"1700000000"}`, "token1", 1700000000, false},
+		{"user-assigned identity", "client1", 200, `{"access_token":"token2","expires_on":"1700000000"}`, "token2", 1700000000, false},
+		{"identity not found", "client1", 400, `{"error":"invalid_request"}`, "", 0, true},
+		{"no access token", "", 200, `{"expires_on":"1700000000"}`, "", 0, true},
+		{"invalid expiration", "", 200, `{"access_token":"token1","expires_on":"soon"}`, "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query := r.URL.Query()
+				if r.Header.Get("Metadata") != "true" || query.Get("resource") != "https://monitor.azure.com/" || query.Get("client_id") != tt.clientID {
+					t.Errorf("unexpected IMDS request %s with Metadata %q", r.URL.String(), r.Header.Get("Metadata"))
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			token, expiration, err := getAccessTokenFromIMDSForResource(&http.Client{}, server.URL, "https://monitor.azure.com/", tt.clientID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getAccessTokenFromIMDSForResource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if token != tt.wantToken || expiration != tt.wantExpiration {
+				t.Errorf("getAccessTokenFromIMDSForResource() = %s, %d, want %s, %d", token, expiration, tt.wantToken, tt.wantExpiration)
+			}
+		})
+	}
+}
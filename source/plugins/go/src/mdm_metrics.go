@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// path of the mapping of the telegraf metrics published to Azure Monitor custom metrics (MDM), the
+// mdm-metrics-mapping key of the container-azm-ms-agentconfig configmap by default
+const envMDMMetricsMappingPath = "AZMON_MDM_METRICS_MAPPING_PATH"
+
+const defaultMDMMetricsMappingPath = "/etc/config/settings/mdm-metrics-mapping"
+
+// settings of the mdm route, read like the route settings: AZMON_MDM_<SETTING> or mdm_<setting> in the plugin
+// configuration
+const (
+	// the metrics endpoint, https://<cluster region>.monitoring.azure.com<cluster resource id>/metrics when not set
+	mdmSettingEndpoint = "endpoint"
+	// client id of the user-assigned identity, the only identity of the node when not set
+	mdmSettingClientID = "client_id"
+)
+
+const (
+	// the audience of the tokens of the managed identity for the custom metrics
+	mdmResource = "https://monitoring.azure.com/"
+	// the max size of a request of the custom metrics
+	mdmMaxPayloadBytes = 1024 * 1024
+)
+
+// mdmMetricMappingConfig the mapping of a telegraf metric as configured in the configmap. The dimensions map the tags of
+// the telegraf metric to the names of the dimensions of the custom metric
+type mdmMetricMappingConfig struct {
+	Namespace       string            `json:"namespace"`
+	Name            string            `json:"name"`
+	MetricNamespace string            `json:"metricNamespace"`
+	Metric          string            `json:"metric"`
+	Dimensions      map[string]string `json:"dimensions"`
+}
+
+// mdmMetricMapping the custom metric a telegraf metric is published as, its dimensions sorted by tag
+type mdmMetricMapping struct {
+	metricNamespace string
+	metric          string
+	tags            []string
+	dimNames        []string
+}
+
+// mdmSeries a series of a custom metric
+type mdmSeries struct {
+	DimValues []string `json:"dimValues"`
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+	Sum       float64  `json:"sum"`
+	Count     int      `json:"count"`
+}
+
+// mdmMetric a custom metric of the metrics endpoint
+type mdmMetric struct {
+	Time string `json:"time"`
+	Data struct {
+		BaseData struct {
+			Metric    string      `json:"metric"`
+			Namespace string      `json:"namespace"`
+			DimNames  []string    `json:"dimNames"`
+			Series    []mdmSeries `json:"series"`
+		} `json:"baseData"`
+	} `json:"data"`
+}
+
+var (
+	// MDMMetricMappings the custom metrics of the telegraf metrics published, by telegraf namespace and name. The
+	// telegraf metrics are not published when empty
+	MDMMetricMappings map[string]*mdmMetricMapping
+	// MDMMetricsEndpoint the metrics endpoint of the cluster
+	MDMMetricsEndpoint string
+	// MDMHTTPClient the client of the metrics endpoint
+	MDMHTTPClient *http.Client
+	// MDMTokenProvider the token provider of the managed identity publishing the custom metrics
+	MDMTokenProvider *TokenProvider
+)
+
+// initializeMDMMetrics loads the mapping of the telegraf metrics published as custom metrics, when the configmap has one
+func initializeMDMMetrics() {
+	MDMMetricMappings = nil
+	path := strings.TrimSpace(os.Getenv(envMDMMetricsMappingPath))
+	if path == "" {
+		path = defaultMDMMetricsMappingPath
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log("Error::mdm::Unable to read the custom metrics mapping from %s: %s", path, err.Error())
+		}
+		return
+	}
+	mappings, err := parseMDMMetricMappings(content)
+	if err != nil {
+		Log("Error::mdm::Invalid custom metrics mapping in %s, no telegraf metric is published as custom metric: %s", path, err.Error())
+		return
+	}
+	if len(mappings) == 0 {
+		return
+	}
+	endpoint := routeSetting(requestRouteMDM, mdmSettingEndpoint)
+	if endpoint == "" {
+		region := normalizeRegion(os.Getenv(envClusterRegion))
+		if region == "" || !strings.HasPrefix(strings.ToLower(ResourceID), "/subscriptions/") {
+			Log("Error::mdm::Unable to publish the telegraf metrics as custom metrics: the %s is required when the cluster region or resource id is unknown", mdmSettingEndpoint)
+			return
+		}
+		endpoint = fmt.Sprintf("https://%s.monitoring.azure.com%s/metrics", region, ResourceID)
+	}
+	clientID := routeSetting(requestRouteMDM, mdmSettingClientID)
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	MDMTokenProvider = getOrCreateTokenProvider("msi:"+clientID+":"+mdmResource, aadTokenRefreshBuffer, func() (string, int64, error) {
+		return getAccessTokenFromIMDSForResource(httpClient, imdsTokenEndpoint, mdmResource, clientID)
+	})
+	MDMHTTPClient = newAADTokenHTTPClient()
+	MDMMetricsEndpoint = endpoint
+	MDMMetricMappings = mappings
+	Log("Publishing %d telegraf metrics as custom metrics to %s", len(mappings), endpoint)
+}
+
+// parseMDMMetricMappings parses the json array of the mappings
+func parseMDMMetricMappings(content []byte) (map[string]*mdmMetricMapping, error) {
+	if strings.TrimSpace(string(content)) == "" {
+		return nil, nil
+	}
+	var configs []mdmMetricMappingConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, err
+	}
+	mappings := make(map[string]*mdmMetricMapping)
+	for i, config := range configs {
+		if config.Namespace == "" || config.Name == "" || config.MetricNamespace == "" || config.Metric == "" {
+			return nil, fmt.Errorf("the mapping %d must have the namespace, name, metricNamespace and metric", i)
+		}
+		key := mdmMetricMappingKey(config.Namespace, config.Name)
+		if _, ok := mappings[key]; ok {
+			return nil, fmt.Errorf("the metric %s of %s is mapped more than once", config.Name, config.Namespace)
+		}
+		mapping := &mdmMetricMapping{metricNamespace: config.MetricNamespace, metric: config.Metric}
+		for tag := range config.Dimensions {
+			mapping.tags = append(mapping.tags, tag)
+		}
+		sort.Strings(mapping.tags)
+		for _, tag := range mapping.tags {
+			if config.Dimensions[tag] == "" {
+				return nil, fmt.Errorf("the dimension of the tag %s of the mapping %d has no name", tag, i)
+			}
+			mapping.dimNames = append(mapping.dimNames, config.Dimensions[tag])
+		}
+		mappings[key] = mapping
+	}
+	return mappings, nil
+}
+
+func mdmMetricMappingKey(namespace string, name string) string {
+	return namespace + "\x00" + name
+}
+
+// newMDMMetric returns the custom metric of the telegraf metric, nil when it is not mapped. The metrics aggregated in a
+// window are published with the min, max and count of the window
+func newMDMMetric(laMetric *laTelegrafMetric) *mdmMetric {
+	mapping, ok := MDMMetricMappings[mdmMetricMappingKey(laMetric.Namespace, laMetric.Name)]
+	if !ok {
+		return nil
+	}
+	tags := make(map[string]string)
+	json.Unmarshal([]byte(laMetric.Tags), &tags)
+	series := mdmSeries{DimValues: make([]string, 0, len(mapping.tags)), Min: laMetric.Value, Max: laMetric.Value, Sum: laMetric.Value, Count: 1}
+	if count, err := strconv.Atoi(tags[fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, telegrafTagAggregationCount)]); err == nil && count > 0 {
+		min, minErr := strconv.ParseFloat(tags[fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, telegrafTagAggregationMin)], 64)
+		max, maxErr := strconv.ParseFloat(tags[fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, telegrafTagAggregationMax)], 64)
+		if minErr == nil && maxErr == nil {
+			series = mdmSeries{DimValues: series.DimValues, Min: min, Max: max, Sum: laMetric.Value * float64(count), Count: count}
+		}
+	}
+	for _, tag := range mapping.tags {
+		series.DimValues = append(series.DimValues, tags[tag])
+	}
+	metric := &mdmMetric{Time: laMetric.CollectionTime}
+	metric.Data.BaseData.Metric = mapping.metric
+	metric.Data.BaseData.Namespace = mapping.metricNamespace
+	metric.Data.BaseData.DimNames = mapping.dimNames
+	metric.Data.BaseData.Series = []mdmSeries{series}
+	return metric
+}
+
+// mdmRequestAuthorization returns the token of the managed identity for the custom metrics
+func mdmRequestAuthorization() (string, error) {
+	if MDMTokenProvider == nil {
+		return "", errors.New("the custom metrics are not configured")
+	}
+	return MDMTokenProvider.Token()
+}
+
+// postToMDM posts the custom metrics of the payload, one json object per line
+func postToMDM(ctx context.Context, payload []byte, numMetrics int) error {
+	req, reqID, err := newRouteRequest(ctx, "POST", requestRouteMDM, MDMMetricsEndpoint, payload)
+	if err != nil {
+		return err
+	}
+	sendStart := time.Now()
+	resp, err := doRouteRequestWithClient(MDMHTTPClient, requestRouteMDM, req)
+	trackFlushDependency(dependencyTypeMDM, dependencyTarget(MDMMetricsEndpoint), InsightsMetricsDataType, sendStart, httpDependencyResultCode(resp, err), err == nil && resp != nil && resp.StatusCode/100 == 2, numMetrics)
+	if err != nil {
+		Log("Error::mdm::Error when posting %d custom metrics: %s", numMetrics, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		LogWith(pluginLogFields{Route: requestRouteMDM, RequestID: reqID}, "Error::mdm::RequestId %s Status %s Status Code %d when posting %d custom metrics", reqID, resp.Status, resp.StatusCode, numMetrics)
+		return &sinkStatusError{statusCode: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	return nil
+}
+
+// publishTelegrafMetricsToMDM publishes the mapped metrics of a flush as custom metrics, in addition to their route.
+// The publish is best effort, a failure is logged and counted but does not fail the flush, and the payloads published
+// by an earlier delivery of the chunk are not published again
+func publishTelegrafMetricsToMDM(ctx context.Context, laMetrics []*laTelegrafMetric, batchID string) {
+	if len(MDMMetricMappings) == 0 {
+		return
+	}
+	var metrics []*mdmMetric
+	for _, laMetric := range laMetrics {
+		if metric := newMDMMetric(laMetric); metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	if len(metrics) == 0 {
+		return
+	}
+	marshal := func(start int, end int) ([]byte, error) {
+		var payload bytes.Buffer
+		for _, metric := range metrics[start:end] {
+			jsonBytes, err := json.Marshal(metric)
+			if err != nil {
+				Log("Error::mdm::Unable to marshal a custom metric: %s", err.Error())
+				return nil, errBatchDropped
+			}
+			payload.Write(jsonBytes)
+			payload.WriteString("\n")
+		}
+		return payload.Bytes(), nil
+	}
+	err := sendPayloadChunks(ctx, "PostTelegrafMetricsToLA", len(metrics), mdmMaxPayloadBytes, marshal, func(start int, end int, payload []byte) error {
+		return sendUnsentPayload("PostTelegrafMetricsToLA", requestRouteMDM, batchID, start, end, func() error {
+			return postToMDM(ctx, payload, end-start)
+		})
+	})
+	if err != nil {
+		ContainerLogTelemetryMutex.Lock()
+		MDMPublishFailedMetricCount += float64(len(metrics))
+		ContainerLogTelemetryMutex.Unlock()
+		return
+	}
+	Log("Success::mdm::Published %d telegraf metrics as custom metrics", len(metrics))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseMDMMetricMappings(t *testing.T) {
+	type test_struct struct {
+		testName     string
+		content      string
+		wantErr      bool
+		wantMappings int
+	}
+
+	tests := []test_struct{
+		{"empty", "", false, 0},
+		{"mapping", `[{"namespace": "container.azm.ms/disk", "name": "used_percent", "metricNamespace": "insights.container/nodes", "metric": "diskUsedPercentage", "dimensions": {"device": "device"}}]`, false, 1},
+		{"no dimensions", `[{"namespace": "prometheus", "name": "queue_length", "metricNamespace": "custom/app", "metric": "queueLength"}]`, false, 1},
+		{"no metric", `[{"namespace": "prometheus", "name": "queue_length", "metricNamespace": "custom/app"}]`, true, 0},
+		{"mapped twice", `[{"namespace": "prometheus", "name": "queue_length", "metricNamespace": "custom/app", "metric": "a"}, {"namespace": "prometheus", "name": "queue_length", "metricNamespace": "custom/app", "metric": "b"}]`, true, 0},
+		{"dimension without name", `[{"namespace": "prometheus", "name": "queue_length", "metricNamespace": "custom/app", "metric": "queueLength", "dimensions": {"queue": ""}}]`, true, 0},
+		{"invalid json", `{`, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			mappings, err := parseMDMMetricMappings([]byte(tt.content))
+			if (err != nil) != tt.wantErr || len(mappings) != tt.wantMappings {
+				t.Errorf("parseMDMMetricMappings() = %d mappings with error %v, want %d mappings and error %v", len(mappings), err, tt.wantMappings, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newMDMMetric(t *testing.T) {
+	type test_struct struct {
+		testName   string
+		metric     laTelegrafMetric
+		wantNil    bool
+		wantSeries mdmSeries
+	}
+
+	tests := []test_struct{
+		{"not mapped", laTelegrafMetric{Namespace: "container.azm.ms/disk", Name: "free", Value: 1, Tags: `{}`}, true, mdmSeries{}},
+		{"collected", laTelegrafMetric{Namespace: "container.azm.ms/disk", Name: "used_percent", Value: 40, Tags: `{"device":"sda1","host":"node1"}`}, false, mdmSeries{DimValues: []string{"sda1", "node1"}, Min: 40, Max: 40, Sum: 40, Count: 1}},
+		{"aggregated", laTelegrafMetric{Namespace: "container.azm.ms/disk", Name: "used_percent", Value: 40, Tags: `{"device":"sda1","host":"node1","container.azm.ms/aggregationCount":"3","container.azm.ms/aggregationMin":"30","container.azm.ms/aggregationMax":"50"}`}, false, mdmSeries{DimValues: []string{"sda1", "node1"}, Min: 30, Max: 50, Sum: 120, Count: 3}},
+		{"missing tag", laTelegrafMetric{Namespace: "container.azm.ms/disk", Name: "used_percent", Value: 40, Tags: `{"device":"sda1"}`}, false, mdmSeries{DimValues: []string{"sda1", ""}, Min: 40, Max: 40, Sum: 40, Count: 1}},
+	}
+
+	mappings, err := parseMDMMetricMappings([]byte(`[{"namespace": "container.azm.ms/disk", "name": "used_percent", "metricNamespace": "insights.container/nodes", "metric": "diskUsedPercentage", "dimensions": {"device": "device", "host": "hostName"}}]`))
+	if err != nil {
+		t.Fatalf("parseMDMMetricMappings() error = %v", err)
+	}
+	MDMMetricMappings = mappings
+	defer func() { MDMMetricMappings = nil }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			tt.metric.CollectionTime = "2021-06-01T10:00:00Z"
+			metric := newMDMMetric(&tt.metric)
+			if (metric == nil) != tt.wantNil {
+				t.Fatalf("newMDMMetric() = %+v, want nil %v", metric, tt.wantNil)
+			}
+			if metric == nil {
+				return
+			}
+			baseData := metric.Data.BaseData
+			if metric.Time != tt.metric.CollectionTime || baseData.Metric != "diskUsedPercentage" || baseData.Namespace != "insights.container/nodes" || !reflect.DeepEqual(baseData.DimNames, []string{"device", "hostName"}) {
+				t.Errorf("newMDMMetric() = %+v", metric)
+			}
+			if !reflect.DeepEqual(baseData.Series, []mdmSeries{tt.wantSeries}) {
+				t.Errorf("newMDMMetric() series = %+v, want %+v", baseData.Series, tt.wantSeries)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// chunks remembered as written to mdsd, more than the chunks of the batches retried at once
+	mdsdWrittenChunksWindowSize = 1024
+	// how long a chunk written to mdsd is remembered, longer than the retries of a batch by fluent-bit
+	mdsdWrittenChunksTTL = 10 * time.Minute
+)
+
+// MdsdWrittenChunks the chunks of the batches written to mdsd, so the retry of a batch which failed after some of its
+// chunks were written only writes the chunks which were not
+var MdsdWrittenChunks = newRecentChunks(mdsdWrittenChunksWindowSize, mdsdWrittenChunksTTL)
+
+// mdsdChunkID returns the id of the entries of a chunk, the same for the same records of a retried batch
+func mdsdChunkID(entries []MsgPackEntry) string {
+	h := sha256.New()
+	for i := range entries {
+		writeStringMapHash(h, entries[i].Record)
+		writeStringMapHash(h, entries[i].Metadata)
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func writeStringMapHash(h io.Writer, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h.Write([]byte{'{'})
+	for _, key := range keys {
+		h.Write([]byte(key))
+		h.Write([]byte{':'})
+		h.Write([]byte(m[key]))
+		h.Write([]byte{','})
+	}
+	h.Write([]byte{'}'})
+}
+
+// mdsdInflightLimiter limits the bytes written to mdsd at once by the concurrent flushes, so a backlog does not
+// overwhelm mdsd. A write larger than the limit is done once no other write is in flight
+type mdsdInflightLimiter struct {
+	mu       sync.Mutex
+	maxBytes int
+	inflight int
+	// closed and replaced when bytes are released
+	released chan struct{}
+}
+
+func newMdsdInflightLimiter(maxBytes int) *mdsdInflightLimiter {
+	return &mdsdInflightLimiter{maxBytes: maxBytes, released: make(chan struct{})}
+}
+
+// MdsdInflightLimiter limits the bytes of the container logs written to mdsd at once, no limit by default
+var MdsdInflightLimiter = newMdsdInflightLimiter(0)
+
+// acquire waits until the bytes can be written without exceeding the limit. Returns whether it waited, and the error
+// of the context when it is done before
+func (l *mdsdInflightLimiter) acquire(ctx context.Context, bytes int) (bool, error) {
+	waited := false
+	for {
+		l.mu.Lock()
+		if l.maxBytes <= 0 || l.inflight == 0 || l.inflight+bytes <= l.maxBytes {
+			l.inflight += bytes
+			l.mu.Unlock()
+			return waited, nil
+		}
+		released := l.released
+		l.mu.Unlock()
+		waited = true
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return waited, ctx.Err()
+		}
+	}
+}
+
+// release gives back the bytes of a completed write and wakes up the waiting writes
+func (l *mdsdInflightLimiter) release(bytes int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight -= bytes
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// updateMdsdBackpressureTelemetry counts the writes which waited for the in-flight bytes of mdsd and the records of
+// the chunks which were not written again on the retry of their batch
+func updateMdsdBackpressureTelemetry(waitMs float64, skippedRecords int) {
+	ContainerLogTelemetryMutex.Lock()
+	defer ContainerLogTelemetryMutex.Unlock()
+	MdsdInflightWaitMs += waitMs
+	MdsdRetrySkippedRecordCount += float64(skippedRecords)
+}
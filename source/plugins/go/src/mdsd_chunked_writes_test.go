@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_mdsdInflightLimiter(t *testing.T) {
+	type test_struct struct {
+		testname string
+		maxBytes int
+		inflight int
+		bytes    int
+		wait     bool
+	}
+
+	tests := []test_struct{
+		{"no limit", 0, 1000, 1000, false},
+		{"under the limit", 1000, 400, 600, false},
+		{"over the limit", 1000, 600, 600, true},
+		{"larger than the limit, nothing in flight", 1000, 0, 2000, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			limiter := newMdsdInflightLimiter(tt.maxBytes)
+			if tt.inflight > 0 {
+				limiter.acquire(context.Background(), tt.inflight)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			waited, err := limiter.acquire(ctx, tt.bytes)
+			if waited != tt.wait || (err != nil) != tt.wait {
+				t.Errorf("acquire() = %v, %v, want waited %v", waited, err, tt.wait)
+			}
+		})
+	}
+
+	limiter := newMdsdInflightLimiter(1000)
+	limiter.acquire(context.Background(), 800)
+	acquired := make(chan bool)
+	go func() {
+		waited, err := limiter.acquire(context.Background(), 800)
+		acquired <- waited && err == nil
+	}()
+	time.Sleep(20 * time.Millisecond)
+	limiter.release(800)
+	if !<-acquired {
+		t.Errorf("acquire() did not wait for the bytes in flight to be released")
+	}
+}
+
+func Test_mdsdChunkID(t *testing.T) {
+	entries := []MsgPackEntry{
+		{Record: map[string]string{"LogMessage": "line 1", "ContainerId": "c1"}},
+		{Record: map[string]string{"LogMessage": "line 2", "ContainerId": "c1"}},
+	}
+	retried := []MsgPackEntry{
+		{Time: 1, Record: map[string]string{"ContainerId": "c1", "LogMessage": "line 1"}},
+		{Time: 1, Record: map[string]string{"ContainerId": "c1", "LogMessage": "line 2"}},
+	}
+	if mdsdChunkID(entries) != mdsdChunkID(retried) {
+		t.Errorf("mdsdChunkID() differs for the same records of a retried batch")
+	}
+	if mdsdChunkID(entries) == mdsdChunkID(entries[:1]) {
+		t.Errorf("mdsdChunkID() is the same for different records")
+	}
+}
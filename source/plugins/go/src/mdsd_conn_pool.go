@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variable of the max number of connections to mdsd for the container logs, so parallel flushes write on their own
+// connection. 1 by default
+const envMdsdConnectionPoolSize = "AZMON_MDSD_CONNECTION_POOL_SIZE"
+
+const defaultMdsdConnectionPoolSize = 1
+
+// mdsdConnPool the connections to mdsd of the container logs. A flush takes a connection of the pool for its write, an
+// idle one or a new one when the pool is not full, and waits for a free connection when it is
+type mdsdConnPool struct {
+	mu   sync.Mutex
+	idle []net.Conn
+	// holds a slot for each connection taken by a write or a probe
+	slots chan struct{}
+	dial  func() (net.Conn, error)
+}
+
+func newMdsdConnPool(size int, dial func() (net.Conn, error)) *mdsdConnPool {
+	return &mdsdConnPool{slots: make(chan struct{}, size), dial: dial}
+}
+
+// MdsdContainerLogConnPool the connections to mdsd of the container logs
+var MdsdContainerLogConnPool = newMdsdConnPool(defaultMdsdConnectionPoolSize, dialMdsdContainerLogConn)
+
+// initializeMdsdConnPool reads the size of the pool of the connections to mdsd of the container logs
+func initializeMdsdConnPool() {
+	size := defaultMdsdConnectionPoolSize
+	if value := strings.TrimSpace(os.Getenv(envMdsdConnectionPoolSize)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			size = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d", value, envMdsdConnectionPoolSize, defaultMdsdConnectionPoolSize)
+		}
+	}
+	MdsdContainerLogConnPool = newMdsdConnPool(size, dialMdsdContainerLogConn)
+	Log("Up to %d connections to mdsd are used for the container logs", size)
+}
+
+func dialMdsdContainerLogConn() (net.Conn, error) {
+	network, address := getMdsdFluentNetwork(ContainerType)
+	return net.DialTimeout(network, address, 10*time.Second)
+}
+
+// acquire returns an idle connection, or a new one when there is none, waiting for a free connection when all the
+// connections of the pool are taken
+func (p *mdsdConnPool) acquire() (net.Conn, error) {
+	p.slots <- struct{}{}
+	if conn := p.takeIdle(false); conn != nil {
+		return conn, nil
+	}
+	conn, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// acquireIdle takes the connection idle for the longest time without waiting, nil when all the connections are taken
+// or none is idle
+func (p *mdsdConnPool) acquireIdle() net.Conn {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return nil
+	}
+	conn := p.takeIdle(true)
+	if conn == nil {
+		<-p.slots
+	}
+	return conn
+}
+
+// takeIdle removes the oldest or the last used idle connection from the pool, the writes reusing the last used ones
+func (p *mdsdConnPool) takeIdle(oldest bool) net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	if oldest {
+		conn := p.idle[0]
+		p.idle = p.idle[1:]
+		return conn
+	}
+	conn := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return conn
+}
+
+// release gives the connection back to the pool, or closes it when its write failed so the next write reconnects
+func (p *mdsdConnPool) release(conn net.Conn, failed bool) {
+	if failed {
+		conn.Close()
+	} else {
+		p.mu.Lock()
+		p.idle = append(p.idle, conn)
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// warm opens a connection ahead of the first write when the pool has none
+func (p *mdsdConnPool) warm() error {
+	if p.connected() {
+		return nil
+	}
+	conn, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	p.release(conn, false)
+	return nil
+}
+
+// closeIdle closes the idle connections
+func (p *mdsdConnPool) closeIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, conn := range idle {
+		conn.Close()
+	}
+}
+
+// connected whether the pool has a connection, idle or taken
+func (p *mdsdConnPool) connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle) > 0 || len(p.slots) > 0
+}
+
+// probeIdle writes the heartbeat on the idle connections and closes the ones which fail, then opens a connection when
+// none is left. Returns whether the pool is connected, and whether it reconnected
+func (p *mdsdConnPool) probeIdle(heartbeat []byte, deadline time.Duration) (bool, bool) {
+	p.mu.Lock()
+	idleCount := len(p.idle)
+	p.mu.Unlock()
+	// each idle connection is probed once, the probed ones going back at the end of the idle connections
+	for i := 0; i < idleCount; i++ {
+		conn := p.acquireIdle()
+		if conn == nil {
+			break
+		}
+		conn.SetWriteDeadline(time.Now().Add(deadline))
+		_, err := conn.Write(heartbeat)
+		if err != nil {
+			Log("Error::mdsd::Health probe failed on the mdsd connection, reconnecting ... error : %s", err.Error())
+		}
+		p.release(conn, err != nil)
+	}
+	if p.connected() {
+		return true, false
+	}
+	if err := p.warm(); err != nil {
+		Log("Error::mdsd::Unable to open MDSD msgp socket connection for ContainerLogV2 %s", err.Error())
+		return false, false
+	}
+	return true, true
+}
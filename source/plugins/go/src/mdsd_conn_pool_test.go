@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeMdsdConn a connection to mdsd whose writes fail when broken
+type fakeMdsdConn struct {
+	net.Conn
+	broken bool
+	closed bool
+	writes int
+}
+
+func (c *fakeMdsdConn) Write(b []byte) (int, error) {
+	if c.broken {
+		return 0, errors.New("broken pipe")
+	}
+	c.writes++
+	return len(b), nil
+}
+
+func (c *fakeMdsdConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *fakeMdsdConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func Test_mdsdConnPool(t *testing.T) {
+	var dialed []*fakeMdsdConn
+	dialFails := false
+	pool := newMdsdConnPool(2, func() (net.Conn, error) {
+		if dialFails {
+			return nil, errors.New("no such file or directory")
+		}
+		conn := &fakeMdsdConn{}
+		dialed = append(dialed, conn)
+		return conn, nil
+	})
+
+	first, _ := pool.acquire()
+	second, _ := pool.acquire()
+	if len(dialed) != 2 || first == second {
+		t.Fatalf("got %d connections dialed, want 2 distinct connections", len(dialed))
+	}
+
+	acquired := make(chan net.Conn)
+	go func() {
+		conn, _ := pool.acquire()
+		acquired <- conn
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("acquire() returned while all the connections are taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	pool.release(second, false)
+	if conn := <-acquired; conn != second {
+		t.Errorf("acquire() did not reuse the released connection")
+	}
+
+	pool.release(first, true)
+	if !dialed[0].closed {
+		t.Errorf("the connection of the failed write was not closed")
+	}
+	pool.release(second, false)
+	if conn, _ := pool.acquire(); conn != second || len(dialed) != 2 {
+		t.Errorf("acquire() dialed a new connection while one is idle")
+	}
+	pool.release(second, false)
+
+	// the probe closes the broken idle connection and opens a new one
+	dialed[1].broken = true
+	connected, reconnected := pool.probeIdle([]byte{0x92}, time.Second)
+	if !connected || !reconnected || !dialed[1].closed || len(dialed) != 3 {
+		t.Errorf("probeIdle() = %v, %v with %d connections dialed, want a reconnection", connected, reconnected, len(dialed))
+	}
+	connected, reconnected = pool.probeIdle([]byte{0x92}, time.Second)
+	if !connected || reconnected || dialed[2].writes != 1 {
+		t.Errorf("probeIdle() = %v, %v with %d heartbeats, want the healthy connection kept", connected, reconnected, dialed[2].writes)
+	}
+
+	dialed[2].broken = true
+	dialFails = true
+	if connected, _ := pool.probeIdle([]byte{0x92}, time.Second); connected || pool.connected() {
+		t.Errorf("probeIdle() connected = %v when mdsd is not reachable, want false", connected)
+	}
+	if _, err := pool.acquire(); err == nil {
+		t.Errorf("acquire() succeeded when mdsd is not reachable")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// env variable for the interval (seconds) of the mdsd container log connection health probe, 0 disables the probe
+const envMdsdHealthProbeIntervalSeconds = "AZMON_MDSD_HEALTH_PROBE_INTERVAL_SECONDS"
+
+const (
+	defaultMdsdHealthProbeIntervalSeconds = 30
+	mdsdHealthProbeWriteDeadline          = 2 * time.Second
+)
+
+var (
+	// MdsdHealthProbeTicker to probe the mdsd container log connection periodically
+	MdsdHealthProbeTicker *time.Ticker
+)
+
+// buildMdsdHeartbeat returns a forward mode message without entries, which checks the connection without sending data
+func buildMdsdHeartbeat(tag string) []byte {
+	heartbeat := msgp.Require(nil, 1+msgp.StringPrefixSize+len(tag)+msgp.ArrayHeaderSize)
+	heartbeat = append(heartbeat, 0x92)
+	heartbeat = msgp.AppendString(heartbeat, tag)
+	heartbeat = msgp.AppendArrayHeader(heartbeat, 0)
+	return heartbeat
+}
+
+// startMdsdHealthProbe starts probing the mdsd container log connection, when the v2 route is used
+func startMdsdHealthProbe() {
+	if ContainerLogsRouteV2 == false {
+		return
+	}
+	intervalSeconds := defaultMdsdHealthProbeIntervalSeconds
+	if value := strings.TrimSpace(os.Getenv(envMdsdHealthProbeIntervalSeconds)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			intervalSeconds = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d seconds", value, envMdsdHealthProbeIntervalSeconds, defaultMdsdHealthProbeIntervalSeconds)
+		}
+	}
+	if intervalSeconds == 0 {
+		Log("mdsd connection health probe is disabled")
+		return
+	}
+	Log("Probing the mdsd connection health every %d seconds", intervalSeconds)
+	MdsdHealthProbeTicker = time.NewTicker(time.Second * time.Duration(intervalSeconds))
+	go probeMdsdConnectionHealth()
+}
+
+// probeMdsdConnectionHealth writes a heartbeat on the idle container log connections and reconnects when they all
+// fail, so a half-dead socket is replaced before it fails a flush carrying data
+func probeMdsdConnectionHealth() {
+	for ; true; <-MdsdHealthProbeTicker.C {
+		connected, reconnected := MdsdContainerLogConnPool.probeIdle(buildMdsdHeartbeat(MdsdContainerLogTagName), mdsdHealthProbeWriteDeadline)
+		UpdateMdsdConnectionTelemetry(connected, reconnected)
+	}
+}
+
+// UpdateMdsdConnectionTelemetry updates the mdsd container log connection state gauge and the reconnect counter
+func UpdateMdsdConnectionTelemetry(connected bool, reconnected bool) {
+	ContainerLogTelemetryMutex.Lock()
+	defer ContainerLogTelemetryMutex.Unlock()
+	if connected {
+		MdsdConnectionState = 1
+	} else {
+		MdsdConnectionState = 0
+	}
+	if reconnected {
+		MdsdProbeReconnectCount += 1
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// settings of the plugin conf for the mdsd deployments configured differently than the agent's
+const (
+	// the fluent socket of mdsd, for all the container types
+	mdsdFluentSocketPathSetting = "mdsd_fluent_socket_path"
+	// the event source of the container logs, for the ContainerLog and the ContainerLogV2 schemas
+	mdsdContainerLogSourceNameSetting = "mdsd_container_log_source_name"
+	// the deadline (seconds) of the writes to mdsd
+	mdsdWriteDeadlineSecondsSetting = "mdsd_write_deadline_seconds"
+	// the max size (KB) of the container log messages written to mdsd
+	mdsdMaxMessageSizeKBSetting = "mdsd_max_message_size_kb"
+	// the size (KB) of the chunks a batch is written to mdsd in, so the retry of a batch only writes its chunks which
+	// were not written
+	mdsdWriteChunkSizeKBSetting = "mdsd_write_chunk_size_kb"
+	// the max size (KB) of the container logs written to mdsd at once by the concurrent flushes
+	mdsdMaxInflightKBSetting = "mdsd_max_inflight_kb"
+)
+
+const (
+	defaultMdsdWriteDeadlineSeconds = 10
+	defaultMdsdWriteChunkSizeKB     = 1024
+)
+
+var (
+	// MdsdFluentSocketPath the fluent socket of mdsd of the plugin conf, the socket of the container type when empty
+	MdsdFluentSocketPath string
+	// MdsdConfiguredContainerLogSource the event source of the container logs of the plugin conf, the source of the
+	// schema when empty
+	MdsdConfiguredContainerLogSource string
+	// MdsdWriteDeadline the deadline of the writes to mdsd
+	MdsdWriteDeadline = time.Second * defaultMdsdWriteDeadlineSeconds
+	// MdsdMaxMessageBytes the max size of a container log message written to mdsd, the records of a flush over it being
+	// written in several messages. No limit when 0
+	MdsdMaxMessageBytes int
+	// MdsdWriteChunkBytes the size of the chunks a batch of container logs is written to mdsd in. Not chunked when 0
+	MdsdWriteChunkBytes = defaultMdsdWriteChunkSizeKB * 1024
+)
+
+// initializeMdsdSettings reads the socket, the event source, the write deadline and the max message size of mdsd from
+// the plugin conf
+func initializeMdsdSettings(pluginConfig map[string]string) {
+	MdsdFluentSocketPath = strings.TrimSpace(pluginConfig[mdsdFluentSocketPathSetting])
+	if MdsdFluentSocketPath != "" {
+		Log("Using the mdsd fluent socket %s", MdsdFluentSocketPath)
+	}
+	MdsdConfiguredContainerLogSource = strings.TrimSpace(pluginConfig[mdsdContainerLogSourceNameSetting])
+	if MdsdConfiguredContainerLogSource != "" {
+		Log("Using the mdsd event source %s for the container logs", MdsdConfiguredContainerLogSource)
+	}
+
+	deadlineSeconds := defaultMdsdWriteDeadlineSeconds
+	if value := strings.TrimSpace(pluginConfig[mdsdWriteDeadlineSecondsSetting]); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			deadlineSeconds = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d seconds", value, mdsdWriteDeadlineSecondsSetting, defaultMdsdWriteDeadlineSeconds)
+		}
+	}
+	MdsdWriteDeadline = time.Second * time.Duration(deadlineSeconds)
+
+	MdsdMaxMessageBytes = 0
+	if value := strings.TrimSpace(pluginConfig[mdsdMaxMessageSizeKBSetting]); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			MdsdMaxMessageBytes = parsed * 1024
+		} else {
+			Log("Invalid value %s for %s, the size of the messages is not limited", value, mdsdMaxMessageSizeKBSetting)
+		}
+	}
+	MdsdWriteChunkBytes = readMdsdSizeSetting(pluginConfig, mdsdWriteChunkSizeKBSetting, defaultMdsdWriteChunkSizeKB)
+	MdsdInflightLimiter = newMdsdInflightLimiter(readMdsdSizeSetting(pluginConfig, mdsdMaxInflightKBSetting, 0))
+	Log("mdsd write deadline = %s, max container log message size = %d bytes, write chunk size = %d bytes, max in-flight = %d bytes (0 for no limit)", MdsdWriteDeadline, MdsdMaxMessageBytes, MdsdWriteChunkBytes, MdsdInflightLimiter.maxBytes)
+}
+
+// readMdsdSizeSetting returns the size in bytes of a setting in KB of the plugin conf, the default when not set or
+// invalid
+func readMdsdSizeSetting(pluginConfig map[string]string, setting string, defaultKB int) int {
+	value := strings.TrimSpace(pluginConfig[setting])
+	if value == "" {
+		return defaultKB * 1024
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		Log("Invalid value %s for %s, using the default of %d KB", value, setting, defaultKB)
+		return defaultKB * 1024
+	}
+	return parsed * 1024
+}
+
+// mdsdWriteChunkLimit returns the max size of the chunks written to mdsd, the smallest of the chunk size and the max
+// message size. No limit when 0
+func mdsdWriteChunkLimit() int {
+	if MdsdMaxMessageBytes > 0 && (MdsdWriteChunkBytes <= 0 || MdsdMaxMessageBytes < MdsdWriteChunkBytes) {
+		return MdsdMaxMessageBytes
+	}
+	return MdsdWriteChunkBytes
+}
+
+// splitMdsdEntries returns the entries of each message written to mdsd, the size of a message being estimated as the
+// forward message of its entries. All the entries are written in one message when maxBytes is 0, and an entry over
+// maxBytes is written in its own message
+func splitMdsdEntries(tag string, entries []MsgPackEntry, maxBytes int) [][]MsgPackEntry {
+	if maxBytes <= 0 {
+		return [][]MsgPackEntry{entries}
+	}
+	headerSize := estimateMdsdMessageSize(tag, nil)
+	var messages [][]MsgPackEntry
+	start := 0
+	size := headerSize
+	for i := range entries {
+		entrySize := estimateMdsdEntrySize(entries[i])
+		if i > start && size+entrySize > maxBytes {
+			messages = append(messages, entries[start:i])
+			start = i
+			size = headerSize
+		}
+		size += entrySize
+	}
+	return append(messages, entries[start:])
+}
+
+// estimateMdsdEntrySize returns the estimated size of the [time, record] array of the entry in a forward message
+func estimateMdsdEntrySize(entry MsgPackEntry) int {
+	size := 1 + msgp.Int64Size + msgp.GuessSize(entry.Record)
+	if entry.Metadata != nil {
+		size += 1 + msgp.GuessSize(entry.Metadata)
+	}
+	return size
+}
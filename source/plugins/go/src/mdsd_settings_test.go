@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_initializeMdsdSettings(t *testing.T) {
+	type test_struct struct {
+		testname     string
+		pluginConfig map[string]string
+		deadline     time.Duration
+		maxBytes     int
+		socketPath   string
+	}
+
+	tests := []test_struct{
+		{"defaults", map[string]string{}, 10 * time.Second, 0, ""},
+		{
+			"configured",
+			map[string]string{mdsdFluentSocketPathSetting: " /var/run/ama/fluent.socket ", mdsdWriteDeadlineSecondsSetting: "30", mdsdMaxMessageSizeKBSetting: "512"},
+			30 * time.Second,
+			512 * 1024,
+			"/var/run/ama/fluent.socket",
+		},
+		{"invalid", map[string]string{mdsdWriteDeadlineSecondsSetting: "0", mdsdMaxMessageSizeKBSetting: "-1"}, 10 * time.Second, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			initializeMdsdSettings(tt.pluginConfig)
+			if MdsdWriteDeadline != tt.deadline || MdsdMaxMessageBytes != tt.maxBytes || MdsdFluentSocketPath != tt.socketPath {
+				t.Errorf("got deadline %s, max message bytes %d and socket %q, want %s, %d and %q", MdsdWriteDeadline, MdsdMaxMessageBytes, MdsdFluentSocketPath, tt.deadline, tt.maxBytes, tt.socketPath)
+			}
+		})
+	}
+	initializeMdsdSettings(map[string]string{})
+}
+
+func Test_splitMdsdEntries(t *testing.T) {
+	type test_struct struct {
+		testname string
+		maxBytes int
+		want     []int
+	}
+
+	entries := make([]MsgPackEntry, 5)
+	for i := range entries {
+		entries[i] = MsgPackEntry{Record: map[string]string{"LogEntry": "0123456789012345678901234567890123456789"}}
+	}
+	headerSize := 1 + 5 + len("ContainerLogV2Source") + 5
+
+	tests := []test_struct{
+		{"no limit", 0, []int{5}},
+		{"large limit", 1 << 20, []int{5}},
+		{"two entries per message", headerSize + 2*estimateMdsdEntrySize(entries[0]), []int{2, 2, 1}},
+		{"entry over the limit", 10, []int{1, 1, 1, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			messages := splitMdsdEntries("ContainerLogV2Source", entries, tt.maxBytes)
+			var got []int
+			total := 0
+			for _, message := range messages {
+				got = append(got, len(message))
+				total += len(message)
+			}
+			if len(got) != len(tt.want) || total != len(entries) {
+				t.Fatalf("splitMdsdEntries() = messages of %v entries, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("splitMdsdEntries() = messages of %v entries, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_mdsdWriteChunkLimit(t *testing.T) {
+	type test_struct struct {
+		testname        string
+		maxMessageBytes int
+		chunkBytes      int
+		want            int
+	}
+
+	tests := []test_struct{
+		{"chunk size", 0, 1024, 1024},
+		{"max message size under the chunk size", 512, 1024, 512},
+		{"max message size over the chunk size", 2048, 1024, 1024},
+		{"not chunked", 2048, 0, 2048},
+		{"no limit", 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			MdsdMaxMessageBytes, MdsdWriteChunkBytes = tt.maxMessageBytes, tt.chunkBytes
+			if got := mdsdWriteChunkLimit(); got != tt.want {
+				t.Errorf("mdsdWriteChunkLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	initializeMdsdSettings(map[string]string{})
+}
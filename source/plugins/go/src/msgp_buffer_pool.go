@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// buffers grown over this size are not kept in the pool, so a burst of large batches does not hold their memory
+const maxPooledMsgpBufferBytes = 4 * 1024 * 1024
+
+// msgpBuffer a buffer the msgpack messages written to mdsd are serialized in, reused across the flushes
+type msgpBuffer struct {
+	b []byte
+}
+
+var msgpBufferPool = sync.Pool{
+	New: func() interface{} { return &msgpBuffer{} },
+}
+
+// getMsgpBuffer returns an empty buffer of the pool with room for size bytes
+func getMsgpBuffer(size int) *msgpBuffer {
+	buf := msgpBufferPool.Get().(*msgpBuffer)
+	buf.b = msgp.Require(buf.b[:0], size)
+	return buf
+}
+
+// release gives the buffer back to the pool, once the bytes serialized in it are written
+func (buf *msgpBuffer) release() {
+	if cap(buf.b) > maxPooledMsgpBufferBytes {
+		buf.b = nil
+	}
+	msgpBufferPool.Put(buf)
+}
+
+// estimateMdsdMessageSize returns the estimated size of the forward message of the entries
+func estimateMdsdMessageSize(tag string, entries []MsgPackEntry) int {
+	size := 1 + msgp.StringPrefixSize + len(tag) + msgp.ArrayHeaderSize
+	for i := range entries {
+		size += estimateMdsdEntrySize(entries[i])
+	}
+	return size
+}
+
+// appendMdsdEntries appends the [time, record] array of each entry, the entries being serialized one after the other
+// in the buffer without building the message first
+func appendMdsdEntries(b []byte, entries []MsgPackEntry, entryTime int64) []byte {
+	for i := range entries {
+		b = append(b, 0x92)
+		b = appendEntryTime(b, entryTime, entries[i].Metadata)
+		b = msgp.AppendMapStrStr(b, entries[i].Record)
+	}
+	return b
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+func benchmarkMdsdEntries(count int) []MsgPackEntry {
+	entries := make([]MsgPackEntry, count)
+	for i := range entries {
+		entries[i] = MsgPackEntry{Record: map[string]string{
+			"LogMessage":    fmt.Sprintf("log line %d of the container with some text in it", i),
+			"ContainerId":   "5cbc5c0d4a0a4ae8a9f1f7d2e2a0c4b1",
+			"Stream":        "stdout",
+			"TimeGenerated": "2021-07-01T00:00:00.000000000Z",
+		}}
+	}
+	return entries
+}
+
+func Test_appendMdsdEntries(t *testing.T) {
+	type test_struct struct {
+		testname string
+		entries  []MsgPackEntry
+	}
+
+	tests := []test_struct{
+		{"no entries", nil},
+		// a single field per record, the fields of a map not being serialized in a fixed order
+		{"records", []MsgPackEntry{{Record: map[string]string{"LogMessage": "line 1"}}, {Record: map[string]string{"LogMessage": "line 2"}}}},
+		{"records with metadata", []MsgPackEntry{{Record: map[string]string{"LogMessage": "line"}, Metadata: map[string]string{"k": "v"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			var want []byte
+			for i := range tt.entries {
+				want = append(want, 0x92)
+				want = appendEntryTime(want, 42, tt.entries[i].Metadata)
+				want = msgp.AppendMapStrStr(want, tt.entries[i].Record)
+			}
+			buf := getMsgpBuffer(estimateMdsdMessageSize("tag", tt.entries))
+			defer buf.release()
+			buf.b = appendMdsdEntries(buf.b, tt.entries, 42)
+			if !bytes.Equal(buf.b, want) {
+				t.Errorf("appendMdsdEntries() = %x, want %x", buf.b, want)
+			}
+		})
+	}
+}
+
+func Test_msgpBufferRelease(t *testing.T) {
+	type test_struct struct {
+		testname string
+		size     int
+		kept     bool
+	}
+
+	tests := []test_struct{
+		{"small buffer", 1024, true},
+		{"buffer over the max pooled size", maxPooledMsgpBufferBytes + 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			buf := getMsgpBuffer(tt.size)
+			buf.b = append(buf.b, 0x92)
+			buf.release()
+			if kept := buf.b != nil; kept != tt.kept {
+				t.Errorf("release() kept the buffer = %v, want %v", kept, tt.kept)
+			}
+			if buf = getMsgpBuffer(0); len(buf.b) != 0 {
+				t.Errorf("getMsgpBuffer() returned a buffer of %d bytes, want an empty buffer", len(buf.b))
+			}
+		})
+	}
+}
+
+// Benchmark_serializeMdsdMessage compares the allocations of the message serialized in a new buffer and in a buffer
+// of the pool
+func Benchmark_serializeMdsdMessage(b *testing.B) {
+	entries := benchmarkMdsdEntries(1000)
+	size := estimateMdsdMessageSize("tag", entries)
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			msgpBytes := msgp.Require(nil, size)
+			msgpBytes = appendMdsdEntries(msgpBytes, entries, 42)
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := getMsgpBuffer(size)
+			buf.b = appendMdsdEntries(buf.b, entries, 42)
+			buf.release()
+		}
+	})
+}
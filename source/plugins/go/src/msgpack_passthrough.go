@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"Docker-Provider/source/plugins/go/src/containermetadata"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// rewrites the container log chunks into the forward message for mdsd without decoding the records, when true
+const envMdsdMsgpackPassthrough = "AZMON_MDSD_MSGPACK_PASSTHROUGH"
+
+// MdsdMsgpackPassthrough when true, the container log chunks of the mdsd route are rewritten from the fluent-bit
+// msgpack into the forward message for mdsd, instead of being decoded into maps and encoded again
+var MdsdMsgpackPassthrough bool
+
+// initializeMsgpackPassthrough enables the passthrough for the routes which forward the msgpack to mdsd
+func initializeMsgpackPassthrough() {
+	MdsdMsgpackPassthrough = false
+	if !strings.EqualFold(strings.TrimSpace(os.Getenv(envMdsdMsgpackPassthrough)), "true") {
+		return
+	}
+	if ContainerLogsRouteV2 == false {
+		Log("%s is only supported on the %s route, the container log records are decoded", envMdsdMsgpackPassthrough, ContainerLogsV2Route)
+		return
+	}
+	if containerExitLogMarkerEnabled {
+		Log("%s is not supported with the container exit log markers, the container log records are decoded", envMdsdMsgpackPassthrough)
+		return
+	}
+	// the passthrough does not look into the records, so their log analytics limits are neither enforced nor counted
+	if LogAnalyticsLimitsAction != laLimitsActionNone {
+		Log("%s is not supported with %s=%s, the container log records are decoded", envMdsdMsgpackPassthrough, envLogAnalyticsLimitsAction, LogAnalyticsLimitsAction)
+		return
+	}
+	// the failover sends the records to ODS in its schema
+	if ContainerLogFailoverRoute != "" {
+		Log("%s is not supported with the failover to the %s route, the container log records are decoded", envMdsdMsgpackPassthrough, ContainerLogFailoverRoute)
+		return
+	}
+	// the tee route is sent the decoded records in the schema of its sink
+	if TeeSink != nil {
+		Log("%s is not supported with the tee route %s, the container log records are decoded", envMdsdMsgpackPassthrough, TeeRoute)
+		return
+	}
+	// the passthrough does not look into the records, so their throughput is not counted
+	if ContainerLogThroughputInterval != 0 {
+		Log("%s is not supported with the container log throughput metrics, the container log records are decoded", envMdsdMsgpackPassthrough)
+		return
+	}
+	// the passthrough does not look into the records, so their lines can't be merged
+	if MultilineAssembler != nil {
+		Log("%s is not supported with the multiline assembly, the container log records are decoded", envMdsdMsgpackPassthrough)
+		return
+	}
+	// the passthrough does not look into the records, so their json fields can't be promoted
+	if len(JSONPromotedFields) > 0 {
+		Log("%s is not supported with the json field promotion, the container log records are decoded", envMdsdMsgpackPassthrough)
+		return
+	}
+	// the passthrough does not look into the records, so they can't be dropped
+	if len(LogDropRules) > 0 {
+		Log("%s is not supported with the log drop rules, the container log records are decoded", envMdsdMsgpackPassthrough)
+		return
+	}
+	// the failed chunks of the passthrough are not decoded, so they can't be spooled
+	if SpoolDir != "" {
+		Log("%s is not supported with the disk spool, the container log records are decoded", envMdsdMsgpackPassthrough)
+		return
+	}
+	if _, ok := ColumnLengthLimits[columnLogEntry]; ok {
+		Log("%s is not supported with a %s length limit, the container log records are decoded", envMdsdMsgpackPassthrough, columnLogEntry)
+		return
+	}
+	MdsdMsgpackPassthrough = true
+	Log("msgpack passthrough enabled for the container logs of the %s route", getContainerLogsRouteName())
+	if SortBatchByTimestamp {
+		Log("The container log chunks of the msgpack passthrough are not sorted by timestamp")
+	}
+}
+
+// containerLogMetadata the snapshot of the container metadata added to the records
+type containerLogMetadata struct {
+	imageIDs      map[string]string
+	names         map[string]string
+	podUIDs       map[string]string
+	restartCounts map[string]string
+	labels        map[string]string
+	// log collection annotations of the pods of the containers
+	logCollections map[string]podLogCollection
+	// the containers missing from the maps looked up in the flush, and whether the maps were copied to add them
+	lookedUp map[string]bool
+	copied   bool
+	// the deadline of the queries of the lookups of the flush, set by the first one
+	lookupDeadline time.Time
+}
+
+// chunkRecord the fields of a fluent-bit record used by the container log schemas. The values point into the chunk
+type chunkRecord struct {
+	log      []byte
+	stream   []byte
+	time     []byte
+	filepath []byte
+}
+
+// passthroughBatch the forward message rewritten from a chunk, and the stats of its records
+type passthroughBatch struct {
+	msgpBytes []byte
+	// offset of the entries in msgpBytes
+	entriesOffset       int
+	numRecords          int
+	logBytes            int
+	maxLatency          float64
+	maxLatencyContainer string
+	// ordering lanes of the containers of the chunk
+	lanes []int
+}
+
+// PostContainerLogChunk flushes a chunk of container logs to mdsd with the msgpack passthrough. It returns false when
+// the chunk has to be decoded and flushed by PostDataHelper instead, i.e. when the chunk can't be rewritten or the
+// route is over its retry budget, which samples and dead-letters the decoded records
+func PostContainerLogChunk(chunk []byte) (int, bool) {
+	route := getContainerLogsRouteName()
+	if isRoutePaused(route) {
+		return output.FLB_RETRY, true
+	}
+	if isOverRetryBudget(route) {
+		return output.FLB_OK, false
+	}
+	// the redaction rules are reloaded on their changes, so the chunks are decoded once there are rules
+	if hasLogRedactionRules() {
+		return output.FLB_OK, false
+	}
+	start := time.Now()
+	ensureMdsdContainerLogTagName()
+	imageIDMap, nameIDMap, podUIDMap, restartCountMap := snapshotContainerMetadata()
+	metadata := containerLogMetadata{imageIDs: imageIDMap, names: nameIDMap, podUIDs: podUIDMap, restartCounts: restartCountMap, labels: snapshotContainerLabels(), logCollections: snapshotPodLogCollections()}
+	batch, err := buildMdsdForwardFromChunk(MdsdContainerLogTagName, chunk, start, metadata)
+	if err != nil {
+		Log("PostContainerLogChunk::Warning::unable to rewrite the chunk, decoding the records instead: %s", err.Error())
+		return output.FLB_OK, false
+	}
+	// the decoded records are written in several messages
+	if MdsdMaxMessageBytes > 0 && len(batch.msgpBytes) > MdsdMaxMessageBytes {
+		return output.FLB_OK, false
+	}
+	batchID := flushChunkID(chunk)
+	if retCode, backingOff := waitRetryBackoff("PostContainerLogChunk", route, batch.numRecords); backingOff {
+		return retCode, true
+	}
+	if skipFlushedBatch("PostContainerLogChunk", route, batchID, batch.numRecords) {
+		return output.FLB_OK, true
+	}
+	span := startFlushSpan("PostContainerLogChunk")
+	span.setAttribute("records", batch.numRecords)
+	span.setAttribute("chunk.bytes", batch.logBytes)
+	ctx, stopWatchdog := startFlushWatchdog("PostContainerLogChunk")
+	defer stopWatchdog()
+	releaseLanes, err := ContainerOrdering.acquire(ctx, batch.lanes)
+	if err != nil {
+		Log("PostContainerLogChunk::Error::the earlier flushes of the containers did not complete before the flush deadline, will retry")
+		recordFlushOutcome(route, output.FLB_RETRY)
+		span.finish(output.FLB_RETRY)
+		return output.FLB_RETRY, true
+	}
+	releaseFlushSlot, err := acquireFlushSlot(ctx, route)
+	if err != nil {
+		releaseLanes()
+		Log("PostContainerLogChunk::Error::no in-flight flush slot available before the flush deadline, will retry")
+		recordFlushOutcome(route, output.FLB_RETRY)
+		span.finish(output.FLB_RETRY)
+		return output.FLB_RETRY, true
+	}
+	retCode := postContainerLogChunk(ctx, batch, start)
+	releaseFlushSlot()
+	releaseLanes()
+	retCode = recordRetryBackoffOutcome("PostContainerLogChunk", route, retCode, batch.numRecords, time.Now())
+	recordFlushOutcome(route, retCode)
+	if retCode == output.FLB_OK {
+		addFlushedBatch(route, batchID)
+	}
+	span.finish(retCode)
+	return retCode, true
+}
+
+func postContainerLogChunk(ctx context.Context, batch *passthroughBatch, start time.Time) int {
+	if DataResidencyBlocked == true {
+		Log("PostContainerLogChunk::Warning::dropping %d records since the workspace region violates the region policy", batch.numRecords)
+		countDataResidencyDroppedRecords(batch.numRecords)
+		return output.FLB_OK
+	}
+	if DataBoundaryWorkspaceBlocked == true {
+		Log("PostContainerLogChunk::Warning::dropping %d records since the workspace is outside of the %s data boundary", batch.numRecords, DataBoundary)
+		return output.FLB_OK
+	}
+	if !isDownstreamReady("PostContainerLogChunk") {
+		return output.FLB_RETRY
+	}
+	if batch.numRecords == 0 {
+		return output.FLB_OK
+	}
+	// smooth the send rate when replaying a backlog, so the burst doesn't get throttled downstream
+	if !throttleCatchUp(ctx, batch.numRecords, batch.logBytes) {
+		return output.FLB_RETRY
+	}
+	if ContainerLogSchemaV2 == false {
+		FlushedRecordsSize += float64(batch.logBytes)
+	}
+
+	msgpBytes := batch.msgpBytes
+	ackChunk := ""
+	if option := newMdsdForwardOption(batch.numRecords); option != nil {
+		// the option follows the entries, which are packed and compressed when the route accepts it
+		message, err := appendForwardMessage(nil, MdsdContainerLogTagName, batch.msgpBytes[batch.entriesOffset:], batch.numRecords, option)
+		if err != nil {
+			Log("Error::mdsd::Failed to encode the forward message of %d records. Will retry ... error : %s", batch.numRecords, err.Error())
+			return output.FLB_RETRY
+		}
+		msgpBytes = message
+		ackChunk = option.Chunk
+	}
+
+	sendStart := time.Now()
+	elapsed, err := writeContainerLogsToMdsd(ctx, msgpBytes, ackChunk, batch.numRecords, start)
+	// the chunk bypasses the sink, its send is counted for the sink of the route
+	recordSinkSend(ContainerLogSink, batch.numRecords, batch.logBytes, time.Since(sendStart), err)
+	if err != nil {
+		return output.FLB_RETRY
+	}
+	updateContainerLogFlushTelemetry(batch.numRecords, elapsed, batch.maxLatency, batch.maxLatencyContainer)
+	return output.FLB_OK
+}
+
+// buildMdsdForwardFromChunk rewrites the fluent-bit chunk into the forward message [tag, [[time, record], ...]] for
+// mdsd. The records are filtered and enriched the same way as by postDataHelper, but the values are copied from the
+// chunk as is, without decoding the records into maps
+func buildMdsdForwardFromChunk(tag string, chunk []byte, start time.Time, metadata containerLogMetadata) (*passthroughBatch, error) {
+	batch := &passthroughBatch{}
+	// the enrichment fields are about the size of the fluent-bit fields they replace
+	out := make([]byte, 0, len(chunk)+len(chunk)/4+len(tag)+16)
+	out = append(out, 0x92)
+	out = msgp.AppendString(out, tag)
+	// array 32 header, the number of entries is known once the records are filtered
+	entriesHeader := len(out)
+	out = append(out, 0xdd, 0, 0, 0, 0)
+	batch.entriesOffset = len(out)
+
+	// mdsd uses this time in its buffer/expiry calculations, so it is the flush time rather than the log time
+	batchTime := time.Now().Unix()
+	timeOfCommand := start.Format(time.RFC3339)
+	metadataCache := recordMetadataCache{}
+
+	// the records of a chunk are mostly from the same file, so the parsed file name is reused
+	var lastFilepath []byte
+	var containerID, k8sNamespace, k8sPodName, containerName string
+	parsed := false
+	lanes := make(map[int]bool)
+	runtime := getContainerRuntime()
+
+	b := chunk
+	for len(b) > 0 {
+		record, rest, err := readChunkRecord(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+		if len(record.stream) == 0 {
+			// not parsed by fluent-bit, the parser does not match the runtime of the node
+			if line, ok := parseRuntimeLogLine(runtime, record.log); ok {
+				record.log, record.stream, record.time = line.log, line.stream, line.time
+			}
+		}
+
+		if !parsed || !bytes.Equal(record.filepath, lastFilepath) {
+			logFile := containermetadata.ParseContainerLogFilePath(string(record.filepath))
+			containerID, k8sNamespace, k8sPodName, containerName = logFile.ContainerID, logFile.Namespace, logFile.PodName, logFile.ContainerName
+			lastFilepath = record.filepath
+			parsed = true
+			if ContainerOrdering != nil {
+				lanes[ContainerOrdering.lane(record.filepath)] = true
+			}
+		}
+		if bytes.EqualFold(record.stream, []byte("stdout")) {
+			if containerID == "" || isContainerLogExcluded(metadata.logCollections, containerID, "stdout", isNamespaceExcluded(StdoutIgnoreNsSet, StdoutIncludeNsSet, k8sNamespace)) {
+				continue
+			}
+		} else if bytes.EqualFold(record.stream, []byte("stderr")) {
+			if containerID == "" || isContainerLogExcluded(metadata.logCollections, containerID, "stderr", isNamespaceExcluded(StderrIgnoreNsSet, StderrIncludeNsSet, k8sNamespace)) {
+				continue
+			}
+		}
+
+		metadata.resolve(containerID, k8sNamespace, k8sPodName)
+		podUID, hasPodUID := metadata.podUIDs[containerID]
+		restartCount, hasRestartCount := metadata.restartCounts[containerID]
+		if ContainerLogSchemaV2 == false {
+			// the ContainerLog table of the v1 schema has no pod uid and restart count columns
+			hasPodUID, hasRestartCount = false, false
+		}
+		labels, hasLabels := metadata.labels[containerID]
+		fields := uint32(0)
+		if hasPodUID {
+			fields++
+		}
+		if hasRestartCount {
+			fields++
+		}
+		if hasLabels {
+			fields++
+		}
+		if LogLevelDetection {
+			fields++
+		}
+
+		out = append(out, 0x92)
+		if ForwardRecordMetadata {
+			out = appendEntryTime(out, batchTime, metadataCache.get(k8sNamespace))
+		} else {
+			out = msgp.AppendInt64(out, batchTime)
+		}
+		if ContainerLogSchemaV2 == true {
+			out = msgp.AppendMapHeader(out, fields+8)
+			out = appendStringField(out, "Computer", Computer)
+			out = appendStringField(out, "ContainerId", containerID)
+			out = appendStringField(out, "ContainerName", containerName)
+			out = appendStringField(out, "PodName", k8sPodName)
+			out = appendStringField(out, "PodNamespace", k8sNamespace)
+			out = appendBytesField(out, "LogMessage", record.log)
+			out = appendBytesField(out, "LogSource", record.stream)
+			out = appendBytesField(out, "TimeGenerated", record.time)
+		} else {
+			image, hasImage := metadata.imageIDs[containerID]
+			name, hasName := metadata.names[containerID]
+			if hasImage {
+				fields++
+			}
+			if hasName {
+				fields++
+			}
+			out = msgp.AppendMapHeader(out, fields+7)
+			out = appendBytesField(out, "LogEntry", record.log)
+			out = appendBytesField(out, "LogEntrySource", record.stream)
+			out = appendBytesField(out, "LogEntryTimeStamp", record.time)
+			out = appendStringField(out, "SourceSystem", "Containers")
+			out = appendStringField(out, "Id", containerID)
+			if hasImage {
+				out = appendStringField(out, "Image", image)
+			}
+			if hasName {
+				out = appendStringField(out, "Name", name)
+			}
+			out = appendStringField(out, "TimeOfCommand", timeOfCommand)
+			out = appendStringField(out, "Computer", Computer)
+		}
+		if hasPodUID {
+			out = appendStringField(out, "PodUid", podUID)
+		}
+		if hasRestartCount {
+			out = appendStringField(out, "RestartCount", restartCount)
+		}
+		if hasLabels {
+			out = appendStringField(out, "ContainerLabels", labels)
+		}
+		if LogLevelDetection {
+			out = appendStringField(out, "LogLevel", detectLogLevel(string(record.log), string(record.stream)))
+		}
+
+		batch.numRecords++
+		batch.logBytes += len(record.log)
+		if len(record.time) > 0 {
+			loggedTime, e := time.Parse(time.RFC3339, string(record.time))
+			if e != nil {
+				message := fmt.Sprintf("Error while converting logEntryTimeStamp for telemetry purposes: %s", e.Error())
+				Log(message)
+				SendException(message)
+			} else {
+				ltncy := float64(start.Sub(loggedTime) / time.Millisecond)
+				if ltncy >= batch.maxLatency {
+					batch.maxLatency = ltncy
+					batch.maxLatencyContainer = containerName + "=" + containerID
+				}
+			}
+		}
+	}
+
+	binary.BigEndian.PutUint32(out[entriesHeader+1:], uint32(batch.numRecords))
+	batch.msgpBytes = out
+	batch.lanes = sortedLanes(lanes)
+	return batch, nil
+}
+
+// readChunkRecord reads the next entry of a fluent-bit chunk, either [time, record] or [[time, metadata], record]
+// since fluent-bit 2.1, and returns the fields of the record used by the container log schemas
+func readChunkRecord(b []byte) (chunkRecord, []byte, error) {
+	var record chunkRecord
+	size, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return record, b, err
+	}
+	if size != 2 {
+		return record, b, fmt.Errorf("unexpected entry with %d elements", size)
+	}
+	// the time, or the time and the metadata
+	if b, err = msgp.Skip(b); err != nil {
+		return record, b, err
+	}
+	fields, b, err := msgp.ReadMapHeaderBytes(b)
+	if err != nil {
+		return record, b, err
+	}
+	for i := uint32(0); i < fields; i++ {
+		var key []byte
+		if key, b, err = readStringBytes(b); err != nil {
+			return record, b, err
+		}
+		var value *[]byte
+		switch string(key) {
+		case "log":
+			value = &record.log
+		case "stream":
+			value = &record.stream
+		case "time":
+			value = &record.time
+		case "filepath":
+			value = &record.filepath
+		}
+		if valueType := msgp.NextType(b); value != nil && (valueType == msgp.StrType || valueType == msgp.BinType) {
+			*value, b, err = readStringBytes(b)
+		} else {
+			b, err = msgp.Skip(b)
+		}
+		if err != nil {
+			return record, b, err
+		}
+	}
+	return record, b, nil
+}
+
+// readStringBytes reads a str or a bin without copying it, fluent-bit encodes the log lines as either
+func readStringBytes(b []byte) ([]byte, []byte, error) {
+	if msgp.NextType(b) == msgp.BinType {
+		return msgp.ReadBytesZC(b)
+	}
+	return msgp.ReadStringZC(b)
+}
+
+func appendStringField(b []byte, key string, value string) []byte {
+	b = msgp.AppendString(b, key)
+	return msgp.AppendString(b, value)
+}
+
+// appendBytesField appends the value as a str, as the records encoded by msgp.AppendMapStrStr
+func appendBytesField(b []byte, key string, value []byte) []byte {
+	b = msgp.AppendString(b, key)
+	return msgp.AppendStringFromBytes(b, value)
+}
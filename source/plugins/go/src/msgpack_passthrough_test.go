@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+const passthroughTestFilepath = "/var/log/containers/pod-1_kube-apps_web-0123456789abcdef.log"
+
+// appendChunkEntry appends a fluent-bit entry with the fields encoded as bin, the fluent-bit 2.1 format when withMetadata
+func appendChunkEntry(b []byte, withMetadata bool, fields map[string]string) []byte {
+	b = msgp.AppendArrayHeader(b, 2)
+	if withMetadata {
+		b = msgp.AppendArrayHeader(b, 2)
+		b = msgp.AppendInt64(b, 1700000000)
+		b = msgp.AppendMapHeader(b, 0)
+	} else {
+		b = msgp.AppendInt64(b, 1700000000)
+	}
+	b = msgp.AppendMapHeader(b, uint32(len(fields)))
+	for key, value := range fields {
+		b = msgp.AppendString(b, key)
+		b = msgp.AppendBytes(b, []byte(value))
+	}
+	return b
+}
+
+// readForwardRecords decodes the forward message written to mdsd
+func readForwardRecords(t *testing.T, b []byte) (string, []map[string]string) {
+	_, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, b, err := msgp.ReadStringBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []map[string]string{}
+	for i := uint32(0); i < entries; i++ {
+		if _, b, err = msgp.ReadArrayHeaderBytes(b); err != nil {
+			t.Fatal(err)
+		}
+		if _, b, err = msgp.ReadInt64Bytes(b); err != nil {
+			t.Fatal(err)
+		}
+		var fields uint32
+		if fields, b, err = msgp.ReadMapHeaderBytes(b); err != nil {
+			t.Fatal(err)
+		}
+		record := make(map[string]string)
+		for j := uint32(0); j < fields; j++ {
+			var key, value string
+			if key, b, err = msgp.ReadStringBytes(b); err != nil {
+				t.Fatal(err)
+			}
+			if value, b, err = msgp.ReadStringBytes(b); err != nil {
+				t.Fatal(err)
+			}
+			record[key] = value
+		}
+		records = append(records, record)
+	}
+	if len(b) != 0 {
+		t.Fatalf("%d bytes left after the forward message", len(b))
+	}
+	return tag, records
+}
+
+func Test_buildMdsdForwardFromChunk(t *testing.T) {
+	type test_struct struct {
+		testname     string
+		schemaV2     bool
+		withMetadata bool
+		records      []map[string]string
+		want         []map[string]string
+	}
+
+	record := map[string]string{"log": "hello", "stream": "stdout", "time": "2023-11-14T22:13:20Z", "filepath": passthroughTestFilepath, "tag": "oms.container.log.la"}
+	tests := []test_struct{
+		{"v2 schema", true, false,
+			[]map[string]string{record},
+			[]map[string]string{{"Computer": "node-1", "ContainerId": "0123456789abcdef", "ContainerName": "web", "PodName": "pod-1", "PodNamespace": "kube-apps",
+				"LogMessage": "hello", "LogSource": "stdout", "TimeGenerated": "2023-11-14T22:13:20Z", "PodUid": "uid-1", "RestartCount": "2"}}},
+		{"v1 schema", false, false,
+			[]map[string]string{record},
+			[]map[string]string{{"LogEntry": "hello", "LogEntrySource": "stdout", "LogEntryTimeStamp": "2023-11-14T22:13:20Z", "SourceSystem": "Containers",
+				"Id": "0123456789abcdef", "Image": "nginx", "Name": "web", "TimeOfCommand": "2023-11-14T22:13:25Z", "Computer": "node-1"}}},
+		{"fluent-bit 2.1 entries", true, true,
+			[]map[string]string{record},
+			[]map[string]string{{"Computer": "node-1", "ContainerId": "0123456789abcdef", "ContainerName": "web", "PodName": "pod-1", "PodNamespace": "kube-apps",
+				"LogMessage": "hello", "LogSource": "stdout", "TimeGenerated": "2023-11-14T22:13:20Z", "PodUid": "uid-1", "RestartCount": "2"}}},
+		{"ignored namespace and unknown container are filtered", true, false,
+			[]map[string]string{
+				{"log": "ignored", "stream": "stderr", "filepath": passthroughTestFilepath},
+				{"log": "no container", "stream": "stdout", "filepath": "/var/log/containers/unknown"}},
+			[]map[string]string{}},
+	}
+
+	Computer = "node-1"
+	StdoutIgnoreNsSet = map[string]bool{}
+	StderrIgnoreNsSet = map[string]bool{"kube-apps": true}
+	metadata := containerLogMetadata{
+		imageIDs:      map[string]string{"0123456789abcdef": "nginx"},
+		names:         map[string]string{"0123456789abcdef": "web"},
+		podUIDs:       map[string]string{"0123456789abcdef": "uid-1"},
+		restartCounts: map[string]string{"0123456789abcdef": "2"},
+	}
+	start := time.Date(2023, 11, 14, 22, 13, 25, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ContainerLogSchemaV2 = tt.schemaV2
+			defer func() { ContainerLogSchemaV2 = false }()
+			var chunk []byte
+			for _, record := range tt.records {
+				chunk = appendChunkEntry(chunk, tt.withMetadata, record)
+			}
+			batch, err := buildMdsdForwardFromChunk("mdsd.tag", chunk, start, metadata)
+			if err != nil {
+				t.Fatalf("buildMdsdForwardFromChunk() error = %v", err)
+			}
+			tag, records := readForwardRecords(t, batch.msgpBytes)
+			if tag != "mdsd.tag" {
+				t.Errorf("tag = %s, want mdsd.tag", tag)
+			}
+			if batch.numRecords != len(tt.want) || !reflect.DeepEqual(records, tt.want) {
+				t.Errorf("records = %v (%d), want %v", records, batch.numRecords, tt.want)
+			}
+		})
+	}
+}
+
+func Test_buildMdsdForwardFromChunkInvalid(t *testing.T) {
+	type test_struct struct {
+		testname string
+		chunk    []byte
+	}
+
+	valid := appendChunkEntry(nil, false, map[string]string{"log": "hello"})
+	tests := []test_struct{
+		{"truncated entry", valid[:len(valid)-2]},
+		{"not an entry", msgp.AppendString(nil, "log")},
+		{"entry with 3 elements", msgp.AppendArrayHeader(nil, 3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if _, err := buildMdsdForwardFromChunk("mdsd.tag", tt.chunk, time.Now(), containerLogMetadata{}); err == nil {
+				t.Errorf("buildMdsdForwardFromChunk() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func Test_initializeMsgpackPassthrough(t *testing.T) {
+	os.Setenv(envMdsdMsgpackPassthrough, "true")
+	throughputInterval := ContainerLogThroughputInterval
+	defer func() {
+		os.Unsetenv(envMdsdMsgpackPassthrough)
+		ContainerLogsRouteV2, TeeRoute, TeeSink, MdsdMsgpackPassthrough = false, "", nil, false
+		ContainerLogThroughputInterval = throughputInterval
+	}()
+	ContainerLogsRouteV2, ContainerLogThroughputInterval = true, 0
+
+	initializeMsgpackPassthrough()
+	if !MdsdMsgpackPassthrough {
+		t.Errorf("the passthrough is not enabled on the %s route", ContainerLogsV2Route)
+	}
+	// the tee route is sent the decoded records
+	TeeRoute, TeeSink = ContainerLogsADXRoute, &testSink{name: teeSinkName}
+	initializeMsgpackPassthrough()
+	if MdsdMsgpackPassthrough {
+		t.Errorf("the passthrough is enabled with the tee route")
+	}
+}
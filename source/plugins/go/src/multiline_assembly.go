@@ -0,0 +1,280 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+// env variables of the assembly of the multiline container logs, i.e. stack traces
+const (
+	// regexes of the first line of a log entry, separated by ;. The lines which match none of them are appended to the
+	// entry of the previous line of the container. The assembly is off when not set
+	envMultilineStartPatterns = "AZMON_MULTILINE_START_PATTERNS"
+	// how long (seconds) the last entry of a container waits for its next lines before it is flushed
+	envMultilineFlushTimeoutSeconds = "AZMON_MULTILINE_FLUSH_TIMEOUT_SECONDS"
+	// max number of lines of an entry
+	envMultilineMaxLines = "AZMON_MULTILINE_MAX_LINES"
+)
+
+const (
+	defaultMultilineFlushTimeoutSeconds = 5
+	defaultMultilineMaxLines            = 500
+	// marks the entries which are complete, so they are not held again when they are flushed on their timeout
+	multilineAssembledKey = "azmon_multiline_assembled"
+)
+
+// multilineEntry an entry of a container being assembled
+type multilineEntry struct {
+	record map[interface{}]interface{}
+	log    []byte
+	lines  int
+	heldAt time.Time
+}
+
+// multilineAssembler merges the lines of the entries of the containers. The last entry of a container in a chunk is
+// held until its next lines or its flush timeout, since its lines can span chunks
+type multilineAssembler struct {
+	mu            sync.Mutex
+	startPatterns []*regexp.Regexp
+	flushTimeout  time.Duration
+	maxLines      int
+	// the held entries by log file and stream
+	pending map[string]*multilineEntry
+}
+
+// MultilineAssembler the assembler of the container logs, nil when the assembly is off
+var MultilineAssembler *multilineAssembler
+
+// MultilineFlushTicker to flush the held entries after their timeout
+var MultilineFlushTicker *time.Ticker
+
+// initializeMultilineAssembly reads the start patterns of the entries
+func initializeMultilineAssembly() {
+	MultilineAssembler = nil
+	value := strings.TrimSpace(os.Getenv(envMultilineStartPatterns))
+	if value == "" {
+		return
+	}
+	var startPatterns []*regexp.Regexp
+	for _, pattern := range strings.Split(value, ";") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			Log("Error::multiline::Invalid start pattern %s in %s, the pattern is ignored: %s", pattern, envMultilineStartPatterns, err.Error())
+			continue
+		}
+		startPatterns = append(startPatterns, compiled)
+	}
+	if len(startPatterns) == 0 {
+		Log("Error::multiline::No valid start pattern in %s, the multiline assembly is disabled", envMultilineStartPatterns)
+		return
+	}
+	flushTimeoutSeconds := defaultMultilineFlushTimeoutSeconds
+	if value := strings.TrimSpace(os.Getenv(envMultilineFlushTimeoutSeconds)); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			flushTimeoutSeconds = seconds
+		} else {
+			Log("Invalid value %s for %s, using the default of %d seconds", value, envMultilineFlushTimeoutSeconds, defaultMultilineFlushTimeoutSeconds)
+		}
+	}
+	maxLines := defaultMultilineMaxLines
+	if value := strings.TrimSpace(os.Getenv(envMultilineMaxLines)); value != "" {
+		if lines, err := strconv.Atoi(value); err == nil && lines > 0 {
+			maxLines = lines
+		} else {
+			Log("Invalid value %s for %s, using the default of %d lines", value, envMultilineMaxLines, defaultMultilineMaxLines)
+		}
+	}
+	MultilineAssembler = newMultilineAssembler(startPatterns, time.Second*time.Duration(flushTimeoutSeconds), maxLines)
+	Log("Assembling the multiline container logs with %d start patterns, flush timeout %d seconds, at most %d lines", len(startPatterns), flushTimeoutSeconds, maxLines)
+}
+
+func newMultilineAssembler(startPatterns []*regexp.Regexp, flushTimeout time.Duration, maxLines int) *multilineAssembler {
+	return &multilineAssembler{
+		startPatterns: startPatterns,
+		flushTimeout:  flushTimeout,
+		maxLines:      maxLines,
+		pending:       make(map[string]*multilineEntry),
+	}
+}
+
+// startMultilineFlush flushes the held entries once their timeout expires
+func startMultilineFlush() {
+	if MultilineAssembler == nil {
+		return
+	}
+	MultilineFlushTicker = time.NewTicker(MultilineAssembler.flushTimeout)
+	go func() {
+		for range MultilineFlushTicker.C {
+			flushExpiredMultilineEntries(time.Now())
+		}
+	}()
+}
+
+// flushExpiredMultilineEntries flushes the held entries whose timeout expires before now
+func flushExpiredMultilineEntries(now time.Time) {
+	records := MultilineAssembler.takeExpired(now)
+	if len(records) == 0 {
+		return
+	}
+	if retCode := flushContainerLogRecords(records, "", true); retCode != output.FLB_OK {
+		Log("Error::multiline::Unable to flush %d multiline entries after their timeout, they are dropped", len(records))
+	}
+}
+
+// flushHeldMultilineEntries flushes all the held entries when the plugin exits, since they are not in the chunks of
+// fluent-bit anymore
+func flushHeldMultilineEntries() {
+	if MultilineAssembler == nil {
+		return
+	}
+	flushExpiredMultilineEntries(time.Now().Add(MultilineAssembler.flushTimeout))
+}
+
+func (a *multilineAssembler) isStart(line []byte) bool {
+	for _, pattern := range a.startPatterns {
+		if pattern.Match(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// assemble merges the lines of the entries of the records, in place of their first line. Returns the records to send,
+// and the rollback to call when they are not sent, which restores the entries held before the call
+func (a *multilineAssembler) assemble(records []map[interface{}]interface{}, now time.Time) ([]map[interface{}]interface{}, func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	previous := make(map[string]*multilineEntry, len(a.pending))
+	for key, entry := range a.pending {
+		copied := *entry
+		previous[key] = &copied
+	}
+	rollback := func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.pending = previous
+	}
+
+	assembled := make([]map[interface{}]interface{}, 0, len(records))
+	// the entries of the containers in this chunk, by their index in assembled
+	current := make(map[string]int)
+	for _, record := range records {
+		if _, ok := record[multilineAssembledKey]; ok {
+			assembled = append(assembled, record)
+			continue
+		}
+		normalizeRuntimeLogRecord(record)
+		line, ok := record["log"].([]byte)
+		if !ok {
+			assembled = append(assembled, record)
+			continue
+		}
+		key := ToString(record["filepath"]) + "|" + ToString(record["stream"])
+		if !a.isStart(line) {
+			if index, ok := current[key]; ok && a.append(assembled[index], line) {
+				continue
+			}
+			if entry, ok := a.pending[key]; ok && entry.lines < a.maxLines {
+				delete(a.pending, key)
+				entry.log = joinMultilineLog(entry.log, line)
+				entry.lines++
+				assembled = append(assembled, entry.toRecord())
+				current[key] = len(assembled) - 1
+				continue
+			}
+		}
+		// a new entry flushes the held entry of the container before it
+		if entry, ok := a.pending[key]; ok {
+			delete(a.pending, key)
+			assembled = append(assembled, entry.toRecord())
+		}
+		assembled = append(assembled, newMultilineRecord(record, line, 1))
+		current[key] = len(assembled) - 1
+	}
+
+	// the last entry of each container may go on in the next chunk
+	held := make(map[int]bool, len(current))
+	for key, index := range current {
+		record := assembled[index]
+		if lines, _ := record[multilineAssembledKey].(int); lines >= a.maxLines {
+			continue
+		}
+		a.pending[key] = &multilineEntry{record: record, log: record["log"].([]byte), lines: record[multilineAssembledKey].(int), heldAt: now}
+		held[index] = true
+	}
+	// the held entries of the other containers are flushed with this chunk once their timeout expires
+	for key, entry := range a.pending {
+		if _, ok := current[key]; !ok && now.Sub(entry.heldAt) >= a.flushTimeout {
+			delete(a.pending, key)
+			assembled = append(assembled, entry.toRecord())
+		}
+	}
+
+	sent := make([]map[interface{}]interface{}, 0, len(assembled))
+	for index, record := range assembled {
+		if !held[index] {
+			sent = append(sent, record)
+		}
+	}
+	return sent, rollback
+}
+
+// append appends a line to an entry of the chunk, when it has less than the max lines
+func (a *multilineAssembler) append(record map[interface{}]interface{}, line []byte) bool {
+	lines, _ := record[multilineAssembledKey].(int)
+	if lines >= a.maxLines {
+		return false
+	}
+	record["log"] = joinMultilineLog(record["log"].([]byte), line)
+	record[multilineAssembledKey] = lines + 1
+	return true
+}
+
+// takeExpired removes and returns the held entries whose timeout expired
+func (a *multilineAssembler) takeExpired(now time.Time) []map[interface{}]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var records []map[interface{}]interface{}
+	for key, entry := range a.pending {
+		if now.Sub(entry.heldAt) >= a.flushTimeout {
+			delete(a.pending, key)
+			records = append(records, entry.toRecord())
+		}
+	}
+	return records
+}
+
+// toRecord the record of a complete entry
+func (e *multilineEntry) toRecord() map[interface{}]interface{} {
+	return newMultilineRecord(e.record, e.log, e.lines)
+}
+
+// newMultilineRecord copies the record of the first line of an entry with the log of the entry
+func newMultilineRecord(record map[interface{}]interface{}, log []byte, lines int) map[interface{}]interface{} {
+	copied := make(map[interface{}]interface{}, len(record)+1)
+	for key, value := range record {
+		copied[key] = value
+	}
+	copied["log"] = append([]byte{}, log...)
+	copied[multilineAssembledKey] = lines
+	return copied
+}
+
+// joinMultilineLog appends a line to the log of an entry, on a new line
+func joinMultilineLog(log []byte, line []byte) []byte {
+	joined := make([]byte, 0, len(log)+len(line)+1)
+	joined = append(joined, log...)
+	if len(log) > 0 && log[len(log)-1] != '\n' {
+		joined = append(joined, '\n')
+	}
+	return append(joined, line...)
+}
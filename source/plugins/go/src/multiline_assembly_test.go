@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_multilineAssemblerAssemble(t *testing.T) {
+	type test_struct struct {
+		testname string
+		// the log lines of each chunk, by container
+		chunks [][][2]string
+		// the logs sent for each chunk
+		output [][]string
+		// the logs of the entries held after the last chunk
+		held []string
+	}
+
+	tests := []test_struct{
+		{
+			"stack trace in a chunk",
+			[][][2]string{{{"a", "2021-06-01 ERROR boom\n"}, {"a", "\tat Main.run\n"}, {"a", "\tat Main.main\n"}, {"a", "2021-06-01 INFO ok\n"}}},
+			[][]string{{"2021-06-01 ERROR boom\n\tat Main.run\n\tat Main.main\n"}},
+			[]string{"2021-06-01 INFO ok\n"},
+		},
+		{
+			"stack trace across chunks",
+			[][][2]string{{{"a", "2021-06-01 ERROR boom\n"}, {"a", "\tat Main.run\n"}}, {{"a", "\tat Main.main\n"}, {"a", "2021-06-01 INFO ok\n"}}},
+			[][]string{{}, {"2021-06-01 ERROR boom\n\tat Main.run\n\tat Main.main\n"}},
+			[]string{"2021-06-01 INFO ok\n"},
+		},
+		{
+			"containers apart",
+			[][][2]string{{{"a", "2021-06-01 ERROR a\n"}, {"b", "2021-06-01 ERROR b\n"}, {"a", "\tat A\n"}, {"b", "\tat B\n"}, {"a", "2021-06-01 INFO a\n"}}},
+			[][]string{{"2021-06-01 ERROR a\n\tat A\n"}},
+			[]string{"2021-06-01 ERROR b\n\tat B\n", "2021-06-01 INFO a\n"},
+		},
+		{
+			"continuation without an entry",
+			[][][2]string{{{"a", "\tat Main.run\n"}, {"a", "2021-06-01 INFO ok\n"}}},
+			[][]string{{"\tat Main.run\n"}},
+			[]string{"2021-06-01 INFO ok\n"},
+		},
+		{
+			"max lines",
+			[][][2]string{{{"a", "2021-06-01 ERROR boom\n"}, {"a", "1\n"}, {"a", "2\n"}, {"a", "3\n"}, {"a", "4\n"}}},
+			[][]string{{"2021-06-01 ERROR boom\n1\n2\n"}},
+			[]string{"3\n4\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			assembler := newMultilineAssembler([]*regexp.Regexp{regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)}, time.Minute, 3)
+			now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+			for i, chunk := range tt.chunks {
+				var records []map[interface{}]interface{}
+				for _, line := range chunk {
+					records = append(records, map[interface{}]interface{}{"filepath": []byte(line[0]), "stream": []byte("stdout"), "log": []byte(line[1])})
+				}
+				sent, _ := assembler.assemble(records, now)
+				if got := multilineLogs(sent); !reflect.DeepEqual(got, tt.output[i]) {
+					t.Errorf("logs sent for chunk %d = %q, want %q", i, got, tt.output[i])
+				}
+			}
+			held := multilineLogs(assembler.takeExpired(now.Add(time.Minute)))
+			if !sameStrings(held, tt.held) {
+				t.Errorf("held logs = %q, want %q", held, tt.held)
+			}
+		})
+	}
+}
+
+func Test_multilineAssemblerRollback(t *testing.T) {
+	assembler := newMultilineAssembler([]*regexp.Regexp{regexp.MustCompile(`^ERROR`)}, time.Minute, 10)
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	first := []map[interface{}]interface{}{{"filepath": []byte("a"), "stream": []byte("stdout"), "log": []byte("ERROR boom\n")}}
+	second := []map[interface{}]interface{}{{"filepath": []byte("a"), "stream": []byte("stdout"), "log": []byte("\tat Main.run\n")}}
+	assembler.assemble(first, now)
+	_, rollback := assembler.assemble(second, now)
+	rollback()
+	sent, _ := assembler.assemble(second, now)
+	if len(sent) != 0 {
+		t.Errorf("logs sent after the rollback = %q, want none", multilineLogs(sent))
+	}
+	if held := multilineLogs(assembler.takeExpired(now.Add(time.Minute))); !reflect.DeepEqual(held, []string{"ERROR boom\n\tat Main.run\n"}) {
+		t.Errorf("held logs after the rollback = %q, want the entry with its line once", held)
+	}
+}
+
+func multilineLogs(records []map[interface{}]interface{}) []string {
+	logs := []string{}
+	for _, record := range records {
+		logs = append(logs, ToString(record["log"]))
+	}
+	return logs
+}
+
+func sameStrings(a []string, b []string) bool {
+	counts := make(map[string]int)
+	for _, value := range a {
+		counts[value]++
+	}
+	for _, value := range b {
+		counts[value]--
+	}
+	for _, count := range counts {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_isNamespaceExcluded(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		exclude   map[string]bool
+		include   map[string]bool
+		namespace string
+		output    bool
+	}
+
+	tests := []test_struct{
+		{"no lists", map[string]bool{}, nil, "default", false},
+		{"excluded", map[string]bool{"kube-system": true}, nil, "kube-system", true},
+		{"not excluded", map[string]bool{"kube-system": true}, nil, "default", false},
+		{"included", map[string]bool{}, map[string]bool{"prod": true}, "prod", false},
+		{"not included", map[string]bool{}, map[string]bool{"prod": true}, "default", true},
+		{"included and excluded", map[string]bool{"prod": true}, map[string]bool{"prod": true}, "prod", true},
+		{"empty include list", map[string]bool{}, map[string]bool{}, "default", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := isNamespaceExcluded(tt.exclude, tt.include, tt.namespace); got != tt.output {
+				t.Errorf("isNamespaceExcluded() = %v, want %v", got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_populateIncludedNamespaces(t *testing.T) {
+	type test_struct struct {
+		testname    string
+		collectLogs string
+		includeList string
+		exclude     map[string]bool
+		output      map[string]bool
+	}
+
+	tests := []test_struct{
+		{"no include list", "true", "", map[string]bool{}, nil},
+		{"blank include list", "true", " ", map[string]bool{}, nil},
+		{"collection disabled", "false", "prod", map[string]bool{}, nil},
+		{"include list", "true", "prod, staging,", map[string]bool{}, map[string]bool{"prod": true, "staging": true}},
+		{"excluded namespace", "true", "prod,kube-system", map[string]bool{"kube-system": true}, map[string]bool{"prod": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got := populateIncludedNamespaces("stdout", tt.collectLogs, tt.includeList, tt.exclude)
+			if (got == nil) != (tt.output == nil) || len(got) != len(tt.output) {
+				t.Fatalf("populateIncludedNamespaces() = %v, want %v", got, tt.output)
+			}
+			for ns := range tt.output {
+				if !got[ns] {
+					t.Errorf("populateIncludedNamespaces() = %v, want %v", got, tt.output)
+				}
+			}
+		})
+	}
+}
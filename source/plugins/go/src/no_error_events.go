@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variable of the interval (hours) at which a node sends the "No errors" KubeMonAgentEvents record, 1 (every
+// flush) by default. The record is not sent when 0
+const envNoErrorEventIntervalHours = "AZMON_KUBEMON_NO_ERROR_EVENT_INTERVAL_HOURS"
+
+// env variable to send the "No errors" record from the replicaset only, instead of from every node
+const envNoErrorEventReplicaSetOnly = "AZMON_KUBEMON_NO_ERROR_EVENT_REPLICASET_ONLY"
+
+const defaultNoErrorEventIntervalHours = 1
+
+// the flushes run on a ticker, a record due a little after the flush is sent on that flush rather than on the next one
+const noErrorEventIntervalSlack = 5 * time.Minute
+
+var (
+	// NoErrorEventInterval the interval at which the "No errors" record is sent, 0 when it is not sent
+	NoErrorEventInterval time.Duration
+	// NoErrorEventReplicaSetOnly when true, only the replicaset sends the "No errors" record
+	NoErrorEventReplicaSetOnly bool
+
+	noErrorEventMutex    = &sync.Mutex{}
+	lastNoErrorEventTime time.Time
+)
+
+// initializeNoErrorEvents reads the interval of the "No errors" record and whether this agent sends it
+func initializeNoErrorEvents(isReplicaSet bool) {
+	noErrorEventMutex.Lock()
+	lastNoErrorEventTime = time.Time{}
+	noErrorEventMutex.Unlock()
+
+	intervalHours := defaultNoErrorEventIntervalHours
+	if value := strings.TrimSpace(os.Getenv(envNoErrorEventIntervalHours)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			intervalHours = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d hours", value, envNoErrorEventIntervalHours, defaultNoErrorEventIntervalHours)
+		}
+	}
+	NoErrorEventInterval = time.Hour * time.Duration(intervalHours)
+
+	NoErrorEventReplicaSetOnly = strings.EqualFold(strings.TrimSpace(os.Getenv(envNoErrorEventReplicaSetOnly)), "true")
+	if NoErrorEventReplicaSetOnly == true && isReplicaSet == false {
+		NoErrorEventInterval = 0
+	}
+
+	if NoErrorEventInterval == 0 {
+		Log("The \"No errors\" KubeMonAgentEvents record is not sent by this agent")
+	} else {
+		Log("The \"No errors\" KubeMonAgentEvents record is sent every %s", NoErrorEventInterval)
+	}
+}
+
+// shouldSendNoErrorEvent whether the "No errors" record is sent at the time of the flush, and if so records the time
+// it was sent at
+func shouldSendNoErrorEvent(now time.Time) bool {
+	if NoErrorEventInterval == 0 {
+		return false
+	}
+	noErrorEventMutex.Lock()
+	defer noErrorEventMutex.Unlock()
+	if !lastNoErrorEventTime.IsZero() && now.Sub(lastNoErrorEventTime) < NoErrorEventInterval-noErrorEventIntervalSlack {
+		return false
+	}
+	lastNoErrorEventTime = now
+	return true
+}
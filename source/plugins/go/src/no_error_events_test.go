@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_shouldSendNoErrorEvent(t *testing.T) {
+	type test_struct struct {
+		testname       string
+		intervalHours  string
+		replicaSetOnly string
+		isReplicaSet   bool
+		flushes        []time.Duration
+		output         []bool
+	}
+
+	tests := []test_struct{
+		{"every flush by default", "", "", false, []time.Duration{0, time.Hour, 2 * time.Hour}, []bool{true, true, true}},
+		{"ticker jitter", "", "", false, []time.Duration{0, 59 * time.Minute}, []bool{true, true}},
+		{"disabled", "0", "", false, []time.Duration{0, time.Hour}, []bool{false, false}},
+		{"every 6 hours", "6", "", false, []time.Duration{0, time.Hour, 5 * time.Hour, 6 * time.Hour, 7 * time.Hour}, []bool{true, false, false, true, false}},
+		{"flush requested between ticks", "", "", false, []time.Duration{0, 10 * time.Minute, time.Hour}, []bool{true, false, true}},
+		{"invalid interval", "-1", "", false, []time.Duration{0, time.Hour}, []bool{true, true}},
+		{"replicaset only on a node", "", "true", false, []time.Duration{0, time.Hour}, []bool{false, false}},
+		{"replicaset only on the replicaset", "24", "true", true, []time.Duration{0, time.Hour, 24 * time.Hour}, []bool{true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			os.Setenv(envNoErrorEventIntervalHours, tt.intervalHours)
+			os.Setenv(envNoErrorEventReplicaSetOnly, tt.replicaSetOnly)
+			defer os.Unsetenv(envNoErrorEventIntervalHours)
+			defer os.Unsetenv(envNoErrorEventReplicaSetOnly)
+			initializeNoErrorEvents(tt.isReplicaSet)
+			start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+			for i, flush := range tt.flushes {
+				if got := shouldSendNoErrorEvent(start.Add(flush)); got != tt.output[i] {
+					t.Errorf("shouldSendNoErrorEvent at %s = %v, want %v", flush, got, tt.output[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// env variables of the chunking of the ODS payloads
+const (
+	// max size (KB) of a payload posted to ODS, the items of a larger payload are posted in chunks
+	envODSMaxPayloadKB = "AZMON_ODS_MAX_PAYLOAD_KB"
+	// number of times a failed chunk is posted again within the flush, before the flush is retried
+	envODSPayloadChunkRetries = "AZMON_ODS_PAYLOAD_CHUNK_RETRIES"
+)
+
+const (
+	// under the 30 MB limit of an ODS post, for the headers and the compression overhead
+	defaultODSMaxPayloadKB        = 25 * 1024
+	defaultODSPayloadChunkRetries = 2
+)
+
+var (
+	// ODSMaxPayloadBytes the max size of a payload posted to ODS
+	ODSMaxPayloadBytes = defaultODSMaxPayloadKB * 1024
+	// ODSPayloadChunkRetries the number of times a failed chunk is posted again within the flush
+	ODSPayloadChunkRetries = defaultODSPayloadChunkRetries
+	// ODSPayloadChunkRetryBackoff the base delay of the backoff between the posts of a failed chunk
+	ODSPayloadChunkRetryBackoff = 500 * time.Millisecond
+)
+
+// initializeODSPayloadChunks reads the max size of the ODS payloads
+func initializeODSPayloadChunks() {
+	ODSMaxPayloadBytes = defaultODSMaxPayloadKB * 1024
+	if value := strings.TrimSpace(os.Getenv(envODSMaxPayloadKB)); value != "" {
+		if kb, err := strconv.Atoi(value); err == nil && kb > 0 {
+			ODSMaxPayloadBytes = kb * 1024
+		} else {
+			Log("Invalid value %s for %s, using the default %d KB", value, envODSMaxPayloadKB, defaultODSMaxPayloadKB)
+		}
+	}
+	ODSPayloadChunkRetries = defaultODSPayloadChunkRetries
+	if value := strings.TrimSpace(os.Getenv(envODSPayloadChunkRetries)); value != "" {
+		if retries, err := strconv.Atoi(value); err == nil && retries >= 0 {
+			ODSPayloadChunkRetries = retries
+		} else {
+			Log("Invalid value %s for %s, using the default %d", value, envODSPayloadChunkRetries, defaultODSPayloadChunkRetries)
+		}
+	}
+	Log("ODS payloads are posted in chunks of at most %d KB", ODSMaxPayloadBytes/1024)
+}
+
+// sendODSPayloadChunks posts the items [0, count) to ODS. The payload of a range of items is marshalled by marshal, and
+// halved until it is under the max payload size. The chunks are posted in order by post, a failed chunk being posted
+// again within the flush. The chunks posted before a failure are posted again when the flush is retried, unless post
+// skips them with sendUnsentPayload. Returns the error of the chunk which still fails, or of the marshalling
+func sendODSPayloadChunks(ctx context.Context, caller string, count int, marshal func(start int, end int) ([]byte, error), post func(start int, end int, payload []byte) error) error {
+	return sendPayloadChunks(ctx, caller, count, ODSMaxPayloadBytes, marshal, post)
+}
+
+// sendPayloadChunks posts the items [0, count) like sendODSPayloadChunks, in chunks under the max payload size of the
+// destination
+func sendPayloadChunks(ctx context.Context, caller string, count int, maxPayloadBytes int, marshal func(start int, end int) ([]byte, error), post func(start int, end int, payload []byte) error) error {
+	if count == 0 {
+		return nil
+	}
+	return sendPayloadRange(ctx, caller, 0, count, true, maxPayloadBytes, marshal, post)
+}
+
+func sendPayloadRange(ctx context.Context, caller string, start int, end int, whole bool, maxPayloadBytes int, marshal func(start int, end int) ([]byte, error), post func(start int, end int, payload []byte) error) error {
+	payload, err := marshal(start, end)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxPayloadBytes {
+		if end-start > 1 {
+			ContainerLogTelemetryMutex.Lock()
+			ODSPayloadSplitCount += 1
+			ContainerLogTelemetryMutex.Unlock()
+			middle := start + (end-start)/2
+			if err := sendPayloadRange(ctx, caller, start, middle, false, maxPayloadBytes, marshal, post); err != nil {
+				return err
+			}
+			return sendPayloadRange(ctx, caller, middle, end, false, maxPayloadBytes, marshal, post)
+		}
+		Log("%s::Warning::the payload of a single item is %d bytes, over the max payload size of %d bytes", caller, len(payload), maxPayloadBytes)
+	}
+	// a payload which was not split is posted once, the flush is retried when it fails
+	if whole {
+		return post(start, end, payload)
+	}
+	for retry := 0; ; retry++ {
+		err = post(start, end, payload)
+		if err == nil || retry >= ODSPayloadChunkRetries || isThrottled(err) {
+			return err
+		}
+		Log("%s::Warning::posting the chunk of items %d to %d again after: %s", caller, start, end, err.Error())
+		select {
+		case <-time.After(jitteredBackoff(retry, ODSPayloadChunkRetryBackoff)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// isThrottled whether ODS refused the post for throttling, the route backs off instead of posting again
+func isThrottled(err error) bool {
+	var statusErr *sinkStatusError
+	return errors.As(err, &statusErr) && statusErr.statusCode == 429
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_sendODSPayloadChunks(t *testing.T) {
+	type test_struct struct {
+		testname string
+		count    int
+		// the failures of the posts of the chunks by their first item
+		failures map[int]int
+		isError  bool
+		// the ranges of items posted successfully
+		posted [][2]int
+	}
+
+	tests := []test_struct{
+		{"under the max size", 4, map[int]int{}, false, [][2]int{{0, 4}}},
+		{"split in chunks", 10, map[int]int{}, false, [][2]int{{0, 5}, {5, 10}}},
+		{"split twice", 16, map[int]int{}, false, [][2]int{{0, 4}, {4, 8}, {8, 12}, {12, 16}}},
+		{"chunk posted again", 10, map[int]int{5: 2}, false, [][2]int{{0, 5}, {5, 10}}},
+		{"chunk still failing", 10, map[int]int{5: 3}, true, [][2]int{{0, 5}}},
+		{"failed payload not split", 4, map[int]int{0: 1}, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			// 10 bytes per item, at most 5 items per payload
+			ODSMaxPayloadBytes, ODSPayloadChunkRetries, ODSPayloadChunkRetryBackoff = 50, 2, time.Millisecond
+			defer func() { ODSMaxPayloadBytes = defaultODSMaxPayloadKB * 1024 }()
+			marshal := func(start int, end int) ([]byte, error) {
+				return []byte(strings.Repeat("0123456789", end-start)), nil
+			}
+			var posted [][2]int
+			post := func(start int, end int, payload []byte) error {
+				if tt.failures[start] > 0 {
+					tt.failures[start]--
+					return errors.New("ingestion failed")
+				}
+				posted = append(posted, [2]int{start, end})
+				return nil
+			}
+			err := sendODSPayloadChunks(context.Background(), "test", tt.count, marshal, post)
+			if (err != nil) != tt.isError {
+				t.Errorf("sendODSPayloadChunks() error = %v, want error %v", err, tt.isError)
+			}
+			if !reflect.DeepEqual(posted, tt.posted) {
+				t.Errorf("posted %v, want %v", posted, tt.posted)
+			}
+		})
+	}
+}
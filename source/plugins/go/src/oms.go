@@ -1,31 +1,30 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/fluent/fluent-bit-go/output"
-	"github.com/google/uuid"
-	"github.com/tinylib/msgp/msgp"
 
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+	"Docker-Provider/source/plugins/go/src/containermetadata"
 	"Docker-Provider/source/plugins/go/src/extension"
 
 	"github.com/Azure/azure-kusto-go/kusto/ingest"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -105,12 +104,39 @@ const ContainerLogsADXRoute = "adx"
 //fallback option v1 route i.e. ODS direct if required in any case
 const ContainerLogsV1Route = "v1"
 
+//geneva route i.e. flush to the local GenevaMonitoringAgent (1P clusters which must not use public LA ingestion)
+const ContainerLogsGenevaRoute = "geneva"
+
+//dcr route i.e. post to a DCR thru the Logs Ingestion API, authenticated with AAD instead of the agent certificates
+const ContainerLogsDCRRoute = "dcr"
+
+//kafka route i.e. publish the ContainerLogV2 records to a Kafka topic, e.g. the Kafka endpoint of Event Hubs
+const ContainerLogsKafkaRoute = "kafka"
+
+//eventhubs route i.e. publish the container log records to an event hub over AMQP, authenticated with the managed identity
+const ContainerLogsEventHubsRoute = "eventhubs"
+
+//otlp route i.e. export the container log records and the telegraf metrics to an OpenTelemetry collector over OTLP/gRPC
+const ContainerLogsOTLPRoute = "otlp"
+
+//Default fluent socket of the local GenevaMonitoringAgent, can be overriden through plugin configuration
+const DefaultGenevaFluentSocketPath = "/var/run/mdsd-geneva/default_fluent.socket"
+
+//Event names in geneva (distinct per data type)
+const GenevaContainerLogEventName = "ContainerInsightsContainerLog"
+const GenevaContainerLogV2EventName = "ContainerInsightsContainerLogV2"
+const GenevaKubeMonAgentEventsEventName = "ContainerInsightsKubeMonAgentEvents"
+const GenevaInsightsMetricsEventName = "ContainerInsightsInsightsMetrics"
+
 //container logs schema (v2=ContainerLogsV2 table in LA, anything else ContainerLogs table in LA. This is applicable only if Container logs route is NOT ADX)
 const ContainerLogV2SchemaVersion = "v2"
 
 //env variable for AAD MSI Auth mode
 const AADMSIAuthMode = "AAD_MSI_AUTH_MODE"
 
+// env variable to authenticate the ODS posts with the federated workload identity token instead of the agent certificates
+const WorkloadIdentityAuthMode = "AZMON_WORKLOAD_IDENTITY_AUTH_MODE"
+
 // Tag prefix of mdsd output streamid for AMA in MSI auth mode
 const MdsdOutputStreamIdTagPrefix = "dcr-"
 
@@ -125,8 +151,6 @@ var (
 	PluginConfiguration map[string]string
 	// HTTPClient for making POST requests to OMSEndpoint
 	HTTPClient http.Client
-	// Client for MDSD msgp Unix socket
-	MdsdMsgpUnixSocketClient net.Conn
 	// Client for MDSD msgp Unix socket for KubeMon Agent events
 	MdsdKubeMonMsgpUnixSocketClient net.Conn
 	// Client for MDSD msgp Unix socket for Insights Metrics
@@ -151,7 +175,7 @@ var (
 	skipKubeMonEventsFlush bool
 	// enrich container logs (when true this will add the fields - timeofcommand, containername & containerimage)
 	enrichContainerLogs bool
-	// container runtime engine configured on the kubelet
+	// container runtime engine of the node, detected from its socket and corrected from the pod container ids
 	containerRuntime string
 	// Proxy endpoint in format http(s)://<user>:<pwd>@<proxyserver>:<port>
 	ProxyEndpoint string
@@ -159,6 +183,10 @@ var (
 	ContainerLogsRouteV2 bool
 	// container log route for routing thru ADX
 	ContainerLogsRouteADX bool
+	// container log route for routing thru the local geneva agent (uses the same msgpack forward path as the oneagent route)
+	ContainerLogsRouteGeneva bool
+	// fluent socket of the local geneva agent
+	GenevaFluentSocketPath string
 	// container log schema (applicable only for non-ADX route)
 	ContainerLogSchemaV2 bool
 	//ADX Cluster URI
@@ -169,6 +197,8 @@ var (
 	AdxTenantID string
 	//ADX client secret
 	AdxClientSecret string
+	// ADX client authenticates with the federated workload identity token instead of the client secret
+	AdxWorkloadIdentity bool
 	//ADX destination database name, default is DefaultAdxDatabaseName, can be overridden in configuration
 	AdxDatabaseName string
 	// container log or container log v2 tag name for oneagent route
@@ -183,6 +213,8 @@ var (
 	ContainerType string
 	// flag to check whether LA AAD MSI Auth Enabled or not
 	IsAADMSIAuthMode bool
+	// flag to check whether the ODS posts are authenticated with the workload identity token
+	IsWorkloadIdentityAuthMode bool
 )
 
 var (
@@ -190,12 +222,20 @@ var (
 	ImageIDMap map[string]string
 	// NameIDMap caches the container it to Name mapping
 	NameIDMap map[string]string
+	// PodUIDMap caches the container id to pod uid mapping
+	PodUIDMap map[string]string
+	// RestartCountMap caches the container id to container restart count mapping
+	RestartCountMap map[string]string
 	// StdoutIgnoreNamespaceSet set of  excluded K8S namespaces for stdout logs
 	StdoutIgnoreNsSet map[string]bool
 	// StderrIgnoreNamespaceSet set of  excluded K8S namespaces for stderr logs
 	StderrIgnoreNsSet map[string]bool
+	// StdoutIncludeNsSet set of included K8S namespaces for stdout logs, all the namespaces are included when nil
+	StdoutIncludeNsSet map[string]bool
+	// StderrIncludeNsSet set of included K8S namespaces for stderr logs, all the namespaces are included when nil
+	StderrIncludeNsSet map[string]bool
 	// DataUpdateMutex read and write mutex access to the container id set
-	DataUpdateMutex = &sync.Mutex{}
+	DataUpdateMutex = &sync.RWMutex{}
 	// ContainerLogTelemetryMutex read and write mutex access to the Container Log Telemetry
 	ContainerLogTelemetryMutex = &sync.Mutex{}
 	// ClientSet for querying KubeAPIs
@@ -208,10 +248,6 @@ var (
 	EventHashUpdateMutex = &sync.Mutex{}
 	// parent context used by ADX uploader
 	ParentContext = context.Background()
-	// IngestionAuthTokenUpdateMutex read and write mutex access for ODSIngestionAuthToken
-	IngestionAuthTokenUpdateMutex = &sync.Mutex{}
-	// ODSIngestionAuthToken for windows agent AAD MSI Auth
-	ODSIngestionAuthToken string
 )
 
 var (
@@ -219,15 +255,15 @@ var (
 	ContainerImageNameRefreshTicker *time.Ticker
 	// KubeMonAgentConfigEventsSendTicker to send config events every hour
 	KubeMonAgentConfigEventsSendTicker *time.Ticker
-	// IngestionAuthTokenRefreshTicker to refresh ingestion token
-	IngestionAuthTokenRefreshTicker *time.Ticker
 )
 
 var (
 	// FLBLogger stream
 	FLBLogger = createLogger()
+	// PluginLogger writes the lines at or over the level of the runtime log, as printf lines or as json
+	PluginLogger = newPluginLogger(FLBLogger)
 	// Log wrapper function
-	Log = FLBLogger.Printf
+	Log = trackPluginErrors(PluginLogger.Printf)
 )
 
 var (
@@ -247,6 +283,8 @@ type DataItemLAv1 struct {
 	Name                  string `json:"Name"`
 	SourceSystem          string `json:"SourceSystem"`
 	Computer              string `json:"Computer"`
+	ContainerLabels       string `json:"ContainerLabels,omitempty"`
+	LogLevel              string `json:"LogLevel,omitempty"`
 }
 
 // DataItemLAv2 == ContainerLogV2 table in LA
@@ -261,6 +299,10 @@ type DataItemLAv2 struct {
 	LogMessage            string `json:"LogMessage"`
 	LogSource             string `json:"LogSource"`
 	//PodLabels			  string `json:"PodLabels"`
+	PodUid                string `json:"PodUid,omitempty"`
+	RestartCount          string `json:"RestartCount,omitempty"`
+	ContainerLabels       string `json:"ContainerLabels,omitempty"`
+	LogLevel              string `json:"LogLevel,omitempty"`
 }
 
 // DataItemADX == ContainerLogV2 table in ADX
@@ -275,6 +317,12 @@ type DataItemADX struct {
 	LogSource             string `json:"LogSource"`
 	//PodLabels			  string `json:"PodLabels"`
 	AzureResourceId       string `json:"AzureResourceId"`
+	PodUid                string `json:"PodUid,omitempty"`
+	RestartCount          string `json:"RestartCount,omitempty"`
+	ContainerLabels       string `json:"ContainerLabels,omitempty"`
+	LogLevel              string `json:"LogLevel,omitempty"`
+	// the fields of the json log line promoted to columns
+	PromotedFields        map[string]string `json:"-"`
 }
 
 // telegraf metric DataItem represents the object corresponding to the json that is sent by fluentbit tail plugin
@@ -315,13 +363,16 @@ type ContainerLogBlobLAv2 struct {
 type MsgPackEntry struct {
 	Time   int64             `msg:"time"`
 	Record map[string]string `msg:"record"`
+	// written with the time as [time, metadata] when set
+	Metadata map[string]string `msg:"metadata"`
 }
 
 //MsgPackForward represents a series of messagepack events in Forward Mode
 type MsgPackForward struct {
 	Tag     string         `msg:"tag"`
 	Entries []MsgPackEntry `msg:"entries"`
-	//Option  interface{}  //intentionally commented out as we do not have any optional keys
+	// nil for the plain forward mode messages
+	Option *MsgPackForwardOption `msg:"option"`
 }
 
 // Config Error message to be sent to Log Analytics
@@ -342,6 +393,30 @@ type KubeMonAgentEventTags struct {
 	FirstOccurrence string
 	LastOccurrence  string
 	Count           int
+	// the details of the prometheus scrape errors
+	ScrapeTarget    string `json:",omitempty"`
+	ScrapeJob       string `json:",omitempty"`
+	ScrapeNamespace string `json:",omitempty"`
+	ErrorClass      string `json:",omitempty"`
+	// the location of the setting causing the config errors
+	ConfigMapKey  string `json:",omitempty"`
+	ConfigSection string `json:",omitempty"`
+	ConfigValue   string `json:",omitempty"`
+	// the data type of the sends throttled by the workspace, and the time they were paused
+	DataType         string `json:",omitempty"`
+	ThrottledSeconds int    `json:",omitempty"`
+	// the telegraf namespace and the tag over the limit of distinct values
+	MetricNamespace string `json:",omitempty"`
+	TagKey          string `json:",omitempty"`
+	// the first distinct errors of the failed sends to mdsd and ADX
+	ErrorSamples []string `json:",omitempty"`
+	// the health of the agent in the heartbeats
+	AgentVersion        string `json:",omitempty"`
+	Route               string `json:",omitempty"`
+	MDSDConnectivity    string `json:",omitempty"`
+	ADXConnectivity     string `json:",omitempty"`
+	RecordsPerSecond    string `json:",omitempty"`
+	LastSuccessfulFlush string `json:",omitempty"`
 }
 
 type KubeMonAgentEventBlob struct {
@@ -401,30 +476,34 @@ func createLogger() *log.Logger {
 
 	logger := log.New(logfile, "", 0)
 
-	logger.SetOutput(&lumberjack.Logger{
+	// the rotation of the plugin conf is applied once the conf is read
+	PluginLogRotation = &lumberjack.Logger{
 		Filename:   logPath,
-		MaxSize:    10, //megabytes
-		MaxBackups: 1,
-		MaxAge:     28,   //days
-		Compress:   true, // false by default
-	})
+		MaxSize:    defaultPluginLogMaxSizeMB, //megabytes
+		MaxBackups: defaultPluginLogMaxBackups,
+		MaxAge:     defaultPluginLogMaxAgeDays, //days
+		Compress:   true,                       // false by default
+	}
+	logger.SetOutput(PluginLogRotation)
 
 	logger.SetFlags(log.Ltime | log.Lshortfile | log.LstdFlags)
 	return logger
 }
 
 func updateContainerImageNameMaps() {
-	for ; true; <-ContainerImageNameRefreshTicker.C {
+	startNodePodInformer()
+	for ; true; waitForTickOrRequest(ContainerImageNameRefreshTicker.C, containerMetadataRefreshRequests) {
 		Log("Updating ImageIDMap and NameIDMap")
 
 		_imageIDMap := make(map[string]string)
 		_nameIDMap := make(map[string]string)
+		_podUIDMap := make(map[string]string)
+		_restartCountMap := make(map[string]string)
+		_terminatedContainers := make(map[string]bool)
+		_podLogCollectionMap := make(map[string]podLogCollection)
 
-		listOptions := metav1.ListOptions{}
-		listOptions.FieldSelector = fmt.Sprintf("spec.nodeName=%s", Computer)
-
-		// Context was added as a parameter, but we want the same behavior as before: see https://pkg.go.dev/context#TODO
-		pods, err := ClientSet.CoreV1().Pods("").List(context.TODO(), listOptions)
+		// the pods of the node are kept up to date by the pod informer
+		pods, err := listNodePods()
 
 		if err != nil {
 			message := fmt.Sprintf("Error getting pods %s\nIt is ok to log here and continue, because the logs will be missing image and Name, but the logs will still have the containerID", err.Error())
@@ -432,30 +511,46 @@ func updateContainerImageNameMaps() {
 			continue
 		}
 
-		for _, pod := range pods.Items {
-			podContainerStatuses := pod.Status.ContainerStatuses
+		for _, pod := range pods {
+			// copying the statuses since the pods are shared with the informer cache
+			podContainerStatuses := append([]corev1.ContainerStatus{}, pod.Status.ContainerStatuses...)
 
 			// Doing this to include init container logs as well
 			podInitContainerStatuses := pod.Status.InitContainerStatuses
 			if (podInitContainerStatuses != nil) && (len(podInitContainerStatuses) > 0) {
 				podContainerStatuses = append(podContainerStatuses, podInitContainerStatuses...)
 			}
+			trackContainerTerminations(*pod, podContainerStatuses, _terminatedContainers)
+			collection, annotated := podLogCollectionOf(pod.Annotations)
 			for _, status := range podContainerStatuses {
-				lastSlashIndex := strings.LastIndex(status.ContainerID, "/")
-				containerID := status.ContainerID[lastSlashIndex+1 : len(status.ContainerID)]
+				containerID, runtime := runtimeContainerID(status.ContainerID)
+				observeContainerRuntime(runtime)
 				image := status.Image
 				name := fmt.Sprintf("%s/%s", pod.UID, status.Name)
 				if containerID != "" {
 					_imageIDMap[containerID] = image
 					_nameIDMap[containerID] = name
+					_podUIDMap[containerID] = string(pod.UID)
+					_restartCountMap[containerID] = strconv.Itoa(int(status.RestartCount))
+					if annotated {
+						_podLogCollectionMap[containerID] = collection
+					}
 				}
 			}
 		}
 
+		completeContainerTerminationsRefresh(_terminatedContainers)
+		refreshContainerLabelsFromCRI()
+		// the containers removed since the previous refresh are kept for the ttl of the cache
+		imageIDMap, nameIDMap, podUIDMap, restartCountMap := ContainerMetadataCache.refresh(_imageIDMap, _nameIDMap, _podUIDMap, _restartCountMap, time.Now())
+
 		Log("Locking to update image and name maps")
 		DataUpdateMutex.Lock()
-		ImageIDMap = _imageIDMap
-		NameIDMap = _nameIDMap
+		ImageIDMap = imageIDMap
+		NameIDMap = nameIDMap
+		PodUIDMap = podUIDMap
+		RestartCountMap = restartCountMap
+		PodLogCollectionMap = _podLogCollectionMap
 		DataUpdateMutex.Unlock()
 		Log("Unlocking after updating image and name maps")
 	}
@@ -487,6 +582,46 @@ func populateExcludedStderrNamespaces() {
 	}
 }
 
+// populateIncludedNamespaces returns the set of the namespaces of the include list of a stream, nil when the stream
+// has no include list and the logs of all the namespaces are collected
+func populateIncludedNamespaces(stream string, collectLogs string, includeList string, ignoreNsSet map[string]bool) map[string]bool {
+	if strings.Compare(collectLogs, "true") != 0 || len(strings.TrimSpace(includeList)) == 0 {
+		return nil
+	}
+	includeNsSet := make(map[string]bool)
+	for _, ns := range strings.Split(includeList, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" {
+			continue
+		}
+		if containsKey(ignoreNsSet, ns) {
+			// the exclude list takes precedence over the include list
+			Log("Namespace %s is both included and excluded for %s log collection, excluding it", ns, stream)
+			continue
+		}
+		Log("Including namespace %s for %s log collection", ns, stream)
+		includeNsSet[ns] = true
+	}
+	return includeNsSet
+}
+
+func populateIncludedStdoutNamespaces() {
+	StdoutIncludeNsSet = populateIncludedNamespaces("stdout", os.Getenv("AZMON_COLLECT_STDOUT_LOGS"), os.Getenv("AZMON_STDOUT_INCLUDED_NAMESPACES"), StdoutIgnoreNsSet)
+}
+
+func populateIncludedStderrNamespaces() {
+	StderrIncludeNsSet = populateIncludedNamespaces("stderr", os.Getenv("AZMON_COLLECT_STDERR_LOGS"), os.Getenv("AZMON_STDERR_INCLUDED_NAMESPACES"), StderrIgnoreNsSet)
+}
+
+// isNamespaceExcluded whether the logs of a namespace are not collected, because it is in the exclude list, or it is
+// not in the include list when there is one
+func isNamespaceExcluded(ignoreNsSet map[string]bool, includeNsSet map[string]bool, namespace string) bool {
+	if containsKey(ignoreNsSet, namespace) {
+		return true
+	}
+	return includeNsSet != nil && !containsKey(includeNsSet, namespace)
+}
+
 //Azure loganalytics metric values have to be numeric, so string values are dropped
 func convert(in interface{}) (float64, bool) {
 	switch v := in.(type) {
@@ -509,9 +644,11 @@ func convert(in interface{}) (float64, bool) {
 
 // PostConfigErrorstoLA sends config/prometheus scraping error log lines to LA
 func populateKubeMonAgentEventHash(record map[interface{}]interface{}, errType KubeMonAgentEventType) {
+	normalizeRuntimeLogRecord(record)
 	var logRecordString = ToString(record["log"])
 	var eventTimeStamp = ToString(record["time"])
-	containerID, _, podName, _ := GetContainerIDK8sNamespacePodNameFromFileName(ToString(record["filepath"]))
+	logFile := containermetadata.ParseContainerLogFilePath(ToString(record["filepath"]))
+	containerID, podName := logFile.ContainerID, logFile.PodName
 
 	Log("Locked EventHashUpdateMutex for updating hash \n ")
 	EventHashUpdateMutex.Lock()
@@ -521,6 +658,7 @@ func populateKubeMonAgentEventHash(record map[interface{}]interface{}, errType K
 		// we are converting string to json to log lines in different lines as one record
 		logRecordString = strings.TrimSuffix(logRecordString, "\n")
 		logRecordString = logRecordString[1 : len(logRecordString)-1]
+		logRecordString, source := parseConfigErrorSource(logRecordString)
 
 		if val, ok := ConfigErrorEvent[logRecordString]; ok {
 			Log("In config error existing hash update\n")
@@ -533,6 +671,9 @@ func populateKubeMonAgentEventHash(record map[interface{}]interface{}, errType K
 				FirstOccurrence: eventFirstOccurrence,
 				LastOccurrence:  eventTimeStamp,
 				Count:           eventCount + 1,
+				ConfigMapKey:    source.Key,
+				ConfigSection:   source.Section,
+				ConfigValue:     source.Value,
 			}
 		} else {
 			ConfigErrorEvent[logRecordString] = KubeMonAgentEventTags{
@@ -541,37 +682,43 @@ func populateKubeMonAgentEventHash(record map[interface{}]interface{}, errType K
 				FirstOccurrence: eventTimeStamp,
 				LastOccurrence:  eventTimeStamp,
 				Count:           1,
+				ConfigMapKey:    source.Key,
+				ConfigSection:   source.Section,
+				ConfigValue:     source.Value,
 			}
 		}
 
 	case PromScrapingError:
-		// Splitting this based on the string 'E! [inputs.prometheus]: ' since the log entry has timestamp and we want to remove that before building the hash
-		var scrapingSplitString = strings.Split(logRecordString, "E! [inputs.prometheus]: ")
-		if scrapingSplitString != nil && len(scrapingSplitString) == 2 {
-			var splitString = scrapingSplitString[1]
-			// Trimming the newline character at the end since this is being added as the key
-			splitString = strings.TrimSuffix(splitString, "\n")
-			if splitString != "" {
-				if val, ok := PromScrapeErrorEvent[splitString]; ok {
-					Log("In config error existing hash update\n")
-					eventCount := val.Count
-					eventFirstOccurrence := val.FirstOccurrence
-
-					PromScrapeErrorEvent[splitString] = KubeMonAgentEventTags{
-						PodName:         podName,
-						ContainerId:     containerID,
-						FirstOccurrence: eventFirstOccurrence,
-						LastOccurrence:  eventTimeStamp,
-						Count:           eventCount + 1,
-					}
-				} else {
-					PromScrapeErrorEvent[splitString] = KubeMonAgentEventTags{
-						PodName:         podName,
-						ContainerId:     containerID,
-						FirstOccurrence: eventTimeStamp,
-						LastOccurrence:  eventTimeStamp,
-						Count:           1,
-					}
+		// the message of the error is the key, without the timestamp and the level of the log line
+		if scrapeError, ok := parsePromScrapeError(logRecordString); ok {
+			scrapeErrorMessage := scrapeError.message
+			if val, ok := PromScrapeErrorEvent[scrapeErrorMessage]; ok {
+				Log("In config error existing hash update\n")
+				eventCount := val.Count
+				eventFirstOccurrence := val.FirstOccurrence
+
+				PromScrapeErrorEvent[scrapeErrorMessage] = KubeMonAgentEventTags{
+					PodName:         podName,
+					ContainerId:     containerID,
+					FirstOccurrence: eventFirstOccurrence,
+					LastOccurrence:  eventTimeStamp,
+					Count:           eventCount + 1,
+					ScrapeTarget:    scrapeError.target,
+					ScrapeJob:       scrapeError.job,
+					ScrapeNamespace: scrapeError.namespace,
+					ErrorClass:      scrapeError.errorClass,
+				}
+			} else {
+				PromScrapeErrorEvent[scrapeErrorMessage] = KubeMonAgentEventTags{
+					PodName:         podName,
+					ContainerId:     containerID,
+					FirstOccurrence: eventTimeStamp,
+					LastOccurrence:  eventTimeStamp,
+					Count:           1,
+					ScrapeTarget:    scrapeError.target,
+					ScrapeJob:       scrapeError.job,
+					ScrapeNamespace: scrapeError.namespace,
+					ErrorClass:      scrapeError.errorClass,
 				}
 			}
 		}
@@ -582,108 +729,102 @@ func populateKubeMonAgentEventHash(record map[interface{}]interface{}, errType K
 
 // Function to get config error log records after iterating through the two hashes
 func flushKubeMonAgentEventRecords() {
-	for ; true; <-KubeMonAgentConfigEventsSendTicker.C {
+	for ; true; waitForTickOrRequest(KubeMonAgentConfigEventsSendTicker.C, kubeMonAgentEventsFlushRequests) {
+		if isRoutePaused(getAgentDataRouteName()) {
+			Log("flushKubeMonAgentEventRecords::Warning::not flushing since the %s route is paused", getAgentDataRouteName())
+			continue
+		}
+		if ODSThrottles.checkThrottled("flushKubeMonAgentEventRecords", KubeMonAgentEventDataType, 0) {
+			continue
+		}
+		if DataBoundaryWorkspaceBlocked == true {
+			Log("flushKubeMonAgentEventRecords::Warning::not flushing since the workspace is outside of the %s data boundary", DataBoundary)
+			continue
+		}
+		if DataResidencyBlocked == true {
+			Log("flushKubeMonAgentEventRecords::Warning::only flushing the region policy events since the workspace region violates the region policy")
+			flushDataResidencyEventRecords()
+			continue
+		}
 		if skipKubeMonEventsFlush != true {
 			Log("In flushConfigErrorRecords\n")
+			span := startFlushSpan("flushKubeMonAgentEventRecords")
+			span.setAttribute("route", getAgentDataRouteName())
+			ctx, stopWatchdog := startFlushWatchdog("flushKubeMonAgentEventRecords")
 			start := time.Now()
-			var elapsed time.Duration
 			var laKubeMonAgentEventsRecords []laKubeMonAgentEvents
 			var msgPackEntries []MsgPackEntry
 			telemetryDimensions := make(map[string]string)
 
 			telemetryDimensions["ConfigErrorEventCount"] = strconv.Itoa(len(ConfigErrorEvent))
 			telemetryDimensions["PromScrapeErrorEventCount"] = strconv.Itoa(len(PromScrapeErrorEvent))
-
-			if (len(ConfigErrorEvent) > 0) || (len(PromScrapeErrorEvent) > 0) {
+			telemetryDimensions["ContainerExitEventCount"] = strconv.Itoa(len(ContainerExitEvent))
+			telemetryDimensions["LogCollectionErrorEventCount"] = strconv.Itoa(len(LogCollectionErrorEvent))
+			telemetryDimensions["DataResidencyEventCount"] = strconv.Itoa(len(DataResidencyEvent))
+			pluginErrorEvents := takePluginErrorEvents()
+			telemetryDimensions["PluginErrorEventCount"] = strconv.Itoa(len(pluginErrorEvents))
+			throttlingEvents := ODSThrottles.takeThrottlingEvents()
+			telemetryDimensions["ThrottlingEventCount"] = strconv.Itoa(len(throttlingEvents))
+			tagCardinalityEvents := TelegrafTagCardinality.takeTagCardinalityEvents()
+			telemetryDimensions["TagCardinalityEventCount"] = strconv.Itoa(len(tagCardinalityEvents))
+			ingestionEvents := takeIngestionEvents()
+			telemetryDimensions["IngestionEventCount"] = strconv.Itoa(len(ingestionEvents))
+
+			if (len(ConfigErrorEvent) > 0) || (len(PromScrapeErrorEvent) > 0) || (len(ContainerExitEvent) > 0) || (len(LogCollectionErrorEvent) > 0) || (len(DataResidencyEvent) > 0) || (len(pluginErrorEvents) > 0) || (len(throttlingEvents) > 0) || (len(tagCardinalityEvents) > 0) || (len(ingestionEvents) > 0) {
 				EventHashUpdateMutex.Lock()
 				Log("Locked EventHashUpdateMutex for reading hashes\n")
-				for k, v := range ConfigErrorEvent {
-					tagJson, err := json.Marshal(v)
+				configErrorRecords, configErrorEntries := buildKubeMonAgentEventRecords(ConfigErrorEvent, ConfigErrorEventCategory, KubeMonAgentEventError, start)
+				laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, configErrorRecords...)
+				msgPackEntries = append(msgPackEntries, configErrorEntries...)
 
-					if err != nil {
-						message := fmt.Sprintf("Error while Marshalling config error event tags: %s", err.Error())
-						Log(message)
-						SendException(message)
-					} else {
-						laKubeMonAgentEventsRecord := laKubeMonAgentEvents{
-							Computer:       Computer,
-							CollectionTime: start.Format(time.RFC3339),
-							Category:       ConfigErrorEventCategory,
-							Level:          KubeMonAgentEventError,
-							ClusterId:      ResourceID,
-							ClusterName:    ResourceName,
-							Message:        k,
-							Tags:           fmt.Sprintf("%s", tagJson),
-						}
-						laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, laKubeMonAgentEventsRecord)
-						var stringMap map[string]string
-						jsonBytes, err := json.Marshal(&laKubeMonAgentEventsRecord)
-						if err != nil {
-							message := fmt.Sprintf("Error while Marshalling laKubeMonAgentEventsRecord to json bytes: %s", err.Error())
-							Log(message)
-							SendException(message)
-						} else {
-							if err := json.Unmarshal(jsonBytes, &stringMap); err != nil {
-								message := fmt.Sprintf("Error while UnMarhalling json bytes to stringmap: %s", err.Error())
-								Log(message)
-								SendException(message)
-							} else {
-						    	msgPackEntry := MsgPackEntry{
-									Record: stringMap,
-								}
-						    msgPackEntries = append(msgPackEntries, msgPackEntry)
-						  }
-					   }
-					}
-				}
+				promScrapeErrorRecords, promScrapeErrorEntries := buildKubeMonAgentEventRecords(PromScrapeErrorEvent, PromScrapingErrorEventCategory, KubeMonAgentEventWarning, start)
+				laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, promScrapeErrorRecords...)
+				msgPackEntries = append(msgPackEntries, promScrapeErrorEntries...)
 
-				for k, v := range PromScrapeErrorEvent {
-					tagJson, err := json.Marshal(v)
-					if err != nil {
-						message := fmt.Sprintf("Error while Marshalling prom scrape error event tags: %s", err.Error())
-						Log(message)
-						SendException(message)
-					} else {
-						laKubeMonAgentEventsRecord := laKubeMonAgentEvents{
-							Computer:       Computer,
-							CollectionTime: start.Format(time.RFC3339),
-							Category:       PromScrapingErrorEventCategory,
-							Level:          KubeMonAgentEventWarning,
-							ClusterId:      ResourceID,
-							ClusterName:    ResourceName,
-							Message:        k,
-							Tags:           fmt.Sprintf("%s", tagJson),
-						}
-						laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, laKubeMonAgentEventsRecord)
-						var stringMap map[string]string
-						jsonBytes, err := json.Marshal(&laKubeMonAgentEventsRecord)
-						if err != nil {
-							message := fmt.Sprintf("Error while Marshalling laKubeMonAgentEventsRecord to json bytes: %s", err.Error())
-							Log(message)
-							SendException(message)
-						} else {
-							if err := json.Unmarshal(jsonBytes, &stringMap); err != nil {
-								message := fmt.Sprintf("Error while UnMarhalling json bytes to stringmap: %s", err.Error())
-								Log(message)
-								SendException(message)
-							} else {
-								msgPackEntry := MsgPackEntry{
-									Record: stringMap,
-								}
-							msgPackEntries = append(msgPackEntries, msgPackEntry)
-						   }
-						}
-					}
-				}
+				containerExitRecords, containerExitEntries := buildKubeMonAgentEventRecords(ContainerExitEvent, ContainerExitEventCategory, KubeMonAgentEventWarning, start)
+				laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, containerExitRecords...)
+				msgPackEntries = append(msgPackEntries, containerExitEntries...)
+
+				logCollectionErrorRecords, logCollectionErrorEntries := buildKubeMonAgentEventRecords(LogCollectionErrorEvent, LogCollectionErrorEventCategory, KubeMonAgentEventWarning, start)
+				laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, logCollectionErrorRecords...)
+				msgPackEntries = append(msgPackEntries, logCollectionErrorEntries...)
+
+				dataResidencyRecords, dataResidencyEntries := buildKubeMonAgentEventRecords(DataResidencyEvent, DataResidencyEventCategory, KubeMonAgentEventWarning, start)
+				laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, dataResidencyRecords...)
+				msgPackEntries = append(msgPackEntries, dataResidencyEntries...)
+
+				pluginErrorRecords, pluginErrorEntries := buildKubeMonAgentEventRecords(pluginErrorEvents, PluginErrorEventCategory, KubeMonAgentEventError, start)
+				laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, pluginErrorRecords...)
+				msgPackEntries = append(msgPackEntries, pluginErrorEntries...)
+
+				throttlingRecords, throttlingEntries := buildKubeMonAgentEventRecords(throttlingEvents, ThrottlingEventCategory, KubeMonAgentEventWarning, start)
+				laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, throttlingRecords...)
+				msgPackEntries = append(msgPackEntries, throttlingEntries...)
+
+				tagCardinalityRecords, tagCardinalityEntries := buildKubeMonAgentEventRecords(tagCardinalityEvents, TagCardinalityEventCategory, KubeMonAgentEventWarning, start)
+				laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, tagCardinalityRecords...)
+				msgPackEntries = append(msgPackEntries, tagCardinalityEntries...)
+
+				ingestionRecords, ingestionEntries := buildKubeMonAgentEventRecords(ingestionEvents, IngestionEventCategory, KubeMonAgentEventError, start)
+				laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, ingestionRecords...)
+				msgPackEntries = append(msgPackEntries, ingestionEntries...)
 
 				//Clearing out the prometheus scrape hash so that it can be rebuilt with the errors in the next hour
 				for k := range PromScrapeErrorEvent {
 					delete(PromScrapeErrorEvent, k)
 				}
 				Log("PromScrapeErrorEvent cache cleared\n")
+				//Clearing out the container exit hash since terminations are reported only once
+				for k := range ContainerExitEvent {
+					delete(ContainerExitEvent, k)
+				}
+				//Clearing out the log collection error hash so that it can be rebuilt with the errors in the next hour
+				for k := range LogCollectionErrorEvent {
+					delete(LogCollectionErrorEvent, k)
+				}
 				EventHashUpdateMutex.Unlock()
 				Log("Unlocked EventHashUpdateMutex for reading hashes\n")
-			} else {
+			} else if isKubeMonAgentEventEnabled(NoErrorEventCategory, KubeMonAgentEventInfo) && shouldSendNoErrorEvent(start) {
 				//Sending a record in case there are no errors to be able to differentiate between no data vs no errors
 				tagsValue := KubeMonAgentEventTags{}
 
@@ -724,108 +865,166 @@ func flushKubeMonAgentEventRecords() {
 					}
 				}
 			}
-			if (IsWindows == false && len(msgPackEntries) > 0) { //for linux, mdsd route
-				if IsAADMSIAuthMode == true && strings.HasPrefix(MdsdKubeMonAgentEventsTagName, MdsdOutputStreamIdTagPrefix) == false {
-					Log("Info::mdsd::obtaining output stream id for data type: %s", KubeMonAgentEventDataType)
-					MdsdKubeMonAgentEventsTagName = extension.GetInstance(FLBLogger, ContainerType).GetOutputStreamId(KubeMonAgentEventDataType)
-				}
-				Log("Info::mdsd:: using mdsdsource name for KubeMonAgentEvents: %s", MdsdKubeMonAgentEventsTagName)
-				msgpBytes := convertMsgPackEntriesToMsgpBytes(MdsdKubeMonAgentEventsTagName, msgPackEntries)
+			heartbeatRecords, heartbeatEntries := buildKubeMonAgentEventRecords(takeHeartbeatEvent(start), HeartbeatEventCategory, KubeMonAgentEventInfo, start)
+			laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, heartbeatRecords...)
+			msgPackEntries = append(msgPackEntries, heartbeatEntries...)
+
+			flushRetCode := sendKubeMonAgentEventRecords(ctx, laKubeMonAgentEventsRecords, msgPackEntries, start, telemetryDimensions)
+			publishKubeMonAgentEventsToEventHubs(ctx, laKubeMonAgentEventsRecords)
+			stopWatchdog()
+			span.setAttribute("records", len(laKubeMonAgentEventsRecords))
+			span.finish(flushRetCode)
+		} else {
+			// Setting this to false to allow for subsequent flushes after the first hour
+			skipKubeMonEventsFlush = false
+		}
+	}
+}
+
+// sendKubeMonAgentEventRecords writes the KubeMonAgentEvents to mdsd on linux and posts them to ODS on windows
+func sendKubeMonAgentEventRecords(ctx context.Context, laKubeMonAgentEventsRecords []laKubeMonAgentEvents, msgPackEntries []MsgPackEntry, start time.Time, telemetryDimensions map[string]string) int {
+	flushRetCode := output.FLB_OK
+	var elapsed time.Duration
+	if (IsWindows == false && len(msgPackEntries) > 0) { //for linux, mdsd route
+		if IsAADMSIAuthMode == true && ContainerLogsRouteGeneva == false && strings.HasPrefix(MdsdKubeMonAgentEventsTagName, MdsdOutputStreamIdTagPrefix) == false {
+			Log("Info::mdsd::obtaining output stream id for data type: %s", KubeMonAgentEventDataType)
+			MdsdKubeMonAgentEventsTagName = extension.GetInstance(FLBLogger, ContainerType).GetOutputStreamId(KubeMonAgentEventDataType)
+		}
+		Log("Info::mdsd:: using mdsdsource name for KubeMonAgentEvents: %s", MdsdKubeMonAgentEventsTagName)
+		// the records are written in batches of at most the max records per post, the batches after a failed one are
+		// not written
+		for _, batch := range kubeMonAgentEventBatches(len(msgPackEntries)) {
+			batchEntries := msgPackEntries[batch[0]:batch[1]]
+			msgpBytes := convertMsgPackEntriesToMsgpBytes(MdsdKubeMonAgentEventsTagName, batchEntries)
+			if MdsdKubeMonMsgpUnixSocketClient == nil {
+				Log("Error::mdsd::mdsd connection for KubeMonAgentEvents does not exist. re-connecting ...")
+				CreateMDSDClient(KubeMonAgentEvents, ContainerType)
 				if MdsdKubeMonMsgpUnixSocketClient == nil {
-					Log("Error::mdsd::mdsd connection for KubeMonAgentEvents does not exist. re-connecting ...")
-					CreateMDSDClient(KubeMonAgentEvents, ContainerType)
-					if MdsdKubeMonMsgpUnixSocketClient == nil {
-						Log("Error::mdsd::Unable to create mdsd client for KubeMonAgentEvents. Please check error log.")
-						ContainerLogTelemetryMutex.Lock()
-						defer ContainerLogTelemetryMutex.Unlock()
-						KubeMonEventsMDSDClientCreateErrors += 1
-					}
+					Log("Error::mdsd::Unable to create mdsd client for KubeMonAgentEvents. Please check error log.")
+					ContainerLogTelemetryMutex.Lock()
+					KubeMonEventsMDSDClientCreateErrors += 1
+					ContainerLogTelemetryMutex.Unlock()
+					flushRetCode = output.FLB_RETRY
+					break
 				}
+			}
+			deadline := MdsdWriteDeadline
+			MdsdKubeMonMsgpUnixSocketClient.SetWriteDeadline(time.Now().Add(deadline)) //this is based of clock time, so cannot reuse
+			sendStart := time.Now()
+			bts, er := MdsdKubeMonMsgpUnixSocketClient.Write(msgpBytes)
+			trackFlushDependency(dependencyTypeMDSD, getMdsdFluentSocketPath(ContainerType), KubeMonAgentEventDataType, sendStart, errorDependencyResultCode(er), er == nil, len(batchEntries))
+			elapsed = time.Since(start)
+			if er != nil {
+				message := fmt.Sprintf("Error::mdsd::Failed to write to kubemonagent mdsd %d records after %s. Will retry ... error : %s", len(batchEntries), elapsed, er.Error())
+				Log(message)
 				if MdsdKubeMonMsgpUnixSocketClient != nil {
-					deadline := 10 * time.Second
-					MdsdKubeMonMsgpUnixSocketClient.SetWriteDeadline(time.Now().Add(deadline)) //this is based of clock time, so cannot reuse
-					bts, er := MdsdKubeMonMsgpUnixSocketClient.Write(msgpBytes)
-					elapsed = time.Since(start)
-					if er != nil {
-						message := fmt.Sprintf("Error::mdsd::Failed to write to kubemonagent mdsd %d records after %s. Will retry ... error : %s", len(msgPackEntries), elapsed, er.Error())
-						Log(message)
-						if MdsdKubeMonMsgpUnixSocketClient != nil {
-							MdsdKubeMonMsgpUnixSocketClient.Close()
-							MdsdKubeMonMsgpUnixSocketClient = nil
-						}
-						SendException(message)
-					} else {
-						numRecords := len(msgPackEntries)
-						Log("FlushKubeMonAgentEventRecords::Info::Successfully flushed %d records that was %d bytes in %s", numRecords, bts, elapsed)
-					    // Send telemetry to AppInsights resource
-						SendEvent(KubeMonAgentEventsFlushedEvent, telemetryDimensions)
-					}
-				} else {
-					Log("Error::mdsd::Unable to create mdsd client for KubeMonAgentEvents. Please check error log.")
+					MdsdKubeMonMsgpUnixSocketClient.Close()
+					MdsdKubeMonMsgpUnixSocketClient = nil
 				}
-			} else if len(laKubeMonAgentEventsRecords) > 0 { //for windows, ODS direct
-				kubeMonAgentEventEntry := KubeMonAgentEventBlob{
-					DataType:  KubeMonAgentEventDataType,
-					IPName:    IPName,
-					DataItems: laKubeMonAgentEventsRecords}
-
-				marshalled, err := json.Marshal(kubeMonAgentEventEntry)
-
-				if err != nil {
-					message := fmt.Sprintf("Error while marshalling kubemonagentevent entry: %s", err.Error())
-					Log(message)
-					SendException(message)
-				} else {
-					req, _ := http.NewRequest("POST", OMSEndpoint, bytes.NewBuffer(marshalled))
-					req.Header.Set("Content-Type", "application/json")
-					req.Header.Set("User-Agent", userAgent)
-					reqId := uuid.New().String()
-					req.Header.Set("X-Request-ID", reqId)
-					//expensive to do string len for every request, so use a flag
-					if ResourceCentric == true {
-						req.Header.Set("x-ms-AzureResourceId", ResourceID)
-					}
-
-					if IsAADMSIAuthMode == true {
-						IngestionAuthTokenUpdateMutex.Lock()
-			            ingestionAuthToken := ODSIngestionAuthToken
-			            IngestionAuthTokenUpdateMutex.Unlock()
-						if ingestionAuthToken == "" {
-							Log("Error::ODS Ingestion Auth Token is empty. Please check error log.")
-						}
-						req.Header.Set("Authorization", "Bearer "+ingestionAuthToken)
-					}
-
-					resp, err := HTTPClient.Do(req)
-					elapsed = time.Since(start)
+				flushRetCode = output.FLB_RETRY
+				SendException(message)
+				break
+			}
+			Log("FlushKubeMonAgentEventRecords::Info::Successfully flushed %d records that was %d bytes in %s", len(batchEntries), bts, elapsed)
+		}
+		if flushRetCode == output.FLB_OK {
+			// Send telemetry to AppInsights resource
+			SendEvent(KubeMonAgentEventsFlushedEvent, telemetryDimensions)
+		}
+	} else if len(laKubeMonAgentEventsRecords) > 0 { //for windows, ODS direct
+		for _, batch := range kubeMonAgentEventBatches(len(laKubeMonAgentEventsRecords)) {
+			batchRecords := laKubeMonAgentEventsRecords[batch[0]:batch[1]]
+			kubeMonAgentEventEntry := KubeMonAgentEventBlob{
+				DataType:  KubeMonAgentEventDataType,
+				IPName:    IPName,
+				DataItems: batchRecords}
 
-					if err != nil {
-						message := fmt.Sprintf("Error when sending kubemonagentevent request %s \n", err.Error())
-						Log(message)
-						Log("Failed to flush %d records after %s", len(laKubeMonAgentEventsRecords), elapsed)
-					} else if resp == nil || resp.StatusCode != 200 {
-						if resp != nil {
-							Log("flushKubeMonAgentEventRecords: RequestId %s Status %s Status Code %d", reqId, resp.Status, resp.StatusCode)
-						}
-						Log("Failed to flush %d records after %s", len(laKubeMonAgentEventsRecords), elapsed)
-					} else {
-						numRecords := len(laKubeMonAgentEventsRecords)
-						Log("FlushKubeMonAgentEventRecords::Info::Successfully flushed %d records in %s", numRecords, elapsed)
+			marshalled, err := json.Marshal(kubeMonAgentEventEntry)
 
-						// Send telemetry to AppInsights resource
-						SendEvent(KubeMonAgentEventsFlushedEvent, telemetryDimensions)
+			if err != nil {
+				message := fmt.Sprintf("Error while marshalling kubemonagentevent entry: %s", err.Error())
+				Log(message)
+				SendException(message)
+				continue
+			}
+			sendStart := time.Now()
+			resp, reqId, endpoint, err := postODSPayload(ctx, marshalled)
+			trackFlushDependency(dependencyTypeODS, dependencyTarget(endpoint), KubeMonAgentEventDataType, sendStart, httpDependencyResultCode(resp, err), err == nil && resp != nil && resp.StatusCode == 200, len(batchRecords))
+			elapsed = time.Since(start)
+			if resp != nil && resp.Body != nil {
+				resp.Body.Close()
+			}
 
-					}
-					if resp != nil && resp.Body != nil {
-						defer resp.Body.Close()
+			if err != nil {
+				message := fmt.Sprintf("Error when sending kubemonagentevent request %s \n", err.Error())
+				Log(message)
+				Log("Failed to flush %d records after %s", len(batchRecords), elapsed)
+				flushRetCode = output.FLB_RETRY
+				break
+			} else if resp == nil || resp.StatusCode != 200 {
+				if resp != nil {
+					Log("flushKubeMonAgentEventRecords: RequestId %s Status %s Status Code %d", reqId, resp.Status, resp.StatusCode)
+					if resp.StatusCode == 429 {
+						ODSThrottles.throttle(KubeMonAgentEventDataType, parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), time.Now())
 					}
 				}
+				Log("Failed to flush %d records after %s", len(batchRecords), elapsed)
+				flushRetCode = output.FLB_RETRY
+				break
 			}
-		} else {
-			// Setting this to false to allow for subsequent flushes after the first hour
-			skipKubeMonEventsFlush = false
+			Log("FlushKubeMonAgentEventRecords::Info::Successfully flushed %d records in %s", len(batchRecords), elapsed)
+		}
+		if flushRetCode == output.FLB_OK {
+			// Send telemetry to AppInsights resource
+			SendEvent(KubeMonAgentEventsFlushedEvent, telemetryDimensions)
 		}
 	}
+	return flushRetCode
+}
+
+// buildKubeMonAgentEventRecords converts an event hash to LA records (ODS route) and msgpack entries (mdsd route)
+func buildKubeMonAgentEventRecords(eventHash map[string]KubeMonAgentEventTags, category string, level string, collectionTime time.Time) ([]laKubeMonAgentEvents, []MsgPackEntry) {
+	var laKubeMonAgentEventsRecords []laKubeMonAgentEvents
+	var msgPackEntries []MsgPackEntry
+	if !isKubeMonAgentEventEnabled(category, level) {
+		return laKubeMonAgentEventsRecords, msgPackEntries
+	}
+	for k, v := range eventHash {
+		tagJson, err := json.Marshal(v)
+		if err != nil {
+			message := fmt.Sprintf("Error while Marshalling %s event tags: %s", category, err.Error())
+			Log(message)
+			SendException(message)
+			continue
+		}
+		laKubeMonAgentEventsRecord := laKubeMonAgentEvents{
+			Computer:       Computer,
+			CollectionTime: collectionTime.Format(time.RFC3339),
+			Category:       category,
+			Level:          level,
+			ClusterId:      ResourceID,
+			ClusterName:    ResourceName,
+			Message:        limitColumnLength(columnMessage, k),
+			Tags:           limitColumnLength(columnTags, fmt.Sprintf("%s", tagJson)),
+		}
+		laKubeMonAgentEventsRecords = append(laKubeMonAgentEventsRecords, laKubeMonAgentEventsRecord)
+		var stringMap map[string]string
+		jsonBytes, err := json.Marshal(&laKubeMonAgentEventsRecord)
+		if err != nil {
+			message := fmt.Sprintf("Error while Marshalling laKubeMonAgentEventsRecord to json bytes: %s", err.Error())
+			Log(message)
+			SendException(message)
+			continue
+		}
+		if err := json.Unmarshal(jsonBytes, &stringMap); err != nil {
+			message := fmt.Sprintf("Error while UnMarhalling json bytes to stringmap: %s", err.Error())
+			Log(message)
+			SendException(message)
+			continue
+		}
+		msgPackEntries = append(msgPackEntries, MsgPackEntry{Record: stringMap})
+	}
+	return laKubeMonAgentEventsRecords, msgPackEntries
 }
 
 //Translates telegraf time series to one or more Azure loganalytics metric(s)
@@ -843,21 +1042,43 @@ func translateTelegrafMetrics(m map[interface{}]interface{}) ([]*laTelegrafMetri
 		tagMap[key] = fmt.Sprintf("%s", v)
 	}
 
+	var fieldMap map[interface{}]interface{}
+	fieldMap = m["fields"].(map[interface{}]interface{})
+
+	// the filters apply to the tags of the series, not to the azure monitor tags
+	if !TelegrafMetricFilters.sendsSeries(fmt.Sprintf("%s", m["name"]), tagMap) {
+		countFilteredTelegrafMetrics(len(fieldMap))
+		return nil, nil
+	}
+	TelegrafTagCardinality.limit(fmt.Sprintf("%s", m["name"]), tagMap, time.Now())
+
 	//add azure monitor tags
 	tagMap[fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, TelegrafTagClusterID)] = ResourceID
 	tagMap[fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, TelegrafTagClusterName)] = ResourceName
 
-	var fieldMap map[interface{}]interface{}
-	fieldMap = m["fields"].(map[interface{}]interface{})
-
 	tagJson, err := json.Marshal(&tagMap)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// the fields are translated in order, so that the metrics of a chunk are the same when the chunk is retried
+	fieldNames := make([]string, 0, len(fieldMap))
+	fieldValues := make(map[string]interface{}, len(fieldMap))
 	for k, v := range fieldMap {
-		fv, ok := convert(v)
+		name := fmt.Sprintf("%s", k)
+		fieldNames = append(fieldNames, name)
+		fieldValues[name] = v
+	}
+	sort.Strings(fieldNames)
+
+	filtered := 0
+	for _, name := range fieldNames {
+		if !TelegrafMetricFilters.sendsMetric(name) {
+			filtered++
+			continue
+		}
+		fv, ok := convert(fieldValues[name])
 		if !ok {
 			continue
 		}
@@ -866,9 +1087,9 @@ func translateTelegrafMetrics(m map[interface{}]interface{}) ([]*laTelegrafMetri
 			Origin: fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, TelegrafMetricOriginSuffix),
 			//Namespace:  	fmt.Sprintf("%s/%s", TelegrafMetricNamespacePrefix, m["name"]),
 			Namespace:      fmt.Sprintf("%s", m["name"]),
-			Name:           fmt.Sprintf("%s", k),
+			Name:           name,
 			Value:          fv,
-			Tags:           fmt.Sprintf("%s", tagJson),
+			Tags:           limitColumnLength(columnTags, fmt.Sprintf("%s", tagJson)),
 			CollectionTime: time.Unix(int64(i), 0).Format(time.RFC3339),
 			Computer:       Computer, //this is the collection agent's computer name, not necessarily to which computer the metric applies to
 		}
@@ -876,11 +1097,58 @@ func translateTelegrafMetrics(m map[interface{}]interface{}) ([]*laTelegrafMetri
 		//Log ("la metric:%v", laMetric)
 		laMetrics = append(laMetrics, &laMetric)
 	}
+	countFilteredTelegrafMetrics(filtered)
 	return laMetrics, nil
 }
 
 // send metrics from Telegraf to LA. 1) Translate telegraf timeseries to LA metric(s) 2) Send it to LA as 'InsightsMetrics' fixed type
-func PostTelegrafMetricsToLA(telegrafRecords []map[interface{}]interface{}) int {
+// The batch id identifies the chunk for the duplicate detection and the flush checkpoint, empty when not needed
+func PostTelegrafMetricsToLA(telegrafRecords []map[interface{}]interface{}, batchID string) int {
+	route := getTelegrafMetricsRouteName()
+	if isRoutePaused(route) {
+		return output.FLB_RETRY
+	}
+	if retCode, backingOff := waitRetryBackoff("PostTelegrafMetricsToLA", route, len(telegrafRecords)); backingOff {
+		return retCode
+	}
+	if ODSThrottles.checkThrottled("PostTelegrafMetricsToLA", InsightsMetricsDataType, len(telegrafRecords)) {
+		return output.FLB_RETRY
+	}
+	if skipFlushedBatch("PostTelegrafMetricsToLA", route, batchID, len(telegrafRecords)) {
+		return output.FLB_OK
+	}
+	// the metrics are low priority, so they are paused while the metrics or the container logs are over the retry budget
+	if isOverRetryBudget(route) || isOverRetryBudget(getContainerLogsRouteName()) {
+		Log("PostTelegrafMetricsToLA::Warning:dropping %d timeseries since the retry budget is exceeded", len(telegrafRecords))
+		updateRetryBudgetTelemetry(len(telegrafRecords), 0)
+		return output.FLB_OK
+	}
+	span := startFlushSpan("PostTelegrafMetricsToLA")
+	span.setAttribute("route", route)
+	span.setAttribute("records", len(telegrafRecords))
+	ctx, stopWatchdog := startFlushWatchdog("PostTelegrafMetricsToLA")
+	defer stopWatchdog()
+	releaseFlushSlot, err := acquireFlushSlot(ctx, route)
+	if err != nil {
+		Log("PostTelegrafMetricsToLA::Error:no in-flight flush slot available before the flush deadline, will retry")
+		recordFlushOutcome(route, output.FLB_RETRY)
+		span.finish(output.FLB_RETRY)
+		return output.FLB_RETRY
+	}
+	retCode := postTelegrafMetricsToLA(ctx, telegrafRecords, batchID)
+	releaseFlushSlot()
+	retCode = recordRetryBackoffOutcome("PostTelegrafMetricsToLA", route, retCode, len(telegrafRecords), time.Now())
+	recordFlushOutcome(route, retCode)
+	if retCode == output.FLB_OK {
+		addFlushedBatch(route, batchID)
+	}
+	span.finish(retCode)
+	return retCode
+}
+
+// postTelegrafMetricsToLA sends the metrics of the chunk, the payloads sent by an earlier delivery of the chunk with the
+// batch id are not sent again
+func postTelegrafMetricsToLA(ctx context.Context, telegrafRecords []map[interface{}]interface{}, batchID string) (retCode int) {
 	var laMetrics []*laTelegrafMetric
 
 	if (telegrafRecords == nil) || !(len(telegrafRecords) > 0) {
@@ -888,6 +1156,19 @@ func PostTelegrafMetricsToLA(telegrafRecords []map[interface{}]interface{}) int
 		return output.FLB_OK
 	}
 
+	if DataResidencyBlocked == true {
+		Log("PostTelegrafMetricsToLA::Warning:dropping %d timeseries since the workspace region violates the region policy", len(telegrafRecords))
+		return output.FLB_OK
+	}
+	if DataBoundaryWorkspaceBlocked == true {
+		Log("PostTelegrafMetricsToLA::Warning:dropping %d timeseries since the workspace is outside of the %s data boundary", len(telegrafRecords), DataBoundary)
+		return output.FLB_OK
+	}
+
+	if !isDownstreamReady("PostTelegrafMetricsToLA") {
+		return output.FLB_RETRY
+	}
+
 	for _, record := range telegrafRecords {
 		translatedMetrics, err := translateTelegrafMetrics(record)
 		if err != nil {
@@ -898,6 +1179,22 @@ func PostTelegrafMetricsToLA(telegrafRecords []map[interface{}]interface{}) int
 		laMetrics = append(laMetrics, translatedMetrics...)
 	}
 
+	if TelegrafMetricAggregator != nil {
+		aggregationBatchID := batchID
+		if aggregationBatchID == "" {
+			aggregationBatchID = recordsBatchID(telegrafRecords)
+		}
+		aggregates := TelegrafMetricAggregator.add(aggregationBatchID, laMetrics, time.Now())
+		defer func() {
+			if retCode == output.FLB_RETRY {
+				TelegrafMetricAggregator.restore(aggregates)
+			}
+		}()
+		laMetrics = TelegrafMetricAggregator.aggregatedTelegrafMetrics(aggregates)
+		// the aggregates sent are not the metrics of the chunk, so their payloads are not remembered
+		batchID = ""
+	}
+
 	if (laMetrics == nil) || !(len(laMetrics) > 0) {
 		Log("PostTelegrafMetricsToLA::Info:no metrics derived from timeseries data")
 		return output.FLB_OK
@@ -906,6 +1203,20 @@ func PostTelegrafMetricsToLA(telegrafRecords []map[interface{}]interface{}) int
 		Log(message)
 	}
 
+	publishTelegrafMetricsToMDM(ctx, laMetrics, batchID)
+
+	if TelegrafMetricsRouteADX == true {
+		return sendTelegrafMetricsToADX(ctx, laMetrics, batchID)
+	}
+
+	if TelegrafMetricsRouteDCR == true {
+		return sendTelegrafMetricsToDCR(ctx, laMetrics, batchID)
+	}
+
+	if TelegrafMetricsRouteOTLP == true {
+		return sendTelegrafMetricsToOTLP(ctx, laMetrics, batchID)
+	}
+
 	if IsWindows == false { //for linux, mdsd route
 		var msgPackEntries []MsgPackEntry
 		var i int
@@ -941,7 +1252,7 @@ func PostTelegrafMetricsToLA(telegrafRecords []map[interface{}]interface{}) int
 				}
 		}
 		if (len(msgPackEntries) > 0) {
-			    if IsAADMSIAuthMode == true && (strings.HasPrefix(MdsdInsightsMetricsTagName, MdsdOutputStreamIdTagPrefix) == false) {
+			    if IsAADMSIAuthMode == true && ContainerLogsRouteGeneva == false && (strings.HasPrefix(MdsdInsightsMetricsTagName, MdsdOutputStreamIdTagPrefix) == false) {
 				  Log("Info::mdsd::obtaining output stream id for InsightsMetricsDataType since Log Analytics AAD MSI Auth Enabled")
 				  MdsdInsightsMetricsTagName = extension.GetInstance(FLBLogger, ContainerType).GetOutputStreamId(InsightsMetricsDataType)
 			    }
@@ -951,6 +1262,7 @@ func PostTelegrafMetricsToLA(telegrafRecords []map[interface{}]interface{}) int
 					CreateMDSDClient(InsightsMetrics, ContainerType)
 					if MdsdInsightsMetricsMsgpUnixSocketClient == nil {
 						Log("Error::mdsd::Unable to create mdsd client for insights metrics. Please check error log.")
+						addIngestionFailureEvent(getAgentDataRouteName(), InsightsMetricsDataType, errors.New("unable to create the mdsd client"), time.Now())
 						ContainerLogTelemetryMutex.Lock()
 						defer ContainerLogTelemetryMutex.Unlock()
 						InsightsMetricsMDSDClientCreateErrors += 1
@@ -958,14 +1270,17 @@ func PostTelegrafMetricsToLA(telegrafRecords []map[interface{}]interface{}) int
 					}
 				}
 
-				deadline := 10 * time.Second
+				deadline := MdsdWriteDeadline
 				MdsdInsightsMetricsMsgpUnixSocketClient.SetWriteDeadline(time.Now().Add(deadline)) //this is based of clock time, so cannot reuse
+				sendStart := time.Now()
 				bts, er := MdsdInsightsMetricsMsgpUnixSocketClient.Write(msgpBytes)
+				trackFlushDependency(dependencyTypeMDSD, getMdsdFluentSocketPath(ContainerType), InsightsMetricsDataType, sendStart, errorDependencyResultCode(er), er == nil, len(msgPackEntries))
 
 				elapsed = time.Since(start)
 
 				if er != nil {
 					Log("Error::mdsd::Failed to write to mdsd %d records after %s. Will retry ... error : %s", len(msgPackEntries), elapsed, er.Error())
+					addIngestionFailureEvent(getAgentDataRouteName(), InsightsMetricsDataType, er, time.Now())
 					UpdateNumTelegrafMetricsSentTelemetry(0, 1, 0)
 					if MdsdInsightsMetricsMsgpUnixSocketClient != nil {
 						MdsdInsightsMetricsMsgpUnixSocketClient.Close()
@@ -992,77 +1307,76 @@ func PostTelegrafMetricsToLA(telegrafRecords []map[interface{}]interface{}) int
 			metrics = append(metrics, *laMetrics[i])
 		}
 
-		laTelegrafMetrics := InsightsMetricsBlob{
-			DataType:  InsightsMetricsDataType,
-			IPName:    IPName,
-			DataItems: metrics}
-
-		jsonBytes, err := json.Marshal(laTelegrafMetrics)
+		marshal := func(start int, end int) ([]byte, error) {
+			laTelegrafMetrics := InsightsMetricsBlob{
+				DataType:  InsightsMetricsDataType,
+				IPName:    IPName,
+				DataItems: metrics[start:end]}
 
-		if err != nil {
-			message := fmt.Sprintf("PostTelegrafMetricsToLA::Error:when marshalling json %q", err)
-			Log(message)
-			SendException(message)
+			jsonBytes, err := json.Marshal(laTelegrafMetrics)
+			if err != nil {
+				message := fmt.Sprintf("PostTelegrafMetricsToLA::Error:when marshalling json %q", err)
+				Log(message)
+				SendException(message)
+				return nil, errBatchDropped
+			}
+			return jsonBytes, nil
+		}
+		err := sendODSPayloadChunks(ctx, "PostTelegrafMetricsToLA", len(metrics), marshal, func(start int, end int, jsonBytes []byte) error {
+			return sendUnsentPayload("PostTelegrafMetricsToLA", getTelegrafMetricsRouteName(), batchID, start, end, func() error {
+				return postTelegrafMetricsToODS(ctx, jsonBytes, end-start)
+			})
+		})
+		if err == errBatchDropped {
 			return output.FLB_OK
+		} else if err != nil {
+			return output.FLB_RETRY
 		}
+	}
 
-		//Post metrics data to LA
-		req, _ := http.NewRequest("POST", OMSEndpoint, bytes.NewBuffer(jsonBytes))
-
-		//req.URL.Query().Add("api-version","2016-04-01")
+	return output.FLB_OK
+}
 
-		//set headers
-		req.Header.Set("x-ms-date", time.Now().Format(time.RFC3339))
-		req.Header.Set("User-Agent", userAgent)
-		reqID := uuid.New().String()
-		req.Header.Set("X-Request-ID", reqID)
+// postTelegrafMetricsToODS posts a payload of the metrics to ODS
+func postTelegrafMetricsToODS(ctx context.Context, jsonBytes []byte, numMetrics int) error {
+	//Post metrics data to LA
+	req, reqID, err := newRouteRequest(ctx, "POST", requestRouteODS, OMSEndpoint, jsonBytes)
+	if err != nil {
+		Log("PostTelegrafMetricsToLA::Error:when building the request %s", err.Error())
+		return err
+	}
 
-		//expensive to do string len for every request, so use a flag
-		if ResourceCentric == true {
-			req.Header.Set("x-ms-AzureResourceId", ResourceID)
-		}
-		if IsAADMSIAuthMode == true {
-			IngestionAuthTokenUpdateMutex.Lock()
-			ingestionAuthToken := ODSIngestionAuthToken
-			IngestionAuthTokenUpdateMutex.Unlock()
-			if ingestionAuthToken == "" {
-				message := "Error::ODS Ingestion Auth Token is empty. Please check error log."
-				Log(message)
-				return output.FLB_RETRY
-			}
-			// add authorization header to the req
-			req.Header.Set("Authorization", "Bearer "+ingestionAuthToken)
-		}
+	start := time.Now()
+	resp, err := doRouteRequest(requestRouteODS, req)
+	trackFlushDependency(dependencyTypeODS, dependencyTarget(OMSEndpoint), InsightsMetricsDataType, start, httpDependencyResultCode(resp, err), err == nil && resp != nil && resp.StatusCode == 200, numMetrics)
+	elapsed := time.Since(start)
 
-		start := time.Now()
-		resp, err := HTTPClient.Do(req)
-		elapsed := time.Since(start)
+	if err != nil {
+		message := fmt.Sprintf("PostTelegrafMetricsToLA::Error:(retriable) when sending %v metrics. duration:%v err:%q \n", numMetrics, elapsed, err.Error())
+		Log(message)
+		UpdateNumTelegrafMetricsSentTelemetry(0, 1, 0)
+		return err
+	}
 
-		if err != nil {
-			message := fmt.Sprintf("PostTelegrafMetricsToLA::Error:(retriable) when sending %v metrics. duration:%v err:%q \n", len(laMetrics), elapsed, err.Error())
-			Log(message)
-			UpdateNumTelegrafMetricsSentTelemetry(0, 1, 0)
-			return output.FLB_RETRY
+	if resp == nil || resp.StatusCode != 200 {
+		if resp == nil {
+			return errors.New("no response from ODS")
 		}
-
-		if resp == nil || resp.StatusCode != 200 {
-			if resp != nil {
-				Log("PostTelegrafMetricsToLA::Error:(retriable) RequestID %s Response Status %v Status Code %v", reqID, resp.Status, resp.StatusCode)
-			}
-			if resp != nil && resp.StatusCode == 429 {
-				UpdateNumTelegrafMetricsSentTelemetry(0, 1, 1)
-			}
-			return output.FLB_RETRY
+		LogWith(pluginLogFields{Route: getAgentDataRouteName(), RequestID: reqID}, "PostTelegrafMetricsToLA::Error:(retriable) RequestID %s Response Status %v Status Code %v", reqID, resp.Status, resp.StatusCode)
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if resp.StatusCode == 429 {
+			UpdateNumTelegrafMetricsSentTelemetry(0, 1, 1)
+			ODSThrottles.throttle(InsightsMetricsDataType, retryAfter, time.Now())
+			delayRouteRetry(getTelegrafMetricsRouteName(), retryAfter, time.Now())
 		}
-
-		defer resp.Body.Close()
-
-		numMetrics := len(laMetrics)
-		UpdateNumTelegrafMetricsSentTelemetry(numMetrics, 0, 0)
-		Log("PostTelegrafMetricsToLA::Info:Successfully flushed %v records in %v", numMetrics, elapsed)
+		return &sinkStatusError{statusCode: resp.StatusCode, retryAfter: retryAfter}
 	}
 
-	return output.FLB_OK
+	defer resp.Body.Close()
+
+	UpdateNumTelegrafMetricsSentTelemetry(numMetrics, 0, 0)
+	Log("PostTelegrafMetricsToLA::Info:Successfully flushed %v records in %v", numMetrics, elapsed)
+	return nil
 }
 
 func UpdateNumTelegrafMetricsSentTelemetry(numMetricsSent int, numSendErrors int, numSend429Errors int) {
@@ -1073,8 +1387,116 @@ func UpdateNumTelegrafMetricsSentTelemetry(numMetricsSent int, numSendErrors int
 	ContainerLogTelemetryMutex.Unlock()
 }
 
-// PostDataHelper sends data to the ODS endpoint or oneagent or ADX
-func PostDataHelper(tailPluginRecords []map[interface{}]interface{}) int {
+// PostDataHelper sends data to the ODS endpoint or oneagent or ADX. The batch id identifies the chunk for the duplicate
+// detection and the flush checkpoint, empty when not needed
+func PostDataHelper(tailPluginRecords []map[interface{}]interface{}, batchID string) int {
+	return flushContainerLogRecords(tailPluginRecords, batchID, true)
+}
+
+// flushContainerLogRecords sends the container log records, and spools them to the disk when they fail and spool is set
+func flushContainerLogRecords(tailPluginRecords []map[interface{}]interface{}, batchID string, spool bool) int {
+	route := getContainerLogsRouteName()
+	if isRoutePaused(route) {
+		return output.FLB_RETRY
+	}
+	if retCode, backingOff := waitRetryBackoff("PostDataHelper", route, len(tailPluginRecords)); backingOff {
+		return retCode
+	}
+	if route == ContainerLogsV1Route && ODSThrottles.checkThrottled("PostDataHelper", getContainerLogsDataType(), len(tailPluginRecords)) {
+		return output.FLB_RETRY
+	}
+	if skipFlushedBatch("PostDataHelper", route, batchID, len(tailPluginRecords)) {
+		return output.FLB_OK
+	}
+	overRetryBudget := isOverRetryBudget(route)
+	if overRetryBudget && RetryBudgetSamplingRatio < 1 {
+		sampledRecords := sampleRecords(tailPluginRecords, RetryBudgetSamplingRatio)
+		updateRetryBudgetTelemetry(len(tailPluginRecords)-len(sampledRecords), 0)
+		tailPluginRecords = sampledRecords
+	}
+	span := startFlushSpan("PostDataHelper")
+	span.setAttribute("records", len(tailPluginRecords))
+	span.setAttribute("retry_budget.exceeded", overRetryBudget)
+	ctx, stopWatchdog := startFlushWatchdog("PostDataHelper")
+	defer stopWatchdog()
+	// the lanes are acquired before the flush slot, so a flush holding a slot never waits for the earlier flushes
+	releaseLanes, err := ContainerOrdering.acquire(ctx, ContainerOrdering.recordLanes(tailPluginRecords))
+	if err != nil {
+		Log("PostDataHelper::Error::the earlier flushes of the containers did not complete before the flush deadline, will retry")
+		recordFlushOutcome(route, output.FLB_RETRY)
+		span.finish(output.FLB_RETRY)
+		return output.FLB_RETRY
+	}
+	releaseFlushSlot, err := acquireFlushSlot(ctx, route)
+	if err != nil {
+		releaseLanes()
+		Log("PostDataHelper::Error::no in-flight flush slot available before the flush deadline, will retry")
+		recordFlushOutcome(route, output.FLB_RETRY)
+		span.finish(output.FLB_RETRY)
+		return output.FLB_RETRY
+	}
+	// the container exit markers are flushed with the records, and queued again when the flush is retried
+	var markers []map[interface{}]interface{}
+	if containerExitLogMarkerEnabled {
+		markers = drainContainerExitLogMarkers()
+		tailPluginRecords = prependContainerExitLogMarkers(markers, tailPluginRecords)
+	}
+	records := tailPluginRecords
+	rollbackMultiline := func() {}
+	if MultilineAssembler != nil {
+		// assembled within the ordering lanes, so the lines of a container are merged in order
+		records, rollbackMultiline = MultilineAssembler.assemble(tailPluginRecords, time.Now())
+	}
+	retCode := postDataHelper(ctx, records, batchID, span)
+	if retCode != output.FLB_OK {
+		rollbackMultiline()
+	}
+	releaseFlushSlot()
+	releaseLanes()
+	retCode = recordRetryBackoffOutcome("PostDataHelper", route, retCode, len(tailPluginRecords), time.Now())
+	recordFlushOutcome(route, retCode)
+	trackSpoolOutcome(retCode, time.Now())
+	// over the budget, the failed chunks are dead-lettered instead of growing the fluent-bit retry queue
+	if retCode == output.FLB_RETRY && overRetryBudget && RetryBudgetDeadLetterDir != "" {
+		if err := writeDeadLetterChunk(route, tailPluginRecords); err != nil {
+			Log("Error::retrybudget::Unable to dead-letter %d records, will retry: %s", len(tailPluginRecords), err.Error())
+		} else {
+			Log("PostDataHelper::Warning::dead-lettered %d records since the retry budget of the %s route is exceeded", len(tailPluginRecords), route)
+			updateRetryBudgetTelemetry(0, len(tailPluginRecords))
+			retCode = output.FLB_OK
+		}
+	}
+	if retCode == output.FLB_RETRY && spool {
+		retCode = spoolFailedChunk(route, tailPluginRecords, time.Now())
+	}
+	if retCode == output.FLB_OK {
+		addFlushedBatch(route, batchID)
+	} else if retCode == output.FLB_RETRY {
+		requeueContainerExitLogMarkers(markers)
+	}
+	span.finish(retCode)
+	return retCode
+}
+
+func postDataHelper(ctx context.Context, tailPluginRecords []map[interface{}]interface{}, batchID string, span *flushSpan) int {
+	// the workspace blocks don't apply to the container logs of the ADX route
+	if ContainerLogsRouteADX == false && DataResidencyBlocked == true {
+		Log("PostDataHelper::Warning::dropping %d records since the workspace region violates the region policy", len(tailPluginRecords))
+		countDataResidencyDroppedRecords(len(tailPluginRecords))
+		return output.FLB_OK
+	}
+	if ContainerLogsRouteADX == false && DataBoundaryWorkspaceBlocked == true {
+		Log("PostDataHelper::Warning::dropping %d records since the workspace is outside of the %s data boundary", len(tailPluginRecords), DataBoundary)
+		return output.FLB_OK
+	}
+	if ContainerLogsRouteADX == true && DataBoundaryADXBlocked == true {
+		Log("PostDataHelper::Warning::dropping %d records since the ADX cluster is outside of the %s data boundary", len(tailPluginRecords), DataBoundary)
+		return output.FLB_OK
+	}
+	if !isDownstreamReady("PostDataHelper") {
+		return output.FLB_RETRY
+	}
+
 	start := time.Now()
 	var dataItemsLAv1 []DataItemLAv1
 	var dataItemsLAv2 []DataItemLAv2
@@ -1086,41 +1508,53 @@ func PostDataHelper(tailPluginRecords []map[interface{}]interface{}) int {
 
 	var maxLatency float64
 	var maxLatencyContainer string
-
-	imageIDMap := make(map[string]string)
-	nameIDMap := make(map[string]string)
-
-	DataUpdateMutex.Lock()
-
-	for k, v := range ImageIDMap {
-		imageIDMap[k] = v
-	}
-	for k, v := range NameIDMap {
-		nameIDMap[k] = v
-	}
-	DataUpdateMutex.Unlock()
+	var batchLogBytes int
+
+	imageIDMap, nameIDMap, podUIDMap, restartCountMap := snapshotContainerMetadata()
+	// the containers started after the last refresh are looked up
+	metadata := containerLogMetadata{imageIDs: imageIDMap, names: nameIDMap, podUIDs: podUIDMap, restartCounts: restartCountMap}
+	containerLabelsMap := snapshotContainerLabels()
+	podLogCollectionMap := snapshotPodLogCollections()
+	metadataCache := recordMetadataCache{}
+	throughput := make(map[containerLogThroughputKey]*containerLogThroughput)
+	droppedByRule := make(map[string]int)
+	redactedByRule := make(map[string]int)
+	routedBatches := make(workspaceRouteBatches)
+	teeBatch := &containerLogBatch{start: start}
+	var canaryLogBytes int
 
 	for _, record := range tailPluginRecords {
-		containerID, k8sNamespace, k8sPodName, containerName := GetContainerIDK8sNamespacePodNameFromFileName(ToString(record["filepath"]))
+		normalizeRuntimeLogRecord(record)
+		logFile := containermetadata.ParseContainerLogFilePath(ToString(record["filepath"]))
+		containerID, k8sNamespace, k8sPodName, containerName := logFile.ContainerID, logFile.Namespace, logFile.PodName, logFile.ContainerName
 		logEntrySource := ToString(record["stream"])
 
 		if strings.EqualFold(logEntrySource, "stdout") {
-			if containerID == "" || containsKey(StdoutIgnoreNsSet, k8sNamespace) {
+			if containerID == "" || isContainerLogExcluded(podLogCollectionMap, containerID, logEntrySource, isNamespaceExcluded(StdoutIgnoreNsSet, StdoutIncludeNsSet, k8sNamespace)) {
 				continue
 			}
 		} else if strings.EqualFold(logEntrySource, "stderr") {
-			if containerID == "" || containsKey(StderrIgnoreNsSet, k8sNamespace) {
+			if containerID == "" || isContainerLogExcluded(podLogCollectionMap, containerID, logEntrySource, isNamespaceExcluded(StderrIgnoreNsSet, StderrIncludeNsSet, k8sNamespace)) {
+				continue
+			}
+		}
+		if len(LogDropRules) > 0 {
+			if rule := matchLogDropRule(k8sNamespace, containerName, ToString(record["log"])); rule != "" {
+				droppedByRule[rule]++
 				continue
 			}
 		}
 
+		metadata.resolve(containerID, k8sNamespace, k8sPodName)
 		stringMap = make(map[string]string)
 		//below id & name are used by latency telemetry in both v1 & v2 LA schemas
 		id := ""
 	    name := ""
 
-		logEntry := ToString(record["log"])
+		logEntry := limitColumnLength(columnLogEntry, redactLogEntry(ToString(record["log"]), redactedByRule))
 		logEntryTimeStamp := ToString(record["time"])
+		batchLogBytes += len(logEntry)
+		countContainerLogRecord(throughput, containerLogThroughputKey{namespace: k8sNamespace, podName: k8sPodName, containerName: containerName, containerID: containerID}, len(logEntry))
 		//ADX Schema & LAv2 schema are almost the same (except resourceId)
 		if (ContainerLogSchemaV2 == true || ContainerLogsRouteADX == true) {
 			stringMap["Computer"] = Computer
@@ -1131,6 +1565,7 @@ func PostDataHelper(tailPluginRecords []map[interface{}]interface{}) int {
 			stringMap["LogMessage"] = logEntry
 			stringMap["LogSource"] = logEntrySource
 			stringMap["TimeGenerated"] = logEntryTimeStamp
+			metadata.addIncarnationColumns(stringMap, containerID)
 		} else {
 			stringMap["LogEntry"] = logEntry
 			stringMap["LogEntrySource"] = logEntrySource
@@ -1138,17 +1573,28 @@ func PostDataHelper(tailPluginRecords []map[interface{}]interface{}) int {
 			stringMap["SourceSystem"] = "Containers"
 			stringMap["Id"] = containerID
 
-			if val, ok := imageIDMap[containerID]; ok {
+			if val, ok := metadata.imageIDs[containerID]; ok {
 				stringMap["Image"] = val
 			}
 
-			if val, ok := nameIDMap[containerID]; ok {
+			if val, ok := metadata.names[containerID]; ok {
 				stringMap["Name"] = val
 			}
 
 			stringMap["TimeOfCommand"] = start.Format(time.RFC3339)
 			stringMap["Computer"] = Computer
 		}
+
+		if val, ok := containerLabelsMap[containerID]; ok {
+			stringMap["ContainerLabels"] = val
+		}
+		if LogLevelDetection {
+			stringMap["LogLevel"] = detectLogLevel(logEntry, logEntrySource)
+		}
+		var promotedFields map[string]string
+		if len(JSONPromotedFields) > 0 {
+			promotedFields = promoteJSONFields(logEntry, JSONPromotedFields)
+		}
 		var dataItemLAv1 DataItemLAv1
 		var dataItemLAv2 DataItemLAv2
 		var dataItemADX DataItemADX
@@ -1156,13 +1602,24 @@ func PostDataHelper(tailPluginRecords []map[interface{}]interface{}) int {
 
 		FlushedRecordsSize += float64(len(stringMap["LogEntry"]))
 
-		if ContainerLogsRouteV2 == true {
+		canary := ContainerLogsRouteV2 == false && routesToCanary(containerID)
+		if canary {
+			canaryLogBytes += len(logEntry)
+		}
+
+		if ContainerLogsRouteV2 == true || canary {
+			for column, value := range promotedFields {
+				stringMap[column] = value
+			}
 			msgPackEntry = MsgPackEntry{
 				// this below time is what mdsd uses in its buffer/expiry calculations. better to be as close to flushtime as possible, so its filled just before flushing for each entry
 				//Time: start.Unix(),
 				//Time: time.Now().Unix(),
 				Record: stringMap,
 			}
+			if ForwardRecordMetadata {
+				msgPackEntry.Metadata = metadataCache.get(k8sNamespace)
+			}
 			msgPackEntries = append(msgPackEntries, msgPackEntry)
 		} else if ContainerLogsRouteADX == true {
 			if ResourceCentric == true {
@@ -1180,43 +1637,45 @@ func PostDataHelper(tailPluginRecords []map[interface{}]interface{}) int {
 				LogMessage:            stringMap["LogMessage"],
 				LogSource:             stringMap["LogSource"],
 				AzureResourceId:       stringMap["AzureResourceId"],
+				PodUid:                stringMap["PodUid"],
+				RestartCount:          stringMap["RestartCount"],
+				ContainerLabels:       stringMap["ContainerLabels"],
+				LogLevel:              stringMap["LogLevel"],
+				PromotedFields:        promotedFields,
 			}
 			//ADX
 			dataItemsADX = append(dataItemsADX, dataItemADX)
 		} else {
 			if (ContainerLogSchemaV2 == true) {
-				dataItemLAv2 = DataItemLAv2{
-					TimeGenerated:         stringMap["TimeGenerated"],
-					Computer:              stringMap["Computer"],
-					ContainerId:           stringMap["ContainerId"],
-					ContainerName:         stringMap["ContainerName"],
-					PodName:               stringMap["PodName"],
-					PodNamespace:          stringMap["PodNamespace"],
-					LogMessage:            stringMap["LogMessage"],
-					LogSource:             stringMap["LogSource"],
+				if stringMap["LogLevel"] == "" {
+					stringMap["LogLevel"] = streamLogLevel(logEntrySource)
 				}
+				dataItemLAv2 = newDataItemLAv2(stringMap)
 				//ODS-v2 schema
-				dataItemsLAv2 = append(dataItemsLAv2, dataItemLAv2)
+				if routed := routedBatches.batchOf(k8sNamespace, start); routed != nil {
+					routed.dataItemsLAv2 = append(routed.dataItemsLAv2, dataItemLAv2)
+					routed.logBytes += len(logEntry)
+				} else {
+					dataItemsLAv2 = append(dataItemsLAv2, dataItemLAv2)
+				}
 				name = stringMap["ContainerName"]
 				id = stringMap["ContainerId"]
 			} else {
-				dataItemLAv1 = DataItemLAv1{
-					ID:                    stringMap["Id"],
-					LogEntry:              stringMap["LogEntry"],
-					LogEntrySource:        stringMap["LogEntrySource"],
-					LogEntryTimeStamp:     stringMap["LogEntryTimeStamp"],
-					LogEntryTimeOfCommand: stringMap["TimeOfCommand"],
-					SourceSystem:          stringMap["SourceSystem"],
-					Computer:              stringMap["Computer"],
-					Image:                 stringMap["Image"],
-					Name:                  stringMap["Name"],
-				}
+				dataItemLAv1 = newDataItemLAv1(stringMap)
 			//ODS-v1 schema
-			dataItemsLAv1 = append(dataItemsLAv1, dataItemLAv1)
+			if routed := routedBatches.batchOf(k8sNamespace, start); routed != nil {
+				routed.dataItemsLAv1 = append(routed.dataItemsLAv1, dataItemLAv1)
+				routed.logBytes += len(logEntry)
+			} else {
+				dataItemsLAv1 = append(dataItemsLAv1, dataItemLAv1)
+			}
 			name = stringMap["Name"]
 			id = stringMap["Id"]
 			}
 		}
+		if TeeSink != nil {
+			appendTeeItem(teeBatch, stringMap, teeRecordFields{containerID: containerID, namespace: k8sNamespace, podName: k8sPodName, containerName: containerName, logEntry: logEntry, logSource: logEntrySource, timeStamp: logEntryTimeStamp, image: metadata.imageIDs[containerID], name: metadata.names[containerID], promotedFields: promotedFields})
+		}
 
 		if logEntryTimeStamp != "" {
 			loggedTime, e := time.Parse(time.RFC3339, logEntryTimeStamp)
@@ -1234,232 +1693,204 @@ func PostDataHelper(tailPluginRecords []map[interface{}]interface{}) int {
 		}
 	}
 
-	numContainerLogRecords := 0
-
-	if len(msgPackEntries) > 0 && ContainerLogsRouteV2 == true {
-		//flush to mdsd
-		if IsAADMSIAuthMode == true && strings.HasPrefix(MdsdContainerLogTagName, MdsdOutputStreamIdTagPrefix) == false {
-			Log("Info::mdsd::obtaining output stream id")
-			if ContainerLogSchemaV2 == true {
-				MdsdContainerLogTagName = extension.GetInstance(FLBLogger, ContainerType).GetOutputStreamId(ContainerLogV2DataType)
-			} else {
-				MdsdContainerLogTagName = extension.GetInstance(FLBLogger, ContainerType).GetOutputStreamId(ContainerLogDataType)
-			}
-			Log("Info::mdsd:: using mdsdsource name: %s", MdsdContainerLogTagName)
-		}
-
-		fluentForward := MsgPackForward{
-			Tag:     MdsdContainerLogTagName,
-			Entries: msgPackEntries,
-		}
-
-		//determine the size of msgp message
-		msgpSize := 1 + msgp.StringPrefixSize + len(fluentForward.Tag) + msgp.ArrayHeaderSize
-		for i := range fluentForward.Entries {
-			msgpSize += 1 + msgp.Int64Size + msgp.GuessSize(fluentForward.Entries[i].Record)
-		}
+	// smooth the send rate when replaying a backlog, so the burst doesn't get throttled downstream. A retried flush is
+	// counted by the flush that sends it
+	if !throttleCatchUp(ctx, len(msgPackEntries)+len(dataItemsADX)+len(dataItemsLAv2)+len(dataItemsLAv1), batchLogBytes) {
+		return output.FLB_RETRY
+	}
 
-		//allocate buffer for msgp message
-		var msgpBytes []byte
-		msgpBytes = msgp.Require(nil, msgpSize)
+	numContainerLogRecords := 0
+	addContainerLogThroughput(throughput)
+	addLogDropRuleCounts(droppedByRule)
+	addLogRedactionCounts(redactedByRule)
+	span.setAttribute("chunk.bytes", batchLogBytes)
+	if ContainerLogsRouteADX == false {
+		msgPackEntries, dataItemsLAv2, dataItemsLAv1 = enforceLogAnalyticsLimits(msgPackEntries, dataItemsLAv2, dataItemsLAv1)
+	}
+	sortContainerLogBatch(msgPackEntries, dataItemsADX, dataItemsLAv2, dataItemsLAv1)
 
-		//construct the stream
-		msgpBytes = append(msgpBytes, 0x92)
-		msgpBytes = msgp.AppendString(msgpBytes, fluentForward.Tag)
-		msgpBytes = msgp.AppendArrayHeader(msgpBytes, uint32(len(fluentForward.Entries)))
-		batchTime := time.Now().Unix()
-		for entry := range fluentForward.Entries {
-			msgpBytes = append(msgpBytes, 0x92)
-			msgpBytes = msgp.AppendInt64(msgpBytes, batchTime)
-			msgpBytes = msgp.AppendMapStrStr(msgpBytes, fluentForward.Entries[entry].Record)
+	// the tee route is sent with the route of the container logs, and never fails the flush
+	teeBatchID := ""
+	if TeeSink != nil && RecentChunks != nil {
+		teeBatchID = recordsBatchID(tailPluginRecords)
+	}
+	waitTeeSend := startTeeSend(ctx, teeBatch, teeBatchID)
+	defer waitTeeSend()
+
+	if CanarySink != nil && len(msgPackEntries) > 0 {
+		// the records of the canary containers are sent thru the v2 route, the others thru the route of the container logs
+		canaryBatch := &containerLogBatch{msgPackEntries: msgPackEntries, logBytes: canaryLogBytes, start: start}
+		if err := CanarySink.Send(ctx, canaryBatch); err != nil && err != errBatchDropped {
+			delayRouteRetryForError(ContainerLogsV2Route, err)
+			return output.FLB_RETRY
 		}
+		numContainerLogRecords += canaryBatch.len()
+		msgPackEntries = nil
+	}
 
-		if MdsdMsgpUnixSocketClient == nil {
-			Log("Error::mdsd::mdsd connection does not exist. re-connecting ...")
-			CreateMDSDClient(ContainerLogV2, ContainerType)
-			if MdsdMsgpUnixSocketClient == nil {
-				Log("Error::mdsd::Unable to create mdsd client. Please check error log.")
-
-				ContainerLogTelemetryMutex.Lock()
-				defer ContainerLogTelemetryMutex.Unlock()
-				ContainerLogsMDSDClientCreateErrors += 1
-
-				return output.FLB_RETRY
-			}
+	if len(routedBatches) > 0 {
+		routed, err := sendWorkspaceRouteBatches(ctx, routedBatches)
+		if err != nil {
+			delayRouteRetryForError(getContainerLogsRouteName(), err)
+			return output.FLB_RETRY
 		}
+		numContainerLogRecords += routed
+	}
 
-		deadline := 10 * time.Second
-		MdsdMsgpUnixSocketClient.SetWriteDeadline(time.Now().Add(deadline)) //this is based of clock time, so cannot reuse
-
-		bts, er := MdsdMsgpUnixSocketClient.Write(msgpBytes)
-
-		elapsed = time.Since(start)
-
-		if er != nil {
-			Log("Error::mdsd::Failed to write to mdsd %d records after %s. Will retry ... error : %s", len(msgPackEntries), elapsed, er.Error())
-			if MdsdMsgpUnixSocketClient != nil {
-				MdsdMsgpUnixSocketClient.Close()
-				MdsdMsgpUnixSocketClient = nil
-			}
-
-			ContainerLogTelemetryMutex.Lock()
-			defer ContainerLogTelemetryMutex.Unlock()
-			ContainerLogsSendErrorsToMDSDFromFluent += 1
-
+	batch := &containerLogBatch{
+		msgPackEntries: msgPackEntries,
+		dataItemsADX:   dataItemsADX,
+		dataItemsLAv2:  dataItemsLAv2,
+		dataItemsLAv1:  dataItemsLAv1,
+		logBytes:       batchLogBytes - routedBatches.logBytes() - canaryLogBytes,
+		start:          start,
+		id:             batchID,
+	}
+	if batch.len() > 0 {
+		if err := sendContainerLogBatch(ctx, batch); err == errBatchDropped {
+			return output.FLB_OK
+		} else if err != nil {
+			delayRouteRetryForError(getContainerLogsRouteName(), err)
 			return output.FLB_RETRY
-		} else {
-			numContainerLogRecords = len(msgPackEntries)
-			Log("Success::mdsd::Successfully flushed %d container log records that was %d bytes to mdsd in %s ", numContainerLogRecords, bts, elapsed)
-		}
-	} else if ContainerLogsRouteADX == true && len(dataItemsADX) > 0 {
-		// Route to ADX
-		r, w := io.Pipe()
-		defer r.Close()
-		enc := json.NewEncoder(w)
-		go func() {
-			defer w.Close()
-			for _, data := range dataItemsADX {
-				if encError := enc.Encode(data); encError != nil {
-					message := fmt.Sprintf("Error::ADX Encoding data for ADX %s", encError)
-					Log(message)
-					//SendException(message) //use for testing/debugging only as this can generate a lot of exceptions
-					//continue and move on, so one poisoned message does not impact the whole batch
-				}
-			}
-		}()
-
-		if ADXIngestor == nil {
-			Log("Error::ADX::ADXIngestor does not exist. re-creating ...")
-			CreateADXClient()
-			if ADXIngestor == nil {
-				Log("Error::ADX::Unable to create ADX client. Please check error log.")
-
-				ContainerLogTelemetryMutex.Lock()
-				defer ContainerLogTelemetryMutex.Unlock()
-				ContainerLogsADXClientCreateErrors += 1
-
-				return output.FLB_RETRY
-			}
 		}
+		numContainerLogRecords += batch.len()
+	}
+	elapsed = time.Since(start)
 
-		// Setup a maximum time for completion to be 30 Seconds.
-		ctx, cancel := context.WithTimeout(ParentContext, 30*time.Second)
-		defer cancel()
+	updateContainerLogFlushTelemetry(numContainerLogRecords, elapsed, maxLatency, maxLatencyContainer)
 
-		//ADXFlushMutex.Lock()
-		//defer ADXFlushMutex.Unlock()
-		//MultiJSON support is not there yet
-		if _, ingestionErr := ADXIngestor.FromReader(ctx, r, ingest.IngestionMappingRef("ContainerLogV2Mapping", ingest.JSON), ingest.FileFormat(ingest.JSON)); ingestionErr != nil {
-			Log("Error when streaming to ADX Ingestion: %s", ingestionErr.Error())
-			//ADXIngestor = nil  //not required as per ADX team. Will keep it to indicate that we tried this approach
+	return output.FLB_OK
+}
 
-			ContainerLogTelemetryMutex.Lock()
-			defer ContainerLogTelemetryMutex.Unlock()
-			ContainerLogsSendErrorsToADXFromFluent += 1
+// newDataItemLAv1 returns the ContainerLog item of a record
+func newDataItemLAv1(stringMap map[string]string) DataItemLAv1 {
+	return DataItemLAv1{
+		ID:                    stringMap["Id"],
+		LogEntry:              stringMap["LogEntry"],
+		LogEntrySource:        stringMap["LogEntrySource"],
+		LogEntryTimeStamp:     stringMap["LogEntryTimeStamp"],
+		LogEntryTimeOfCommand: stringMap["TimeOfCommand"],
+		SourceSystem:          stringMap["SourceSystem"],
+		Computer:              stringMap["Computer"],
+		Image:                 stringMap["Image"],
+		Name:                  stringMap["Name"],
+		ContainerLabels:       stringMap["ContainerLabels"],
+		LogLevel:              stringMap["LogLevel"],
+	}
+}
 
-			return output.FLB_RETRY
-		}
+// newDataItemLAv2 returns the ContainerLogV2 item of a record
+func newDataItemLAv2(stringMap map[string]string) DataItemLAv2 {
+	return DataItemLAv2{
+		TimeGenerated:   stringMap["TimeGenerated"],
+		Computer:        stringMap["Computer"],
+		ContainerId:     stringMap["ContainerId"],
+		ContainerName:   stringMap["ContainerName"],
+		PodName:         stringMap["PodName"],
+		PodNamespace:    stringMap["PodNamespace"],
+		LogMessage:      stringMap["LogMessage"],
+		LogSource:       stringMap["LogSource"],
+		PodUid:          stringMap["PodUid"],
+		RestartCount:    stringMap["RestartCount"],
+		ContainerLabels: stringMap["ContainerLabels"],
+		LogLevel:        stringMap["LogLevel"],
+	}
+}
 
-		elapsed = time.Since(start)
-		numContainerLogRecords = len(dataItemsADX)
-		Log("Success::ADX::Successfully wrote %d container log records to ADX in %s", numContainerLogRecords, elapsed)
+// snapshotContainerMetadata returns the container metadata maps, so the flush doesn't hold DataUpdateMutex. The maps
+// are replaced by the refreshes, so they are not copied
+func snapshotContainerMetadata() (map[string]string, map[string]string, map[string]string, map[string]string) {
+	rlockContainerMetadata()
+	defer DataUpdateMutex.RUnlock()
+	return ImageIDMap, NameIDMap, PodUIDMap, RestartCountMap
+}
 
-	} else if ((ContainerLogSchemaV2 == true && len(dataItemsLAv2) > 0) || len(dataItemsLAv1) > 0) { //ODS
-		var logEntry interface{}
-		recordType := ""
-		loglinesCount := 0
-		//schema v2
-		if (len(dataItemsLAv2) > 0 && ContainerLogSchemaV2 == true) {
-			logEntry = ContainerLogBlobLAv2{
-				DataType:  ContainerLogV2DataType,
-				IPName:    IPName,
-				DataItems: dataItemsLAv2}
-				loglinesCount = len(dataItemsLAv2)
-				recordType = "ContainerLogV2"
+// ensureMdsdContainerLogTagName gets the output stream id of the container logs from the extension in MSI auth mode
+func ensureMdsdContainerLogTagName() {
+	// the output stream ids are read from the unix socket of the agent, the windows agent uses the source names
+	if IsAADMSIAuthMode == true && IsWindows == false && ContainerLogsRouteGeneva == false && strings.HasPrefix(MdsdContainerLogTagName, MdsdOutputStreamIdTagPrefix) == false {
+		Log("Info::mdsd::obtaining output stream id")
+		if ContainerLogSchemaV2 == true {
+			MdsdContainerLogTagName = extension.GetInstance(FLBLogger, ContainerType).GetOutputStreamId(ContainerLogV2DataType)
 		} else {
-			//schema v1
-			if len(dataItemsLAv1) > 0 {
-				logEntry = ContainerLogBlobLAv1{
-					DataType:  ContainerLogDataType,
-					IPName:    IPName,
-					DataItems: dataItemsLAv1}
-					loglinesCount = len(dataItemsLAv1)
-					recordType = "ContainerLog"
-			}
-		}
-
-		marshalled, err := json.Marshal(logEntry)
-		//Log("LogEntry::e %s", marshalled)
-		if err != nil {
-			message := fmt.Sprintf("Error while Marshalling log Entry: %s", err.Error())
-			Log(message)
-			SendException(message)
-			return output.FLB_OK
+			MdsdContainerLogTagName = extension.GetInstance(FLBLogger, ContainerType).GetOutputStreamId(ContainerLogDataType)
 		}
+		Log("Info::mdsd:: using mdsdsource name: %s", MdsdContainerLogTagName)
+	}
+}
 
-		req, _ := http.NewRequest("POST", OMSEndpoint, bytes.NewBuffer(marshalled))
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("User-Agent", userAgent)
-		reqId := uuid.New().String()
-		req.Header.Set("X-Request-ID", reqId)
-		//expensive to do string len for every request, so use a flag
-		if ResourceCentric == true {
-			req.Header.Set("x-ms-AzureResourceId", ResourceID)
-		}
+// writeContainerLogsToMdsd writes the forward message with the container log records to mdsd, reconnecting when there
+// is no connection, and waits for the ack of the chunk when set. Returns the time taken since the start of the flush,
+// and the error when the write fails
+func writeContainerLogsToMdsd(ctx context.Context, msgpBytes []byte, ackChunk string, numRecords int, start time.Time) (time.Duration, error) {
+	var elapsed time.Duration
+	waitStart := time.Now()
+	waited, err := MdsdInflightLimiter.acquire(ctx, len(msgpBytes))
+	if waited {
+		updateMdsdBackpressureTelemetry(float64(time.Since(waitStart)/time.Millisecond), 0)
+	}
+	if err != nil {
+		Log("Error::mdsd::The writes in flight to mdsd did not complete before the flush deadline. Will retry ... error : %s", err.Error())
+		return time.Since(start), err
+	}
+	defer MdsdInflightLimiter.release(len(msgpBytes))
+	conn, err := MdsdContainerLogConnPool.acquire()
+	if err != nil {
+		Log("Error::mdsd::Unable to open MDSD msgp socket connection for ContainerLogV2 %s", err.Error())
+		Log("Error::mdsd::Unable to create mdsd client. Please check error log.")
 
-		if IsAADMSIAuthMode == true {
-			IngestionAuthTokenUpdateMutex.Lock()
-			ingestionAuthToken := ODSIngestionAuthToken
-			IngestionAuthTokenUpdateMutex.Unlock()
-			if ingestionAuthToken == "" {
-				Log("Error::ODS Ingestion Auth Token is empty. Please check error log.")
-				return output.FLB_RETRY
-			}
-			// add authorization header to the req
-		    req.Header.Set("Authorization", "Bearer "+ingestionAuthToken)
-		}
+		ContainerLogTelemetryMutex.Lock()
+		defer ContainerLogTelemetryMutex.Unlock()
+		ContainerLogsMDSDClientCreateErrors += 1
+		MdsdConnectionState = 0
+		PluginMetrics.addClientCreateError("mdsd")
 
-		resp, err := HTTPClient.Do(req)
-		elapsed = time.Since(start)
+		return elapsed, errors.New("unable to create the mdsd client")
+	}
 
-		if err != nil {
-			message := fmt.Sprintf("Error when sending request %s \n", err.Error())
-			Log(message)
-			// Commenting this out for now. TODO - Add better telemetry for ods errors using aggregation
-			//SendException(message)
+	deadline := MdsdWriteDeadline
+	conn.SetWriteDeadline(time.Now().Add(deadline)) //this is based of clock time, so cannot reuse
 
-			Log("Failed to flush %d records after %s", loglinesCount, elapsed)
+	sendStart := time.Now()
+	bts, er := conn.Write(msgpBytes)
+	if er == nil && ackChunk != "" {
+		er = readForwardAck(conn, ackChunk)
+	}
+	// the connection is closed when the write failed, the next write reconnecting
+	MdsdContainerLogConnPool.release(conn, er != nil)
+	trackFlushDependency(dependencyTypeMDSD, getMdsdFluentSocketPath(ContainerType), getContainerLogsDataType(), sendStart, errorDependencyResultCode(er), er == nil, numRecords)
 
-			return output.FLB_RETRY
-		}
+	elapsed = time.Since(start)
 
-		if resp == nil || resp.StatusCode != 200 {
-			if resp != nil {
-				Log("RequestId %s Status %s Status Code %d", reqId, resp.Status, resp.StatusCode)
-			}
-			return output.FLB_RETRY
-		}
+	if er != nil {
+		Log("Error::mdsd::Failed to write to mdsd %d records after %s. Will retry ... error : %s", numRecords, elapsed, er.Error())
 
-		defer resp.Body.Close()
-		numContainerLogRecords = loglinesCount
-		Log("PostDataHelper::Info::Successfully flushed %d %s records to ODS in %s", numContainerLogRecords, recordType, elapsed)
+		ContainerLogTelemetryMutex.Lock()
+		defer ContainerLogTelemetryMutex.Unlock()
+		MdsdConnectionState = 0
 
-		}
+		return elapsed, er
+	} else {
+		Log("Success::mdsd::Successfully flushed %d container log records that was %d bytes to mdsd in %s ", numRecords, bts, elapsed)
+	}
+	return elapsed, nil
+}
 
+// updateContainerLogFlushTelemetry counts the flushed container log records and the max processing latency
+func updateContainerLogFlushTelemetry(numContainerLogRecords int, elapsed time.Duration, maxLatency float64, maxLatencyContainer string) {
 	ContainerLogTelemetryMutex.Lock()
 	defer ContainerLogTelemetryMutex.Unlock()
 
 	if numContainerLogRecords > 0 {
 		FlushedRecordsCount += float64(numContainerLogRecords)
 		FlushedRecordsTimeTaken += float64(elapsed / time.Millisecond)
+		PluginMetrics.addFlushedRecords(numContainerLogRecords, elapsed)
 
 		if maxLatency >= AgentLogProcessingMaxLatencyMs {
 			AgentLogProcessingMaxLatencyMs = maxLatency
 			AgentLogProcessingMaxLatencyMsContainer = maxLatencyContainer
 		}
 	}
-
-	return output.FLB_OK
 }
 
 func containsKey(currentMap map[string]bool, key string) bool {
@@ -1467,53 +1898,6 @@ func containsKey(currentMap map[string]bool, key string) bool {
 	return c
 }
 
-// GetContainerIDK8sNamespacePodNameFromFileName Gets the container ID, k8s namespace, pod name and containername From the file Name
-// sample filename kube-proxy-dgcx7_kube-system_kube-proxy-8df7e49e9028b60b5b0d0547f409c455a9567946cf763267b7e6fa053ab8c182.log
-func GetContainerIDK8sNamespacePodNameFromFileName(filename string) (string, string, string, string) {
-	id := ""
-	ns := ""
-	podName := ""
-	containerName := ""
-
-	start := strings.LastIndex(filename, "-")
-	end := strings.LastIndex(filename, ".")
-
-	if start >= end || start == -1 || end == -1 {
-		id = ""
-	} else {
-		id = filename[start+1 : end]
-	}
-
-	start = strings.Index(filename, "_")
-	end = strings.LastIndex(filename, "_")
-
-	if start >= end || start == -1 || end == -1 {
-		ns = ""
-	} else {
-		ns = filename[start+1 : end]
-	}
-
-	start = strings.LastIndex(filename, "_")
-	end = strings.LastIndex(filename, "-")
-
-	if start >= end || start == -1 || end == -1 {
-		containerName = ""
-	} else {
-		containerName = filename[start+1 : end]
-	}
-
-	start = strings.Index(filename, "/containers/")
-	end = strings.Index(filename, "_")
-
-	if start >= end || start == -1 || end == -1 {
-		podName = ""
-	} else {
-		podName = filename[(start + len("/containers/")):end]
-	}
-
-	return id, ns, podName, containerName
-}
-
 // InitializePlugin reads and populates plugin configuration
 func InitializePlugin(pluginConfPath string, agentVersion string) {
 	go func() {
@@ -1525,14 +1909,41 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 			}
 		}
 	}()
+	initializePluginLog()
 	StdoutIgnoreNsSet = make(map[string]bool)
 	StderrIgnoreNsSet = make(map[string]bool)
 	ImageIDMap = make(map[string]string)
 	NameIDMap = make(map[string]string)
+	PodUIDMap = make(map[string]string)
+	RestartCountMap = make(map[string]string)
 	// Keeping the two error hashes separate since we need to keep the config error hash for the lifetime of the container
 	// whereas the prometheus scrape error hash needs to be refreshed every hour
 	ConfigErrorEvent = make(map[string]KubeMonAgentEventTags)
 	PromScrapeErrorEvent = make(map[string]KubeMonAgentEventTags)
+	initializeContainerExitTracking()
+	LogCollectionErrorEvent = make(map[string]KubeMonAgentEventTags)
+	DataResidencyEvent = make(map[string]KubeMonAgentEventTags)
+	initializeKubeMonAgentEventFilter()
+	initializeTelegrafMetricFilters()
+	initializeTelegrafMetricAggregation()
+	initializeTelegrafTagCardinality()
+	initializePluginErrorEvents()
+	initializeNoErrorEvents(strings.Compare(strings.ToLower(os.Getenv("CONTROLLER_TYPE")), "daemonset") != 0)
+	initializePromScrapeErrorInputs()
+	initializeCatchUpThrottling()
+	initializeFlushTracing()
+	initializeDependencyTelemetry()
+	initializeFlushWatchdog()
+	initializeRetryBudget()
+	initializeRetryBackoff()
+	initializeDiskSpool()
+	initializeMultilineAssembly()
+	initializeLogDropRules()
+	initializeLogRedaction()
+	initializeLogLevelDetection()
+	initializeContainerOrdering()
+	initializeBatchSorting()
+	initializeDuplicateChunkDetection()
 	// Initializing this to true to skip the first kubemonagentevent flush since the errors are not populated at this time
 	skipKubeMonEventsFlush = true
 
@@ -1553,6 +1964,7 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 		time.Sleep(30 * time.Second)
 		log.Fatalln(message)
 	}
+	configurePluginLog(pluginConfig)
 
 	ContainerType = os.Getenv(ContainerTypeEnv)
 	Log("Container Type %s", ContainerType)
@@ -1621,6 +2033,15 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 		IsAADMSIAuthMode = true
 		Log("AAD MSI Auth Mode Configured")
 	}
+	IsWorkloadIdentityAuthMode = false
+	if strings.Compare(strings.ToLower(os.Getenv(WorkloadIdentityAuthMode)), "true") == 0 {
+		if isWorkloadIdentityConfigured() {
+			IsWorkloadIdentityAuthMode = true
+			Log("Workload Identity Auth Mode Configured")
+		} else {
+			Log("Workload Identity Auth Mode configured but %s is not set, falling back to the agent certificates", envAzureFederatedTokenFile)
+		}
+	}
 	ResourceID = os.Getenv(envAKSResourceID)
 
 	if len(ResourceID) > 0 {
@@ -1641,9 +2062,7 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 		Log("ResourceName=%s", ResourceName)
 	}
 
-	// log runtime info for debug purpose
-	containerRuntime = os.Getenv(ContainerRuntimeEnv)
-	Log("Container Runtime engine %s", containerRuntime)
+	initializeContainerRuntime()
 
 	// set useragent to be used by ingestion
 	dockerCimprovVersionEnv := strings.TrimSpace(os.Getenv("DOCKER_CIMPROV_VERSION"))
@@ -1666,9 +2085,11 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 	}
 	Log("containerInventoryRefreshInterval = %d \n", containerInventoryRefreshInterval)
 	ContainerImageNameRefreshTicker = time.NewTicker(time.Second * time.Duration(containerInventoryRefreshInterval))
+	PodLogCollectionRefreshTicker = time.NewTicker(time.Second * time.Duration(containerInventoryRefreshInterval))
 
-	Log("kubeMonAgentConfigEventFlushInterval = %d \n", kubeMonAgentConfigEventFlushInterval)
-	KubeMonAgentConfigEventsSendTicker = time.NewTicker(time.Minute * time.Duration(kubeMonAgentConfigEventFlushInterval))
+	initializeKubeMonAgentEventBatches()
+	initializeHeartbeats(agentVersion)
+	KubeMonAgentConfigEventsSendTicker = time.NewTicker(KubeMonAgentEventsFlushInterval)
 
 	Log("Computer == %s \n", Computer)
 
@@ -1679,6 +2100,10 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 		Log(message)
 	}
 
+	initializeDataBoundary()
+	validateWorkspaceRegion()
+	enforceWorkspaceDataBoundary()
+
 	// Initialize KubeAPI Client
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -1695,14 +2120,45 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 	}
 
 	PluginConfiguration = pluginConfig
+	initializeRouteRequestHeaders()
+	initializeLogsIngestionAuth()
+	initializeFlushConcurrency()
+	initializeMdsdSettings(pluginConfig)
+	initializeMdsdConnPool()
+	initializeFlushWorkers()
+	initializeODSPayloadChunks()
+	initializeFlushCheckpoint()
+	initializeCompressionCapabilities()
+	initializePartitionKeying()
 
 	ContainerLogsRoute := strings.TrimSpace(strings.ToLower(os.Getenv("AZMON_CONTAINER_LOGS_ROUTE")))
 	Log("AZMON_CONTAINER_LOGS_ROUTE:%s", ContainerLogsRoute)
 
 	ContainerLogsRouteV2 = false
 	ContainerLogsRouteADX = false
-
-	if strings.Compare(ContainerLogsRoute, ContainerLogsADXRoute) == 0 {
+	ContainerLogsRouteGeneva = false
+	ContainerLogsRouteDCR = false
+	ContainerLogsRouteKafka = false
+	ContainerLogsRouteEventHubs = false
+	ContainerLogsRouteOTLP = false
+
+	if strings.Compare(ContainerLogsRoute, ContainerLogsDCRRoute) == 0 && initializeDCRRoute() {
+		ContainerLogsRouteDCR = true
+		Log("Routing container logs thru %s route...", ContainerLogsDCRRoute)
+		fmt.Fprintf(os.Stdout, "Routing container logs thru %s route...\n", ContainerLogsDCRRoute)
+	} else if strings.Compare(ContainerLogsRoute, ContainerLogsKafkaRoute) == 0 && initializeKafkaRoute() {
+		ContainerLogsRouteKafka = true
+		Log("Routing container logs thru %s route...", ContainerLogsKafkaRoute)
+		fmt.Fprintf(os.Stdout, "Routing container logs thru %s route...\n", ContainerLogsKafkaRoute)
+	} else if strings.Compare(ContainerLogsRoute, ContainerLogsEventHubsRoute) == 0 && initializeEventHubsRoute() {
+		ContainerLogsRouteEventHubs = true
+		Log("Routing container logs thru %s route...", ContainerLogsEventHubsRoute)
+		fmt.Fprintf(os.Stdout, "Routing container logs thru %s route...\n", ContainerLogsEventHubsRoute)
+	} else if strings.Compare(ContainerLogsRoute, ContainerLogsOTLPRoute) == 0 && initializeOTLPRoute() {
+		ContainerLogsRouteOTLP = true
+		Log("Routing container logs thru %s route...", ContainerLogsOTLPRoute)
+		fmt.Fprintf(os.Stdout, "Routing container logs thru %s route...\n", ContainerLogsOTLPRoute)
+	} else if strings.Compare(ContainerLogsRoute, ContainerLogsADXRoute) == 0 {
 		// Try to read the ADX database name from environment variables. Default to DefaultAdsDatabaseName if not set. 
 		// This SHOULD be set by tomlparser.rb so it's a highly unexpected event if it isn't.
 		// It should be set by the logic in tomlparser.rb EVEN if ADX logging isn't enabled
@@ -1740,8 +2196,21 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 			Log("Error when reading AdxClientSecret %s", err)
 		}
 
+		// without a client secret, the projected service account token is exchanged when workload identity is configured
+		AdxWorkloadIdentity = false
+		if len(AdxClientSecret) == 0 && isWorkloadIdentityConfigured() {
+			AdxWorkloadIdentity = true
+			if len(AdxClientID) == 0 {
+				AdxClientID = strings.TrimSpace(os.Getenv(envAzureClientID))
+			}
+			if len(AdxTenantID) == 0 {
+				AdxTenantID = strings.TrimSpace(os.Getenv(envAzureTenantID))
+			}
+			Log("Using workload identity for the ADX client")
+		}
+
 		// AdxDatabaseName should never get in a state where its length is 0, but it doesn't hurt to add the check
-		if len(AdxClusterUri) > 0 && len(AdxClientID) > 0 && len(AdxClientSecret) > 0 && len(AdxTenantID) > 0 && len(AdxDatabaseName) > 0 {
+		if len(AdxClusterUri) > 0 && len(AdxClientID) > 0 && (len(AdxClientSecret) > 0 || AdxWorkloadIdentity) && len(AdxTenantID) > 0 && len(AdxDatabaseName) > 0 {
 			ContainerLogsRouteADX = true
 			Log("Routing container logs thru %s route...", ContainerLogsADXRoute)
 			fmt.Fprintf(os.Stdout, "Routing container logs thru %s route...\n", ContainerLogsADXRoute)
@@ -1750,19 +2219,58 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 		ContainerLogsRouteV2 = true  //default is mdsd route
 		if strings.Compare(ContainerLogsRoute, ContainerLogsV1Route) == 0 {
 			ContainerLogsRouteV2 = false  //fallback option when hiddensetting set
+		} else if strings.Compare(ContainerLogsRoute, ContainerLogsGenevaRoute) == 0 {
+			// geneva agent accepts the same msgpack forward stream as mdsd, only the socket and event names differ
+			ContainerLogsRouteGeneva = true
+			GenevaFluentSocketPath = strings.TrimSpace(PluginConfiguration["geneva_fluent_socket_path"])
+			if GenevaFluentSocketPath == "" {
+				GenevaFluentSocketPath = DefaultGenevaFluentSocketPath
+			}
+			Log("Geneva fluent socket path: %s", GenevaFluentSocketPath)
 		}
 		Log("Routing container logs thru %s route...", ContainerLogsRoute)
 		fmt.Fprintf(os.Stdout, "Routing container logs thru %s route... \n", ContainerLogsRoute)
+	} else if strings.Compare(ContainerLogsRoute, ContainerLogsV2Route) == 0 { //for windows, the v2 route is opted in
+		ContainerLogsRouteV2 = initializeWindowsMdsdRoute()
+		if ContainerLogsRouteV2 == true {
+			Log("Routing container logs thru %s route...", ContainerLogsRoute)
+			fmt.Fprintf(os.Stdout, "Routing container logs thru %s route... \n", ContainerLogsRoute)
+		}
 	}
 
+	initializeSinkDeclarations()
+	enforceADXDataBoundary()
+
 	if ContainerLogsRouteV2 == true {
 		CreateMDSDClient(ContainerLogV2, ContainerType)
+		if IsWindows == true {
+			// the kubemonagent events and the telegraf metrics of windows are still posted to ODS
+			CreateHTTPClient()
+			initializeSecondaryWorkspace()
+		}
 	} else if ContainerLogsRouteADX == true {
-		CreateADXClient()
+		// the declared ADX sinks create the clients of their clusters
+		if len(ContainerLogSinkDeclarations) == 0 {
+			CreateADXClient()
+		}
+	} else if ContainerLogsRouteDCR == true || ContainerLogsRouteKafka == true || ContainerLogsRouteEventHubs == true || ContainerLogsRouteOTLP == true {
+		if IsWindows == true {
+			// the kubemonagent events of windows are still posted to ODS
+			CreateHTTPClient()
+			initializeSecondaryWorkspace()
+		}
 	} else { // v1 or windows
 		Log("Creating HTTP Client since either OS Platform is Windows or configmap configured with fallback option for ODS direct")
 		CreateHTTPClient()
+		initializeSecondaryWorkspace()
+		go probeRouteCompression(requestRouteODS, OMSEndpoint)
 	}
+	initializeContainerLogSink()
+	initializeADXMetricsRoute()
+	initializeDCRMetricsRoute()
+	initializeOTLPMetricsRoute()
+	initializeMDMMetrics()
+	initializeEventHubsKubeMonAgentEvents()
 
 	if IsWindows == false { // mdsd linux specific
 		Log("Creating MDSD clients for KubeMonAgentEvents & InsightsMetrics")
@@ -1770,32 +2278,80 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 		CreateMDSDClient(InsightsMetrics, ContainerType)
     }
 
+	// flushes are retried until the route's destination is ready, instead of failing with errors at pod start
+	startDownstreamReadinessGate()
+	startMdsdHealthProbe()
+
 	ContainerLogSchemaVersion := strings.TrimSpace(strings.ToLower(os.Getenv("AZMON_CONTAINER_LOG_SCHEMA_VERSION")))
 	Log("AZMON_CONTAINER_LOG_SCHEMA_VERSION:%s", ContainerLogSchemaVersion)
 
 	ContainerLogSchemaV2 = false  //default is v1 schema
 
-	if strings.Compare(ContainerLogSchemaVersion, ContainerLogV2SchemaVersion) == 0  && ContainerLogsRouteADX != true {
+	// the kafka route always publishes the ContainerLogV2 schema
+	if (strings.Compare(ContainerLogSchemaVersion, ContainerLogV2SchemaVersion) == 0  && ContainerLogsRouteADX != true) || ContainerLogsRouteKafka == true {
 		ContainerLogSchemaV2 = true
 		Log("Container logs schema=%s", ContainerLogV2SchemaVersion)
 		fmt.Fprintf(os.Stdout, "Container logs schema=%s... \n", ContainerLogV2SchemaVersion)
 	}
+	initializeLogAnalyticsLimits()
+	initializeColumnLengthLimits()
+	initializeContainerLogThroughput()
+	initializeJSONFieldPromotion()
+	initializeWorkspaceRouting()
+	initializeTeeRoute()
+	initializeCanaryRoute()
+	initializeMsgpackPassthrough()
+	initializeMdsdForwardOptions()
+	initializeRecordMetadata()
+	initializeAdminAPI()
+	initializeStatsEndpoint()
+	initializeMetricsEndpoint()
 
 	if strings.Compare(strings.ToLower(os.Getenv("CONTROLLER_TYPE")), "daemonset") == 0 {
 		populateExcludedStdoutNamespaces()
 		populateExcludedStderrNamespaces()
-		//enrichment not applicable for ADX and v2 schema
-		if enrichContainerLogs == true && ContainerLogsRouteADX != true && ContainerLogSchemaV2 != true {
+		populateIncludedStdoutNamespaces()
+		populateIncludedStderrNamespaces()
+		//image & name enrichment not applicable for ADX and v2 schema, but pod uid & restart count are added on all routes
+		if enrichContainerLogs == true {
 			Log("ContainerLogEnrichment=true; starting goroutine to update containerimagenamemaps \n")
-			go updateContainerImageNameMaps()
+			initializeContainerMetadataSource()
+			initializeContainerMetadataCache()
+			initializeContainerLabels()
+			if ContainerMetadataSource == containerMetadataSourceCRI {
+				go updateContainerMetadataFromCRI()
+				// the CRI source does not read the pods, so their log collection annotations are read on their own
+				go updatePodLogCollections()
+			} else {
+				go updateContainerImageNameMaps()
+			}
 		} else {
 			Log("ContainerLogEnrichment=false \n")
+			go updatePodLogCollections()
+		}
+
+		if IsWindows == true {
+			fluentdLogFilePath := strings.TrimSpace(pluginConfig["fluentd_log_file_path"])
+			if fluentdLogFilePath == "" {
+				fluentdLogFilePath = defaultWindowsFluentdLogFilePath
+			}
+			Log("Monitoring container log collection failures from %s \n", fluentdLogFilePath)
+			LogCollectionHealthTicker = time.NewTicker(time.Second * time.Duration(logCollectionHealthCheckIntervalSeconds))
+			go monitorLogCollectionHealth(fluentdLogFilePath)
 		}
 
 		// Flush config error records every hour
 		go flushKubeMonAgentEventRecords()
+		startContainerLogThroughputMetrics()
+		startSpoolReplay()
+		startMultilineFlush()
+		startLogRedactionReload()
 	} else {
 		Log("Running in replicaset. Disabling container enrichment caching & updates \n")
+		if NoErrorEventReplicaSetOnly == true {
+			// the replicaset sends the "No errors" record in place of the nodes
+			go flushKubeMonAgentEventRecords()
+		}
 	}
 
 	if ContainerLogSchemaV2 == true {
@@ -1804,12 +2360,30 @@ func InitializePlugin(pluginConfPath string, agentVersion string) {
 	   MdsdContainerLogTagName = MdsdContainerLogSourceName
     }
 
+	if MdsdConfiguredContainerLogSource != "" {
+		MdsdContainerLogTagName = MdsdConfiguredContainerLogSource
+	}
+
 	MdsdInsightsMetricsTagName = MdsdInsightsMetricsSourceName
     MdsdKubeMonAgentEventsTagName = MdsdKubeMonAgentEventsSourceName
+
+	if ContainerLogsRouteGeneva == true {
+		if ContainerLogSchemaV2 == true {
+			MdsdContainerLogTagName = GenevaContainerLogV2EventName
+		} else {
+			MdsdContainerLogTagName = GenevaContainerLogEventName
+		}
+		MdsdInsightsMetricsTagName = GenevaInsightsMetricsEventName
+		MdsdKubeMonAgentEventsTagName = GenevaKubeMonAgentEventsEventName
+		Log("Geneva event names: %s, %s, %s", MdsdContainerLogTagName, MdsdInsightsMetricsTagName, MdsdKubeMonAgentEventsTagName)
+	}
 	Log("ContainerLogsRouteADX: %v, IsWindows: %v, IsAADMSIAuthMode = %v \n", ContainerLogsRouteADX, IsWindows, IsAADMSIAuthMode)
-	if !ContainerLogsRouteADX && IsWindows && IsAADMSIAuthMode {
+	// on linux, the ingestion token is needed only for the ODS direct route since mdsd authenticates the v2 route itself
+	if !ContainerLogsRouteADX && ((IsWindows && IsAADMSIAuthMode) || (IsWorkloadIdentityAuthMode && !ContainerLogsRouteV2)) {
 		Log("defaultIngestionAuthTokenRefreshIntervalSeconds = %d \n", defaultIngestionAuthTokenRefreshIntervalSeconds)
-	    IngestionAuthTokenRefreshTicker = time.NewTicker(time.Second * time.Duration(defaultIngestionAuthTokenRefreshIntervalSeconds))
-		go refreshIngestionAuthToken()
+		initializeODSIngestionTokenProvider()
 	}
+	// after the tag names and the ODS ingestion token are set up
+	startRouteProbes()
+	logEffectiveConfig()
 }
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// settings of an OTLP collector, read like the route settings: AZMON_OTLP_<SETTING> or otlp_<setting> in the plugin
+// configuration for the otlp route, and AZMON_<NAME>_<SETTING> for a declared otlp sink
+const (
+	// the OTLP/gRPC endpoint of the collector as host:port, e.g. otel-collector.monitoring:4317
+	otlpSettingEndpoint = "endpoint"
+	// true to connect without TLS, to a collector of the cluster
+	otlpSettingInsecure = "insecure"
+	// the headers of the export requests as key=value separated by commas, e.g. the api key of a vendor
+	otlpSettingHeaders = "headers"
+)
+
+const (
+	// under the 4 MB default max message size of the gRPC servers
+	otlpMaxExportBytes = 3 * 1000 * 1000
+	otlpExportTimeout  = 30 * time.Second
+)
+
+// the attributes of the container log records, from the semantic conventions of OpenTelemetry
+const (
+	otlpAttributeNamespace     = "k8s.namespace.name"
+	otlpAttributePodName       = "k8s.pod.name"
+	otlpAttributePodUID        = "k8s.pod.uid"
+	otlpAttributeContainerName = "k8s.container.name"
+	otlpAttributeRestartCount  = "k8s.container.restart_count"
+	otlpAttributeContainerID   = "container.id"
+	otlpAttributeImage         = "container.image.name"
+	otlpAttributeLogSource     = "log.iostream"
+	// the ContainerLabels column, json of the labels of the container
+	otlpAttributeContainerLabels = "container.labels"
+	otlpAttributeMetricOrigin    = "azure.monitor.origin"
+)
+
+// otlpConfig the collector the records and metrics are exported to
+type otlpConfig struct {
+	endpoint string
+	insecure bool
+	headers  map[string]string
+	// the TLS config with the client certificate or the CA bundle of the route, TLS 1.2 when nil
+	tlsConfig *tls.Config
+}
+
+var (
+	// ContainerLogsRouteOTLP when true, the container logs are exported to an OTLP collector as log records
+	ContainerLogsRouteOTLP bool
+	// TelegrafMetricsRouteOTLP when true, the telegraf metrics are exported to the collector of the otlp route as gauges
+	TelegrafMetricsRouteOTLP bool
+	// OTLPRouteConfig the collector of the otlp route
+	OTLPRouteConfig otlpConfig
+	// OTLPRouteExporter the exporter of the otlp route, shared by the container logs and the telegraf metrics
+	OTLPRouteExporter *otlpExporter
+)
+
+// otlpSeverities the severity numbers of the LogLevel values
+var otlpSeverities = map[string]logspb.SeverityNumber{
+	logLevelCritical: logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+	logLevelError:    logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	logLevelWarning:  logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+	logLevelInfo:     logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+	logLevelDebug:    logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+	logLevelTrace:    logspb.SeverityNumber_SEVERITY_NUMBER_TRACE,
+}
+
+// newOTLPConfig reads the collector settings of the route or the declared sink
+func newOTLPConfig(name string) (otlpConfig, error) {
+	config := otlpConfig{
+		endpoint: routeSetting(name, otlpSettingEndpoint),
+		insecure: strings.EqualFold(routeSetting(name, otlpSettingInsecure), "true"),
+		headers:  make(map[string]string),
+	}
+	if _, _, err := net.SplitHostPort(config.endpoint); err != nil {
+		return config, fmt.Errorf("invalid endpoint %q, it must be host:port", config.endpoint)
+	}
+	for _, header := range strings.Split(routeSetting(name, otlpSettingHeaders), ",") {
+		if header = strings.TrimSpace(header); header == "" {
+			continue
+		}
+		parts := strings.SplitN(header, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return config, errors.New("the headers are not key=value")
+		}
+		// the keys of the grpc metadata are lower case
+		config.headers[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	if !config.insecure {
+		tlsConfig, err := newRouteDialTLSConfig(name)
+		if err != nil {
+			return config, err
+		}
+		config.tlsConfig = tlsConfig
+	}
+	return config, nil
+}
+
+// initializeOTLPRoute reads the collector of the otlp route, and returns whether the container logs can be sent thru it
+func initializeOTLPRoute() bool {
+	config, err := newOTLPConfig(ContainerLogsOTLPRoute)
+	if err != nil {
+		Log("Error::otlp::Unable to route the container logs thru the %s route: %s", ContainerLogsOTLPRoute, err.Error())
+		return false
+	}
+	OTLPRouteConfig = config
+	OTLPRouteExporter = newOTLPExporter(config)
+	Log("Exporting container logs to the OTLP collector %s, insecure %t", config.endpoint, config.insecure)
+	return true
+}
+
+// initializeOTLPMetricsRoute exports the telegraf metrics to the collector on the otlp route
+func initializeOTLPMetricsRoute() {
+	TelegrafMetricsRouteOTLP = false
+	if ContainerLogsRouteOTLP == false || OTLPRouteExporter == nil {
+		return
+	}
+	TelegrafMetricsRouteOTLP = true
+	Log("Routing telegraf metrics thru the %s route", ContainerLogsOTLPRoute)
+}
+
+// otlpExporter exports to a collector over a gRPC connection, which reconnects by itself after a failure
+type otlpExporter struct {
+	config otlpConfig
+
+	mu   sync.Mutex
+	conn *grpc.ClientConn
+}
+
+func newOTLPExporter(config otlpConfig) *otlpExporter {
+	return &otlpExporter{config: config}
+}
+
+func (e *otlpExporter) getConn() (*grpc.ClientConn, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		return e.conn, nil
+	}
+	tlsConfig := e.config.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	creds := grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	if e.config.insecure {
+		creds = grpc.WithInsecure()
+	}
+	conn, err := grpc.Dial(e.config.endpoint, creds, grpc.WithUserAgent(userAgent))
+	if err != nil {
+		PluginMetrics.addClientCreateError("otlp")
+		return nil, err
+	}
+	e.conn = conn
+	return conn, nil
+}
+
+// export sends a request thru the connection, with the headers of the collector
+func (e *otlpExporter) export(ctx context.Context, dataType string, numRecords int, send func(ctx context.Context, conn *grpc.ClientConn) error) error {
+	conn, err := e.getConn()
+	if err != nil {
+		Log("Error::otlp::Unable to connect to the collector %s: %s", e.config.endpoint, err.Error())
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, otlpExportTimeout)
+	defer cancel()
+	if len(e.config.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.config.headers))
+	}
+	sendStart := time.Now()
+	err = send(ctx, conn)
+	trackFlushDependency(dependencyTypeOTLP, e.config.endpoint, dataType, sendStart, status.Code(err).String(), err == nil, numRecords)
+	if err == nil {
+		return nil
+	}
+	Log("Error::otlp::Failed to export %d %s records to the collector %s: %s", numRecords, dataType, e.config.endpoint, err.Error())
+	if !isRetryableOTLPCode(status.Code(err)) {
+		// the collector won't accept the records when they are sent again
+		return errBatchDropped
+	}
+	return err
+}
+
+// isRetryableOTLPCode whether the export can be retried, the retryable codes of the OTLP specification
+func isRetryableOTLPCode(code codes.Code) bool {
+	switch code {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+		return true
+	}
+	// the errors of the client, e.g. the connection was closed
+	return code == codes.Unknown
+}
+
+// exportLogs exports the log records, in requests under the max export size
+func (e *otlpExporter) exportLogs(ctx context.Context, dataType string, records []*logspb.LogRecord) error {
+	for _, chunk := range sizeChunks(len(records), func(i int) int { return proto.Size(records[i]) }, otlpMaxExportBytes) {
+		request := &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: otlpResource(),
+			InstrumentationLibraryLogs: []*logspb.InstrumentationLibraryLogs{{
+				InstrumentationLibrary: otlpInstrumentationLibrary(),
+				Logs:                   records[chunk[0]:chunk[1]],
+			}},
+		}}}
+		err := e.export(ctx, dataType, chunk[1]-chunk[0], func(ctx context.Context, conn *grpc.ClientConn) error {
+			_, err := collogspb.NewLogsServiceClient(conn).Export(ctx, request)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportMetrics exports the metrics, in requests under the max export size. The requests exported by an earlier
+// delivery of the chunk with the batch id are not exported again
+func (e *otlpExporter) exportMetrics(ctx context.Context, metrics []*metricspb.Metric, batchID string) (int, error) {
+	exported := 0
+	for _, chunk := range sizeChunks(len(metrics), func(i int) int { return proto.Size(metrics[i]) }, otlpMaxExportBytes) {
+		request := &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: otlpResource(),
+			InstrumentationLibraryMetrics: []*metricspb.InstrumentationLibraryMetrics{{
+				InstrumentationLibrary: otlpInstrumentationLibrary(),
+				Metrics:                metrics[chunk[0]:chunk[1]],
+			}},
+		}}}
+		err := sendUnsentPayload("PostTelegrafMetricsToLA", ContainerLogsOTLPRoute, batchID, chunk[0], chunk[1], func() error {
+			return e.export(ctx, InsightsMetricsDataType, chunk[1]-chunk[0], func(ctx context.Context, conn *grpc.ClientConn) error {
+				_, err := colmetricspb.NewMetricsServiceClient(conn).Export(ctx, request)
+				return err
+			})
+		})
+		if err != nil {
+			return exported, err
+		}
+		exported += chunk[1] - chunk[0]
+	}
+	return exported, nil
+}
+
+// sizeChunks splits the items in ranges [start, end) under the max size, an item over the max size is in a range of
+// its own
+func sizeChunks(count int, size func(i int) int, maxBytes int) [][2]int {
+	var chunks [][2]int
+	start, bytes := 0, 0
+	for i := 0; i < count; i++ {
+		itemBytes := size(i)
+		if i > start && bytes+itemBytes > maxBytes {
+			chunks = append(chunks, [2]int{start, i})
+			start, bytes = i, 0
+		}
+		bytes += itemBytes
+	}
+	if count > start {
+		chunks = append(chunks, [2]int{start, count})
+	}
+	return chunks
+}
+
+// otlpResource the resource of the records and metrics, the node and the cluster of the agent
+func otlpResource() *resourcepb.Resource {
+	attributes := []*commonpb.KeyValue{otlpStringAttribute("service.name", agentName), otlpStringAttribute("host.name", Computer)}
+	if ResourceName != "" {
+		attributes = append(attributes, otlpStringAttribute("k8s.cluster.name", ResourceName))
+	}
+	if ResourceID != "" {
+		attributes = append(attributes, otlpStringAttribute("cloud.resource_id", ResourceID))
+	}
+	return &resourcepb.Resource{Attributes: attributes}
+}
+
+func otlpInstrumentationLibrary() *commonpb.InstrumentationLibrary {
+	return &commonpb.InstrumentationLibrary{Name: agentName, Version: dockerCimprovVersion}
+}
+
+func otlpStringValue(value string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}
+}
+
+func otlpStringAttribute(key string, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: otlpStringValue(value)}
+}
+
+// appendOTLPAttribute adds the attribute when the column has a value, the empty columns are not exported
+func appendOTLPAttribute(attributes []*commonpb.KeyValue, key string, value string) []*commonpb.KeyValue {
+	if value == "" {
+		return attributes
+	}
+	return append(attributes, otlpStringAttribute(key, value))
+}
+
+// otlpTimeUnixNano returns the time of a column in nanoseconds, 0 (unknown) when it can't be parsed
+func otlpTimeUnixNano(value string) uint64 {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || t.UnixNano() < 0 {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// newOTLPLogRecordV2 maps a ContainerLogV2 record to a log record, the log line is the body
+func newOTLPLogRecordV2(item *DataItemLAv2) *logspb.LogRecord {
+	var attributes []*commonpb.KeyValue
+	attributes = appendOTLPAttribute(attributes, otlpAttributeNamespace, item.PodNamespace)
+	attributes = appendOTLPAttribute(attributes, otlpAttributePodName, item.PodName)
+	attributes = appendOTLPAttribute(attributes, otlpAttributePodUID, item.PodUid)
+	attributes = appendOTLPAttribute(attributes, otlpAttributeContainerName, item.ContainerName)
+	attributes = appendOTLPAttribute(attributes, otlpAttributeContainerID, item.ContainerId)
+	attributes = appendOTLPAttribute(attributes, otlpAttributeRestartCount, item.RestartCount)
+	attributes = appendOTLPAttribute(attributes, otlpAttributeLogSource, item.LogSource)
+	attributes = appendOTLPAttribute(attributes, otlpAttributeContainerLabels, item.ContainerLabels)
+	return &logspb.LogRecord{
+		TimeUnixNano:   otlpTimeUnixNano(item.TimeGenerated),
+		SeverityNumber: otlpSeverities[item.LogLevel],
+		SeverityText:   item.LogLevel,
+		Body:           otlpStringValue(item.LogMessage),
+		Attributes:     attributes,
+	}
+}
+
+// newOTLPLogRecordV1 maps a ContainerLog record to a log record. The Name column is the pod uid/container name
+func newOTLPLogRecordV1(item *DataItemLAv1) *logspb.LogRecord {
+	containerName := item.Name
+	if i := strings.LastIndex(containerName, "/"); i >= 0 {
+		containerName = containerName[i+1:]
+	}
+	var attributes []*commonpb.KeyValue
+	attributes = appendOTLPAttribute(attributes, otlpAttributeContainerName, containerName)
+	attributes = appendOTLPAttribute(attributes, otlpAttributeContainerID, item.ID)
+	attributes = appendOTLPAttribute(attributes, otlpAttributeImage, item.Image)
+	attributes = appendOTLPAttribute(attributes, otlpAttributeLogSource, item.LogEntrySource)
+	attributes = appendOTLPAttribute(attributes, otlpAttributeContainerLabels, item.ContainerLabels)
+	return &logspb.LogRecord{
+		TimeUnixNano:   otlpTimeUnixNano(item.LogEntryTimeStamp),
+		SeverityNumber: otlpSeverities[item.LogLevel],
+		SeverityText:   item.LogLevel,
+		Body:           otlpStringValue(item.LogEntry),
+		Attributes:     attributes,
+	}
+}
+
+// newOTLPMetric maps a telegraf metric to a gauge named <namespace>_<name>, with the tags as attributes
+func newOTLPMetric(metric *laTelegrafMetric) *metricspb.Metric {
+	var tags map[string]string
+	if err := json.Unmarshal([]byte(metric.Tags), &tags); err != nil {
+		// the tags were cut to the max length of the column
+		tags = nil
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attributes := []*commonpb.KeyValue{otlpStringAttribute(otlpAttributeMetricOrigin, metric.Origin)}
+	for _, key := range keys {
+		attributes = append(attributes, otlpStringAttribute(key, tags[key]))
+	}
+	return &metricspb.Metric{
+		Name: metric.Namespace + "_" + metric.Name,
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{
+			Attributes:   attributes,
+			TimeUnixNano: otlpTimeUnixNano(metric.CollectionTime),
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: metric.Value},
+		}}}},
+	}
+}
+
+// otlpSink exports the records to an OTLP collector as log records
+type otlpSink struct {
+	sinkHealth
+	name     string
+	exporter *otlpExporter
+}
+
+// newDeclaredOTLPSink creates an OTLP sink from the endpoint, insecure and headers settings of the sink
+func newDeclaredOTLPSink(name string) (Sink, error) {
+	config, err := newOTLPConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	return &otlpSink{name: name, exporter: newOTLPExporter(config)}, nil
+}
+
+func (s *otlpSink) Name() string { return s.name }
+
+func (s *otlpSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	return s.setSendResult(s.send(ctx, batch))
+}
+
+func (s *otlpSink) send(ctx context.Context, batch *containerLogBatch) error {
+	records := make([]*logspb.LogRecord, 0, batch.len())
+	for i := range batch.dataItemsLAv2 {
+		records = append(records, newOTLPLogRecordV2(&batch.dataItemsLAv2[i]))
+	}
+	for i := range batch.dataItemsLAv1 {
+		records = append(records, newOTLPLogRecordV1(&batch.dataItemsLAv1[i]))
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if err := s.exporter.exportLogs(ctx, getContainerLogsDataType(), records); err != nil {
+		return err
+	}
+	Log("Success::otlp::Exported %d container log records to the collector %s in %s", len(records), s.exporter.config.endpoint, time.Since(batch.start))
+	return nil
+}
+
+// sendTelegrafMetricsToOTLP exports the metrics to the collector of the route
+func sendTelegrafMetricsToOTLP(ctx context.Context, laMetrics []*laTelegrafMetric, batchID string) int {
+	start := time.Now()
+	metrics := make([]*metricspb.Metric, 0, len(laMetrics))
+	for _, laMetric := range laMetrics {
+		metrics = append(metrics, newOTLPMetric(laMetric))
+	}
+	exported, err := OTLPRouteExporter.exportMetrics(ctx, metrics, batchID)
+	if exported > 0 {
+		UpdateNumTelegrafMetricsSentTelemetry(exported, 0, 0)
+	}
+	if err == errBatchDropped {
+		UpdateNumTelegrafMetricsSentTelemetry(0, 1, 0)
+		return output.FLB_OK
+	} else if err != nil {
+		UpdateNumTelegrafMetricsSentTelemetry(0, 1, 0)
+		Log("PostTelegrafMetricsToLA::Error:(retriable) when exporting %d metrics to the collector %s: %s", len(metrics), OTLPRouteConfig.endpoint, err.Error())
+		return output.FLB_RETRY
+	}
+	Log("PostTelegrafMetricsToLA::Info:Successfully exported %d metrics to the collector %s in %s", len(metrics), OTLPRouteConfig.endpoint, time.Since(start))
+	return output.FLB_OK
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_newOTLPConfig(t *testing.T) {
+	type test_struct struct {
+		testName     string
+		settings     map[string]string
+		wantErr      bool
+		wantInsecure bool
+		wantHeaders  map[string]string
+	}
+
+	tests := []test_struct{
+		{"tls", map[string]string{"otlp_endpoint": "collector.example.com:4317"}, false, false, map[string]string{}},
+		{"insecure", map[string]string{"otlp_endpoint": "otel-collector.monitoring:4317", "otlp_insecure": "True"}, false, true, map[string]string{}},
+		{"headers", map[string]string{"otlp_endpoint": "collector.example.com:4317", "otlp_headers": "Api-Key=key1, x-tenant=tenant1"}, false, false, map[string]string{"api-key": "key1", "x-tenant": "tenant1"}},
+		{"no port", map[string]string{"otlp_endpoint": "collector.example.com"}, true, false, nil},
+		{"no endpoint", map[string]string{}, true, false, nil},
+		{"invalid header", map[string]string{"otlp_endpoint": "collector.example.com:4317", "otlp_headers": "key1"}, true, false, nil},
+	}
+
+	defer func() { PluginConfiguration = nil }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			PluginConfiguration = tt.settings
+			config, err := newOTLPConfig(ContainerLogsOTLPRoute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newOTLPConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (config.endpoint != tt.settings["otlp_endpoint"] || config.insecure != tt.wantInsecure || !reflect.DeepEqual(config.headers, tt.wantHeaders)) {
+				t.Errorf("newOTLPConfig() = %+v", config)
+			}
+		})
+	}
+}
+
+func Test_sizeChunks(t *testing.T) {
+	type test_struct struct {
+		testName string
+		sizes    []int
+		maxBytes int
+		want     [][2]int
+	}
+
+	tests := []test_struct{
+		{"empty", nil, 10, nil},
+		{"one chunk", []int{3, 3, 3}, 10, [][2]int{{0, 3}}},
+		{"split", []int{4, 4, 4, 4}, 10, [][2]int{{0, 2}, {2, 4}}},
+		{"oversized item", []int{2, 20, 2}, 10, [][2]int{{0, 1}, {1, 2}, {2, 3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			got := sizeChunks(len(tt.sizes), func(i int) int { return tt.sizes[i] }, tt.maxBytes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sizeChunks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newOTLPLogRecordV2(t *testing.T) {
+	item := &DataItemLAv2{
+		TimeGenerated: "2021-06-01T10:00:00.5Z",
+		ContainerId:   "container1",
+		ContainerName: "app",
+		PodName:       "pod1",
+		PodNamespace:  "default",
+		LogMessage:    "line1",
+		LogSource:     "stderr",
+		LogLevel:      logLevelError,
+	}
+	record := newOTLPLogRecordV2(item)
+	if record.TimeUnixNano != 1622541600500000000 || record.SeverityText != logLevelError || record.Body.GetStringValue() != "line1" {
+		t.Errorf("newOTLPLogRecordV2() = %v", record)
+	}
+	attributes := make(map[string]string)
+	for _, attribute := range record.Attributes {
+		attributes[attribute.Key] = attribute.Value.GetStringValue()
+	}
+	want := map[string]string{
+		otlpAttributeNamespace:     "default",
+		otlpAttributePodName:       "pod1",
+		otlpAttributeContainerName: "app",
+		otlpAttributeContainerID:   "container1",
+		otlpAttributeLogSource:     "stderr",
+	}
+	if !reflect.DeepEqual(attributes, want) {
+		t.Errorf("newOTLPLogRecordV2() attributes = %v, want %v", attributes, want)
+	}
+}
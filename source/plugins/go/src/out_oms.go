@@ -55,6 +55,15 @@ func FLBPluginFlush(data unsafe.Pointer, length C.int, tag *C.char) int {
 	var record map[interface{}]interface{}
 	var records []map[interface{}]interface{}
 
+	incomingTag := strings.ToLower(C.GoString(tag))
+	// the chunk is only valid during the flush, the passthrough copies what it keeps
+	chunk := (*[1 << 30]byte)(data)[:int(length):int(length)]
+	if MdsdMsgpackPassthrough && !strings.Contains(incomingTag, "oms.container.log.flbplugin") && !strings.Contains(incomingTag, "oms.container.perf.telegraf") {
+		if retCode, ok := PostContainerLogChunk(chunk); ok {
+			return retCode
+		}
+	}
+
 	// Create Fluent Bit decoder
 	dec := output.NewDecoder(data, int(length))
 
@@ -68,21 +77,22 @@ func FLBPluginFlush(data unsafe.Pointer, length C.int, tag *C.char) int {
 		records = append(records, record)
 	}
 
-	incomingTag := strings.ToLower(C.GoString(tag))
 	if strings.Contains(incomingTag, "oms.container.log.flbplugin") {
 		// This will also include populating cache to be sent as for config events
 		return PushToAppInsightsTraces(records, appinsights.Information, incomingTag)
 	} else if strings.Contains(incomingTag, "oms.container.perf.telegraf") {
-		return PostTelegrafMetricsToLA(records)
+		return PostTelegrafMetricsToLA(records, flushChunkID(chunk))
 	}
 
-	return PostDataHelper(records)
+	return PostDataHelper(records, flushChunkID(chunk))
 }
 
 // FLBPluginExit exits the plugin
 func FLBPluginExit() int {
 	ContainerLogTelemetryTicker.Stop()
 	ContainerImageNameRefreshTicker.Stop()
+	close(podInformerStopCh)
+	flushHeldMultilineEntries()
 	return output.FLB_OK
 }
 
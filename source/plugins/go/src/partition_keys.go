@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+)
+
+// route of the streaming sinks (Event Hubs, Kafka), the partition key is read from AZMON_STREAMING_PARTITION_KEY or
+// streaming_partition_key
+const streamingRoute = "streaming"
+
+// partition keys of the records sent to the streaming sinks. The records with the same key go to the same partition,
+// so they are consumed in order. Without a key, the records are spread evenly over the partitions
+const (
+	partitionKeyNone      = "none"
+	partitionKeyPod       = "pod"
+	partitionKeyNamespace = "namespace"
+	partitionKeyContainer = "container"
+)
+
+var (
+	// StreamingPartitionKey the record field the streaming sinks partition on
+	StreamingPartitionKey = partitionKeyNone
+	// next partition of the records without a key
+	roundRobinPartition uint32
+)
+
+// initializePartitionKeying reads the partition key of the streaming sinks
+func initializePartitionKeying() {
+	StreamingPartitionKey = partitionKeyNone
+	value := routeSetting(streamingRoute, "partition_key")
+	if value == "" {
+		return
+	}
+	key, err := parsePartitionKey(value)
+	if err != nil {
+		Log("Error::partition::Ignoring the partition key of the %s route, the records are spread evenly over the partitions: %s", streamingRoute, err.Error())
+		return
+	}
+	StreamingPartitionKey = key
+	Log("Partition key of the %s route = %s", streamingRoute, StreamingPartitionKey)
+}
+
+func parsePartitionKey(value string) (string, error) {
+	switch key := strings.ToLower(strings.TrimSpace(value)); key {
+	case partitionKeyNone, partitionKeyPod, partitionKeyNamespace, partitionKeyContainer:
+		return key, nil
+	}
+	return "", fmt.Errorf("unknown partition key %s, expected one of none, pod, namespace or container", value)
+}
+
+// recordPartitionKey returns the partition key of a container log record in the v1 or v2 schema, empty when the
+// records are not keyed or the record doesn't have the field
+func recordPartitionKey(key string, record map[string]string) string {
+	switch key {
+	case partitionKeyPod:
+		if record["PodNamespace"] == "" || record["PodName"] == "" {
+			return ""
+		}
+		return record["PodNamespace"] + "/" + record["PodName"]
+	case partitionKeyNamespace:
+		return record["PodNamespace"]
+	case partitionKeyContainer:
+		if id := record["ContainerId"]; id != "" {
+			return id
+		}
+		return record["Id"]
+	}
+	return ""
+}
+
+// partitionForKey returns the partition of the key, hashed so the same key always gets the same partition. The
+// records without a key go round robin over the partitions
+func partitionForKey(key string, partitions int) int {
+	if partitions <= 1 {
+		return 0
+	}
+	if key == "" {
+		return int((atomic.AddUint32(&roundRobinPartition, 1) - 1) % uint32(partitions))
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(partitions))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_recordPartitionKey(t *testing.T) {
+	type test_struct struct {
+		testname string
+		key      string
+		record   map[string]string
+		want     string
+	}
+
+	v2Record := map[string]string{"PodNamespace": "kube-apps", "PodName": "web-0", "ContainerId": "0123456789abcdef"}
+	tests := []test_struct{
+		{"pod", partitionKeyPod, v2Record, "kube-apps/web-0"},
+		{"namespace", partitionKeyNamespace, v2Record, "kube-apps"},
+		{"container v2 schema", partitionKeyContainer, v2Record, "0123456789abcdef"},
+		{"container v1 schema", partitionKeyContainer, map[string]string{"Id": "fedcba9876543210"}, "fedcba9876543210"},
+		{"pod without pod name", partitionKeyPod, map[string]string{"PodNamespace": "kube-apps"}, ""},
+		{"none", partitionKeyNone, v2Record, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := recordPartitionKey(tt.key, tt.record); got != tt.want {
+				t.Errorf("recordPartitionKey() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parsePartitionKey(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		want     string
+		wantErr  bool
+	}
+
+	tests := []test_struct{
+		{"pod", "pod", partitionKeyPod, false},
+		{"case and spaces", " Container ", partitionKeyContainer, false},
+		{"unknown", "node", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := parsePartitionKey(tt.value)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("parsePartitionKey() = %s, %v, want %s, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_partitionForKey(t *testing.T) {
+	const partitions = 4
+
+	if first, second := partitionForKey("kube-apps/web-0", partitions), partitionForKey("kube-apps/web-0", partitions); first != second {
+		t.Errorf("partitionForKey() = %d and %d for the same key, want the same partition", first, second)
+	}
+
+	counts := make([]int, partitions)
+	for i := 0; i < 4*partitions; i++ {
+		counts[partitionForKey("", partitions)]++
+	}
+	for partition, count := range counts {
+		if count != 4 {
+			t.Errorf("partition %d got %d records without a key, want 4", partition, count)
+		}
+	}
+
+	if got := partitionForKey("kube-apps/web-0", 1); got != 0 {
+		t.Errorf("partitionForKey() = %d with a single partition, want 0", got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PluginErrorEventCategory is the KubeMonAgentEvent category for the errors of the plugin's runtime log
+const PluginErrorEventCategory = "container.azm.ms/pluginerror"
+
+// env variable of the areas of the runtime log errors (Error::<area>::) sent as KubeMonAgentEvents, separated by commas
+const envPluginErrorEventAreas = "AZMON_KUBEMON_PLUGIN_ERROR_AREAS"
+
+const defaultPluginErrorEventAreas = "mdsd,adx,ods,token,dcr,kafka,eventhubs,otlp,mdm"
+
+const (
+	// the max number of distinct errors kept between two flushes, the errors over it are counted in the last one
+	maxPluginErrorEvents = 100
+	// the max length of the message of an error
+	maxPluginErrorEventMessageLength = 1024
+	pluginErrorEventsOverflowMessage = "Other errors of the plugin"
+)
+
+// the area of the runtime log errors, Error::<area>::<message>
+var pluginErrorLineRegex = regexp.MustCompile(`^Error::([A-Za-z]+)::\s*(.*)$`)
+
+// the parts of the messages which change between the occurrences of an error, replaced for the errors to be deduped
+var pluginErrorVariableRegex = regexp.MustCompile(`[0-9]+(\.[0-9]+)?(ns|µs|ms|s|m|h)?`)
+
+var (
+	// PluginErrorEventAreas the areas of the runtime log errors sent as KubeMonAgentEvents
+	PluginErrorEventAreas = make(map[string]bool)
+	// PluginErrorEvent hash of the runtime log errors since the last KubeMonAgentEvents flush. It has its own mutex since
+	// the plugin logs while holding EventHashUpdateMutex
+	PluginErrorEvent      = make(map[string]KubeMonAgentEventTags)
+	pluginErrorEventMutex = &sync.Mutex{}
+)
+
+// initializePluginErrorEvents reads the areas of the runtime log errors sent as KubeMonAgentEvents
+func initializePluginErrorEvents() {
+	value := strings.TrimSpace(os.Getenv(envPluginErrorEventAreas))
+	if value == "" {
+		value = defaultPluginErrorEventAreas
+	}
+	areas := make(map[string]bool)
+	for _, area := range strings.Split(value, ",") {
+		area = strings.ToLower(strings.TrimSpace(area))
+		if area != "" {
+			areas[area] = true
+		}
+	}
+	pluginErrorEventMutex.Lock()
+	PluginErrorEventAreas = areas
+	PluginErrorEvent = make(map[string]KubeMonAgentEventTags)
+	pluginErrorEventMutex.Unlock()
+	Log("Runtime log errors of the areas %v are sent as KubeMonAgentEvents", areas)
+}
+
+// trackPluginErrors returns the log function which also adds the errors of the log lines to the KubeMonAgentEvents
+func trackPluginErrors(logf func(format string, v ...interface{})) func(format string, v ...interface{}) {
+	return func(format string, v ...interface{}) {
+		logf(format, v...)
+		if strings.Contains(format, "Error::") {
+			addPluginErrorEvent(fmt.Sprintf(format, v...), time.Now())
+		}
+	}
+}
+
+// addPluginErrorEvent adds the error of a runtime log line, the occurrences of an error differing only in their
+// numbers and durations being deduped
+func addPluginErrorEvent(line string, now time.Time) {
+	match := pluginErrorLineRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return
+	}
+	area := strings.ToLower(match[1])
+	message := pluginErrorVariableRegex.ReplaceAllString(match[2], "#")
+	if len(message) > maxPluginErrorEventMessageLength {
+		message = message[:maxPluginErrorEventMessageLength]
+	}
+	message = fmt.Sprintf("Error::%s::%s", match[1], message)
+	eventTimeStamp := now.UTC().Format(time.RFC3339)
+
+	pluginErrorEventMutex.Lock()
+	defer pluginErrorEventMutex.Unlock()
+	if !PluginErrorEventAreas[area] {
+		return
+	}
+	val, ok := PluginErrorEvent[message]
+	if !ok && len(PluginErrorEvent) >= maxPluginErrorEvents {
+		message = pluginErrorEventsOverflowMessage
+		val, ok = PluginErrorEvent[message]
+	}
+	if ok {
+		val.LastOccurrence = eventTimeStamp
+		val.Count = val.Count + 1
+		PluginErrorEvent[message] = val
+	} else {
+		PluginErrorEvent[message] = KubeMonAgentEventTags{
+			FirstOccurrence: eventTimeStamp,
+			LastOccurrence:  eventTimeStamp,
+			Count:           1,
+		}
+	}
+}
+
+// takePluginErrorEvents returns the runtime log errors since the last flush and clears them
+func takePluginErrorEvents() map[string]KubeMonAgentEventTags {
+	pluginErrorEventMutex.Lock()
+	defer pluginErrorEventMutex.Unlock()
+	events := PluginErrorEvent
+	PluginErrorEvent = make(map[string]KubeMonAgentEventTags)
+	return events
+}
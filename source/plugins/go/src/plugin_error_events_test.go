@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_addPluginErrorEvent(t *testing.T) {
+	type test_struct struct {
+		testname string
+		areas    string
+		lines    []string
+		output   map[string]int
+	}
+
+	tests := []test_struct{
+		{
+			"deduped mdsd write errors",
+			"",
+			[]string{
+				"Error::mdsd::Failed to write to mdsd 12 records after 1.5s. Will retry ... error : broken pipe",
+				"Error::mdsd::Failed to write to mdsd 300 records after 250ms. Will retry ... error : broken pipe",
+			},
+			map[string]int{"Error::mdsd::Failed to write to mdsd # records after #. Will retry ... error : broken pipe": 2},
+		},
+		{
+			"client create errors",
+			"",
+			[]string{
+				"Error::ADX::Unable to create ADX client for the adx sink. Please check error log.",
+				"Error::mdsd::Unable to create mdsd client for insights metrics. Please check error log.",
+			},
+			map[string]int{
+				"Error::ADX::Unable to create ADX client for the adx sink. Please check error log.":       1,
+				"Error::mdsd::Unable to create mdsd client for insights metrics. Please check error log.": 1,
+			},
+		},
+		{
+			"areas not sent",
+			"",
+			[]string{"Error::stats::Unable to listen", "Info::mdsd::Successfully flushed", "mdsd Error::mdsd:: in the middle"},
+			map[string]int{},
+		},
+		{
+			"configured areas",
+			"stats, MDSD",
+			[]string{"Error::stats::Unable to listen", "Error::ADX::Unable to create the ADX ingestor"},
+			map[string]int{"Error::stats::Unable to listen": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			os.Setenv(envPluginErrorEventAreas, tt.areas)
+			defer os.Unsetenv(envPluginErrorEventAreas)
+			initializePluginErrorEvents()
+			now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+			for _, line := range tt.lines {
+				addPluginErrorEvent(line, now)
+			}
+			events := takePluginErrorEvents()
+			if len(events) != len(tt.output) {
+				t.Errorf("got %d events %v, want %d", len(events), events, len(tt.output))
+			}
+			for message, count := range tt.output {
+				if events[message].Count != count {
+					t.Errorf("count of %q = %d, want %d", message, events[message].Count, count)
+				}
+			}
+			if len(takePluginErrorEvents()) != 0 {
+				t.Errorf("the events are not cleared once taken")
+			}
+		})
+	}
+}
+
+func Test_addPluginErrorEventOverflow(t *testing.T) {
+	os.Unsetenv(envPluginErrorEventAreas)
+	initializePluginErrorEvents()
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxPluginErrorEvents+10; i++ {
+		addPluginErrorEvent(fmt.Sprintf("Error::mdsd::Unable to connect to socket-%c%c", 'a'+i/26, 'a'+i%26), now)
+	}
+	events := takePluginErrorEvents()
+	if len(events) != maxPluginErrorEvents+1 {
+		t.Errorf("got %d events, want %d", len(events), maxPluginErrorEvents+1)
+	}
+	if events[pluginErrorEventsOverflowMessage].Count != 10 {
+		t.Errorf("count of the overflow = %d, want 10", events[pluginErrorEventsOverflowMessage].Count)
+	}
+}
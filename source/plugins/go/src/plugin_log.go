@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+// env variable of the format of the plugin's runtime log, text (default) or json
+const envPluginLogFormat = "AZMON_PLUGIN_LOG_FORMAT"
+
+// env variable of the min level of the lines of the runtime log, debug, info (default), warn or error
+const envPluginLogLevel = "AZMON_PLUGIN_LOG_LEVEL"
+
+// env variable of the file the level of the runtime log is read again from on SIGHUP, the plugin-log-level key of
+// the configmap by default
+const envPluginLogLevelPath = "AZMON_PLUGIN_LOG_LEVEL_PATH"
+
+const defaultPluginLogLevelPath = "/etc/config/settings/plugin-log-level"
+
+// settings of the plugin conf for the rotation of the runtime log, and its level when the env variable is not set
+const (
+	pluginLogMaxSizeMBSetting  = "log_max_size_mb"
+	pluginLogMaxBackupsSetting = "log_max_backups"
+	pluginLogMaxAgeDaysSetting = "log_max_age_days"
+	pluginLogLevelSetting      = "log_level"
+)
+
+const (
+	defaultPluginLogMaxSizeMB  = 10
+	defaultPluginLogMaxBackups = 1
+	defaultPluginLogMaxAgeDays = 28
+)
+
+const (
+	pluginLogFormatText = "text"
+	pluginLogFormatJSON = "json"
+)
+
+// the levels of the lines of the runtime log, in increasing order
+const (
+	pluginLogLevelDebug int32 = iota
+	pluginLogLevelInfo
+	pluginLogLevelWarn
+	pluginLogLevelError
+)
+
+var pluginLogLevelNames = map[int32]string{
+	pluginLogLevelDebug: "debug",
+	pluginLogLevelInfo:  "info",
+	pluginLogLevelWarn:  "warn",
+	pluginLogLevelError: "error",
+}
+
+// the level and the component of the lines, <Level>::<component>:: or <Function>::<Level>::. The lines without a level
+// are info lines
+var pluginLogLineRegex = regexp.MustCompile(`^(?:([A-Za-z]+)::?\s*)?(Error|Warning|Info|Success|Debug)\b(?:::([A-Za-z]+)::)?`)
+
+// the request id of the lines of the HTTP senders, RequestId <uuid>
+var pluginLogRequestIDRegex = regexp.MustCompile(`(?i)\bRequest ?Id:? ([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})`)
+
+// pluginLogFields the fields of a line of the structured runtime log. The component and the request id are parsed
+// from the line when not set
+type pluginLogFields struct {
+	Component string
+	Route     string
+	RequestID string
+}
+
+// pluginLogLine a line of the structured runtime log
+type pluginLogLine struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Component string `json:"component,omitempty"`
+	Route     string `json:"route,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	Message   string `json:"message"`
+}
+
+// pluginLogger writes the lines of the runtime log at or over its level, as printf lines or as json. The format and
+// the level can change while the plugin logs
+type pluginLogger struct {
+	logger *log.Logger
+	json   int32
+	level  int32
+}
+
+func newPluginLogger(logger *log.Logger) *pluginLogger {
+	return &pluginLogger{logger: logger, level: pluginLogLevelInfo}
+}
+
+var (
+	// PluginLogRotation the rotation of the file of the runtime log
+	PluginLogRotation *lumberjack.Logger
+	// the level of the env variable or the plugin conf, set again on SIGHUP when the level file does not exist
+	pluginLogConfiguredLevel = pluginLogLevelNames[pluginLogLevelInfo]
+)
+
+// initializePluginLog reads the format and the level of the runtime log, and reads the level again on SIGHUP
+func initializePluginLog() {
+	format := strings.ToLower(strings.TrimSpace(os.Getenv(envPluginLogFormat)))
+	switch format {
+	case "", pluginLogFormatText:
+		atomic.StoreInt32(&PluginLogger.json, 0)
+	case pluginLogFormatJSON:
+		atomic.StoreInt32(&PluginLogger.json, 1)
+	default:
+		Log("Invalid value %s for %s, using the %s format", format, envPluginLogFormat, pluginLogFormatText)
+	}
+	if value := strings.TrimSpace(os.Getenv(envPluginLogLevel)); value != "" {
+		if !PluginLogger.setLevel(value) {
+			Log("Invalid value %s for %s, using the %s level", value, envPluginLogLevel, PluginLogger.levelName())
+		}
+	}
+	pluginLogConfiguredLevel = PluginLogger.levelName()
+	Log("The runtime log is written at the %s level", PluginLogger.levelName())
+
+	levelPath := strings.TrimSpace(os.Getenv(envPluginLogLevelPath))
+	if levelPath == "" {
+		levelPath = defaultPluginLogLevelPath
+	}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			reloadPluginLogLevel(levelPath)
+		}
+	}()
+}
+
+// configurePluginLog applies the rotation of the runtime log of the plugin conf, and its level when the env variable
+// is not set
+func configurePluginLog(pluginConfig map[string]string) {
+	maxSizeMB := readPluginLogSetting(pluginConfig, pluginLogMaxSizeMBSetting, defaultPluginLogMaxSizeMB, 1)
+	maxBackups := readPluginLogSetting(pluginConfig, pluginLogMaxBackupsSetting, defaultPluginLogMaxBackups, 0)
+	maxAgeDays := readPluginLogSetting(pluginConfig, pluginLogMaxAgeDaysSetting, defaultPluginLogMaxAgeDays, 0)
+	if PluginLogRotation != nil && (maxSizeMB != PluginLogRotation.MaxSize || maxBackups != PluginLogRotation.MaxBackups || maxAgeDays != PluginLogRotation.MaxAge) {
+		rotation := &lumberjack.Logger{
+			Filename:   PluginLogRotation.Filename,
+			MaxSize:    maxSizeMB,
+			MaxBackups: maxBackups,
+			MaxAge:     maxAgeDays,
+			Compress:   PluginLogRotation.Compress,
+		}
+		FLBLogger.SetOutput(rotation)
+		PluginLogRotation.Close()
+		PluginLogRotation = rotation
+	}
+	Log("The runtime log is rotated at %d MB, keeping %d backups for %d days (0 for no limit)", maxSizeMB, maxBackups, maxAgeDays)
+
+	value := strings.TrimSpace(pluginConfig[pluginLogLevelSetting])
+	if value == "" || strings.TrimSpace(os.Getenv(envPluginLogLevel)) != "" {
+		return
+	}
+	if !PluginLogger.setLevel(value) {
+		Log("Invalid value %s for %s, using the %s level", value, pluginLogLevelSetting, PluginLogger.levelName())
+		return
+	}
+	pluginLogConfiguredLevel = PluginLogger.levelName()
+	Log("The runtime log is written at the %s level", PluginLogger.levelName())
+}
+
+// readPluginLogSetting returns the value of the setting of the plugin conf, the default when it is not set or under min
+func readPluginLogSetting(pluginConfig map[string]string, setting string, defaultValue int, min int) int {
+	value := strings.TrimSpace(pluginConfig[setting])
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < min {
+		Log("Invalid value %s for %s, using the default of %d", value, setting, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// reloadPluginLogLevel sets the level of the runtime log to the level in the file, the level of the env variable or the
+// plugin conf when the file does not exist
+func reloadPluginLogLevel(levelPath string) {
+	value := pluginLogConfiguredLevel
+	if content, err := ioutil.ReadFile(levelPath); err == nil {
+		value = strings.TrimSpace(string(content))
+	} else if !os.IsNotExist(err) {
+		Log("Error::config::Unable to read the runtime log level from %s: %s", levelPath, err.Error())
+		return
+	}
+	if !PluginLogger.setLevel(value) {
+		Log("Error::config::Invalid runtime log level %s in %s, keeping the %s level", value, levelPath, PluginLogger.levelName())
+		return
+	}
+	Log("The runtime log is written at the %s level", PluginLogger.levelName())
+}
+
+// setLevel sets the level of the runtime log, false when the level is not known
+func (l *pluginLogger) setLevel(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "warning" {
+		value = pluginLogLevelNames[pluginLogLevelWarn]
+	}
+	for level, name := range pluginLogLevelNames {
+		if name == value {
+			atomic.StoreInt32(&l.level, level)
+			return true
+		}
+	}
+	return false
+}
+
+// enabled whether the lines of the level are written
+func (l *pluginLogger) enabled(level int32) bool {
+	return level >= atomic.LoadInt32(&l.level)
+}
+
+func (l *pluginLogger) levelName() string {
+	return pluginLogLevelNames[atomic.LoadInt32(&l.level)]
+}
+
+// Printf writes the line when its level is at or over the level of the runtime log
+func (l *pluginLogger) Printf(format string, v ...interface{}) {
+	l.write(4, pluginLogFields{}, fmt.Sprintf(format, v...))
+}
+
+// write writes the line, calldepth being the frames to the caller of Log for the file and line of the printf lines
+func (l *pluginLogger) write(calldepth int, fields pluginLogFields, message string) {
+	level, component := parseLogLineLevel(message)
+	if !l.enabled(level) {
+		return
+	}
+	if atomic.LoadInt32(&l.json) == 0 {
+		l.logger.Output(calldepth, message)
+		return
+	}
+	if fields.Component == "" {
+		fields.Component = component
+	}
+	if fields.RequestID == "" {
+		if match := pluginLogRequestIDRegex.FindStringSubmatch(message); match != nil {
+			fields.RequestID = match[1]
+		}
+	}
+	line, err := json.Marshal(pluginLogLine{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     pluginLogLevelNames[level],
+		Component: fields.Component,
+		Route:     fields.Route,
+		RequestID: fields.RequestID,
+		Message:   strings.TrimRight(message, "\n"),
+	})
+	if err != nil {
+		l.logger.Output(calldepth, message)
+		return
+	}
+	l.logger.Writer().Write(append(line, '\n'))
+}
+
+// parseLogLineLevel returns the level and the component of a line of the runtime log
+func parseLogLineLevel(message string) (int32, string) {
+	match := pluginLogLineRegex.FindStringSubmatch(message)
+	if match == nil {
+		return pluginLogLevelInfo, ""
+	}
+	level := pluginLogLevelInfo
+	switch match[2] {
+	case "Error":
+		level = pluginLogLevelError
+	case "Warning":
+		level = pluginLogLevelWarn
+	case "Debug":
+		level = pluginLogLevelDebug
+	}
+	component := match[3]
+	if component == "" {
+		component = match[1]
+	}
+	return level, strings.ToLower(component)
+}
+
+// LogWith logs the line with the route and the request id of the structured runtime log
+func LogWith(fields pluginLogFields, format string, v ...interface{}) {
+	trackPluginErrors(func(format string, v ...interface{}) {
+		PluginLogger.write(5, fields, fmt.Sprintf(format, v...))
+	})(format, v...)
+}
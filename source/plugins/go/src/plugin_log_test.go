@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"testing"
+)
+
+func Test_parseLogLineLevel(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		line      string
+		level     int32
+		component string
+	}
+
+	tests := []test_struct{
+		{"level and area", "Error::mdsd::Failed to write to mdsd 12 records", pluginLogLevelError, "mdsd"},
+		{"upper case area", "Success::ADX::Successfully ingested telegraf metrics", pluginLogLevelInfo, "adx"},
+		{"function and level", "PostDataHelper::Warning::Unable to get the pod", pluginLogLevelWarn, "postdatahelper"},
+		{"function and short separator", "PostTelegrafMetricsToLA::Error:(retriable) RequestID 1", pluginLogLevelError, "posttelegrafmetricstola"},
+		{"level only", "Error while Marshalling no error tags", pluginLogLevelError, ""},
+		{"debug", "Debug::spool::Replayed 3 chunks", pluginLogLevelDebug, "spool"},
+		{"no level", "Successfully flushed 12 records", pluginLogLevelInfo, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			level, component := parseLogLineLevel(tt.line)
+			if level != tt.level || component != tt.component {
+				t.Errorf("parseLogLineLevel(%q) = %d, %q, want %d, %q", tt.line, level, component, tt.level, tt.component)
+			}
+		})
+	}
+}
+
+func Test_pluginLoggerJSON(t *testing.T) {
+	type test_struct struct {
+		testname string
+		level    string
+		fields   pluginLogFields
+		line     string
+		want     *pluginLogLine
+	}
+
+	requestID := "0f8fad5b-d9cb-469f-a165-70867728950e"
+	tests := []test_struct{
+		{
+			"request id parsed",
+			"info",
+			pluginLogFields{Route: "v1"},
+			"PostTelegrafMetricsToLA::Error:(retriable) RequestID " + requestID + " Response Status 503",
+			&pluginLogLine{Level: "error", Component: "posttelegrafmetricstola", Route: "v1", RequestID: requestID},
+		},
+		{
+			"fields given",
+			"info",
+			pluginLogFields{Component: "sinks", Route: "dcr", RequestID: "given"},
+			"Error::dcr::RequestId " + requestID + " Status 400",
+			&pluginLogLine{Level: "error", Component: "sinks", Route: "dcr", RequestID: "given"},
+		},
+		{
+			"under the level",
+			"warning",
+			pluginLogFields{},
+			"Info::mdsd::obtaining output stream id",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			var buffer bytes.Buffer
+			logger := newPluginLogger(log.New(&buffer, "", 0))
+			logger.json = 1
+			if !logger.setLevel(tt.level) {
+				t.Fatalf("setLevel(%q) = false, want true", tt.level)
+			}
+			logger.write(2, tt.fields, tt.line)
+			if tt.want == nil {
+				if buffer.Len() != 0 {
+					t.Errorf("got %q, want no line", buffer.String())
+				}
+				return
+			}
+			var got pluginLogLine
+			if err := json.Unmarshal(buffer.Bytes(), &got); err != nil {
+				t.Fatalf("got %q, not a json line: %s", buffer.String(), err.Error())
+			}
+			if got.Level != tt.want.Level || got.Component != tt.want.Component || got.Route != tt.want.Route || got.RequestID != tt.want.RequestID || got.Message != tt.line || got.Time == "" {
+				t.Errorf("got %+v, want %+v with the message %q", got, *tt.want, tt.line)
+			}
+		})
+	}
+}
+
+func Test_readPluginLogSetting(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		min      int
+		want     int
+	}
+
+	tests := []test_struct{
+		{"not set", "", 1, 10},
+		{"set", " 50 ", 1, 50},
+		{"zero allowed", "0", 0, 0},
+		{"under min", "0", 1, 10},
+		{"not a number", "ten", 1, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			pluginConfig := map[string]string{pluginLogMaxSizeMBSetting: tt.value}
+			if got := readPluginLogSetting(pluginConfig, pluginLogMaxSizeMBSetting, 10, tt.min); got != tt.want {
+				t.Errorf("readPluginLogSetting(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
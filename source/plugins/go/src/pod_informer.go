@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// how long the refresh waits for the first list of the pods before it runs with the pods received so far
+const podInformerSyncTimeout = 2 * time.Minute
+
+var (
+	// NodePodLister lists the pods of the node from the cache of the pod informer
+	NodePodLister listerscorev1.PodLister
+	// podInformerStopCh stops the pod informer when the plugin exits
+	podInformerStopCh = make(chan struct{})
+)
+
+// startNodePodInformer watches the pods of the node, so the container metadata is refreshed from the informer cache on
+// the changes of the pods instead of listing the pods from the API server. The resync is off, the refresh ticker
+// already rebuilds the maps periodically from the cache
+func startNodePodInformer() {
+	factory := informers.NewSharedInformerFactoryWithOptions(ClientSet, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fmt.Sprintf("spec.nodeName=%s", Computer)
+		}))
+	podInformer := factory.Core().V1().Pods()
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			requestNow(containerMetadataRefreshRequests)
+			requestNow(podLogCollectionRefreshRequests)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, oldOk := oldObj.(*corev1.Pod)
+			newPod, newOk := newObj.(*corev1.Pod)
+			if !oldOk || !newOk || podContainerStatusesChanged(oldPod, newPod) {
+				requestNow(containerMetadataRefreshRequests)
+			}
+			if !oldOk || !newOk || !reflect.DeepEqual(oldPod.Annotations, newPod.Annotations) {
+				requestNow(podLogCollectionRefreshRequests)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			requestNow(containerMetadataRefreshRequests)
+			requestNow(podLogCollectionRefreshRequests)
+		},
+	})
+	NodePodLister = podInformer.Lister()
+	factory.Start(podInformerStopCh)
+
+	syncTimeout := make(chan struct{})
+	timer := time.AfterFunc(podInformerSyncTimeout, func() { close(syncTimeout) })
+	if cache.WaitForCacheSync(syncTimeout, podInformer.Informer().HasSynced) {
+		timer.Stop()
+		Log("Watching the pods of node %s for the container metadata", Computer)
+	} else {
+		Log("Error getting pods: the pods of node %s were not listed in %s, the logs are enriched as the pods are received", Computer, podInformerSyncTimeout)
+	}
+}
+
+// podContainerStatusesChanged whether an update of a pod changes the metadata of its containers
+func podContainerStatusesChanged(oldPod *corev1.Pod, newPod *corev1.Pod) bool {
+	return oldPod.UID != newPod.UID ||
+		!reflect.DeepEqual(oldPod.Status.ContainerStatuses, newPod.Status.ContainerStatuses) ||
+		!reflect.DeepEqual(oldPod.Status.InitContainerStatuses, newPod.Status.InitContainerStatuses)
+}
+
+// listNodePods returns the pods of the node from the informer cache. The pods are shared with the cache and must not
+// be modified
+func listNodePods() ([]*corev1.Pod, error) {
+	return NodePodLister.List(labels.Everything())
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_podContainerStatusesChanged(t *testing.T) {
+	type test_struct struct {
+		testname string
+		update   func(pod *corev1.Pod)
+		output   bool
+	}
+
+	tests := []test_struct{
+		{"no change", func(pod *corev1.Pod) {}, false},
+		{"labels changed", func(pod *corev1.Pod) { pod.Labels = map[string]string{"app": "web"} }, false},
+		{"container restarted", func(pod *corev1.Pod) {
+			pod.Status.ContainerStatuses[0].RestartCount = 1
+			pod.Status.ContainerStatuses[0].ContainerID = "containerd://def"
+		}, true},
+		{"init container started", func(pod *corev1.Pod) {
+			pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "init", ContainerID: "containerd://ghi"}}
+		}, true},
+		{"pod recreated", func(pod *corev1.Pod) { pod.UID = "uid-2" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			oldPod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-0", UID: "uid-1"},
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{{Name: "web", Image: "nginx", ContainerID: "containerd://abc"}},
+				},
+			}
+			newPod := oldPod.DeepCopy()
+			tt.update(newPod)
+			if got := podContainerStatusesChanged(oldPod, newPod); got != tt.output {
+				t.Errorf("podContainerStatusesChanged() = %v, want %v", got, tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// annotations of the pods which control the collection of the logs of their containers
+const (
+	// "false" opts the pod out of the log collection, "true" opts it in even when its namespace is excluded
+	annotationLogs = "azm.ms/logs"
+	// "true" collects only the stderr logs of the pod
+	annotationStderrOnly = "azm.ms/stderr-only"
+	// "true" collects only the stdout logs of the pod
+	annotationStdoutOnly = "azm.ms/stdout-only"
+)
+
+// podLogCollection the log collection of a pod as set by its annotations
+type podLogCollection struct {
+	optIn      bool
+	optOut     bool
+	stderrOnly bool
+	stdoutOnly bool
+}
+
+// PodLogCollectionMap caches the container id to the log collection annotations of its pod, for the annotated pods
+var PodLogCollectionMap map[string]podLogCollection
+
+var (
+	// PodLogCollectionRefreshTicker refreshes PodLogCollectionMap when it is not refreshed with the container metadata
+	PodLogCollectionRefreshTicker *time.Ticker
+	// podLogCollectionRefreshRequests requests a refresh of PodLogCollectionMap on the changes of the pods of the node
+	podLogCollectionRefreshRequests = make(chan struct{}, 1)
+)
+
+// podLogCollectionOf returns the log collection of a pod from its annotations, false when it has none of them
+func podLogCollectionOf(annotations map[string]string) (podLogCollection, bool) {
+	var collection podLogCollection
+	if value, ok := annotations[annotationLogs]; ok {
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true":
+			collection.optIn = true
+		case "false":
+			collection.optOut = true
+		}
+	}
+	collection.stderrOnly = strings.EqualFold(strings.TrimSpace(annotations[annotationStderrOnly]), "true")
+	collection.stdoutOnly = strings.EqualFold(strings.TrimSpace(annotations[annotationStdoutOnly]), "true")
+	return collection, collection != podLogCollection{}
+}
+
+// isContainerLogExcluded whether a log line of a stream of the container is not collected. The annotations of the pod
+// take precedence over the namespace lists, an opted in pod being collected even when its namespace is excluded
+func isContainerLogExcluded(collections map[string]podLogCollection, containerID string, stream string, namespaceExcluded bool) bool {
+	collection, ok := collections[containerID]
+	if !ok {
+		return namespaceExcluded
+	}
+	if collection.optOut {
+		return true
+	}
+	if (collection.stderrOnly && strings.EqualFold(stream, "stdout")) || (collection.stdoutOnly && strings.EqualFold(stream, "stderr")) {
+		return true
+	}
+	return namespaceExcluded && !collection.optIn
+}
+
+// updatePodLogCollections refreshes the log collection annotations of the pods of the node from the pod informer. It
+// runs when the container metadata is not read from the pods, without the enrichment or with the CRI source, so the
+// annotations are enforced on all the routes
+func updatePodLogCollections() {
+	startNodePodInformer()
+	for ; true; waitForTickOrRequest(PodLogCollectionRefreshTicker.C, podLogCollectionRefreshRequests) {
+		pods, err := listNodePods()
+		if err != nil {
+			Log("Error getting the pods for their log collection annotations: %s", err.Error())
+			continue
+		}
+		collections := podLogCollectionsOf(pods)
+		DataUpdateMutex.Lock()
+		PodLogCollectionMap = collections
+		DataUpdateMutex.Unlock()
+		Log("Updated the log collection annotations of %d containers", len(collections))
+	}
+}
+
+// podLogCollectionsOf returns the container id to the log collection annotations of its pod, for the annotated pods
+func podLogCollectionsOf(pods []*corev1.Pod) map[string]podLogCollection {
+	collections := make(map[string]podLogCollection)
+	for _, pod := range pods {
+		collection, annotated := podLogCollectionOf(pod.Annotations)
+		if !annotated {
+			continue
+		}
+		for _, statuses := range [][]corev1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses} {
+			for _, status := range statuses {
+				if containerID, _ := runtimeContainerID(status.ContainerID); containerID != "" {
+					collections[containerID] = collection
+				}
+			}
+		}
+	}
+	return collections
+}
+
+// snapshotPodLogCollections returns the log collection annotations of the containers, replaced by the refreshes so
+// not copied
+func snapshotPodLogCollections() map[string]podLogCollection {
+	rlockContainerMetadata()
+	defer DataUpdateMutex.RUnlock()
+	return PodLogCollectionMap
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_podLogCollectionOf(t *testing.T) {
+	type test_struct struct {
+		testname    string
+		annotations map[string]string
+		collection  podLogCollection
+		annotated   bool
+	}
+
+	tests := []test_struct{
+		{"no annotations", nil, podLogCollection{}, false},
+		{"other annotations", map[string]string{"prometheus.io/scrape": "true"}, podLogCollection{}, false},
+		{"opted out", map[string]string{"azm.ms/logs": "false"}, podLogCollection{optOut: true}, true},
+		{"opted in", map[string]string{"azm.ms/logs": " True "}, podLogCollection{optIn: true}, true},
+		{"invalid value", map[string]string{"azm.ms/logs": "no"}, podLogCollection{}, false},
+		{"stderr only", map[string]string{"azm.ms/stderr-only": "true"}, podLogCollection{stderrOnly: true}, true},
+		{"stdout only", map[string]string{"azm.ms/stdout-only": "true", "azm.ms/logs": "true"}, podLogCollection{optIn: true, stdoutOnly: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			collection, annotated := podLogCollectionOf(tt.annotations)
+			if collection != tt.collection || annotated != tt.annotated {
+				t.Errorf("podLogCollectionOf() = %+v, %v, want %+v, %v", collection, annotated, tt.collection, tt.annotated)
+			}
+		})
+	}
+}
+
+func Test_podLogCollectionsOf(t *testing.T) {
+	pods := []*corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"azm.ms/logs": "false"}},
+			Status: corev1.PodStatus{
+				ContainerStatuses:     []corev1.ContainerStatus{{ContainerID: "containerd://app"}},
+				InitContainerStatuses: []corev1.ContainerStatus{{ContainerID: "containerd://init"}, {ContainerID: ""}},
+			},
+		},
+		{
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{ContainerID: "containerd://other"}}},
+		},
+	}
+	want := map[string]podLogCollection{"app": {optOut: true}, "init": {optOut: true}}
+	if collections := podLogCollectionsOf(pods); !reflect.DeepEqual(collections, want) {
+		t.Errorf("podLogCollectionsOf() = %+v, want %+v", collections, want)
+	}
+}
+
+func Test_isContainerLogExcluded(t *testing.T) {
+	type test_struct struct {
+		testname          string
+		containerID       string
+		stream            string
+		namespaceExcluded bool
+		output            bool
+	}
+
+	collections := map[string]podLogCollection{
+		"optout":     {optOut: true},
+		"optin":      {optIn: true},
+		"stderronly": {stderrOnly: true},
+		"optinerr":   {optIn: true, stderrOnly: true},
+	}
+
+	tests := []test_struct{
+		{"not annotated", "other", "stdout", false, false},
+		{"not annotated in excluded namespace", "other", "stdout", true, true},
+		{"opted out", "optout", "stderr", false, true},
+		{"opted in to excluded namespace", "optin", "stdout", true, false},
+		{"stderr only stdout", "stderronly", "stdout", false, true},
+		{"stderr only stderr", "stderronly", "stderr", false, false},
+		{"stderr only in excluded namespace", "stderronly", "stderr", true, true},
+		{"opted in stderr only stdout", "optinerr", "stdout", true, true},
+		{"opted in stderr only stderr", "optinerr", "stderr", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := isContainerLogExcluded(collections, tt.containerID, tt.stream, tt.namespaceExcluded); got != tt.output {
+				t.Errorf("isContainerLogExcluded() = %v, want %v", got, tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// env variable of the telegraf input plugins whose errors are prometheus scrape errors, separated by commas.
+// prometheus by default
+const envPromScrapeErrorInputs = "AZMON_PROM_SCRAPE_ERROR_INPUTS"
+
+const defaultPromScrapeErrorInputs = "prometheus"
+
+// the error lines of the telegraf text log format, E! [inputs.<plugin>]: <message> before telegraf 1.14 and
+// E! [inputs.<plugin>::<alias>] <message> since, the alias being optional
+var telegrafTextErrorRegex = regexp.MustCompile(`E! \[inputs\.([A-Za-z0-9_]+)(?:::([^\]]*))?\]:? (.*)$`)
+
+// the levels of the error lines of the telegraf structured log formats
+var telegrafStructuredErrorLevels = map[string]bool{"e!": true, "error": true}
+
+// PromScrapeErrorInputs the telegraf input plugins whose errors are prometheus scrape errors
+var PromScrapeErrorInputs = map[string]bool{defaultPromScrapeErrorInputs: true}
+
+// promScrapeError a prometheus scrape error parsed from a telegraf log line
+type promScrapeError struct {
+	// the telegraf input plugin and its alias
+	plugin  string
+	alias   string
+	message string
+	// the details of the error parsed from the message
+	target     string
+	job        string
+	namespace  string
+	errorClass string
+}
+
+// promScrapeErrorParser parses a telegraf log line, ok is false when the line is not an error of an input plugin in
+// the line format of the parser
+type promScrapeErrorParser func(line string) (promScrapeError, bool)
+
+// the parsers of the telegraf log formats, the first one parsing the line is used
+var promScrapeErrorParsers = []promScrapeErrorParser{parseTelegrafJSONErrorLine, parseTelegrafLogfmtErrorLine, parseTelegrafTextErrorLine}
+
+// initializePromScrapeErrorInputs reads the telegraf input plugins whose errors are prometheus scrape errors
+func initializePromScrapeErrorInputs() {
+	value := strings.TrimSpace(os.Getenv(envPromScrapeErrorInputs))
+	if value == "" {
+		value = defaultPromScrapeErrorInputs
+	}
+	PromScrapeErrorInputs = make(map[string]bool)
+	for _, input := range strings.Split(value, ",") {
+		input = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(input)), "inputs.")
+		if input != "" {
+			PromScrapeErrorInputs[input] = true
+		}
+	}
+	Log("Prometheus scrape errors of the telegraf inputs %v", PromScrapeErrorInputs)
+}
+
+// parsePromScrapeError returns the prometheus scrape error of a telegraf log line, ok is false when the line is not
+// a scrape error
+func parsePromScrapeError(line string) (promScrapeError, bool) {
+	line = strings.TrimSpace(line)
+	for _, parser := range promScrapeErrorParsers {
+		if scrapeError, ok := parser(line); ok {
+			if !PromScrapeErrorInputs[strings.ToLower(scrapeError.plugin)] || scrapeError.message == "" {
+				return promScrapeError{}, false
+			}
+			addPromScrapeErrorDetails(&scrapeError)
+			return scrapeError, true
+		}
+	}
+	return promScrapeError{}, false
+}
+
+func parseTelegrafTextErrorLine(line string) (promScrapeError, bool) {
+	match := telegrafTextErrorRegex.FindStringSubmatch(line)
+	if match == nil {
+		return promScrapeError{}, false
+	}
+	return promScrapeError{plugin: match[1], alias: match[2], message: strings.TrimSpace(match[3])}, true
+}
+
+// parseTelegrafJSONErrorLine parses the lines of the json log format
+func parseTelegrafJSONErrorLine(line string) (promScrapeError, bool) {
+	if !strings.HasPrefix(line, "{") {
+		return promScrapeError{}, false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return promScrapeError{}, false
+	}
+	stringFields := make(map[string]string, len(fields))
+	for key, value := range fields {
+		if s, ok := value.(string); ok {
+			stringFields[key] = s
+		}
+	}
+	return structuredTelegrafError(stringFields)
+}
+
+// parseTelegrafLogfmtErrorLine parses the lines of the structured (logfmt) log format
+func parseTelegrafLogfmtErrorLine(line string) (promScrapeError, bool) {
+	if !strings.Contains(line, "level=") || !strings.Contains(line, "msg=") {
+		return promScrapeError{}, false
+	}
+	return structuredTelegrafError(parseLogfmt(line))
+}
+
+// structuredTelegrafError returns the error of the fields of a structured log line. The plugin is either
+// inputs.<plugin>, or in the plugin field with the inputs category
+func structuredTelegrafError(fields map[string]string) (promScrapeError, bool) {
+	if !telegrafStructuredErrorLevels[strings.ToLower(fields["level"])] {
+		return promScrapeError{}, false
+	}
+	plugin := fields["plugin"]
+	if strings.HasPrefix(plugin, "inputs.") {
+		plugin = strings.TrimPrefix(plugin, "inputs.")
+	} else if fields["category"] != "inputs" {
+		return promScrapeError{}, false
+	}
+	message := fields["msg"]
+	if message == "" {
+		message = fields["message"]
+	}
+	return promScrapeError{plugin: plugin, alias: fields["alias"], message: strings.TrimSpace(message)}, true
+}
+
+// parseLogfmt parses the key=value pairs of a logfmt line, the values being quoted when they contain spaces
+func parseLogfmt(line string) map[string]string {
+	fields := make(map[string]string)
+	for len(line) > 0 {
+		line = strings.TrimLeft(line, " ")
+		equal := strings.IndexAny(line, "= ")
+		if equal <= 0 || line[equal] != '=' {
+			// skip a token without value
+			next := strings.IndexByte(line, ' ')
+			if next < 0 {
+				break
+			}
+			line = line[next:]
+			continue
+		}
+		key := line[:equal]
+		line = line[equal+1:]
+		value := ""
+		if strings.HasPrefix(line, `"`) {
+			end := 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				end = len(line) - 1
+			}
+			quoted := line[:end+1]
+			if unquoted, err := strconv.Unquote(quoted); err == nil {
+				value = unquoted
+			} else {
+				value = strings.Trim(quoted, `"`)
+			}
+			line = line[end+1:]
+		} else {
+			next := strings.IndexByte(line, ' ')
+			if next < 0 {
+				next = len(line)
+			}
+			value = line[:next]
+			line = line[next:]
+		}
+		fields[key] = value
+	}
+	return fields
+}
+
+// the urls of the scrape targets in the error messages
+var scrapeTargetRegex = regexp.MustCompile(`https?://[^\s"',]+`)
+
+// the http status of the scrape responses in the error messages, e.g. returned HTTP status 503 Service Unavailable
+var scrapeHTTPStatusRegex = regexp.MustCompile(`(?i)HTTP status (\d{3})`)
+
+// the classes of the scrape errors, from the errors of their message
+var scrapeErrorClasses = []struct {
+	class    string
+	contains []string
+}{
+	{"connection_refused", []string{"connection refused"}},
+	{"connection_reset", []string{"connection reset", "broken pipe", "EOF"}},
+	{"dns", []string{"no such host", "server misbehaving"}},
+	{"timeout", []string{"context deadline exceeded", "timeout", "Timeout"}},
+	{"tls", []string{"x509", "tls:"}},
+	{"parse", []string{"error reading metrics", "parse", "unmarshal", "invalid metric"}},
+}
+
+// addPromScrapeErrorDetails adds the target, job, namespace and class of the error from its message. The job is the
+// alias of the input, or else the service of the target
+func addPromScrapeErrorDetails(scrapeError *promScrapeError) {
+	if target := scrapeTargetRegex.FindString(scrapeError.message); target != "" {
+		target = strings.TrimRight(target, ":;.)")
+		if targetURL, err := url.Parse(target); err == nil {
+			// the credentials and the query are not part of the events
+			targetURL.User = nil
+			targetURL.RawQuery = ""
+			scrapeError.target = targetURL.String()
+			service, namespace := serviceOfScrapeTarget(targetURL.Hostname())
+			scrapeError.job = service
+			scrapeError.namespace = namespace
+		}
+	}
+	if scrapeError.alias != "" {
+		scrapeError.job = scrapeError.alias
+	}
+	scrapeError.errorClass = classifyScrapeError(scrapeError.message)
+}
+
+// serviceOfScrapeTarget returns the service and the namespace of the cluster dns name of a target,
+// <service>.<namespace>.svc[.<cluster domain>], or the namespace of a pod dns name, <pod ip>.<namespace>.pod
+func serviceOfScrapeTarget(host string) (string, string) {
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 {
+		return "", ""
+	}
+	switch labels[2] {
+	case "svc":
+		return labels[0], labels[1]
+	case "pod":
+		return "", labels[1]
+	}
+	return "", ""
+}
+
+// classifyScrapeError returns the class of a scrape error, http_<status class> for the error responses
+func classifyScrapeError(message string) string {
+	if match := scrapeHTTPStatusRegex.FindStringSubmatch(message); match != nil {
+		return "http_" + match[1][:1] + "xx"
+	}
+	for _, errorClass := range scrapeErrorClasses {
+		for _, contains := range errorClass.contains {
+			if strings.Contains(message, contains) {
+				return errorClass.class
+			}
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+// port of the prometheus metrics endpoint of the plugin, served on all the interfaces so the agent can be scraped. The
+// endpoint is off when not set
+const envMetricsPort = "AZMON_METRICS_PORT"
+
+// prefix of the names of the prometheus metrics of the plugin
+const promMetricPrefix = "azmon_plugin_"
+
+// upper bounds (seconds) of the buckets of the latency histograms
+var promLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// latencyHistogram a cumulative prometheus histogram of latencies
+type latencyHistogram struct {
+	buckets []float64
+	count   float64
+	sum     float64
+}
+
+func (h *latencyHistogram) observe(latency time.Duration) {
+	if h.buckets == nil {
+		h.buckets = make([]float64, len(promLatencyBuckets))
+	}
+	seconds := latency.Seconds()
+	for i, bound := range promLatencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// flushOutcomeKey the flushes of a route by return code
+type flushOutcomeKey struct {
+	route   string
+	outcome string
+}
+
+// pluginMetrics the cumulative counters of the plugin served by the metrics endpoint. Unlike the telemetry counters,
+// they are never reset
+type pluginMetrics struct {
+	mu                  sync.Mutex
+	flushedRecords      float64
+	flushSeconds        float64
+	clientCreateErrors  map[string]float64
+	flushOutcomes       map[flushOutcomeKey]float64
+	sinkSendLatencies   map[string]*latencyHistogram
+	containerLogFlushes latencyHistogram
+	// the time of the last successful flush of each route
+	lastSuccessfulFlushes map[string]time.Time
+}
+
+// PluginMetrics the counters of the plugin served by the metrics endpoint
+var PluginMetrics = newPluginMetrics()
+
+func newPluginMetrics() *pluginMetrics {
+	return &pluginMetrics{
+		clientCreateErrors:    make(map[string]float64),
+		flushOutcomes:         make(map[flushOutcomeKey]float64),
+		sinkSendLatencies:     make(map[string]*latencyHistogram),
+		lastSuccessfulFlushes: make(map[string]time.Time),
+	}
+}
+
+// initializeMetricsEndpoint serves the prometheus metrics of the plugin when the port is set
+func initializeMetricsEndpoint() {
+	value := strings.TrimSpace(os.Getenv(envMetricsPort))
+	if value == "" {
+		return
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		Log("Error::metrics::Invalid value %s for %s, the metrics endpoint is disabled", value, envMetricsPort)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handlePrometheusMetrics)
+	go func() {
+		if err := http.ListenAndServe(":"+value, mux); err != nil {
+			Log("Error::metrics::Metrics endpoint stopped: %s", err.Error())
+		}
+	}()
+	Log("Serving the prometheus metrics of the plugin on :%d/metrics", port)
+}
+
+func (m *pluginMetrics) addFlushedRecords(numRecords int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushedRecords += float64(numRecords)
+	m.flushSeconds += elapsed.Seconds()
+	m.containerLogFlushes.observe(elapsed)
+}
+
+// addClientCreateError counts a failure to create the client of a destination, mdsd or adx
+func (m *pluginMetrics) addClientCreateError(destination string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clientCreateErrors[destination]++
+}
+
+func (m *pluginMetrics) addFlushOutcome(route string, retCode int) {
+	outcome := "ok"
+	switch retCode {
+	case output.FLB_RETRY:
+		outcome = "retry"
+	case output.FLB_ERROR:
+		outcome = "error"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushOutcomes[flushOutcomeKey{route: route, outcome: outcome}]++
+	if retCode == output.FLB_OK {
+		m.lastSuccessfulFlushes[route] = time.Now()
+	}
+}
+
+// getFlushedRecords returns the number of container log records flushed since the start of the plugin
+func (m *pluginMetrics) getFlushedRecords() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flushedRecords
+}
+
+// getLastSuccessfulFlush returns the time of the last successful flush of the route, zero when it has none
+func (m *pluginMetrics) getLastSuccessfulFlush(route string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastSuccessfulFlushes[route]
+}
+
+func (m *pluginMetrics) observeSinkSend(sinkName string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	histogram, ok := m.sinkSendLatencies[sinkName]
+	if !ok {
+		histogram = &latencyHistogram{}
+		m.sinkSendLatencies[sinkName] = histogram
+	}
+	histogram.observe(latency)
+}
+
+func handlePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	PluginMetrics.write(w, ContainerLogSinkStats.snapshot())
+	writeRuntimeMetrics(w)
+}
+
+// write writes the counters in the prometheus text format, with the failures of the sinks
+func (m *pluginMetrics) write(w io.Writer, sinks map[string]sinkCounters) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writePromHeader(w, "flushed_records_total", "counter", "Container log records flushed to their route.")
+	writePromSample(w, "flushed_records_total", nil, m.flushedRecords)
+	writePromHeader(w, "flushed_records_seconds_total", "counter", "Time taken to flush the container log records.")
+	writePromSample(w, "flushed_records_seconds_total", nil, m.flushSeconds)
+	writePromHeader(w, "flush_duration_seconds", "histogram", "Duration of the flushes of the container log records.")
+	writePromHistogram(w, "flush_duration_seconds", nil, &m.containerLogFlushes)
+
+	writePromHeader(w, "client_create_errors_total", "counter", "Failures to create the mdsd or ADX client.")
+	for _, destination := range sortedKeys(m.clientCreateErrors) {
+		writePromSample(w, "client_create_errors_total", []string{"destination", destination}, m.clientCreateErrors[destination])
+	}
+
+	writePromHeader(w, "flushes_total", "counter", "Flushes of the container log chunks by route and outcome, retry being a chunk fluent-bit retries.")
+	keys := make([]flushOutcomeKey, 0, len(m.flushOutcomes))
+	for key := range m.flushOutcomes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].outcome < keys[j].outcome
+	})
+	for _, key := range keys {
+		writePromSample(w, "flushes_total", []string{"route", key.route, "outcome", key.outcome}, m.flushOutcomes[key])
+	}
+
+	writePromHeader(w, "sink_send_duration_seconds", "histogram", "Duration of the sends of the container log sinks.")
+	sinkNames := make([]string, 0, len(m.sinkSendLatencies))
+	for name := range m.sinkSendLatencies {
+		sinkNames = append(sinkNames, name)
+	}
+	sort.Strings(sinkNames)
+	for _, name := range sinkNames {
+		writePromHistogram(w, "sink_send_duration_seconds", []string{"sink", name}, m.sinkSendLatencies[name])
+	}
+
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writePromHeader(w, "sink_send_failures_total", "counter", "Failed sends of the container log sinks by failure class.")
+	for _, name := range names {
+		counters := sinks[name]
+		for _, class := range sortedKeys(counters.failures) {
+			writePromSample(w, "sink_send_failures_total", []string{"sink", name, "type", counters.sinkType, "class", class}, counters.failures[class])
+		}
+	}
+	writePromHeader(w, "sink_sent_records_total", "counter", "Records sent by the container log sinks.")
+	for _, name := range names {
+		writePromSample(w, "sink_sent_records_total", []string{"sink", name, "type", sinks[name].sinkType}, sinks[name].records)
+	}
+}
+
+// writeRuntimeMetrics writes the go runtime stats of the plugin
+func writeRuntimeMetrics(w io.Writer) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	writePromHeader(w, "go_goroutines", "gauge", "Number of goroutines.")
+	writePromSample(w, "go_goroutines", nil, float64(runtime.NumGoroutine()))
+	writePromHeader(w, "go_heap_alloc_bytes", "gauge", "Bytes of the allocated heap objects.")
+	writePromSample(w, "go_heap_alloc_bytes", nil, float64(memStats.HeapAlloc))
+	writePromHeader(w, "go_sys_bytes", "gauge", "Bytes of memory obtained from the OS.")
+	writePromSample(w, "go_sys_bytes", nil, float64(memStats.Sys))
+	writePromHeader(w, "go_gc_cycles_total", "counter", "Completed GC cycles.")
+	writePromSample(w, "go_gc_cycles_total", nil, float64(memStats.NumGC))
+	writePromHeader(w, "go_gc_pause_seconds_total", "counter", "Cumulative GC stop-the-world pause.")
+	writePromSample(w, "go_gc_pause_seconds_total", nil, float64(memStats.PauseTotalNs)/1e9)
+}
+
+func writePromHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", promMetricPrefix, name, help, promMetricPrefix, name, metricType)
+}
+
+// writePromSample writes a sample, labels being the pairs of label name and value
+func writePromSample(w io.Writer, name string, labels []string, value float64) {
+	fmt.Fprintf(w, "%s%s%s %s\n", promMetricPrefix, name, formatPromLabels(labels), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func writePromHistogram(w io.Writer, name string, labels []string, histogram *latencyHistogram) {
+	for i, bound := range promLatencyBuckets {
+		count := 0.0
+		if histogram.buckets != nil {
+			count = histogram.buckets[i]
+		}
+		writePromSample(w, name+"_bucket", append(append([]string{}, labels...), "le", strconv.FormatFloat(bound, 'g', -1, 64)), count)
+	}
+	writePromSample(w, name+"_bucket", append(append([]string{}, labels...), "le", "+Inf"), histogram.count)
+	writePromSample(w, name+"_sum", labels, histogram.sum)
+	writePromSample(w, name+"_count", labels, histogram.count)
+}
+
+func formatPromLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+func Test_pluginMetricsWrite(t *testing.T) {
+	type test_struct struct {
+		testname string
+		line     string
+	}
+
+	metrics := newPluginMetrics()
+	metrics.addFlushedRecords(10, 300*time.Millisecond)
+	metrics.addFlushedRecords(5, 200*time.Millisecond)
+	metrics.addClientCreateError("mdsd")
+	metrics.addFlushOutcome(ContainerLogsV2Route, output.FLB_RETRY)
+	metrics.addFlushOutcome(ContainerLogsV2Route, output.FLB_OK)
+	metrics.addFlushOutcome(ContainerLogsV2Route, output.FLB_OK)
+	metrics.observeSinkSend("mdsd", 20*time.Millisecond)
+	stats := &sinkStats{counters: make(map[string]*sinkCounters)}
+	stats.record(sinkTypeMdsd, "mdsd", 15, 100, 20*time.Millisecond, nil)
+	stats.record(sinkTypeMdsd, "mdsd", 3, 10, time.Second, &sinkStatusError{statusCode: 503})
+
+	var buffer bytes.Buffer
+	metrics.write(&buffer, stats.snapshot())
+	lines := strings.Split(buffer.String(), "\n")
+
+	tests := []test_struct{
+		{"flushed records", "azmon_plugin_flushed_records_total 15"},
+		{"flush time", "azmon_plugin_flushed_records_seconds_total 0.5"},
+		{"flush duration bucket", `azmon_plugin_flush_duration_seconds_bucket{le="0.25"} 1`},
+		{"flush duration +Inf bucket", `azmon_plugin_flush_duration_seconds_bucket{le="+Inf"} 2`},
+		{"client create errors", `azmon_plugin_client_create_errors_total{destination="mdsd"} 1`},
+		{"retried flushes", `azmon_plugin_flushes_total{route="v2",outcome="retry"} 1`},
+		{"successful flushes", `azmon_plugin_flushes_total{route="v2",outcome="ok"} 2`},
+		{"sink send latency", `azmon_plugin_sink_send_duration_seconds_bucket{sink="mdsd",le="0.01"} 0`},
+		{"sink send count", `azmon_plugin_sink_send_duration_seconds_count{sink="mdsd"} 1`},
+		{"sink failures", `azmon_plugin_sink_send_failures_total{sink="mdsd",type="mdsd",class="server"} 1`},
+		{"sink records", `azmon_plugin_sink_sent_records_total{sink="mdsd",type="mdsd"} 15`},
+		{"metric type", "# TYPE azmon_plugin_flushes_total counter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			for _, line := range lines {
+				if line == tt.line {
+					return
+				}
+			}
+			t.Errorf("line %q not found in\n%s", tt.line, buffer.String())
+		})
+	}
+}
+
+func Test_formatPromLabels(t *testing.T) {
+	type test_struct struct {
+		testname string
+		labels   []string
+		output   string
+	}
+
+	tests := []test_struct{
+		{"no labels", nil, ""},
+		{"labels", []string{"route", "v2", "outcome", "ok"}, `{route="v2",outcome="ok"}`},
+		{"escaped value", []string{"error", "a \"b\"\\\n"}, `{error="a \"b\"\\\n"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := formatPromLabels(tt.labels); got != tt.output {
+				t.Errorf("formatPromLabels() = %s, want %s", got, tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// adds the record metadata to the container log entries forwarded to mdsd, when true. The entries are then written in
+// the fluent-bit 2.1 format [[time, metadata], record], so the receiver can route and filter without the record
+const envForwardRecordMetadata = "AZMON_FORWARD_RECORD_METADATA"
+
+// keys of the record metadata, also the message headers of the streaming sinks
+const (
+	recordMetadataCluster       = "cluster"
+	recordMetadataNode          = "node"
+	recordMetadataNamespace     = "namespace"
+	recordMetadataSchemaVersion = "schema_version"
+)
+
+// ForwardRecordMetadata when true, the container log entries forwarded to mdsd carry the record metadata
+var ForwardRecordMetadata bool
+
+// initializeRecordMetadata reads whether the entries forwarded to mdsd carry the record metadata
+func initializeRecordMetadata() {
+	ForwardRecordMetadata = false
+	if ContainerLogsRouteV2 == false {
+		return
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv(envForwardRecordMetadata)), "true") {
+		ForwardRecordMetadata = true
+		Log("Record metadata enabled for the container logs of the %s route", getContainerLogsRouteName())
+	}
+}
+
+// recordMetadata returns the metadata of the container log records of the namespace
+func recordMetadata(namespace string) map[string]string {
+	schemaVersion := "v1"
+	if ContainerLogSchemaV2 == true {
+		schemaVersion = ContainerLogV2SchemaVersion
+	}
+	metadata := map[string]string{
+		recordMetadataCluster:       ResourceName,
+		recordMetadataNode:          Computer,
+		recordMetadataSchemaVersion: schemaVersion,
+	}
+	if namespace != "" {
+		metadata[recordMetadataNamespace] = namespace
+	}
+	return metadata
+}
+
+// recordMetadataCache shares the metadata of the records of a batch by namespace
+type recordMetadataCache map[string]map[string]string
+
+func (c recordMetadataCache) get(namespace string) map[string]string {
+	metadata, ok := c[namespace]
+	if !ok {
+		metadata = recordMetadata(namespace)
+		c[namespace] = metadata
+	}
+	return metadata
+}
+
+// appendEntryTime appends the time of a forward entry, with the metadata as [time, metadata] when there is any
+func appendEntryTime(b []byte, entryTime int64, metadata map[string]string) []byte {
+	if metadata == nil {
+		return msgp.AppendInt64(b, entryTime)
+	}
+	b = append(b, 0x92)
+	b = msgp.AppendInt64(b, entryTime)
+	return msgp.AppendMapStrStr(b, metadata)
+}
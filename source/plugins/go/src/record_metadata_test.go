@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+func Test_recordMetadata(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		schemaV2  bool
+		namespace string
+		want      map[string]string
+	}
+
+	tests := []test_struct{
+		{"v2 schema", true, "kube-apps", map[string]string{"cluster": "cluster-1", "node": "node-1", "namespace": "kube-apps", "schema_version": "v2"}},
+		{"v1 schema", false, "kube-apps", map[string]string{"cluster": "cluster-1", "node": "node-1", "namespace": "kube-apps", "schema_version": "v1"}},
+		{"no namespace", true, "", map[string]string{"cluster": "cluster-1", "node": "node-1", "schema_version": "v2"}},
+	}
+
+	ResourceName = "cluster-1"
+	Computer = "node-1"
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ContainerLogSchemaV2 = tt.schemaV2
+			defer func() { ContainerLogSchemaV2 = false }()
+			if got := recordMetadata(tt.namespace); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recordMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_appendEntryTime(t *testing.T) {
+	type test_struct struct {
+		testname string
+		metadata map[string]string
+	}
+
+	tests := []test_struct{
+		{"without metadata", nil},
+		{"with metadata", map[string]string{"namespace": "kube-apps"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			b := appendEntryTime(nil, 1700000000, tt.metadata)
+			if tt.metadata != nil {
+				size, rest, err := msgp.ReadArrayHeaderBytes(b)
+				if err != nil || size != 2 {
+					t.Fatalf("entry time is an array of %d (%v), want [time, metadata]", size, err)
+				}
+				b = rest
+			}
+			entryTime, b, err := msgp.ReadInt64Bytes(b)
+			if err != nil || entryTime != 1700000000 {
+				t.Fatalf("entry time = %d (%v), want 1700000000", entryTime, err)
+			}
+			if tt.metadata == nil {
+				if len(b) != 0 {
+					t.Errorf("%d bytes after the time, want none", len(b))
+				}
+				return
+			}
+			fields, b, err := msgp.ReadMapHeaderBytes(b)
+			if err != nil || int(fields) != len(tt.metadata) {
+				t.Fatalf("metadata has %d fields (%v), want %d", fields, err, len(tt.metadata))
+			}
+			for i := uint32(0); i < fields; i++ {
+				var key, value string
+				if key, b, err = msgp.ReadStringBytes(b); err != nil {
+					t.Fatal(err)
+				}
+				if value, b, err = msgp.ReadStringBytes(b); err != nil {
+					t.Fatal(err)
+				}
+				if tt.metadata[key] != value {
+					t.Errorf("metadata %s = %s, want %s", key, value, tt.metadata[key])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// appended to the agent's User-Agent of the outbound requests, e.g. to identify the cluster to the customer's proxy
+const envUserAgentSuffix = "AZMON_USER_AGENT_SUFFIX"
+
+// routes with HTTP senders, the custom headers are read from AZMON_<ROUTE>_CUSTOM_HEADERS or <route>_custom_headers,
+// and the header policy from <route>_header_content_type, <route>_header_date, <route>_header_request_id and
+// <route>_header_resource_id
+const (
+	requestRouteODS           = "ods"
+	requestRouteAMCS          = "amcs"
+	requestRouteLogsIngestion = "logsingestion"
+	requestRouteMDM           = "mdm"
+)
+
+const (
+	maxUserAgentSuffixLength = 64
+	maxRouteCustomHeaders    = 5
+	maxCustomHeaderLength    = 256
+)
+
+// headers set by the senders, which the custom headers must not override
+var reservedRequestHeaders = map[string]bool{
+	"Authorization":        true,
+	"Content-Encoding":     true,
+	"Content-Length":       true,
+	"Content-Type":         true,
+	"Host":                 true,
+	"User-Agent":           true,
+	"X-Request-Id":         true,
+	"X-Ms-Azureresourceid": true,
+	"X-Ms-Date":            true,
+}
+
+var (
+	// RouteCustomHeaders the validated static headers added to the requests of each route
+	RouteCustomHeaders = make(map[string]http.Header)
+)
+
+// initializeRouteRequestHeaders appends the configured suffix to the User-Agent and reads the custom headers of the
+// routes. Invalid settings are logged and ignored, so a typo does not stop the ingestion
+func initializeRouteRequestHeaders() {
+	suffix := strings.TrimSpace(os.Getenv(envUserAgentSuffix))
+	if suffix != "" {
+		if err := validateUserAgentSuffix(suffix); err != nil {
+			Log("Error::headers::Ignoring %s: %s", envUserAgentSuffix, err.Error())
+		} else {
+			userAgent = fmt.Sprintf("%s %s", userAgent, suffix)
+			Log("User-Agent = %s", userAgent)
+		}
+	}
+
+	for _, route := range []string{requestRouteODS, requestRouteAMCS, requestRouteLogsIngestion, requestRouteMDM} {
+		value := routeSetting(route, "custom_headers")
+		if value == "" {
+			continue
+		}
+		headers, err := parseCustomHeaders(value)
+		if err != nil {
+			Log("Error::headers::Ignoring the custom headers of the %s route: %s", route, err.Error())
+			continue
+		}
+		RouteCustomHeaders[route] = headers
+		for name := range headers {
+			Log("Adding custom header %s to the requests of the %s route", name, route)
+		}
+	}
+	initializeRequestHeaderPolicies()
+}
+
+// initializeRequestHeaderPolicies reads the header policy of each route from the settings of the route, the default
+// policy being kept for the settings not configured. The authorization of a route is not configurable
+func initializeRequestHeaderPolicies() {
+	requestHeaderPolicies = make(map[string]requestHeaderPolicy, len(defaultRequestHeaderPolicies))
+	for route, policy := range defaultRequestHeaderPolicies {
+		policy, err := readRequestHeaderPolicy(route, policy)
+		if err != nil {
+			Log("Error::headers::Using the default header policy of the %s route: %s", route, err.Error())
+			policy = defaultRequestHeaderPolicies[route]
+		}
+		requestHeaderPolicies[route] = policy
+	}
+}
+
+// readRequestHeaderPolicy returns the policy with the settings configured for the route
+func readRequestHeaderPolicy(route string, policy requestHeaderPolicy) (requestHeaderPolicy, error) {
+	if value := routeSetting(route, "header_content_type"); value != "" {
+		if len(value) > maxCustomHeaderLength || !isPrintableASCII(value) {
+			return policy, fmt.Errorf("invalid content type %q", value)
+		}
+		policy.contentType = value
+	}
+	for setting, enabled := range map[string]*bool{
+		"header_date":        &policy.date,
+		"header_request_id":  &policy.requestID,
+		"header_resource_id": &policy.resourceID,
+	} {
+		value := routeSetting(route, setting)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid value %q for %s_%s", value, route, setting)
+		}
+		*enabled = parsed
+	}
+	return policy, nil
+}
+
+// setRouteRequestHeaders sets the User-Agent and the custom headers of the route on the request
+func setRouteRequestHeaders(req *http.Request, route string) {
+	req.Header.Set("User-Agent", userAgent)
+	for name, values := range RouteCustomHeaders[route] {
+		req.Header[name] = values
+	}
+}
+
+func validateUserAgentSuffix(suffix string) error {
+	if len(suffix) > maxUserAgentSuffixLength {
+		return fmt.Errorf("longer than %d characters", maxUserAgentSuffixLength)
+	}
+	if !isPrintableASCII(suffix) {
+		return fmt.Errorf("only printable ASCII characters are allowed")
+	}
+	return nil
+}
+
+// parseCustomHeaders parses the headers in the format name1=value1;name2=value2
+func parseCustomHeaders(value string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, pair := range strings.Split(value, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		nameValue := strings.SplitN(pair, "=", 2)
+		if len(nameValue) != 2 {
+			return nil, fmt.Errorf("%q is not in the format name=value", pair)
+		}
+		name := strings.TrimSpace(nameValue[0])
+		headerValue := strings.TrimSpace(nameValue[1])
+		if !isHeaderToken(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if reservedRequestHeaders[name] {
+			return nil, fmt.Errorf("header %s is set by the agent and cannot be overridden", name)
+		}
+		if headerValue == "" || len(headerValue) > maxCustomHeaderLength || !isPrintableASCII(headerValue) {
+			return nil, fmt.Errorf("invalid value for header %s, it must be 1 to %d printable ASCII characters", name, maxCustomHeaderLength)
+		}
+		headers.Set(name, headerValue)
+	}
+	if len(headers) > maxRouteCustomHeaders {
+		return nil, fmt.Errorf("at most %d custom headers are allowed", maxRouteCustomHeaders)
+	}
+	return headers, nil
+}
+
+// isHeaderToken returns whether the name only has the token characters allowed in a header name (RFC 7230)
+func isHeaderToken(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > 127 || !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isPrintableASCII(value string) bool {
+	for _, c := range value {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestHeaderPolicy the headers the agent sets on the requests of a route, on top of the User-Agent and the custom
+// headers of the route
+type requestHeaderPolicy struct {
+	contentType string
+	// x-ms-date with the time of the request
+	date bool
+	// X-Request-ID with a new id for each request, returned by newRouteRequest to correlate the failures
+	requestID bool
+	// x-ms-AzureResourceId for the resource centric clusters
+	resourceID bool
+	// returns the bearer token, or empty when the route is not authenticated with a token
+	authorization func() (string, error)
+}
+
+// defaultRequestHeaderPolicies the header policy of each route with HTTP senders, when not configured
+var defaultRequestHeaderPolicies = map[string]requestHeaderPolicy{
+	requestRouteODS: {
+		contentType:   "application/json",
+		date:          true,
+		requestID:     true,
+		resourceID:    true,
+		authorization: odsRequestAuthorization,
+	},
+	// the AMCS callers set the IMDS token themselves
+	requestRouteAMCS: {},
+	requestRouteLogsIngestion: {
+		contentType:   "application/json",
+		requestID:     true,
+		authorization: logsIngestionRequestAuthorization,
+	},
+	requestRouteMDM: {
+		contentType:   "application/x-ndjson",
+		requestID:     true,
+		authorization: mdmRequestAuthorization,
+	},
+}
+
+// requestHeaderPolicies the header policy of each route, read from the plugin configuration at init
+var requestHeaderPolicies = defaultRequestHeaderPolicies
+
+// newRouteRequest builds a request to the endpoint of the route with the headers of the route's policy. It returns the
+// request id, empty when the policy does not set one. The request is cancelled with the context, and the body is
+// compressed with the best encoding accepted by the destination of the route
+func newRouteRequest(ctx context.Context, method string, route string, endpoint string, body []byte) (*http.Request, string, error) {
+	policy, ok := requestHeaderPolicies[route]
+	if !ok {
+		return nil, "", fmt.Errorf("no header policy for the %s route", route)
+	}
+	var bodyReader io.Reader
+	encoding := encodingNone
+	if len(body) > 0 {
+		encoding = selectRouteEncoding(route)
+		compressed, err := compressPayload(encoding, body)
+		if err != nil {
+			return nil, "", err
+		}
+		trackPayloadCompression(route, encoding, len(body), len(compressed))
+		bodyReader = bytes.NewBuffer(compressed)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bodyReader)
+	if err != nil {
+		return nil, "", err
+	}
+	if encoding != encodingNone {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if policy.contentType != "" {
+		req.Header.Set("Content-Type", policy.contentType)
+	}
+	if policy.date {
+		req.Header.Set("x-ms-date", time.Now().Format(time.RFC3339))
+	}
+	reqID := ""
+	if policy.requestID {
+		reqID = uuid.New().String()
+		req.Header.Set("X-Request-ID", reqID)
+	}
+	//expensive to do string len for every request, so use a flag
+	if policy.resourceID && ResourceCentric == true {
+		req.Header.Set("x-ms-AzureResourceId", ResourceID)
+	}
+	if policy.authorization != nil {
+		token, err := policy.authorization()
+		if err != nil {
+			return nil, reqID, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	setRouteRequestHeaders(req, route)
+	return req, reqID, nil
+}
+
+// odsRequestAuthorization returns the ODS ingestion token in the MSI and workload identity auth modes
+func odsRequestAuthorization() (string, error) {
+	if IsAADMSIAuthMode == false && IsWorkloadIdentityAuthMode == false {
+		return "", nil
+	}
+	ingestionAuthToken := getODSIngestionAuthToken()
+	if ingestionAuthToken == "" {
+		return "", errors.New("ODS Ingestion Auth Token is empty. Please check error log.")
+	}
+	return ingestionAuthToken, nil
+}
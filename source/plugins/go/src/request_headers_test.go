@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_parseCustomHeaders(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		output   map[string]string
+		isError  bool
+	}
+
+	tests := []test_struct{
+		{"single header", "x-traffic-class=monitoring", map[string]string{"X-Traffic-Class": "monitoring"}, false},
+		{"multiple headers with spaces", " X-Traffic-Class = monitoring ; X-Tenant=contoso;", map[string]string{"X-Traffic-Class": "monitoring", "X-Tenant": "contoso"}, false},
+		{"missing value", "X-Traffic-Class", nil, true},
+		{"invalid name", "X Traffic=monitoring", nil, true},
+		{"reserved header", "authorization=Bearer abc", nil, true},
+		{"control character in value", "X-Traffic-Class=a\x01b", nil, true},
+		{"too many headers", "a=1;b=2;c=3;d=4;e=5;f=6", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := parseCustomHeaders(tt.value)
+			if (err != nil) != tt.isError {
+				t.Fatalf("parseCustomHeaders(%q) error = %v, want error %v", tt.value, err, tt.isError)
+			}
+			if len(got) != len(tt.output) {
+				t.Errorf("parseCustomHeaders(%q) = %v, want %v", tt.value, got, tt.output)
+			}
+			for name, value := range tt.output {
+				if got.Get(name) != value {
+					t.Errorf("parseCustomHeaders(%q) %s = %s, want %s", tt.value, name, got.Get(name), value)
+				}
+			}
+		})
+	}
+}
+
+func Test_newRouteRequest(t *testing.T) {
+	type test_struct struct {
+		testname        string
+		route           string
+		resourceCentric bool
+		headers         map[string]string
+		isError         bool
+	}
+
+	tests := []test_struct{
+		{"ods route", requestRouteODS, false, map[string]string{"Content-Type": "application/json", "x-ms-AzureResourceId": ""}, false},
+		{"ods route of a resource centric cluster", requestRouteODS, true, map[string]string{"x-ms-AzureResourceId": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/cluster"}, false},
+		{"amcs route", requestRouteAMCS, true, map[string]string{"Content-Type": "", "X-Request-ID": "", "x-ms-AzureResourceId": ""}, false},
+		{"unknown route", "unknown", false, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ResourceCentric = tt.resourceCentric
+			ResourceID = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/cluster"
+			defer func() { ResourceCentric = false }()
+
+			req, reqID, err := newRouteRequest(context.Background(), "POST", tt.route, "https://workspace.ods.opinsights.azure.com/OperationalData.svc/PostJsonDataItems", []byte("{}"))
+			if (err != nil) != tt.isError {
+				t.Fatalf("newRouteRequest(%s) error = %v, want error %v", tt.route, err, tt.isError)
+			}
+			if err != nil {
+				return
+			}
+			if req.Header.Get("User-Agent") != userAgent {
+				t.Errorf("newRouteRequest(%s) User-Agent = %s, want %s", tt.route, req.Header.Get("User-Agent"), userAgent)
+			}
+			if req.Header.Get("X-Request-ID") != reqID {
+				t.Errorf("newRouteRequest(%s) X-Request-ID = %s, want %s", tt.route, req.Header.Get("X-Request-ID"), reqID)
+			}
+			for name, value := range tt.headers {
+				if req.Header.Get(name) != value {
+					t.Errorf("newRouteRequest(%s) %s = %s, want %s", tt.route, name, req.Header.Get(name), value)
+				}
+			}
+		})
+	}
+}
+
+func Test_initializeRequestHeaderPolicies(t *testing.T) {
+	type test_struct struct {
+		testname string
+		settings map[string]string
+		want     requestHeaderPolicy
+	}
+
+	tests := []test_struct{
+		{"not configured", nil, requestHeaderPolicy{contentType: "application/json", date: true, requestID: true, resourceID: true}},
+		{"configured", map[string]string{"ods_header_content_type": "application/x-ndjson", "ods_header_date": "false", "ods_header_resource_id": "false"}, requestHeaderPolicy{contentType: "application/x-ndjson", requestID: true}},
+		{"invalid setting", map[string]string{"ods_header_content_type": "text/plain", "ods_header_request_id": "maybe"}, requestHeaderPolicy{contentType: "application/json", date: true, requestID: true, resourceID: true}},
+	}
+
+	defer func() { PluginConfiguration, requestHeaderPolicies = nil, defaultRequestHeaderPolicies }()
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			PluginConfiguration = tt.settings
+			initializeRequestHeaderPolicies()
+			got := requestHeaderPolicies[requestRouteODS]
+			if got.contentType != tt.want.contentType || got.date != tt.want.date || got.requestID != tt.want.requestID || got.resourceID != tt.want.resourceID {
+				t.Errorf("initializeRequestHeaderPolicies() ods policy = %+v, want %+v", got, tt.want)
+			}
+			if got.authorization == nil {
+				t.Errorf("initializeRequestHeaderPolicies() dropped the authorization of the ods route")
+			}
+			if len(requestHeaderPolicies) != len(defaultRequestHeaderPolicies) {
+				t.Errorf("initializeRequestHeaderPolicies() has %d routes, want %d", len(requestHeaderPolicies), len(defaultRequestHeaderPolicies))
+			}
+		})
+	}
+}
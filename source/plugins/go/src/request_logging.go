@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const redactedValue = "REDACTED"
+
+// the headers whose values are never written to the runtime log, on top of the headers matching
+// sensitiveRequestHeaderRegex
+var sensitiveRequestHeaders = map[string]bool{
+	"authorization":        true,
+	"proxy-authorization":  true,
+	"cookie":               true,
+	"x-ms-azureresourceid": true,
+}
+
+// the custom headers of the routes carrying keys and tokens
+var sensitiveRequestHeaderRegex = regexp.MustCompile(`(?i)(key|token|secret|signature|password|auth)`)
+
+// the workspace, subscription and resource ids of the endpoints
+var requestURLIDRegex = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// scrubRequestHeaders returns a copy of the headers with the values of the sensitive headers redacted
+func scrubRequestHeaders(header http.Header) http.Header {
+	scrubbed := make(http.Header, len(header))
+	for name, values := range header {
+		if sensitiveRequestHeaders[strings.ToLower(name)] || sensitiveRequestHeaderRegex.MatchString(name) {
+			scrubbed[name] = []string{redactedValue}
+			continue
+		}
+		scrubbed[name] = append([]string(nil), values...)
+	}
+	return scrubbed
+}
+
+// scrubRequestURL returns the url with the ids and the values of the query redacted
+func scrubRequestURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	scrubbed := *u
+	scrubbed.User = nil
+	if scrubbed.RawQuery != "" {
+		query := scrubbed.Query()
+		for name := range query {
+			query[name] = []string{redactedValue}
+		}
+		scrubbed.RawQuery = query.Encode()
+	}
+	return requestURLIDRegex.ReplaceAllString(scrubbed.String(), redactedValue)
+}
+
+// describeRequest returns the method, url, headers and body size of the request, with the ids and the secrets redacted
+func describeRequest(req *http.Request) string {
+	header := scrubRequestHeaders(req.Header)
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers := make([]string, 0, len(names))
+	for _, name := range names {
+		headers = append(headers, fmt.Sprintf("%s=%s", name, strings.Join(header[name], ",")))
+	}
+	return fmt.Sprintf("%s %s Headers [%s] ContentLength %d", req.Method, scrubRequestURL(req.URL), strings.Join(headers, " "), req.ContentLength)
+}
+
+// logRouteRequest writes the redacted request of the route to the runtime log when the log is at the debug level
+func logRouteRequest(route string, req *http.Request) {
+	if !PluginLogger.enabled(pluginLogLevelDebug) {
+		return
+	}
+	LogWith(pluginLogFields{Route: route, RequestID: req.Header.Get("X-Request-ID")}, "Debug::http::Request %s", describeRequest(req))
+}
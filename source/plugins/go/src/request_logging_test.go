@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_describeRequest(t *testing.T) {
+	type test_struct struct {
+		testname string
+		url      string
+		headers  map[string]string
+		want     string
+		leaked   []string
+	}
+
+	workspaceID := "0f8fad5b-d9cb-469f-a165-70867728950e"
+	tests := []test_struct{
+		{
+			"ods request",
+			"https://" + workspaceID + ".ods.opinsights.azure.com/OperationalData.svc/PostJsonDataItems",
+			map[string]string{"Authorization": "Bearer secret-token", "Content-Type": "application/json", "x-ms-AzureResourceId": "/subscriptions/sub/resourceGroups/rg"},
+			"POST https://REDACTED.ods.opinsights.azure.com/OperationalData.svc/PostJsonDataItems Headers [Authorization=REDACTED Content-Type=application/json X-Ms-Azureresourceid=REDACTED] ContentLength 0",
+			[]string{workspaceID, "secret-token", "/subscriptions/sub"},
+		},
+		{
+			"custom key header and query",
+			"https://dce.ingest.monitor.azure.com/dataCollectionRules/dcr-1/streams/Custom?api-version=2021-11-01-preview&sig=abc",
+			map[string]string{"X-Api-Key": "k1", "X-Request-ID": "r1"},
+			"POST https://dce.ingest.monitor.azure.com/dataCollectionRules/dcr-1/streams/Custom?api-version=REDACTED&sig=REDACTED Headers [X-Api-Key=REDACTED X-Request-Id=r1] ContentLength 0",
+			[]string{"k1", "sig=abc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, tt.url, nil)
+			if err != nil {
+				t.Fatalf("unable to build the request: %s", err.Error())
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			got := describeRequest(req)
+			if got != tt.want {
+				t.Errorf("describeRequest() = %q, want %q", got, tt.want)
+			}
+			for _, secret := range tt.leaked {
+				if strings.Contains(got, secret) {
+					t.Errorf("describeRequest() = %q, leaks %q", got, secret)
+				}
+			}
+			if req.Header.Get("Authorization") != tt.headers["Authorization"] {
+				t.Errorf("the headers of the request were changed")
+			}
+		})
+	}
+}
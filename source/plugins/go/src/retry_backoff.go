@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+// env variables of the backoff of the routes after failed sends
+const (
+	// base delay (seconds) of the exponential backoff, 1 by default. The backoff is off when 0
+	envRetryBackoffBaseSeconds = "AZMON_RETRY_BACKOFF_BASE_SECONDS"
+	// max delay (seconds) of the backoff, 300 by default
+	envRetryBackoffMaxSeconds = "AZMON_RETRY_BACKOFF_MAX_SECONDS"
+	// how long (seconds) the chunks are retried while the route keeps failing, before they are dropped. The chunks are
+	// retried until the route recovers when not set or 0
+	envRetryMaxDurationSeconds = "AZMON_RETRY_MAX_DURATION_SECONDS"
+	// the longest backoff (seconds) waited within a flush, 10 by default. The longer ones are retried by fluent-bit, which
+	// uses up the Retry_Limit of the chunk
+	envRetryBackoffMaxWaitSeconds = "AZMON_RETRY_BACKOFF_MAX_WAIT_SECONDS"
+)
+
+const (
+	defaultRetryBackoffBaseSeconds    = 1
+	defaultRetryBackoffMaxSeconds     = 300
+	defaultRetryBackoffMaxWaitSeconds = 10
+	// the max delay honored from a Retry-After header
+	maxRetryAfter = time.Hour
+)
+
+// retryBackoffState the failures of a route since its last successful send
+type retryBackoffState struct {
+	failures     int
+	failingSince time.Time
+	// the chunks are not sent before this time
+	retryAt time.Time
+}
+
+var (
+	// RetryBackoffBase the base delay of the backoff, 0 when the backoff is off
+	RetryBackoffBase time.Duration
+	// RetryBackoffMax the max delay of the backoff
+	RetryBackoffMax time.Duration
+	// RetryMaxDuration how long the chunks are retried while the route keeps failing, 0 when they are retried until the
+	// route recovers
+	RetryMaxDuration time.Duration
+	// RetryBackoffMaxWait the longest backoff waited within a flush
+	RetryBackoffMaxWait time.Duration
+	// RetryBackoffStates the backoff of each route
+	RetryBackoffStates = make(map[string]*retryBackoffState)
+	// RetryBackoffMutex read and write mutex access to RetryBackoffStates
+	RetryBackoffMutex = &sync.Mutex{}
+)
+
+// initializeRetryBackoff reads the backoff configuration
+func initializeRetryBackoff() {
+	RetryBackoffBase = readRetryBackoffSeconds(envRetryBackoffBaseSeconds, defaultRetryBackoffBaseSeconds)
+	RetryBackoffMax = readRetryBackoffSeconds(envRetryBackoffMaxSeconds, defaultRetryBackoffMaxSeconds)
+	RetryMaxDuration = readRetryBackoffSeconds(envRetryMaxDurationSeconds, 0)
+	RetryBackoffMaxWait = readRetryBackoffSeconds(envRetryBackoffMaxWaitSeconds, defaultRetryBackoffMaxWaitSeconds)
+	if RetryBackoffMax < RetryBackoffBase {
+		RetryBackoffMax = RetryBackoffBase
+	}
+	RetryBackoffMutex.Lock()
+	RetryBackoffStates = make(map[string]*retryBackoffState)
+	RetryBackoffMutex.Unlock()
+	if RetryBackoffBase == 0 {
+		Log("The backoff of the routes after failed sends is disabled")
+		return
+	}
+	Log("Routes back off from %s to %s after failed sends, the chunks are retried for at most %s (0 is until the route recovers)", RetryBackoffBase, RetryBackoffMax, RetryMaxDuration)
+}
+
+func readRetryBackoffSeconds(name string, defaultSeconds int) time.Duration {
+	seconds := defaultSeconds
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			seconds = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d seconds", value, name, defaultSeconds)
+		}
+	}
+	return time.Second * time.Duration(seconds)
+}
+
+// waitRetryBackoff waits for the end of the backoff of the route when it is at most RetryBackoffMaxWait away, so the
+// short backoffs don't use up the fluent-bit retries of the chunk, and then checks the backoff like checkRetryBackoff
+func waitRetryBackoff(caller string, route string, numRecords int) (int, bool) {
+	now := time.Now()
+	if wait := retryBackoffRemaining(route, now); wait > 0 && wait <= RetryBackoffMaxWait {
+		time.Sleep(wait)
+		now = time.Now()
+	}
+	return checkRetryBackoff(caller, route, numRecords, now)
+}
+
+// retryBackoffRemaining returns how long the route is still backing off, 0 when it is not
+func retryBackoffRemaining(route string, now time.Time) time.Duration {
+	RetryBackoffMutex.Lock()
+	defer RetryBackoffMutex.Unlock()
+	if state, ok := RetryBackoffStates[route]; ok && now.Before(state.retryAt) {
+		return state.retryAt.Sub(now)
+	}
+	return 0
+}
+
+// checkRetryBackoff returns whether the chunk is not sent since the route is backing off, and the return code of the
+// flush when it is not: FLB_RETRY, or FLB_ERROR once the route has failed for longer than the max retry duration
+func checkRetryBackoff(caller string, route string, numRecords int, now time.Time) (int, bool) {
+	if RetryBackoffBase == 0 {
+		return output.FLB_OK, false
+	}
+	RetryBackoffMutex.Lock()
+	state, ok := RetryBackoffStates[route]
+	if !ok || !now.Before(state.retryAt) {
+		RetryBackoffMutex.Unlock()
+		return output.FLB_OK, false
+	}
+	expired := isRetryExpired(state, now)
+	retryIn, failures := state.retryAt.Sub(now), state.failures
+	RetryBackoffMutex.Unlock()
+	if expired {
+		dropExpiredRetry(caller, route, numRecords)
+		return output.FLB_ERROR, true
+	}
+	Log("%s::Warning::the %s route is backing off for %s after %d failures, will retry", caller, route, retryIn, failures)
+	return output.FLB_RETRY, true
+}
+
+// recordRetryBackoffOutcome updates the backoff of the route with the return code of a flush, and returns the return
+// code of the flush: FLB_ERROR instead of FLB_RETRY once the route has failed for longer than the max retry duration
+func recordRetryBackoffOutcome(caller string, route string, retCode int, numRecords int, now time.Time) int {
+	if RetryBackoffBase == 0 {
+		return retCode
+	}
+	RetryBackoffMutex.Lock()
+	state, ok := RetryBackoffStates[route]
+	if retCode != output.FLB_RETRY {
+		if ok {
+			delete(RetryBackoffStates, route)
+			RetryBackoffMutex.Unlock()
+			Log("%s::Info::the %s route recovered after %d failures in %s", caller, route, state.failures, now.Sub(state.failingSince))
+			return retCode
+		}
+		RetryBackoffMutex.Unlock()
+		return retCode
+	}
+	if !ok {
+		state = &retryBackoffState{failingSince: now}
+		RetryBackoffStates[route] = state
+	}
+	backoff := jitteredBackoff(state.failures, RetryBackoffBase)
+	if backoff > RetryBackoffMax {
+		backoff = RetryBackoffMax
+	}
+	state.failures++
+	if retryAt := now.Add(backoff); retryAt.After(state.retryAt) {
+		state.retryAt = retryAt
+	}
+	expired := isRetryExpired(state, now)
+	RetryBackoffMutex.Unlock()
+	if expired {
+		dropExpiredRetry(caller, route, numRecords)
+		return output.FLB_ERROR
+	}
+	return retCode
+}
+
+// delayRouteRetry delays the next send of the route by the Retry-After of the destination
+func delayRouteRetry(route string, retryAfter time.Duration, now time.Time) {
+	if RetryBackoffBase == 0 || retryAfter <= 0 {
+		return
+	}
+	if retryAfter > maxRetryAfter {
+		retryAfter = maxRetryAfter
+	}
+	RetryBackoffMutex.Lock()
+	defer RetryBackoffMutex.Unlock()
+	state, ok := RetryBackoffStates[route]
+	if !ok {
+		state = &retryBackoffState{failingSince: now}
+		RetryBackoffStates[route] = state
+	}
+	if retryAt := now.Add(retryAfter); retryAt.After(state.retryAt) {
+		state.retryAt = retryAt
+	}
+}
+
+// delayRouteRetryForError delays the next send of the route by the Retry-After of a send refused by the destination
+func delayRouteRetryForError(route string, err error) {
+	var statusErr *sinkStatusError
+	if errors.As(err, &statusErr) {
+		delayRouteRetry(route, statusErr.retryAfter, time.Now())
+	}
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, in seconds or as an http date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Second * time.Duration(seconds)
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+func isRetryExpired(state *retryBackoffState, now time.Time) bool {
+	return RetryMaxDuration > 0 && now.Sub(state.failingSince) > RetryMaxDuration
+}
+
+func dropExpiredRetry(caller string, route string, numRecords int) {
+	Log("%s::Warning::dropping %d records since the %s route has failed for longer than %s", caller, numRecords, route, RetryMaxDuration)
+	ContainerLogTelemetryMutex.Lock()
+	defer ContainerLogTelemetryMutex.Unlock()
+	RetryExpiredRecords += float64(numRecords)
+}
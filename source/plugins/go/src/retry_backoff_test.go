@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		output   time.Duration
+	}
+
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []test_struct{
+		{"not set", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"negative seconds", "-1", 0},
+		{"http date", "Tue, 01 Jun 2021 00:00:30 GMT", 30 * time.Second},
+		{"http date in the past", "Mon, 31 May 2021 23:00:00 GMT", 0},
+		{"invalid", "soon", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.output {
+				t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_retryBackoff(t *testing.T) {
+	type test_struct struct {
+		testname    string
+		maxDuration string
+		// the return codes of the flushes, one second apart
+		flushes []int
+		// the delay after the last flush before the next one is sent, within the jitter
+		minDelay    time.Duration
+		maxDelay    time.Duration
+		lastRetCode int
+	}
+
+	tests := []test_struct{
+		{"first failure", "", []int{output.FLB_RETRY}, time.Second, 1500 * time.Millisecond, output.FLB_RETRY},
+		{"third failure", "", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY}, 4 * time.Second, 6 * time.Second, output.FLB_RETRY},
+		{"capped at the max", "", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY}, 10 * time.Second, 10 * time.Second, output.FLB_RETRY},
+		{"recovered", "", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_OK}, 0, 0, output.FLB_OK},
+		{"over the max retry duration", "2", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY}, 8 * time.Second, 10 * time.Second, output.FLB_ERROR},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			os.Setenv(envRetryBackoffMaxSeconds, "10")
+			os.Setenv(envRetryMaxDurationSeconds, tt.maxDuration)
+			defer os.Unsetenv(envRetryBackoffMaxSeconds)
+			defer os.Unsetenv(envRetryMaxDurationSeconds)
+			initializeRetryBackoff()
+			now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+			retCode := output.FLB_OK
+			for _, flush := range tt.flushes {
+				now = now.Add(time.Second)
+				retCode = recordRetryBackoffOutcome("test", "v1", flush, 1, now)
+			}
+			if retCode != tt.lastRetCode {
+				t.Errorf("return code of the last flush = %d, want %d", retCode, tt.lastRetCode)
+			}
+			if _, backingOff := checkRetryBackoff("test", "v1", 1, now.Add(tt.minDelay-time.Millisecond)); tt.minDelay > 0 && !backingOff {
+				t.Errorf("the route is not backing off %s after the last flush", tt.minDelay-time.Millisecond)
+			}
+			if _, backingOff := checkRetryBackoff("test", "v1", 1, now.Add(tt.maxDelay)); backingOff {
+				t.Errorf("the route is backing off %s after the last flush", tt.maxDelay)
+			}
+		})
+	}
+}
+
+func Test_delayRouteRetry(t *testing.T) {
+	os.Unsetenv(envRetryMaxDurationSeconds)
+	initializeRetryBackoff()
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	delayRouteRetry("v1", parseRetryAfter("60", now), now)
+	recordRetryBackoffOutcome("test", "v1", output.FLB_RETRY, 1, now)
+	if retCode, backingOff := checkRetryBackoff("test", "v1", 1, now.Add(59*time.Second)); !backingOff || retCode != output.FLB_RETRY {
+		t.Errorf("the route does not honor the Retry-After of 60 seconds")
+	}
+	if _, backingOff := checkRetryBackoff("test", "v1", 1, now.Add(60*time.Second)); backingOff {
+		t.Errorf("the route is backing off after the Retry-After")
+	}
+}
+
+func Test_waitRetryBackoff(t *testing.T) {
+	os.Unsetenv(envRetryMaxDurationSeconds)
+	initializeRetryBackoff()
+	RetryBackoffMaxWait = time.Second
+
+	delayRouteRetry("v1", 50*time.Millisecond, time.Now())
+	start := time.Now()
+	if _, backingOff := waitRetryBackoff("test", "v1", 1); backingOff {
+		t.Errorf("the flush did not wait for the backoff of 50ms")
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("waitRetryBackoff() returned after %v, want after the backoff of 50ms", elapsed)
+	}
+
+	delayRouteRetry("v1", time.Minute, time.Now())
+	if retCode, backingOff := waitRetryBackoff("test", "v1", 1); !backingOff || retCode != output.FLB_RETRY {
+		t.Errorf("the flush waited for the backoff longer than %s", RetryBackoffMaxWait)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+// env variables of the retry budget
+const (
+	// chunks in retry per route beyond which the route degrades. The retry budget is off when not set
+	envRetryBudgetChunks = "AZMON_RETRY_BUDGET_CHUNKS"
+	// ratio of the container log records kept while over the budget, between 0 and 1 (default 1, no sampling)
+	envRetryBudgetSamplingRatio = "AZMON_RETRY_BUDGET_SAMPLING_RATIO"
+	// directory the failed container log chunks are written to while over the budget, instead of being retried
+	envRetryBudgetDeadLetterDir = "AZMON_RETRY_BUDGET_DEAD_LETTER_DIR"
+	// cap on the size of the dead-letter directory, in MB
+	envRetryBudgetDeadLetterMaxMB = "AZMON_RETRY_BUDGET_DEAD_LETTER_MAX_MB"
+)
+
+const defaultRetryBudgetDeadLetterMaxMB = 100
+
+var (
+	// RetryBudgetChunks the chunks in retry per route beyond which the route degrades, 0 when the budget is off
+	RetryBudgetChunks int
+	// RetryBudgetSamplingRatio the ratio of the container log records kept while over the budget
+	RetryBudgetSamplingRatio = 1.0
+	// RetryBudgetDeadLetterDir the dead-letter directory, empty when the failed chunks are retried
+	RetryBudgetDeadLetterDir string
+	// RetryBudgetDeadLetterMaxBytes the cap on the bytes written to the dead-letter directory
+	RetryBudgetDeadLetterMaxBytes int64
+	// ChunksInRetry the chunks returned with FLB_RETRY and not followed by a successful flush yet, per route, tracked
+	// whether the budget is on or off
+	ChunksInRetry = make(map[string]int)
+	// ChunksInRetryMutex read and write mutex access to ChunksInRetry and the dead-letter bytes
+	ChunksInRetryMutex        = &sync.Mutex{}
+	retryBudgetDeadLetterSize int64
+)
+
+// initializeRetryBudget reads the retry budget configuration
+func initializeRetryBudget() {
+	RetryBudgetChunks = 0
+	value := strings.TrimSpace(os.Getenv(envRetryBudgetChunks))
+	if value == "" {
+		return
+	}
+	chunks, err := strconv.Atoi(value)
+	if err != nil || chunks <= 0 {
+		Log("Invalid value %s for %s, the retry budget is disabled", value, envRetryBudgetChunks)
+		return
+	}
+	RetryBudgetChunks = chunks
+
+	RetryBudgetSamplingRatio = 1.0
+	if value := strings.TrimSpace(os.Getenv(envRetryBudgetSamplingRatio)); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			Log("Invalid value %s for %s, the records are not sampled", value, envRetryBudgetSamplingRatio)
+		} else {
+			RetryBudgetSamplingRatio = ratio
+		}
+	}
+
+	RetryBudgetDeadLetterDir = strings.TrimSpace(os.Getenv(envRetryBudgetDeadLetterDir))
+	maxMB := defaultRetryBudgetDeadLetterMaxMB
+	if value := strings.TrimSpace(os.Getenv(envRetryBudgetDeadLetterMaxMB)); value != "" {
+		if mb, err := strconv.Atoi(value); err == nil && mb > 0 {
+			maxMB = mb
+		} else {
+			Log("Invalid value %s for %s, using the default %d MB", value, envRetryBudgetDeadLetterMaxMB, defaultRetryBudgetDeadLetterMaxMB)
+		}
+	}
+	RetryBudgetDeadLetterMaxBytes = int64(maxMB) * 1024 * 1024
+	if RetryBudgetDeadLetterDir != "" {
+		if err := os.MkdirAll(RetryBudgetDeadLetterDir, 0755); err != nil {
+			Log("Error::retrybudget::Unable to create the dead-letter directory %s, the failed chunks are retried: %s", RetryBudgetDeadLetterDir, err.Error())
+			RetryBudgetDeadLetterDir = ""
+		}
+	}
+	Log("Retry budget = %d chunks per route, sampling ratio = %.2f, dead-letter directory = %s", RetryBudgetChunks, RetryBudgetSamplingRatio, RetryBudgetDeadLetterDir)
+}
+
+// recordFlushOutcome tracks the chunks in retry of the route, for the budget and the backlog stats. A successful flush
+// is assumed to be a retried chunk going through, since fluent-bit does not tell the retries apart
+func recordFlushOutcome(route string, retCode int) {
+	ChunksInRetryMutex.Lock()
+	if retCode == output.FLB_RETRY {
+		ChunksInRetry[route]++
+	} else if ChunksInRetry[route] > 0 {
+		ChunksInRetry[route]--
+	}
+	chunksInRetry := ChunksInRetry[route]
+	trackRetryingSince(route, chunksInRetry, time.Now())
+	ChunksInRetryMutex.Unlock()
+	PluginMetrics.addFlushOutcome(route, retCode)
+
+	if route == getContainerLogsRouteName() {
+		ContainerLogTelemetryMutex.Lock()
+		ContainerLogsChunksInRetry = float64(chunksInRetry)
+		ContainerLogTelemetryMutex.Unlock()
+	}
+}
+
+// isOverRetryBudget returns whether the route has more chunks in retry than the budget
+func isOverRetryBudget(route string) bool {
+	if RetryBudgetChunks <= 0 {
+		return false
+	}
+	ChunksInRetryMutex.Lock()
+	defer ChunksInRetryMutex.Unlock()
+	return ChunksInRetry[route] > RetryBudgetChunks
+}
+
+// sampleRecords keeps the sampling ratio of the records
+func sampleRecords(records []map[interface{}]interface{}, ratio float64) []map[interface{}]interface{} {
+	if ratio >= 1 {
+		return records
+	}
+	sampled := make([]map[interface{}]interface{}, 0, int(float64(len(records))*ratio)+1)
+	for _, record := range records {
+		if rand.Float64() < ratio {
+			sampled = append(sampled, record)
+		}
+	}
+	return sampled
+}
+
+// updateRetryBudgetTelemetry counts the records dropped or dead-lettered by the retry budget
+func updateRetryBudgetTelemetry(droppedRecords int, deadLetteredRecords int) {
+	ContainerLogTelemetryMutex.Lock()
+	RetryBudgetDroppedRecordCount += float64(droppedRecords)
+	RetryBudgetDeadLetteredRecordCount += float64(deadLetteredRecords)
+	ContainerLogTelemetryMutex.Unlock()
+}
+
+// writeDeadLetterChunk writes the records of a failed chunk as json lines to the dead-letter directory
+func writeDeadLetterChunk(route string, records []map[interface{}]interface{}) error {
+	var builder strings.Builder
+	for _, record := range records {
+		line, err := json.Marshal(toDeadLetterRecord(record))
+		if err != nil {
+			return err
+		}
+		builder.Write(line)
+		builder.WriteString("\n")
+	}
+	content := builder.String()
+
+	ChunksInRetryMutex.Lock()
+	defer ChunksInRetryMutex.Unlock()
+	if retryBudgetDeadLetterSize+int64(len(content)) > RetryBudgetDeadLetterMaxBytes {
+		return fmt.Errorf("the dead-letter directory %s is full (%d MB)", RetryBudgetDeadLetterDir, RetryBudgetDeadLetterMaxBytes/1024/1024)
+	}
+	fileName := filepath.Join(RetryBudgetDeadLetterDir, fmt.Sprintf("%s-%d.json", route, time.Now().UnixNano()))
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		return err
+	}
+	retryBudgetDeadLetterSize += int64(len(content))
+	return nil
+}
+
+// toDeadLetterRecord converts the fluent-bit record to a json serializable map, nested maps included
+func toDeadLetterRecord(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case map[interface{}]interface{}:
+		record := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			record[fmt.Sprintf("%v", toDeadLetterRecord(key))] = toDeadLetterRecord(value)
+		}
+		return record
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			values[i] = toDeadLetterRecord(value)
+		}
+		return values
+	}
+	return value
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+func Test_isOverRetryBudget(t *testing.T) {
+	RetryBudgetChunks = 2
+	defer func() { RetryBudgetChunks = 0 }()
+
+	type test_struct struct {
+		testname string
+		outcomes []int
+		output   bool
+	}
+
+	tests := []test_struct{
+		{"no retries", []int{output.FLB_OK, output.FLB_OK}, false},
+		{"retries within the budget", []int{output.FLB_RETRY, output.FLB_RETRY}, false},
+		{"retries over the budget", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY}, true},
+		{"retried chunks going through", []int{output.FLB_RETRY, output.FLB_RETRY, output.FLB_RETRY, output.FLB_OK}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ChunksInRetry = make(map[string]int)
+			for _, retCode := range tt.outcomes {
+				recordFlushOutcome("test", retCode)
+			}
+			if got := isOverRetryBudget("test"); got != tt.output {
+				t.Errorf("isOverRetryBudget() = %v with %d chunks in retry, want %v", got, ChunksInRetry["test"], tt.output)
+			}
+		})
+	}
+}
+
+func Test_toDeadLetterRecord(t *testing.T) {
+	type test_struct struct {
+		testname string
+		record   map[interface{}]interface{}
+		output   string
+	}
+
+	tests := []test_struct{
+		{"byte values", map[interface{}]interface{}{"log": []byte("line"), "stream": []byte("stdout")}, `{"log":"line","stream":"stdout"}`},
+		{"nested map", map[interface{}]interface{}{"kubernetes": map[interface{}]interface{}{"pod_name": []byte("pod")}}, `{"kubernetes":{"pod_name":"pod"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := json.Marshal(toDeadLetterRecord(tt.record))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.output {
+				t.Errorf("toDeadLetterRecord() = %s, want %s", got, tt.output)
+			}
+		})
+	}
+}
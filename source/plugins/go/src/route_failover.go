@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variable of the route the container logs fail over to when the route is unhealthy. Only the v1 (ODS) route is
+// supported, as the fallback of the v2 (mdsd) route
+const envContainerLogFailoverRoute = "AZMON_CONTAINER_LOG_FAILOVER_ROUTE"
+
+// env variable of how long (seconds) the sends of the route fail before the container logs fail over
+const envContainerLogFailoverThresholdSeconds = "AZMON_CONTAINER_LOG_FAILOVER_THRESHOLD_SECONDS"
+
+// env variable of the interval (seconds) at which a batch is sent to the route again while failed over, to fail back
+// once it recovers
+const envContainerLogFailbackIntervalSeconds = "AZMON_CONTAINER_LOG_FAILBACK_INTERVAL_SECONDS"
+
+const (
+	defaultContainerLogFailoverThresholdSeconds = 300
+	defaultContainerLogFailbackIntervalSeconds  = 60
+
+	eventNameContainerLogRouteFailover = "ContainerLogRouteFailoverEvent"
+	eventNameContainerLogRouteFailback = "ContainerLogRouteFailbackEvent"
+)
+
+// ContainerLogFailoverRoute the route the container logs fail over to, empty when the failover is disabled
+var ContainerLogFailoverRoute string
+
+// newContainerLogFailoverSink returns the sink of the route failing over to the configured fallback route, or the sink
+// of the route when the failover is not configured or not supported
+func newContainerLogFailoverSink(primary Sink) Sink {
+	ContainerLogFailoverRoute = ""
+	route := strings.ToLower(strings.TrimSpace(os.Getenv(envContainerLogFailoverRoute)))
+	if route == "" {
+		return primary
+	}
+	if route != ContainerLogsV1Route || ContainerLogsRouteV2 == false || ContainerLogsRouteGeneva == true || IsWindows == true {
+		Log("Error::failover::Failing over to the %s route is only supported from the %s route on linux, the container logs don't fail over", route, ContainerLogsV2Route)
+		return primary
+	}
+	if err := createSecondaryODSClient(); err != nil {
+		Log("Error::failover::The container logs don't fail over to the %s route: %s", route, err.Error())
+		return primary
+	}
+	threshold := readFailoverSeconds(envContainerLogFailoverThresholdSeconds, defaultContainerLogFailoverThresholdSeconds)
+	failbackInterval := readFailoverSeconds(envContainerLogFailbackIntervalSeconds, defaultContainerLogFailbackIntervalSeconds)
+	ContainerLogFailoverRoute = route
+	Log("Container logs fail over to the %s route after %s of failures of the %s route, and fail back every %s", route, threshold, primary.Name(), failbackInterval)
+	return newFailoverSink(primary, instrumentSink(sinkTypeODS, newODSSink()), threshold, failbackInterval)
+}
+
+func readFailoverSeconds(name string, defaultSeconds int) time.Duration {
+	seconds := defaultSeconds
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			seconds = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d seconds", value, name, defaultSeconds)
+		}
+	}
+	return time.Second * time.Duration(seconds)
+}
+
+// failoverSink sends the batches to the primary sink, and to the fallback sink once the primary has failed for the
+// threshold. While failed over, a batch is sent to the primary every failback interval, and the sink fails back when
+// it succeeds
+type failoverSink struct {
+	primary          Sink
+	fallback         Sink
+	threshold        time.Duration
+	failbackInterval time.Duration
+
+	mu             sync.Mutex
+	failingSince   time.Time
+	failedOver     bool
+	lastPrimaryTry time.Time
+	now            func() time.Time
+	sendEvent      func(eventName string, dimensions map[string]string)
+}
+
+func newFailoverSink(primary Sink, fallback Sink, threshold time.Duration, failbackInterval time.Duration) *failoverSink {
+	return &failoverSink{primary: primary, fallback: fallback, threshold: threshold, failbackInterval: failbackInterval, now: time.Now, sendEvent: SendEvent}
+}
+
+func (s *failoverSink) Name() string {
+	return fmt.Sprintf("%s (failover %s)", s.primary.Name(), s.fallback.Name())
+}
+
+func (s *failoverSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	if s.usePrimary() {
+		err := s.primary.Send(ctx, batch)
+		if !s.setPrimaryResult(err) {
+			return err
+		}
+	}
+	return s.fallback.Send(ctx, odsBatch(batch))
+}
+
+// Healthy whether the sink currently sending the batches is healthy
+func (s *failoverSink) Healthy() bool {
+	if s.isFailedOver() {
+		return s.fallback.Healthy()
+	}
+	return s.primary.Healthy()
+}
+
+func (s *failoverSink) isFailedOver() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failedOver
+}
+
+// usePrimary whether the batch is sent to the primary, always when not failed over and once per failback interval
+// when failed over
+func (s *failoverSink) usePrimary() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.failedOver {
+		return true
+	}
+	if now := s.now(); now.Sub(s.lastPrimaryTry) >= s.failbackInterval {
+		s.lastPrimaryTry = now
+		return true
+	}
+	return false
+}
+
+// setPrimaryResult updates the failover state with the result of a send to the primary, and returns whether the batch
+// is sent to the fallback
+func (s *failoverSink) setPrimaryResult(err error) bool {
+	s.mu.Lock()
+	now := s.now()
+	if err == nil || err == errBatchDropped {
+		failedOver := s.failedOver
+		failingFor := now.Sub(s.failingSince)
+		s.failingSince = time.Time{}
+		s.failedOver = false
+		s.mu.Unlock()
+		if failedOver {
+			Log("Info::failover::The %s route recovered, container logs fail back from the %s route", s.primary.Name(), s.fallback.Name())
+			s.sendTransitionEvent(eventNameContainerLogRouteFailback, failingFor, nil)
+		}
+		return false
+	}
+	if s.failingSince.IsZero() {
+		s.failingSince = now
+	}
+	if s.failedOver {
+		s.mu.Unlock()
+		return true
+	}
+	failingFor := now.Sub(s.failingSince)
+	if failingFor < s.threshold {
+		s.mu.Unlock()
+		return false
+	}
+	s.failedOver = true
+	s.lastPrimaryTry = now
+	s.mu.Unlock()
+	Log("Error::failover::The %s route failed for %s, container logs fail over to the %s route: %s", s.primary.Name(), failingFor, s.fallback.Name(), err.Error())
+	s.sendTransitionEvent(eventNameContainerLogRouteFailover, failingFor, err)
+	return true
+}
+
+func (s *failoverSink) sendTransitionEvent(eventName string, failingFor time.Duration, err error) {
+	telemetryDimensions := make(map[string]string)
+	telemetryDimensions["PrimaryRoute"] = s.primary.Name()
+	telemetryDimensions["FallbackRoute"] = s.fallback.Name()
+	telemetryDimensions["UnhealthySeconds"] = strconv.Itoa(int(failingFor / time.Second))
+	if err != nil {
+		telemetryDimensions["Error"] = err.Error()
+		telemetryDimensions["FailureClass"] = classifySinkError(err)
+	}
+	s.sendEvent(eventName, telemetryDimensions)
+}
+
+// odsBatch returns the batch with the records of the mdsd route as the ODS items of the container log schema
+func odsBatch(batch *containerLogBatch) *containerLogBatch {
+	if len(batch.msgPackEntries) == 0 {
+		return batch
+	}
+	converted := &containerLogBatch{logBytes: batch.logBytes, start: batch.start}
+	for _, entry := range batch.msgPackEntries {
+		if ContainerLogSchemaV2 {
+			converted.dataItemsLAv2 = append(converted.dataItemsLAv2, newDataItemLAv2(entry.Record))
+		} else {
+			converted.dataItemsLAv1 = append(converted.dataItemsLAv1, newDataItemLAv1(entry.Record))
+		}
+	}
+	return converted
+}
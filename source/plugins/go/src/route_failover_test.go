@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_failoverSink(t *testing.T) {
+	type step struct {
+		// seconds since the start
+		at         int
+		primaryErr error
+	}
+	type test_struct struct {
+		testname string
+		steps    []step
+		// the sink sending each step, p for the primary and f for the fallback, or both
+		sentTo []string
+		events []string
+	}
+
+	failed := errors.New("mdsd write failed")
+	tests := []test_struct{
+		{"healthy", []step{{0, nil}, {10, nil}}, []string{"p", "p"}, nil},
+		{"under the threshold", []step{{0, failed}, {30, failed}, {50, nil}}, []string{"p", "p", "p"}, nil},
+		{"fail over", []step{{0, failed}, {60, failed}, {70, failed}}, []string{"p", "pf", "f"}, []string{eventNameContainerLogRouteFailover}},
+		{"still failing at the failback", []step{{0, failed}, {60, failed}, {130, failed}, {140, nil}}, []string{"p", "pf", "pf", "f"}, []string{eventNameContainerLogRouteFailover}},
+		{"fail back", []step{{0, failed}, {60, failed}, {130, nil}, {140, nil}}, []string{"p", "pf", "p", "p"}, []string{eventNameContainerLogRouteFailover, eventNameContainerLogRouteFailback}},
+		{"dropped batches are not failures", []step{{0, errBatchDropped}, {60, errBatchDropped}}, []string{"p", "p"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			primary := &testSink{name: "v2"}
+			fallback := &testSink{name: "v1"}
+			start := time.Now()
+			var now time.Time
+			var events []string
+			sink := newFailoverSink(primary, fallback, 60*time.Second, 60*time.Second)
+			sink.now = func() time.Time { return now }
+			sink.sendEvent = func(eventName string, dimensions map[string]string) { events = append(events, eventName) }
+			var sentTo []string
+			for _, s := range tt.steps {
+				now = start.Add(time.Duration(s.at) * time.Second)
+				primary.err = s.primaryErr
+				primarySends, fallbackSends := primary.sends, fallback.sends
+				sink.Send(context.Background(), &containerLogBatch{})
+				to := ""
+				if primary.sends > primarySends {
+					to += "p"
+				}
+				if fallback.sends > fallbackSends {
+					to += "f"
+				}
+				sentTo = append(sentTo, to)
+			}
+			if !reflect.DeepEqual(sentTo, tt.sentTo) || !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("sent to %v with events %v, want %v with events %v", sentTo, events, tt.sentTo, tt.events)
+			}
+		})
+	}
+}
+
+func Test_odsBatch(t *testing.T) {
+	type test_struct struct {
+		testname string
+		schemaV2 bool
+		record   map[string]string
+		v1       []DataItemLAv1
+		v2       []DataItemLAv2
+	}
+
+	tests := []test_struct{
+		{"ContainerLog", false, map[string]string{"Id": "c1", "LogEntry": "line", "LogEntrySource": "stdout"}, []DataItemLAv1{{ID: "c1", LogEntry: "line", LogEntrySource: "stdout"}}, nil},
+		{"ContainerLogV2", true, map[string]string{"ContainerId": "c1", "LogMessage": "line", "LogSource": "stderr"}, nil, []DataItemLAv2{{ContainerId: "c1", LogMessage: "line", LogSource: "stderr"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ContainerLogSchemaV2 = tt.schemaV2
+			defer func() { ContainerLogSchemaV2 = false }()
+			batch := odsBatch(&containerLogBatch{msgPackEntries: []MsgPackEntry{{Record: tt.record}}, logBytes: 4})
+			if len(batch.msgPackEntries) != 0 || batch.logBytes != 4 || !reflect.DeepEqual(batch.dataItemsLAv1, tt.v1) || !reflect.DeepEqual(batch.dataItemsLAv2, tt.v2) {
+				t.Errorf("odsBatch() = %+v, want %v %v", batch, tt.v1, tt.v2)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// env variable for the interval (seconds) of the probes comparing the latency of the ODS and mdsd routes. The probes are
+// off when not set or 0
+const envRouteProbeIntervalSeconds = "AZMON_ROUTE_PROBE_INTERVAL_SECONDS"
+
+const (
+	minRouteProbeIntervalSeconds = 60
+	routeProbeTimeout            = 30 * time.Second
+)
+
+// RouteProbeTicker to probe the container log routes periodically
+var RouteProbeTicker *time.Ticker
+
+// routeProbeResult the outcome of a probe of a route
+type routeProbeResult struct {
+	route      string
+	latency    time.Duration
+	statusCode string
+	err        error
+}
+
+// startRouteProbes probes the ODS and mdsd routes periodically when both are available on the node. The probes send
+// batches without records, so no data is ingested
+func startRouteProbes() {
+	value := strings.TrimSpace(os.Getenv(envRouteProbeIntervalSeconds))
+	if value == "" || value == "0" {
+		return
+	}
+	intervalSeconds, err := strconv.Atoi(value)
+	if err != nil || intervalSeconds < minRouteProbeIntervalSeconds {
+		Log("Invalid value %s for %s, the interval is at least %d seconds. The route probes are disabled", value, envRouteProbeIntervalSeconds, minRouteProbeIntervalSeconds)
+		return
+	}
+	if IsWindows == true || ContainerLogsRouteADX == true || ContainerLogsRouteGeneva == true || ContainerLogsRouteDCR == true || ContainerLogsRouteKafka == true || ContainerLogsRouteEventHubs == true || ContainerLogsRouteOTLP == true {
+		Log("The route probes compare the %s and %s routes, they are disabled on this node", ContainerLogsV1Route, ContainerLogsV2Route)
+		return
+	}
+	if _, err := os.Stat(getMdsdFluentSocketPath(ContainerType)); err != nil {
+		Log("The route probes are disabled since the mdsd socket is not available: %s", err.Error())
+		return
+	}
+	if ContainerLogsRouteV2 == true {
+		// the ODS client is only created for the ODS route
+		if err := createSecondaryODSClient(); err != nil {
+			Log("The route probes are disabled: %s", err.Error())
+			return
+		}
+	}
+
+	Log("Probing the %s and %s routes every %d seconds", ContainerLogsV1Route, ContainerLogsV2Route, intervalSeconds)
+	RouteProbeTicker = time.NewTicker(time.Second * time.Duration(intervalSeconds))
+	go probeRoutes()
+}
+
+func probeRoutes() {
+	for ; true; <-RouteProbeTicker.C {
+		for _, result := range []routeProbeResult{probeODSRoute(), probeMdsdRoute()} {
+			trackRouteProbe(result)
+		}
+	}
+}
+
+// probeODSRoute posts a container log blob without records to ODS
+func probeODSRoute() routeProbeResult {
+	result := routeProbeResult{route: ContainerLogsV1Route}
+	blob, err := json.Marshal(ContainerLogBlobLAv1{DataType: ContainerLogDataType, IPName: IPName, DataItems: []DataItemLAv1{}})
+	if err != nil {
+		result.err = err
+		return result
+	}
+	ctx, cancel := context.WithTimeout(ParentContext, routeProbeTimeout)
+	defer cancel()
+	req, _, err := newRouteRequest(ctx, "POST", requestRouteODS, OMSEndpoint, blob)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	start := time.Now()
+	resp, err := doRouteRequest(requestRouteODS, req)
+	result.latency = time.Since(start)
+	result.statusCode = httpDependencyResultCode(resp, err)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		result.err = &sinkStatusError{statusCode: resp.StatusCode}
+	}
+	return result
+}
+
+// probeMdsdRoute writes a forward message without entries to mdsd, on its own connection so the probe never holds the
+// connection of the flushes
+func probeMdsdRoute() routeProbeResult {
+	result := routeProbeResult{route: ContainerLogsV2Route}
+	start := time.Now()
+	conn, err := net.DialTimeout("unix", getMdsdFluentSocketPath(ContainerType), routeProbeTimeout)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(routeProbeTimeout))
+	_, result.err = conn.Write(buildMdsdHeartbeat(MdsdContainerLogTagName))
+	result.latency = time.Since(start)
+	return result
+}
+
+func trackRouteProbe(result routeProbeResult) {
+	success := result.err == nil
+	if !success {
+		Log("Error::routeprobe::Probe of the %s route failed: %s", result.route, result.err.Error())
+	}
+	properties := map[string]string{"Route": result.route, "Success": strconv.FormatBool(success)}
+	if !success {
+		properties["FailureClass"] = classifySinkError(result.err)
+	}
+	if result.statusCode != "" {
+		properties["ResultCode"] = result.statusCode
+	}
+	trackSinkMetric(metricNameRouteProbeLatencyMs, float64(result.latency/time.Millisecond), properties)
+}
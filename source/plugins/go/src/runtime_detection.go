@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+
+	"Docker-Provider/source/plugins/go/src/containermetadata"
+)
+
+const (
+	containerRuntimeDocker     = "docker"
+	containerRuntimeContainerd = "containerd"
+	containerRuntimeCRIO       = "cri-o"
+)
+
+// containerRuntimeSocket the socket a runtime listens on, under the host's /var/run mounted at /var/run/host, and its
+// CRI socket
+type containerRuntimeSocket struct {
+	runtime   string
+	socket    string
+	criSocket string
+}
+
+// the runtime sockets in detection order. Docker nodes usually run containerd too, so docker is checked before it
+var containerRuntimeSockets = []containerRuntimeSocket{
+	{containerRuntimeCRIO, "/var/run/host/crio/crio.sock", "/var/run/host/crio/crio.sock"},
+	{containerRuntimeDocker, "/var/run/host/docker.sock", "/var/run/host/dockershim.sock"},
+	{containerRuntimeContainerd, "/var/run/host/containerd/containerd.sock", defaultCRISocketPath},
+}
+
+// ContainerRuntimeMutex read and write mutex access to containerRuntime, which the enrichment refresh corrects from
+// the container ids of the pods
+var ContainerRuntimeMutex = &sync.RWMutex{}
+
+// initializeContainerRuntime detects the container runtime of the node from its socket. CONTAINER_RUNTIME is only used
+// when no runtime socket is found
+func initializeContainerRuntime() {
+	configured := normalizeContainerRuntime(os.Getenv(ContainerRuntimeEnv))
+	detected := ""
+	for _, runtimeSocket := range containerRuntimeSockets {
+		if _, err := os.Stat(runtimeSocket.socket); err == nil {
+			detected = runtimeSocket.runtime
+			break
+		}
+	}
+
+	runtime := detected
+	switch {
+	case detected == "" && configured == "":
+		runtime = containerRuntimeDocker
+		Log("No container runtime socket found and %s is not set, using %s", ContainerRuntimeEnv, runtime)
+	case detected == "":
+		runtime = configured
+		Log("No container runtime socket found, using %s=%s", ContainerRuntimeEnv, runtime)
+	case configured != "" && configured != detected:
+		Log("Warning::%s=%s does not match the detected container runtime %s, using %s", ContainerRuntimeEnv, configured, detected, detected)
+	}
+	setContainerRuntime(runtime)
+	Log("Container Runtime engine %s", runtime)
+}
+
+// normalizeContainerRuntime returns the runtime of a CONTAINER_RUNTIME value or of a container id scheme, empty when
+// unknown
+func normalizeContainerRuntime(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case containerRuntimeDocker:
+		return containerRuntimeDocker
+	case containerRuntimeContainerd:
+		return containerRuntimeContainerd
+	case containerRuntimeCRIO, "crio":
+		return containerRuntimeCRIO
+	}
+	return ""
+}
+
+func getContainerRuntime() string {
+	ContainerRuntimeMutex.RLock()
+	defer ContainerRuntimeMutex.RUnlock()
+	return containerRuntime
+}
+
+func setContainerRuntime(runtime string) {
+	ContainerRuntimeMutex.Lock()
+	defer ContainerRuntimeMutex.Unlock()
+	containerRuntime = runtime
+}
+
+// criSocketOfContainerRuntime returns the CRI socket of the detected runtime
+func criSocketOfContainerRuntime() string {
+	runtime := getContainerRuntime()
+	for _, runtimeSocket := range containerRuntimeSockets {
+		if runtimeSocket.runtime == runtime {
+			return runtimeSocket.criSocket
+		}
+	}
+	return defaultCRISocketPath
+}
+
+// runtimeContainerID splits a container id reported by the kubelet, <runtime>://<id>, into the id and the runtime
+func runtimeContainerID(kubeletContainerID string) (string, string) {
+	id, runtime := containermetadata.ParseKubeletContainerID(kubeletContainerID)
+	return id, normalizeContainerRuntime(runtime)
+}
+
+// observeContainerRuntime corrects the detected runtime with the runtime of the container ids the kubelet reports
+func observeContainerRuntime(runtime string) {
+	if runtime == "" {
+		return
+	}
+	ContainerRuntimeMutex.Lock()
+	defer ContainerRuntimeMutex.Unlock()
+	if runtime != containerRuntime {
+		Log("Container runtime of the pods on the node is %s, not %s. Using %s", runtime, containerRuntime, runtime)
+		containerRuntime = runtime
+	}
+}
+
+// runtimeLogLine the fields of a container log line written by the runtime
+type runtimeLogLine struct {
+	time   []byte
+	stream []byte
+	log    []byte
+}
+
+// dockerLogLine a line of the docker json-file log driver
+type dockerLogLine struct {
+	Log    string `json:"log"`
+	Stream string `json:"stream"`
+	Time   string `json:"time"`
+}
+
+// parseRuntimeLogLine parses a container log line fluent-bit left unparsed, because its parser does not match the
+// runtime of the node. Docker writes json lines, containerd and cri-o write "<time> <stream> <tag> <log>" lines
+func parseRuntimeLogLine(runtime string, line []byte) (runtimeLogLine, bool) {
+	if runtime == containerRuntimeDocker {
+		if len(line) == 0 || line[0] != '{' {
+			return runtimeLogLine{}, false
+		}
+		var parsed dockerLogLine
+		if err := json.Unmarshal(line, &parsed); err != nil || parsed.Stream == "" {
+			return runtimeLogLine{}, false
+		}
+		return runtimeLogLine{time: []byte(parsed.Time), stream: []byte(parsed.Stream), log: []byte(parsed.Log)}, true
+	}
+
+	fields := bytes.SplitN(line, []byte(" "), 4)
+	if len(fields) != 4 {
+		return runtimeLogLine{}, false
+	}
+	if !bytes.Equal(fields[1], []byte("stdout")) && !bytes.Equal(fields[1], []byte("stderr")) {
+		return runtimeLogLine{}, false
+	}
+	return runtimeLogLine{time: fields[0], stream: fields[1], log: fields[3]}, true
+}
+
+// normalizeRuntimeLogRecord replaces the log of a record without a stream with the fields parsed from it for the
+// runtime of the node
+func normalizeRuntimeLogRecord(record map[interface{}]interface{}) {
+	if _, ok := record["stream"]; ok {
+		return
+	}
+	line, ok := record["log"].([]byte)
+	if !ok {
+		return
+	}
+	parsed, ok := parseRuntimeLogLine(getContainerRuntime(), line)
+	if !ok {
+		return
+	}
+	record["log"] = parsed.log
+	record["stream"] = parsed.stream
+	record["time"] = parsed.time
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_parseRuntimeLogLine(t *testing.T) {
+	type test_struct struct {
+		testname string
+		runtime  string
+		line     string
+		ok       bool
+		time     string
+		stream   string
+		log      string
+	}
+
+	tests := []test_struct{
+		{"docker", containerRuntimeDocker, `{"log":"hello world\n","stream":"stderr","time":"2021-03-01T10:00:00.123Z"}`, true, "2021-03-01T10:00:00.123Z", "stderr", "hello world\n"},
+		{"containerd", containerRuntimeContainerd, "2021-03-01T10:00:00.123Z stdout F hello world", true, "2021-03-01T10:00:00.123Z", "stdout", "hello world"},
+		{"cri-o partial", containerRuntimeCRIO, "2021-03-01T10:00:00.123Z stderr P hello", true, "2021-03-01T10:00:00.123Z", "stderr", "hello"},
+		{"cri line on docker", containerRuntimeDocker, "2021-03-01T10:00:00.123Z stdout F hello world", false, "", "", ""},
+		{"docker line on containerd", containerRuntimeContainerd, `{"log":"hello world","stream":"stdout","time":"2021-03-01T10:00:00.123Z"}`, false, "", "", ""},
+		{"plain text", containerRuntimeContainerd, "hello world", false, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, ok := parseRuntimeLogLine(tt.runtime, []byte(tt.line))
+			if ok != tt.ok {
+				t.Fatalf("parseRuntimeLogLine() ok = %v, want %v", ok, tt.ok)
+			}
+			if string(got.time) != tt.time || string(got.stream) != tt.stream || string(got.log) != tt.log {
+				t.Errorf("parseRuntimeLogLine() = (%q, %q, %q), want (%q, %q, %q)", got.time, got.stream, got.log, tt.time, tt.stream, tt.log)
+			}
+		})
+	}
+}
+
+func Test_runtimeContainerID(t *testing.T) {
+	type test_struct struct {
+		testname    string
+		containerID string
+		id          string
+		runtime     string
+	}
+
+	tests := []test_struct{
+		{"docker", "docker://0123abcd", "0123abcd", containerRuntimeDocker},
+		{"containerd", "containerd://0123abcd", "0123abcd", containerRuntimeContainerd},
+		{"cri-o", "cri-o://0123abcd", "0123abcd", containerRuntimeCRIO},
+		{"unknown runtime", "rkt://0123abcd", "0123abcd", ""},
+		{"no scheme", "0123abcd", "0123abcd", ""},
+		{"empty", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			id, runtime := runtimeContainerID(tt.containerID)
+			if id != tt.id || runtime != tt.runtime {
+				t.Errorf("runtimeContainerID(%q) = (%q, %q), want (%q, %q)", tt.containerID, id, runtime, tt.id, tt.runtime)
+			}
+		})
+	}
+}
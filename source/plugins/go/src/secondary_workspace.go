@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variables of the secondary workspace the ODS posts fail over to
+const (
+	// id of the secondary workspace, the failover is disabled when not set
+	envSecondaryWorkspaceID = "AZMON_SECONDARY_WORKSPACE_ID"
+	// domain of the secondary workspace, the domain of the primary workspace by default
+	envSecondaryWorkspaceDomain = "AZMON_SECONDARY_WORKSPACE_DOMAIN"
+	// client certificate and key of the secondary workspace, the agent certificate of the workspace by default on linux
+	envSecondaryWorkspaceCertFilePath = "AZMON_SECONDARY_WORKSPACE_CERT_FILE_PATH"
+	envSecondaryWorkspaceKeyFilePath  = "AZMON_SECONDARY_WORKSPACE_KEY_FILE_PATH"
+	// how long (seconds) the primary workspace returns 5xx before the posts fail over
+	envSecondaryWorkspaceFailoverSeconds = "AZMON_SECONDARY_WORKSPACE_FAILOVER_SECONDS"
+	// interval (seconds) at which a post is sent to the primary workspace while failed over, to fail back once it
+	// recovers
+	envSecondaryWorkspaceFailbackIntervalSeconds = "AZMON_SECONDARY_WORKSPACE_FAILBACK_INTERVAL_SECONDS"
+)
+
+const (
+	defaultSecondaryWorkspaceFailoverSeconds         = 300
+	defaultSecondaryWorkspaceFailbackIntervalSeconds = 60
+
+	eventNameWorkspaceFailover = "WorkspaceFailoverEvent"
+	eventNameWorkspaceFailback = "WorkspaceFailbackEvent"
+)
+
+// SecondaryWorkspace the failover of the ODS posts to the secondary workspace, nil when it is not configured
+var SecondaryWorkspace *workspaceFailover
+
+// workspaceFailover posts to the primary workspace, and to the secondary workspace once the primary has returned 5xx
+// for the threshold. While failed over, a post is sent to the primary every failback interval, and the posts fail back
+// when it succeeds
+type workspaceFailover struct {
+	workspaceID      string
+	endpoint         string
+	client           *http.Client
+	threshold        time.Duration
+	failbackInterval time.Duration
+
+	mu             sync.Mutex
+	failingSince   time.Time
+	failedOver     bool
+	lastPrimaryTry time.Time
+	now            func() time.Time
+	sendEvent      func(eventName string, dimensions map[string]string)
+}
+
+// initializeSecondaryWorkspace creates the client of the secondary workspace, after the primary workspace is set up
+func initializeSecondaryWorkspace() {
+	SecondaryWorkspace = nil
+	workspaceID := strings.TrimSpace(os.Getenv(envSecondaryWorkspaceID))
+	if workspaceID == "" {
+		return
+	}
+	if IsAADMSIAuthMode || IsWorkloadIdentityAuthMode {
+		Log("Error::failover::The secondary workspace %s is only supported with the agent certificate auth, the posts don't fail over", workspaceID)
+		return
+	}
+	if workspaceID == WorkspaceID {
+		Log("Error::failover::The secondary workspace is the primary workspace %s, the posts don't fail over", workspaceID)
+		return
+	}
+	logAnalyticsDomain := strings.TrimSpace(os.Getenv(envSecondaryWorkspaceDomain))
+	if logAnalyticsDomain == "" {
+		logAnalyticsDomain = os.Getenv("DOMAIN")
+	}
+	certFile := strings.TrimSpace(os.Getenv(envSecondaryWorkspaceCertFilePath))
+	keyFile := strings.TrimSpace(os.Getenv(envSecondaryWorkspaceKeyFilePath))
+	client, err := newWorkspaceClient(workspaceID, certFile, keyFile)
+	if err != nil {
+		Log("Error::failover::Unable to create the client of the secondary workspace %s, the posts don't fail over: %s", workspaceID, err.Error())
+		return
+	}
+	threshold := readFailoverSeconds(envSecondaryWorkspaceFailoverSeconds, defaultSecondaryWorkspaceFailoverSeconds)
+	failbackInterval := readFailoverSeconds(envSecondaryWorkspaceFailbackIntervalSeconds, defaultSecondaryWorkspaceFailbackIntervalSeconds)
+	endpoint := "https://" + workspaceID + ".ods." + logAnalyticsDomain + "/OperationalData.svc/PostJsonDataItems"
+	SecondaryWorkspace = newWorkspaceFailover(workspaceID, endpoint, client, threshold, failbackInterval)
+	Log("ODS posts fail over to the secondary workspace %s after %s of 5xx of the primary workspace, and fail back every %s", workspaceID, threshold, failbackInterval)
+}
+
+// newWorkspaceClient creates the ODS client of another workspace than the one of the agent, with the client
+// certificate and key, or the agent certificate of the workspace when they are not set on linux
+func newWorkspaceClient(workspaceID string, certFile string, keyFile string) (*http.Client, error) {
+	if certFile == "" && keyFile == "" {
+		if IsWindows == true {
+			return nil, fmt.Errorf("the client certificate and key of the workspace %s are required on windows", workspaceID)
+		}
+		certFile = fmt.Sprintf(PluginConfiguration["cert_file_path"], workspaceID)
+		keyFile = fmt.Sprintf(PluginConfiguration["key_file_path"], workspaceID)
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Stat(file); err != nil {
+			return nil, fmt.Errorf("the certificate of the workspace %s is not available: %s", workspaceID, err.Error())
+		}
+	}
+	tlsConfig, err := newRouteTLSConfig("ods_"+workspaceID, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	if ProxyEndpoint != "" {
+		proxyEndpointUrl, err := url.Parse(ProxyEndpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy endpoint: %s", err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyEndpointUrl)
+	}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+func newWorkspaceFailover(workspaceID string, endpoint string, client *http.Client, threshold time.Duration, failbackInterval time.Duration) *workspaceFailover {
+	return &workspaceFailover{workspaceID: workspaceID, endpoint: endpoint, client: client, threshold: threshold, failbackInterval: failbackInterval, now: time.Now, sendEvent: SendEvent}
+}
+
+// postODSPayload posts the payload to the ODS endpoint of the primary workspace, or of the secondary workspace once
+// the posts failed over. Returns the response, the request id and the endpoint of the post
+func postODSPayload(ctx context.Context, payload []byte) (*http.Response, string, string, error) {
+	if SecondaryWorkspace == nil {
+		resp, reqID, err := postToODSEndpoint(ctx, OMSEndpoint, &HTTPClient, payload)
+		return resp, reqID, OMSEndpoint, err
+	}
+	return SecondaryWorkspace.post(ctx, payload)
+}
+
+func (f *workspaceFailover) post(ctx context.Context, payload []byte) (*http.Response, string, string, error) {
+	if f.usePrimary() {
+		resp, reqID, err := postToODSEndpoint(ctx, OMSEndpoint, &HTTPClient, payload)
+		if !f.setPrimaryResult(resp, err) {
+			return resp, reqID, OMSEndpoint, err
+		}
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+	}
+	resp, reqID, err := postToODSEndpoint(ctx, f.endpoint, f.client, payload)
+	return resp, reqID, f.endpoint, err
+}
+
+func postToODSEndpoint(ctx context.Context, endpoint string, client *http.Client, payload []byte) (*http.Response, string, error) {
+	req, reqID, err := newRouteRequest(ctx, "POST", requestRouteODS, endpoint, payload)
+	if err != nil {
+		return nil, reqID, err
+	}
+	resp, err := doRouteRequestWithClient(client, requestRouteODS, req)
+	return resp, reqID, err
+}
+
+// usePrimary whether the post is sent to the primary workspace, always when not failed over and once per failback
+// interval when failed over
+func (f *workspaceFailover) usePrimary() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.failedOver {
+		return true
+	}
+	if now := f.now(); now.Sub(f.lastPrimaryTry) >= f.failbackInterval {
+		f.lastPrimaryTry = now
+		return true
+	}
+	return false
+}
+
+// setPrimaryResult updates the failover state with the result of a post to the primary workspace, and returns
+// whether the payload is posted to the secondary workspace. Only the 5xx of the primary count as its failures
+func (f *workspaceFailover) setPrimaryResult(resp *http.Response, err error) bool {
+	f.mu.Lock()
+	now := f.now()
+	if err == nil && resp != nil && resp.StatusCode < 500 {
+		failedOver := f.failedOver
+		failingFor := now.Sub(f.failingSince)
+		f.failingSince = time.Time{}
+		f.failedOver = false
+		f.mu.Unlock()
+		if failedOver {
+			Log("Info::failover::The primary workspace %s recovered, ODS posts fail back from the secondary workspace %s", WorkspaceID, f.workspaceID)
+			f.sendTransitionEvent(eventNameWorkspaceFailback, failingFor, 0)
+		}
+		return false
+	}
+	if err != nil || resp == nil {
+		// the posts which don't reach the workspace are retried by the flush, they neither fail over nor back
+		failedOver := f.failedOver
+		f.mu.Unlock()
+		return failedOver
+	}
+	if f.failingSince.IsZero() {
+		f.failingSince = now
+	}
+	if f.failedOver {
+		f.mu.Unlock()
+		return true
+	}
+	failingFor := now.Sub(f.failingSince)
+	if failingFor < f.threshold {
+		f.mu.Unlock()
+		return false
+	}
+	f.failedOver = true
+	f.lastPrimaryTry = now
+	f.mu.Unlock()
+	Log("Error::failover::The primary workspace %s returned %d for %s, ODS posts fail over to the secondary workspace %s", WorkspaceID, resp.StatusCode, failingFor, f.workspaceID)
+	f.sendTransitionEvent(eventNameWorkspaceFailover, failingFor, resp.StatusCode)
+	return true
+}
+
+func (f *workspaceFailover) sendTransitionEvent(eventName string, failingFor time.Duration, statusCode int) {
+	telemetryDimensions := make(map[string]string)
+	telemetryDimensions["PrimaryWorkspaceId"] = WorkspaceID
+	telemetryDimensions["SecondaryWorkspaceId"] = f.workspaceID
+	telemetryDimensions["UnhealthySeconds"] = strconv.Itoa(int(failingFor / time.Second))
+	if statusCode != 0 {
+		telemetryDimensions["StatusCode"] = strconv.Itoa(statusCode)
+	}
+	f.sendEvent(eventName, telemetryDimensions)
+}
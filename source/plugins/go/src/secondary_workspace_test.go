@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_workspaceFailover(t *testing.T) {
+	type step struct {
+		// seconds since the start
+		at int
+		// status code of the primary workspace
+		primaryStatus int
+	}
+	type test_struct struct {
+		testname string
+		steps    []step
+		// the workspace posted to at each step, p for the primary and s for the secondary, or both
+		postedTo []string
+		events   []string
+	}
+
+	tests := []test_struct{
+		{"healthy", []step{{0, 200}, {10, 200}}, []string{"p", "p"}, nil},
+		{"under the threshold", []step{{0, 503}, {30, 500}, {50, 200}}, []string{"p", "p", "p"}, nil},
+		{"client errors are not failures", []step{{0, 403}, {60, 400}}, []string{"p", "p"}, nil},
+		{"fail over", []step{{0, 503}, {60, 503}, {70, 503}}, []string{"p", "ps", "s"}, []string{eventNameWorkspaceFailover}},
+		{"still failing at the failback", []step{{0, 500}, {60, 500}, {130, 500}, {140, 200}}, []string{"p", "ps", "ps", "s"}, []string{eventNameWorkspaceFailover}},
+		{"fail back", []step{{0, 500}, {60, 500}, {130, 200}, {140, 200}}, []string{"p", "ps", "p", "p"}, []string{eventNameWorkspaceFailover, eventNameWorkspaceFailback}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			primaryStatus, primaryPosts, secondaryPosts := 0, 0, 0
+			primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				primaryPosts++
+				w.WriteHeader(primaryStatus)
+			}))
+			defer primary.Close()
+			secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				secondaryPosts++
+			}))
+			defer secondary.Close()
+			OMSEndpoint = primary.URL
+			defer func() { OMSEndpoint = "" }()
+
+			start := time.Now()
+			var now time.Time
+			var events []string
+			failover := newWorkspaceFailover("secondary", secondary.URL, &http.Client{}, 60*time.Second, 60*time.Second)
+			failover.now = func() time.Time { return now }
+			failover.sendEvent = func(eventName string, dimensions map[string]string) { events = append(events, eventName) }
+			var postedTo []string
+			for _, s := range tt.steps {
+				now = start.Add(time.Duration(s.at) * time.Second)
+				primaryStatus = s.primaryStatus
+				before, beforeSecondary := primaryPosts, secondaryPosts
+				resp, _, _, err := failover.post(context.Background(), []byte("{}"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				to := ""
+				if primaryPosts > before {
+					to += "p"
+				}
+				if secondaryPosts > beforeSecondary {
+					to += "s"
+				}
+				postedTo = append(postedTo, to)
+			}
+			if !reflect.DeepEqual(postedTo, tt.postedTo) || !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("posted to %v with events %v, want %v with events %v", postedTo, events, tt.postedTo, tt.events)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// the payloads of a chunk sent successfully are remembered like the flushed chunks, in the recently flushed chunks and
+// the flush checkpoint, so the retry of a chunk whose send failed part way only sends the payloads which were not sent.
+// The ids of the payloads are stable across the retries since the items of a chunk are built in the same order
+
+// payloadID returns the id of the payload of the items [start, end) of a chunk, empty when the chunk has no id
+func payloadID(batchID string, start int, end int) string {
+	if batchID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d-%d", batchID, start, end)
+}
+
+// sendUnsentPayload sends the payload of the items [start, end) of the chunk unless it was sent by an earlier delivery
+// of the chunk, and remembers it once sent
+func sendUnsentPayload(caller string, route string, batchID string, start int, end int, send func() error) error {
+	id := payloadID(batchID, start, end)
+	if isPayloadSent(route, id) {
+		Log("%s::Info::skipping the items %d to %d of batch %s already sent on the %s route", caller, start, end, batchID, route)
+		return nil
+	}
+	if err := send(); err != nil {
+		return err
+	}
+	addFlushedBatch(route, id)
+	return nil
+}
+
+// isPayloadSent returns whether the payload with the id was sent on the route by an earlier delivery of its chunk, and
+// counts the skipped payload
+func isPayloadSent(route string, id string) bool {
+	if !RecentChunks.contains(route, id, time.Now()) && !FlushCheckpoint.contains(route, id) {
+		return false
+	}
+	ContainerLogTelemetryMutex.Lock()
+	SentPayloadSkipCount += 1
+	ContainerLogTelemetryMutex.Unlock()
+	return true
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_sendUnsentPayload(t *testing.T) {
+	type test_struct struct {
+		testName  string
+		batchID   string
+		start     int
+		end       int
+		sendErr   error
+		wantSends int
+		wantErr   bool
+	}
+
+	// the sends of the deliveries of a chunk, in order
+	tests := []test_struct{
+		{"first payload sent", "batch1", 0, 10, nil, 1, false},
+		{"second payload fails", "batch1", 10, 20, errors.New("ods unavailable"), 1, true},
+		{"retry skips the sent payload", "batch1", 0, 10, nil, 0, false},
+		{"retry sends the failed payload", "batch1", 10, 20, nil, 1, false},
+		{"payload of another chunk", "batch2", 0, 10, nil, 1, false},
+		{"chunk without id", "", 0, 10, nil, 1, false},
+		{"chunk without id sent again", "", 0, 10, nil, 1, false},
+	}
+
+	RecentChunks = newRecentChunks(16, time.Minute)
+	defer func() { RecentChunks = nil }()
+	SentPayloadSkipCount = 0
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			sends := 0
+			err := sendUnsentPayload("Test", ContainerLogsV1Route, tt.batchID, tt.start, tt.end, func() error {
+				sends++
+				return tt.sendErr
+			})
+			if (err != nil) != tt.wantErr || sends != tt.wantSends {
+				t.Errorf("sendUnsentPayload() sent %d times with error %v, want %d sends and error %v", sends, err, tt.wantSends, tt.wantErr)
+			}
+		})
+	}
+	if SentPayloadSkipCount != 1 {
+		t.Errorf("SentPayloadSkipCount = %v, want 1", SentPayloadSkipCount)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+)
+
+// the classes of the send failures of the sinks
+const (
+	sinkFailureDropped    = "dropped"
+	sinkFailureTimeout    = "timeout"
+	sinkFailureThrottled  = "throttled"
+	sinkFailureServer     = "server"
+	sinkFailureClient     = "client"
+	sinkFailureConnection = "connection"
+	sinkFailureOther      = "other"
+)
+
+// sinkStatusError a send refused by the destination with an http status code
+type sinkStatusError struct {
+	statusCode int
+	// the Retry-After of the response, 0 when not set
+	retryAfter time.Duration
+}
+
+func (e *sinkStatusError) Error() string {
+	return fmt.Sprintf("status code %d", e.statusCode)
+}
+
+// classifySinkError returns the failure class of a send error
+func classifySinkError(err error) string {
+	if err == errBatchDropped {
+		return sinkFailureDropped
+	}
+	var statusErr *sinkStatusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.statusCode == 429:
+			return sinkFailureThrottled
+		case statusErr.statusCode >= 500:
+			return sinkFailureServer
+		}
+		return sinkFailureClient
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return sinkFailureTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return sinkFailureTimeout
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return sinkFailureConnection
+	}
+	return sinkFailureOther
+}
+
+// sinkCounters the cumulative send counters of a sink
+type sinkCounters struct {
+	sinkType  string
+	attempts  float64
+	successes float64
+	failures  map[string]float64
+	records   float64
+	bytes     float64
+	latencyMs float64
+}
+
+// sinkStats the send counters of the sinks by sink name. The counters are cumulative, the telemetry sends their
+// increase since its last tick
+type sinkStats struct {
+	mu       sync.Mutex
+	counters map[string]*sinkCounters
+}
+
+// ContainerLogSinkStats the send counters of the container log sinks
+var ContainerLogSinkStats = &sinkStats{counters: make(map[string]*sinkCounters)}
+
+func (s *sinkStats) record(sinkType string, name string, records int, bytes int, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counters, ok := s.counters[name]
+	if !ok {
+		counters = &sinkCounters{sinkType: sinkType, failures: make(map[string]float64)}
+		s.counters[name] = counters
+	}
+	counters.attempts += 1
+	counters.latencyMs += float64(latency / time.Millisecond)
+	if err != nil {
+		counters.failures[classifySinkError(err)] += 1
+		return
+	}
+	counters.successes += 1
+	counters.records += float64(records)
+	counters.bytes += float64(bytes)
+}
+
+// snapshot returns a copy of the counters of the sinks
+func (s *sinkStats) snapshot() map[string]sinkCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make(map[string]sinkCounters, len(s.counters))
+	for name, counters := range s.counters {
+		copied := *counters
+		copied.failures = make(map[string]float64, len(counters.failures))
+		for class, count := range counters.failures {
+			copied.failures[class] = count
+		}
+		snapshot[name] = copied
+	}
+	return snapshot
+}
+
+// instrumentedSink records the sends of a sink in ContainerLogSinkStats
+type instrumentedSink struct {
+	Sink
+	sinkType string
+}
+
+func instrumentSink(sinkType string, sink Sink) Sink {
+	return &instrumentedSink{Sink: sink, sinkType: sinkType}
+}
+
+func (s *instrumentedSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	start := time.Now()
+	err := s.Sink.Send(ctx, batch)
+	latency := time.Since(start)
+	ContainerLogSinkStats.record(s.sinkType, s.Name(), batch.len(), batch.logBytes, latency, err)
+	if err != nil && (s.sinkType == sinkTypeMdsd || s.sinkType == sinkTypeADX) {
+		addIngestionFailureEvent(s.Name(), getContainerLogsDataType(), err, start.Add(latency))
+	}
+	PluginMetrics.observeSinkSend(s.Name(), latency)
+	return err
+}
+
+// recordSinkSend records a send made for an instrumented sink without going thru it
+func recordSinkSend(sink Sink, records int, bytes int, latency time.Duration, err error) {
+	if instrumented, ok := sink.(*instrumentedSink); ok {
+		ContainerLogSinkStats.record(instrumented.sinkType, instrumented.Name(), records, bytes, latency, err)
+		PluginMetrics.observeSinkSend(instrumented.Name(), latency)
+	}
+}
+
+// the counters of the sinks at the last telemetry tick
+var lastSinkTelemetry = make(map[string]sinkCounters)
+
+// sendSinkTelemetry sends the increase of the sink counters since the last tick, and returns the failures other than
+// the dropped batches by sink type
+func sendSinkTelemetry() map[string]float64 {
+	failuresByType := make(map[string]float64)
+	snapshot := ContainerLogSinkStats.snapshot()
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		current := snapshot[name]
+		last := lastSinkTelemetry[name]
+		attempts := current.attempts - last.attempts
+		if attempts <= 0 {
+			continue
+		}
+		properties := map[string]string{"Sink": name, "SinkType": current.sinkType}
+		trackSinkMetric(metricNameSinkSendAttemptCount, attempts, properties)
+		trackSinkMetric(metricNameSinkSendSuccessCount, current.successes-last.successes, properties)
+		trackSinkMetric(metricNameSinkSentRecordCount, current.records-last.records, properties)
+		trackSinkMetric(metricNameSinkSentBytes, current.bytes-last.bytes, properties)
+		trackSinkMetric(metricNameSinkSendAvgLatencyMs, (current.latencyMs-last.latencyMs)/attempts, properties)
+		for class, count := range current.failures {
+			failures := count - last.failures[class]
+			if failures <= 0 {
+				continue
+			}
+			if class != sinkFailureDropped {
+				failuresByType[current.sinkType] += failures
+			}
+			failureProperties := map[string]string{"Sink": name, "SinkType": current.sinkType, "FailureClass": class}
+			trackSinkMetric(metricNameSinkSendFailureCount, failures, failureProperties)
+		}
+	}
+	lastSinkTelemetry = snapshot
+	return failuresByType
+}
+
+func trackSinkMetric(name string, value float64, properties map[string]string) {
+	metric := appinsights.NewMetricTelemetry(name, value)
+	for k, v := range properties {
+		metric.Properties[k] = v
+	}
+	TelemetryClient.Track(metric)
+}
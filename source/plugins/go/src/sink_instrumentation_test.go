@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_classifySinkError(t *testing.T) {
+	type test_struct struct {
+		testname string
+		err      error
+		output   string
+	}
+
+	tests := []test_struct{
+		{"dropped", errBatchDropped, sinkFailureDropped},
+		{"throttled", &sinkStatusError{statusCode: 429}, sinkFailureThrottled},
+		{"server", &sinkStatusError{statusCode: 503}, sinkFailureServer},
+		{"client", &sinkStatusError{statusCode: 403}, sinkFailureClient},
+		{"deadline", fmt.Errorf("ingestion failed: %w", context.DeadlineExceeded), sinkFailureTimeout},
+		{"connection", &net.OpError{Op: "dial", Net: "unix", Err: errors.New("connection refused")}, sinkFailureConnection},
+		{"other", errors.New("unable to create the mdsd client"), sinkFailureOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := classifySinkError(tt.err); got != tt.output {
+				t.Errorf("classifySinkError() = %s, want %s", got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_instrumentedSink(t *testing.T) {
+	ContainerLogSinkStats = &sinkStats{counters: make(map[string]*sinkCounters)}
+	failing := &testSink{name: "adxwest", err: &sinkStatusError{statusCode: 500}}
+	sinks := []Sink{instrumentSink(sinkTypeADX, &testSink{name: "adxeast"}), instrumentSink(sinkTypeADX, failing)}
+	batch := &containerLogBatch{dataItemsADX: make([]DataItemADX, 3), logBytes: 120}
+	for _, sink := range sinks {
+		sink.Send(context.Background(), batch)
+		sink.Send(context.Background(), batch)
+	}
+	recordSinkSend(sinks[1], 2, 10, time.Millisecond, errBatchDropped)
+
+	snapshot := ContainerLogSinkStats.snapshot()
+	east := snapshot["adxeast"]
+	if east.attempts != 2 || east.successes != 2 || east.records != 6 || east.bytes != 240 || len(east.failures) != 0 {
+		t.Errorf("adxeast counters = %+v", east)
+	}
+	west := snapshot["adxwest"]
+	if west.sinkType != sinkTypeADX || west.attempts != 3 || west.successes != 0 || west.failures[sinkFailureServer] != 2 || west.failures[sinkFailureDropped] != 1 {
+		t.Errorf("adxwest counters = %+v", west)
+	}
+
+	// the snapshot is a copy
+	west.failures[sinkFailureServer] = 0
+	if ContainerLogSinkStats.snapshot()["adxwest"].failures[sinkFailureServer] != 2 {
+		t.Errorf("snapshot shares the failures of the counters")
+	}
+}
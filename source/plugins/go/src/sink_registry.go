@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// declares the container log sinks as <name>:<type> separated by commas, e.g. adxeast:adx,adxwest:adx. The settings of
+// a sink are read like the route settings, AZMON_<NAME>_<SETTING> or <name>_<setting> in the plugin configuration
+const envContainerLogSinks = "AZMON_CONTAINER_LOG_SINKS"
+
+const (
+	sinkTypeMdsd      = "mdsd"
+	sinkTypeADX       = "adx"
+	sinkTypeODS       = "ods"
+	sinkTypeDCR       = "dcr"
+	sinkTypeKafka     = "kafka"
+	sinkTypeEventHubs = "eventhubs"
+	sinkTypeOTLP      = "otlp"
+)
+
+// sinkDeclaration a container log sink declared in the configuration
+type sinkDeclaration struct {
+	name     string
+	sinkType string
+}
+
+// sinkFactory creates a declared sink of a type from its settings
+type sinkFactory func(name string) (Sink, error)
+
+// sinkFactories the types of the sinks that can be declared
+var sinkFactories = map[string]sinkFactory{
+	sinkTypeMdsd:      func(name string) (Sink, error) { return newMdsdSink(name), nil },
+	sinkTypeADX:       newDeclaredADXSink,
+	sinkTypeODS:       func(name string) (Sink, error) { return &odsSink{name: name}, nil },
+	sinkTypeDCR:       newDeclaredDCRSink,
+	sinkTypeKafka:     newDeclaredKafkaSink,
+	sinkTypeEventHubs: newDeclaredEventHubsSink,
+	sinkTypeOTLP:      newDeclaredOTLPSink,
+
+	sinkTypeValidate: newValidateSink,
+}
+
+// containerLogRoutes the routes of the container logs, with the type of the sink sending them
+var containerLogRoutes = []struct {
+	route    string
+	sinkType string
+}{
+	{ContainerLogsV1Route, sinkTypeODS},
+	{ContainerLogsV2Route, sinkTypeMdsd},
+	{ContainerLogsGenevaRoute, sinkTypeMdsd},
+	{ContainerLogsADXRoute, sinkTypeADX},
+	{ContainerLogsDCRRoute, sinkTypeDCR},
+	{ContainerLogsKafkaRoute, sinkTypeKafka},
+	{ContainerLogsEventHubsRoute, sinkTypeEventHubs},
+	{ContainerLogsOTLPRoute, sinkTypeOTLP},
+}
+
+// the types sending to a destination of the node or of the workspace, which can't have more than one sink
+var singleInstanceSinkTypes = map[string]bool{sinkTypeMdsd: true, sinkTypeODS: true}
+
+// the sink names are part of the env variables of their settings
+var sinkNameRegex = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// ContainerLogSinkDeclarations the container log sinks declared in the configuration, empty when the sink is the one of
+// the container logs route
+var ContainerLogSinkDeclarations []sinkDeclaration
+
+// initializeSinkDeclarations reads the declared container log sinks, and sets the route flags for their type in place
+// of the container logs route. With only validate sinks, the records of the container logs route are validated instead
+// of being sent
+func initializeSinkDeclarations() {
+	ContainerLogSinkDeclarations = nil
+	value := strings.TrimSpace(os.Getenv(envContainerLogSinks))
+	if value == "" {
+		value = strings.TrimSpace(PluginConfiguration["container_log_sinks"])
+	}
+	if value == "" {
+		return
+	}
+	declarations, err := parseSinkDeclarations(value)
+	backendType := sinkDeclarationsBackendType(declarations)
+	if err == nil && IsWindows == true && WindowsMdsdFluentAddress == "" && backendType == sinkTypeMdsd {
+		err = fmt.Errorf("the mdsd sink needs %s on windows", envWindowsMdsdFluentAddress)
+	}
+	if err != nil {
+		Log("Error::sinks::Ignoring the declared container log sinks %s, using the %s route: %s", value, getContainerLogsRouteName(), err.Error())
+		return
+	}
+
+	ContainerLogSinkDeclarations = declarations
+	if backendType == "" {
+		Log("Validating the records of the %s route with the declared sinks %s, without sending them", getContainerLogsRouteName(), value)
+		return
+	}
+	ContainerLogsRouteV2 = backendType == sinkTypeMdsd
+	ContainerLogsRouteADX = backendType == sinkTypeADX
+	ContainerLogsRouteDCR = backendType == sinkTypeDCR
+	ContainerLogsRouteKafka = backendType == sinkTypeKafka
+	ContainerLogsRouteEventHubs = backendType == sinkTypeEventHubs
+	ContainerLogsRouteOTLP = backendType == sinkTypeOTLP
+	ContainerLogsRouteGeneva = false
+	Log("Routing container logs thru the declared %s sinks %s", backendType, value)
+}
+
+// sinkDeclarationsBackendType returns the type of the sinks sending the records, empty when there are only validate
+// sinks
+func sinkDeclarationsBackendType(declarations []sinkDeclaration) string {
+	for _, declaration := range declarations {
+		if declaration.sinkType != sinkTypeValidate {
+			return declaration.sinkType
+		}
+	}
+	return ""
+}
+
+// parseSinkDeclarations parses the <name>:<type> sink declarations. The records of a flush are built in the schema of
+// one sink type, so all the sinks have the same type, besides the validate sinks checking the records of that type
+func parseSinkDeclarations(value string) ([]sinkDeclaration, error) {
+	var declarations []sinkDeclaration
+	names := make(map[string]bool)
+	types := make(map[string]int)
+	backendType := ""
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("sink %s is not <name>:<type>", item)
+		}
+		declaration := sinkDeclaration{name: strings.ToLower(strings.TrimSpace(parts[0])), sinkType: strings.ToLower(strings.TrimSpace(parts[1]))}
+		if !sinkNameRegex.MatchString(declaration.name) {
+			return nil, fmt.Errorf("sink name %s is not lowercase letters and digits", declaration.name)
+		}
+		if names[declaration.name] {
+			return nil, fmt.Errorf("sink %s is declared more than once", declaration.name)
+		}
+		if _, ok := sinkFactories[declaration.sinkType]; !ok {
+			return nil, fmt.Errorf("unknown type %s of sink %s", declaration.sinkType, declaration.name)
+		}
+		if declaration.sinkType != sinkTypeValidate {
+			if backendType != "" && declaration.sinkType != backendType {
+				return nil, fmt.Errorf("sink %s is of type %s, not %s like the other sinks", declaration.name, declaration.sinkType, backendType)
+			}
+			backendType = declaration.sinkType
+		}
+		if types[declaration.sinkType] > 0 && singleInstanceSinkTypes[declaration.sinkType] {
+			return nil, fmt.Errorf("only one %s sink can be declared", declaration.sinkType)
+		}
+		types[declaration.sinkType]++
+		names[declaration.name] = true
+		declarations = append(declarations, declaration)
+	}
+	if len(declarations) == 0 {
+		return nil, errors.New("no sink declared")
+	}
+	return declarations, nil
+}
+
+// newDeclaredSinks creates the declared sinks, nil when there are none or none could be created
+func newDeclaredSinks(declarations []sinkDeclaration) Sink {
+	var sinks []Sink
+	for _, declaration := range declarations {
+		sink, err := sinkFactories[declaration.sinkType](declaration.name)
+		if err != nil {
+			Log("Error::sinks::Unable to create the %s sink %s: %s", declaration.sinkType, declaration.name, err.Error())
+			continue
+		}
+		sinks = append(sinks, instrumentSink(declaration.sinkType, sink))
+	}
+	switch len(sinks) {
+	case 0:
+		return nil
+	case 1:
+		return sinks[0]
+	}
+	return &sinkGroup{sinks: sinks}
+}
+
+// newDeclaredADXSink creates an ADX sink from the cluster_uri, database, tenant_id, client_id and client_secret_path
+// settings of the sink. Without a client secret, workload identity is used when configured
+func newDeclaredADXSink(name string) (Sink, error) {
+	config := adxClientConfig{
+		clusterURI: routeSetting(name, "cluster_uri"),
+		database:   routeSetting(name, "database"),
+		tenantID:   routeSetting(name, "tenant_id"),
+		clientID:   routeSetting(name, "client_id"),
+	}
+	if config.database == "" {
+		config.database = DefaultAdxDatabaseName
+	}
+	if path := routeSetting(name, "client_secret_path"); path != "" {
+		secret, err := ReadFileContents(path)
+		if err != nil {
+			return nil, err
+		}
+		config.clientSecret = secret
+	}
+	if config.clientSecret == "" && isWorkloadIdentityConfigured() {
+		config.workloadIdentity = true
+		if config.clientID == "" {
+			config.clientID = strings.TrimSpace(os.Getenv(envAzureClientID))
+		}
+		if config.tenantID == "" {
+			config.tenantID = strings.TrimSpace(os.Getenv(envAzureTenantID))
+		}
+	}
+	if !isValidUrl(config.clusterURI) {
+		return nil, fmt.Errorf("invalid cluster uri %s", config.clusterURI)
+	}
+	if config.clientID == "" || config.tenantID == "" || (config.clientSecret == "" && !config.workloadIdentity) {
+		return nil, errors.New("the client id, tenant id and client secret or workload identity are required")
+	}
+	if DataBoundary != "" && !isWithinDataBoundary(regionFromADXClusterUri(config.clusterURI)) {
+		refuseDataBoundaryDestination("ADX cluster", config.clusterURI)
+		return nil, fmt.Errorf("%s is outside of the %s data boundary", config.clusterURI, DataBoundary)
+	}
+	return &adxSink{name: name, config: &config}, nil
+}
+
+// sinkGroup sends the batches to all its sinks. The sinks a batch was sent to are remembered with the id of its chunk,
+// like the tee route, so a batch that fails on one of the sinks is only sent again to the sinks that failed
+type sinkGroup struct {
+	sinks []Sink
+}
+
+func (g *sinkGroup) Name() string {
+	names := make([]string, len(g.sinks))
+	for i, sink := range g.sinks {
+		names[i] = sink.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (g *sinkGroup) Send(ctx context.Context, batch *containerLogBatch) error {
+	var failed []string
+	dropped := 0
+	for _, sink := range g.sinks {
+		if isPayloadSent(sink.Name(), batch.id) {
+			Log("sinks::Info::skipping the batch %s already sent to the %s sink", batch.id, sink.Name())
+			continue
+		}
+		if err := sink.Send(ctx, batch); err == errBatchDropped {
+			dropped++
+		} else if err != nil {
+			failed = append(failed, sink.Name())
+			continue
+		}
+		addFlushedBatch(sink.Name(), batch.id)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to send the batch to the %s sinks", strings.Join(failed, ","))
+	}
+	if dropped == len(g.sinks) {
+		return errBatchDropped
+	}
+	return nil
+}
+
+func (g *sinkGroup) Healthy() bool {
+	for _, sink := range g.sinks {
+		if !sink.Healthy() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseSinkDeclarations(t *testing.T) {
+	type test_struct struct {
+		testname string
+		value    string
+		output   []sinkDeclaration
+		wantErr  bool
+	}
+
+	tests := []test_struct{
+		{"two adx clusters", "adxeast:adx, AdxWest:ADX", []sinkDeclaration{{"adxeast", sinkTypeADX}, {"adxwest", sinkTypeADX}}, false},
+		{"mdsd", "local:mdsd", []sinkDeclaration{{"local", sinkTypeMdsd}}, false},
+		{"mixed types", "adxeast:adx,local:mdsd", nil, true},
+		{"validate only", "check:validate", []sinkDeclaration{{"check", sinkTypeValidate}}, false},
+		{"validate with adx", "check:validate,adxeast:adx", []sinkDeclaration{{"check", sinkTypeValidate}, {"adxeast", sinkTypeADX}}, false},
+		{"two ods sinks", "ws1:ods,ws2:ods", nil, true},
+		{"duplicate name", "adx1:adx,adx1:adx", nil, true},
+		{"unknown type", "bucket1:s3", nil, true},
+		{"kafka", "topic1:kafka", []sinkDeclaration{{"topic1", sinkTypeKafka}}, false},
+		{"eventhubs", "hub1:eventhubs", []sinkDeclaration{{"hub1", sinkTypeEventHubs}}, false},
+		{"otlp", "collector1:otlp", []sinkDeclaration{{"collector1", sinkTypeOTLP}}, false},
+		{"invalid name", "adx-east:adx", nil, true},
+		{"no type", "adxeast", nil, true},
+		{"empty", " , ", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := parseSinkDeclarations(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSinkDeclarations(%s) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.output) {
+				t.Errorf("parseSinkDeclarations(%s) = %v, want %v", tt.value, got, tt.output)
+			}
+		})
+	}
+}
+
+// testSink a sink returning the configured error
+type testSink struct {
+	sinkHealth
+	name  string
+	err   error
+	sends int
+}
+
+func (s *testSink) Name() string { return s.name }
+
+func (s *testSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	s.sends++
+	return s.setSendResult(s.err)
+}
+
+func Test_sinkGroup(t *testing.T) {
+	type test_struct struct {
+		testname string
+		errs     []error
+		output   error
+		healthy  bool
+	}
+
+	failed := errors.New("ingestion failed")
+	tests := []test_struct{
+		{"all sent", []error{nil, nil}, nil, true},
+		{"one failed", []error{failed, nil}, errors.New("unable to send the batch to the sink0 sinks"), false},
+		{"one dropped", []error{errBatchDropped, nil}, nil, true},
+		{"all dropped", []error{errBatchDropped, errBatchDropped}, errBatchDropped, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			group := &sinkGroup{}
+			for i, err := range tt.errs {
+				group.sinks = append(group.sinks, &testSink{name: fmt.Sprintf("sink%d", i), err: err})
+			}
+			err := group.Send(context.Background(), &containerLogBatch{})
+			if (err == nil) != (tt.output == nil) || (err != nil && err.Error() != tt.output.Error()) {
+				t.Errorf("Send() = %v, want %v", err, tt.output)
+			}
+			for _, sink := range group.sinks {
+				if sink.(*testSink).sends != 1 {
+					t.Errorf("sink %s got %d sends, want 1", sink.Name(), sink.(*testSink).sends)
+				}
+			}
+			if group.Healthy() != tt.healthy {
+				t.Errorf("Healthy() = %v, want %v", group.Healthy(), tt.healthy)
+			}
+		})
+	}
+}
+
+func Test_sinkGroupRetry(t *testing.T) {
+	RecentChunks = newRecentChunks(16, time.Minute)
+	defer func() { RecentChunks = nil }()
+	sent := &testSink{name: "sink0"}
+	failing := &testSink{name: "sink1", err: errors.New("ingestion failed")}
+	group := &sinkGroup{sinks: []Sink{sent, failing}}
+	batch := &containerLogBatch{id: "chunk"}
+	if err := group.Send(context.Background(), batch); err == nil {
+		t.Fatal("Send() succeeded, want the error of sink1")
+	}
+	failing.err = nil
+	if err := group.Send(context.Background(), batch); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if sent.sends != 1 || failing.sends != 2 {
+		t.Errorf("the sinks got %d and %d sends, want 1 and 2", sent.sends, failing.sends)
+	}
+	// a batch without a chunk id is sent to all the sinks
+	if err := group.Send(context.Background(), &containerLogBatch{}); err != nil || sent.sends != 2 {
+		t.Errorf("Send() = %v with %d sends of sink0, want 2", err, sent.sends)
+	}
+}
+
+func Test_containerLogRoutes(t *testing.T) {
+	routeSinkTypes := make(map[string]bool)
+	for _, containerLogRoute := range containerLogRoutes {
+		if _, ok := sinkFactories[containerLogRoute.sinkType]; !ok {
+			t.Errorf("the %s route has the unknown sink type %s", containerLogRoute.route, containerLogRoute.sinkType)
+		}
+		routeSinkTypes[containerLogRoute.sinkType] = true
+	}
+	for sinkType := range sinkFactories {
+		if sinkType != sinkTypeValidate && !routeSinkTypes[sinkType] {
+			t.Errorf("the sink type %s has no container log route", sinkType)
+		}
+	}
+}
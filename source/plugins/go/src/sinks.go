@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-kusto-go/kusto/ingest"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Sink a destination of the container log records. PostDataHelper sends the batches to the sink of the route, so a new
+// route is a new sink instead of another branch in the flush. The sinks are in package main with the flush and the
+// route settings they share; moving the routes into their own packages (config, enrichment, senders, events, telemetry)
+// like containermetadata is tracked separately
+type Sink interface {
+	// Name the route of the sink
+	Name() string
+	// Send flushes the batch, the batch is retried when it returns an error other than errBatchDropped
+	Send(ctx context.Context, batch *containerLogBatch) error
+	// Healthy whether the last send of the sink succeeded
+	Healthy() bool
+}
+
+// errBatchDropped is returned by a sink for a batch that can't be sent and must not be retried
+var errBatchDropped = errors.New("batch dropped")
+
+// containerLogBatch the container log records of a flush, in the schema of the route. Only one of the slices is set
+type containerLogBatch struct {
+	msgPackEntries []MsgPackEntry
+	dataItemsADX   []DataItemADX
+	dataItemsLAv2  []DataItemLAv2
+	dataItemsLAv1  []DataItemLAv1
+	// size of the log lines
+	logBytes int
+	// when the flush started
+	start time.Time
+	// the id of the chunk of the batch, so the payloads sent by an earlier delivery of the chunk are not sent again.
+	// Empty when the payloads are not tracked
+	id string
+}
+
+func (b *containerLogBatch) len() int {
+	return len(b.msgPackEntries) + len(b.dataItemsADX) + len(b.dataItemsLAv2) + len(b.dataItemsLAv1)
+}
+
+// ContainerLogSink the sink of the container logs route
+var ContainerLogSink Sink
+
+// initializeContainerLogSink creates the sinks declared in the configuration, or the sink of the container logs
+// route, once the route and its client are set up
+func initializeContainerLogSink() {
+	ContainerLogFailoverRoute = ""
+	if sink := newDeclaredSinks(ContainerLogSinkDeclarations); sink != nil {
+		ContainerLogSink = sink
+		Log("Container log sink = %s", ContainerLogSink.Name())
+		return
+	}
+	switch {
+	case ContainerLogsRouteV2:
+		ContainerLogSink = newContainerLogFailoverSink(instrumentSink(sinkTypeMdsd, newMdsdSink(getContainerLogsRouteName())))
+	case ContainerLogsRouteADX:
+		ContainerLogSink = instrumentSink(sinkTypeADX, newADXSink())
+	case ContainerLogsRouteDCR:
+		ContainerLogSink = instrumentSink(sinkTypeDCR, &dcrSink{name: ContainerLogsDCRRoute, config: DCRRouteConfig})
+	case ContainerLogsRouteKafka:
+		ContainerLogSink = instrumentSink(sinkTypeKafka, &kafkaSink{name: ContainerLogsKafkaRoute, config: KafkaRouteConfig})
+	case ContainerLogsRouteEventHubs:
+		ContainerLogSink = instrumentSink(sinkTypeEventHubs, newEventHubsSink(ContainerLogsEventHubsRoute, EventHubsRouteConfig))
+	case ContainerLogsRouteOTLP:
+		ContainerLogSink = instrumentSink(sinkTypeOTLP, &otlpSink{name: ContainerLogsOTLPRoute, exporter: OTLPRouteExporter})
+	default:
+		ContainerLogSink = instrumentSink(sinkTypeODS, newODSSink())
+	}
+	Log("Container log sink = %s", ContainerLogSink.Name())
+}
+
+// sinkHealth the health of a sink, from the outcome of its last send
+type sinkHealth struct {
+	mu        sync.Mutex
+	unhealthy bool
+}
+
+func (h *sinkHealth) Healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.unhealthy
+}
+
+// setSendResult updates the health with the result of a send and returns it
+func (h *sinkHealth) setSendResult(err error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unhealthy = err != nil && err != errBatchDropped
+	return err
+}
+
+// mdsdSink sends the records to the local agent, mdsd or geneva, as a forward message on its unix socket
+type mdsdSink struct {
+	sinkHealth
+	route string
+}
+
+func newMdsdSink(route string) *mdsdSink {
+	return &mdsdSink{route: route}
+}
+
+func (s *mdsdSink) Name() string { return s.route }
+
+func (s *mdsdSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	return s.setSendResult(s.send(ctx, batch))
+}
+
+func (s *mdsdSink) send(ctx context.Context, batch *containerLogBatch) error {
+	ensureMdsdContainerLogTagName()
+	// the records are written in chunks, the chunks after a failed one are not written. The chunks written before the
+	// failure are remembered so the retry of the batch does not write them again
+	chunks := splitMdsdEntries(MdsdContainerLogTagName, batch.msgPackEntries, mdsdWriteChunkLimit())
+	skippedRecords := 0
+	var err error
+	for _, entries := range chunks {
+		chunkID := ""
+		if len(chunks) > 1 {
+			chunkID = mdsdChunkID(entries)
+			if MdsdWrittenChunks.contains(s.route, chunkID, time.Now()) {
+				skippedRecords += len(entries)
+				continue
+			}
+		}
+		if err = s.sendMessage(ctx, entries, batch.start); err != nil {
+			break
+		}
+		MdsdWrittenChunks.add(s.route, chunkID, time.Now())
+	}
+	if skippedRecords > 0 {
+		Log("Info::mdsd::skipped %d records of the retried batch already written to mdsd", skippedRecords)
+		updateMdsdBackpressureTelemetry(0, skippedRecords)
+	}
+	return err
+}
+
+func (s *mdsdSink) sendMessage(ctx context.Context, entries []MsgPackEntry, start time.Time) error {
+	fluentForward := MsgPackForward{
+		Tag:     MdsdContainerLogTagName,
+		Entries: entries,
+		Option:  newMdsdForwardOption(len(entries)),
+	}
+
+	//serialize the msgp message in a buffer of the pool, given back once written
+	msgpSize := estimateMdsdMessageSize(fluentForward.Tag, fluentForward.Entries)
+	buf := getMsgpBuffer(msgpSize)
+	defer buf.release()
+
+	//construct the stream
+	if fluentForward.Option == nil {
+		buf.b = append(buf.b, 0x92)
+		buf.b = msgp.AppendString(buf.b, fluentForward.Tag)
+		buf.b = msgp.AppendArrayHeader(buf.b, uint32(len(fluentForward.Entries)))
+	}
+	buf.b = appendMdsdEntries(buf.b, fluentForward.Entries, time.Now().Unix())
+	msgpBytes := buf.b
+	ackChunk := ""
+	if fluentForward.Option != nil {
+		// the option follows the entries, which are packed and compressed when the route accepts it
+		message := getMsgpBuffer(msgpSize)
+		defer message.release()
+		var err error
+		message.b, err = appendForwardMessage(message.b, fluentForward.Tag, buf.b, len(fluentForward.Entries), fluentForward.Option)
+		if err != nil {
+			Log("Error::mdsd::Failed to encode the forward message of %d records. Will retry ... error : %s", len(entries), err.Error())
+			return err
+		}
+		msgpBytes = message.b
+		ackChunk = fluentForward.Option.Chunk
+	}
+
+	_, err := writeContainerLogsToMdsd(ctx, msgpBytes, ackChunk, len(entries), start)
+	return err
+}
+
+// adxSink ingests the records into the ContainerLogV2 table of an ADX cluster, the cluster of the ADX route when
+// config is nil
+type adxSink struct {
+	sinkHealth
+	name       string
+	config     *adxClientConfig
+	ingestorMu sync.Mutex
+	ingestor   *ingest.Ingestion
+}
+
+func newADXSink() *adxSink {
+	return &adxSink{name: ContainerLogsADXRoute}
+}
+
+func (s *adxSink) Name() string { return s.name }
+
+func (s *adxSink) clusterURI() string {
+	if s.config == nil {
+		return AdxClusterUri
+	}
+	return s.config.clusterURI
+}
+
+// getIngestor returns the ingestor of the cluster, created again when the previous creation failed
+func (s *adxSink) getIngestor() (*ingest.Ingestion, error) {
+	if s.config == nil {
+		if ADXIngestor == nil {
+			Log("Error::ADX::ADXIngestor does not exist. re-creating ...")
+			CreateADXClient()
+			if ADXIngestor == nil {
+				return nil, errors.New("unable to create the ADX client")
+			}
+		}
+		return ADXIngestor, nil
+	}
+	s.ingestorMu.Lock()
+	defer s.ingestorMu.Unlock()
+	if s.ingestor == nil {
+		ingestor, err := newADXIngestor(*s.config)
+		if err != nil {
+			return nil, err
+		}
+		s.ingestor = ingestor
+	}
+	return s.ingestor, nil
+}
+
+func (s *adxSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	return s.setSendResult(s.send(ctx, batch))
+}
+
+func (s *adxSink) send(ctx context.Context, batch *containerLogBatch) error {
+	r, w := io.Pipe()
+	defer r.Close()
+	enc := json.NewEncoder(w)
+	go func() {
+		defer w.Close()
+		for _, data := range batch.dataItemsADX {
+			if encError := enc.Encode(data); encError != nil {
+				message := fmt.Sprintf("Error::ADX Encoding data for ADX %s", encError)
+				Log(message)
+				//SendException(message) //use for testing/debugging only as this can generate a lot of exceptions
+				//continue and move on, so one poisoned message does not impact the whole batch
+			}
+		}
+	}()
+
+	ingestor, err := s.getIngestor()
+	if err != nil {
+		Log("Error::ADX::Unable to create ADX client for the %s sink. Please check error log.", s.name)
+
+		ContainerLogTelemetryMutex.Lock()
+		defer ContainerLogTelemetryMutex.Unlock()
+		ContainerLogsADXClientCreateErrors += 1
+		PluginMetrics.addClientCreateError("adx")
+
+		return err
+	}
+
+	// Setup a maximum time for completion to be 30 Seconds.
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	//MultiJSON support is not there yet
+	sendStart := time.Now()
+	_, ingestionErr := ingestor.FromReader(ctx, r, ingest.IngestionMappingRef("ContainerLogV2Mapping", ingest.JSON), ingest.FileFormat(ingest.JSON))
+	trackFlushDependency(dependencyTypeADX, dependencyTarget(s.clusterURI()), ContainerLogV2DataType, sendStart, errorDependencyResultCode(ingestionErr), ingestionErr == nil, len(batch.dataItemsADX))
+	if ingestionErr != nil {
+		Log("Error when streaming to ADX Ingestion: %s", ingestionErr.Error())
+
+		return ingestionErr
+	}
+
+	Log("Success::ADX::Successfully wrote %d container log records to ADX (%s) in %s", len(batch.dataItemsADX), s.name, time.Since(batch.start))
+	return nil
+}
+
+// odsSink posts the records to the ODS endpoint of the workspace, with the ContainerLog or the ContainerLogV2 schema
+type odsSink struct {
+	sinkHealth
+	name string
+	// the ODS endpoint and client of a workspace route, the workspace of the agent when not set
+	endpoint string
+	client   *http.Client
+}
+
+func newODSSink() *odsSink {
+	return &odsSink{name: ContainerLogsV1Route}
+}
+
+func (s *odsSink) Name() string { return s.name }
+
+func (s *odsSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	return s.setSendResult(s.send(ctx, batch))
+}
+
+// send posts the batch, in chunks under the max payload size of ODS
+func (s *odsSink) send(ctx context.Context, batch *containerLogBatch) error {
+	recordType := "ContainerLog"
+	loglinesCount := len(batch.dataItemsLAv1)
+	if len(batch.dataItemsLAv2) > 0 {
+		recordType = "ContainerLogV2"
+		loglinesCount = len(batch.dataItemsLAv2)
+	}
+	marshal := func(start int, end int) ([]byte, error) {
+		var logEntry interface{}
+		if len(batch.dataItemsLAv2) > 0 {
+			//schema v2
+			logEntry = ContainerLogBlobLAv2{
+				DataType:  ContainerLogV2DataType,
+				IPName:    IPName,
+				DataItems: batch.dataItemsLAv2[start:end]}
+		} else {
+			//schema v1
+			logEntry = ContainerLogBlobLAv1{
+				DataType:  ContainerLogDataType,
+				IPName:    IPName,
+				DataItems: batch.dataItemsLAv1[start:end]}
+		}
+		marshalled, err := json.Marshal(logEntry)
+		if err != nil {
+			message := fmt.Sprintf("Error while Marshalling log Entry: %s", err.Error())
+			Log(message)
+			SendException(message)
+			return nil, errBatchDropped
+		}
+		return marshalled, nil
+	}
+	return sendODSPayloadChunks(ctx, "PostDataHelper", loglinesCount, marshal, func(start int, end int, marshalled []byte) error {
+		return sendUnsentPayload("PostDataHelper", s.name, batch.id, start, end, func() error {
+			return s.post(ctx, batch, marshalled, end-start, recordType)
+		})
+	})
+}
+
+// postPayload posts the payload to the endpoint of the sink when set, and to the workspace of the agent otherwise
+func (s *odsSink) postPayload(ctx context.Context, payload []byte) (*http.Response, string, string, error) {
+	if s.endpoint == "" {
+		return postODSPayload(ctx, payload)
+	}
+	resp, reqID, err := postToODSEndpoint(ctx, s.endpoint, s.client, payload)
+	return resp, reqID, s.endpoint, err
+}
+
+// post posts a payload of the batch to ODS
+func (s *odsSink) post(ctx context.Context, batch *containerLogBatch, marshalled []byte, loglinesCount int, recordType string) error {
+	sendStart := time.Now()
+	resp, reqId, endpoint, err := s.postPayload(ctx, marshalled)
+	trackFlushDependency(dependencyTypeODS, dependencyTarget(endpoint), getContainerLogsDataType(), sendStart, httpDependencyResultCode(resp, err), err == nil && resp != nil && resp.StatusCode == 200, loglinesCount)
+	elapsed := time.Since(batch.start)
+
+	if err != nil {
+		message := fmt.Sprintf("Error when sending request %s \n", err.Error())
+		Log(message)
+		// Commenting this out for now. TODO - Add better telemetry for ods errors using aggregation
+		//SendException(message)
+
+		Log("Failed to flush %d records after %s", loglinesCount, elapsed)
+
+		return err
+	}
+
+	if resp == nil || resp.StatusCode != 200 {
+		if resp != nil {
+			LogWith(pluginLogFields{Route: s.name, RequestID: reqId}, "RequestId %s Status %s Status Code %d", reqId, resp.Status, resp.StatusCode)
+			retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if resp.StatusCode == 429 && s.endpoint == "" {
+				ODSThrottles.throttle(getContainerLogsDataType(), retryAfter, time.Now())
+			}
+			return &sinkStatusError{statusCode: resp.StatusCode, retryAfter: retryAfter}
+		}
+		return errors.New("no response from ODS")
+	}
+
+	defer resp.Body.Close()
+	Log("PostDataHelper::Info::Successfully flushed %d %s records to ODS in %s", loglinesCount, recordType, elapsed)
+	return nil
+}
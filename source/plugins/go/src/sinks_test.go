@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_sinkHealth(t *testing.T) {
+	type test_struct struct {
+		testname string
+		results  []error
+		output   bool
+	}
+
+	tests := []test_struct{
+		{"no send", nil, true},
+		{"send failed", []error{errors.New("connection refused")}, false},
+		{"recovered", []error{errors.New("connection refused"), nil}, true},
+		{"batch dropped", []error{errBatchDropped}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			sink := newODSSink()
+			for _, err := range tt.results {
+				if got := sink.setSendResult(err); got != err {
+					t.Errorf("setSendResult() = %v, want %v", got, err)
+				}
+			}
+			if got := sink.Healthy(); got != tt.output {
+				t.Errorf("Healthy() = %v, want %v", got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_odsSinkSend(t *testing.T) {
+	type test_struct struct {
+		testname   string
+		batch      *containerLogBatch
+		statusCode int
+		dataType   string
+		isError    bool
+	}
+
+	tests := []test_struct{
+		{"ContainerLog", &containerLogBatch{dataItemsLAv1: []DataItemLAv1{{LogEntry: "line"}}}, 200, ContainerLogDataType, false},
+		{"ContainerLogV2", &containerLogBatch{dataItemsLAv2: []DataItemLAv2{{LogMessage: "line"}}}, 200, ContainerLogV2DataType, false},
+		{"server error", &containerLogBatch{dataItemsLAv2: []DataItemLAv2{{LogMessage: "line"}}}, 503, ContainerLogV2DataType, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			sink := &odsSink{name: "workspace", endpoint: server.URL, client: server.Client()}
+			tt.batch.start = time.Now()
+			err := sink.Send(context.Background(), tt.batch)
+			if (err != nil) != tt.isError {
+				t.Fatalf("Send() error = %v, want error %v", err, tt.isError)
+			}
+			if statusErr, ok := err.(*sinkStatusError); tt.isError && (!ok || statusErr.statusCode != tt.statusCode) {
+				t.Errorf("Send() error = %v, want the status %d", err, tt.statusCode)
+			}
+			if sink.Healthy() == tt.isError {
+				t.Errorf("Healthy() = %v after the send error %v", sink.Healthy(), err)
+			}
+			if !bytes.Contains(body, []byte(`"DataType":"`+tt.dataType+`"`)) {
+				t.Errorf("Send() posted %s, want the %s data type", body, tt.dataType)
+			}
+		})
+	}
+}
+
+func Test_mdsdSinkSend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64*1024)
+		n, _ := conn.Read(buf)
+		received <- buf[:n]
+	}()
+
+	type test_struct struct {
+		testname string
+		dial     func() (net.Conn, error)
+		isError  bool
+	}
+
+	tests := []test_struct{
+		{"mdsd listening", func() (net.Conn, error) { return net.Dial("tcp", listener.Addr().String()) }, false},
+		{"mdsd not listening", func() (net.Conn, error) { return nil, errors.New("connection refused") }, true},
+	}
+
+	defer func() {
+		MdsdContainerLogConnPool = newMdsdConnPool(defaultMdsdConnectionPoolSize, dialMdsdContainerLogConn)
+	}()
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			MdsdContainerLogConnPool = newMdsdConnPool(1, tt.dial)
+			sink := newMdsdSink(ContainerLogsV2Route)
+			batch := &containerLogBatch{msgPackEntries: []MsgPackEntry{{Record: map[string]string{"LogMessage": "mdsd line"}}}, start: time.Now()}
+			err := sink.Send(context.Background(), batch)
+			if (err != nil) != tt.isError {
+				t.Fatalf("Send() error = %v, want error %v", err, tt.isError)
+			}
+			if sink.Healthy() == tt.isError {
+				t.Errorf("Healthy() = %v after the send error %v", sink.Healthy(), err)
+			}
+			if tt.isError {
+				return
+			}
+			select {
+			case message := <-received:
+				if !bytes.Contains(message, []byte("mdsd line")) {
+					t.Errorf("Send() wrote %x, want the record", message)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("Send() did not write to mdsd")
+			}
+		})
+	}
+}
+
+func Test_odsSinkSendSkipsSentPayloads(t *testing.T) {
+	posts := make(map[string]int)
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		for _, line := range []string{"line 0", "line 1"} {
+			if bytes.Contains(body, []byte(line)) {
+				posts[line]++
+				if line == "line 1" && failing {
+					w.WriteHeader(503)
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	RecentChunks = newRecentChunks(10, time.Minute)
+	ODSMaxPayloadBytes, ODSPayloadChunkRetries = 150, 0
+	defer func() {
+		RecentChunks = nil
+		ODSMaxPayloadBytes, ODSPayloadChunkRetries = defaultODSMaxPayloadKB*1024, defaultODSPayloadChunkRetries
+	}()
+	sink := &odsSink{name: "workspace", endpoint: server.URL, client: server.Client()}
+	batch := func() *containerLogBatch {
+		return &containerLogBatch{dataItemsLAv2: []DataItemLAv2{{LogMessage: "line 0"}, {LogMessage: "line 1"}}, start: time.Now(), id: "chunk"}
+	}
+
+	if err := sink.Send(context.Background(), batch()); err == nil {
+		t.Fatalf("Send() succeeded with a failing payload")
+	}
+	failing = false
+	if err := sink.Send(context.Background(), batch()); err != nil {
+		t.Fatal(err)
+	}
+	if posts["line 0"] != 1 || posts["line 1"] != 2 {
+		t.Errorf("Send() posted the payloads %v times, want line 0 once and line 1 twice", posts)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variable for the warm-up window (seconds) during which flushes wait for the downstream to become ready
+const envStartupReadinessTimeoutSeconds = "AZMON_STARTUP_READINESS_TIMEOUT_SECONDS"
+
+const (
+	defaultStartupReadinessTimeoutSeconds = 300
+	startupReadinessInitialBackoff        = 1 * time.Second
+	startupReadinessMaxBackoff            = 30 * time.Second
+	// flushes deferred during the warm-up are logged at most once per this interval
+	startupReadinessLogInterval = 60 * time.Second
+)
+
+var (
+	// DownstreamReady is false during the warm-up window until the route's destination is reachable
+	DownstreamReady = true
+	// DownstreamReadinessMutex read and write mutex access to DownstreamReady and the deferred flush counters
+	DownstreamReadinessMutex = &sync.Mutex{}
+	deferredFlushCount       int
+	lastDeferredFlushLog     time.Time
+)
+
+// nextStartupReadinessBackoff doubles the backoff up to the max
+func nextStartupReadinessBackoff(backoff time.Duration) time.Duration {
+	backoff = backoff * 2
+	if backoff > startupReadinessMaxBackoff {
+		return startupReadinessMaxBackoff
+	}
+	return backoff
+}
+
+// readinessDialAddress returns the host:port to dial for checking the reachability of the endpoint. When a proxy
+// is configured only the proxy is reachable from the agent, so the proxy is checked instead
+func readinessDialAddress(endpoint string, proxyEndpoint string) string {
+	if proxyEndpoint != "" {
+		endpoint = proxyEndpoint
+	}
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if strings.EqualFold(u.Scheme, "http") {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// checkDownstreamReadiness returns nil when the destination of the configured route is ready to accept data
+func checkDownstreamReadiness() error {
+	if ContainerLogsRouteV2 == true {
+		return checkMdsdFluentAvailable(ContainerType)
+	}
+	endpoint := OMSEndpoint
+	if ContainerLogsRouteADX == true {
+		endpoint = AdxClusterUri
+	} else if ContainerLogsRouteDCR == true {
+		endpoint = DCRRouteConfig.endpoint
+	}
+	address := readinessDialAddress(endpoint, ProxyEndpoint)
+	if ContainerLogsRouteKafka == true && len(KafkaRouteConfig.brokers) > 0 {
+		// the brokers are not reached thru the proxy
+		address = KafkaRouteConfig.brokers[0]
+	} else if ContainerLogsRouteEventHubs == true {
+		// AMQP over TLS, not thru the proxy
+		address = EventHubsRouteConfig.namespace + ":5671"
+	} else if ContainerLogsRouteOTLP == true {
+		// the collector is reached directly, usually in the cluster
+		address = OTLPRouteConfig.endpoint
+	}
+	if address == "" {
+		// nothing to check, the flush path reports the misconfiguration
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("%s is not reachable: %s", address, err.Error())
+	}
+	conn.Close()
+	return nil
+}
+
+// startDownstreamReadinessGate defers the flushes until the downstream is ready or the warm-up window elapses
+func startDownstreamReadinessGate() {
+	timeoutSeconds := defaultStartupReadinessTimeoutSeconds
+	if value := strings.TrimSpace(os.Getenv(envStartupReadinessTimeoutSeconds)); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			timeoutSeconds = parsed
+		} else {
+			Log("Invalid value %s for %s, using the default of %d seconds", value, envStartupReadinessTimeoutSeconds, defaultStartupReadinessTimeoutSeconds)
+		}
+	}
+	if timeoutSeconds == 0 {
+		Log("Startup readiness gating is disabled")
+		return
+	}
+
+	DownstreamReadinessMutex.Lock()
+	DownstreamReady = false
+	DownstreamReadinessMutex.Unlock()
+	go waitForDownstreamReadiness(timeoutSeconds)
+}
+
+func waitForDownstreamReadiness(timeoutSeconds int) {
+	start := time.Now()
+	deadline := start.Add(time.Duration(timeoutSeconds) * time.Second)
+	backoff := startupReadinessInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := checkDownstreamReadiness()
+		if err == nil {
+			Log("Info::Downstream is ready after %s (%d attempts), accepting flushes", time.Since(start), attempt)
+			break
+		}
+		if time.Now().After(deadline) {
+			message := fmt.Sprintf("Downstream is still not ready after %d seconds, accepting flushes anyway: %s", timeoutSeconds, err.Error())
+			Log("Error::%s", message)
+			SendException(message)
+			break
+		}
+		Log("Info::Waiting %s for the downstream to become ready (attempt %d): %s", backoff, attempt, err.Error())
+		time.Sleep(backoff)
+		backoff = nextStartupReadinessBackoff(backoff)
+	}
+
+	DownstreamReadinessMutex.Lock()
+	DownstreamReady = true
+	if deferredFlushCount > 0 {
+		Log("Info::%d flushes were deferred during the startup warm-up", deferredFlushCount)
+	}
+	deferredFlushCount = 0
+	DownstreamReadinessMutex.Unlock()
+}
+
+// isDownstreamReady returns whether flushes are accepted. Deferred flushes are logged at most once per interval,
+// so the warm-up does not flood the log with an error per flush
+func isDownstreamReady(caller string) bool {
+	DownstreamReadinessMutex.Lock()
+	defer DownstreamReadinessMutex.Unlock()
+	if DownstreamReady {
+		return true
+	}
+	deferredFlushCount++
+	if time.Since(lastDeferredFlushLog) >= startupReadinessLogInterval {
+		Log("%s::Info::Downstream is not ready yet, retrying the flush later (%d flushes deferred so far)", caller, deferredFlushCount)
+		lastDeferredFlushLog = time.Now()
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// port of the stats endpoint, served on localhost only. The endpoint is off when not set
+const envStatsPort = "AZMON_STATS_PORT"
+
+// StatsServeMux the handlers of the localhost stats endpoint
+var StatsServeMux = http.NewServeMux()
+
+// sinkStatsView the send counters of a sink as served by the stats endpoint
+type sinkStatsView struct {
+	Type      string             `json:"type"`
+	Attempts  float64            `json:"attempts"`
+	Successes float64            `json:"successes"`
+	Failures  map[string]float64 `json:"failures"`
+	Records   float64            `json:"records"`
+	Bytes     float64            `json:"bytes"`
+	LatencyMs float64            `json:"latencyMs"`
+}
+
+// pluginStats the document served at /stats
+type pluginStats struct {
+	Backlog backlogStats             `json:"backlog"`
+	Sinks   map[string]sinkStatsView `json:"sinks"`
+}
+
+// initializeStatsEndpoint serves the stats of the plugin on localhost when the port is set
+func initializeStatsEndpoint() {
+	value := strings.TrimSpace(os.Getenv(envStatsPort))
+	if value == "" {
+		return
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		Log("Error::stats::Invalid value %s for %s, the stats endpoint is disabled", value, envStatsPort)
+		return
+	}
+	StatsServeMux.HandleFunc("/stats", handleStats)
+	StatsServeMux.HandleFunc("/config", handleEffectiveConfig)
+	go func() {
+		if err := http.ListenAndServe("localhost:"+value, StatsServeMux); err != nil {
+			Log("Error::stats::Stats endpoint stopped: %s", err.Error())
+		}
+	}()
+	Log("Serving the plugin stats on localhost:%d/stats and its configuration on localhost:%d/config", port, port)
+}
+
+func getPluginStats() pluginStats {
+	stats := pluginStats{Backlog: getBacklogStats(), Sinks: make(map[string]sinkStatsView)}
+	for name, counters := range ContainerLogSinkStats.snapshot() {
+		stats.Sinks[name] = sinkStatsView{
+			Type:      counters.sinkType,
+			Attempts:  counters.attempts,
+			Successes: counters.successes,
+			Failures:  counters.failures,
+			Records:   counters.records,
+			Bytes:     counters.bytes,
+			LatencyMs: counters.latencyMs,
+		}
+	}
+	return stats
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getPluginStats())
+}
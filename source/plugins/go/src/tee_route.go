@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the second route the container log records are written to with the route of the container logs, to compare their
+// data before switching routes: adx, v1 for ODS, or eventhubs to stream them in addition to the route
+const envContainerLogsTeeRoute = "AZMON_CONTAINER_LOGS_TEE_ROUTE"
+
+// name of the sink of the tee route. The settings of the adx tee sink are read like the ones of a declared sink,
+// AZMON_TEE_<SETTING> or tee_<setting> in the plugin configuration
+const teeSinkName = "tee"
+
+// TeeRoute the route the records are also written to, empty when the tee is disabled
+var TeeRoute string
+
+// TeeSink the sink of the tee route, nil when the tee is disabled
+var TeeSink Sink
+
+// initializeTeeRoute creates the sink of the tee route, after the route of the container logs is set up
+func initializeTeeRoute() {
+	TeeRoute = ""
+	TeeSink = nil
+	route := strings.TrimSpace(strings.ToLower(os.Getenv(envContainerLogsTeeRoute)))
+	if route == "" {
+		return
+	}
+	if route == getContainerLogsRouteName() {
+		Log("Error::tee::The tee route %s is the route of the container logs, the records are not written twice", route)
+		return
+	}
+	var sink Sink
+	switch route {
+	case ContainerLogsADXRoute:
+		adxSink, err := newDeclaredADXSink(teeSinkName)
+		if err != nil {
+			Log("Error::tee::Unable to create the sink of the tee route %s: %s", route, err.Error())
+			return
+		}
+		sink = instrumentSink(sinkTypeADX, adxSink)
+	case ContainerLogsV1Route:
+		if HTTPClient.Transport == nil {
+			CreateHTTPClient()
+		}
+		sink = instrumentSink(sinkTypeODS, &odsSink{name: teeSinkName})
+	case ContainerLogsEventHubsRoute:
+		eventHubsSink, err := newDeclaredEventHubsSink(teeSinkName)
+		if err != nil {
+			Log("Error::tee::Unable to create the sink of the tee route %s: %s", route, err.Error())
+			return
+		}
+		sink = instrumentSink(sinkTypeEventHubs, eventHubsSink)
+	default:
+		Log("Error::tee::Unsupported tee route %s, the tee route is %s, %s or %s", route, ContainerLogsADXRoute, ContainerLogsV1Route, ContainerLogsEventHubsRoute)
+		return
+	}
+	TeeRoute = route
+	TeeSink = sink
+	Log("Writing the container log records to the tee route %s with the %s route", route, getContainerLogsRouteName())
+}
+
+// teeRecordFields the fields of a record sent to the tee route. The columns of the record are the ones of the schema
+// of the route of the container logs, so the fields the other schemas need are set from the log line
+type teeRecordFields struct {
+	containerID    string
+	namespace      string
+	podName        string
+	containerName  string
+	logEntry       string
+	logSource      string
+	timeStamp      string
+	image          string
+	name           string
+	promotedFields map[string]string
+}
+
+// appendTeeItem adds the record to the batch of the tee route, in the schema of the tee route
+func appendTeeItem(batch *containerLogBatch, stringMap map[string]string, fields teeRecordFields) {
+	batch.logBytes += len(fields.logEntry)
+	if TeeRoute == ContainerLogsADXRoute {
+		azureResourceID := ""
+		if ResourceCentric == true {
+			azureResourceID = ResourceID
+		}
+		batch.dataItemsADX = append(batch.dataItemsADX, DataItemADX{
+			TimeGenerated:   fields.timeStamp,
+			Computer:        Computer,
+			ContainerId:     fields.containerID,
+			ContainerName:   fields.containerName,
+			PodName:         fields.podName,
+			PodNamespace:    fields.namespace,
+			LogMessage:      fields.logEntry,
+			LogSource:       fields.logSource,
+			AzureResourceId: azureResourceID,
+			PodUid:          stringMap["PodUid"],
+			RestartCount:    stringMap["RestartCount"],
+			ContainerLabels: stringMap["ContainerLabels"],
+			LogLevel:        stringMap["LogLevel"],
+			PromotedFields:  fields.promotedFields,
+		})
+		return
+	}
+	if ContainerLogSchemaV2 == true {
+		logLevel := stringMap["LogLevel"]
+		if logLevel == "" {
+			logLevel = streamLogLevel(fields.logSource)
+		}
+		batch.dataItemsLAv2 = append(batch.dataItemsLAv2, DataItemLAv2{
+			TimeGenerated:   fields.timeStamp,
+			Computer:        Computer,
+			ContainerId:     fields.containerID,
+			ContainerName:   fields.containerName,
+			PodName:         fields.podName,
+			PodNamespace:    fields.namespace,
+			LogMessage:      fields.logEntry,
+			LogSource:       fields.logSource,
+			PodUid:          stringMap["PodUid"],
+			RestartCount:    stringMap["RestartCount"],
+			ContainerLabels: stringMap["ContainerLabels"],
+			LogLevel:        logLevel,
+		})
+		return
+	}
+	batch.dataItemsLAv1 = append(batch.dataItemsLAv1, DataItemLAv1{
+		LogEntry:              fields.logEntry,
+		LogEntrySource:        fields.logSource,
+		LogEntryTimeStamp:     fields.timeStamp,
+		LogEntryTimeOfCommand: batch.start.Format(time.RFC3339),
+		ID:                    fields.containerID,
+		Image:                 fields.image,
+		Name:                  fields.name,
+		SourceSystem:          "Containers",
+		Computer:              Computer,
+		ContainerLabels:       stringMap["ContainerLabels"],
+		LogLevel:              stringMap["LogLevel"],
+	})
+}
+
+// startTeeSend sends the batch of the tee route while the batch of the route of the container logs is sent, and
+// returns the function waiting for the send. The tee route is best effort: its failures are counted and never retry
+// the chunk, and a chunk retried for the route of the container logs is not sent again to the tee route once sent
+func startTeeSend(ctx context.Context, batch *containerLogBatch, batchID string) func() {
+	if TeeSink == nil || batch.len() == 0 {
+		return func() {}
+	}
+	if RecentChunks.contains(TeeRoute, batchID, time.Now()) {
+		return func() {}
+	}
+	if TeeRoute == ContainerLogsV1Route {
+		_, batch.dataItemsLAv2, batch.dataItemsLAv1 = enforceLogAnalyticsLimits(nil, batch.dataItemsLAv2, batch.dataItemsLAv1)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := TeeSink.Send(ctx, batch)
+		if err == nil || err == errBatchDropped {
+			RecentChunks.add(TeeRoute, batchID, time.Now())
+			return
+		}
+		Log("Error::tee::Failed to send %d records to the tee route %s, the records are dropped on this route: %s", batch.len(), TeeRoute, err.Error())
+		ContainerLogTelemetryMutex.Lock()
+		TeeRouteDroppedRecordCount += float64(batch.len())
+		ContainerLogTelemetryMutex.Unlock()
+	}()
+	return wg.Wait
+}
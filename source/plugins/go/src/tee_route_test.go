@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// teeSink a sink of the tee route counting the sends
+type teeSink struct {
+	sinkHealth
+	err   error
+	sends int
+}
+
+func (s *teeSink) Name() string { return teeSinkName }
+
+func (s *teeSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	s.sends++
+	return s.setSendResult(s.err)
+}
+
+func Test_appendTeeItem(t *testing.T) {
+	type test_struct struct {
+		testName    string
+		teeRoute    string
+		schemaV2    bool
+		wantADX     int
+		wantLAv2    int
+		wantLAv1    int
+		wantMessage string
+	}
+
+	tests := []test_struct{
+		{"adx", ContainerLogsADXRoute, false, 1, 0, 0, "line"},
+		{"ods v1 schema", ContainerLogsV1Route, false, 0, 0, 1, "line"},
+		{"ods v2 schema", ContainerLogsV1Route, true, 0, 1, 0, "line"},
+	}
+
+	defer func() { TeeRoute, ContainerLogSchemaV2 = "", false }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			TeeRoute, ContainerLogSchemaV2 = tt.teeRoute, tt.schemaV2
+			batch := &containerLogBatch{start: time.Now()}
+			// the columns of the v1 schema of the route of the container logs
+			stringMap := map[string]string{"LogEntry": "line", "Id": "c1", "PodUid": "uid1"}
+			appendTeeItem(batch, stringMap, teeRecordFields{containerID: "c1", namespace: "ns", podName: "pod", containerName: "app", logEntry: "line", logSource: "stderr", image: "img", name: "pod/app"})
+			if len(batch.dataItemsADX) != tt.wantADX || len(batch.dataItemsLAv2) != tt.wantLAv2 || len(batch.dataItemsLAv1) != tt.wantLAv1 {
+				t.Fatalf("appendTeeItem() = %d ADX, %d LAv2, %d LAv1 items, want %d, %d, %d", len(batch.dataItemsADX), len(batch.dataItemsLAv2), len(batch.dataItemsLAv1), tt.wantADX, tt.wantLAv2, tt.wantLAv1)
+			}
+			switch {
+			case tt.wantADX > 0:
+				item := batch.dataItemsADX[0]
+				if item.LogMessage != tt.wantMessage || item.PodNamespace != "ns" || item.PodUid != "uid1" {
+					t.Errorf("appendTeeItem() = %+v", item)
+				}
+			case tt.wantLAv2 > 0:
+				item := batch.dataItemsLAv2[0]
+				if item.LogMessage != tt.wantMessage || item.ContainerName != "app" || item.LogLevel != logLevelError {
+					t.Errorf("appendTeeItem() = %+v", item)
+				}
+			default:
+				item := batch.dataItemsLAv1[0]
+				if item.LogEntry != tt.wantMessage || item.Image != "img" || item.Name != "pod/app" || item.ID != "c1" {
+					t.Errorf("appendTeeItem() = %+v", item)
+				}
+			}
+			if batch.logBytes != len("line") {
+				t.Errorf("appendTeeItem() logBytes = %d, want %d", batch.logBytes, len("line"))
+			}
+		})
+	}
+}
+
+func Test_startTeeSend(t *testing.T) {
+	type test_struct struct {
+		testName    string
+		err         error
+		wantSends   int
+		wantDropped float64
+	}
+
+	tests := []test_struct{
+		// a chunk sent to the tee route is not sent again when it is retried
+		{"sent once", nil, 1, 0},
+		// a failed chunk is counted each time, and sent again when it is retried
+		{"failed", errors.New("ingestion failed"), 2, 2},
+	}
+
+	defer func() { TeeRoute, TeeSink, RecentChunks = "", nil, nil }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			sink := &teeSink{err: tt.err}
+			TeeRoute, TeeSink = ContainerLogsADXRoute, sink
+			RecentChunks = newRecentChunks(8, time.Minute)
+			TeeRouteDroppedRecordCount = 0
+			batch := &containerLogBatch{dataItemsADX: []DataItemADX{{LogMessage: "line"}}, start: time.Now()}
+			for i := 0; i < 2; i++ {
+				startTeeSend(context.Background(), batch, "chunk1")()
+			}
+			if sink.sends != tt.wantSends || TeeRouteDroppedRecordCount != tt.wantDropped {
+				t.Errorf("startTeeSend() sent %d times with %v dropped records, want %d and %v", sink.sends, TeeRouteDroppedRecordCount, tt.wantSends, tt.wantDropped)
+			}
+		})
+	}
+}
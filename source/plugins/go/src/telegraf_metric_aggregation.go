@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// env variable of the window in seconds of the aggregation of the telegraf metrics, set from the
+// metric_collection_settings.telegraf_metric_aggregation section of the configmap. The metrics are sent as they are
+// collected when it is not set or 0
+const envTelegrafMetricsAggregationWindowSeconds = "AZMON_TELEGRAF_METRICS_AGGREGATION_WINDOW_SECONDS"
+
+// the tags of the aggregated metrics, whose value is the average of the metrics of the window
+const (
+	telegrafTagAggregationWindow = "aggregationWindowSeconds"
+	telegrafTagAggregationMin    = "aggregationMin"
+	telegrafTagAggregationMax    = "aggregationMax"
+	telegrafTagAggregationCount  = "aggregationCount"
+)
+
+// the ids of the chunks aggregated are remembered for an hour, so a chunk retried within the hour is not aggregated twice
+const telegrafAggregatedBatchTTL = time.Hour
+
+// telegrafMetricAggregate the min, max, sum and count of the metrics of a series in a window
+type telegrafMetricAggregate struct {
+	key         string
+	metric      laTelegrafMetric
+	windowStart time.Time
+	min         float64
+	max         float64
+	sum         float64
+	count       int
+}
+
+// telegrafMetricAggregator aggregates the telegraf metrics per namespace, name and tags in windows. The windows closed
+// are sent with the metrics of the next chunk
+type telegrafMetricAggregator struct {
+	window     time.Duration
+	mu         sync.Mutex
+	aggregates map[string]*telegrafMetricAggregate
+	// the ids of the chunks aggregated, with the time they were aggregated
+	batches map[string]time.Time
+}
+
+// TelegrafMetricAggregator the aggregator of the telegraf metrics, nil when the metrics are not aggregated
+var TelegrafMetricAggregator *telegrafMetricAggregator
+
+// initializeTelegrafMetricAggregation reads the window of the aggregation of the telegraf metrics
+func initializeTelegrafMetricAggregation() {
+	TelegrafMetricAggregator = nil
+	value := strings.TrimSpace(os.Getenv(envTelegrafMetricsAggregationWindowSeconds))
+	if value == "" {
+		return
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		Log("Invalid value %s for %s, not aggregating the telegraf metrics", value, envTelegrafMetricsAggregationWindowSeconds)
+		return
+	}
+	if seconds == 0 {
+		return
+	}
+	TelegrafMetricAggregator = newTelegrafMetricAggregator(time.Duration(seconds) * time.Second)
+	Log("Aggregating the telegraf metrics in windows of %d seconds", seconds)
+}
+
+func newTelegrafMetricAggregator(window time.Duration) *telegrafMetricAggregator {
+	return &telegrafMetricAggregator{
+		window:     window,
+		aggregates: make(map[string]*telegrafMetricAggregate),
+		batches:    make(map[string]time.Time),
+	}
+}
+
+// add aggregates the metrics of the chunk, unless the chunk was already aggregated, and returns the aggregates of the
+// windows closed at now
+func (a *telegrafMetricAggregator) add(batchID string, metrics []*laTelegrafMetric, now time.Time) []*telegrafMetricAggregate {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, aggregatedAt := range a.batches {
+		if now.Sub(aggregatedAt) > telegrafAggregatedBatchTTL {
+			delete(a.batches, id)
+		}
+	}
+	if _, ok := a.batches[batchID]; ok && batchID != "" {
+		Log("PostTelegrafMetricsToLA::Info::not aggregating again the %d metrics of batch %s", len(metrics), batchID)
+	} else {
+		if batchID != "" {
+			a.batches[batchID] = now
+		}
+		for _, metric := range metrics {
+			a.addMetric(metric, now)
+		}
+		ContainerLogTelemetryMutex.Lock()
+		AggregatedTelegrafMetricCount += float64(len(metrics))
+		ContainerLogTelemetryMutex.Unlock()
+	}
+
+	var closed []*telegrafMetricAggregate
+	for key, aggregate := range a.aggregates {
+		if !aggregate.windowStart.Add(a.window).After(now) {
+			closed = append(closed, aggregate)
+			delete(a.aggregates, key)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].key < closed[j].key })
+	return closed
+}
+
+// addMetric adds the metric to the aggregate of its series in the window of its collection time, the metrics whose
+// collection time is invalid are aggregated in the window of now
+func (a *telegrafMetricAggregator) addMetric(metric *laTelegrafMetric, now time.Time) {
+	collectionTime, err := time.Parse(time.RFC3339, metric.CollectionTime)
+	if err != nil {
+		collectionTime = now
+	}
+	windowStart := collectionTime.Truncate(a.window)
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%d", metric.Namespace, metric.Name, metric.Tags, windowStart.Unix())
+	aggregate, ok := a.aggregates[key]
+	if !ok {
+		aggregate = &telegrafMetricAggregate{key: key, metric: *metric, windowStart: windowStart, min: metric.Value, max: metric.Value}
+		a.aggregates[key] = aggregate
+	}
+	if metric.Value < aggregate.min {
+		aggregate.min = metric.Value
+	}
+	if metric.Value > aggregate.max {
+		aggregate.max = metric.Value
+	}
+	aggregate.sum += metric.Value
+	aggregate.count++
+}
+
+// restore puts back the aggregates whose send failed, so they are sent with the metrics of the next chunk
+func (a *telegrafMetricAggregator) restore(aggregates []*telegrafMetricAggregate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, aggregate := range aggregates {
+		existing, ok := a.aggregates[aggregate.key]
+		if !ok {
+			a.aggregates[aggregate.key] = aggregate
+			continue
+		}
+		if aggregate.min < existing.min {
+			existing.min = aggregate.min
+		}
+		if aggregate.max > existing.max {
+			existing.max = aggregate.max
+		}
+		existing.sum += aggregate.sum
+		existing.count += aggregate.count
+	}
+}
+
+// toMetric returns the metric of the aggregate, collected at the start of the window. Its value is the average, and its
+// tags have the min, max and count of the metrics of the window
+func (aggregate *telegrafMetricAggregate) toMetric(window time.Duration) *laTelegrafMetric {
+	metric := aggregate.metric
+	metric.Value = aggregate.sum / float64(aggregate.count)
+	metric.CollectionTime = aggregate.windowStart.UTC().Format(time.RFC3339)
+	tagMap := make(map[string]string)
+	if err := json.Unmarshal([]byte(metric.Tags), &tagMap); err != nil {
+		// the tags were truncated, the metric is sent without the min, max and count
+		return &metric
+	}
+	tagMap[fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, telegrafTagAggregationWindow)] = strconv.Itoa(int(window.Seconds()))
+	tagMap[fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, telegrafTagAggregationMin)] = strconv.FormatFloat(aggregate.min, 'g', -1, 64)
+	tagMap[fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, telegrafTagAggregationMax)] = strconv.FormatFloat(aggregate.max, 'g', -1, 64)
+	tagMap[fmt.Sprintf("%s/%s", TelegrafMetricOriginPrefix, telegrafTagAggregationCount)] = strconv.Itoa(aggregate.count)
+	if tagJson, err := json.Marshal(&tagMap); err == nil {
+		metric.Tags = limitColumnLength(columnTags, string(tagJson))
+	}
+	return &metric
+}
+
+// aggregatedTelegrafMetrics returns the metrics of the aggregates
+func (a *telegrafMetricAggregator) aggregatedTelegrafMetrics(aggregates []*telegrafMetricAggregate) []*laTelegrafMetric {
+	var metrics []*laTelegrafMetric
+	for _, aggregate := range aggregates {
+		metrics = append(metrics, aggregate.toMetric(a.window))
+	}
+	return metrics
+}
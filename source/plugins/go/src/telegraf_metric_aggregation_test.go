@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_telegrafMetricAggregator(t *testing.T) {
+	type test_struct struct {
+		testName  string
+		batchID   string
+		values    []float64
+		at        string
+		wantCount int
+		wantValue float64
+		wantTags  string
+	}
+
+	// the chunks aggregated in order, their metrics collected at 10:00:10
+	tests := []test_struct{
+		{"window open", "batch1", []float64{1, 5}, "2021-06-01T10:00:30Z", 0, 0, ""},
+		{"retried chunk not aggregated again", "batch1", []float64{1, 5}, "2021-06-01T10:00:40Z", 0, 0, ""},
+		{"window closed", "batch2", []float64{3}, "2021-06-01T10:01:00Z", 1, 3, `{"container.azm.ms/aggregationCount":"3","container.azm.ms/aggregationMax":"5","container.azm.ms/aggregationMin":"1","container.azm.ms/aggregationWindowSeconds":"60","host":"node1"}`},
+		{"window drained", "batch3", nil, "2021-06-01T10:01:10Z", 0, 0, ""},
+	}
+
+	TelegrafMetricAggregator = newTelegrafMetricAggregator(time.Minute)
+	defer func() { TelegrafMetricAggregator = nil }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var metrics []*laTelegrafMetric
+			for _, value := range tt.values {
+				metrics = append(metrics, &laTelegrafMetric{Namespace: "container.azm.ms/disk", Name: "used", Value: value, Tags: `{"host":"node1"}`, CollectionTime: "2021-06-01T10:00:10Z"})
+			}
+			now, _ := time.Parse(time.RFC3339, tt.at)
+			aggregated := TelegrafMetricAggregator.aggregatedTelegrafMetrics(TelegrafMetricAggregator.add(tt.batchID, metrics, now))
+			if len(aggregated) != tt.wantCount {
+				t.Fatalf("add() = %d aggregates, want %d", len(aggregated), tt.wantCount)
+			}
+			for _, metric := range aggregated {
+				if metric.Value != tt.wantValue || metric.Tags != tt.wantTags || metric.CollectionTime != "2021-06-01T10:00:00Z" {
+					t.Errorf("add() = %+v, want value %v and tags %s", metric, tt.wantValue, tt.wantTags)
+				}
+			}
+		})
+	}
+}
+
+func Test_telegrafMetricAggregatorRestore(t *testing.T) {
+	aggregator := newTelegrafMetricAggregator(time.Minute)
+	now, _ := time.Parse(time.RFC3339, "2021-06-01T10:01:00Z")
+	metric := &laTelegrafMetric{Namespace: "container.azm.ms/disk", Name: "used", Value: 2, Tags: `{"host":"node1"}`, CollectionTime: "2021-06-01T10:00:10Z"}
+	closed := aggregator.add("batch1", []*laTelegrafMetric{metric}, now)
+	if len(closed) != 1 {
+		t.Fatalf("add() = %d aggregates, want 1", len(closed))
+	}
+	// the send of the aggregate failed, it is sent with the next chunk, merged with the late metrics of its window
+	aggregator.restore(closed)
+	metric.Value = 4
+	closed = aggregator.add("batch2", []*laTelegrafMetric{metric}, now.Add(time.Second))
+	if len(closed) != 1 || closed[0].count != 2 || closed[0].sum != 6 || closed[0].min != 2 || closed[0].max != 4 {
+		t.Errorf("add() after restore() = %+v, want the 2 metrics of the window", closed)
+	}
+}
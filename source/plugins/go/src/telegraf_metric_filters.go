@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// env variables of the filters of the telegraf metrics, set from the metric_collection_settings.telegraf_metric_filters
+// section of the configmap. The lists are separated by commas and their items are glob patterns, e.g. container.azm.ms/*
+const (
+	// the namespaces (telegraf measurements) of the metrics sent, all of them when empty
+	envTelegrafMetricsIncludedNamespaces = "AZMON_TELEGRAF_METRICS_INCLUDED_NAMESPACES"
+	envTelegrafMetricsExcludedNamespaces = "AZMON_TELEGRAF_METRICS_EXCLUDED_NAMESPACES"
+	// the names (telegraf fields) of the metrics sent, all of them when empty
+	envTelegrafMetricsIncludedNames = "AZMON_TELEGRAF_METRICS_INCLUDED_NAMES"
+	envTelegrafMetricsExcludedNames = "AZMON_TELEGRAF_METRICS_EXCLUDED_NAMES"
+	// the tags of the series sent as key=value, the value being a pattern. A series is sent when it has one of the
+	// included tags, and none of the excluded tags
+	envTelegrafMetricsIncludedTags = "AZMON_TELEGRAF_METRICS_INCLUDED_TAGS"
+	envTelegrafMetricsExcludedTags = "AZMON_TELEGRAF_METRICS_EXCLUDED_TAGS"
+)
+
+// telegrafTagPattern a tag pattern of the filters, the key is matched exactly
+type telegrafTagPattern struct {
+	key   string
+	value string
+}
+
+// telegrafMetricFilters the allow and deny lists of the telegraf metrics, the deny lists take precedence
+type telegrafMetricFilters struct {
+	includedNamespaces []string
+	excludedNamespaces []string
+	includedNames      []string
+	excludedNames      []string
+	includedTags       []telegrafTagPattern
+	excludedTags       []telegrafTagPattern
+}
+
+// TelegrafMetricFilters the filters of the telegraf metrics, nil when all the metrics are sent
+var TelegrafMetricFilters *telegrafMetricFilters
+
+// initializeTelegrafMetricFilters reads the filters of the telegraf metrics
+func initializeTelegrafMetricFilters() {
+	TelegrafMetricFilters = nil
+	filters := &telegrafMetricFilters{
+		includedNamespaces: parseTelegrafMetricPatterns(envTelegrafMetricsIncludedNamespaces),
+		excludedNamespaces: parseTelegrafMetricPatterns(envTelegrafMetricsExcludedNamespaces),
+		includedNames:      parseTelegrafMetricPatterns(envTelegrafMetricsIncludedNames),
+		excludedNames:      parseTelegrafMetricPatterns(envTelegrafMetricsExcludedNames),
+		includedTags:       parseTelegrafTagPatterns(envTelegrafMetricsIncludedTags),
+		excludedTags:       parseTelegrafTagPatterns(envTelegrafMetricsExcludedTags),
+	}
+	if len(filters.includedNamespaces)+len(filters.excludedNamespaces)+len(filters.includedNames)+len(filters.excludedNames)+len(filters.includedTags)+len(filters.excludedTags) == 0 {
+		return
+	}
+	TelegrafMetricFilters = filters
+	Log("Telegraf metric filters: namespaces %v excluding %v, names %v excluding %v, tags %v excluding %v", filters.includedNamespaces, filters.excludedNamespaces, filters.includedNames, filters.excludedNames, filters.includedTags, filters.excludedTags)
+}
+
+// parseTelegrafMetricPatterns reads the patterns of a list, skipping the invalid ones
+func parseTelegrafMetricPatterns(env string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(os.Getenv(env), ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			Log("Invalid pattern %s in %s, ignoring it", pattern, env)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// parseTelegrafTagPatterns reads the key=value patterns of a list, skipping the invalid ones
+func parseTelegrafTagPatterns(env string) []telegrafTagPattern {
+	var patterns []telegrafTagPattern
+	for _, item := range strings.Split(os.Getenv(env), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			Log("Invalid tag %s in %s, it must be key=value, ignoring it", item, env)
+			continue
+		}
+		pattern := telegrafTagPattern{key: strings.TrimSpace(parts[0]), value: strings.TrimSpace(parts[1])}
+		if _, err := path.Match(pattern.value, ""); err != nil {
+			Log("Invalid pattern %s in %s, ignoring it", item, env)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// matchesAnyPattern whether the value matches one of the patterns
+func matchesAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyTag whether one of the tags matches one of the patterns
+func matchesAnyTag(patterns []telegrafTagPattern, tags map[string]string) bool {
+	for _, pattern := range patterns {
+		if value, ok := tags[pattern.key]; ok {
+			if matched, _ := path.Match(pattern.value, value); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isIncluded whether the value passes the allow and the deny lists
+func isIncluded(included []string, excluded []string, value string) bool {
+	if matchesAnyPattern(excluded, value) {
+		return false
+	}
+	return len(included) == 0 || matchesAnyPattern(included, value)
+}
+
+// sendsSeries whether the series of the namespace with the tags are sent, before their fields are translated
+func (f *telegrafMetricFilters) sendsSeries(namespace string, tags map[string]string) bool {
+	if f == nil {
+		return true
+	}
+	if !isIncluded(f.includedNamespaces, f.excludedNamespaces, namespace) {
+		return false
+	}
+	if matchesAnyTag(f.excludedTags, tags) {
+		return false
+	}
+	return len(f.includedTags) == 0 || matchesAnyTag(f.includedTags, tags)
+}
+
+// sendsMetric whether the metric with the name is sent
+func (f *telegrafMetricFilters) sendsMetric(name string) bool {
+	if f == nil {
+		return true
+	}
+	return isIncluded(f.includedNames, f.excludedNames, name)
+}
+
+// countFilteredTelegrafMetrics counts the metrics not sent because of the filters
+func countFilteredTelegrafMetrics(count int) {
+	if count == 0 {
+		return
+	}
+	ContainerLogTelemetryMutex.Lock()
+	FilteredTelegrafMetricCount += float64(count)
+	ContainerLogTelemetryMutex.Unlock()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_telegrafMetricFilters(t *testing.T) {
+	type test_struct struct {
+		testName   string
+		env        map[string]string
+		namespace  string
+		tags       map[string]string
+		name       string
+		wantSeries bool
+		wantMetric bool
+	}
+
+	tags := map[string]string{"namespace": "kube-system", "pod_name": "coredns-1"}
+	tests := []test_struct{
+		{"no filters", map[string]string{}, "container.azm.ms/disk", tags, "used", true, true},
+		{"included namespace", map[string]string{envTelegrafMetricsIncludedNamespaces: "container.azm.ms/*, prometheus"}, "container.azm.ms/disk", tags, "used", true, true},
+		{"namespace not included", map[string]string{envTelegrafMetricsIncludedNamespaces: "prometheus"}, "container.azm.ms/disk", tags, "used", false, true},
+		{"excluded namespace takes precedence", map[string]string{envTelegrafMetricsIncludedNamespaces: "container.azm.ms/*", envTelegrafMetricsExcludedNamespaces: "container.azm.ms/disk"}, "container.azm.ms/disk", tags, "used", false, true},
+		{"excluded name", map[string]string{envTelegrafMetricsExcludedNames: "*_bucket"}, "prometheus", tags, "request_duration_bucket", true, false},
+		{"name not included", map[string]string{envTelegrafMetricsIncludedNames: "used,free"}, "prometheus", tags, "inodes", true, false},
+		{"excluded tag", map[string]string{envTelegrafMetricsExcludedTags: "pod_name=coredns-*"}, "prometheus", tags, "used", false, true},
+		{"included tag", map[string]string{envTelegrafMetricsIncludedTags: "namespace=kube-system"}, "prometheus", tags, "used", true, true},
+		{"tag not included", map[string]string{envTelegrafMetricsIncludedTags: "namespace=default"}, "prometheus", tags, "used", false, true},
+		{"invalid patterns ignored", map[string]string{envTelegrafMetricsIncludedNamespaces: "[", envTelegrafMetricsIncludedTags: "namespace"}, "prometheus", tags, "used", true, true},
+	}
+
+	envs := []string{envTelegrafMetricsIncludedNamespaces, envTelegrafMetricsExcludedNamespaces, envTelegrafMetricsIncludedNames, envTelegrafMetricsExcludedNames, envTelegrafMetricsIncludedTags, envTelegrafMetricsExcludedTags}
+	defer func() {
+		for _, env := range envs {
+			os.Unsetenv(env)
+		}
+		TelegrafMetricFilters = nil
+	}()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			for _, env := range envs {
+				os.Setenv(env, tt.env[env])
+			}
+			initializeTelegrafMetricFilters()
+			if got := TelegrafMetricFilters.sendsSeries(tt.namespace, tt.tags); got != tt.wantSeries {
+				t.Errorf("sendsSeries() = %v, want %v", got, tt.wantSeries)
+			}
+			if got := TelegrafMetricFilters.sendsMetric(tt.name); got != tt.wantMetric {
+				t.Errorf("sendsMetric() = %v, want %v", got, tt.wantMetric)
+			}
+		})
+	}
+}
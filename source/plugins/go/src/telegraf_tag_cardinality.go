@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagCardinalityEventCategory is the KubeMonAgentEvent category for the tags of the telegraf metrics over the limit of
+// distinct values
+const TagCardinalityEventCategory = "container.azm.ms/tagcardinality"
+
+// env variables of the limit of the distinct values of the tags of the telegraf metrics, set from the
+// metric_collection_settings.telegraf_tag_cardinality section of the configmap
+const (
+	// the max number of distinct values of a tag of a telegraf namespace in a window, no limit when not set or 0
+	envTelegrafMetricsMaxTagValues = "AZMON_TELEGRAF_METRICS_MAX_TAG_VALUES"
+	// what is done with the values of a tag over the limit: drop (the default) removes the tag, hash replaces the value
+	// with a short hash of it
+	envTelegrafMetricsTagOverflowAction = "AZMON_TELEGRAF_METRICS_TAG_OVERFLOW_ACTION"
+)
+
+const (
+	tagOverflowActionDrop = "drop"
+	tagOverflowActionHash = "hash"
+)
+
+// what is done with the values over the limit, for the logs and the events
+var tagOverflowActionDescriptions = map[string]string{tagOverflowActionDrop: "dropped", tagOverflowActionHash: "hashed"}
+
+// the distinct values of the tags are counted over an hour, so a tag whose values change slowly is not limited forever
+const telegrafTagCardinalityWindow = time.Hour
+
+// tagCardinalityOverflow the values of a tag over the limit since the last KubeMonAgentEvents flush
+type tagCardinalityOverflow struct {
+	count           int
+	firstOccurrence time.Time
+	lastOccurrence  time.Time
+}
+
+// telegrafTagCardinalityLimiter limits the distinct values of each tag of each telegraf namespace
+type telegrafTagCardinalityLimiter struct {
+	maxValues int
+	action    string
+
+	mu          sync.Mutex
+	windowStart time.Time
+	// the distinct values of the tags by namespace and tag, at most maxValues per tag
+	values map[string]map[string]map[string]bool
+	// the tags over the limit by namespace and tag
+	overflows map[string]map[string]*tagCardinalityOverflow
+}
+
+// TelegrafTagCardinality the limiter of the tags of the telegraf metrics, nil when they are not limited
+var TelegrafTagCardinality *telegrafTagCardinalityLimiter
+
+// initializeTelegrafTagCardinality reads the limit of the distinct values of the tags of the telegraf metrics
+func initializeTelegrafTagCardinality() {
+	TelegrafTagCardinality = nil
+	value := strings.TrimSpace(os.Getenv(envTelegrafMetricsMaxTagValues))
+	if value == "" {
+		return
+	}
+	maxValues, err := strconv.Atoi(value)
+	if err != nil || maxValues < 0 {
+		Log("Invalid value %s for %s, not limiting the tags of the telegraf metrics", value, envTelegrafMetricsMaxTagValues)
+		return
+	}
+	if maxValues == 0 {
+		return
+	}
+	action := strings.ToLower(strings.TrimSpace(os.Getenv(envTelegrafMetricsTagOverflowAction)))
+	if action == "" {
+		action = tagOverflowActionDrop
+	} else if action != tagOverflowActionDrop && action != tagOverflowActionHash {
+		Log("Invalid value %s for %s, using %s", action, envTelegrafMetricsTagOverflowAction, tagOverflowActionDrop)
+		action = tagOverflowActionDrop
+	}
+	TelegrafTagCardinality = newTelegrafTagCardinalityLimiter(maxValues, action)
+	Log("Limiting the tags of the telegraf metrics to %d distinct values, the values over it are %s", maxValues, tagOverflowActionDescriptions[action])
+}
+
+func newTelegrafTagCardinalityLimiter(maxValues int, action string) *telegrafTagCardinalityLimiter {
+	return &telegrafTagCardinalityLimiter{
+		maxValues: maxValues,
+		action:    action,
+		values:    make(map[string]map[string]map[string]bool),
+		overflows: make(map[string]map[string]*tagCardinalityOverflow),
+	}
+}
+
+// limit drops or hashes the values of the tags of the namespace over the limit, and returns the number of tags limited
+func (l *telegrafTagCardinalityLimiter) limit(namespace string, tags map[string]string, now time.Time) int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.windowStart) >= telegrafTagCardinalityWindow {
+		l.values = make(map[string]map[string]map[string]bool)
+		l.windowStart = now
+	}
+	namespaceValues, ok := l.values[namespace]
+	if !ok {
+		namespaceValues = make(map[string]map[string]bool)
+		l.values[namespace] = namespaceValues
+	}
+	limited := 0
+	for key, value := range tags {
+		tagValues, ok := namespaceValues[key]
+		if !ok {
+			tagValues = make(map[string]bool)
+			namespaceValues[key] = tagValues
+		}
+		if tagValues[value] {
+			continue
+		}
+		if len(tagValues) < l.maxValues {
+			tagValues[value] = true
+			continue
+		}
+		if l.action == tagOverflowActionHash {
+			tags[key] = hashTagValue(value)
+		} else {
+			delete(tags, key)
+		}
+		l.addOverflow(namespace, key, now)
+		limited++
+	}
+	if limited > 0 {
+		ContainerLogTelemetryMutex.Lock()
+		LimitedTelegrafTagCount += float64(limited)
+		ContainerLogTelemetryMutex.Unlock()
+	}
+	return limited
+}
+
+func (l *telegrafTagCardinalityLimiter) addOverflow(namespace string, key string, now time.Time) {
+	namespaceOverflows, ok := l.overflows[namespace]
+	if !ok {
+		namespaceOverflows = make(map[string]*tagCardinalityOverflow)
+		l.overflows[namespace] = namespaceOverflows
+	}
+	overflow, ok := namespaceOverflows[key]
+	if !ok {
+		overflow = &tagCardinalityOverflow{firstOccurrence: now}
+		namespaceOverflows[key] = overflow
+	}
+	overflow.count++
+	overflow.lastOccurrence = now
+}
+
+// hashTagValue returns the short hash of a tag value over the limit
+func hashTagValue(value string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(value))
+	return fmt.Sprintf("hash-%08x", hash.Sum32())
+}
+
+// takeTagCardinalityEvents returns the events of the tags over the limit since the last call, identifying the
+// telegraf namespace and the tag
+func (l *telegrafTagCardinalityLimiter) takeTagCardinalityEvents() map[string]KubeMonAgentEventTags {
+	events := make(map[string]KubeMonAgentEventTags)
+	if l == nil {
+		return events
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for namespace, namespaceOverflows := range l.overflows {
+		for key, overflow := range namespaceOverflows {
+			message := fmt.Sprintf("The tag %s of the telegraf metrics %s has more than %d distinct values, the values over it were %s", key, namespace, l.maxValues, tagOverflowActionDescriptions[l.action])
+			events[message] = KubeMonAgentEventTags{
+				FirstOccurrence: overflow.firstOccurrence.UTC().Format(time.RFC3339),
+				LastOccurrence:  overflow.lastOccurrence.UTC().Format(time.RFC3339),
+				Count:           overflow.count,
+				MetricNamespace: namespace,
+				TagKey:          key,
+			}
+		}
+	}
+	l.overflows = make(map[string]map[string]*tagCardinalityOverflow)
+	return events
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_telegrafTagCardinalityLimiter(t *testing.T) {
+	type test_struct struct {
+		testName    string
+		action      string
+		namespace   string
+		tags        map[string]string
+		at          time.Duration
+		wantTags    map[string]string
+		wantLimited int
+	}
+
+	// the series limited in order by a limiter of 2 values per tag
+	tests := []test_struct{
+		{"first value", tagOverflowActionDrop, "prometheus", map[string]string{"path": "/a", "host": "node1"}, 0, map[string]string{"path": "/a", "host": "node1"}, 0},
+		{"second value", tagOverflowActionDrop, "prometheus", map[string]string{"path": "/b", "host": "node1"}, 0, map[string]string{"path": "/b", "host": "node1"}, 0},
+		{"value over the limit dropped", tagOverflowActionDrop, "prometheus", map[string]string{"path": "/c", "host": "node1"}, 0, map[string]string{"host": "node1"}, 1},
+		{"known value", tagOverflowActionDrop, "prometheus", map[string]string{"path": "/a", "host": "node1"}, 0, map[string]string{"path": "/a", "host": "node1"}, 0},
+		{"other namespace", tagOverflowActionDrop, "container.azm.ms/disk", map[string]string{"path": "/c"}, 0, map[string]string{"path": "/c"}, 0},
+		{"value over the limit hashed", tagOverflowActionHash, "prometheus", map[string]string{"path": "/d"}, 0, map[string]string{"path": hashTagValue("/d")}, 1},
+		{"next window", tagOverflowActionDrop, "prometheus", map[string]string{"path": "/e"}, telegrafTagCardinalityWindow, map[string]string{"path": "/e"}, 0},
+	}
+
+	limiter := newTelegrafTagCardinalityLimiter(2, tagOverflowActionDrop)
+	start := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			limiter.action = tt.action
+			if got := limiter.limit(tt.namespace, tt.tags, start.Add(tt.at)); got != tt.wantLimited || !reflect.DeepEqual(tt.tags, tt.wantTags) {
+				t.Errorf("limit() = %d with tags %v, want %d with tags %v", got, tt.tags, tt.wantLimited, tt.wantTags)
+			}
+		})
+	}
+
+	events := limiter.takeTagCardinalityEvents()
+	if len(events) != 1 {
+		t.Fatalf("takeTagCardinalityEvents() = %v, want 1 event", events)
+	}
+	for _, tags := range events {
+		if tags.MetricNamespace != "prometheus" || tags.TagKey != "path" || tags.Count != 2 {
+			t.Errorf("takeTagCardinalityEvents() = %+v, want 2 values of the tag path of prometheus", tags)
+		}
+	}
+	if events := limiter.takeTagCardinalityEvents(); len(events) != 0 {
+		t.Errorf("takeTagCardinalityEvents() = %v after the events were taken, want none", events)
+	}
+}
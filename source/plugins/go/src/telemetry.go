@@ -38,18 +38,98 @@ var (
 	TelegrafMetricsSendErrorCount float64
 	//Tracks the number of 429 (throttle) errors between telemetry ticker periods (uses ContainerLogTelemetryTicker)
 	TelegrafMetricsSend429ErrorCount float64
-	//Tracks the number of write/send errors to mdsd for containerlogs (uses ContainerLogTelemetryTicker)
-	ContainerLogsSendErrorsToMDSDFromFluent float64
 	//Tracks the number of mdsd client create errors for containerlogs (uses ContainerLogTelemetryTicker)
 	ContainerLogsMDSDClientCreateErrors float64
 	//Tracks the number of mdsd client create errors for insightsmetrics (uses ContainerLogTelemetryTicker)
 	InsightsMetricsMDSDClientCreateErrors float64
 	//Tracks the number of mdsd client create errors for kubemonevents (uses ContainerLogTelemetryTicker)
 	KubeMonEventsMDSDClientCreateErrors float64
-	//Tracks the number of write/send errors to ADX for containerlogs (uses ContainerLogTelemetryTicker)
-	ContainerLogsSendErrorsToADXFromFluent float64
 	//Tracks the number of ADX client create errors for containerlogs (uses ContainerLogTelemetryTicker)
 	ContainerLogsADXClientCreateErrors float64
+	//Tracks the number of container log tailing failures on windows (uses ContainerLogTelemetryTicker)
+	LogCollectionTailFailureCount float64
+	//Tracks the number of locked container log files on windows (uses ContainerLogTelemetryTicker)
+	LogCollectionLockedFileCount float64
+	//Tracks the number of container log encoding errors on windows (uses ContainerLogTelemetryTicker)
+	LogCollectionEncodingErrorCount float64
+	//Tracks the number of problem container log files which became readable again on windows (uses ContainerLogTelemetryTicker)
+	LogCollectionFileRecoveredCount float64
+	//Tracks the number of container log flushes held back or retried to stay within the configured send rate (uses ContainerLogTelemetryTicker)
+	CatchUpThrottledFlushCount float64
+	//Tracks the state of the mdsd container log connection, 1 when connected (gauge, uses ContainerLogTelemetryTicker)
+	MdsdConnectionState float64
+	//Tracks the number of mdsd container log reconnects done by the health probe (uses ContainerLogTelemetryTicker)
+	MdsdProbeReconnectCount float64
+	//Tracks the time the writes to mdsd waited for the in-flight bytes under the limit (uses ContainerLogTelemetryTicker)
+	MdsdInflightWaitMs float64
+	//Tracks the records of the retried batches not written again to mdsd since their chunk was written (uses ContainerLogTelemetryTicker)
+	MdsdRetrySkippedRecordCount float64
+	//Tracks the number of flushes which waited for a refresh of the container metadata holding its lock (uses ContainerLogTelemetryTicker)
+	ContainerMetadataLockContentionCount float64
+	//Tracks the time flushes waited for a refresh of the container metadata holding its lock (uses ContainerLogTelemetryTicker)
+	ContainerMetadataLockWaitMs float64
+	//Tracks the number of containers started after the last refresh whose metadata was looked up by a flush (uses ContainerLogTelemetryTicker)
+	ContainerMetadataLookupCount float64
+	//Tracks the number of lookups of the containers missing from the metadata maps which found no metadata (uses ContainerLogTelemetryTicker)
+	ContainerMetadataLookupMissCount float64
+	//Tracks the number of container log records dropped since the workspace region violates the region policy (uses ContainerLogTelemetryTicker)
+	DataResidencyDroppedRecordCount float64
+	//Tracks the number of flushes cancelled by the watchdog after the flush deadline (uses ContainerLogTelemetryTicker)
+	StuckFlushCount float64
+	//Tracks the time flushes waited for a free in-flight flush slot (uses ContainerLogTelemetryTicker)
+	FlushQueueWaitMs float64
+	//Tracks the records sampled out or paused while a route is over its retry budget (uses ContainerLogTelemetryTicker)
+	RetryBudgetDroppedRecordCount float64
+	//Tracks the records written to the dead-letter directory while a route is over its retry budget (uses ContainerLogTelemetryTicker)
+	RetryBudgetDeadLetteredRecordCount float64
+	//Tracks the container log chunks in retry, when the retry budget is enabled (gauge, uses ContainerLogTelemetryTicker)
+	ContainerLogsChunksInRetry float64
+	//Tracks the chunks skipped since the checkpoint shows they were already flushed before a restart (uses ContainerLogTelemetryTicker)
+	CheckpointDeduplicatedChunkCount float64
+	//Tracks the number of container log records of the batches sorted by timestamp
+	ContainerLogsSortedRecordCount float64
+	//Tracks the number of container log records out of timestamp order in their batch
+	ContainerLogsOutOfOrderRecordCount float64
+	//Tracks the chunks re-delivered by fluent-bit and skipped since they were flushed recently (uses ContainerLogTelemetryTicker)
+	DuplicateChunkCount float64
+	//Tracks the number of container log records violating the schema of their backend (uses ContainerLogTelemetryTicker)
+	ContainerLogsSchemaViolationCount float64
+	//Tracks the number of container log records with a column over the log analytics limit (uses ContainerLogTelemetryTicker)
+	ContainerLogsLAFieldSizeViolationCount float64
+	//Tracks the number of container log records with too many columns or invalid column names (uses ContainerLogTelemetryTicker)
+	ContainerLogsLAColumnViolationCount float64
+	//Tracks the bytes of the gzip compressed ODS payloads before compression (uses ContainerLogTelemetryTicker)
+	ODSRawPayloadBytes float64
+	//Tracks the bytes of the gzip compressed ODS payloads after compression (uses ContainerLogTelemetryTicker)
+	ODSCompressedPayloadBytes float64
+	//Tracks the number of records dropped since their route failed for longer than the max retry duration (uses ContainerLogTelemetryTicker)
+	RetryExpiredRecords float64
+	//Tracks the bytes of the container log chunks spooled to the disk (uses ContainerLogTelemetryTicker)
+	SpoolDepthBytes float64
+	//Tracks the number of container log chunks spooled to the disk (uses ContainerLogTelemetryTicker)
+	SpooledChunkCount float64
+	//Tracks the number of spooled container log chunks replayed (uses ContainerLogTelemetryTicker)
+	SpoolReplayedChunkCount float64
+	//Tracks the number of sub-batches sent by the flush workers (uses ContainerLogTelemetryTicker)
+	FlushWorkerSubBatchCount float64
+	//Tracks the number of times the flush workers sent a failed sub-batch again (uses ContainerLogTelemetryTicker)
+	FlushWorkerRetryCount float64
+	//Tracks the number of ODS payloads split since they were over the max payload size (uses ContainerLogTelemetryTicker)
+	ODSPayloadSplitCount float64
+	//Tracks the number of container log records the tee route failed to send between telemetry ticker periods (uses ContainerLogTelemetryTicker)
+	TeeRouteDroppedRecordCount float64
+	//Tracks the number of the events that failed to be published to Event Hubs
+	EventHubsPublishFailedEventCount float64
+	//Tracks the number of the payloads of retried chunks not sent again since they were sent
+	SentPayloadSkipCount float64
+	//Tracks the number of telegraf metrics not sent because of the metric filters
+	FilteredTelegrafMetricCount float64
+	//Tracks the number of telegraf metrics aggregated in windows
+	AggregatedTelegrafMetricCount float64
+	//Tracks the number of telegraf metrics not published as custom metrics
+	MDMPublishFailedMetricCount float64
+	//Tracks the number of telegraf metric tags dropped or hashed over the limit of distinct values
+	LimitedTelegrafTagCount float64
 	//Tracks the number of OSM namespaces and sent only from prometheus sidecar (uses ContainerLogTelemetryTicker)
 	OSMNamespaceCount int
 	//Tracks whether monitor kubernetes pods is set to true and sent only from prometheus sidecar (uses ContainerLogTelemetryTicker)
@@ -82,6 +162,59 @@ const (
 	metricNameErrorCountKubeMonEventsMDSDClientCreateError      = "KubeMonEventsMDSDClientCreateErrorsCount"
 	metricNameErrorCountContainerLogsSendErrorsToADXFromFluent  = "ContainerLogs2ADXSendErrorCount"
 	metricNameErrorCountContainerLogsADXClientCreateError       = "ContainerLogsADXClientCreateErrorCount"
+	metricNameErrorCountLogCollectionTailFailure                = "WindowsLogCollectionTailFailureCount"
+	metricNameErrorCountLogCollectionLockedFile                 = "WindowsLogCollectionLockedFileCount"
+	metricNameErrorCountLogCollectionEncodingError              = "WindowsLogCollectionEncodingErrorCount"
+	metricNameLogCollectionFileRecovered                        = "WindowsLogCollectionFileRecoveredCount"
+	metricNameCatchUpThrottledFlushCount                        = "ContainerLogsCatchUpThrottledFlushCount"
+	metricNameMdsdConnectionState                               = "ContainerLogsMdsdConnectionState"
+	metricNameMdsdProbeReconnectCount                           = "ContainerLogsMdsdProbeReconnectCount"
+	metricNameMdsdInflightWaitMs                                = "ContainerLogsMdsdInflightWaitMs"
+	metricNameMdsdRetrySkippedRecordCount                       = "ContainerLogsMdsdRetrySkippedRecordCount"
+	metricNameContainerMetadataLockContentionCount              = "ContainerLogsMetadataLockContentionCount"
+	metricNameContainerMetadataLockWaitMs                       = "ContainerLogsMetadataLockWaitMs"
+	metricNameContainerMetadataLookupCount                      = "ContainerLogsMetadataLookupCount"
+	metricNameContainerMetadataLookupMissCount                  = "ContainerLogsMetadataLookupMissCount"
+	metricNameDataResidencyDroppedRecordCount                   = "ContainerLogsDataResidencyDroppedRecordCount"
+	metricNameStuckFlushCount                                   = "ContainerLogsStuckFlushCount"
+	metricNameFlushQueueWaitMs                                  = "ContainerLogsFlushQueueWaitMs"
+	metricNameRetryBudgetDroppedRecordCount                     = "ContainerLogsRetryBudgetDroppedRecordCount"
+	metricNameRetryBudgetDeadLetteredRecordCount                = "ContainerLogsRetryBudgetDeadLetteredRecordCount"
+	metricNameContainerLogsChunksInRetry                        = "ContainerLogsChunksInRetry"
+	metricNameCheckpointDeduplicatedChunkCount                  = "ContainerLogsCheckpointDeduplicatedChunkCount"
+	metricNameContainerLogsSortedRecordCount                    = "ContainerLogsSortedRecordCount"
+	metricNameContainerLogsOutOfOrderRecordCount                = "ContainerLogsOutOfOrderRecordCount"
+	metricNameContainerLogsOutOfOrderPercent                    = "ContainerLogsOutOfOrderPercent"
+	metricNameDuplicateChunkCount                               = "ContainerLogsDuplicateChunkCount"
+	metricNameColumnTruncationCount                             = "ColumnTruncationCount"
+	metricNameSinkSendAttemptCount                              = "ContainerLogsSinkSendAttemptCount"
+	metricNameSinkSendSuccessCount                              = "ContainerLogsSinkSendSuccessCount"
+	metricNameSinkSendFailureCount                              = "ContainerLogsSinkSendFailureCount"
+	metricNameSinkSentRecordCount                               = "ContainerLogsSinkSentRecordCount"
+	metricNameSinkSentBytes                                     = "ContainerLogsSinkSentBytes"
+	metricNameSinkSendAvgLatencyMs                              = "ContainerLogsSinkSendAvgLatencyMs"
+	metricNameRouteProbeLatencyMs                               = "ContainerLogsRouteProbeLatencyMs"
+	metricNameContainerLogsSchemaViolationCount                 = "ContainerLogsSchemaViolationCount"
+	metricNameContainerLogsLAFieldSizeViolationCount            = "ContainerLogsLAFieldSizeViolationCount"
+	metricNameContainerLogsLAColumnViolationCount               = "ContainerLogsLAColumnViolationCount"
+	metricNameODSRawPayloadBytes                                = "ODSRawPayloadBytes"
+	metricNameODSCompressedPayloadBytes                         = "ODSCompressedPayloadBytes"
+	metricNameRetryExpiredRecords                               = "RetryExpiredRecords"
+	metricNameSpoolDepthBytes                                   = "SpoolDepthBytes"
+	metricNameSpooledChunkCount                                 = "SpooledChunkCount"
+	metricNameSpoolReplayedChunkCount                           = "SpoolReplayedChunkCount"
+	metricNameLogDropRuleDroppedCount                           = "ContainerLogsDroppedByRuleCount"
+	metricNameLogRedactionCount                                 = "ContainerLogsRedactedCount"
+	metricNameFlushWorkerSubBatchCount                          = "ContainerLogsFlushWorkerSubBatchCount"
+	metricNameFlushWorkerRetryCount                             = "ContainerLogsFlushWorkerRetryCount"
+	metricNameODSPayloadSplitCount                              = "ODSPayloadSplitCount"
+	metricNameTeeRouteDroppedRecordCount                        = "ContainerLogsTeeRouteDroppedRecordCount"
+	metricNameEventHubsPublishFailedEventCount                  = "EventHubsPublishFailedEventCount"
+	metricNameSentPayloadSkipCount                              = "RetriedChunkSentPayloadSkipCount"
+	metricNameFilteredTelegrafMetricCount                       = "FilteredTelegrafMetricCount"
+	metricNameAggregatedTelegrafMetricCount                     = "AggregatedTelegrafMetricCount"
+	metricNameMDMPublishFailedMetricCount                       = "MDMPublishFailedMetricCount"
+	metricNameLimitedTelegrafTagCount                           = "LimitedTelegrafTagCount"
 
 	defaultTelemetryPushIntervalSeconds = 300
 
@@ -114,12 +247,53 @@ func SendContainerLogPluginMetrics(telemetryPushIntervalProperty string) {
 		telegrafMetricsSentCount := TelegrafMetricsSentCount
 		telegrafMetricsSendErrorCount := TelegrafMetricsSendErrorCount
 		telegrafMetricsSend429ErrorCount := TelegrafMetricsSend429ErrorCount
-		containerLogsSendErrorsToMDSDFromFluent := ContainerLogsSendErrorsToMDSDFromFluent
 		containerLogsMDSDClientCreateErrors := ContainerLogsMDSDClientCreateErrors
-		containerLogsSendErrorsToADXFromFluent := ContainerLogsSendErrorsToADXFromFluent
 		containerLogsADXClientCreateErrors := ContainerLogsADXClientCreateErrors
 		insightsMetricsMDSDClientCreateErrors := InsightsMetricsMDSDClientCreateErrors
 		kubeMonEventsMDSDClientCreateErrors := KubeMonEventsMDSDClientCreateErrors
+		logCollectionTailFailureCount := LogCollectionTailFailureCount
+		logCollectionLockedFileCount := LogCollectionLockedFileCount
+		logCollectionEncodingErrorCount := LogCollectionEncodingErrorCount
+		logCollectionFileRecoveredCount := LogCollectionFileRecoveredCount
+		catchUpThrottledFlushCount := CatchUpThrottledFlushCount
+		mdsdConnectionState := MdsdConnectionState
+		mdsdProbeReconnectCount := MdsdProbeReconnectCount
+		mdsdInflightWaitMs := MdsdInflightWaitMs
+		mdsdRetrySkippedRecordCount := MdsdRetrySkippedRecordCount
+		containerMetadataLockContentionCount := ContainerMetadataLockContentionCount
+		containerMetadataLockWaitMs := ContainerMetadataLockWaitMs
+		containerMetadataLookupCount := ContainerMetadataLookupCount
+		containerMetadataLookupMissCount := ContainerMetadataLookupMissCount
+		dataResidencyDroppedRecordCount := DataResidencyDroppedRecordCount
+		stuckFlushCount := StuckFlushCount
+		flushQueueWaitMs := FlushQueueWaitMs
+		retryBudgetDroppedRecordCount := RetryBudgetDroppedRecordCount
+		retryBudgetDeadLetteredRecordCount := RetryBudgetDeadLetteredRecordCount
+		containerLogsChunksInRetry := ContainerLogsChunksInRetry
+		checkpointDeduplicatedChunkCount := CheckpointDeduplicatedChunkCount
+		containerLogsSortedRecordCount := ContainerLogsSortedRecordCount
+		containerLogsOutOfOrderRecordCount := ContainerLogsOutOfOrderRecordCount
+		duplicateChunkCount := DuplicateChunkCount
+		columnTruncationCount := ColumnTruncationCount
+		containerLogsSchemaViolationCount := ContainerLogsSchemaViolationCount
+		containerLogsLAFieldSizeViolationCount := ContainerLogsLAFieldSizeViolationCount
+		containerLogsLAColumnViolationCount := ContainerLogsLAColumnViolationCount
+		odsRawPayloadBytes := ODSRawPayloadBytes
+		odsCompressedPayloadBytes := ODSCompressedPayloadBytes
+		retryExpiredRecords := RetryExpiredRecords
+		spoolDepthBytes := SpoolDepthBytes
+		spooledChunkCount := SpooledChunkCount
+		spoolReplayedChunkCount := SpoolReplayedChunkCount
+		flushWorkerSubBatchCount := FlushWorkerSubBatchCount
+		flushWorkerRetryCount := FlushWorkerRetryCount
+		odsPayloadSplitCount := ODSPayloadSplitCount
+		teeRouteDroppedRecordCount := TeeRouteDroppedRecordCount
+		eventHubsPublishFailedEventCount := EventHubsPublishFailedEventCount
+		sentPayloadSkipCount := SentPayloadSkipCount
+		filteredTelegrafMetricCount := FilteredTelegrafMetricCount
+		aggregatedTelegrafMetricCount := AggregatedTelegrafMetricCount
+		mDMPublishFailedMetricCount := MDMPublishFailedMetricCount
+		limitedTelegrafTagCount := LimitedTelegrafTagCount
 		osmNamespaceCount := OSMNamespaceCount
 		promMonitorPods := PromMonitorPods
 		promMonitorPodsNamespaceLength := PromMonitorPodsNamespaceLength
@@ -136,14 +310,60 @@ func SendContainerLogPluginMetrics(telemetryPushIntervalProperty string) {
 		logLatencyMsContainer := AgentLogProcessingMaxLatencyMsContainer
 		AgentLogProcessingMaxLatencyMs = 0
 		AgentLogProcessingMaxLatencyMsContainer = ""
-		ContainerLogsSendErrorsToMDSDFromFluent = 0.0
 		ContainerLogsMDSDClientCreateErrors = 0.0
-		ContainerLogsSendErrorsToADXFromFluent = 0.0
 		ContainerLogsADXClientCreateErrors = 0.0
 		InsightsMetricsMDSDClientCreateErrors = 0.0
 		KubeMonEventsMDSDClientCreateErrors = 0.0
+		LogCollectionTailFailureCount = 0.0
+		LogCollectionLockedFileCount = 0.0
+		LogCollectionEncodingErrorCount = 0.0
+		LogCollectionFileRecoveredCount = 0.0
+		CatchUpThrottledFlushCount = 0.0
+		MdsdProbeReconnectCount = 0.0
+		MdsdInflightWaitMs = 0.0
+		MdsdRetrySkippedRecordCount = 0.0
+		ContainerMetadataLockContentionCount = 0.0
+		ContainerMetadataLockWaitMs = 0.0
+		ContainerMetadataLookupCount = 0.0
+		ContainerMetadataLookupMissCount = 0.0
+		DataResidencyDroppedRecordCount = 0.0
+		StuckFlushCount = 0.0
+		FlushQueueWaitMs = 0.0
+		RetryBudgetDroppedRecordCount = 0.0
+		RetryBudgetDeadLetteredRecordCount = 0.0
+		CheckpointDeduplicatedChunkCount = 0.0
+		ContainerLogsSortedRecordCount = 0.0
+		ContainerLogsOutOfOrderRecordCount = 0.0
+		DuplicateChunkCount = 0.0
+		ColumnTruncationCount = make(map[string]float64)
+		ContainerLogsSchemaViolationCount = 0.0
+		ContainerLogsLAFieldSizeViolationCount = 0.0
+		ContainerLogsLAColumnViolationCount = 0.0
+		ODSRawPayloadBytes = 0.0
+		ODSCompressedPayloadBytes = 0.0
+		RetryExpiredRecords = 0.0
+		SpooledChunkCount = 0.0
+		SpoolReplayedChunkCount = 0.0
+		FlushWorkerSubBatchCount = 0.0
+		FlushWorkerRetryCount = 0.0
+		ODSPayloadSplitCount = 0.0
+		TeeRouteDroppedRecordCount = 0.0
+		EventHubsPublishFailedEventCount = 0.0
+		SentPayloadSkipCount = 0.0
+		FilteredTelegrafMetricCount = 0.0
+		AggregatedTelegrafMetricCount = 0.0
+		MDMPublishFailedMetricCount = 0.0
+		LimitedTelegrafTagCount = 0.0
 		ContainerLogTelemetryMutex.Unlock()
 
+		// the send errors of the container log routes come from the counters of their sinks
+		sinkFailures := sendSinkTelemetry()
+		sendLogDropRuleTelemetry()
+		sendLogRedactionTelemetry()
+		sendThrottleTelemetry()
+		containerLogsSendErrorsToMDSDFromFluent := sinkFailures[sinkTypeMdsd]
+		containerLogsSendErrorsToADXFromFluent := sinkFailures[sinkTypeADX]
+
 		if strings.Compare(strings.ToLower(os.Getenv("CONTROLLER_TYPE")), "daemonset") == 0 {
 			telemetryDimensions := make(map[string]string)
 			if strings.Compare(strings.ToLower(os.Getenv("CONTAINER_TYPE")), "prometheussidecar") == 0 {
@@ -184,6 +404,7 @@ func SendContainerLogPluginMetrics(telemetryPushIntervalProperty string) {
 				if fbitTailMemBufLimitMBs != "" {
 					telemetryDimensions["FbitMemBufLimitSizeMBs"] = fbitTailMemBufLimitMBs
 				}
+				addBacklogDimensions(telemetryDimensions)
 				SendEvent(eventNameDaemonSetHeartbeat, telemetryDimensions)
 				flushRateMetric := appinsights.NewMetricTelemetry(metricNameAvgFlushRate, flushRate)
 				TelemetryClient.Track(flushRateMetric)
@@ -194,6 +415,7 @@ func SendContainerLogPluginMetrics(telemetryPushIntervalProperty string) {
 				TelemetryClient.Track(logSizeMetric)
 				logLatencyMetric := appinsights.NewMetricTelemetry(metricNameAgentLogProcessingMaxLatencyMs, logLatencyMs)
 				logLatencyMetric.Properties["Container"] = logLatencyMsContainer
+				scrubTelemetryProperties(logLatencyMetric.Properties)
 				TelemetryClient.Track(logLatencyMetric)
 			}
 		}
@@ -222,6 +444,136 @@ func SendContainerLogPluginMetrics(telemetryPushIntervalProperty string) {
 		if kubeMonEventsMDSDClientCreateErrors > 0.0 {
 			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameErrorCountKubeMonEventsMDSDClientCreateError, kubeMonEventsMDSDClientCreateErrors))
 		}
+		if logCollectionTailFailureCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameErrorCountLogCollectionTailFailure, logCollectionTailFailureCount))
+		}
+		if logCollectionLockedFileCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameErrorCountLogCollectionLockedFile, logCollectionLockedFileCount))
+		}
+		if logCollectionEncodingErrorCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameErrorCountLogCollectionEncodingError, logCollectionEncodingErrorCount))
+		}
+		if logCollectionFileRecoveredCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameLogCollectionFileRecovered, logCollectionFileRecoveredCount))
+		}
+		if catchUpThrottledFlushCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameCatchUpThrottledFlushCount, catchUpThrottledFlushCount))
+		}
+		if MdsdHealthProbeTicker != nil {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameMdsdConnectionState, mdsdConnectionState))
+		}
+		if mdsdProbeReconnectCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameMdsdProbeReconnectCount, mdsdProbeReconnectCount))
+		}
+		if mdsdInflightWaitMs > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameMdsdInflightWaitMs, mdsdInflightWaitMs))
+		}
+		if mdsdRetrySkippedRecordCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameMdsdRetrySkippedRecordCount, mdsdRetrySkippedRecordCount))
+		}
+		if containerMetadataLockContentionCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerMetadataLockContentionCount, containerMetadataLockContentionCount))
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerMetadataLockWaitMs, containerMetadataLockWaitMs))
+		}
+		if containerMetadataLookupCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerMetadataLookupCount, containerMetadataLookupCount))
+		}
+		if containerMetadataLookupMissCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerMetadataLookupMissCount, containerMetadataLookupMissCount))
+		}
+		if dataResidencyDroppedRecordCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameDataResidencyDroppedRecordCount, dataResidencyDroppedRecordCount))
+		}
+		if stuckFlushCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameStuckFlushCount, stuckFlushCount))
+		}
+		if flushQueueWaitMs > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameFlushQueueWaitMs, flushQueueWaitMs))
+		}
+		if retryBudgetDroppedRecordCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameRetryBudgetDroppedRecordCount, retryBudgetDroppedRecordCount))
+		}
+		if retryBudgetDeadLetteredRecordCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameRetryBudgetDeadLetteredRecordCount, retryBudgetDeadLetteredRecordCount))
+		}
+		if RetryBudgetChunks > 0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerLogsChunksInRetry, containerLogsChunksInRetry))
+		}
+		if checkpointDeduplicatedChunkCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameCheckpointDeduplicatedChunkCount, checkpointDeduplicatedChunkCount))
+		}
+		if containerLogsSortedRecordCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerLogsSortedRecordCount, containerLogsSortedRecordCount))
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerLogsOutOfOrderPercent, 100*containerLogsOutOfOrderRecordCount/containerLogsSortedRecordCount))
+		}
+		if containerLogsOutOfOrderRecordCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerLogsOutOfOrderRecordCount, containerLogsOutOfOrderRecordCount))
+		}
+		if duplicateChunkCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameDuplicateChunkCount, duplicateChunkCount))
+		}
+		for column, count := range columnTruncationCount {
+			columnTruncationMetric := appinsights.NewMetricTelemetry(metricNameColumnTruncationCount, count)
+			columnTruncationMetric.Properties["Column"] = column
+			TelemetryClient.Track(columnTruncationMetric)
+		}
+		if containerLogsSchemaViolationCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerLogsSchemaViolationCount, containerLogsSchemaViolationCount))
+		}
+		if containerLogsLAFieldSizeViolationCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerLogsLAFieldSizeViolationCount, containerLogsLAFieldSizeViolationCount))
+		}
+		if containerLogsLAColumnViolationCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameContainerLogsLAColumnViolationCount, containerLogsLAColumnViolationCount))
+		}
+		if odsRawPayloadBytes > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameODSRawPayloadBytes, odsRawPayloadBytes))
+		}
+		if odsCompressedPayloadBytes > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameODSCompressedPayloadBytes, odsCompressedPayloadBytes))
+		}
+		if retryExpiredRecords > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameRetryExpiredRecords, retryExpiredRecords))
+		}
+		if spoolDepthBytes > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameSpoolDepthBytes, spoolDepthBytes))
+		}
+		if spooledChunkCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameSpooledChunkCount, spooledChunkCount))
+		}
+		if spoolReplayedChunkCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameSpoolReplayedChunkCount, spoolReplayedChunkCount))
+		}
+		if flushWorkerSubBatchCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameFlushWorkerSubBatchCount, flushWorkerSubBatchCount))
+		}
+		if flushWorkerRetryCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameFlushWorkerRetryCount, flushWorkerRetryCount))
+		}
+		if odsPayloadSplitCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameODSPayloadSplitCount, odsPayloadSplitCount))
+		}
+		if teeRouteDroppedRecordCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameTeeRouteDroppedRecordCount, teeRouteDroppedRecordCount))
+		}
+		if eventHubsPublishFailedEventCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameEventHubsPublishFailedEventCount, eventHubsPublishFailedEventCount))
+		}
+		if sentPayloadSkipCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameSentPayloadSkipCount, sentPayloadSkipCount))
+		}
+		if filteredTelegrafMetricCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameFilteredTelegrafMetricCount, filteredTelegrafMetricCount))
+		}
+		if aggregatedTelegrafMetricCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameAggregatedTelegrafMetricCount, aggregatedTelegrafMetricCount))
+		}
+		if mDMPublishFailedMetricCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameMDMPublishFailedMetricCount, mDMPublishFailedMetricCount))
+		}
+		if limitedTelegrafTagCount > 0.0 {
+			TelemetryClient.Track(appinsights.NewMetricTelemetry(metricNameLimitedTelegrafTagCount, limitedTelegrafTagCount))
+		}
 
 		start = time.Now()
 	}
@@ -236,6 +588,7 @@ func SendEvent(eventName string, dimensions map[string]string) {
 	for k, v := range dimensions {
 		event.Properties[k] = v
 	}
+	scrubTelemetryProperties(event.Properties)
 
 	TelemetryClient.Track(event)
 }
@@ -243,6 +596,14 @@ func SendEvent(eventName string, dimensions map[string]string) {
 // SendException  send an event to the configured app insights instance
 func SendException(err interface{}) {
 	if TelemetryClient != nil {
+		if len(TelemetryScrubRules) > 0 {
+			switch e := err.(type) {
+			case string:
+				err = scrubTelemetryMessage(e)
+			case error:
+				err = scrubTelemetryMessage(e.Error())
+			}
+		}
 		TelemetryClient.TrackException(err)
 	}
 }
@@ -341,6 +702,23 @@ func InitializeTelemetryClient(agentVersion string) (int, error) {
 		}
 	}
 
+	scrubbingConfig := strings.TrimSpace(os.Getenv(envTelemetryDimensionScrubbing))
+	if scrubbingConfig != "" {
+		TelemetryScrubRules, err = parseTelemetryScrubRules(scrubbingConfig)
+		if err != nil {
+			// fail closed, since the rules exist to keep dimensions out of telemetry
+			Log("Error parsing %s: %s. Omitting all scrubbable dimensions", envTelemetryDimensionScrubbing, err.Error())
+			TelemetryScrubRules = make(map[string]telemetryScrubRule)
+			for _, properties := range telemetryScrubbingDimensionProperties {
+				for _, property := range properties {
+					TelemetryScrubRules[property] = telemetryScrubRule{Action: telemetryScrubActionOmit}
+				}
+			}
+		}
+		Log("Telemetry dimension scrubbing configured for %d dimensions", len(TelemetryScrubRules))
+		scrubTelemetryProperties(CommonProperties)
+	}
+
 	TelemetryClient.Context().CommonProperties = CommonProperties
 
 	// Getting the namespace count, monitor kubernetes pods values and namespace count once at start because it wont change unless the configmap is applied and the container is restarted
@@ -394,7 +772,7 @@ func PushToAppInsightsTraces(records []map[interface{}]interface{}, severityLeve
 		var logEntry = ToString(record["log"])
 		if strings.Contains(logEntry, "config::error") {
 			populateKubeMonAgentEventHash(record, ConfigError)
-		} else if strings.Contains(logEntry, "E! [inputs.prometheus]") {
+		} else if _, ok := parsePromScrapeError(logEntry); ok {
 			populateKubeMonAgentEventHash(record, PromScrapingError)
 		} else {
 			logLines = append(logLines, logEntry)
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// env variable with the telemetry dimension scrubbing rules, e.g. "Computer=hash;ResourceID=omit;ContainerName=truncate:8"
+const envTelemetryDimensionScrubbing = "AZMON_TELEMETRY_DIMENSION_SCRUBBING"
+
+const (
+	telemetryScrubActionHash     = "hash"
+	telemetryScrubActionTruncate = "truncate"
+	telemetryScrubActionOmit     = "omit"
+
+	defaultTelemetryScrubTruncateLength = 8
+)
+
+// telemetry property keys covered by each configurable dimension
+var telemetryScrubbingDimensionProperties = map[string][]string{
+	"computer":      {"Computer"},
+	"resourceid":    {"AKS_RESOURCE_ID", "ACSResourceName", "ClusterName", "ResourceGroupName", "SubscriptionID"},
+	"containername": {"Container"},
+}
+
+type telemetryScrubRule struct {
+	Action string
+	Length int
+}
+
+// TelemetryScrubRules property key to scrubbing rule, empty when scrubbing is not configured
+var TelemetryScrubRules map[string]telemetryScrubRule
+
+// parseTelemetryScrubRules parses the scrubbing configuration into property key to rule mapping
+func parseTelemetryScrubRules(config string) (map[string]telemetryScrubRule, error) {
+	rules := make(map[string]telemetryScrubRule)
+	for _, entry := range strings.Split(config, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 {
+			return rules, fmt.Errorf("invalid telemetry scrubbing rule '%s', expected <dimension>=<action>", entry)
+		}
+		properties, ok := telemetryScrubbingDimensionProperties[strings.ToLower(strings.TrimSpace(kv[0]))]
+		if !ok {
+			return rules, fmt.Errorf("unknown telemetry dimension '%s' in scrubbing rule", kv[0])
+		}
+		rule := telemetryScrubRule{}
+		actionAndLength := strings.SplitN(strings.ToLower(strings.TrimSpace(kv[1])), ":", 2)
+		rule.Action = actionAndLength[0]
+		switch rule.Action {
+		case telemetryScrubActionHash, telemetryScrubActionOmit:
+		case telemetryScrubActionTruncate:
+			rule.Length = defaultTelemetryScrubTruncateLength
+			if len(actionAndLength) == 2 {
+				length, err := strconv.Atoi(actionAndLength[1])
+				if err != nil || length < 0 {
+					return rules, fmt.Errorf("invalid truncate length in telemetry scrubbing rule '%s'", entry)
+				}
+				rule.Length = length
+			}
+		default:
+			return rules, fmt.Errorf("unknown action '%s' in telemetry scrubbing rule", kv[1])
+		}
+		for _, property := range properties {
+			rules[property] = rule
+		}
+	}
+	return rules, nil
+}
+
+// scrubTelemetryValue applies the rule to a single dimension value
+func scrubTelemetryValue(rule telemetryScrubRule, value string) string {
+	if value == "" {
+		return value
+	}
+	switch rule.Action {
+	case telemetryScrubActionHash:
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])
+	case telemetryScrubActionTruncate:
+		if len(value) > rule.Length {
+			return value[:rule.Length]
+		}
+	case telemetryScrubActionOmit:
+		return ""
+	}
+	return value
+}
+
+// scrubTelemetryProperties applies the configured rules to the properties in place. Omitted dimensions are removed
+func scrubTelemetryProperties(properties map[string]string) {
+	if len(TelemetryScrubRules) == 0 {
+		return
+	}
+	for key, value := range properties {
+		rule, ok := TelemetryScrubRules[key]
+		if !ok {
+			continue
+		}
+		if rule.Action == telemetryScrubActionOmit {
+			delete(properties, key)
+		} else {
+			properties[key] = scrubTelemetryValue(rule, value)
+		}
+	}
+}
+
+// scrubTelemetryMessage replaces the raw values of the scrubbed dimensions in free text (exceptions)
+func scrubTelemetryMessage(message string) string {
+	if len(TelemetryScrubRules) == 0 {
+		return message
+	}
+	// resource id is replaced before the resource name since it contains the name
+	replacements := [][2]string{
+		{"AKS_RESOURCE_ID", ResourceID},
+		{"ClusterName", ResourceName},
+		{"Computer", Computer},
+	}
+	for _, replacement := range replacements {
+		rule, ok := TelemetryScrubRules[replacement[0]]
+		if !ok || replacement[1] == "" {
+			continue
+		}
+		message = strings.Replace(message, replacement[1], scrubTelemetryValue(rule, replacement[1]), -1)
+	}
+	return message
+}
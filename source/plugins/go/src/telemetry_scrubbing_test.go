@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseTelemetryScrubRules(t *testing.T) {
+	type test_struct struct {
+		testname string
+		config   string
+		output   map[string]telemetryScrubRule
+		err      bool
+	}
+
+	tests := []test_struct{
+		{"empty", "", map[string]telemetryScrubRule{}, false},
+		{"hash computer", "Computer=hash", map[string]telemetryScrubRule{"Computer": {telemetryScrubActionHash, 0}}, false},
+		{"truncate with length", " containername = truncate:4 ", map[string]telemetryScrubRule{"Container": {telemetryScrubActionTruncate, 4}}, false},
+		{"truncate default length", "ContainerName=truncate", map[string]telemetryScrubRule{"Container": {telemetryScrubActionTruncate, defaultTelemetryScrubTruncateLength}}, false},
+		{"unknown dimension", "Namespace=hash", map[string]telemetryScrubRule{}, true},
+		{"unknown action", "Computer=encrypt", map[string]telemetryScrubRule{}, true},
+		{"missing action", "Computer", map[string]telemetryScrubRule{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := parseTelemetryScrubRules(tt.config)
+			if tt.err != (err != nil) {
+				t.Errorf("parseTelemetryScrubRules(%s) error = %v, want error %t", tt.config, err, tt.err)
+			}
+			if !tt.err && !reflect.DeepEqual(got, tt.output) {
+				t.Errorf("parseTelemetryScrubRules(%s) = %v, want %v", tt.config, got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_scrubTelemetryProperties(t *testing.T) {
+	TelemetryScrubRules, _ = parseTelemetryScrubRules("Computer=truncate:3;ResourceID=omit")
+	defer func() { TelemetryScrubRules = nil }()
+
+	properties := map[string]string{
+		"Computer":        "aks-nodepool1-123",
+		"AKS_RESOURCE_ID": "/subscriptions/sub/resourcegroups/rg/providers/Microsoft.ContainerService/managedClusters/cluster",
+		"ClusterName":     "cluster",
+		"AgentVersion":    "1.0",
+	}
+	scrubTelemetryProperties(properties)
+
+	want := map[string]string{
+		"Computer":     "aks",
+		"AgentVersion": "1.0",
+	}
+	if !reflect.DeepEqual(properties, want) {
+		t.Errorf("scrubTelemetryProperties() = %v, want %v", properties, want)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ThrottlingEventCategory is the KubeMonAgentEvent category for the sends throttled by the workspace
+const ThrottlingEventCategory = "container.azm.ms/throttling"
+
+const (
+	// the pause of the sends of a data type after a 429 without a Retry-After
+	defaultThrottleDelay = 30 * time.Second
+
+	metricNameThrottledSeconds = "ODSThrottledSeconds"
+)
+
+// throttleWindow the throttling of the sends of a data type by the workspace
+type throttleWindow struct {
+	// the sends are paused until this time
+	until time.Time
+	// the time paused since the last telemetry tick
+	throttledFor time.Duration
+	// the 429s since the last KubeMonAgentEvents flush, and the time paused for them
+	count           int
+	eventThrottled  time.Duration
+	firstOccurrence time.Time
+	lastOccurrence  time.Time
+}
+
+// throttleManager pauses the sends of each data type for the Retry-After of the 429s of the workspace, instead of
+// retrying them while the workspace throttles
+type throttleManager struct {
+	mu      sync.Mutex
+	windows map[string]*throttleWindow
+}
+
+// ODSThrottles the throttling of the ODS sends by data type
+var ODSThrottles = newThrottleManager()
+
+func newThrottleManager() *throttleManager {
+	return &throttleManager{windows: make(map[string]*throttleWindow)}
+}
+
+// throttle pauses the sends of the data type after a 429, for its Retry-After or the default delay when it has none,
+// and returns the pause
+func (m *throttleManager) throttle(dataType string, retryAfter time.Duration, now time.Time) time.Duration {
+	if retryAfter <= 0 {
+		retryAfter = defaultThrottleDelay
+	}
+	if retryAfter > maxRetryAfter {
+		retryAfter = maxRetryAfter
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	window, ok := m.windows[dataType]
+	if !ok {
+		window = &throttleWindow{}
+		m.windows[dataType] = window
+	}
+	// only the extension of the current pause is added to the time paused
+	from := now
+	if window.until.After(now) {
+		from = window.until
+	}
+	if until := now.Add(retryAfter); until.After(from) {
+		window.throttledFor += until.Sub(from)
+		window.eventThrottled += until.Sub(from)
+		window.until = until
+	}
+	if window.count == 0 {
+		window.firstOccurrence = now
+	}
+	window.count++
+	window.lastOccurrence = now
+	return retryAfter
+}
+
+// throttledFor returns how long the sends of the data type are still paused, 0 when they are not
+func (m *throttleManager) throttledFor(dataType string, now time.Time) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	window, ok := m.windows[dataType]
+	if !ok || !now.Before(window.until) {
+		return 0
+	}
+	return window.until.Sub(now)
+}
+
+// checkThrottled returns whether the sends of the data type are paused, logging the pause
+func (m *throttleManager) checkThrottled(caller string, dataType string, numRecords int) bool {
+	retryIn := m.throttledFor(dataType, time.Now())
+	if retryIn <= 0 {
+		return false
+	}
+	Log("%s::Warning::the sends of %d %s records are throttled by the workspace for %s, will retry", caller, numRecords, dataType, retryIn)
+	return true
+}
+
+// takeThrottledDurations returns the time paused by data type since the last call
+func (m *throttleManager) takeThrottledDurations() map[string]time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	durations := make(map[string]time.Duration)
+	for dataType, window := range m.windows {
+		if window.throttledFor > 0 {
+			durations[dataType] = window.throttledFor
+			window.throttledFor = 0
+		}
+	}
+	return durations
+}
+
+// takeThrottlingEvents returns the throttling of the data types since the last KubeMonAgentEvents flush
+func (m *throttleManager) takeThrottlingEvents() map[string]KubeMonAgentEventTags {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := make(map[string]KubeMonAgentEventTags)
+	for dataType, window := range m.windows {
+		if window.count == 0 {
+			continue
+		}
+		message := fmt.Sprintf("The sends of %s were throttled (429) by the workspace", dataType)
+		events[message] = KubeMonAgentEventTags{
+			FirstOccurrence:  window.firstOccurrence.UTC().Format(time.RFC3339),
+			LastOccurrence:   window.lastOccurrence.UTC().Format(time.RFC3339),
+			Count:            window.count,
+			DataType:         dataType,
+			ThrottledSeconds: int(window.eventThrottled / time.Second),
+		}
+		window.count = 0
+		window.eventThrottled = 0
+	}
+	return events
+}
+
+// sendThrottleTelemetry sends the time the sends of each data type were paused since the last tick
+func sendThrottleTelemetry() {
+	durations := ODSThrottles.takeThrottledDurations()
+	dataTypes := make([]string, 0, len(durations))
+	for dataType := range durations {
+		dataTypes = append(dataTypes, dataType)
+	}
+	sort.Strings(dataTypes)
+	for _, dataType := range dataTypes {
+		trackSinkMetric(metricNameThrottledSeconds, durations[dataType].Seconds(), map[string]string{"DataType": dataType})
+	}
+}
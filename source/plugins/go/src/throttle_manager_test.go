@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_throttleManager(t *testing.T) {
+	type throttle struct {
+		at         time.Duration
+		retryAfter time.Duration
+	}
+	type test_struct struct {
+		testName         string
+		throttles        []throttle
+		checkAt          time.Duration
+		wantThrottledFor time.Duration
+		wantDuration     time.Duration
+		wantCount        int
+	}
+
+	tests := []test_struct{
+		{"retry after", []throttle{{0, 10 * time.Second}}, 4 * time.Second, 6 * time.Second, 10 * time.Second, 1},
+		{"default delay", []throttle{{0, 0}}, 0, defaultThrottleDelay, defaultThrottleDelay, 1},
+		{"expired", []throttle{{0, 10 * time.Second}}, 10 * time.Second, 0, 10 * time.Second, 1},
+		// only the extension of the pause is added to the time paused
+		{"overlapping", []throttle{{0, 10 * time.Second}, {5 * time.Second, 10 * time.Second}}, 5 * time.Second, 10 * time.Second, 15 * time.Second, 2},
+		{"within the pause", []throttle{{0, 10 * time.Second}, {2 * time.Second, 5 * time.Second}}, 2 * time.Second, 8 * time.Second, 10 * time.Second, 2},
+		{"capped", []throttle{{0, 2 * maxRetryAfter}}, 0, maxRetryAfter, maxRetryAfter, 1},
+	}
+
+	start := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			m := newThrottleManager()
+			for _, th := range tt.throttles {
+				m.throttle(InsightsMetricsDataType, th.retryAfter, start.Add(th.at))
+			}
+			if got := m.throttledFor(InsightsMetricsDataType, start.Add(tt.checkAt)); got != tt.wantThrottledFor {
+				t.Errorf("throttledFor() = %s, want %s", got, tt.wantThrottledFor)
+			}
+			if got := m.throttledFor(ContainerLogDataType, start.Add(tt.checkAt)); got != 0 {
+				t.Errorf("throttledFor() of another data type = %s, want 0", got)
+			}
+			if got := m.takeThrottledDurations()[InsightsMetricsDataType]; got != tt.wantDuration {
+				t.Errorf("takeThrottledDurations() = %s, want %s", got, tt.wantDuration)
+			}
+			if got := m.takeThrottledDurations(); len(got) != 0 {
+				t.Errorf("takeThrottledDurations() after take = %v, want none", got)
+			}
+			events := m.takeThrottlingEvents()
+			if len(events) != 1 {
+				t.Fatalf("takeThrottlingEvents() = %v, want 1 event", events)
+			}
+			for _, event := range events {
+				if event.Count != tt.wantCount || event.DataType != InsightsMetricsDataType || event.ThrottledSeconds != int(tt.wantDuration/time.Second) {
+					t.Errorf("takeThrottlingEvents() = %+v, want count %d and %s throttled", event, tt.wantCount, tt.wantDuration)
+				}
+			}
+			if events := m.takeThrottlingEvents(); len(events) != 0 {
+				t.Errorf("takeThrottlingEvents() after take = %v, want none", events)
+			}
+		})
+	}
+}
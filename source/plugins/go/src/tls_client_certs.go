@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the certificate files are checked for rotation at most once per this interval
+const clientCertificateReloadCheckInterval = 60 * time.Second
+
+// clientCertificateReloader serves the client certificate of a route for the TLS handshakes and reloads it when the
+// certificate or key file is rotated on disk, so long running connections pick up renewed certificates
+type clientCertificateReloader struct {
+	mu          sync.Mutex
+	route       string
+	certFile    string
+	keyFile     string
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+// routeSetting returns the setting for the route from the env (AZMON_<ROUTE>_<SETTING>) or the plugin configuration
+// (<route>_<setting>), e.g. AZMON_ODS_CLIENT_CERT_PATH or ods_client_cert_path
+func routeSetting(route string, setting string) string {
+	if value := strings.TrimSpace(os.Getenv("AZMON_" + strings.ToUpper(route) + "_" + strings.ToUpper(setting))); value != "" {
+		return value
+	}
+	return strings.TrimSpace(PluginConfiguration[strings.ToLower(route)+"_"+strings.ToLower(setting)])
+}
+
+func newClientCertificateReloader(route string, certFile string, keyFile string) (*clientCertificateReloader, error) {
+	reloader := &clientCertificateReloader{route: route, certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *clientCertificateReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return nil
+}
+
+// GetClientCertificate returns the current certificate, reloading it first when the files have changed. A rotation
+// in progress (e.g. the key written but not the certificate yet) keeps the previous certificate until both match
+func (r *clientCertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.lastCheck) >= clientCertificateReloadCheckInterval {
+		r.lastCheck = time.Now()
+		certInfo, certErr := os.Stat(r.certFile)
+		keyInfo, keyErr := os.Stat(r.keyFile)
+		if certErr == nil && keyErr == nil && (!certInfo.ModTime().Equal(r.certModTime) || !keyInfo.ModTime().Equal(r.keyModTime)) {
+			if err := r.reload(); err != nil {
+				Log("Error::tls::Unable to reload the rotated client certificate for the %s route, using the previous one: %s", r.route, err.Error())
+			} else {
+				Log("Info::tls::Reloaded the rotated client certificate for the %s route", r.route)
+			}
+		}
+	}
+	if r.cert == nil {
+		return nil, fmt.Errorf("no client certificate for the %s route", r.route)
+	}
+	return r.cert, nil
+}
+
+// newRouteTLSConfig returns the TLS config with the client certificate of the route. The certificate configured for
+// the route takes precedence over the default one, and a CA bundle can be configured for private endpoints. Returns
+// nil without error when the route has no client certificate
+func newRouteTLSConfig(route string, defaultCertFile string, defaultKeyFile string) (*tls.Config, error) {
+	certFile := routeSetting(route, "client_cert_path")
+	keyFile := routeSetting(route, "client_key_path")
+	if certFile == "" && keyFile == "" {
+		certFile = defaultCertFile
+		keyFile = defaultKeyFile
+	}
+	caFile := routeSetting(route, "ca_cert_path")
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both the client certificate and key are required for the %s route", route)
+		}
+		reloader, err := newClientCertificateReloader(route, certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+		Log("Using client certificate %s for the %s route", certFile, route)
+	}
+	if caFile != "" {
+		caCerts, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caCerts) {
+			return nil, errors.New("no certificates found in the CA bundle " + caFile + " for the " + route + " route")
+		}
+		tlsConfig.RootCAs = rootCAs
+		Log("Using CA bundle %s for the %s route", caFile, route)
+	}
+	return tlsConfig, nil
+}
+
+// newRouteDialTLSConfig returns the TLS config of the route for the senders dialing the connection themselves (gRPC,
+// Kafka), with TLS 1.2 at least and the client certificate and CA bundle of the route when configured
+func newRouteDialTLSConfig(route string) (*tls.Config, error) {
+	tlsConfig, err := newRouteTLSConfig(route, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig.MinVersion = tls.VersionTLS12
+	return tlsConfig, nil
+}
+
+// hasRouteTLSSettings returns whether a client certificate or a CA bundle is configured for the route
+func hasRouteTLSSettings(route string) bool {
+	return routeSetting(route, "client_cert_path") != "" || routeSetting(route, "client_key_path") != "" || routeSetting(route, "ca_cert_path") != ""
+}
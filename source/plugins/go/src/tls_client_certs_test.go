@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestClientCertificate(t *testing.T, dir string, commonName string, modTime time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+	return certFile, keyFile
+}
+
+func Test_clientCertificateReloader(t *testing.T) {
+	type test_struct struct {
+		testname   string
+		rotate     bool
+		recheck    bool
+		commonName string
+	}
+
+	tests := []test_struct{
+		{"not rotated", false, true, "original"},
+		{"rotated", true, true, "rotated"},
+		{"rotated within the check interval", true, false, "original"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "tlscerts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			certFile, keyFile := writeTestClientCertificate(t, dir, "original", time.Now().Add(-time.Hour))
+			reloader, err := newClientCertificateReloader("test", certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reloader.lastCheck = time.Now()
+			if tt.rotate {
+				writeTestClientCertificate(t, dir, "rotated", time.Now())
+			}
+			if tt.recheck {
+				reloader.lastCheck = time.Time{}
+			}
+
+			cert, err := reloader.GetClientCertificate(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leaf.Subject.CommonName != tt.commonName {
+				t.Errorf("GetClientCertificate() CN = %s, want %s", leaf.Subject.CommonName, tt.commonName)
+			}
+		})
+	}
+}
+
+func Test_routeTLSConfigOfSenders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlscerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestClientCertificate(t, dir, "client", time.Now())
+
+	type test_struct struct {
+		testname  string
+		settings  map[string]string
+		tlsConfig func() (*tls.Config, error)
+	}
+
+	tests := []test_struct{
+		{"dcr", map[string]string{"dcr_endpoint": "https://mydce-a1b2.westeurope-1.ingest.monitor.azure.com", "dcr_immutable_id": "dcr-0123456789abcdef0123456789abcdef"}, func() (*tls.Config, error) {
+			config, err := newDCRConfig(ContainerLogsDCRRoute)
+			if err != nil || config.httpClient == nil {
+				return nil, err
+			}
+			return config.httpClient.Transport.(*http.Transport).TLSClientConfig, nil
+		}},
+		{"otlp", map[string]string{"otlp_endpoint": "collector:4317"}, func() (*tls.Config, error) {
+			config, err := newOTLPConfig(ContainerLogsOTLPRoute)
+			return config.tlsConfig, err
+		}},
+		{"kafka", map[string]string{"kafka_brokers": "broker:9093", "kafka_topic": "logs"}, func() (*tls.Config, error) {
+			config, err := newKafkaConfig(ContainerLogsKafkaRoute)
+			return config.newSaramaConfig().Net.TLS.Config, err
+		}},
+	}
+
+	defer func() { PluginConfiguration, LogsIngestionTokenProvider = nil, nil }()
+	LogsIngestionTokenProvider = &TokenProvider{name: "test"}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			tt.settings[tt.testname+"_client_cert_path"] = certFile
+			tt.settings[tt.testname+"_client_key_path"] = keyFile
+			tt.settings[tt.testname+"_ca_cert_path"] = certFile
+			PluginConfiguration = tt.settings
+			tlsConfig, err := tt.tlsConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tlsConfig == nil || tlsConfig.GetClientCertificate == nil || tlsConfig.RootCAs == nil {
+				t.Fatalf("the %s sender does not use the client certificate and the CA bundle of the route", tt.testname)
+			}
+			if tt.testname != "dcr" && tlsConfig.MinVersion != tls.VersionTLS12 {
+				t.Errorf("the %s sender allows TLS versions under 1.2", tt.testname)
+			}
+		})
+	}
+
+	// the AMQP connection of Event Hubs takes no TLS config
+	PluginConfiguration = map[string]string{"eventhubs_namespace": "mynamespace.servicebus.windows.net", "eventhubs_hub": "logs", "eventhubs_client_cert_path": certFile, "eventhubs_client_key_path": keyFile}
+	if _, err := newEventHubsConfig(ContainerLogsEventHubsRoute); err == nil {
+		t.Errorf("newEventHubsConfig() accepted a client certificate it does not use")
+	}
+}
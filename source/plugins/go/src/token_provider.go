@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// earliest and latest delays of the proactive refresh loop
+	minTokenRefreshDelay = 10 * time.Second
+	maxTokenRefreshDelay = 1 * time.Hour
+	// spreads the proactive refreshes of the agents in a cluster
+	maxTokenRefreshJitter = 60 * time.Second
+	// longest delay of the proactive refresh loop after failed refreshes
+	maxTokenRefreshFailureBackoff = 60 * time.Second
+)
+
+const eventNameTokenRefreshFailure = "TokenRefreshFailureEvent"
+
+// tokenFetchFunc gets a new token and returns it with its expiration (unix time). The fetch retries on its own (e.g.
+// the IMDS requests), a failed fetch is retried by the next refresh
+type tokenFetchFunc func() (string, int64, error)
+
+// tokenRefresh a fetch in flight, waited for by the refreshes started while it runs
+type tokenRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// TokenProvider caches a token and refreshes it before it expires. All the routes get their tokens (MSI, SPN, workload
+// identity and the ODS ingestion token) through a provider. It implements adal.OAuthTokenProvider, so it can be used
+// with autorest.NewBearerAuthorizer
+type TokenProvider struct {
+	name                string
+	fetch               tokenFetchFunc
+	refreshBefore       time.Duration
+	mu                  sync.Mutex
+	token               string
+	expiration          int64
+	consecutiveFailures int
+	refreshing          bool
+	// the fetch in flight, nil when none
+	inflight *tokenRefresh
+}
+
+var (
+	// TokenProviders the token providers by name, so that every route sharing a credential shares its token
+	TokenProviders = make(map[string]*TokenProvider)
+	// TokenProvidersMutex read and write mutex access to TokenProviders
+	TokenProvidersMutex = &sync.Mutex{}
+)
+
+// getOrCreateTokenProvider returns the provider registered with the name, creating it when it does not exist yet
+func getOrCreateTokenProvider(name string, refreshBefore time.Duration, fetch tokenFetchFunc) *TokenProvider {
+	TokenProvidersMutex.Lock()
+	defer TokenProvidersMutex.Unlock()
+	if provider, ok := TokenProviders[name]; ok {
+		return provider
+	}
+	provider := &TokenProvider{name: name, fetch: fetch, refreshBefore: refreshBefore}
+	TokenProviders[name] = provider
+	return provider
+}
+
+// Token returns the cached token, refreshing it when it is within the refresh window. When the refresh fails, the
+// cached token is returned as long as it has not expired
+func (p *TokenProvider) Token() (string, error) {
+	p.mu.Lock()
+	if p.token != "" && time.Now().Add(p.refreshBefore).Unix() < p.expiration {
+		token := p.token
+		p.mu.Unlock()
+		return token, nil
+	}
+	p.mu.Unlock()
+	err := p.refresh()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil && p.token != "" && time.Now().Unix() < p.expiration {
+		return p.token, err
+	}
+	if err != nil {
+		return "", err
+	}
+	return p.token, nil
+}
+
+// OAuthToken returns the token for the bearer authorizer
+func (p *TokenProvider) OAuthToken() string {
+	token, err := p.Token()
+	if err != nil {
+		Log("Error::token::%s: %s", p.name, err.Error())
+	}
+	return token
+}
+
+// refresh fetches a new token without holding the lock, so the cached token is still returned while the fetch runs. A
+// refresh started while a fetch is in flight waits for it instead of fetching again
+func (p *TokenProvider) refresh() error {
+	p.mu.Lock()
+	if inflight := p.inflight; inflight != nil {
+		p.mu.Unlock()
+		<-inflight.done
+		return inflight.err
+	}
+	inflight := &tokenRefresh{done: make(chan struct{})}
+	p.inflight = inflight
+	p.mu.Unlock()
+
+	token, expiration, err := p.fetch()
+	if err == nil && token == "" {
+		err = fmt.Errorf("empty token")
+	}
+
+	p.mu.Lock()
+	if err == nil {
+		p.token = token
+		p.expiration = expiration
+		if p.consecutiveFailures > 0 {
+			Log("Info::token::%s: token refreshed after %d failed refreshes", p.name, p.consecutiveFailures)
+		}
+		p.consecutiveFailures = 0
+	} else {
+		p.consecutiveFailures++
+		p.reportRefreshFailureLocked(err)
+	}
+	p.inflight = nil
+	p.mu.Unlock()
+	inflight.err = err
+	close(inflight.done)
+	return err
+}
+
+func (p *TokenProvider) reportRefreshFailureLocked(err error) {
+	expiresIn := p.expiration - time.Now().Unix()
+	message := fmt.Sprintf("Failed to refresh the %s token (%d consecutive failed refreshes), cached token expires in %d seconds: %s",
+		p.name, p.consecutiveFailures, expiresIn, err.Error())
+	Log("Error::token::%s", message)
+	if TelemetryClient != nil {
+		telemetryDimensions := make(map[string]string)
+		telemetryDimensions["TokenProvider"] = p.name
+		telemetryDimensions["Error"] = err.Error()
+		telemetryDimensions["ConsecutiveFailures"] = strconv.Itoa(p.consecutiveFailures)
+		telemetryDimensions["CachedTokenExpiresInSeconds"] = strconv.FormatInt(expiresIn, 10)
+		SendEvent(eventNameTokenRefreshFailure, telemetryDimensions)
+	}
+}
+
+// startProactiveRefresh refreshes the token in the background before it enters the refresh window, so the senders
+// do not have to wait for a refresh on the flush path
+func (p *TokenProvider) startProactiveRefresh() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.refreshing {
+		return
+	}
+	p.refreshing = true
+	go func() {
+		for {
+			p.mu.Lock()
+			// the jittered delay wakes up ahead of the refresh window
+			needsRefresh := p.token == "" || time.Now().Add(p.refreshBefore+maxTokenRefreshJitter).Unix() >= p.expiration
+			p.mu.Unlock()
+			err := error(nil)
+			if needsRefresh {
+				err = p.refresh()
+			}
+			p.mu.Lock()
+			delay := tokenRefreshDelay(p.expiration, p.refreshBefore, time.Now())
+			if err != nil {
+				delay = jitteredBackoff(p.consecutiveFailures, minTokenRefreshDelay)
+				if delay > maxTokenRefreshFailureBackoff {
+					delay = maxTokenRefreshFailureBackoff
+				}
+			}
+			p.mu.Unlock()
+			time.Sleep(delay)
+		}
+	}()
+}
+
+// tokenRefreshDelay returns how long to wait before refreshing a token expiring at the given time. The refresh is
+// jittered ahead of the refresh window and bounded, so that a bogus expiration does not stop the refreshes
+func tokenRefreshDelay(expiration int64, refreshBefore time.Duration, now time.Time) time.Duration {
+	delay := time.Unix(expiration, 0).Sub(now) - refreshBefore
+	delay -= time.Duration(rand.Int63n(int64(maxTokenRefreshJitter)))
+	if delay < minTokenRefreshDelay {
+		return minTokenRefreshDelay
+	}
+	if delay > maxTokenRefreshDelay {
+		return maxTokenRefreshDelay
+	}
+	return delay
+}
+
+// jitteredBackoff returns base * 2^retryCount plus up to 50% random jitter
+func jitteredBackoff(retryCount int, base time.Duration) time.Duration {
+	if retryCount > 10 {
+		retryCount = 10
+	}
+	backoff := base * time.Duration(1<<uint(retryCount))
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+}
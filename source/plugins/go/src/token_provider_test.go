@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_tokenRefreshDelay(t *testing.T) {
+	type test_struct struct {
+		testname  string
+		expiresIn time.Duration
+		minDelay  time.Duration
+		maxDelay  time.Duration
+	}
+
+	tests := []test_struct{
+		{"refreshed ahead of the refresh window", 30 * time.Minute, 25*time.Minute - maxTokenRefreshJitter, 25 * time.Minute},
+		{"already in the refresh window", 2 * time.Minute, minTokenRefreshDelay, minTokenRefreshDelay},
+		{"expired", -time.Minute, minTokenRefreshDelay, minTokenRefreshDelay},
+		{"long lived token", 24 * time.Hour, maxTokenRefreshDelay, maxTokenRefreshDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			now := time.Now()
+			got := tokenRefreshDelay(now.Add(tt.expiresIn).Unix(), 5*time.Minute, now)
+			if got < tt.minDelay || got > tt.maxDelay {
+				t.Errorf("tokenRefreshDelay(%s) = %s, want between %s and %s", tt.expiresIn, got, tt.minDelay, tt.maxDelay)
+			}
+		})
+	}
+}
+
+func Test_TokenProviderToken(t *testing.T) {
+	type test_struct struct {
+		testname   string
+		token      string
+		expiresIn  time.Duration
+		fetchErr   error
+		output     string
+		fetchCount int
+	}
+
+	tests := []test_struct{
+		{"cached token", "cached", time.Hour, nil, "cached", 0},
+		{"token in refresh window", "cached", time.Minute, nil, "fetched", 1},
+		{"refresh failure keeps valid token", "cached", time.Minute, errors.New("unavailable"), "cached", 1},
+		{"refresh failure without valid token", "cached", -time.Minute, errors.New("unavailable"), "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			fetchCount := 0
+			provider := &TokenProvider{
+				name:          tt.testname,
+				refreshBefore: 5 * time.Minute,
+				token:         tt.token,
+				expiration:    time.Now().Add(tt.expiresIn).Unix(),
+				fetch: func() (string, int64, error) {
+					fetchCount++
+					if tt.fetchErr != nil {
+						return "", 0, tt.fetchErr
+					}
+					return "fetched", time.Now().Add(time.Hour).Unix(), nil
+				},
+			}
+			got, _ := provider.Token()
+			if got != tt.output || fetchCount != tt.fetchCount {
+				t.Errorf("Token() = %s with %d fetches, want %s with %d fetches", got, fetchCount, tt.output, tt.fetchCount)
+			}
+		})
+	}
+}
+
+func Test_TokenProviderConcurrentRefresh(t *testing.T) {
+	release := make(chan struct{})
+	fetchCount := 0
+	provider := &TokenProvider{
+		name:          "concurrent refresh",
+		refreshBefore: 5 * time.Minute,
+		token:         "cached",
+		expiration:    time.Now().Add(time.Minute).Unix(),
+		fetch: func() (string, int64, error) {
+			fetchCount++
+			<-release
+			return "fetched", time.Now().Add(time.Hour).Unix(), nil
+		},
+	}
+
+	tokens := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			token, _ := provider.Token()
+			tokens <- token
+		}()
+	}
+	// the lock is not held during the fetch
+	time.Sleep(20 * time.Millisecond)
+	provider.mu.Lock()
+	cached := provider.token
+	provider.mu.Unlock()
+	if cached != "cached" {
+		t.Errorf("token during the fetch = %s, want cached", cached)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if token := <-tokens; token != "fetched" {
+			t.Errorf("Token() = %s, want fetched", token)
+		}
+	}
+	if fetchCount != 1 {
+		t.Errorf("Token() fetched %d times for concurrent refreshes, want 1", fetchCount)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -16,7 +15,7 @@ import (
 
 	"github.com/Azure/azure-kusto-go/kusto"
 	"github.com/Azure/azure-kusto-go/kusto/ingest"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/tinylib/msgp/msgp"
 )
 
@@ -63,32 +62,27 @@ func ReadConfiguration(filename string) (map[string]string, error) {
 
 // CreateHTTPClient used to create the client for sending post requests to OMSEndpoint
 func CreateHTTPClient() {
-	var transport *http.Transport
-	if IsAADMSIAuthMode {
-		transport = &http.Transport{}
-	} else {
-		certFilePath := PluginConfiguration["cert_file_path"]
-		keyFilePath := PluginConfiguration["key_file_path"]
+	// agent certificates are not used with token auth, but a client certificate can still be configured for the route
+	defaultCertFilePath := ""
+	defaultKeyFilePath := ""
+	if !(IsAADMSIAuthMode || IsWorkloadIdentityAuthMode) {
+		defaultCertFilePath = PluginConfiguration["cert_file_path"]
+		defaultKeyFilePath = PluginConfiguration["key_file_path"]
 		if IsWindows == false {
-			certFilePath = fmt.Sprintf(certFilePath, WorkspaceID)
-			keyFilePath = fmt.Sprintf(keyFilePath, WorkspaceID)
-		}
-		cert, err := tls.LoadX509KeyPair(certFilePath, keyFilePath)
-		if err != nil {
-			message := fmt.Sprintf("Error when loading cert %s", err.Error())
-			SendException(message)
-			time.Sleep(30 * time.Second)
-			Log(message)
-			log.Fatalf("Error when loading cert %s", err.Error())
+			defaultCertFilePath = fmt.Sprintf(defaultCertFilePath, WorkspaceID)
+			defaultKeyFilePath = fmt.Sprintf(defaultKeyFilePath, WorkspaceID)
 		}
-
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		}
-
-		tlsConfig.BuildNameToCertificate()
-		transport = &http.Transport{TLSClientConfig: tlsConfig}
 	}
+	// the certificate is reloaded when the agent renews it
+	tlsConfig, err := newRouteTLSConfig("ods", defaultCertFilePath, defaultKeyFilePath)
+	if err != nil {
+		message := fmt.Sprintf("Error when loading cert %s", err.Error())
+		SendException(message)
+		time.Sleep(30 * time.Second)
+		Log(message)
+		log.Fatalf("Error when loading cert %s", err.Error())
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
 	// set the proxy if the proxy configured
 	if ProxyEndpoint != "" {
 		proxyEndpointUrl, err := url.Parse(ProxyEndpoint)
@@ -110,6 +104,23 @@ func CreateHTTPClient() {
 	Log("Successfully created HTTP Client")
 }
 
+// createSecondaryODSClient creates the ODS client on the v2 route, for the features sending to ODS besides mdsd. Returns
+// an error when ODS can't be reached from the v2 route
+func createSecondaryODSClient() error {
+	// with token auth, the ingestion token is only fetched for the ODS route
+	if IsAADMSIAuthMode || IsWorkloadIdentityAuthMode {
+		return errors.New("ODS is not available with token auth on the v2 route")
+	}
+	// CreateHTTPClient exits the plugin when the certificate can't be loaded
+	for _, setting := range []string{"cert_file_path", "key_file_path"} {
+		if _, err := os.Stat(fmt.Sprintf(PluginConfiguration[setting], WorkspaceID)); err != nil {
+			return fmt.Errorf("the ODS certificate is not available: %s", err.Error())
+		}
+	}
+	CreateHTTPClient()
+	return nil
+}
+
 // ToString converts an interface into a string
 func ToString(s interface{}) string {
 	switch t := s.(type) {
@@ -121,37 +132,39 @@ func ToString(s interface{}) string {
 	}
 }
 
-//mdsdSocketClient to write msgp messages
-func CreateMDSDClient(dataType DataType, containerType string) {
+// getMdsdFluentSocketPath returns the unix socket of the local agent for the container type and route
+func getMdsdFluentSocketPath(containerType string) string {
 	mdsdfluentSocket := "/var/run/mdsd/default_fluent.socket"
 	if containerType != "" && strings.Compare(strings.ToLower(containerType), "prometheussidecar") == 0 {
 		mdsdfluentSocket = fmt.Sprintf("/var/run/mdsd-%s/default_fluent.socket", containerType)
 	}
+	if MdsdFluentSocketPath != "" {
+		mdsdfluentSocket = MdsdFluentSocketPath
+	}
+	if ContainerLogsRouteGeneva == true {
+		mdsdfluentSocket = GenevaFluentSocketPath
+	}
+	return mdsdfluentSocket
+}
+
+//mdsdSocketClient to write msgp messages
+func CreateMDSDClient(dataType DataType, containerType string) {
+	network, mdsdfluentSocket := getMdsdFluentNetwork(containerType)
 	switch dataType {
 	case ContainerLogV2:
-		if MdsdMsgpUnixSocketClient != nil {
-			MdsdMsgpUnixSocketClient.Close()
-			MdsdMsgpUnixSocketClient = nil
-		}
-		/*conn, err := fluent.New(fluent.Config{FluentNetwork:"unix",
-		FluentSocketPath:"/var/run/mdsd/default_fluent.socket",
-		WriteTimeout: 5 * time.Second,
-		RequestAck: true}) */
-		conn, err := net.DialTimeout("unix",
-			mdsdfluentSocket, 10*time.Second)
-		if err != nil {
+		// the container log connections are pooled, the idle ones are replaced by a new connection
+		MdsdContainerLogConnPool.closeIdle()
+		if err := MdsdContainerLogConnPool.warm(); err != nil {
 			Log("Error::mdsd::Unable to open MDSD msgp socket connection for ContainerLogV2 %s", err.Error())
-			//log.Fatalf("Unable to open MDSD msgp socket connection %s", err.Error())
 		} else {
 			Log("Successfully created MDSD msgp socket connection for ContainerLogV2: %s", mdsdfluentSocket)
-			MdsdMsgpUnixSocketClient = conn
 		}
 	case KubeMonAgentEvents:
 		if MdsdKubeMonMsgpUnixSocketClient != nil {
 			MdsdKubeMonMsgpUnixSocketClient.Close()
 			MdsdKubeMonMsgpUnixSocketClient = nil
 		}
-		conn, err := net.DialTimeout("unix",
+		conn, err := net.DialTimeout(network,
 			mdsdfluentSocket, 10*time.Second)
 		if err != nil {
 			Log("Error::mdsd::Unable to open MDSD msgp socket connection for KubeMon events %s", err.Error())
@@ -165,7 +178,7 @@ func CreateMDSDClient(dataType DataType, containerType string) {
 			MdsdInsightsMetricsMsgpUnixSocketClient.Close()
 			MdsdInsightsMetricsMsgpUnixSocketClient = nil
 		}
-		conn, err := net.DialTimeout("unix",
+		conn, err := net.DialTimeout(network,
 			mdsdfluentSocket, 10*time.Second)
 		if err != nil {
 			Log("Error::mdsd::Unable to open MDSD msgp socket connection for insights metrics %s", err.Error())
@@ -177,6 +190,18 @@ func CreateMDSDClient(dataType DataType, containerType string) {
 	}
 }
 
+// adxClientConfig the cluster, database and credentials of an ADX client
+type adxClientConfig struct {
+	clusterURI       string
+	database         string
+	tenantID         string
+	clientID         string
+	clientSecret     string
+	workloadIdentity bool
+	// the table of the ingestor, ContainerLogV2 when empty
+	table string
+}
+
 //ADX client to write to ADX
 func CreateADXClient() {
 
@@ -184,21 +209,48 @@ func CreateADXClient() {
 		ADXIngestor = nil
 	}
 
-	authConfig := auth.NewClientCredentialsConfig(AdxClientID, AdxClientSecret, AdxTenantID)
+	ingestor, err := newADXIngestor(adxClientConfig{
+		clusterURI:       AdxClusterUri,
+		database:         AdxDatabaseName,
+		tenantID:         AdxTenantID,
+		clientID:         AdxClientID,
+		clientSecret:     AdxClientSecret,
+		workloadIdentity: AdxWorkloadIdentity,
+	})
+	if err == nil {
+		ADXIngestor = ingestor
+	}
+}
+
+// newADXIngestor creates the ingestor of the table of an ADX cluster, the ContainerLogV2 table by default
+func newADXIngestor(config adxClientConfig) (*ingest.Ingestion, error) {
+	adxScope := strings.TrimSuffix(config.clusterURI, "/") + "/.default"
+	var tokenProvider *TokenProvider
+	if config.workloadIdentity == true {
+		// federated token exchange with the projected service account token, no node secret or client secret needed
+		tokenProvider = newWorkloadIdentityTokenProvider(config.tenantID, config.clientID, adxScope)
+	} else {
+		tokenProvider = newClientSecretTokenProvider(config.tenantID, config.clientID, config.clientSecret, adxScope)
+	}
+	tokenProvider.startProactiveRefresh()
 
-	client, err := kusto.New(AdxClusterUri, kusto.Authorization{Config: authConfig})
+	client, err := kusto.New(config.clusterURI, kusto.Authorization{Authorizer: autorest.NewBearerAuthorizer(tokenProvider)})
 	if err != nil {
 		Log("Error::mdsd::Unable to create ADX client %s", err.Error())
 		//log.Fatalf("Unable to create ADX connection %s", err.Error())
-	} else {
-		Log("Successfully created ADX Client. Creating Ingestor...")
-		ingestor, ingestorErr := ingest.New(client, AdxDatabaseName, "ContainerLogV2")
-		if ingestorErr != nil {
-			Log("Error::mdsd::Unable to create ADX ingestor %s", ingestorErr.Error())
-		} else {
-			ADXIngestor = ingestor
-		}
+		return nil, err
+	}
+	Log("Successfully created ADX Client. Creating Ingestor...")
+	table := config.table
+	if table == "" {
+		table = "ContainerLogV2"
+	}
+	ingestor, ingestorErr := ingest.New(client, config.database, table)
+	if ingestorErr != nil {
+		Log("Error::mdsd::Unable to create ADX ingestor %s", ingestorErr.Error())
+		return nil, ingestorErr
 	}
+	return ingestor, nil
 }
 
 func ReadFileContents(fullPathToFileName string) (string, error) {
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// the validate sink checks the records of the container logs route against the schema of its backend without sending
+// them, to catch the schema regressions in test and canary deployments
+const sinkTypeValidate = "validate"
+
+// max size of a string field in log analytics, larger values are truncated at ingestion
+const logAnalyticsMaxFieldBytes = 32 * 1024
+
+// recordSchema the columns of a container log table
+type recordSchema struct {
+	name string
+	// the columns which can't be empty
+	required []string
+	// the columns with a RFC3339 time
+	times []string
+	// the columns with the stream of the log
+	streams []string
+	// the largest value of a column, 0 when not limited
+	maxFieldBytes int
+}
+
+var (
+	containerLogSchema = recordSchema{
+		name:          "ContainerLog",
+		required:      []string{"Id", "LogEntrySource", "LogEntryTimeStamp", "TimeOfCommand", "SourceSystem", "Computer"},
+		times:         []string{"LogEntryTimeStamp", "TimeOfCommand"},
+		streams:       []string{"LogEntrySource"},
+		maxFieldBytes: logAnalyticsMaxFieldBytes,
+	}
+	containerLogV2Schema = recordSchema{
+		name:          "ContainerLogV2",
+		required:      []string{"TimeGenerated", "Computer", "ContainerId", "ContainerName", "PodName", "PodNamespace", "LogSource"},
+		times:         []string{"TimeGenerated"},
+		streams:       []string{"LogSource"},
+		maxFieldBytes: logAnalyticsMaxFieldBytes,
+	}
+	// ADX columns are not limited to 32KB
+	containerLogV2ADXSchema = recordSchema{
+		name:     "ContainerLogV2 (ADX)",
+		required: containerLogV2Schema.required,
+		times:    containerLogV2Schema.times,
+		streams:  containerLogV2Schema.streams,
+	}
+)
+
+// validateRecord returns the schema violations of a record
+func validateRecord(schema recordSchema, record map[string]string) []string {
+	var violations []string
+	for _, column := range schema.required {
+		if record[column] == "" {
+			violations = append(violations, fmt.Sprintf("%s is empty", column))
+		}
+	}
+	for _, column := range schema.times {
+		if value := record[column]; value != "" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				violations = append(violations, fmt.Sprintf("%s %s is not a RFC3339 time", column, value))
+			}
+		}
+	}
+	for _, column := range schema.streams {
+		if value := record[column]; value != "" && value != "stdout" && value != "stderr" {
+			violations = append(violations, fmt.Sprintf("%s %s is not stdout or stderr", column, value))
+		}
+	}
+	if value := record["RestartCount"]; value != "" {
+		if _, err := strconv.Atoi(value); err != nil {
+			violations = append(violations, fmt.Sprintf("RestartCount %s is not an integer", value))
+		}
+	}
+	if value := record["ContainerLabels"]; value != "" && !json.Valid([]byte(value)) {
+		violations = append(violations, "ContainerLabels is not json")
+	}
+	if schema.maxFieldBytes > 0 {
+		for column, value := range record {
+			if len(value) > schema.maxFieldBytes {
+				violations = append(violations, fmt.Sprintf("%s is %d bytes, over the %d bytes limit", column, len(value), schema.maxFieldBytes))
+			}
+		}
+	}
+	return violations
+}
+
+// validateSink serializes the records of the batches like the sink of their backend and validates them, without
+// sending them
+type validateSink struct {
+	sinkHealth
+	name string
+}
+
+func newValidateSink(name string) (Sink, error) {
+	return &validateSink{name: name}, nil
+}
+
+func (s *validateSink) Name() string { return s.name }
+
+func (s *validateSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	invalidRecords, violations := validateContainerLogBatch(batch)
+	if invalidRecords > 0 {
+		Log("Warning::validate::%d of %d records of the batch violate the schema, e.g. %s", invalidRecords, batch.len(), violations[0])
+		ContainerLogTelemetryMutex.Lock()
+		ContainerLogsSchemaViolationCount += float64(invalidRecords)
+		ContainerLogTelemetryMutex.Unlock()
+	}
+	return s.setSendResult(nil)
+}
+
+// validateContainerLogBatch returns the number of records of the batch violating the schema of its backend, and the
+// violations of the first one
+func validateContainerLogBatch(batch *containerLogBatch) (int, []string) {
+	invalidRecords := 0
+	var firstViolations []string
+	check := func(schema recordSchema, record map[string]string, serializationErr error) {
+		violations := validateRecord(schema, record)
+		if serializationErr != nil {
+			violations = append(violations, fmt.Sprintf("unable to serialize the record: %s", serializationErr.Error()))
+		}
+		if len(violations) > 0 {
+			invalidRecords++
+			if firstViolations == nil {
+				firstViolations = violations
+			}
+		}
+	}
+
+	schema := containerLogSchema
+	if ContainerLogSchemaV2 {
+		schema = containerLogV2Schema
+	}
+	for _, entry := range batch.msgPackEntries {
+		_, err := msgp.AppendIntf(nil, entry.Record)
+		check(schema, entry.Record, err)
+	}
+	for _, item := range batch.dataItemsADX {
+		record, err := serializedRecord(item)
+		check(containerLogV2ADXSchema, record, err)
+	}
+	for _, item := range batch.dataItemsLAv2 {
+		record, err := serializedRecord(item)
+		check(containerLogV2Schema, record, err)
+	}
+	for _, item := range batch.dataItemsLAv1 {
+		record, err := serializedRecord(item)
+		check(containerLogSchema, record, err)
+	}
+	return invalidRecords, firstViolations
+}
+
+// serializedRecord returns the columns of a record as serialized in json for ODS and ADX
+func serializedRecord(item interface{}) (map[string]string, error) {
+	serialized, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	record := make(map[string]string)
+	err = json.Unmarshal(serialized, &record)
+	return record, err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_validateRecord(t *testing.T) {
+	type test_struct struct {
+		testname string
+		schema   recordSchema
+		record   map[string]string
+		output   []string
+	}
+
+	validV2 := map[string]string{
+		"TimeGenerated": "2021-06-01T10:00:00.123456789Z",
+		"Computer":      "node1",
+		"ContainerId":   "0123abcd",
+		"ContainerName": "app",
+		"PodName":       "app-1",
+		"PodNamespace":  "default",
+		"LogMessage":    "hello",
+		"LogSource":     "stdout",
+	}
+	with := func(key string, value string) map[string]string {
+		record := make(map[string]string)
+		for k, v := range validV2 {
+			record[k] = v
+		}
+		record[key] = value
+		return record
+	}
+
+	tests := []test_struct{
+		{"valid", containerLogV2Schema, validV2, nil},
+		{"empty required column", containerLogV2Schema, with("ContainerId", ""), []string{"ContainerId is empty"}},
+		{"invalid time", containerLogV2Schema, with("TimeGenerated", "yesterday"), []string{"TimeGenerated yesterday is not a RFC3339 time"}},
+		{"invalid stream", containerLogV2Schema, with("LogSource", "stdin"), []string{"LogSource stdin is not stdout or stderr"}},
+		{"invalid restart count", containerLogV2Schema, with("RestartCount", "two"), []string{"RestartCount two is not an integer"}},
+		{"invalid labels", containerLogV2Schema, with("ContainerLabels", "{"), []string{"ContainerLabels is not json"}},
+		{"field over the LA limit", containerLogV2Schema, with("LogMessage", strings.Repeat("a", logAnalyticsMaxFieldBytes+1)), []string{"LogMessage is 32769 bytes, over the 32768 bytes limit"}},
+		{"field over the LA limit in ADX", containerLogV2ADXSchema, with("LogMessage", strings.Repeat("a", logAnalyticsMaxFieldBytes+1)), nil},
+		{"v2 record in the v1 schema", containerLogSchema, validV2, []string{"Id is empty", "LogEntrySource is empty", "LogEntryTimeStamp is empty", "TimeOfCommand is empty", "SourceSystem is empty"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if got := validateRecord(tt.schema, tt.record); !reflect.DeepEqual(got, tt.output) {
+				t.Errorf("validateRecord() = %v, want %v", got, tt.output)
+			}
+		})
+	}
+}
+
+func Test_validateContainerLogBatch(t *testing.T) {
+	batch := &containerLogBatch{dataItemsLAv1: []DataItemLAv1{
+		{ID: "0123abcd", LogEntry: "hello", LogEntrySource: "stdout", LogEntryTimeStamp: "2021-06-01T10:00:00Z", LogEntryTimeOfCommand: "2021-06-01T10:00:01Z", SourceSystem: "Containers", Computer: "node1"},
+		{ID: "0123abcd", LogEntry: "hello", LogEntrySource: "stdout", LogEntryTimeStamp: "2021-06-01T10:00:00Z", LogEntryTimeOfCommand: "2021-06-01T10:00:01Z", SourceSystem: "Containers"},
+	}}
+	invalidRecords, violations := validateContainerLogBatch(batch)
+	if invalidRecords != 1 || !reflect.DeepEqual(violations, []string{"Computer is empty"}) {
+		t.Errorf("validateContainerLogBatch() = %d, %v, want 1, [Computer is empty]", invalidRecords, violations)
+	}
+}
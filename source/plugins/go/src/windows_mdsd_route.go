@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// loopback address (host:port) of the fluent forward input of the local agent on windows, the v2 container log route
+// is enabled on windows when it is set
+const envWindowsMdsdFluentAddress = "AZMON_WINDOWS_MDSD_FLUENT_ADDRESS"
+
+// timeout of the connection to the fluent forward input when checking its availability
+const mdsdFluentAvailabilityTimeout = 2 * time.Second
+
+// WindowsMdsdFluentAddress the loopback address of the fluent forward input of the local agent on windows, empty when
+// the v2 route is not enabled on windows
+var WindowsMdsdFluentAddress string
+
+// initializeWindowsMdsdRoute reads the address of the local agent on windows, and returns whether the v2 route can
+// be used
+func initializeWindowsMdsdRoute() bool {
+	WindowsMdsdFluentAddress = ""
+	address := strings.TrimSpace(os.Getenv(envWindowsMdsdFluentAddress))
+	if address == "" {
+		Log("The %s route needs %s on windows, using the %s route", ContainerLogsV2Route, envWindowsMdsdFluentAddress, ContainerLogsV1Route)
+		return false
+	}
+	if err := validateLoopbackAddress(address); err != nil {
+		Log("Invalid value %s for %s, using the %s route: %s", address, envWindowsMdsdFluentAddress, ContainerLogsV1Route, err.Error())
+		return false
+	}
+	WindowsMdsdFluentAddress = address
+	Log("Sending the container logs to the local agent at %s", address)
+	return true
+}
+
+// validateLoopbackAddress checks the address is a host:port of the node, so the records never leave it
+func validateLoopbackAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if port == "" {
+		return fmt.Errorf("the address has no port")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%s is not a loopback host", host)
+	}
+	return nil
+}
+
+// getMdsdFluentNetwork returns the network and the address of the fluent forward input of the local agent, the tcp
+// loopback address on windows and the unix socket on linux
+func getMdsdFluentNetwork(containerType string) (string, string) {
+	if IsWindows == true && WindowsMdsdFluentAddress != "" {
+		return "tcp", WindowsMdsdFluentAddress
+	}
+	return "unix", getMdsdFluentSocketPath(containerType)
+}
+
+// checkMdsdFluentAvailable returns nil when the fluent forward input of the local agent is available, the socket
+// existing on linux and the address accepting connections on windows
+func checkMdsdFluentAvailable(containerType string) error {
+	network, address := getMdsdFluentNetwork(containerType)
+	if network == "unix" {
+		if _, err := os.Stat(address); err != nil {
+			return fmt.Errorf("mdsd socket %s is not available: %s", address, err.Error())
+		}
+		return nil
+	}
+	conn, err := net.DialTimeout(network, address, mdsdFluentAvailabilityTimeout)
+	if err != nil {
+		return fmt.Errorf("mdsd address %s is not available: %s", address, err.Error())
+	}
+	conn.Close()
+	return nil
+}
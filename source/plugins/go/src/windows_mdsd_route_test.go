@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func Test_validateLoopbackAddress(t *testing.T) {
+	type test_struct struct {
+		testname string
+		address  string
+		isError  bool
+	}
+
+	tests := []test_struct{
+		{"localhost", "localhost:28230", false},
+		{"ipv4 loopback", "127.0.0.1:28230", false},
+		{"ipv6 loopback", "[::1]:28230", false},
+		{"remote host", "10.0.0.4:28230", true},
+		{"host name", "mdsd.example.com:28230", true},
+		{"no port", "127.0.0.1", true},
+		{"empty port", "127.0.0.1:", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if err := validateLoopbackAddress(tt.address); (err != nil) != tt.isError {
+				t.Errorf("validateLoopbackAddress() error = %v, want error %v", err, tt.isError)
+			}
+		})
+	}
+}
+
+func Test_checkMdsdFluentAvailable(t *testing.T) {
+	type test_struct struct {
+		testname string
+		address  func() string
+		isError  bool
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	IsWindows = true
+	defer func() {
+		IsWindows = false
+		WindowsMdsdFluentAddress = ""
+	}()
+
+	tests := []test_struct{
+		{"listening", func() string { return listener.Addr().String() }, false},
+		{"not listening", func() string { return closed.Addr().String() }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			WindowsMdsdFluentAddress = tt.address()
+			if network, address := getMdsdFluentNetwork(""); network != "tcp" || address != WindowsMdsdFluentAddress {
+				t.Errorf("getMdsdFluentNetwork() = %s, %s, want tcp, %s", network, address, WindowsMdsdFluentAddress)
+			}
+			if err := checkMdsdFluentAvailable(""); (err != nil) != tt.isError {
+				t.Errorf("checkMdsdFluentAvailable() error = %v, want error %v", err, tt.isError)
+			}
+		})
+	}
+}
+
+func Test_initializeWindowsMdsdRoute(t *testing.T) {
+	type test_struct struct {
+		testname    string
+		address     string
+		enabled     bool
+		wantAddress string
+	}
+
+	tests := []test_struct{
+		{"not set", "", false, ""},
+		{"loopback address", " 127.0.0.1:28230 ", true, "127.0.0.1:28230"},
+		{"remote address", "10.0.0.4:28230", false, ""},
+	}
+
+	defer func() {
+		os.Unsetenv(envWindowsMdsdFluentAddress)
+		WindowsMdsdFluentAddress = ""
+	}()
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			os.Setenv(envWindowsMdsdFluentAddress, tt.address)
+			if got := initializeWindowsMdsdRoute(); got != tt.enabled {
+				t.Errorf("initializeWindowsMdsdRoute() = %v, want %v", got, tt.enabled)
+			}
+			if WindowsMdsdFluentAddress != tt.wantAddress {
+				t.Errorf("initializeWindowsMdsdRoute() address = %q, want %q", WindowsMdsdFluentAddress, tt.wantAddress)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// path of the routing table of the namespaces to other workspaces than the one of the agent, the workspace-routes
+// key of the container-azm-ms-agentconfig configmap by default
+const envWorkspaceRoutesPath = "AZMON_WORKSPACE_ROUTES_PATH"
+
+const defaultWorkspaceRoutesPath = "/etc/config/settings/workspace-routes"
+
+// workspaceRouteConfig a route as configured in the configmap. The client certificate and key of the workspace are
+// the agent certificate of the workspace by default on linux
+type workspaceRouteConfig struct {
+	Name         string   `json:"name"`
+	Namespaces   []string `json:"namespaces"`
+	WorkspaceID  string   `json:"workspaceId"`
+	Domain       string   `json:"domain"`
+	CertFilePath string   `json:"certFilePath"`
+	KeyFilePath  string   `json:"keyFilePath"`
+}
+
+// workspaceRoute posts the container logs of its namespaces to the ODS endpoint of its workspace
+type workspaceRoute struct {
+	name        string
+	namespaces  []string
+	workspaceID string
+	endpoint    string
+	certFile    string
+	keyFile     string
+	sink        Sink
+}
+
+// WorkspaceRoutesByNamespace the route of the namespaces whose container logs are posted to another workspace than
+// the one of the agent, empty when the routing is disabled
+var WorkspaceRoutesByNamespace map[string]*workspaceRoute
+
+// initializeWorkspaceRouting loads the routing table from the configmap and creates the clients of its workspaces,
+// after the route is set up. The namespaces of a route whose client can't be created stay in the workspace of the
+// agent
+func initializeWorkspaceRouting() {
+	WorkspaceRoutesByNamespace = nil
+	path := strings.TrimSpace(os.Getenv(envWorkspaceRoutesPath))
+	if path == "" {
+		path = defaultWorkspaceRoutesPath
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log("Error::workspaceroutes::Unable to read the workspace routes from %s: %s", path, err.Error())
+		}
+		return
+	}
+	if ContainerLogsRouteV2 == true || ContainerLogsRouteADX == true || ContainerLogsRouteDCR == true || ContainerLogsRouteKafka == true || ContainerLogsRouteEventHubs == true || ContainerLogsRouteOTLP == true {
+		Log("The workspace routes are only supported on the %s route, the container logs are sent to the workspace of the agent", ContainerLogsV1Route)
+		return
+	}
+	if IsAADMSIAuthMode || IsWorkloadIdentityAuthMode {
+		Log("Error::workspaceroutes::The workspace routes are only supported with the agent certificate auth, the container logs are sent to the workspace of the agent")
+		return
+	}
+	routes, err := parseWorkspaceRoutes(content, os.Getenv("DOMAIN"))
+	if err != nil {
+		Log("Error::workspaceroutes::Invalid workspace routes in %s, the container logs are sent to the workspace of the agent: %s", path, err.Error())
+		return
+	}
+	routesByNamespace := make(map[string]*workspaceRoute)
+	for _, route := range routes {
+		client, err := newWorkspaceClient(route.workspaceID, route.certFile, route.keyFile)
+		if err != nil {
+			Log("Error::workspaceroutes::Unable to create the client of the workspace %s of the route %s, its namespaces are sent to the workspace of the agent: %s", route.workspaceID, route.name, err.Error())
+			continue
+		}
+		route.sink = instrumentSink(sinkTypeODS, &odsSink{name: ContainerLogsV1Route + "/" + route.name, endpoint: route.endpoint, client: client})
+		for _, namespace := range route.namespaces {
+			routesByNamespace[namespace] = route
+		}
+		Log("Routing the container logs of the namespaces %s to the workspace %s", strings.Join(route.namespaces, ","), route.workspaceID)
+	}
+	if len(routesByNamespace) > 0 {
+		WorkspaceRoutesByNamespace = routesByNamespace
+	}
+}
+
+// parseWorkspaceRoutes parses the json array of the routes, a namespace being routed to one workspace at most
+func parseWorkspaceRoutes(content []byte, defaultDomain string) ([]*workspaceRoute, error) {
+	if strings.TrimSpace(string(content)) == "" {
+		return nil, nil
+	}
+	var configs []workspaceRouteConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, err
+	}
+	routes := make([]*workspaceRoute, 0, len(configs))
+	routedNamespaces := make(map[string]string)
+	for i, config := range configs {
+		workspaceID := strings.TrimSpace(config.WorkspaceID)
+		if workspaceID == "" {
+			return nil, fmt.Errorf("the route %d has no workspaceId", i)
+		}
+		if workspaceID == WorkspaceID {
+			return nil, fmt.Errorf("the route %d is to the workspace of the agent %s", i, workspaceID)
+		}
+		name := strings.TrimSpace(config.Name)
+		if name == "" {
+			name = workspaceID
+		}
+		if len(config.Namespaces) == 0 {
+			return nil, fmt.Errorf("the route %s has no namespaces", name)
+		}
+		domain := strings.TrimSpace(config.Domain)
+		if domain == "" {
+			domain = defaultDomain
+		}
+		route := &workspaceRoute{
+			name:        name,
+			workspaceID: workspaceID,
+			endpoint:    "https://" + workspaceID + ".ods." + domain + "/OperationalData.svc/PostJsonDataItems",
+			certFile:    strings.TrimSpace(config.CertFilePath),
+			keyFile:     strings.TrimSpace(config.KeyFilePath),
+		}
+		for _, namespace := range config.Namespaces {
+			namespace = strings.TrimSpace(namespace)
+			if namespace == "" {
+				continue
+			}
+			if other, ok := routedNamespaces[namespace]; ok {
+				return nil, fmt.Errorf("the namespace %s is in the routes %s and %s", namespace, other, name)
+			}
+			routedNamespaces[namespace] = name
+			route.namespaces = append(route.namespaces, namespace)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// workspaceRouteBatches the records of a flush routed to other workspaces than the one of the agent, by route
+type workspaceRouteBatches map[*workspaceRoute]*containerLogBatch
+
+// batchOf returns the batch of the route of the namespace, nil when its records are sent to the workspace of the
+// agent
+func (b workspaceRouteBatches) batchOf(namespace string, start time.Time) *containerLogBatch {
+	route, ok := WorkspaceRoutesByNamespace[namespace]
+	if !ok {
+		return nil
+	}
+	batch, ok := b[route]
+	if !ok {
+		batch = &containerLogBatch{start: start}
+		b[route] = batch
+	}
+	return batch
+}
+
+// logBytes returns the size of the log lines of the routed batches
+func (b workspaceRouteBatches) logBytes() int {
+	logBytes := 0
+	for _, batch := range b {
+		logBytes += batch.logBytes
+	}
+	return logBytes
+}
+
+// sendWorkspaceRouteBatches sends each routed batch to the sink of its workspace, and returns the number of records
+// sent. All the batches are sent even when one fails, the error of a failed batch being returned so the chunk is
+// retried
+func sendWorkspaceRouteBatches(ctx context.Context, batches workspaceRouteBatches) (int, error) {
+	sent := 0
+	var failed error
+	for route, batch := range batches {
+		_, batch.dataItemsLAv2, batch.dataItemsLAv1 = enforceLogAnalyticsLimits(nil, batch.dataItemsLAv2, batch.dataItemsLAv1)
+		sortContainerLogBatch(nil, nil, batch.dataItemsLAv2, batch.dataItemsLAv1)
+		if batch.len() == 0 {
+			continue
+		}
+		err := route.sink.Send(ctx, batch)
+		if err == errBatchDropped {
+			continue
+		}
+		if err != nil {
+			Log("Error::workspaceroutes::Failed to send %d records of the route %s to the workspace %s: %s", batch.len(), route.name, route.workspaceID, err.Error())
+			failed = err
+			continue
+		}
+		sent += batch.len()
+	}
+	return sent, failed
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// routeSink a sink of a workspace route recording the records it is sent
+type routeSink struct {
+	sinkHealth
+	err  error
+	sent []string
+}
+
+func (s *routeSink) Name() string { return "route" }
+
+func (s *routeSink) Send(ctx context.Context, batch *containerLogBatch) error {
+	if s.err != nil {
+		return s.setSendResult(s.err)
+	}
+	for _, item := range batch.dataItemsLAv1 {
+		s.sent = append(s.sent, item.LogEntry)
+	}
+	return s.setSendResult(nil)
+}
+
+func Test_parseWorkspaceRoutes(t *testing.T) {
+	type test_struct struct {
+		testName       string
+		content        string
+		wantEndpoints  []string
+		wantNamespaces [][]string
+		wantErr        bool
+	}
+
+	WorkspaceID = "agent-ws"
+	defer func() { WorkspaceID = "" }()
+
+	tests := []test_struct{
+		{"empty", "", nil, nil, false},
+		{"default domain", `[{"name":"a","namespaces":["ns1","ns2"],"workspaceId":"ws1"}]`, []string{"https://ws1.ods.opinsights.azure.com/OperationalData.svc/PostJsonDataItems"}, [][]string{{"ns1", "ns2"}}, false},
+		{"domain", `[{"namespaces":["ns1"],"workspaceId":"ws1","domain":"opinsights.azure.us"}]`, []string{"https://ws1.ods.opinsights.azure.us/OperationalData.svc/PostJsonDataItems"}, [][]string{{"ns1"}}, false},
+		{"two routes", `[{"namespaces":["ns1"],"workspaceId":"ws1"},{"namespaces":["ns2"],"workspaceId":"ws2"}]`, []string{"https://ws1.ods.opinsights.azure.com/OperationalData.svc/PostJsonDataItems", "https://ws2.ods.opinsights.azure.com/OperationalData.svc/PostJsonDataItems"}, [][]string{{"ns1"}, {"ns2"}}, false},
+		{"namespace in two routes", `[{"namespaces":["ns1"],"workspaceId":"ws1"},{"namespaces":["ns1"],"workspaceId":"ws2"}]`, nil, nil, true},
+		{"no workspace", `[{"namespaces":["ns1"]}]`, nil, nil, true},
+		{"no namespaces", `[{"workspaceId":"ws1"}]`, nil, nil, true},
+		{"agent workspace", `[{"namespaces":["ns1"],"workspaceId":"agent-ws"}]`, nil, nil, true},
+		{"invalid json", `{`, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			routes, err := parseWorkspaceRoutes([]byte(tt.content), "opinsights.azure.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWorkspaceRoutes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var endpoints []string
+			var namespaces [][]string
+			for _, route := range routes {
+				endpoints = append(endpoints, route.endpoint)
+				namespaces = append(namespaces, route.namespaces)
+			}
+			if !reflect.DeepEqual(endpoints, tt.wantEndpoints) || !reflect.DeepEqual(namespaces, tt.wantNamespaces) {
+				t.Errorf("parseWorkspaceRoutes() = %v %v, want %v %v", endpoints, namespaces, tt.wantEndpoints, tt.wantNamespaces)
+			}
+		})
+	}
+}
+
+func Test_sendWorkspaceRouteBatches(t *testing.T) {
+	type test_struct struct {
+		testName   string
+		errA       error
+		errB       error
+		wantSent   int
+		wantErr    bool
+		wantRouteB []string
+	}
+
+	tests := []test_struct{
+		{"all sent", nil, nil, 3, false, []string{"b1"}},
+		{"one route fails", errors.New("ingestion failed"), nil, 1, true, []string{"b1"}},
+		{"dropped batch", errBatchDropped, nil, 1, false, []string{"b1"}},
+	}
+
+	defer func() { WorkspaceRoutesByNamespace = nil }()
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			sinkA, sinkB := &routeSink{err: tt.errA}, &routeSink{err: tt.errB}
+			routeA := &workspaceRoute{name: "a", workspaceID: "ws1", sink: sinkA}
+			routeB := &workspaceRoute{name: "b", workspaceID: "ws2", sink: sinkB}
+			WorkspaceRoutesByNamespace = map[string]*workspaceRoute{"ns1": routeA, "ns2": routeA, "ns3": routeB}
+
+			batches := make(workspaceRouteBatches)
+			for _, record := range []struct{ namespace, log string }{{"ns1", "a1"}, {"default", "d1"}, {"ns3", "b1"}, {"ns2", "a2"}} {
+				if batch := batches.batchOf(record.namespace, time.Now()); batch != nil {
+					batch.dataItemsLAv1 = append(batch.dataItemsLAv1, DataItemLAv1{LogEntry: record.log})
+					batch.logBytes += len(record.log)
+				}
+			}
+			if len(batches) != 2 || batches.logBytes() != 6 {
+				t.Fatalf("batchOf() partitioned %d batches of %d bytes, want 2 batches of 6 bytes", len(batches), batches.logBytes())
+			}
+
+			sent, err := sendWorkspaceRouteBatches(context.Background(), batches)
+			if sent != tt.wantSent || (err != nil) != tt.wantErr {
+				t.Errorf("sendWorkspaceRouteBatches() = %d, %v, want %d, wantErr %v", sent, err, tt.wantSent, tt.wantErr)
+			}
+			if !reflect.DeepEqual(sinkB.sent, tt.wantRouteB) {
+				t.Errorf("sendWorkspaceRouteBatches() sent %v to the route b, want %v", sinkB.sent, tt.wantRouteB)
+			}
+		})
+	}
+}